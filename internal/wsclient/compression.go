@@ -0,0 +1,82 @@
+package wsclient
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/gorilla/websocket"
+)
+
+// The compressions of the binary messages of a feed, the message_compression
+// option
+const (
+	CompressionNone    = "none"
+	CompressionGzip    = "gzip"
+	CompressionDeflate = "deflate"
+)
+
+// ValidateCompression checks a message_compression option, empty meaning
+// none
+func ValidateCompression(compression string) error {
+	switch compression {
+	case "", CompressionNone, CompressionGzip, CompressionDeflate:
+		return nil
+	}
+	return fmt.Errorf("invalid message_compression %q, must be %q, %q or %q",
+		compression, CompressionNone, CompressionGzip, CompressionDeflate)
+}
+
+// Decompress returns the payload of a message of messageType compressed with
+// compression. Text messages, which can't carry compressed data, are
+// returned as they are. Deflate payloads are accepted both raw and with a
+// zlib header. A payload decompressing to more than limit bytes fails with
+// ErrMessageTooLarge, unless limit is 0.
+func Decompress(compression string, messageType int, message []byte, limit int64) ([]byte, error) {
+	if messageType != websocket.BinaryMessage {
+		return message, nil
+	}
+
+	var r io.ReadCloser
+	var err error
+	switch compression {
+	case CompressionGzip:
+		r, err = gzip.NewReader(bytes.NewReader(message))
+	case CompressionDeflate:
+		if isZlib(message) {
+			r, err = zlib.NewReader(bytes.NewReader(message))
+		} else {
+			r = flate.NewReader(bytes.NewReader(message))
+		}
+	default:
+		return message, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if limit <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	// read one byte past the limit to tell a payload of exactly limit bytes
+	// from a larger one
+	payload, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(payload)) > limit {
+		return nil, fmt.Errorf("%w of %d bytes once decompressed", ErrMessageTooLarge, limit)
+	}
+	return payload, nil
+}
+
+// isZlib reports whether data starts with a zlib header, a deflate
+// compression method and a header checksum divisible by 31
+func isZlib(data []byte) bool {
+	return len(data) >= 2 && data[0]&0x0f == 8 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0
+}
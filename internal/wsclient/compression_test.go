@@ -0,0 +1,90 @@
+package wsclient
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipped(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func deflated(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func zlibbed(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	_, err := w.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	const payload = `{"ch":"market.btcusdt.bbo"}`
+
+	tests := []struct {
+		name        string
+		compression string
+		messageType int
+		message     []byte
+	}{
+		{"gzip", CompressionGzip, websocket.BinaryMessage, gzipped(t, payload)},
+		{"raw deflate", CompressionDeflate, websocket.BinaryMessage, deflated(t, payload)},
+		{"zlib deflate", CompressionDeflate, websocket.BinaryMessage, zlibbed(t, payload)},
+		{"none", CompressionNone, websocket.BinaryMessage, []byte(payload)},
+		{"unset", "", websocket.BinaryMessage, []byte(payload)},
+		{"text message", CompressionGzip, websocket.TextMessage, []byte(payload)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := Decompress(tt.compression, tt.messageType, tt.message, 0)
+			require.NoError(t, err)
+			require.Equal(t, payload, string(message))
+		})
+	}
+}
+
+func TestDecompressCorruptMessage(t *testing.T) {
+	_, err := Decompress(CompressionGzip, websocket.BinaryMessage, []byte("not gzip"), 0)
+	require.Error(t, err)
+
+	_, err = Decompress(CompressionDeflate, websocket.BinaryMessage, []byte{0xff, 0xff, 0xff}, 0)
+	require.Error(t, err)
+}
+
+func TestDecompressLimit(t *testing.T) {
+	message, err := Decompress(CompressionGzip, websocket.BinaryMessage, gzipped(t, "1234"), 4)
+	require.NoError(t, err)
+	require.Equal(t, "1234", string(message))
+
+	_, err = Decompress(CompressionGzip, websocket.BinaryMessage, gzipped(t, "12345"), 4)
+	require.True(t, errors.Is(err, ErrMessageTooLarge))
+}
+
+func TestValidateCompression(t *testing.T) {
+	for _, compression := range []string{"", CompressionNone, CompressionGzip, CompressionDeflate} {
+		require.NoError(t, ValidateCompression(compression))
+	}
+	require.Error(t, ValidateCompression("zstd"))
+}
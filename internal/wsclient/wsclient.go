@@ -0,0 +1,590 @@
+// Package wsclient is the websocket connection layer of the inputs reading
+// market data and other feeds over a websocket: it dials the server, replays
+// the subscription on every connection, keeps the connection alive, and
+// reconnects with an exponential backoff when it drops.
+package wsclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/selfstat"
+)
+
+const (
+	DefaultPingInterval      = 10 * time.Second
+	DefaultPongWait          = 20 * time.Second
+	DefaultReconnectInterval = time.Second
+	DefaultMaxBackoff        = time.Minute
+
+	// HandshakeTimeout bounds the websocket handshake, like the default
+	// dialer
+	HandshakeTimeout = 45 * time.Second
+
+	// CloseTimeout is how long Stop waits for the server to answer the close
+	// frame
+	CloseTimeout = time.Second
+
+	// close codes from the IANA websocket close code registry
+	closeUnauthorized = 3000
+	closeForbidden    = 3003
+)
+
+// ErrHandshakeRejected is returned when the server refuses the websocket
+// handshake with a client error, which reconnecting won't fix
+var ErrHandshakeRejected = errors.New("handshake rejected")
+
+// ErrRateLimited is returned when the server refuses the websocket handshake
+// with 429 Too Many Requests, which reconnecting later may fix
+var ErrRateLimited = errors.New("rate limited")
+
+// ErrMessageTooLarge is returned for a message exceeding MaxMessageSize, as
+// received or once decompressed
+var ErrMessageTooLarge = errors.New("message exceeds max_message_size")
+
+// ErrNotConnected is returned when writing while no connection is up
+var ErrNotConnected = errors.New("not connected")
+
+// Handler is implemented by the plugins reading a feed through a Client
+type Handler interface {
+	// Subscribe is called on every new connection, initially and after
+	// every reconnect, to send the messages subscribing to the feed. It may
+	// write to conn directly, as the keepalive of conn is only started once
+	// it returned.
+	Subscribe(conn *websocket.Conn) error
+
+	// Handle is called by the read loop with every message received
+	Handle(message []byte)
+}
+
+// Endpoint is the server a Client dials, as resolved before every connection
+type Endpoint struct {
+	URL string
+
+	// the keepalive expected by the server, overriding the PingInterval and
+	// PongWait of the Config when positive
+	PingInterval time.Duration
+	PongWait     time.Duration
+}
+
+// Config is the connection of a Client
+type Config struct {
+	// URL is dialed, unless Resolve is set, and names the server in errors
+	// either way
+	URL    string
+	Header http.Header
+
+	// Resolve, when set, returns the endpoint to dial before every
+	// connection, for servers handing out a token and the address to
+	// connect to over a REST api. An error wrapping ErrHandshakeRejected
+	// isn't retried.
+	Resolve func(ctx context.Context) (Endpoint, error)
+
+	// Dialer dials URL, defaults to a dialer of the proxy of the
+	// environment
+	Dialer *websocket.Dialer
+
+	// a PingInterval of 0 disables the keepalive
+	PingInterval time.Duration
+	PongWait     time.Duration
+
+	// PingMessage, when set, is sent as a text message every PingInterval
+	// instead of a ping frame, for servers expecting pings in their own
+	// protocol, e.g. {"op": "ping"}. Any message received then counts as
+	// the pong.
+	PingMessage []byte
+
+	// MessageCompression of the binary messages, decompressed before being
+	// handled, see Decompress
+	MessageCompression string
+
+	// MaxMessageSize bounds the size of the messages received, a larger
+	// message breaking the connection. 0 is unbounded.
+	MaxMessageSize int64
+
+	ReconnectInterval time.Duration
+	MaxBackoff        time.Duration
+
+	// MaxReconnectAttempts of 0 reconnects forever
+	MaxReconnectAttempts int
+
+	// Disconnected, when set, is called by the read loop with the error
+	// breaking the connection, before reconnecting
+	Disconnected func(err error)
+
+	// RetryDelay, when set, returns the delay before a reconnect attempt
+	// after failures consecutive failed attempts, instead of the Backoff of
+	// ReconnectInterval, e.g. to back off longer when rate limited. It is
+	// called before every attempt, so it may also prepare the attempt, e.g.
+	// move on to the next endpoint returned by Resolve.
+	RetryDelay func(cause error, failures int) time.Duration
+
+	// GaveUp, when set, is called when reconnecting was given up on, after
+	// the error was reported
+	GaveUp func(cause error, failures int)
+
+	// the measurement of the internal statistics, e.g. the name of the
+	// plugin, and their tags
+	StatsName string
+	StatsTags map[string]string
+
+	Log telegraf.Logger
+}
+
+// Validate checks the keepalive, reconnect, compression and size options
+func (cfg *Config) Validate() error {
+	if err := ValidateCompression(cfg.MessageCompression); err != nil {
+		return err
+	}
+	if cfg.PingInterval > 0 && cfg.PongWait <= cfg.PingInterval {
+		return fmt.Errorf("pong_wait %s must be longer than ping_interval %s", cfg.PongWait, cfg.PingInterval)
+	}
+	if cfg.MaxReconnectAttempts < 0 {
+		return fmt.Errorf("invalid max_reconnect_attempts %d, must not be negative", cfg.MaxReconnectAttempts)
+	}
+	if cfg.MaxMessageSize < 0 {
+		return fmt.Errorf("invalid max_message_size %d, must not be negative", cfg.MaxMessageSize)
+	}
+	return nil
+}
+
+// Client maintains a websocket connection to a server, handing every
+// message received to its Handler
+type Client struct {
+	Config
+
+	handler Handler
+	acc     telegraf.Accumulator
+
+	// cancelled by Stop, ending the read loop, keepalive and reconnects
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	conn  *websocket.Conn
+	mutex sync.Mutex
+	wg    sync.WaitGroup
+
+	// serializes the messages written by the handler and the ping messages
+	writeMutex sync.Mutex
+
+	// the keepalive of the current connection, only accessed by connect
+	// and the read loop
+	pingInterval time.Duration
+	pongWait     time.Duration
+
+	MessagesReceived selfstat.Stat
+	BytesReceived    selfstat.Stat
+	Reconnects       selfstat.Stat
+	OversizeMessages selfstat.Stat
+}
+
+// New returns a client of cfg handing messages to handler, registering its
+// internal statistics
+func New(cfg Config, handler Handler) *Client {
+	if cfg.Dialer == nil {
+		cfg.Dialer = &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: HandshakeTimeout,
+		}
+	}
+
+	return &Client{
+		Config:           cfg,
+		handler:          handler,
+		MessagesReceived: selfstat.Register(cfg.StatsName, "messages_received", cfg.StatsTags),
+		BytesReceived:    selfstat.Register(cfg.StatsName, "bytes_received", cfg.StatsTags),
+		Reconnects:       selfstat.Register(cfg.StatsName, "reconnects", cfg.StatsTags),
+		OversizeMessages: selfstat.Register(cfg.StatsName, "oversize_messages", cfg.StatsTags),
+	}
+}
+
+// Start connects to the server and starts the read loop, which reports
+// giving up on reconnecting as an error of acc. Fails when the first
+// connection can't be established.
+func (c *Client) Start(acc telegraf.Accumulator) error {
+	c.acc = acc
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	if err := c.connect(); err != nil {
+		c.cancel()
+		return err
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.read()
+	}()
+
+	return nil
+}
+
+// Stop closes the connection, waiting up to CloseTimeout for the server to
+// answer the close frame, and waits for the read loop to end. Returns the
+// error sending the close frame or closing the connection, nil when already
+// stopped.
+func (c *Client) Stop() error {
+	if c.cancel == nil {
+		// never started
+		return nil
+	}
+
+	// cancelled before closing the connection so that the read loop doesn't
+	// reconnect, which also aborts a reconnect in progress
+	c.cancel()
+
+	var err error
+	if conn := c.Conn(); conn != nil {
+		deadline := time.Now().Add(CloseTimeout)
+		err = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), deadline)
+		if err == websocket.ErrCloseSent {
+			// the close handshake was already started
+			err = nil
+		}
+		if err != nil {
+			// the server won't answer, unblock the read loop right away
+			conn.Close()
+		} else {
+			_ = conn.SetReadDeadline(deadline)
+		}
+	}
+
+	c.wg.Wait()
+
+	// released, so that stopping again is a no-op
+	c.mutex.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.mutex.Unlock()
+	if conn != nil {
+		if closeErr := conn.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Conn returns the current connection
+func (c *Client) Conn() *websocket.Conn {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.conn
+}
+
+// connect dials the server, subscribes and starts the keepalive of the new
+// connection
+func (c *Client) connect() error {
+	endpoint := Endpoint{URL: c.URL}
+	if c.Resolve != nil {
+		var err error
+		if endpoint, err = c.Resolve(c.ctx); err != nil {
+			return fmt.Errorf("resolve %s: %w", c.URL, err)
+		}
+	}
+	c.pingInterval, c.pongWait = c.PingInterval, c.PongWait
+	if endpoint.PingInterval > 0 {
+		c.pingInterval, c.pongWait = endpoint.PingInterval, endpoint.PongWait
+	}
+
+	conn, resp, err := c.Dialer.DialContext(c.ctx, endpoint.URL, c.Header)
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", c.URL, HandshakeError(err, resp))
+	}
+	conn.SetReadLimit(c.MaxMessageSize)
+	c.mutex.Lock()
+	c.conn = conn
+	c.mutex.Unlock()
+
+	if err := c.handler.Subscribe(conn); err != nil {
+		conn.Close()
+		return fmt.Errorf("subscribe: %w", err)
+	}
+
+	if c.PingMessage != nil {
+		c.pingMessages(conn)
+	} else {
+		Keepalive(c.ctx, &c.wg, conn, c.pingInterval, c.pongWait)
+	}
+	return nil
+}
+
+// WriteMessage sends a text message on the current connection, e.g. to
+// resubscribe from Handle
+func (c *Client) WriteMessage(msg []byte) error {
+	conn := c.Conn()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	return c.write(conn, msg)
+}
+
+func (c *Client) write(conn *websocket.Conn, msg []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+// pingMessages sends PingMessage every ping interval and fails reads on conn
+// when no message was received for the pong wait
+func (c *Client) pingMessages(conn *websocket.Conn) {
+	if c.pingInterval <= 0 {
+		return
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(c.pongWait))
+	ctx, pingInterval := c.ctx, c.pingInterval
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := c.write(conn, c.PingMessage); err != nil {
+					// the connection is closed, the read loop reconnects
+					return
+				}
+			}
+		}
+	}()
+}
+
+func (c *Client) read() {
+	for {
+		conn := c.Conn()
+		message, err := c.ReadMessage(conn)
+		if err != nil {
+			if c.ctx.Err() != nil {
+				// the connection was closed by Stop
+				return
+			}
+
+			c.Log.Warnf("Read error, reconnecting: %s", err)
+			conn.Close()
+			if c.Disconnected != nil {
+				c.Disconnected(err)
+			}
+			if !c.reconnect(err) {
+				return
+			}
+			continue
+		}
+
+		if c.ctx.Err() == nil {
+			ExtendDeadline(conn, c.pingInterval, c.pongWait)
+		}
+		c.handler.Handle(message)
+	}
+}
+
+// ReadMessage reads the next message of conn, counting it in the statistics
+// and decompressing it with MessageCompression. Messages failing to
+// decompress are reported and skipped, as are those exceeding MaxMessageSize
+// once decompressed. Used by the read loop, and by a Subscribe awaiting the
+// answer to its subscription.
+func (c *Client) ReadMessage(conn *websocket.Conn) ([]byte, error) {
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				c.OversizeMessages.Incr(1)
+				c.acc.AddError(fmt.Errorf("%s: %w of %d bytes", c.URL, ErrMessageTooLarge, c.MaxMessageSize))
+			}
+			return nil, err
+		}
+
+		c.MessagesReceived.Incr(1)
+		c.BytesReceived.Incr(int64(len(message)))
+
+		message, err = Decompress(c.MessageCompression, messageType, message, c.MaxMessageSize)
+		if err != nil {
+			if errors.Is(err, ErrMessageTooLarge) {
+				c.OversizeMessages.Incr(1)
+			}
+			c.acc.AddError(fmt.Errorf("unable to decompress incoming msg: %s", err))
+			continue
+		}
+		return message, nil
+	}
+}
+
+// reconnect re-dials the server after cause broke the connection, backing
+// off between attempts. It gives up on a permanent failure or once
+// MaxReconnectAttempts consecutive attempts have failed. Returns whether the
+// connection was re-established.
+func (c *Client) reconnect(cause error) bool {
+	failures := 0
+
+	for {
+		if IsPermanent(cause) || (c.MaxReconnectAttempts > 0 && failures >= c.MaxReconnectAttempts) {
+			c.acc.AddError(fmt.Errorf("giving up on %s after %d reconnect attempts: %s", c.URL, failures, cause))
+			if c.GaveUp != nil {
+				c.GaveUp(cause, failures)
+			}
+			return false
+		}
+
+		delay := Backoff(c.ReconnectInterval, c.MaxBackoff, failures)
+		if c.RetryDelay != nil {
+			delay = c.RetryDelay(cause, failures)
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+
+		failures++
+		cause = c.connect()
+		if cause == nil {
+			c.Reconnects.Incr(1)
+			return true
+		}
+
+		c.Log.Warnf("Reconnect attempt %d failed: %s", failures, cause)
+	}
+}
+
+// HandshakeError wraps the error of a handshake the server answered with 429
+// Too Many Requests in ErrRateLimited, and with any other client error in
+// ErrHandshakeRejected
+func HandshakeError(err error, resp *http.Response) error {
+	if resp == nil || resp.StatusCode < 400 || resp.StatusCode >= 500 {
+		return err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return fmt.Errorf("%w: %s", ErrRateLimited, resp.Status)
+	}
+	return fmt.Errorf("%w: %s", ErrHandshakeRejected, resp.Status)
+}
+
+// IsPermanent reports whether err can not be fixed by reconnecting, such as
+// the server rejecting the handshake or closing the connection over our
+// credentials or a policy violation. Rate limiting is never permanent.
+func IsPermanent(err error) bool {
+	if IsRateLimited(err) {
+		return false
+	}
+
+	if errors.Is(err, ErrHandshakeRejected) {
+		return true
+	}
+
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		switch closeErr.Code {
+		case websocket.ClosePolicyViolation, closeUnauthorized, closeForbidden:
+			return true
+		}
+	}
+	return false
+}
+
+// IsRateLimited reports whether err was caused by the server rate limiting
+// the client, by the handshake or the close frame
+func IsRateLimited(err error) bool {
+	if errors.Is(err, ErrRateLimited) {
+		return true
+	}
+
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		return closeErr.Code == websocket.CloseTryAgainLater || IsRateLimitText(closeErr.Text)
+	}
+	return false
+}
+
+// IsRateLimitText reports whether an error message or close reason from the
+// server complains about too many requests or subscriptions
+func IsRateLimitText(text string) bool {
+	text = strings.ToLower(text)
+	for _, s := range []string{"rate limit", "too many", "slow down"} {
+		if strings.Contains(text, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns the delay before a reconnect attempt after failures
+// consecutive failed attempts, doubling interval on every failure up to
+// max, if positive
+func Backoff(interval, max time.Duration, failures int) time.Duration {
+	delay := interval
+	for i := 0; i < failures; i++ {
+		if max > 0 && delay >= max {
+			break
+		}
+		delay *= 2
+	}
+
+	if max > 0 && delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// Keepalive pings the server every pingInterval and fails reads on conn
+// when neither a pong nor a message was received for pongWait, so that a
+// half-open connection is detected and re-established instead of going
+// quiet. The pinging goroutine is tracked by wg and ends with ctx or the
+// connection. A pingInterval of 0 disables it.
+func Keepalive(ctx context.Context, wg *sync.WaitGroup, conn *websocket.Conn, pingInterval, pongWait time.Duration) {
+	if pingInterval <= 0 {
+		return
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		if ctx.Err() != nil {
+			// keep the deadline of the close handshake
+			return nil
+		}
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				deadline := time.Now().Add(pingInterval)
+				if err := conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+					// the connection is closed, its read loop reconnects
+					return
+				}
+			}
+		}
+	}()
+}
+
+// ExtendDeadline pushes back the read deadline of conn after a message was
+// received, as any message proves the connection alive
+func ExtendDeadline(conn *websocket.Conn, pingInterval, pongWait time.Duration) {
+	if pingInterval <= 0 {
+		return
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+}
@@ -0,0 +1,374 @@
+package wsclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+var upgrader = websocket.Upgrader{}
+
+// testHandler subscribes with a single message and collects the messages
+// received
+type testHandler struct {
+	mutex    sync.Mutex
+	messages []string
+	received chan struct{}
+}
+
+func (h *testHandler) Subscribe(conn *websocket.Conn) error {
+	return conn.WriteMessage(websocket.TextMessage, []byte("subscribe"))
+}
+
+func (h *testHandler) Handle(message []byte) {
+	h.mutex.Lock()
+	h.messages = append(h.messages, string(message))
+	h.mutex.Unlock()
+	h.received <- struct{}{}
+}
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*Client, *testHandler) {
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	h := &testHandler{received: make(chan struct{}, 10)}
+	c := New(Config{
+		URL:               "ws" + strings.TrimPrefix(ts.URL, "http"),
+		PingInterval:      DefaultPingInterval,
+		PongWait:          DefaultPongWait,
+		ReconnectInterval: 10 * time.Millisecond,
+		StatsName:         "wsclient_test",
+		Log:               testutil.Logger{},
+	}, h)
+	return c, h
+}
+
+func TestClientReplaysSubscriptionOnReconnect(t *testing.T) {
+	c, h := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// answer the subscription, then drop the connection
+		if _, msg, err := conn.ReadMessage(); err == nil {
+			_ = conn.WriteMessage(websocket.TextMessage, append([]byte("ack "), msg...))
+		}
+	})
+
+	messages, reconnects := c.MessagesReceived.Get(), c.Reconnects.Get()
+	require.NoError(t, c.Start(&testutil.Accumulator{}))
+	for i := 0; i < 3; i++ {
+		<-h.received
+	}
+	c.Stop()
+
+	require.Equal(t, []string{"ack subscribe", "ack subscribe", "ack subscribe"}, h.messages[:3])
+	require.GreaterOrEqual(t, c.MessagesReceived.Get()-messages, int64(3))
+	require.GreaterOrEqual(t, c.Reconnects.Get()-reconnects, int64(2))
+}
+
+func TestClientGivesUpOnRejectedHandshake(t *testing.T) {
+	var attempts int32
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) > 1 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if conn, err := upgrader.Upgrade(w, r, nil); err == nil {
+			conn.Close()
+		}
+	})
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, c.Start(acc))
+	acc.WaitError(1)
+	c.Stop()
+
+	require.Contains(t, acc.FirstError().Error(), "handshake rejected: 403 Forbidden")
+	require.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+}
+
+func TestClientGivesUpAfterMaxReconnectAttempts(t *testing.T) {
+	var attempts int32
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) > 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if conn, err := upgrader.Upgrade(w, r, nil); err == nil {
+			conn.Close()
+		}
+	})
+	c.MaxReconnectAttempts = 2
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, c.Start(acc))
+	acc.WaitError(1)
+	c.Stop()
+
+	require.Contains(t, acc.FirstError().Error(), "after 2 reconnect attempts")
+	require.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+}
+
+func TestBackoff(t *testing.T) {
+	require.Equal(t, time.Second, Backoff(time.Second, time.Minute, 0))
+	require.Equal(t, 4*time.Second, Backoff(time.Second, time.Minute, 2))
+	require.Equal(t, time.Minute, Backoff(time.Second, time.Minute, 10))
+	require.Equal(t, 1024*time.Second, Backoff(time.Second, 0, 10))
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Config{PingInterval: 10 * time.Second, PongWait: 5 * time.Second}
+	require.Error(t, cfg.Validate())
+
+	cfg.PingInterval = 0
+	require.NoError(t, cfg.Validate())
+
+	cfg.MaxReconnectAttempts = -1
+	require.Error(t, cfg.Validate())
+
+	cfg.MaxReconnectAttempts = 0
+	cfg.MessageCompression = "brotli"
+	require.Error(t, cfg.Validate())
+
+	cfg.MessageCompression = CompressionGzip
+	cfg.MaxMessageSize = -1
+	require.Error(t, cfg.Validate())
+}
+
+func TestClientSendsPingMessages(t *testing.T) {
+	pings := make(chan string, 10)
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			select {
+			case pings <- string(msg):
+			default:
+			}
+		}
+	})
+	c.PingInterval = 10 * time.Millisecond
+	c.PongWait = time.Second
+	c.PingMessage = []byte(`{"op": "ping"}`)
+
+	require.NoError(t, c.Start(&testutil.Accumulator{}))
+	require.Equal(t, "subscribe", <-pings)
+	require.Equal(t, `{"op": "ping"}`, <-pings)
+	require.Equal(t, `{"op": "ping"}`, <-pings)
+	require.NoError(t, c.WriteMessage([]byte("resubscribe")))
+	c.Stop()
+}
+
+func TestClientResolvesEndpointOnEveryConnection(t *testing.T) {
+	c, h := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// echo the token, then drop the connection after the first ping
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(r.URL.Query().Get("token")))
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil || string(msg) == "ping" {
+				return
+			}
+		}
+	})
+	var resolved int32
+	url := c.URL
+	c.Resolve = func(ctx context.Context) (Endpoint, error) {
+		n := atomic.AddInt32(&resolved, 1)
+		return Endpoint{
+			URL:          fmt.Sprintf("%s?token=%d", url, n),
+			PingInterval: 10 * time.Millisecond,
+			PongWait:     time.Second,
+		}, nil
+	}
+	c.PingMessage = []byte("ping")
+
+	require.NoError(t, c.Start(&testutil.Accumulator{}))
+	<-h.received
+	<-h.received
+	c.Stop()
+
+	require.Equal(t, []string{"1", "2"}, h.messages[:2])
+}
+
+func TestClientGivesUpOnRejectedResolve(t *testing.T) {
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {})
+	c.Resolve = func(ctx context.Context) (Endpoint, error) {
+		return Endpoint{}, fmt.Errorf("%w: 401 Unauthorized", ErrHandshakeRejected)
+	}
+
+	err := c.Start(&testutil.Accumulator{})
+	require.Error(t, err)
+	require.True(t, errors.Is(err, ErrHandshakeRejected))
+}
+
+func TestClientDecompressesBinaryMessages(t *testing.T) {
+	c, h := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_ = conn.WriteMessage(websocket.BinaryMessage, gzipped(t, "compressed"))
+		_ = conn.WriteMessage(websocket.BinaryMessage, []byte("corrupt"))
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("plain"))
+		_, _, _ = conn.ReadMessage()
+	})
+	c.MessageCompression = CompressionGzip
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, c.Start(acc))
+	<-h.received
+	<-h.received
+	c.Stop()
+
+	require.Equal(t, []string{"compressed", "plain"}, h.messages)
+	require.Len(t, acc.Errors, 1)
+	require.Contains(t, acc.Errors[0].Error(), "unable to decompress incoming msg")
+}
+
+func TestClientMaxMessageSize(t *testing.T) {
+	c, h := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _, _ = conn.ReadMessage()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("small"))
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 64)))
+		_, _, _ = conn.ReadMessage()
+	})
+	c.MaxMessageSize = 32
+
+	oversize := c.OversizeMessages.Get()
+	acc := &testutil.Accumulator{}
+	require.NoError(t, c.Start(acc))
+	<-h.received
+	<-h.received
+	c.Stop()
+
+	// the oversize message broke the connection, the client reconnected
+	require.Equal(t, []string{"small", "small"}, h.messages[:2])
+	require.GreaterOrEqual(t, c.OversizeMessages.Get()-oversize, int64(1))
+	require.True(t, errors.Is(acc.FirstError(), ErrMessageTooLarge))
+}
+
+func TestClientHooks(t *testing.T) {
+	var attempts int32
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) > 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if conn, err := upgrader.Upgrade(w, r, nil); err == nil {
+			conn.Close()
+		}
+	})
+	c.MaxReconnectAttempts = 2
+
+	var disconnects int32
+	var delays []int
+	gaveUp := make(chan int, 1)
+	c.Disconnected = func(err error) { atomic.AddInt32(&disconnects, 1) }
+	c.RetryDelay = func(cause error, failures int) time.Duration {
+		delays = append(delays, failures)
+		return time.Millisecond
+	}
+	c.GaveUp = func(cause error, failures int) { gaveUp <- failures }
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, c.Start(acc))
+	require.Equal(t, 2, <-gaveUp)
+	c.Stop()
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&disconnects))
+	require.Equal(t, []int{0, 1}, delays)
+}
+
+func TestClientStopTwice(t *testing.T) {
+	c, h := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, msg, err := conn.ReadMessage(); err != nil {
+				return
+			} else if string(msg) == "subscribe" {
+				_ = conn.WriteMessage(websocket.TextMessage, msg)
+			}
+		}
+	})
+
+	// stopping a client never started is a no-op as well
+	require.NoError(t, c.Stop())
+
+	require.NoError(t, c.Start(&testutil.Accumulator{}))
+	<-h.received
+	require.NoError(t, c.Stop())
+	require.Nil(t, c.Conn())
+	require.NoError(t, c.Stop())
+}
+
+func TestHandshakeError(t *testing.T) {
+	err := HandshakeError(websocket.ErrBadHandshake, &http.Response{StatusCode: http.StatusTooManyRequests, Status: "429 Too Many Requests"})
+	require.True(t, errors.Is(err, ErrRateLimited))
+	require.False(t, IsPermanent(err))
+
+	err = HandshakeError(websocket.ErrBadHandshake, &http.Response{StatusCode: http.StatusUnauthorized, Status: "401 Unauthorized"})
+	require.True(t, errors.Is(err, ErrHandshakeRejected))
+	require.True(t, IsPermanent(err))
+
+	err = HandshakeError(websocket.ErrBadHandshake, &http.Response{StatusCode: http.StatusBadGateway, Status: "502 Bad Gateway"})
+	require.Equal(t, websocket.ErrBadHandshake, err)
+	require.False(t, IsPermanent(err))
+}
+
+func TestIsPermanent(t *testing.T) {
+	require.True(t, IsPermanent(&websocket.CloseError{Code: websocket.ClosePolicyViolation}))
+	require.True(t, IsPermanent(&websocket.CloseError{Code: closeUnauthorized}))
+	require.False(t, IsPermanent(&websocket.CloseError{Code: websocket.CloseAbnormalClosure}))
+	require.False(t, IsPermanent(io.ErrUnexpectedEOF))
+
+	rateLimitPolicy := &websocket.CloseError{Code: websocket.ClosePolicyViolation, Text: "Rate limit exceeded"}
+	require.False(t, IsPermanent(rateLimitPolicy))
+}
+
+func TestIsRateLimited(t *testing.T) {
+	require.True(t, IsRateLimited(&websocket.CloseError{Code: websocket.CloseTryAgainLater}))
+	require.True(t, IsRateLimited(&websocket.CloseError{Code: websocket.ClosePolicyViolation, Text: "Rate limit exceeded"}))
+	require.False(t, IsRateLimited(&websocket.CloseError{Code: websocket.CloseAbnormalClosure}))
+	require.False(t, IsRateLimited(io.ErrUnexpectedEOF))
+}
@@ -6,5 +6,10 @@ import (
 	_ "github.com/influxdata/telegraf/plugins/aggregators/histogram"
 	_ "github.com/influxdata/telegraf/plugins/aggregators/merge"
 	_ "github.com/influxdata/telegraf/plugins/aggregators/minmax"
+	_ "github.com/influxdata/telegraf/plugins/aggregators/ohlcv"
+	_ "github.com/influxdata/telegraf/plugins/aggregators/trade_flow"
 	_ "github.com/influxdata/telegraf/plugins/aggregators/valuecounter"
+	_ "github.com/influxdata/telegraf/plugins/aggregators/volatility"
+	_ "github.com/influxdata/telegraf/plugins/aggregators/volume_profile"
+	_ "github.com/influxdata/telegraf/plugins/aggregators/vwap"
 )
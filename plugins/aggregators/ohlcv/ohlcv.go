@@ -0,0 +1,222 @@
+package ohlcv
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/aggregators"
+)
+
+var sampleConfig = `
+  ## The period on which to flush & clear the aggregator.
+  period = "30s"
+  ## If true, the original metric will be dropped by the
+  ## aggregator and will not get sent to the output plugins.
+  drop_original = false
+
+  ## The lengths of the candles, e.g. ["1m", "5m", "1h"], aligned on multiples
+  ## of the length since the epoch. A candle is emitted once, at the first
+  ## flush after it closed.
+  # intervals = ["1m"]
+
+  ## The field of the price, of the ticker and match metrics, and of the size
+  ## of the trades, summed into the volume
+  # price_field = "price"
+  # size_field = "size"
+
+  ## The tags of every series of candles, e.g. the product and the exchange
+  # group_by = ["exchange", "base", "quote"]
+
+  ## The measurement of the candles
+  # measurement = "candle"
+`
+
+type OHLCV struct {
+	Intervals   []string `toml:"intervals"`
+	PriceField  string   `toml:"price_field"`
+	SizeField   string   `toml:"size_field"`
+	GroupBy     []string `toml:"group_by"`
+	Measurement string   `toml:"measurement"`
+
+	intervals []time.Duration
+	// the candles not emitted yet by series and start
+	candles map[candleKey]*candle
+	// the time of the last flush, before which the closed candles were
+	// emitted
+	flushed time.Time
+	now     func() time.Time
+}
+
+type candleKey struct {
+	series   string
+	interval int
+	start    time.Time
+}
+
+type candle struct {
+	tags      map[string]string
+	open      float64
+	openTime  time.Time
+	high      float64
+	low       float64
+	close     float64
+	closeTime time.Time
+	volume    float64
+	trades    int64
+}
+
+func NewOHLCV() *OHLCV {
+	return &OHLCV{
+		Intervals:   []string{"1m"},
+		PriceField:  "price",
+		SizeField:   "size",
+		GroupBy:     []string{"exchange", "base", "quote"},
+		Measurement: "candle",
+		candles:     make(map[candleKey]*candle),
+		now:         time.Now,
+	}
+}
+
+func (o *OHLCV) SampleConfig() string {
+	return sampleConfig
+}
+
+func (o *OHLCV) Description() string {
+	return "Aggregate the prices and sizes of tickers and trades into OHLCV candles"
+}
+
+func (o *OHLCV) Init() error {
+	if len(o.Intervals) == 0 {
+		return fmt.Errorf("intervals must be set")
+	}
+	o.intervals = make([]time.Duration, 0, len(o.Intervals))
+	for _, s := range o.Intervals {
+		interval, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid interval %q: %v", s, err)
+		}
+		if interval <= 0 {
+			return fmt.Errorf("invalid interval %q, must be positive", s)
+		}
+		o.intervals = append(o.intervals, interval)
+	}
+	if o.PriceField == "" {
+		return fmt.Errorf("price_field must be set")
+	}
+	return nil
+}
+
+func (o *OHLCV) Add(in telegraf.Metric) {
+	price, ok := convert(in.Fields()[o.PriceField])
+	if !ok {
+		return
+	}
+	size, hasSize := convert(in.Fields()[o.SizeField])
+
+	tags := make(map[string]string, len(o.GroupBy)+1)
+	values := make([]string, 0, len(o.GroupBy))
+	for _, key := range o.GroupBy {
+		if value, ok := in.GetTag(key); ok {
+			tags[key] = value
+			values = append(values, key+"="+value)
+		}
+	}
+	series := strings.Join(values, ",")
+
+	// candles which closed before the last flush were emitted already, the
+	// prices arriving late for them are dropped
+	for i, interval := range o.intervals {
+		start := in.Time().Truncate(interval)
+		if !start.Add(interval).After(o.flushed) {
+			continue
+		}
+
+		key := candleKey{series: series, interval: i, start: start}
+		c, ok := o.candles[key]
+		if !ok {
+			candleTags := make(map[string]string, len(tags)+1)
+			for k, v := range tags {
+				candleTags[k] = v
+			}
+			candleTags["interval"] = o.Intervals[i]
+			c = &candle{
+				tags:      candleTags,
+				open:      price,
+				openTime:  in.Time(),
+				high:      price,
+				low:       price,
+				close:     price,
+				closeTime: in.Time(),
+			}
+			o.candles[key] = c
+		}
+		c.update(price, in.Time())
+		if hasSize {
+			c.volume += size
+			c.trades++
+		}
+	}
+}
+
+// update applies a price received at t, which may be out of order
+func (c *candle) update(price float64, t time.Time) {
+	if t.Before(c.openTime) {
+		c.open, c.openTime = price, t
+	}
+	if !t.Before(c.closeTime) {
+		c.close, c.closeTime = price, t
+	}
+	if price > c.high {
+		c.high = price
+	}
+	if price < c.low {
+		c.low = price
+	}
+}
+
+func (o *OHLCV) Push(acc telegraf.Accumulator) {
+	// Preserve the start of the candles
+	acc.SetPrecision(time.Nanosecond)
+
+	now := o.now()
+	o.flushed = now
+	for key, c := range o.candles {
+		if key.start.Add(o.intervals[key.interval]).After(now) {
+			continue
+		}
+		acc.AddFields(o.Measurement, map[string]interface{}{
+			"open":   c.open,
+			"high":   c.high,
+			"low":    c.low,
+			"close":  c.close,
+			"volume": c.volume,
+			"trades": c.trades,
+		}, c.tags, key.start)
+		delete(o.candles, key)
+	}
+}
+
+// Reset keeps the candles which did not close yet, spanning periods
+func (o *OHLCV) Reset() {
+}
+
+func convert(in interface{}) (float64, bool) {
+	switch v := in.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+func init() {
+	aggregators.Add("ohlcv", func() telegraf.Aggregator {
+		return NewOHLCV()
+	})
+}
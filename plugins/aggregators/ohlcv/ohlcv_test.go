@@ -0,0 +1,133 @@
+package ohlcv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+var btcTags = map[string]string{"exchange": "coinbase", "base": "BTC", "quote": "USD"}
+
+func trade(tags map[string]string, price, size float64, t time.Time) telegraf.Metric {
+	return testutil.MustMetric("market_trade",
+		tags,
+		map[string]interface{}{"price": price, "size": size},
+		t,
+	)
+}
+
+func newTestOHLCV(t *testing.T, intervals ...string) (*OHLCV, *time.Time) {
+	now := start
+	o := NewOHLCV()
+	o.Intervals = intervals
+	o.now = func() time.Time { return now }
+	require.NoError(t, o.Init())
+	return o, &now
+}
+
+func TestCandle(t *testing.T) {
+	o, now := newTestOHLCV(t, "1m")
+
+	o.Add(trade(btcTags, 100, 0.5, start.Add(time.Second)))
+	o.Add(trade(btcTags, 104, 0.25, start.Add(20*time.Second)))
+	// out of order
+	o.Add(trade(btcTags, 98, 1, start.Add(40*time.Second)))
+	o.Add(trade(btcTags, 101, 0.5, start.Add(10*time.Second)))
+	// a ticker updates the prices, but not the volume
+	o.Add(testutil.MustMetric("market_ticker",
+		btcTags,
+		map[string]interface{}{"price": 99.0, "bid": 98.5, "ask": 99.0},
+		start.Add(50*time.Second),
+	))
+	// the next candle
+	o.Add(trade(btcTags, 97, 2, start.Add(time.Minute)))
+
+	// the candle did not close yet
+	acc := &testutil.Accumulator{}
+	*now = start.Add(59 * time.Second)
+	o.Push(acc)
+	o.Reset()
+	require.Empty(t, acc.GetTelegrafMetrics())
+
+	*now = start.Add(90 * time.Second)
+	o.Push(acc)
+	o.Reset()
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("candle",
+			map[string]string{"exchange": "coinbase", "base": "BTC", "quote": "USD", "interval": "1m"},
+			map[string]interface{}{
+				"open":   100.0,
+				"high":   104.0,
+				"low":    98.0,
+				"close":  99.0,
+				"volume": 2.25,
+				"trades": int64(4),
+			},
+			start,
+		),
+	}, acc.GetTelegrafMetrics())
+
+	// the candle is emitted once, and late prices are dropped
+	acc.ClearMetrics()
+	o.Add(trade(btcTags, 200, 1, start.Add(30*time.Second)))
+	*now = start.Add(2 * time.Minute)
+	o.Push(acc)
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("candle",
+			map[string]string{"exchange": "coinbase", "base": "BTC", "quote": "USD", "interval": "1m"},
+			map[string]interface{}{
+				"open":   97.0,
+				"high":   97.0,
+				"low":    97.0,
+				"close":  97.0,
+				"volume": 2.0,
+				"trades": int64(1),
+			},
+			start.Add(time.Minute),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestCandleIntervalsAndSeries(t *testing.T) {
+	o, now := newTestOHLCV(t, "1m", "5m")
+
+	ethTags := map[string]string{"exchange": "kraken", "base": "ETH", "quote": "USD", "side": "buy"}
+	o.Add(trade(btcTags, 100, 1, start.Add(30*time.Second)))
+	o.Add(trade(btcTags, 102, 1, start.Add(90*time.Second)))
+	o.Add(trade(ethTags, 1500, 4, start.Add(time.Minute)))
+
+	acc := &testutil.Accumulator{}
+	*now = start.Add(5 * time.Minute)
+	o.Push(acc)
+
+	candles := make(map[string]map[string]interface{})
+	for _, m := range acc.GetTelegrafMetrics() {
+		candles[m.Tags()["base"]+"/"+m.Tags()["interval"]+"@"+m.Time().Sub(start).String()] = m.Fields()
+		require.NotContains(t, m.Tags(), "side")
+	}
+	require.Len(t, candles, 5)
+	require.Equal(t, 100.0, candles["BTC/1m@0s"]["close"])
+	require.Equal(t, 102.0, candles["BTC/1m@1m0s"]["close"])
+	require.Equal(t, 100.0, candles["BTC/5m@0s"]["open"])
+	require.Equal(t, 102.0, candles["BTC/5m@0s"]["close"])
+	require.Equal(t, 2.0, candles["BTC/5m@0s"]["volume"])
+	require.Equal(t, 4.0, candles["ETH/1m@1m0s"]["volume"])
+	require.Equal(t, 4.0, candles["ETH/5m@0s"]["volume"])
+}
+
+func TestInit(t *testing.T) {
+	o := NewOHLCV()
+	o.Intervals = nil
+	require.Error(t, o.Init())
+
+	o.Intervals = []string{"1 minute"}
+	require.Error(t, o.Init())
+
+	o.Intervals = []string{"0s"}
+	require.Error(t, o.Init())
+}
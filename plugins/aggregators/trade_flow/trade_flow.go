@@ -0,0 +1,161 @@
+package tradeflow
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/aggregators"
+)
+
+var sampleConfig = `
+  ## The period on which to flush & clear the aggregator, the window of the
+  ## trade flows.
+  period = "30s"
+  ## If true, the original metric will be dropped by the
+  ## aggregator and will not get sent to the output plugins.
+  drop_original = false
+
+  ## The field of the size of every trade, and the tag or field of its side,
+  ## "buy" or "sell"
+  # size_field = "last_size"
+  # side_key = "side"
+
+  ## Set when the side is that of the maker order rather than of the taker
+  ## initiating the trade, e.g. for the match metrics of the Coinbase pro feed
+  # maker_side = false
+
+  ## The tags of the products whose flows are aggregated apart
+  # group_by = ["product_id"]
+
+  ## The measurement of the trade flows
+  # measurement = "trade_flow"
+`
+
+type TradeFlow struct {
+	SizeField   string   `toml:"size_field"`
+	SideKey     string   `toml:"side_key"`
+	MakerSide   bool     `toml:"maker_side"`
+	GroupBy     []string `toml:"group_by"`
+	Measurement string   `toml:"measurement"`
+
+	cache map[string]*flow
+}
+
+// flow is the volume initiated by buyers and sellers of a product over the
+// period
+type flow struct {
+	tags       map[string]string
+	buyVolume  float64
+	sellVolume float64
+	buyTrades  int64
+	sellTrades int64
+}
+
+func NewTradeFlow() *TradeFlow {
+	f := &TradeFlow{
+		SizeField:   "last_size",
+		SideKey:     "side",
+		GroupBy:     []string{"product_id"},
+		Measurement: "trade_flow",
+	}
+	f.Reset()
+	return f
+}
+
+func (f *TradeFlow) SampleConfig() string {
+	return sampleConfig
+}
+
+func (f *TradeFlow) Description() string {
+	return "Aggregate the volume of buyer and seller initiated trades of every product"
+}
+
+func (f *TradeFlow) Init() error {
+	if f.SizeField == "" || f.SideKey == "" {
+		return fmt.Errorf("size_field and side_key must be set")
+	}
+	return nil
+}
+
+func (f *TradeFlow) Add(in telegraf.Metric) {
+	size, ok := convert(in.Fields()[f.SizeField])
+	if !ok || size <= 0 {
+		return
+	}
+
+	side, ok := in.GetTag(f.SideKey)
+	if !ok {
+		side, _ = in.Fields()[f.SideKey].(string)
+	}
+	buy := side == "buy"
+	if !buy && side != "sell" {
+		return
+	}
+	if f.MakerSide {
+		buy = !buy
+	}
+
+	tags := make(map[string]string, len(f.GroupBy))
+	values := make([]string, 0, len(f.GroupBy))
+	for _, key := range f.GroupBy {
+		if value, ok := in.GetTag(key); ok {
+			tags[key] = value
+			values = append(values, key+"="+value)
+		}
+	}
+	series := strings.Join(values, ",")
+
+	a, ok := f.cache[series]
+	if !ok {
+		a = &flow{tags: tags}
+		f.cache[series] = a
+	}
+	if buy {
+		a.buyVolume += size
+		a.buyTrades++
+	} else {
+		a.sellVolume += size
+		a.sellTrades++
+	}
+}
+
+func (f *TradeFlow) Push(acc telegraf.Accumulator) {
+	for _, a := range f.cache {
+		fields := map[string]interface{}{
+			"buy_volume":  a.buyVolume,
+			"sell_volume": a.sellVolume,
+			"net_flow":    a.buyVolume - a.sellVolume,
+			"buy_trades":  a.buyTrades,
+			"sell_trades": a.sellTrades,
+		}
+		// undefined without sells
+		if a.sellVolume > 0 {
+			fields["buy_sell_ratio"] = a.buyVolume / a.sellVolume
+		}
+		acc.AddFields(f.Measurement, fields, a.tags)
+	}
+}
+
+func (f *TradeFlow) Reset() {
+	f.cache = make(map[string]*flow)
+}
+
+func convert(in interface{}) (float64, bool) {
+	switch v := in.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+func init() {
+	aggregators.Add("trade_flow", func() telegraf.Aggregator {
+		return NewTradeFlow()
+	})
+}
@@ -0,0 +1,80 @@
+package tradeflow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func ticker(productID string, side string, lastSize float64) telegraf.Metric {
+	return testutil.MustMetric("ticker",
+		map[string]string{"type": "ticker", "product_id": productID, "side": side},
+		map[string]interface{}{"price": 100.0, "last_size": lastSize},
+		time.Now(),
+	)
+}
+
+func TestTradeFlow(t *testing.T) {
+	f := NewTradeFlow()
+	require.NoError(t, f.Init())
+
+	f.Add(ticker("BTC-USD", "buy", 1.5))
+	f.Add(ticker("BTC-USD", "buy", 0.5))
+	f.Add(ticker("BTC-USD", "sell", 0.5))
+	f.Add(ticker("ETH-USD", "buy", 3))
+	// without a side
+	f.Add(ticker("ETH-USD", "", 3))
+
+	acc := &testutil.Accumulator{}
+	f.Push(acc)
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("trade_flow",
+			map[string]string{"product_id": "BTC-USD"},
+			map[string]interface{}{
+				"buy_volume":     2.0,
+				"sell_volume":    0.5,
+				"net_flow":       1.5,
+				"buy_sell_ratio": 4.0,
+				"buy_trades":     int64(2),
+				"sell_trades":    int64(1),
+			},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric("trade_flow",
+			map[string]string{"product_id": "ETH-USD"},
+			map[string]interface{}{
+				"buy_volume":  3.0,
+				"sell_volume": 0.0,
+				"net_flow":    3.0,
+				"buy_trades":  int64(1),
+				"sell_trades": int64(0),
+			},
+			time.Unix(0, 0),
+		),
+	}, acc.GetTelegrafMetrics(), testutil.SortMetrics(), testutil.IgnoreTime())
+}
+
+func TestMakerSideField(t *testing.T) {
+	f := NewTradeFlow()
+	f.SizeField = "size"
+	f.MakerSide = true
+	require.NoError(t, f.Init())
+
+	// the side of a match is a field without it among the metric_tag_keys,
+	// and that of the maker: a sell maker is a buyer initiated trade
+	f.Add(testutil.MustMetric("match",
+		map[string]string{"product_id": "BTC-USD"},
+		map[string]interface{}{"price": 400.23, "size": 5.0, "side": "sell"},
+		time.Now(),
+	))
+
+	acc := &testutil.Accumulator{}
+	f.Push(acc)
+	require.Len(t, acc.GetTelegrafMetrics(), 1)
+	fields := acc.GetTelegrafMetrics()[0].Fields()
+	require.Equal(t, 5.0, fields["buy_volume"])
+	require.Equal(t, 0.0, fields["sell_volume"])
+}
@@ -0,0 +1,148 @@
+package volatility
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/aggregators"
+)
+
+var sampleConfig = `
+  ## The period on which to flush & clear the aggregator, the window of the
+  ## volatilities.
+  period = "30s"
+  ## If true, the original metric will be dropped by the
+  ## aggregator and will not get sent to the output plugins.
+  drop_original = false
+
+  ## The field of the price of the trades and tickers
+  # price_field = "price"
+
+  ## The tags of the products whose volatility is computed apart
+  # group_by = ["product_id"]
+
+  ## The measurement of the volatilities
+  # measurement = "volatility"
+`
+
+type Volatility struct {
+	PriceField  string   `toml:"price_field"`
+	GroupBy     []string `toml:"group_by"`
+	Measurement string   `toml:"measurement"`
+
+	// the last price of every product, kept across periods for the first
+	// return of a period to be that from the last price of the previous one
+	prices map[string]float64
+	cache  map[string]*aggregate
+}
+
+// aggregate is the sums of the log returns of a product over the period
+type aggregate struct {
+	tags    map[string]string
+	count   int64
+	sum     float64
+	squares float64
+}
+
+func NewVolatility() *Volatility {
+	v := &Volatility{
+		PriceField:  "price",
+		GroupBy:     []string{"product_id"},
+		Measurement: "volatility",
+		prices:      make(map[string]float64),
+	}
+	v.Reset()
+	return v
+}
+
+func (v *Volatility) SampleConfig() string {
+	return sampleConfig
+}
+
+func (v *Volatility) Description() string {
+	return "Compute the realized volatility of the prices of every product"
+}
+
+func (v *Volatility) Init() error {
+	if v.PriceField == "" {
+		return fmt.Errorf("price_field must be set")
+	}
+	return nil
+}
+
+func (v *Volatility) Add(in telegraf.Metric) {
+	price, ok := convert(in.Fields()[v.PriceField])
+	if !ok || price <= 0 {
+		return
+	}
+
+	tags := make(map[string]string, len(v.GroupBy))
+	values := make([]string, 0, len(v.GroupBy))
+	for _, key := range v.GroupBy {
+		if value, ok := in.GetTag(key); ok {
+			tags[key] = value
+			values = append(values, key+"="+value)
+		}
+	}
+	series := strings.Join(values, ",")
+
+	last, ok := v.prices[series]
+	v.prices[series] = price
+	if !ok {
+		return
+	}
+
+	a, ok := v.cache[series]
+	if !ok {
+		a = &aggregate{tags: tags}
+		v.cache[series] = a
+	}
+	r := math.Log(price / last)
+	a.count++
+	a.sum += r
+	a.squares += r * r
+}
+
+func (v *Volatility) Push(acc telegraf.Accumulator) {
+	for _, a := range v.cache {
+		// the standard deviation of a single return is undefined
+		if a.count < 2 {
+			continue
+		}
+		mean := a.sum / float64(a.count)
+		variance := (a.squares - float64(a.count)*mean*mean) / float64(a.count-1)
+		if variance < 0 {
+			variance = 0
+		}
+		acc.AddFields(v.Measurement, map[string]interface{}{
+			"volatility":  math.Sqrt(variance),
+			"mean_return": mean,
+			"returns":     a.count,
+		}, a.tags)
+	}
+}
+
+func (v *Volatility) Reset() {
+	v.cache = make(map[string]*aggregate)
+}
+
+func convert(in interface{}) (float64, bool) {
+	switch v := in.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+func init() {
+	aggregators.Add("volatility", func() telegraf.Aggregator {
+		return NewVolatility()
+	})
+}
@@ -0,0 +1,71 @@
+package volatility
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func ticker(productID string, price float64) telegraf.Metric {
+	return testutil.MustMetric("ticker",
+		map[string]string{"type": "ticker", "product_id": productID, "side": "buy"},
+		map[string]interface{}{"price": price},
+		time.Now(),
+	)
+}
+
+func TestVolatility(t *testing.T) {
+	v := NewVolatility()
+	require.NoError(t, v.Init())
+
+	// log returns of ln(2), -ln(2) and ln(2)
+	for _, price := range []float64{100, 200, 100, 200} {
+		v.Add(ticker("BTC-USD", price))
+	}
+	// a single return
+	v.Add(ticker("ETH-USD", 10))
+	v.Add(ticker("ETH-USD", 11))
+
+	acc := &testutil.Accumulator{}
+	v.Push(acc)
+	metrics := acc.GetTelegrafMetrics()
+	require.Len(t, metrics, 1)
+	require.Equal(t, "volatility", metrics[0].Name())
+	require.Equal(t, map[string]string{"product_id": "BTC-USD"}, metrics[0].Tags())
+
+	// a mean of ln(2)/3, and squared deviations of (2ln(2)/3)², (4ln(2)/3)²
+	// and (2ln(2)/3)² over 2 degrees of freedom
+	ln2 := math.Log(2)
+	fields := metrics[0].Fields()
+	require.InDelta(t, ln2/3, fields["mean_return"], 1e-12)
+	require.InDelta(t, math.Sqrt(24*ln2*ln2/9/2), fields["volatility"], 1e-12)
+	require.Equal(t, int64(3), fields["returns"])
+}
+
+func TestReturnsSpanPeriods(t *testing.T) {
+	v := NewVolatility()
+	require.NoError(t, v.Init())
+
+	v.Add(ticker("BTC-USD", 100))
+	acc := &testutil.Accumulator{}
+	v.Push(acc)
+	v.Reset()
+	require.Empty(t, acc.GetTelegrafMetrics())
+
+	// the first return of the period is from the last price of the previous
+	// one
+	v.Add(ticker("BTC-USD", 100))
+	v.Add(ticker("BTC-USD", 100))
+	v.Push(acc)
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("volatility",
+			map[string]string{"product_id": "BTC-USD"},
+			map[string]interface{}{"volatility": 0.0, "mean_return": 0.0, "returns": int64(2)},
+			time.Unix(0, 0),
+		),
+	}, acc.GetTelegrafMetrics(), testutil.IgnoreTime())
+}
@@ -0,0 +1,155 @@
+package volumeprofile
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/aggregators"
+)
+
+var sampleConfig = `
+  ## The period on which to flush & clear the aggregator, the window of the
+  ## volume profiles.
+  period = "30s"
+  ## If true, the original metric will be dropped by the
+  ## aggregator and will not get sent to the output plugins.
+  drop_original = false
+
+  ## The width of the price buckets
+  bucket_size = 10.0
+
+  ## The fields of the price and the size of every trade
+  # price_field = "price"
+  # size_field = "last_size"
+
+  ## The tags of the products profiled apart
+  # group_by = ["product_id"]
+
+  ## The measurement of the buckets
+  # measurement = "volume_profile"
+`
+
+type VolumeProfile struct {
+	BucketSize  float64  `toml:"bucket_size"`
+	PriceField  string   `toml:"price_field"`
+	SizeField   string   `toml:"size_field"`
+	GroupBy     []string `toml:"group_by"`
+	Measurement string   `toml:"measurement"`
+
+	// the decimals of the price_bucket tags, those of bucket_size
+	decimals int
+	cache    map[bucketKey]*bucket
+}
+
+type bucketKey struct {
+	series string
+	index  int64
+}
+
+type bucket struct {
+	tags   map[string]string
+	volume float64
+	trades int64
+}
+
+func NewVolumeProfile() *VolumeProfile {
+	v := &VolumeProfile{
+		PriceField:  "price",
+		SizeField:   "last_size",
+		GroupBy:     []string{"product_id"},
+		Measurement: "volume_profile",
+	}
+	v.Reset()
+	return v
+}
+
+func (v *VolumeProfile) SampleConfig() string {
+	return sampleConfig
+}
+
+func (v *VolumeProfile) Description() string {
+	return "Bucket the traded volume of every product by price level"
+}
+
+func (v *VolumeProfile) Init() error {
+	if v.BucketSize <= 0 || math.IsInf(v.BucketSize, 0) || math.IsNaN(v.BucketSize) {
+		return fmt.Errorf("invalid bucket_size %v, must be positive", v.BucketSize)
+	}
+	if v.PriceField == "" || v.SizeField == "" {
+		return fmt.Errorf("price_field and size_field must be set")
+	}
+
+	text := strconv.FormatFloat(v.BucketSize, 'f', -1, 64)
+	if i := strings.IndexByte(text, '.'); i >= 0 {
+		v.decimals = len(text) - i - 1
+	}
+	return nil
+}
+
+func (v *VolumeProfile) Add(in telegraf.Metric) {
+	price, ok := convert(in.Fields()[v.PriceField])
+	if !ok {
+		return
+	}
+	size, ok := convert(in.Fields()[v.SizeField])
+	if !ok || size <= 0 {
+		return
+	}
+
+	tags := make(map[string]string, len(v.GroupBy)+1)
+	values := make([]string, 0, len(v.GroupBy))
+	for _, key := range v.GroupBy {
+		if value, ok := in.GetTag(key); ok {
+			tags[key] = value
+			values = append(values, key+"="+value)
+		}
+	}
+	key := bucketKey{
+		series: strings.Join(values, ","),
+		index:  int64(math.Floor(price / v.BucketSize)),
+	}
+
+	b, ok := v.cache[key]
+	if !ok {
+		tags["price_bucket"] = strconv.FormatFloat(float64(key.index)*v.BucketSize, 'f', v.decimals, 64)
+		b = &bucket{tags: tags}
+		v.cache[key] = b
+	}
+	b.volume += size
+	b.trades++
+}
+
+func (v *VolumeProfile) Push(acc telegraf.Accumulator) {
+	for _, b := range v.cache {
+		acc.AddFields(v.Measurement, map[string]interface{}{
+			"volume": b.volume,
+			"trades": b.trades,
+		}, b.tags)
+	}
+}
+
+func (v *VolumeProfile) Reset() {
+	v.cache = make(map[bucketKey]*bucket)
+}
+
+func convert(in interface{}) (float64, bool) {
+	switch v := in.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+func init() {
+	aggregators.Add("volume_profile", func() telegraf.Aggregator {
+		return NewVolumeProfile()
+	})
+}
@@ -0,0 +1,75 @@
+package volumeprofile
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func ticker(productID string, price, lastSize float64) telegraf.Metric {
+	return testutil.MustMetric("ticker",
+		map[string]string{"type": "ticker", "product_id": productID, "side": "buy"},
+		map[string]interface{}{"price": price, "last_size": lastSize},
+		time.Now(),
+	)
+}
+
+func TestVolumeProfile(t *testing.T) {
+	v := NewVolumeProfile()
+	v.BucketSize = 10
+	require.NoError(t, v.Init())
+
+	v.Add(ticker("BTC-USD", 100, 0.5))
+	v.Add(ticker("BTC-USD", 109.99, 0.25))
+	v.Add(ticker("BTC-USD", 110, 2))
+	v.Add(ticker("ETH-USD", 5, 1))
+
+	acc := &testutil.Accumulator{}
+	v.Push(acc)
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("volume_profile",
+			map[string]string{"product_id": "BTC-USD", "price_bucket": "100"},
+			map[string]interface{}{"volume": 0.75, "trades": int64(2)},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric("volume_profile",
+			map[string]string{"product_id": "BTC-USD", "price_bucket": "110"},
+			map[string]interface{}{"volume": 2.0, "trades": int64(1)},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric("volume_profile",
+			map[string]string{"product_id": "ETH-USD", "price_bucket": "0"},
+			map[string]interface{}{"volume": 1.0, "trades": int64(1)},
+			time.Unix(0, 0),
+		),
+	}, acc.GetTelegrafMetrics(), testutil.SortMetrics(), testutil.IgnoreTime())
+
+	// every window is profiled apart
+	v.Reset()
+	acc.ClearMetrics()
+	v.Push(acc)
+	require.Empty(t, acc.GetTelegrafMetrics())
+}
+
+func TestFractionalBuckets(t *testing.T) {
+	v := NewVolumeProfile()
+	v.BucketSize = 0.1
+	require.NoError(t, v.Init())
+
+	v.Add(ticker("DOGE-USD", 0.35, 100))
+	acc := &testutil.Accumulator{}
+	v.Push(acc)
+	require.Len(t, acc.GetTelegrafMetrics(), 1)
+	require.Equal(t, "0.3", acc.GetTelegrafMetrics()[0].Tags()["price_bucket"])
+}
+
+func TestInit(t *testing.T) {
+	v := NewVolumeProfile()
+	require.Error(t, v.Init())
+
+	v.BucketSize = -1
+	require.Error(t, v.Init())
+}
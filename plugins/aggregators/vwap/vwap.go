@@ -0,0 +1,138 @@
+package vwap
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/aggregators"
+)
+
+var sampleConfig = `
+  ## The period on which to flush & clear the aggregator, the window of the
+  ## average prices.
+  period = "30s"
+  ## If true, the original metric will be dropped by the
+  ## aggregator and will not get sent to the output plugins.
+  drop_original = false
+
+  ## The fields of the price and the size of every trade
+  # price_field = "price"
+  # size_field = "last_size"
+
+  ## The tags of the products averaged apart
+  # group_by = ["product_id"]
+
+  ## The measurement of the average prices
+  # measurement = "vwap"
+`
+
+type VWAP struct {
+	PriceField  string   `toml:"price_field"`
+	SizeField   string   `toml:"size_field"`
+	GroupBy     []string `toml:"group_by"`
+	Measurement string   `toml:"measurement"`
+
+	cache map[string]*aggregate
+}
+
+type aggregate struct {
+	tags     map[string]string
+	notional float64
+	volume   float64
+	trades   int64
+}
+
+func NewVWAP() *VWAP {
+	v := &VWAP{
+		PriceField:  "price",
+		SizeField:   "last_size",
+		GroupBy:     []string{"product_id"},
+		Measurement: "vwap",
+	}
+	v.Reset()
+	return v
+}
+
+func (v *VWAP) SampleConfig() string {
+	return sampleConfig
+}
+
+func (v *VWAP) Description() string {
+	return "Compute the volume-weighted average price of the trades of every product"
+}
+
+func (v *VWAP) Init() error {
+	if v.PriceField == "" || v.SizeField == "" {
+		return fmt.Errorf("price_field and size_field must be set")
+	}
+	return nil
+}
+
+func (v *VWAP) Add(in telegraf.Metric) {
+	price, ok := convert(in.Fields()[v.PriceField])
+	if !ok {
+		return
+	}
+	size, ok := convert(in.Fields()[v.SizeField])
+	if !ok || size < 0 {
+		return
+	}
+
+	tags := make(map[string]string, len(v.GroupBy))
+	values := make([]string, 0, len(v.GroupBy))
+	for _, key := range v.GroupBy {
+		if value, ok := in.GetTag(key); ok {
+			tags[key] = value
+			values = append(values, key+"="+value)
+		}
+	}
+	series := strings.Join(values, ",")
+
+	a, ok := v.cache[series]
+	if !ok {
+		a = &aggregate{tags: tags}
+		v.cache[series] = a
+	}
+	a.notional += price * size
+	a.volume += size
+	a.trades++
+}
+
+func (v *VWAP) Push(acc telegraf.Accumulator) {
+	for _, a := range v.cache {
+		// trades of no size leave the average price undefined
+		if a.volume == 0 {
+			continue
+		}
+		acc.AddFields(v.Measurement, map[string]interface{}{
+			"vwap":     a.notional / a.volume,
+			"volume":   a.volume,
+			"notional": a.notional,
+			"trades":   a.trades,
+		}, a.tags)
+	}
+}
+
+func (v *VWAP) Reset() {
+	v.cache = make(map[string]*aggregate)
+}
+
+func convert(in interface{}) (float64, bool) {
+	switch v := in.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+func init() {
+	aggregators.Add("vwap", func() telegraf.Aggregator {
+		return NewVWAP()
+	})
+}
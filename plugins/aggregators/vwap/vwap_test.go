@@ -0,0 +1,87 @@
+package vwap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func ticker(productID string, price, lastSize float64) telegraf.Metric {
+	return testutil.MustMetric("ticker",
+		map[string]string{"type": "ticker", "product_id": productID, "side": "buy"},
+		map[string]interface{}{"price": price, "last_size": lastSize, "best_bid": price - 1, "best_ask": price},
+		time.Now(),
+	)
+}
+
+func TestVWAP(t *testing.T) {
+	v := NewVWAP()
+	require.NoError(t, v.Init())
+
+	v.Add(ticker("BTC-USD", 100, 1))
+	v.Add(ticker("BTC-USD", 104, 3))
+	v.Add(ticker("ETH-USD", 10, 0.5))
+	// not a trade
+	v.Add(testutil.MustMetric("coinbase_book",
+		map[string]string{"product_id": "BTC-USD"},
+		map[string]interface{}{"best_bid": 99.0, "best_ask": 100.0},
+		time.Now(),
+	))
+
+	acc := &testutil.Accumulator{}
+	v.Push(acc)
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("vwap",
+			map[string]string{"product_id": "BTC-USD"},
+			map[string]interface{}{"vwap": 103.0, "volume": 4.0, "notional": 412.0, "trades": int64(2)},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric("vwap",
+			map[string]string{"product_id": "ETH-USD"},
+			map[string]interface{}{"vwap": 10.0, "volume": 0.5, "notional": 5.0, "trades": int64(1)},
+			time.Unix(0, 0),
+		),
+	}, acc.GetTelegrafMetrics(), testutil.SortMetrics(), testutil.IgnoreTime())
+
+	// every window is averaged apart
+	v.Reset()
+	acc.ClearMetrics()
+	v.Add(ticker("BTC-USD", 110, 1))
+	v.Push(acc)
+	require.Len(t, acc.GetTelegrafMetrics(), 1)
+	require.Equal(t, 110.0, acc.GetTelegrafMetrics()[0].Fields()["vwap"])
+}
+
+func TestVWAPOfNormalizedTrades(t *testing.T) {
+	v := NewVWAP()
+	v.SizeField = "size"
+	v.GroupBy = []string{"exchange", "base", "quote"}
+	require.NoError(t, v.Init())
+
+	for _, side := range []string{"buy", "sell"} {
+		v.Add(testutil.MustMetric("market_trade",
+			map[string]string{"exchange": "kraken", "base": "BTC", "quote": "USD", "side": side},
+			map[string]interface{}{"price": 100.0, "size": 0.5},
+			time.Now(),
+		))
+	}
+	// trades of no size are not averaged
+	v.Add(testutil.MustMetric("market_trade",
+		map[string]string{"exchange": "okx", "base": "BTC", "quote": "USD", "side": "buy"},
+		map[string]interface{}{"price": 100.0, "size": 0.0},
+		time.Now(),
+	))
+
+	acc := &testutil.Accumulator{}
+	v.Push(acc)
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("vwap",
+			map[string]string{"exchange": "kraken", "base": "BTC", "quote": "USD"},
+			map[string]interface{}{"vwap": 100.0, "volume": 1.0, "notional": 100.0, "trades": int64(2)},
+			time.Unix(0, 0),
+		),
+	}, acc.GetTelegrafMetrics(), testutil.IgnoreTime())
+}
@@ -0,0 +1,236 @@
+// Package marketdata is the normalized schema shared by the exchange inputs,
+// so that the trades, tickers and books of every venue are emitted with the
+// same measurements, tags and fields:
+//
+//   - market_trade, tagged with exchange, base, quote and side (of the
+//     taker), with the fields price and size
+//   - market_ticker, tagged with exchange, base and quote, with the fields
+//     price, bid, bid_size, ask, ask_size and volume_24h
+//   - market_book, tagged with exchange, base and quote, with the fields bid,
+//     bid_size, ask, ask_size, spread, mid_price, bid_depth and ask_depth
+//
+// The other tags of the native metrics, e.g. channel or those configured,
+// are kept, only the symbol tag being replaced by base and quote.
+//
+// Every input maps its native metrics to the schema with a Normalizer.
+package marketdata
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+)
+
+// the values of the metric_schema option of the inputs
+const (
+	SchemaNative     = "native"
+	SchemaNormalized = "normalized"
+)
+
+// the measurements of the normalized schema
+const (
+	Trade  = "market_trade"
+	Ticker = "market_ticker"
+	Book   = "market_book"
+)
+
+// the fields of every measurement of the schema, keyed by the name of the
+// field in the native metrics of the inputs
+var schemaFields = map[string]map[string]string{
+	Trade: {
+		"price": "price",
+		"size":  "size",
+	},
+	Ticker: {
+		"price":         "price",
+		"best_bid":      "bid",
+		"best_bid_size": "bid_size",
+		"best_ask":      "ask",
+		"best_ask_size": "ask_size",
+		"volume_24h":    "volume_24h",
+	},
+	Book: {
+		"best_bid":      "bid",
+		"best_bid_size": "bid_size",
+		"best_ask":      "ask",
+		"best_ask_size": "ask_size",
+		"spread":        "spread",
+		"mid_price":     "mid_price",
+		"bid_depth":     "bid_depth",
+		"ask_depth":     "ask_depth",
+	},
+}
+
+// quoteCurrencies are the quote currencies recognized at the end of the
+// symbols concatenating their currencies, e.g. BTCUSDT, longest first
+var quoteCurrencies = []string{
+	"FDUSD", "USDT", "USDC", "BUSD", "TUSD", "USDP", "GUSD", "PYUSD", "DAI",
+	"USD", "EUR", "GBP", "JPY", "AUD", "CAD", "CHF", "SGD", "TRY", "BRL",
+	"BTC", "ETH", "BNB",
+}
+
+func init() {
+	sort.SliceStable(quoteCurrencies, func(i, j int) bool {
+		return len(quoteCurrencies[i]) > len(quoteCurrencies[j])
+	})
+}
+
+// Pair is the base and quote currency of a symbol
+type Pair struct {
+	Base  string
+	Quote string
+}
+
+// ParsePair splits a symbol into its currencies, either separated by "-",
+// "/", "_" or ":", e.g. BTC-USD, the parts after the quote currency such
+// as the SWAP of BTC-USDT-SWAP being ignored, or concatenated and ending
+// with a known quote currency, e.g. btcusdt. The currencies are upper cased.
+func ParsePair(symbol string) (Pair, error) {
+	s := strings.ToUpper(symbol)
+	if i := strings.IndexAny(s, "-/_:"); i >= 0 {
+		base, quote := s[:i], s[i+1:]
+		if j := strings.IndexAny(quote, "-/_:"); j >= 0 {
+			quote = quote[:j]
+		}
+		if base == "" || quote == "" {
+			return Pair{}, fmt.Errorf("invalid symbol %q", symbol)
+		}
+		return Pair{Base: base, Quote: quote}, nil
+	}
+
+	for _, quote := range quoteCurrencies {
+		if len(s) > len(quote) && strings.HasSuffix(s, quote) {
+			return Pair{Base: strings.TrimSuffix(s, quote), Quote: quote}, nil
+		}
+	}
+	return Pair{}, fmt.Errorf("unable to tell the currencies of symbol %q apart", symbol)
+}
+
+// Normalizer maps the native metrics of an exchange input to the schema
+type Normalizer struct {
+	// Exchange is the value of the exchange tag, e.g. "kraken"
+	Exchange string
+
+	// Measurements maps the native measurements of the input to those of
+	// the schema. The other measurements, e.g. events, are emitted as they
+	// are.
+	Measurements map[string]string
+
+	// SymbolTag is the tag of the symbol in the native metrics
+	SymbolTag string
+
+	// ParsePair splits a symbol of the input, defaults to ParsePair
+	ParsePair func(symbol string) (Pair, error)
+
+	// MakerSide is set when the side of the native trades is the side of
+	// the maker order, flipped to the side of the taker
+	MakerSide bool
+}
+
+// NewNormalizer returns n when schema is normalized, checking that the
+// currencies of every symbol can be told apart, and nil when native
+func NewNormalizer(schema string, n Normalizer, symbols []string) (*Normalizer, error) {
+	switch schema {
+	case "", SchemaNative:
+		return nil, nil
+	case SchemaNormalized:
+	default:
+		return nil, fmt.Errorf("invalid metric_schema %q, must be %q or %q", schema, SchemaNative, SchemaNormalized)
+	}
+
+	if n.ParsePair == nil {
+		n.ParsePair = ParsePair
+	}
+	for _, symbol := range symbols {
+		if _, err := n.ParsePair(symbol); err != nil {
+			return nil, err
+		}
+	}
+	return &n, nil
+}
+
+// Normalize returns the measurement, fields and tags of a native metric in
+// the schema, or the metric as it is when not of the schema. The tags are
+// kept but for the symbol tag, which is replaced by base and quote.
+func (n *Normalizer) Normalize(measurement string, fields map[string]interface{}, tags map[string]string) (string, map[string]interface{}, map[string]string) {
+	name, ok := n.Measurements[measurement]
+	if !ok {
+		return measurement, fields, tags
+	}
+	pair, err := n.ParsePair(tags[n.SymbolTag])
+	if err != nil {
+		return measurement, fields, tags
+	}
+
+	normalized := make(map[string]interface{}, len(schemaFields[name]))
+	for from, to := range schemaFields[name] {
+		if value, ok := fields[from]; ok {
+			normalized[to] = value
+		}
+	}
+
+	normalizedTags := make(map[string]string, len(tags)+2)
+	for key, value := range tags {
+		normalizedTags[key] = value
+	}
+	delete(normalizedTags, n.SymbolTag)
+	normalizedTags["exchange"] = n.Exchange
+	normalizedTags["base"] = pair.Base
+	normalizedTags["quote"] = pair.Quote
+	if side, ok := tags["side"]; ok && name == Trade && n.MakerSide {
+		normalizedTags["side"] = oppositeSide(side)
+	}
+	return name, normalized, normalizedTags
+}
+
+// NormalizeMetric returns a native metric in the schema, or m when not of
+// the schema
+func (n *Normalizer) NormalizeMetric(m telegraf.Metric) telegraf.Metric {
+	if _, ok := n.Measurements[m.Name()]; !ok {
+		return m
+	}
+
+	name, fields, tags := n.Normalize(m.Name(), m.Fields(), m.Tags())
+	normalized, err := metric.New(name, tags, fields, m.Time(), m.Type())
+	if err != nil {
+		return m
+	}
+	return normalized
+}
+
+func oppositeSide(side string) string {
+	switch side {
+	case "buy":
+		return "sell"
+	case "sell":
+		return "buy"
+	}
+	return side
+}
+
+// Accumulator returns acc normalizing the metrics added, or acc itself when
+// n is nil, i.e. the schema is native
+func (n *Normalizer) Accumulator(acc telegraf.Accumulator) telegraf.Accumulator {
+	if n == nil {
+		return acc
+	}
+	return &accumulator{Accumulator: acc, normalizer: n}
+}
+
+type accumulator struct {
+	telegraf.Accumulator
+	normalizer *Normalizer
+}
+
+func (a *accumulator) AddFields(measurement string, fields map[string]interface{}, tags map[string]string, t ...time.Time) {
+	measurement, fields, tags = a.normalizer.Normalize(measurement, fields, tags)
+	a.Accumulator.AddFields(measurement, fields, tags, t...)
+}
+
+func (a *accumulator) AddMetric(m telegraf.Metric) {
+	a.Accumulator.AddMetric(a.normalizer.NormalizeMetric(m))
+}
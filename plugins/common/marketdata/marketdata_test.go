@@ -0,0 +1,144 @@
+package marketdata
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePair(t *testing.T) {
+	for symbol, expected := range map[string]Pair{
+		"BTC-USD":       {"BTC", "USD"},
+		"XBT/EUR":       {"XBT", "EUR"},
+		"BTC-USDT-SWAP": {"BTC", "USDT"},
+		"TESTBTC:TUSD":  {"TESTBTC", "TUSD"},
+		"btcusdt":       {"BTC", "USDT"},
+		"BTCGUSD":       {"BTC", "GUSD"},
+		"ETHBTC":        {"ETH", "BTC"},
+		"1000PEPEUSDC":  {"1000PEPE", "USDC"},
+	} {
+		pair, err := ParsePair(symbol)
+		require.NoError(t, err, symbol)
+		require.Equal(t, expected, pair, symbol)
+	}
+
+	for _, symbol := range []string{"BTCXYZ", "USDT", "-USD", "BTC-"} {
+		_, err := ParsePair(symbol)
+		require.Error(t, err, symbol)
+	}
+}
+
+func newTestNormalizer(t *testing.T) *Normalizer {
+	n, err := NewNormalizer(SchemaNormalized, Normalizer{
+		Exchange: "test",
+		Measurements: map[string]string{
+			"test_trade":  Trade,
+			"test_ticker": Ticker,
+			"test_book":   Book,
+		},
+		SymbolTag: "symbol",
+	}, []string{"BTCUSDT"})
+	require.NoError(t, err)
+	return n
+}
+
+func TestNormalizerAccumulator(t *testing.T) {
+	tm := time.Unix(1614600000, 0)
+	acc := &testutil.Accumulator{}
+	nacc := newTestNormalizer(t).Accumulator(acc)
+
+	nacc.AddFields("test_trade",
+		map[string]interface{}{"price": 16578.5, "size": 0.001, "trade_id": "20f43950"},
+		map[string]string{"symbol": "BTCUSDT", "side": "buy"},
+		tm,
+	)
+	nacc.AddFields("test_ticker",
+		map[string]interface{}{"price": 16578.5, "best_bid": 16578.0, "best_ask": 16579.0, "high_24h": 17000.0},
+		map[string]string{"symbol": "BTCUSDT"},
+		tm,
+	)
+	nacc.AddMetric(testutil.MustMetric("test_book",
+		map[string]string{"symbol": "BTCUSDT", "levels": "5"},
+		map[string]interface{}{"best_bid": 16578.0, "best_bid_size": 1.5, "best_ask": 16579.0, "best_ask_size": 2.0, "bid_depth": 3.0, "update_id": int64(4)},
+		tm,
+	))
+	// not of the schema
+	nacc.AddFields("test_sequence_gap",
+		map[string]interface{}{"last_seq_id": int64(1)},
+		map[string]string{"symbol": "BTCUSDT"},
+		tm,
+	)
+
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric(Trade,
+			map[string]string{"exchange": "test", "base": "BTC", "quote": "USDT", "side": "buy"},
+			map[string]interface{}{"price": 16578.5, "size": 0.001},
+			tm,
+		),
+		testutil.MustMetric(Ticker,
+			map[string]string{"exchange": "test", "base": "BTC", "quote": "USDT"},
+			map[string]interface{}{"price": 16578.5, "bid": 16578.0, "ask": 16579.0},
+			tm,
+		),
+		testutil.MustMetric(Book,
+			map[string]string{"exchange": "test", "base": "BTC", "quote": "USDT", "levels": "5"},
+			map[string]interface{}{"bid": 16578.0, "bid_size": 1.5, "ask": 16579.0, "ask_size": 2.0, "bid_depth": 3.0},
+			tm,
+		),
+		testutil.MustMetric("test_sequence_gap",
+			map[string]string{"symbol": "BTCUSDT"},
+			map[string]interface{}{"last_seq_id": int64(1)},
+			tm,
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestNormalizerFlipsMakerSide(t *testing.T) {
+	n := newTestNormalizer(t)
+	n.MakerSide = true
+
+	name, fields, tags := n.Normalize("test_trade",
+		map[string]interface{}{"price": 16578.5, "size": 0.001},
+		map[string]string{"symbol": "BTCUSDT", "side": "sell"},
+	)
+	require.Equal(t, Trade, name)
+	require.Equal(t, map[string]interface{}{"price": 16578.5, "size": 0.001}, fields)
+	require.Equal(t, map[string]string{"exchange": "test", "base": "BTC", "quote": "USDT", "side": "buy"}, tags)
+}
+
+func TestNormalizerKeepsTags(t *testing.T) {
+	n := newTestNormalizer(t)
+
+	// a tag of the input and one configured by the user
+	name, _, tags := n.Normalize("test_ticker",
+		map[string]interface{}{"price": 16578.5},
+		map[string]string{"symbol": "BTCUSDT", "channel": "tickers", "region": "eu"},
+	)
+	require.Equal(t, Ticker, name)
+	require.Equal(t, map[string]string{"exchange": "test", "base": "BTC", "quote": "USDT", "channel": "tickers", "region": "eu"}, tags)
+
+	m := n.NormalizeMetric(testutil.MustMetric("test_trade",
+		map[string]string{"symbol": "BTCUSDT", "side": "buy", "region": "eu"},
+		map[string]interface{}{"price": 16578.5, "size": 0.001},
+		time.Unix(1614600000, 0),
+	))
+	require.Equal(t, map[string]string{"exchange": "test", "base": "BTC", "quote": "USDT", "side": "buy", "region": "eu"}, m.Tags())
+}
+
+func TestNewNormalizer(t *testing.T) {
+	n, err := NewNormalizer(SchemaNative, Normalizer{}, []string{"BTCXYZ"})
+	require.NoError(t, err)
+	require.Nil(t, n)
+
+	acc := &testutil.Accumulator{}
+	require.Equal(t, acc, n.Accumulator(acc))
+
+	_, err = NewNormalizer("flat", Normalizer{}, nil)
+	require.Error(t, err)
+
+	_, err = NewNormalizer(SchemaNormalized, Normalizer{}, []string{"BTCXYZ"})
+	require.Error(t, err)
+}
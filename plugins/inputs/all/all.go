@@ -190,5 +190,21 @@ import (
 	_ "github.com/influxdata/telegraf/plugins/inputs/zfs"
 	_ "github.com/influxdata/telegraf/plugins/inputs/zipkin"
 	_ "github.com/influxdata/telegraf/plugins/inputs/zookeeper"
+	_ "github.com/influxdata/telegraf/plugins/inputs/binance_marketdata"
+	_ "github.com/influxdata/telegraf/plugins/inputs/bitfinex_marketdata"
+	_ "github.com/influxdata/telegraf/plugins/inputs/bitstamp_marketdata"
+	_ "github.com/influxdata/telegraf/plugins/inputs/bybit_marketdata"
+	_ "github.com/influxdata/telegraf/plugins/inputs/coinbase_accounts"
+	_ "github.com/influxdata/telegraf/plugins/inputs/coinbase_candles"
+	_ "github.com/influxdata/telegraf/plugins/inputs/coinbase_fix"
 	_ "github.com/influxdata/telegraf/plugins/inputs/coinbase_marketdata"
+	_ "github.com/influxdata/telegraf/plugins/inputs/funding_rate"
+	_ "github.com/influxdata/telegraf/plugins/inputs/gemini_marketdata"
+	_ "github.com/influxdata/telegraf/plugins/inputs/htx_marketdata"
+	_ "github.com/influxdata/telegraf/plugins/inputs/kraken_marketdata"
+	_ "github.com/influxdata/telegraf/plugins/inputs/kucoin_marketdata"
+	_ "github.com/influxdata/telegraf/plugins/inputs/okx_marketdata"
+	_ "github.com/influxdata/telegraf/plugins/inputs/open_interest"
+	_ "github.com/influxdata/telegraf/plugins/inputs/sse_listener"
+	_ "github.com/influxdata/telegraf/plugins/inputs/websocket_listener"
 )
@@ -0,0 +1,425 @@
+package binance_marketdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/wsclient"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/plugins/common/tls"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const (
+	defaultServiceAddress = "wss://stream.binance.com:9443/stream"
+	defaultDepthLevels    = 10
+
+	streamTrade      = "trade"
+	streamBookTicker = "bookTicker"
+	streamDepth      = "depth"
+)
+
+// the levels of the partial book depth streams
+var depthLevels = map[int]bool{5: true, 10: true, 20: true}
+
+type BinanceMarketData struct {
+	ServiceAddress string   `toml:"service_address"`
+	Symbols        []string `toml:"symbols"`
+	Streams        []string `toml:"streams"`
+	DepthLevels    int      `toml:"depth_levels"`
+	MetricSchema   string   `toml:"metric_schema"`
+
+	PingInterval         internal.Duration `toml:"ping_interval"`
+	PongWait             internal.Duration `toml:"pong_wait"`
+	ReconnectInterval    internal.Duration `toml:"reconnect_interval"`
+	MaxBackoff           internal.Duration `toml:"max_backoff"`
+	MaxReconnectAttempts int               `toml:"max_reconnect_attempts"`
+
+	tls.ClientConfig
+
+	Log telegraf.Logger `toml:"-"`
+
+	acc        telegraf.Accumulator
+	client     *wsclient.Client
+	normalizer *marketdata.Normalizer
+	now        func() time.Time
+}
+
+// combinedMessage is the envelope of every message of a combined stream
+type combinedMessage struct {
+	Stream string          `json:"stream"`
+	Data   json.RawMessage `json:"data"`
+
+	// the answer to a subscription request
+	ID    *int64 `json:"id"`
+	Error *struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	} `json:"error"`
+}
+
+// trade is the payload of the <symbol>@trade stream
+type trade struct {
+	Symbol       string `json:"s"`
+	TradeId      int64  `json:"t"`
+	Price        string `json:"p"`
+	Quantity     string `json:"q"`
+	TradeTime    int64  `json:"T"`
+	BuyerIsMaker bool   `json:"m"`
+}
+
+// bookTicker is the payload of the <symbol>@bookTicker stream
+type bookTicker struct {
+	UpdateId int64  `json:"u"`
+	Symbol   string `json:"s"`
+	BidPrice string `json:"b"`
+	BidQty   string `json:"B"`
+	AskPrice string `json:"a"`
+	AskQty   string `json:"A"`
+}
+
+// partialDepth is the payload of the <symbol>@depth<levels> stream, the
+// best levels of each side as [price, quantity] pairs
+type partialDepth struct {
+	LastUpdateId int64       `json:"lastUpdateId"`
+	Bids         [][2]string `json:"bids"`
+	Asks         [][2]string `json:"asks"`
+}
+
+func (b *BinanceMarketData) SampleConfig() string {
+	return `
+## Combined stream endpoint of the Binance websocket api, e.g.
+## "wss://stream.binance.us:9443/stream" for Binance.US
+# service_address = "wss://stream.binance.com:9443/stream"
+## Symbols to subscribe to
+symbols = ["BTCUSDT", "ETHUSDT"]
+## Streams of every symbol: "trade" for every trade, "bookTicker" for every
+## change of the best bid and ask, and "depth" for the best depth_levels
+## levels of the order book every second
+streams = ["trade", "bookTicker"]
+## Levels of the depth stream, one of 5, 10 or 20
+# depth_levels = 10
+## Emit the trades, tickers and books in the "native" schema of the plugin,
+## or the "normalized" schema shared by the exchange inputs
+# metric_schema = "native"
+## Ping the server every ping_interval and reconnect when neither a pong nor
+## a message was received for pong_wait. "0s" disables the keepalive.
+# ping_interval = "10s"
+# pong_wait = "20s"
+## Delay before reconnecting after the connection dropped, doubled after
+## every failed attempt up to max_backoff
+# reconnect_interval = "1s"
+# max_backoff = "1m"
+## Give up reconnecting after this many consecutive failed attempts, 0 retries
+## forever
+# max_reconnect_attempts = 0
+
+## Optional TLS Config
+# tls_ca = "/etc/telegraf/ca.pem"
+# tls_cert = "/etc/telegraf/cert.pem"
+# tls_key = "/etc/telegraf/key.pem"
+## Use TLS but skip chain & host verification
+# insecure_skip_verify = false
+`
+}
+
+func (b *BinanceMarketData) Description() string {
+	return "Receives the trades and order books of Binance symbols from its websocket api"
+}
+
+func (b *BinanceMarketData) Gather(_ telegraf.Accumulator) error {
+	return nil
+}
+
+func (b *BinanceMarketData) Init() error {
+	if len(b.Symbols) == 0 {
+		return fmt.Errorf("symbols must be set")
+	}
+	if len(b.Streams) == 0 {
+		return fmt.Errorf("streams must be set")
+	}
+	for _, stream := range b.Streams {
+		switch stream {
+		case streamTrade, streamBookTicker, streamDepth:
+		default:
+			return fmt.Errorf("invalid stream %q, must be %q, %q or %q", stream, streamTrade, streamBookTicker, streamDepth)
+		}
+	}
+	if !depthLevels[b.DepthLevels] {
+		return fmt.Errorf("invalid depth_levels %d, must be 5, 10 or 20", b.DepthLevels)
+	}
+
+	normalizer, err := marketdata.NewNormalizer(b.MetricSchema, marketdata.Normalizer{
+		Exchange: "binance",
+		Measurements: map[string]string{
+			"binance_trade":       marketdata.Trade,
+			"binance_book_ticker": marketdata.Ticker,
+			"binance_book":        marketdata.Book,
+		},
+		SymbolTag: "symbol",
+	}, b.Symbols)
+	if err != nil {
+		return err
+	}
+	b.normalizer = normalizer
+
+	tlsCfg, err := b.ClientConfig.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	cfg := wsclient.Config{
+		URL: b.ServiceAddress,
+		Dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: wsclient.HandshakeTimeout,
+			TLSClientConfig:  tlsCfg,
+		},
+		PingInterval:         b.PingInterval.Duration,
+		PongWait:             b.PongWait.Duration,
+		ReconnectInterval:    b.ReconnectInterval.Duration,
+		MaxBackoff:           b.MaxBackoff.Duration,
+		MaxReconnectAttempts: b.MaxReconnectAttempts,
+		StatsName:            "binance_marketdata",
+		StatsTags:            map[string]string{"address": b.ServiceAddress},
+		Log:                  b.Log,
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	b.client = wsclient.New(cfg, b)
+
+	return nil
+}
+
+func (b *BinanceMarketData) Start(acc telegraf.Accumulator) error {
+	b.acc = b.normalizer.Accumulator(acc)
+	return b.client.Start(b.acc)
+}
+
+func (b *BinanceMarketData) Stop() {
+	b.client.Stop()
+}
+
+// streamNames returns the names of the streams subscribed to, e.g.
+// btcusdt@trade, in the lower case Binance expects
+func (b *BinanceMarketData) streamNames() []string {
+	var names []string
+	for _, symbol := range b.Symbols {
+		for _, stream := range b.Streams {
+			if stream == streamDepth {
+				stream = streamDepth + strconv.Itoa(b.DepthLevels)
+			}
+			names = append(names, strings.ToLower(symbol)+"@"+stream)
+		}
+	}
+	return names
+}
+
+// Subscribe subscribes to the streams of every symbol on every connection
+func (b *BinanceMarketData) Subscribe(conn *websocket.Conn) error {
+	msg, err := json.Marshal(map[string]interface{}{
+		"method": "SUBSCRIBE",
+		"params": b.streamNames(),
+		"id":     1,
+	})
+	if err != nil {
+		return err
+	}
+	b.Log.Debugf("Subscription Request: %s", msg)
+	return conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+// Handle emits the metrics of a message of the combined stream
+func (b *BinanceMarketData) Handle(message []byte) {
+	b.Log.Debugf("recv: %s", message)
+
+	var msg combinedMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		b.acc.AddError(fmt.Errorf("unable to parse incoming msg: %s", err))
+		return
+	}
+
+	if msg.Error != nil {
+		b.acc.AddError(fmt.Errorf("server error %d: %s", msg.Error.Code, msg.Error.Msg))
+		return
+	}
+	if msg.Stream == "" {
+		// the answer to the subscription
+		return
+	}
+
+	parts := strings.SplitN(msg.Stream, "@", 2)
+	if len(parts) != 2 {
+		b.acc.AddError(fmt.Errorf("unexpected stream %q", msg.Stream))
+		return
+	}
+	symbol, stream := strings.ToUpper(parts[0]), parts[1]
+
+	var err error
+	switch {
+	case stream == streamTrade:
+		err = b.addTrade(msg.Data)
+	case stream == streamBookTicker:
+		err = b.addBookTicker(msg.Data)
+	case strings.HasPrefix(stream, streamDepth):
+		err = b.addDepth(symbol, strings.TrimPrefix(stream, streamDepth), msg.Data)
+	}
+	if err != nil {
+		b.acc.AddError(fmt.Errorf("unable to parse %s message: %s", msg.Stream, err))
+	}
+}
+
+func (b *BinanceMarketData) addTrade(data []byte) error {
+	var t trade
+	if err := json.Unmarshal(data, &t); err != nil {
+		return err
+	}
+	price, err := strconv.ParseFloat(t.Price, 64)
+	if err != nil {
+		return err
+	}
+	size, err := strconv.ParseFloat(t.Quantity, 64)
+	if err != nil {
+		return err
+	}
+
+	// the side of the taker, sell when the buyer placed the resting order
+	side := "buy"
+	if t.BuyerIsMaker {
+		side = "sell"
+	}
+
+	b.acc.AddFields("binance_trade",
+		map[string]interface{}{
+			"price":    price,
+			"size":     size,
+			"trade_id": t.TradeId,
+		},
+		map[string]string{
+			"symbol": t.Symbol,
+			"side":   side,
+		},
+		time.Unix(0, t.TradeTime*int64(time.Millisecond)),
+	)
+	return nil
+}
+
+func (b *BinanceMarketData) addBookTicker(data []byte) error {
+	var t bookTicker
+	if err := json.Unmarshal(data, &t); err != nil {
+		return err
+	}
+
+	fields := map[string]interface{}{
+		"update_id": t.UpdateId,
+	}
+	for name, value := range map[string]string{
+		"best_bid":      t.BidPrice,
+		"best_bid_size": t.BidQty,
+		"best_ask":      t.AskPrice,
+		"best_ask_size": t.AskQty,
+	} {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		fields[name] = f
+	}
+
+	// the stream carries no event time
+	b.acc.AddFields("binance_book_ticker", fields, map[string]string{"symbol": t.Symbol}, b.now())
+	return nil
+}
+
+func (b *BinanceMarketData) addDepth(symbol string, levels string, data []byte) error {
+	var d partialDepth
+	if err := json.Unmarshal(data, &d); err != nil {
+		return err
+	}
+
+	bids, err := parseLevels(d.Bids)
+	if err != nil {
+		return err
+	}
+	asks, err := parseLevels(d.Asks)
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]interface{}{
+		"last_update_id": d.LastUpdateId,
+		"bid_depth":      totalSize(bids),
+		"ask_depth":      totalSize(asks),
+	}
+	if len(bids) > 0 {
+		fields["best_bid"] = bids[0][0]
+		fields["best_bid_size"] = bids[0][1]
+	}
+	if len(asks) > 0 {
+		fields["best_ask"] = asks[0][0]
+		fields["best_ask_size"] = asks[0][1]
+	}
+	if len(bids) > 0 && len(asks) > 0 {
+		fields["spread"] = asks[0][0] - bids[0][0]
+		fields["mid_price"] = (asks[0][0] + bids[0][0]) / 2
+	}
+
+	b.acc.AddFields("binance_book", fields,
+		map[string]string{
+			"symbol": symbol,
+			"levels": levels,
+		},
+		b.now(),
+	)
+	return nil
+}
+
+// parseLevels converts the [price, quantity] pairs of a side of the book
+func parseLevels(levels [][2]string) ([][2]float64, error) {
+	parsed := make([][2]float64, 0, len(levels))
+	for _, level := range levels {
+		price, err := strconv.ParseFloat(level[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseFloat(level[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, [2]float64{price, size})
+	}
+	return parsed, nil
+}
+
+func totalSize(levels [][2]float64) float64 {
+	var total float64
+	for _, level := range levels {
+		total += level[1]
+	}
+	return total
+}
+
+func newBinanceMarketData() *BinanceMarketData {
+	return &BinanceMarketData{
+		ServiceAddress:    defaultServiceAddress,
+		MetricSchema:      marketdata.SchemaNative,
+		DepthLevels:       defaultDepthLevels,
+		PingInterval:      internal.Duration{Duration: wsclient.DefaultPingInterval},
+		PongWait:          internal.Duration{Duration: wsclient.DefaultPongWait},
+		ReconnectInterval: internal.Duration{Duration: wsclient.DefaultReconnectInterval},
+		MaxBackoff:        internal.Duration{Duration: wsclient.DefaultMaxBackoff},
+		now:               time.Now,
+	}
+}
+
+func init() {
+	inputs.Add("binance_marketdata", func() telegraf.Input { return newBinanceMarketData() })
+}
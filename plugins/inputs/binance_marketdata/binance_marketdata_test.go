@@ -0,0 +1,165 @@
+package binance_marketdata
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestPlugin(t *testing.T) (*BinanceMarketData, *testutil.Accumulator) {
+	b := newBinanceMarketData()
+	b.Log = testutil.Logger{}
+	b.Symbols = []string{"BNBBTC"}
+	b.Streams = []string{"trade", "bookTicker", "depth"}
+	b.now = func() time.Time { return now }
+	require.NoError(t, b.Init())
+
+	acc := &testutil.Accumulator{}
+	b.acc = acc
+	return b, acc
+}
+
+func TestTrade(t *testing.T) {
+	b, acc := newTestPlugin(t)
+
+	b.Handle([]byte(`{"stream": "bnbbtc@trade", "data": {"e": "trade", "E": 1614600000100, "s": "BNBBTC", "t": 12345, "p": "0.00100000", "q": "100.00000000", "T": 1614600000099, "m": true, "M": true}}`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("binance_trade",
+			map[string]string{"symbol": "BNBBTC", "side": "sell"},
+			map[string]interface{}{"price": 0.001, "size": 100.0, "trade_id": int64(12345)},
+			time.Unix(0, 1614600000099*int64(time.Millisecond)),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestBookTicker(t *testing.T) {
+	b, acc := newTestPlugin(t)
+
+	b.Handle([]byte(`{"stream": "bnbbtc@bookTicker", "data": {"u": 400900217, "s": "BNBBTC", "b": "25.35190000", "B": "31.21000000", "a": "25.36520000", "A": "40.66000000"}}`))
+
+	require.NoError(t, acc.FirstError())
+	acc.AssertContainsTaggedFields(t, "binance_book_ticker",
+		map[string]interface{}{
+			"update_id":     int64(400900217),
+			"best_bid":      25.3519,
+			"best_bid_size": 31.21,
+			"best_ask":      25.3652,
+			"best_ask_size": 40.66,
+		},
+		map[string]string{"symbol": "BNBBTC"},
+	)
+}
+
+func TestDepth(t *testing.T) {
+	b, acc := newTestPlugin(t)
+
+	b.Handle([]byte(`{"stream": "bnbbtc@depth10", "data": {"lastUpdateId": 160, "bids": [["0.0024", "10"], ["0.0023", "5"]], "asks": [["0.0026", "100"]]}}`))
+
+	require.NoError(t, acc.FirstError())
+	m, ok := acc.Get("binance_book")
+	require.True(t, ok)
+	require.Equal(t, map[string]string{"symbol": "BNBBTC", "levels": "10"}, m.Tags)
+	require.Equal(t, int64(160), m.Fields["last_update_id"])
+	require.Equal(t, 0.0024, m.Fields["best_bid"])
+	require.Equal(t, 10.0, m.Fields["best_bid_size"])
+	require.Equal(t, 0.0026, m.Fields["best_ask"])
+	require.Equal(t, 15.0, m.Fields["bid_depth"])
+	require.Equal(t, 100.0, m.Fields["ask_depth"])
+	require.InDelta(t, 0.0002, m.Fields["spread"], 1e-12)
+	require.InDelta(t, 0.0025, m.Fields["mid_price"], 1e-12)
+	require.Equal(t, now, m.Time)
+}
+
+func TestServerError(t *testing.T) {
+	b, acc := newTestPlugin(t)
+
+	b.Handle([]byte(`{"result": null, "id": 1}`))
+	require.NoError(t, acc.FirstError())
+	require.Zero(t, acc.NMetrics())
+
+	b.Handle([]byte(`{"error": {"code": 2, "msg": "Invalid request: unknown stream"}, "id": 1}`))
+	require.EqualError(t, acc.FirstError(), "server error 2: Invalid request: unknown stream")
+}
+
+func TestSubscribe(t *testing.T) {
+	subscriptions := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		subscriptions <- string(msg)
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"stream": "bnbbtc@trade", "data": {"s": "BNBBTC", "t": 1, "p": "0.001", "q": "1", "T": 1614600000099, "m": false}}`))
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer ts.Close()
+
+	b := newBinanceMarketData()
+	b.Log = testutil.Logger{}
+	b.ServiceAddress = "ws" + strings.TrimPrefix(ts.URL, "http")
+	b.Symbols = []string{"BNBBTC", "ETHUSDT"}
+	b.Streams = []string{"trade", "depth"}
+	b.DepthLevels = 5
+	require.NoError(t, b.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, b.Start(acc))
+	acc.Wait(1)
+	b.Stop()
+
+	require.JSONEq(t, `{"method": "SUBSCRIBE", "params": ["bnbbtc@trade", "bnbbtc@depth5", "ethusdt@trade", "ethusdt@depth5"], "id": 1}`, <-subscriptions)
+	acc.AssertContainsTaggedFields(t, "binance_trade",
+		map[string]interface{}{"price": 0.001, "size": 1.0, "trade_id": int64(1)},
+		map[string]string{"symbol": "BNBBTC", "side": "buy"},
+	)
+}
+
+func TestNormalizedSchema(t *testing.T) {
+	b, acc := newTestPlugin(t)
+	b.MetricSchema = marketdata.SchemaNormalized
+	require.NoError(t, b.Init())
+	b.acc = b.normalizer.Accumulator(acc)
+
+	b.Handle([]byte(`{"stream": "bnbbtc@trade", "data": {"e": "trade", "E": 1614600000100, "s": "BNBBTC", "t": 12345, "p": "0.00100000", "q": "100.00000000", "T": 1614600000099, "m": true, "M": true}}`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("market_trade",
+			map[string]string{"exchange": "binance", "base": "BNB", "quote": "BTC", "side": "sell"},
+			map[string]interface{}{"price": 0.001, "size": 100.0},
+			time.Unix(0, 1614600000099*int64(time.Millisecond)),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestInit(t *testing.T) {
+	b := newBinanceMarketData()
+	b.Streams = []string{"trade"}
+	require.Error(t, b.Init())
+
+	b.Symbols = []string{"BTCUSDT"}
+	b.Streams = []string{"kline"}
+	require.Error(t, b.Init())
+
+	b.Streams = []string{"depth"}
+	b.DepthLevels = 15
+	require.Error(t, b.Init())
+}
@@ -0,0 +1,535 @@
+package bitfinex_marketdata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/wsclient"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/plugins/common/tls"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const (
+	defaultServiceAddress = "wss://api-pub.bitfinex.com/ws/2"
+	defaultBookLength     = 25
+
+	channelTicker = "ticker"
+	channelTrades = "trades"
+	channelBook   = "book"
+
+	// the codes of the info events asking to reconnect, and announcing the
+	// end of a maintenance after which the channels must be subscribed anew
+	infoReconnect      = 20051
+	infoMaintenanceEnd = 20061
+)
+
+// the lengths the book channel can be subscribed with
+var bookLengths = map[int]bool{1: true, 25: true, 100: true, 250: true}
+
+type BitfinexMarketData struct {
+	ServiceAddress string   `toml:"service_address"`
+	Symbols        []string `toml:"symbols"`
+	Channels       []string `toml:"channels"`
+	BookLength     int      `toml:"book_length"`
+	MetricSchema   string   `toml:"metric_schema"`
+
+	PingInterval         internal.Duration `toml:"ping_interval"`
+	PongWait             internal.Duration `toml:"pong_wait"`
+	ReconnectInterval    internal.Duration `toml:"reconnect_interval"`
+	MaxBackoff           internal.Duration `toml:"max_backoff"`
+	MaxReconnectAttempts int               `toml:"max_reconnect_attempts"`
+
+	tls.ClientConfig
+
+	Log telegraf.Logger `toml:"-"`
+
+	acc        telegraf.Accumulator
+	client     *wsclient.Client
+	normalizer *marketdata.Normalizer
+	now        func() time.Time
+
+	// the channel and symbol of every channel id of the connection, only
+	// accessed by Subscribe and Handle, which don't run concurrently
+	subscriptions map[int64]subscription
+
+	// the order book of every symbol, sides keyed by price
+	books     map[string]*book
+	booksLock sync.Mutex
+}
+
+type subscription struct {
+	channel string
+	symbol  string
+}
+
+type book struct {
+	bids map[float64]float64
+	asks map[float64]float64
+}
+
+// event is a message of the connection rather than of a channel
+type event struct {
+	Event   string `json:"event"`
+	Channel string `json:"channel"`
+	ChanId  int64  `json:"chanId"`
+	Symbol  string `json:"symbol"`
+	Code    int    `json:"code"`
+	Msg     string `json:"msg"`
+}
+
+func (b *BitfinexMarketData) SampleConfig() string {
+	return `
+## Bitfinex websocket api v2
+# service_address = "wss://api-pub.bitfinex.com/ws/2"
+## Trading pairs to subscribe to
+symbols = ["tBTCUSD", "tETHUSD"]
+## Channels of every symbol, any of "ticker", "trades" and "book"
+channels = ["ticker", "trades", "book"]
+## Price levels of each side of the book, one of 1, 25, 100 or 250
+# book_length = 25
+## Emit the trades, tickers and books in the "native" schema of the plugin,
+## or the "normalized" schema shared by the exchange inputs
+# metric_schema = "native"
+## Ping the server every ping_interval and reconnect when neither a pong nor
+## a message was received for pong_wait. "0s" disables the keepalive.
+# ping_interval = "10s"
+# pong_wait = "20s"
+## Delay before reconnecting after the connection dropped, doubled after
+## every failed attempt up to max_backoff
+# reconnect_interval = "1s"
+# max_backoff = "1m"
+## Give up reconnecting after this many consecutive failed attempts, 0 retries
+## forever
+# max_reconnect_attempts = 0
+
+## Optional TLS Config
+# tls_ca = "/etc/telegraf/ca.pem"
+# tls_cert = "/etc/telegraf/cert.pem"
+# tls_key = "/etc/telegraf/key.pem"
+## Use TLS but skip chain & host verification
+# insecure_skip_verify = false
+`
+}
+
+func (b *BitfinexMarketData) Description() string {
+	return "Receives the tickers, trades and order books of Bitfinex trading pairs from its websocket api v2"
+}
+
+// Gather emits the top of the order book of every symbol
+func (b *BitfinexMarketData) Gather(acc telegraf.Accumulator) error {
+	acc = b.normalizer.Accumulator(acc)
+
+	b.booksLock.Lock()
+	defer b.booksLock.Unlock()
+
+	now := b.now()
+	for symbol, bk := range b.books {
+		acc.AddFields("bitfinex_book", bk.fields(), map[string]string{"symbol": symbol}, now)
+	}
+	return nil
+}
+
+func (b *BitfinexMarketData) Init() error {
+	if len(b.Symbols) == 0 {
+		return fmt.Errorf("symbols must be set")
+	}
+	for _, symbol := range b.Symbols {
+		if !strings.HasPrefix(symbol, "t") {
+			return fmt.Errorf("invalid symbol %q, must be a trading pair such as \"tBTCUSD\"", symbol)
+		}
+	}
+	if len(b.Channels) == 0 {
+		return fmt.Errorf("channels must be set")
+	}
+	for _, channel := range b.Channels {
+		switch channel {
+		case channelTicker, channelTrades, channelBook:
+		default:
+			return fmt.Errorf("invalid channel %q, must be %q, %q or %q", channel, channelTicker, channelTrades, channelBook)
+		}
+	}
+	if !bookLengths[b.BookLength] {
+		return fmt.Errorf("invalid book_length %d, must be 1, 25, 100 or 250", b.BookLength)
+	}
+
+	normalizer, err := marketdata.NewNormalizer(b.MetricSchema, marketdata.Normalizer{
+		Exchange: "bitfinex",
+		Measurements: map[string]string{
+			"bitfinex_ticker": marketdata.Ticker,
+			"bitfinex_trade":  marketdata.Trade,
+			"bitfinex_book":   marketdata.Book,
+		},
+		SymbolTag: "symbol",
+		ParsePair: parsePair,
+	}, b.Symbols)
+	if err != nil {
+		return err
+	}
+	b.normalizer = normalizer
+
+	tlsCfg, err := b.ClientConfig.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	cfg := wsclient.Config{
+		URL: b.ServiceAddress,
+		Dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: wsclient.HandshakeTimeout,
+			TLSClientConfig:  tlsCfg,
+		},
+		PingInterval:         b.PingInterval.Duration,
+		PongWait:             b.PongWait.Duration,
+		ReconnectInterval:    b.ReconnectInterval.Duration,
+		MaxBackoff:           b.MaxBackoff.Duration,
+		MaxReconnectAttempts: b.MaxReconnectAttempts,
+		StatsName:            "bitfinex_marketdata",
+		StatsTags:            map[string]string{"address": b.ServiceAddress},
+		Log:                  b.Log,
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	b.client = wsclient.New(cfg, b)
+
+	return nil
+}
+
+func (b *BitfinexMarketData) Start(acc telegraf.Accumulator) error {
+	b.acc = b.normalizer.Accumulator(acc)
+	return b.client.Start(b.acc)
+}
+
+func (b *BitfinexMarketData) Stop() {
+	b.client.Stop()
+}
+
+// Subscribe subscribes to every channel of every symbol, a message each.
+// The channel ids are assigned anew on every connection, and the books start
+// over from the snapshot sent upon subscribing.
+func (b *BitfinexMarketData) Subscribe(conn *websocket.Conn) error {
+	b.subscriptions = make(map[int64]subscription)
+	b.booksLock.Lock()
+	b.books = make(map[string]*book)
+	b.booksLock.Unlock()
+
+	for _, symbol := range b.Symbols {
+		for _, channel := range b.Channels {
+			req := map[string]interface{}{
+				"event":   "subscribe",
+				"channel": channel,
+				"symbol":  symbol,
+			}
+			if channel == channelBook {
+				req["prec"] = "P0"
+				req["freq"] = "F0"
+				req["len"] = strconv.Itoa(b.BookLength)
+			}
+
+			msg, err := json.Marshal(req)
+			if err != nil {
+				return err
+			}
+			b.Log.Debugf("Subscription Request: %s", msg)
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Handle handles an event, or emits the metrics of the channel data framed
+// as [chanId, payload] or [chanId, type, payload]
+func (b *BitfinexMarketData) Handle(data []byte) {
+	b.Log.Debugf("recv: %s", data)
+
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		b.handleEvent(data)
+		return
+	}
+
+	var frame []json.RawMessage
+	if err := json.Unmarshal(data, &frame); err != nil || len(frame) < 2 {
+		b.acc.AddError(fmt.Errorf("unable to parse incoming msg: %s", data))
+		return
+	}
+	var chanId int64
+	if err := json.Unmarshal(frame[0], &chanId); err != nil {
+		b.acc.AddError(fmt.Errorf("unable to parse channel id: %s", err))
+		return
+	}
+	sub, ok := b.subscriptions[chanId]
+	if !ok {
+		return
+	}
+
+	// the type of a frame is a string, "hb" for the heartbeats of a channel
+	var msgType string
+	payload := frame[1]
+	if json.Unmarshal(frame[1], &msgType) == nil {
+		if len(frame) < 3 {
+			return
+		}
+		payload = frame[2]
+	}
+
+	var err error
+	switch sub.channel {
+	case channelTicker:
+		err = b.addTicker(sub.symbol, payload)
+	case channelTrades:
+		// "tu" repeats the "te" of a trade along with its database id
+		if msgType == "te" {
+			err = b.addTrade(sub.symbol, payload)
+		}
+	case channelBook:
+		if msgType == "" {
+			err = b.updateBook(sub.symbol, payload)
+		}
+	}
+	if err != nil {
+		b.acc.AddError(fmt.Errorf("unable to parse %s message of %s: %s", sub.channel, sub.symbol, err))
+	}
+}
+
+func (b *BitfinexMarketData) handleEvent(data []byte) {
+	var e event
+	if err := json.Unmarshal(data, &e); err != nil {
+		b.acc.AddError(fmt.Errorf("unable to parse incoming msg: %s", err))
+		return
+	}
+
+	switch e.Event {
+	case "subscribed":
+		b.subscriptions[e.ChanId] = subscription{channel: e.Channel, symbol: e.Symbol}
+	case "error":
+		b.acc.AddError(fmt.Errorf("server error %d: %s", e.Code, e.Msg))
+	case "info":
+		if e.Code == infoReconnect || e.Code == infoMaintenanceEnd {
+			// reconnecting subscribes anew
+			b.Log.Infof("Reconnecting upon info event %d: %s", e.Code, e.Msg)
+			if conn := b.client.Conn(); conn != nil {
+				conn.Close()
+			}
+		}
+	}
+}
+
+// addTicker emits a ticker in the format of [BID, BID_SIZE, ASK, ASK_SIZE,
+// DAILY_CHANGE, DAILY_CHANGE_RELATIVE, LAST_PRICE, VOLUME, HIGH, LOW]
+func (b *BitfinexMarketData) addTicker(symbol string, payload []byte) error {
+	var t []float64
+	if err := json.Unmarshal(payload, &t); err != nil {
+		return err
+	}
+	if len(t) < 10 {
+		return fmt.Errorf("ticker of %d values, expected 10", len(t))
+	}
+
+	b.acc.AddFields("bitfinex_ticker",
+		map[string]interface{}{
+			"best_bid":            t[0],
+			"best_bid_size":       t[1],
+			"best_ask":            t[2],
+			"best_ask_size":       t[3],
+			"change_24h":          t[4],
+			"change_24h_relative": t[5],
+			"price":               t[6],
+			"volume_24h":          t[7],
+			"high_24h":            t[8],
+			"low_24h":             t[9],
+		},
+		map[string]string{"symbol": symbol},
+		b.now(),
+	)
+	return nil
+}
+
+// addTrade emits a trade in the format of [ID, MTS, AMOUNT, PRICE], the
+// amount being negative for a sell. The trades of the snapshot sent upon
+// subscribing were already emitted before reconnecting, or precede the
+// start of the plugin, and are skipped.
+func (b *BitfinexMarketData) addTrade(symbol string, payload []byte) error {
+	var t []json.Number
+	if err := json.Unmarshal(payload, &t); err != nil {
+		return err
+	}
+	if len(t) < 4 {
+		return fmt.Errorf("trade of %d values, expected 4", len(t))
+	}
+
+	id, err := t[0].Int64()
+	if err != nil {
+		return err
+	}
+	mts, err := t[1].Int64()
+	if err != nil {
+		return err
+	}
+	amount, err := t[2].Float64()
+	if err != nil {
+		return err
+	}
+	price, err := t[3].Float64()
+	if err != nil {
+		return err
+	}
+
+	side := "buy"
+	if amount < 0 {
+		side, amount = "sell", -amount
+	}
+	b.acc.AddFields("bitfinex_trade",
+		map[string]interface{}{
+			"price":    price,
+			"size":     amount,
+			"trade_id": id,
+		},
+		map[string]string{
+			"symbol": symbol,
+			"side":   side,
+		},
+		time.Unix(0, mts*int64(time.Millisecond)),
+	)
+	return nil
+}
+
+// updateBook replaces the book of symbol with a snapshot, a list of levels,
+// or applies an update, a single level. A level is in the format of
+// [PRICE, COUNT, AMOUNT], the amount being positive for a bid and negative
+// for an ask, and a count of 0 removes the level.
+func (b *BitfinexMarketData) updateBook(symbol string, payload []byte) error {
+	var values []json.RawMessage
+	if err := json.Unmarshal(payload, &values); err != nil {
+		return err
+	}
+	// a snapshot is a list of levels, possibly empty, an update a level
+	snapshot := len(values) == 0 || bytes.HasPrefix(values[0], []byte("["))
+
+	var levels [][]float64
+	if snapshot {
+		if err := json.Unmarshal(payload, &levels); err != nil {
+			return err
+		}
+	} else {
+		var level []float64
+		if err := json.Unmarshal(payload, &level); err != nil {
+			return err
+		}
+		levels = [][]float64{level}
+	}
+
+	b.booksLock.Lock()
+	defer b.booksLock.Unlock()
+
+	bk, ok := b.books[symbol]
+	if snapshot {
+		bk = &book{bids: make(map[float64]float64), asks: make(map[float64]float64)}
+		b.books[symbol] = bk
+	} else if !ok {
+		// awaiting the snapshot
+		return nil
+	}
+
+	for _, l := range levels {
+		if len(l) < 3 {
+			return fmt.Errorf("level of %d values, expected 3", len(l))
+		}
+		price, count, amount := l[0], l[1], l[2]
+
+		side := bk.bids
+		if amount < 0 {
+			side, amount = bk.asks, -amount
+		}
+		if count == 0 {
+			delete(side, price)
+		} else {
+			side[price] = amount
+		}
+	}
+	return nil
+}
+
+// fields returns the top of the book and the total size of each side
+func (bk *book) fields() map[string]interface{} {
+	bids, asks := sortedPrices(bk.bids, true), sortedPrices(bk.asks, false)
+
+	fields := map[string]interface{}{
+		"bid_depth": totalSize(bk.bids),
+		"ask_depth": totalSize(bk.asks),
+	}
+	if len(bids) > 0 {
+		fields["best_bid"] = bids[0]
+		fields["best_bid_size"] = bk.bids[bids[0]]
+	}
+	if len(asks) > 0 {
+		fields["best_ask"] = asks[0]
+		fields["best_ask_size"] = bk.asks[asks[0]]
+	}
+	if len(bids) > 0 && len(asks) > 0 {
+		fields["spread"] = asks[0] - bids[0]
+		fields["mid_price"] = (asks[0] + bids[0]) / 2
+	}
+	return fields
+}
+
+// sortedPrices returns the prices of a side from the best, the highest bid
+// or the lowest ask
+func sortedPrices(side map[float64]float64, descending bool) []float64 {
+	prices := make([]float64, 0, len(side))
+	for price := range side {
+		prices = append(prices, price)
+	}
+	if descending {
+		sort.Sort(sort.Reverse(sort.Float64Slice(prices)))
+	} else {
+		sort.Float64s(prices)
+	}
+	return prices
+}
+
+func totalSize(side map[float64]float64) float64 {
+	var total float64
+	for _, size := range side {
+		total += size
+	}
+	return total
+}
+
+// parsePair splits a trading pair such as tBTCUSD, or tTESTBTC:TESTUSD for
+// currencies of more than three letters
+func parsePair(symbol string) (marketdata.Pair, error) {
+	return marketdata.ParsePair(strings.TrimPrefix(symbol, "t"))
+}
+
+func newBitfinexMarketData() *BitfinexMarketData {
+	return &BitfinexMarketData{
+		ServiceAddress:    defaultServiceAddress,
+		MetricSchema:      marketdata.SchemaNative,
+		BookLength:        defaultBookLength,
+		PingInterval:      internal.Duration{Duration: wsclient.DefaultPingInterval},
+		PongWait:          internal.Duration{Duration: wsclient.DefaultPongWait},
+		ReconnectInterval: internal.Duration{Duration: wsclient.DefaultReconnectInterval},
+		MaxBackoff:        internal.Duration{Duration: wsclient.DefaultMaxBackoff},
+		now:               time.Now,
+		subscriptions:     make(map[int64]subscription),
+		books:             make(map[string]*book),
+	}
+}
+
+func init() {
+	inputs.Add("bitfinex_marketdata", func() telegraf.Input { return newBitfinexMarketData() })
+}
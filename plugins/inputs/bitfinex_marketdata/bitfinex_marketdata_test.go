@@ -0,0 +1,231 @@
+package bitfinex_marketdata
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+const (
+	subscribedTicker = `{"event": "subscribed", "channel": "ticker", "chanId": 224555, "symbol": "tBTCUSD", "pair": "BTCUSD"}`
+	subscribedTrades = `{"event": "subscribed", "channel": "trades", "chanId": 17470, "symbol": "tBTCUSD", "pair": "BTCUSD"}`
+	subscribedBook   = `{"event": "subscribed", "channel": "book", "chanId": 10961, "symbol": "tBTCUSD", "prec": "P0", "freq": "F0", "len": "25", "pair": "BTCUSD"}`
+)
+
+func newTestPlugin(t *testing.T) (*BitfinexMarketData, *testutil.Accumulator) {
+	b := newBitfinexMarketData()
+	b.Log = testutil.Logger{}
+	b.Symbols = []string{"tBTCUSD"}
+	b.Channels = []string{"ticker", "trades", "book"}
+	b.now = func() time.Time { return now }
+	require.NoError(t, b.Init())
+
+	acc := &testutil.Accumulator{}
+	b.acc = acc
+	for _, msg := range []string{subscribedTicker, subscribedTrades, subscribedBook} {
+		b.Handle([]byte(msg))
+	}
+	return b, acc
+}
+
+func TestTicker(t *testing.T) {
+	b, acc := newTestPlugin(t)
+
+	b.Handle([]byte(`[224555, [7616.5, 31.89055171, 7617.5, 43.358118629999986, -550.8, -0.0674, 7617.1, 8314.71200815, 8257.8, 7500]]`))
+	b.Handle([]byte(`[224555, "hb"]`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("bitfinex_ticker",
+			map[string]string{"symbol": "tBTCUSD"},
+			map[string]interface{}{
+				"best_bid":            7616.5,
+				"best_bid_size":       31.89055171,
+				"best_ask":            7617.5,
+				"best_ask_size":       43.358118629999986,
+				"change_24h":          -550.8,
+				"change_24h_relative": -0.0674,
+				"price":               7617.1,
+				"volume_24h":          8314.71200815,
+				"high_24h":            8257.8,
+				"low_24h":             7500.0,
+			},
+			now,
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestTrades(t *testing.T) {
+	b, acc := newTestPlugin(t)
+
+	// the snapshot and the "tu" repeating a trade are skipped
+	b.Handle([]byte(`[17470, [[401597393, 1574694475039, 0.005, 7244.9], [401597392, 1574694474912, -0.01, 7244.8]]]`))
+	b.Handle([]byte(`[17470, "te", [401597395, 1574694478808, 0.005, 7245.3]]`))
+	b.Handle([]byte(`[17470, "tu", [401597395, 1574694478808, 0.005, 7245.3]]`))
+	b.Handle([]byte(`[17470, "te", [401597396, 1574694478809, -0.2, 7245.2]]`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("bitfinex_trade",
+			map[string]string{"symbol": "tBTCUSD", "side": "buy"},
+			map[string]interface{}{"price": 7245.3, "size": 0.005, "trade_id": int64(401597395)},
+			time.Unix(0, 1574694478808*int64(time.Millisecond)),
+		),
+		testutil.MustMetric("bitfinex_trade",
+			map[string]string{"symbol": "tBTCUSD", "side": "sell"},
+			map[string]interface{}{"price": 7245.2, "size": 0.2, "trade_id": int64(401597396)},
+			time.Unix(0, 1574694478809*int64(time.Millisecond)),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestBook(t *testing.T) {
+	b, acc := newTestPlugin(t)
+
+	// updates before the snapshot are ignored
+	b.Handle([]byte(`[10961, [7254.6, 1, 3]]`))
+	b.Handle([]byte(`[10961, [[7254.7, 1, 0.5], [7254.5, 2, 1.25], [7255.1, 1, -0.5], [7255.4, 3, -2]]]`))
+	b.Handle([]byte(`[10961, [7254.7, 0, 1]]`))
+	b.Handle([]byte(`[10961, [7255.0, 1, -0.25]]`))
+	b.Handle([]byte(`[10961, "hb"]`))
+	require.NoError(t, acc.FirstError())
+
+	require.NoError(t, b.Gather(acc))
+	bid, ask := 7254.5, 7255.0
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("bitfinex_book",
+			map[string]string{"symbol": "tBTCUSD"},
+			map[string]interface{}{
+				"best_bid":      7254.5,
+				"best_bid_size": 1.25,
+				"best_ask":      7255.0,
+				"best_ask_size": 0.25,
+				"spread":        ask - bid,
+				"mid_price":     (ask + bid) / 2,
+				"bid_depth":     1.25,
+				"ask_depth":     2.75,
+			},
+			now,
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestUnknownChannelIdIgnored(t *testing.T) {
+	b, acc := newTestPlugin(t)
+
+	b.Handle([]byte(`[1, [7616.5, 31.89055171, 7617.5, 43.35, -550.8, -0.0674, 7617.1, 8314.7, 8257.8, 7500]]`))
+	require.NoError(t, acc.FirstError())
+	require.Empty(t, acc.GetTelegrafMetrics())
+}
+
+func TestServerError(t *testing.T) {
+	b, acc := newTestPlugin(t)
+
+	b.Handle([]byte(`{"event": "info", "version": 2, "serverId": "1e3e8a3b-5a59-4a1c-8e6b-3a5f4b3a7e1c", "platform": {"status": 1}}`))
+	require.NoError(t, acc.FirstError())
+
+	b.Handle([]byte(`{"event": "error", "msg": "symbol: invalid", "code": 10300, "pair": "BTCUSDX"}`))
+	require.Error(t, acc.FirstError())
+	require.Contains(t, acc.FirstError().Error(), "server error 10300")
+}
+
+func TestSubscribesAndReconnectsUponInfo(t *testing.T) {
+	requests := make(chan map[string]interface{}, 10)
+	connections := make(chan struct{}, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		select {
+		case connections <- struct{}{}:
+		default:
+		}
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var req map[string]interface{}
+			if json.Unmarshal(msg, &req) != nil {
+				return
+			}
+			select {
+			case requests <- req:
+			default:
+			}
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(subscribedBook))
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"event": "info", "code": 20051, "msg": "Stopping. Please try to reconnect"}`))
+		}
+	}))
+	defer ts.Close()
+
+	b := newBitfinexMarketData()
+	b.Log = testutil.Logger{}
+	b.ServiceAddress = "ws" + strings.TrimPrefix(ts.URL, "http")
+	b.Symbols = []string{"tBTCUSD"}
+	b.Channels = []string{"book"}
+	b.BookLength = 100
+	require.NoError(t, b.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, b.Start(acc))
+	defer b.Stop()
+
+	expected := map[string]interface{}{"event": "subscribe", "channel": "book", "symbol": "tBTCUSD", "prec": "P0", "freq": "F0", "len": "100"}
+	require.Equal(t, expected, <-requests)
+	<-connections
+	<-connections
+	require.Equal(t, expected, <-requests)
+}
+
+func TestNormalizedSchema(t *testing.T) {
+	b, acc := newTestPlugin(t)
+	b.MetricSchema = marketdata.SchemaNormalized
+	require.NoError(t, b.Init())
+	b.acc = b.normalizer.Accumulator(acc)
+
+	b.Handle([]byte(`[17470, "te", [401597395, 1574694478808, 0.005, 7245.3]]`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("market_trade",
+			map[string]string{"exchange": "bitfinex", "base": "BTC", "quote": "USD", "side": "buy"},
+			map[string]interface{}{"price": 7245.3, "size": 0.005},
+			time.Unix(0, 1574694478808*int64(time.Millisecond)),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestInit(t *testing.T) {
+	b := newBitfinexMarketData()
+	b.Channels = []string{"ticker"}
+	require.Error(t, b.Init())
+
+	b.Symbols = []string{"fUSD"}
+	require.Error(t, b.Init())
+
+	b.Symbols = []string{"tBTCUSD"}
+	b.Channels = []string{"candles"}
+	require.Error(t, b.Init())
+
+	b.Channels = []string{"book"}
+	b.BookLength = 50
+	require.Error(t, b.Init())
+
+	b.BookLength = 250
+	require.NoError(t, b.Init())
+}
@@ -0,0 +1,354 @@
+package bitstamp_marketdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/wsclient"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/plugins/common/tls"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const (
+	defaultServiceAddress = "wss://ws.bitstamp.net"
+
+	channelLiveTrades = "live_trades"
+	channelOrderBook  = "order_book"
+)
+
+type BitstampMarketData struct {
+	ServiceAddress string   `toml:"service_address"`
+	Pairs          []string `toml:"pairs"`
+	Channels       []string `toml:"channels"`
+	MetricSchema   string   `toml:"metric_schema"`
+
+	PingInterval         internal.Duration `toml:"ping_interval"`
+	PongWait             internal.Duration `toml:"pong_wait"`
+	ReconnectInterval    internal.Duration `toml:"reconnect_interval"`
+	MaxBackoff           internal.Duration `toml:"max_backoff"`
+	MaxReconnectAttempts int               `toml:"max_reconnect_attempts"`
+
+	tls.ClientConfig
+
+	Log telegraf.Logger `toml:"-"`
+
+	acc        telegraf.Accumulator
+	client     *wsclient.Client
+	normalizer *marketdata.Normalizer
+}
+
+// message is the envelope of every message of the feed, in the format of
+// the Pusher protocol
+type message struct {
+	Event   string          `json:"event"`
+	Channel string          `json:"channel"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// trade is the data of the trade event of the live_trades channel, its side
+// the type 0 for buy and 1 for sell
+type trade struct {
+	Id             int64  `json:"id"`
+	AmountStr      string `json:"amount_str"`
+	PriceStr       string `json:"price_str"`
+	Type           int    `json:"type"`
+	Microtimestamp string `json:"microtimestamp"`
+}
+
+// orderBook is the data of the order_book channel, the best 100 levels of
+// each side as [price, amount] pairs
+type orderBook struct {
+	Microtimestamp string      `json:"microtimestamp"`
+	Bids           [][2]string `json:"bids"`
+	Asks           [][2]string `json:"asks"`
+}
+
+func (b *BitstampMarketData) SampleConfig() string {
+	return `
+## Bitstamp websocket api
+# service_address = "wss://ws.bitstamp.net"
+## Currency pairs to subscribe to
+pairs = ["btcusd", "ethusd"]
+## Channels of every pair, "live_trades" for every trade and "order_book"
+## for the best 100 levels of each side of the order book on every change
+channels = ["live_trades", "order_book"]
+## Emit the trades, tickers and books in the "native" schema of the plugin,
+## or the "normalized" schema shared by the exchange inputs
+# metric_schema = "native"
+## Ping the server every ping_interval and reconnect when neither a pong nor
+## a message was received for pong_wait. "0s" disables the keepalive.
+# ping_interval = "10s"
+# pong_wait = "20s"
+## Delay before reconnecting after the connection dropped, doubled after
+## every failed attempt up to max_backoff
+# reconnect_interval = "1s"
+# max_backoff = "1m"
+## Give up reconnecting after this many consecutive failed attempts, 0 retries
+## forever
+# max_reconnect_attempts = 0
+
+## Optional TLS Config
+# tls_ca = "/etc/telegraf/ca.pem"
+# tls_cert = "/etc/telegraf/cert.pem"
+# tls_key = "/etc/telegraf/key.pem"
+## Use TLS but skip chain & host verification
+# insecure_skip_verify = false
+`
+}
+
+func (b *BitstampMarketData) Description() string {
+	return "Receives the trades and order books of Bitstamp pairs from its websocket api"
+}
+
+func (b *BitstampMarketData) Gather(_ telegraf.Accumulator) error {
+	return nil
+}
+
+func (b *BitstampMarketData) Init() error {
+	if len(b.Pairs) == 0 {
+		return fmt.Errorf("pairs must be set")
+	}
+	if len(b.Channels) == 0 {
+		return fmt.Errorf("channels must be set")
+	}
+	for _, channel := range b.Channels {
+		if channel != channelLiveTrades && channel != channelOrderBook {
+			return fmt.Errorf("invalid channel %q, must be %q or %q", channel, channelLiveTrades, channelOrderBook)
+		}
+	}
+
+	normalizer, err := marketdata.NewNormalizer(b.MetricSchema, marketdata.Normalizer{
+		Exchange: "bitstamp",
+		Measurements: map[string]string{
+			"bitstamp_trade": marketdata.Trade,
+			"bitstamp_book":  marketdata.Book,
+		},
+		SymbolTag: "pair",
+	}, b.Pairs)
+	if err != nil {
+		return err
+	}
+	b.normalizer = normalizer
+
+	tlsCfg, err := b.ClientConfig.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	cfg := wsclient.Config{
+		URL: b.ServiceAddress,
+		Dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: wsclient.HandshakeTimeout,
+			TLSClientConfig:  tlsCfg,
+		},
+		PingInterval:         b.PingInterval.Duration,
+		PongWait:             b.PongWait.Duration,
+		ReconnectInterval:    b.ReconnectInterval.Duration,
+		MaxBackoff:           b.MaxBackoff.Duration,
+		MaxReconnectAttempts: b.MaxReconnectAttempts,
+		StatsName:            "bitstamp_marketdata",
+		StatsTags:            map[string]string{"address": b.ServiceAddress},
+		Log:                  b.Log,
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	b.client = wsclient.New(cfg, b)
+
+	return nil
+}
+
+func (b *BitstampMarketData) Start(acc telegraf.Accumulator) error {
+	b.acc = b.normalizer.Accumulator(acc)
+	return b.client.Start(b.acc)
+}
+
+func (b *BitstampMarketData) Stop() {
+	b.client.Stop()
+}
+
+// Subscribe subscribes to every channel of the pairs on every connection,
+// a channel such as live_trades_btcusd per pair
+func (b *BitstampMarketData) Subscribe(conn *websocket.Conn) error {
+	for _, pair := range b.Pairs {
+		for _, channel := range b.Channels {
+			msg, err := json.Marshal(map[string]interface{}{
+				"event": "bts:subscribe",
+				"data":  map[string]string{"channel": channel + "_" + pair},
+			})
+			if err != nil {
+				return err
+			}
+			b.Log.Debugf("Subscription Request: %s", msg)
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Handle emits the metrics of a message of the feed
+func (b *BitstampMarketData) Handle(data []byte) {
+	b.Log.Debugf("recv: %s", data)
+
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		b.acc.AddError(fmt.Errorf("unable to parse incoming msg: %s", err))
+		return
+	}
+
+	var err error
+	switch {
+	case msg.Event == "bts:request_reconnect":
+		// the server is about to go away, reconnecting subscribes anew
+		b.Log.Info("Reconnect requested by the server")
+		if conn := b.client.Conn(); conn != nil {
+			conn.Close()
+		}
+	case msg.Event == "bts:error":
+		err = fmt.Errorf("server error: %s", msg.Data)
+	case msg.Event == "trade" && strings.HasPrefix(msg.Channel, channelLiveTrades+"_"):
+		err = b.addTrade(strings.TrimPrefix(msg.Channel, channelLiveTrades+"_"), msg.Data)
+	case msg.Event == "data" && strings.HasPrefix(msg.Channel, channelOrderBook+"_"):
+		err = b.addBook(strings.TrimPrefix(msg.Channel, channelOrderBook+"_"), msg.Data)
+	}
+	if err != nil {
+		b.acc.AddError(fmt.Errorf("%s: %s", msg.Channel, err))
+	}
+}
+
+func (b *BitstampMarketData) addTrade(pair string, data []byte) error {
+	var t trade
+	if err := json.Unmarshal(data, &t); err != nil {
+		return err
+	}
+	price, err := strconv.ParseFloat(t.PriceStr, 64)
+	if err != nil {
+		return err
+	}
+	size, err := strconv.ParseFloat(t.AmountStr, 64)
+	if err != nil {
+		return err
+	}
+	tm, err := parseMicroTimestamp(t.Microtimestamp)
+	if err != nil {
+		return err
+	}
+
+	side := "buy"
+	if t.Type == 1 {
+		side = "sell"
+	}
+
+	b.acc.AddFields("bitstamp_trade",
+		map[string]interface{}{
+			"price":    price,
+			"size":     size,
+			"trade_id": t.Id,
+		},
+		map[string]string{
+			"pair": pair,
+			"side": side,
+		},
+		tm,
+	)
+	return nil
+}
+
+func (b *BitstampMarketData) addBook(pair string, data []byte) error {
+	var book orderBook
+	if err := json.Unmarshal(data, &book); err != nil {
+		return err
+	}
+	bids, err := parseLevels(book.Bids)
+	if err != nil {
+		return err
+	}
+	asks, err := parseLevels(book.Asks)
+	if err != nil {
+		return err
+	}
+	tm, err := parseMicroTimestamp(book.Microtimestamp)
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]interface{}{
+		"bid_depth": totalSize(bids),
+		"ask_depth": totalSize(asks),
+	}
+	if len(bids) > 0 {
+		fields["best_bid"] = bids[0][0]
+		fields["best_bid_size"] = bids[0][1]
+	}
+	if len(asks) > 0 {
+		fields["best_ask"] = asks[0][0]
+		fields["best_ask_size"] = asks[0][1]
+	}
+	if len(bids) > 0 && len(asks) > 0 {
+		fields["spread"] = asks[0][0] - bids[0][0]
+		fields["mid_price"] = (asks[0][0] + bids[0][0]) / 2
+	}
+
+	b.acc.AddFields("bitstamp_book", fields, map[string]string{"pair": pair}, tm)
+	return nil
+}
+
+// parseMicroTimestamp converts the microseconds since the epoch of the feed
+func parseMicroTimestamp(s string) (time.Time, error) {
+	us, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid microtimestamp %q: %s", s, err)
+	}
+	return time.Unix(0, us*int64(time.Microsecond)), nil
+}
+
+// parseLevels converts the [price, amount] pairs of a side of the book
+func parseLevels(levels [][2]string) ([][2]float64, error) {
+	parsed := make([][2]float64, 0, len(levels))
+	for _, level := range levels {
+		price, err := strconv.ParseFloat(level[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseFloat(level[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, [2]float64{price, size})
+	}
+	return parsed, nil
+}
+
+func totalSize(levels [][2]float64) float64 {
+	var total float64
+	for _, level := range levels {
+		total += level[1]
+	}
+	return total
+}
+
+func newBitstampMarketData() *BitstampMarketData {
+	return &BitstampMarketData{
+		ServiceAddress:    defaultServiceAddress,
+		MetricSchema:      marketdata.SchemaNative,
+		PingInterval:      internal.Duration{Duration: wsclient.DefaultPingInterval},
+		PongWait:          internal.Duration{Duration: wsclient.DefaultPongWait},
+		ReconnectInterval: internal.Duration{Duration: wsclient.DefaultReconnectInterval},
+		MaxBackoff:        internal.Duration{Duration: wsclient.DefaultMaxBackoff},
+	}
+}
+
+func init() {
+	inputs.Add("bitstamp_marketdata", func() telegraf.Input { return newBitstampMarketData() })
+}
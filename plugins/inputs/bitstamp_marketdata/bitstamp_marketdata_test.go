@@ -0,0 +1,143 @@
+package bitstamp_marketdata
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPlugin(t *testing.T) (*BitstampMarketData, *testutil.Accumulator) {
+	b := newBitstampMarketData()
+	b.Log = testutil.Logger{}
+	b.Pairs = []string{"btcusd"}
+	b.Channels = []string{"live_trades", "order_book"}
+	require.NoError(t, b.Init())
+
+	acc := &testutil.Accumulator{}
+	b.acc = acc
+	return b, acc
+}
+
+func TestTrade(t *testing.T) {
+	b, acc := newTestPlugin(t)
+
+	b.Handle([]byte(`{"data": {"id": 159413370, "timestamp": "1614600000", "amount": 0.0075, "amount_str": "0.00750000", "price": 48123.45, "price_str": "48123.45", "type": 1, "microtimestamp": "1614600000123456", "buy_order_id": 1, "sell_order_id": 2}, "channel": "live_trades_btcusd", "event": "trade"}`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("bitstamp_trade",
+			map[string]string{"pair": "btcusd", "side": "sell"},
+			map[string]interface{}{"price": 48123.45, "size": 0.0075, "trade_id": int64(159413370)},
+			time.Unix(1614600000, 123456000),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestOrderBook(t *testing.T) {
+	b, acc := newTestPlugin(t)
+
+	b.Handle([]byte(`{"data": {"timestamp": "1614600000", "microtimestamp": "1614600000500000", "bids": [["48120.00", "0.50000000"], ["48119.50", "1.50000000"]], "asks": [["48121.00", "0.25000000"], ["48122.00", "2.00000000"]]}, "channel": "order_book_btcusd", "event": "data"}`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("bitstamp_book",
+			map[string]string{"pair": "btcusd"},
+			map[string]interface{}{
+				"best_bid":      48120.0,
+				"best_bid_size": 0.5,
+				"best_ask":      48121.0,
+				"best_ask_size": 0.25,
+				"spread":        1.0,
+				"mid_price":     48120.5,
+				"bid_depth":     2.0,
+				"ask_depth":     2.25,
+			},
+			time.Unix(1614600000, 500000000),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestServerError(t *testing.T) {
+	b, acc := newTestPlugin(t)
+
+	b.Handle([]byte(`{"event": "bts:subscription_succeeded", "channel": "live_trades_btcusd", "data": {}}`))
+	require.NoError(t, acc.FirstError())
+
+	b.Handle([]byte(`{"event": "bts:error", "channel": "", "data": {"code": null, "message": "Bad subscription string."}}`))
+	require.Error(t, acc.FirstError())
+	require.Contains(t, acc.FirstError().Error(), "Bad subscription string.")
+}
+
+func TestRequestReconnect(t *testing.T) {
+	subscriptions := make(chan string, 10)
+	var connections int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		subscriptions <- string(msg)
+		if atomic.AddInt32(&connections, 1) == 1 {
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"event": "bts:request_reconnect", "channel": "", "data": ""}`))
+		}
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer ts.Close()
+
+	b := newBitstampMarketData()
+	b.Log = testutil.Logger{}
+	b.ServiceAddress = "ws" + strings.TrimPrefix(ts.URL, "http")
+	b.ReconnectInterval = internal.Duration{Duration: 10 * time.Millisecond}
+	b.Pairs = []string{"btcusd"}
+	b.Channels = []string{"live_trades"}
+	require.NoError(t, b.Init())
+
+	require.NoError(t, b.Start(&testutil.Accumulator{}))
+	subscription := `{"event": "bts:subscribe", "data": {"channel": "live_trades_btcusd"}}`
+	require.JSONEq(t, subscription, <-subscriptions)
+	require.JSONEq(t, subscription, <-subscriptions)
+	b.Stop()
+}
+
+func TestNormalizedSchema(t *testing.T) {
+	b, acc := newTestPlugin(t)
+	b.MetricSchema = marketdata.SchemaNormalized
+	require.NoError(t, b.Init())
+	b.acc = b.normalizer.Accumulator(acc)
+
+	b.Handle([]byte(`{"data": {"id": 159413370, "timestamp": "1614600000", "amount": 0.0075, "amount_str": "0.00750000", "price": 48123.45, "price_str": "48123.45", "type": 1, "microtimestamp": "1614600000123456", "buy_order_id": 1, "sell_order_id": 2}, "channel": "live_trades_btcusd", "event": "trade"}`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("market_trade",
+			map[string]string{"exchange": "bitstamp", "base": "BTC", "quote": "USD", "side": "sell"},
+			map[string]interface{}{"price": 48123.45, "size": 0.0075},
+			time.Unix(1614600000, 123456000),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestInit(t *testing.T) {
+	b := newBitstampMarketData()
+	b.Channels = []string{"live_trades"}
+	require.Error(t, b.Init())
+
+	b.Pairs = []string{"btcusd"}
+	b.Channels = []string{"live_orders"}
+	require.Error(t, b.Init())
+}
@@ -0,0 +1,495 @@
+package bybit_marketdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/wsclient"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/plugins/common/tls"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const (
+	defaultServiceAddress = "wss://stream.bybit.com/v5/public/spot"
+	defaultOrderbookDepth = 50
+
+	// Bybit drops connections which didn't send a ping for a while, and
+	// recommends sending one every 20 seconds
+	defaultPingInterval = 20 * time.Second
+	defaultPongWait     = 40 * time.Second
+
+	topicOrderbook   = "orderbook"
+	topicPublicTrade = "publicTrade"
+	topicTickers     = "tickers"
+
+	// the most topics subscribed to by a single subscribe op
+	maxSubscriptionArgs = 10
+)
+
+// the depths the orderbook topic can be subscribed with
+var orderbookDepths = map[int]bool{1: true, 50: true, 200: true, 500: true}
+
+// the fields of the tickers topic, sent depending on the category
+var tickerFields = map[string]string{
+	"lastPrice":    "price",
+	"bid1Price":    "best_bid",
+	"bid1Size":     "best_bid_size",
+	"ask1Price":    "best_ask",
+	"ask1Size":     "best_ask_size",
+	"prevPrice24h": "open_24h",
+	"highPrice24h": "high_24h",
+	"lowPrice24h":  "low_24h",
+	"volume24h":    "volume_24h",
+	"turnover24h":  "turnover_24h",
+	"markPrice":    "mark_price",
+	"indexPrice":   "index_price",
+	"openInterest": "open_interest",
+	"fundingRate":  "funding_rate",
+}
+
+type BybitMarketData struct {
+	ServiceAddress string   `toml:"service_address"`
+	Symbols        []string `toml:"symbols"`
+	Topics         []string `toml:"topics"`
+	OrderbookDepth int      `toml:"orderbook_depth"`
+	MetricSchema   string   `toml:"metric_schema"`
+
+	PingInterval         internal.Duration `toml:"ping_interval"`
+	PongWait             internal.Duration `toml:"pong_wait"`
+	ReconnectInterval    internal.Duration `toml:"reconnect_interval"`
+	MaxBackoff           internal.Duration `toml:"max_backoff"`
+	MaxReconnectAttempts int               `toml:"max_reconnect_attempts"`
+
+	tls.ClientConfig
+
+	Log telegraf.Logger `toml:"-"`
+
+	acc        telegraf.Accumulator
+	client     *wsclient.Client
+	normalizer *marketdata.Normalizer
+	now        func() time.Time
+
+	// the order book of every symbol, sides keyed by price
+	books     map[string]*book
+	booksLock sync.Mutex
+}
+
+type book struct {
+	bids map[float64]float64
+	asks map[float64]float64
+}
+
+// message is either the answer to an op or the data of a topic
+type message struct {
+	Op      string          `json:"op"`
+	Success *bool           `json:"success"`
+	RetMsg  string          `json:"ret_msg"`
+	Topic   string          `json:"topic"`
+	Type    string          `json:"type"`
+	Ts      int64           `json:"ts"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// orderbookData is the data of the orderbook topic, the levels in the format
+// of [price, size]
+type orderbookData struct {
+	Symbol   string      `json:"s"`
+	Bids     [][2]string `json:"b"`
+	Asks     [][2]string `json:"a"`
+	UpdateId int64       `json:"u"`
+}
+
+// publicTrade is a trade of the publicTrade topic
+type publicTrade struct {
+	Time    int64  `json:"T"`
+	Symbol  string `json:"s"`
+	Side    string `json:"S"`
+	Size    string `json:"v"`
+	Price   string `json:"p"`
+	TradeId string `json:"i"`
+}
+
+func (b *BybitMarketData) SampleConfig() string {
+	return `
+## Bybit V5 public websocket api of the category of the symbols, e.g.
+## "wss://stream.bybit.com/v5/public/linear" for USDT perpetuals
+# service_address = "wss://stream.bybit.com/v5/public/spot"
+## Symbols to subscribe to
+symbols = ["BTCUSDT", "ETHUSDT"]
+## Topics of every symbol, any of "orderbook", "publicTrade" and "tickers"
+topics = ["orderbook", "publicTrade", "tickers"]
+## Levels of each side of the order book, one of 1, 50, 200 or 500
+# orderbook_depth = 50
+## Emit the trades, tickers and books in the "native" schema of the plugin,
+## or the "normalized" schema shared by the exchange inputs
+# metric_schema = "native"
+## Send the ping op every ping_interval, which Bybit requires to keep the
+## connection open, and reconnect when no message was received for
+## pong_wait. "0s" disables the keepalive.
+# ping_interval = "20s"
+# pong_wait = "40s"
+## Delay before reconnecting after the connection dropped, doubled after
+## every failed attempt up to max_backoff
+# reconnect_interval = "1s"
+# max_backoff = "1m"
+## Give up reconnecting after this many consecutive failed attempts, 0 retries
+## forever
+# max_reconnect_attempts = 0
+
+## Optional TLS Config
+# tls_ca = "/etc/telegraf/ca.pem"
+# tls_cert = "/etc/telegraf/cert.pem"
+# tls_key = "/etc/telegraf/key.pem"
+## Use TLS but skip chain & host verification
+# insecure_skip_verify = false
+`
+}
+
+func (b *BybitMarketData) Description() string {
+	return "Receives the order books, trades and tickers of Bybit symbols from its V5 public websocket api"
+}
+
+// Gather emits the top of the order book of every symbol
+func (b *BybitMarketData) Gather(acc telegraf.Accumulator) error {
+	acc = b.normalizer.Accumulator(acc)
+
+	b.booksLock.Lock()
+	defer b.booksLock.Unlock()
+
+	now := b.now()
+	for symbol, bk := range b.books {
+		acc.AddFields("bybit_book", bk.fields(), map[string]string{"symbol": symbol}, now)
+	}
+	return nil
+}
+
+func (b *BybitMarketData) Init() error {
+	if len(b.Symbols) == 0 {
+		return fmt.Errorf("symbols must be set")
+	}
+	if len(b.Topics) == 0 {
+		return fmt.Errorf("topics must be set")
+	}
+	for _, topic := range b.Topics {
+		switch topic {
+		case topicOrderbook, topicPublicTrade, topicTickers:
+		default:
+			return fmt.Errorf("invalid topic %q, must be %q, %q or %q", topic, topicOrderbook, topicPublicTrade, topicTickers)
+		}
+	}
+	if !orderbookDepths[b.OrderbookDepth] {
+		return fmt.Errorf("invalid orderbook_depth %d, must be 1, 50, 200 or 500", b.OrderbookDepth)
+	}
+
+	normalizer, err := marketdata.NewNormalizer(b.MetricSchema, marketdata.Normalizer{
+		Exchange: "bybit",
+		Measurements: map[string]string{
+			"bybit_ticker": marketdata.Ticker,
+			"bybit_trade":  marketdata.Trade,
+			"bybit_book":   marketdata.Book,
+		},
+		SymbolTag: "symbol",
+	}, b.Symbols)
+	if err != nil {
+		return err
+	}
+	b.normalizer = normalizer
+
+	tlsCfg, err := b.ClientConfig.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	cfg := wsclient.Config{
+		URL: b.ServiceAddress,
+		Dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: wsclient.HandshakeTimeout,
+			TLSClientConfig:  tlsCfg,
+		},
+		PingInterval:         b.PingInterval.Duration,
+		PongWait:             b.PongWait.Duration,
+		PingMessage:          []byte(`{"op":"ping"}`),
+		ReconnectInterval:    b.ReconnectInterval.Duration,
+		MaxBackoff:           b.MaxBackoff.Duration,
+		MaxReconnectAttempts: b.MaxReconnectAttempts,
+		StatsName:            "bybit_marketdata",
+		StatsTags:            map[string]string{"address": b.ServiceAddress},
+		Log:                  b.Log,
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	b.client = wsclient.New(cfg, b)
+
+	return nil
+}
+
+func (b *BybitMarketData) Start(acc telegraf.Accumulator) error {
+	b.acc = b.normalizer.Accumulator(acc)
+	return b.client.Start(b.acc)
+}
+
+func (b *BybitMarketData) Stop() {
+	b.client.Stop()
+}
+
+// topicNames returns the topics subscribed to, e.g. orderbook.50.BTCUSDT
+func (b *BybitMarketData) topicNames() []string {
+	var names []string
+	for _, symbol := range b.Symbols {
+		for _, topic := range b.Topics {
+			if topic == topicOrderbook {
+				topic += "." + strconv.Itoa(b.OrderbookDepth)
+			}
+			names = append(names, topic+"."+symbol)
+		}
+	}
+	return names
+}
+
+// Subscribe subscribes to the topics of every symbol on every connection,
+// at most maxSubscriptionArgs topics per op. The books start over from the
+// snapshot sent upon subscribing.
+func (b *BybitMarketData) Subscribe(conn *websocket.Conn) error {
+	b.booksLock.Lock()
+	b.books = make(map[string]*book)
+	b.booksLock.Unlock()
+
+	names := b.topicNames()
+	for len(names) > 0 {
+		n := len(names)
+		if n > maxSubscriptionArgs {
+			n = maxSubscriptionArgs
+		}
+
+		msg, err := json.Marshal(map[string]interface{}{
+			"op":   "subscribe",
+			"args": names[:n],
+		})
+		if err != nil {
+			return err
+		}
+		b.Log.Debugf("Subscription Request: %s", msg)
+		if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			return err
+		}
+		names = names[n:]
+	}
+	return nil
+}
+
+// Handle emits the metrics of a message of the feed
+func (b *BybitMarketData) Handle(data []byte) {
+	b.Log.Debugf("recv: %s", data)
+
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		b.acc.AddError(fmt.Errorf("unable to parse incoming msg: %s", err))
+		return
+	}
+
+	if msg.Op != "" {
+		if msg.Success != nil && !*msg.Success {
+			b.acc.AddError(fmt.Errorf("%s failed: %s", msg.Op, msg.RetMsg))
+		}
+		return
+	}
+
+	var err error
+	switch topic := strings.SplitN(msg.Topic, ".", 2)[0]; topic {
+	case topicOrderbook:
+		err = b.updateBook(msg.Type, msg.Data)
+	case topicPublicTrade:
+		err = b.addTrades(msg.Data)
+	case topicTickers:
+		err = b.addTicker(msg.Ts, msg.Data)
+	}
+	if err != nil {
+		b.acc.AddError(fmt.Errorf("unable to parse %s message: %s", msg.Topic, err))
+	}
+}
+
+// updateBook applies a snapshot or delta of the orderbook topic. A delta of
+// update id 1 replaces the book, as sent after a restart of the service.
+func (b *BybitMarketData) updateBook(msgType string, data []byte) error {
+	var d orderbookData
+	if err := json.Unmarshal(data, &d); err != nil {
+		return err
+	}
+
+	b.booksLock.Lock()
+	defer b.booksLock.Unlock()
+
+	bk, ok := b.books[d.Symbol]
+	if msgType == "snapshot" || d.UpdateId == 1 {
+		bk = &book{bids: make(map[float64]float64), asks: make(map[float64]float64)}
+		b.books[d.Symbol] = bk
+	} else if !ok {
+		// awaiting the snapshot
+		return nil
+	}
+
+	if err := apply(bk.bids, d.Bids); err != nil {
+		return err
+	}
+	return apply(bk.asks, d.Asks)
+}
+
+// apply updates a side of the book with levels, removing the levels of a
+// zero size
+func apply(side map[float64]float64, levels [][2]string) error {
+	for _, l := range levels {
+		price, err := strconv.ParseFloat(l[0], 64)
+		if err != nil {
+			return err
+		}
+		size, err := strconv.ParseFloat(l[1], 64)
+		if err != nil {
+			return err
+		}
+
+		if size == 0 {
+			delete(side, price)
+		} else {
+			side[price] = size
+		}
+	}
+	return nil
+}
+
+func (b *BybitMarketData) addTrades(data []byte) error {
+	var trades []publicTrade
+	if err := json.Unmarshal(data, &trades); err != nil {
+		return err
+	}
+
+	for _, t := range trades {
+		price, err := strconv.ParseFloat(t.Price, 64)
+		if err != nil {
+			return err
+		}
+		size, err := strconv.ParseFloat(t.Size, 64)
+		if err != nil {
+			return err
+		}
+
+		b.acc.AddFields("bybit_trade",
+			map[string]interface{}{
+				"price":    price,
+				"size":     size,
+				"trade_id": t.TradeId,
+			},
+			map[string]string{
+				"symbol": t.Symbol,
+				"side":   strings.ToLower(t.Side),
+			},
+			time.Unix(0, t.Time*int64(time.Millisecond)),
+		)
+	}
+	return nil
+}
+
+// addTicker emits the fields of a ticker, which are only those changed in
+// the deltas of derivatives
+func (b *BybitMarketData) addTicker(ts int64, data []byte) error {
+	var ticker map[string]string
+	if err := json.Unmarshal(data, &ticker); err != nil {
+		return err
+	}
+
+	fields := make(map[string]interface{})
+	for key, name := range tickerFields {
+		if ticker[key] == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(ticker[key], 64)
+		if err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+		fields[name] = value
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	b.acc.AddFields("bybit_ticker", fields,
+		map[string]string{"symbol": ticker["symbol"]},
+		time.Unix(0, ts*int64(time.Millisecond)),
+	)
+	return nil
+}
+
+// fields returns the top of the book and the total size of each side
+func (bk *book) fields() map[string]interface{} {
+	bids, asks := sortedPrices(bk.bids, true), sortedPrices(bk.asks, false)
+
+	fields := map[string]interface{}{
+		"bid_depth": totalSize(bk.bids),
+		"ask_depth": totalSize(bk.asks),
+	}
+	if len(bids) > 0 {
+		fields["best_bid"] = bids[0]
+		fields["best_bid_size"] = bk.bids[bids[0]]
+	}
+	if len(asks) > 0 {
+		fields["best_ask"] = asks[0]
+		fields["best_ask_size"] = bk.asks[asks[0]]
+	}
+	if len(bids) > 0 && len(asks) > 0 {
+		fields["spread"] = asks[0] - bids[0]
+		fields["mid_price"] = (asks[0] + bids[0]) / 2
+	}
+	return fields
+}
+
+// sortedPrices returns the prices of a side from the best, the highest bid
+// or the lowest ask
+func sortedPrices(side map[float64]float64, descending bool) []float64 {
+	prices := make([]float64, 0, len(side))
+	for price := range side {
+		prices = append(prices, price)
+	}
+	if descending {
+		sort.Sort(sort.Reverse(sort.Float64Slice(prices)))
+	} else {
+		sort.Float64s(prices)
+	}
+	return prices
+}
+
+func totalSize(side map[float64]float64) float64 {
+	var total float64
+	for _, size := range side {
+		total += size
+	}
+	return total
+}
+
+func newBybitMarketData() *BybitMarketData {
+	return &BybitMarketData{
+		ServiceAddress:    defaultServiceAddress,
+		MetricSchema:      marketdata.SchemaNative,
+		OrderbookDepth:    defaultOrderbookDepth,
+		PingInterval:      internal.Duration{Duration: defaultPingInterval},
+		PongWait:          internal.Duration{Duration: defaultPongWait},
+		ReconnectInterval: internal.Duration{Duration: wsclient.DefaultReconnectInterval},
+		MaxBackoff:        internal.Duration{Duration: wsclient.DefaultMaxBackoff},
+		now:               time.Now,
+		books:             make(map[string]*book),
+	}
+}
+
+func init() {
+	inputs.Add("bybit_marketdata", func() telegraf.Input { return newBybitMarketData() })
+}
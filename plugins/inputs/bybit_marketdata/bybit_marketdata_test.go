@@ -0,0 +1,233 @@
+package bybit_marketdata
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+const (
+	bookSnapshot = `{"topic": "orderbook.50.BTCUSDT", "type": "snapshot", "ts": 1672304484978, "data": {"s": "BTCUSDT", "b": [["16493.50", "0.006"], ["16493.00", "0.100"]], "a": [["16611.00", "0.029"], ["16612.00", "0.213"]], "u": 18521288, "seq": 7961638724}, "cts": 1672304484976}`
+	bookDelta    = `{"topic": "orderbook.50.BTCUSDT", "type": "delta", "ts": 1672304484998, "data": {"s": "BTCUSDT", "b": [["16493.50", "0"], ["16493.20", "0.5"]], "a": [["16611.00", "0.1"]], "u": 18521289, "seq": 7961638725}, "cts": 1672304484996}`
+)
+
+func newTestPlugin(t *testing.T) (*BybitMarketData, *testutil.Accumulator) {
+	b := newBybitMarketData()
+	b.Log = testutil.Logger{}
+	b.Symbols = []string{"BTCUSDT"}
+	b.Topics = []string{"orderbook", "publicTrade", "tickers"}
+	b.now = func() time.Time { return now }
+	require.NoError(t, b.Init())
+
+	acc := &testutil.Accumulator{}
+	b.acc = acc
+	return b, acc
+}
+
+func TestTicker(t *testing.T) {
+	b, acc := newTestPlugin(t)
+
+	b.Handle([]byte(`{"topic": "tickers.BTCUSDT", "ts": 1673853746003, "type": "snapshot", "cs": 2588407389, "data": {"symbol": "BTCUSDT", "lastPrice": "21109.77", "highPrice24h": "21426.99", "lowPrice24h": "20575", "prevPrice24h": "20704.93", "volume24h": "6780.866843", "turnover24h": "141946527.22907118", "price24hPcnt": "0.0196", "usdIndexPrice": "21120.2400136"}}`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("bybit_ticker",
+			map[string]string{"symbol": "BTCUSDT"},
+			map[string]interface{}{
+				"price":        21109.77,
+				"open_24h":     20704.93,
+				"high_24h":     21426.99,
+				"low_24h":      20575.0,
+				"volume_24h":   6780.866843,
+				"turnover_24h": 141946527.22907118,
+			},
+			time.Unix(0, 1673853746003*int64(time.Millisecond)),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestTrades(t *testing.T) {
+	b, acc := newTestPlugin(t)
+
+	b.Handle([]byte(`{"topic": "publicTrade.BTCUSDT", "type": "snapshot", "ts": 1672304486868, "data": [{"T": 1672304486865, "s": "BTCUSDT", "S": "Buy", "v": "0.001", "p": "16578.50", "L": "PlusTick", "i": "20f43950-d8dd-5b31-9112-a178eb6023af", "BT": false}, {"T": 1672304486866, "s": "BTCUSDT", "S": "Sell", "v": "0.02", "p": "16578.00", "L": "MinusTick", "i": "2290000000032345", "BT": false}]}`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("bybit_trade",
+			map[string]string{"symbol": "BTCUSDT", "side": "buy"},
+			map[string]interface{}{"price": 16578.5, "size": 0.001, "trade_id": "20f43950-d8dd-5b31-9112-a178eb6023af"},
+			time.Unix(0, 1672304486865*int64(time.Millisecond)),
+		),
+		testutil.MustMetric("bybit_trade",
+			map[string]string{"symbol": "BTCUSDT", "side": "sell"},
+			map[string]interface{}{"price": 16578.0, "size": 0.02, "trade_id": "2290000000032345"},
+			time.Unix(0, 1672304486866*int64(time.Millisecond)),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestBook(t *testing.T) {
+	b, acc := newTestPlugin(t)
+
+	// deltas before the snapshot are ignored
+	b.Handle([]byte(bookDelta))
+	b.Handle([]byte(bookSnapshot))
+	b.Handle([]byte(bookDelta))
+	require.NoError(t, acc.FirstError())
+
+	require.NoError(t, b.Gather(acc))
+	bid, ask := 16493.2, 16611.0
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("bybit_book",
+			map[string]string{"symbol": "BTCUSDT"},
+			map[string]interface{}{
+				"best_bid":      16493.2,
+				"best_bid_size": 0.5,
+				"best_ask":      16611.0,
+				"best_ask_size": 0.1,
+				"spread":        ask - bid,
+				"mid_price":     (ask + bid) / 2,
+				"bid_depth":     0.6,
+				"ask_depth":     0.313,
+			},
+			now,
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestBookDeltaOfFirstUpdateIdReplacesBook(t *testing.T) {
+	b, acc := newTestPlugin(t)
+
+	b.Handle([]byte(bookSnapshot))
+	b.Handle([]byte(`{"topic": "orderbook.50.BTCUSDT", "type": "delta", "ts": 1672304485998, "data": {"s": "BTCUSDT", "b": [["16500.00", "1"]], "a": [["16600.00", "2"]], "u": 1, "seq": 7961638800}}`))
+	require.NoError(t, acc.FirstError())
+
+	require.NoError(t, b.Gather(acc))
+	acc.AssertContainsTaggedFields(t, "bybit_book",
+		map[string]interface{}{
+			"best_bid":      16500.0,
+			"best_bid_size": 1.0,
+			"best_ask":      16600.0,
+			"best_ask_size": 2.0,
+			"spread":        100.0,
+			"mid_price":     16550.0,
+			"bid_depth":     1.0,
+			"ask_depth":     2.0,
+		},
+		map[string]string{"symbol": "BTCUSDT"},
+	)
+}
+
+func TestOpAnswers(t *testing.T) {
+	b, acc := newTestPlugin(t)
+
+	b.Handle([]byte(`{"success": true, "ret_msg": "pong", "conn_id": "0970e817-426e-429a-a679-ff7f55e0b16a", "op": "ping"}`))
+	b.Handle([]byte(`{"success": true, "ret_msg": "subscribe", "conn_id": "2324d924-aa4d-45b0-a858-7b8be29ab52b", "req_id": "10001", "op": "subscribe"}`))
+	require.NoError(t, acc.FirstError())
+	require.Empty(t, acc.GetTelegrafMetrics())
+
+	b.Handle([]byte(`{"success": false, "ret_msg": "error:handler not found,topic:tickers.BTCUSDX", "conn_id": "2324d924-aa4d-45b0-a858-7b8be29ab52b", "op": "subscribe"}`))
+	require.Error(t, acc.FirstError())
+	require.Contains(t, acc.FirstError().Error(), "handler not found")
+}
+
+func TestSubscribesAndPings(t *testing.T) {
+	msgs := make(chan map[string]interface{}, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var op map[string]interface{}
+			if json.Unmarshal(msg, &op) != nil {
+				return
+			}
+			select {
+			case msgs <- op:
+			default:
+			}
+			if op["op"] == "ping" {
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"success": true, "ret_msg": "pong", "op": "ping"}`))
+			}
+		}
+	}))
+	defer ts.Close()
+
+	b := newBybitMarketData()
+	b.Log = testutil.Logger{}
+	b.ServiceAddress = "ws" + strings.TrimPrefix(ts.URL, "http")
+	b.Symbols = []string{"BTCUSDT", "ETHUSDT", "SOLUSDT", "XRPUSDT"}
+	b.Topics = []string{"orderbook", "publicTrade", "tickers"}
+	b.OrderbookDepth = 1
+	b.PingInterval = internal.Duration{Duration: 50 * time.Millisecond}
+	require.NoError(t, b.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, b.Start(acc))
+	defer b.Stop()
+
+	// the 12 topics are subscribed to by two ops
+	first, second := <-msgs, <-msgs
+	require.Equal(t, "subscribe", first["op"])
+	require.Len(t, first["args"], 10)
+	require.Equal(t, []interface{}{"orderbook.1.BTCUSDT", "publicTrade.BTCUSDT", "tickers.BTCUSDT"}, first["args"].([]interface{})[:3])
+	require.Equal(t, "subscribe", second["op"])
+	require.Equal(t, []interface{}{"publicTrade.XRPUSDT", "tickers.XRPUSDT"}, second["args"])
+
+	require.Equal(t, map[string]interface{}{"op": "ping"}, <-msgs)
+	require.NoError(t, acc.FirstError())
+}
+
+func TestNormalizedSchema(t *testing.T) {
+	b, acc := newTestPlugin(t)
+	b.MetricSchema = marketdata.SchemaNormalized
+	require.NoError(t, b.Init())
+	b.acc = b.normalizer.Accumulator(acc)
+
+	b.Handle([]byte(`{"topic": "publicTrade.BTCUSDT", "type": "snapshot", "ts": 1672304486868, "data": [{"T": 1672304486865, "s": "BTCUSDT", "S": "Buy", "v": "0.001", "p": "16578.50", "L": "PlusTick", "i": "20f43950-d8dd-5b31-9112-a178eb6023af", "BT": false}]}`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("market_trade",
+			map[string]string{"exchange": "bybit", "base": "BTC", "quote": "USDT", "side": "buy"},
+			map[string]interface{}{"price": 16578.5, "size": 0.001},
+			time.Unix(0, 1672304486865*int64(time.Millisecond)),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestInit(t *testing.T) {
+	b := newBybitMarketData()
+	b.Topics = []string{"tickers"}
+	require.Error(t, b.Init())
+
+	b.Symbols = []string{"BTCUSDT"}
+	b.Topics = []string{"kline.1"}
+	require.Error(t, b.Init())
+
+	b.Topics = []string{"orderbook"}
+	b.OrderbookDepth = 100
+	require.Error(t, b.Init())
+
+	b.OrderbookDepth = 200
+	require.NoError(t, b.Init())
+}
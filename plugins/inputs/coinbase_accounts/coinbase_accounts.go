@@ -0,0 +1,254 @@
+package coinbase_accounts
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/common/tls"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const (
+	environmentProduction = "production"
+	environmentSandbox    = "sandbox"
+
+	defaultTimeout = 10 * time.Second
+
+	accountsPath = "/accounts"
+)
+
+// the REST api of each environment
+var restAddresses = map[string]string{
+	environmentProduction: "https://api.pro.coinbase.com",
+	environmentSandbox:    "https://api-public.sandbox.pro.coinbase.com",
+}
+
+type CoinbaseAccounts struct {
+	Environment   string            `toml:"environment"`
+	RestAddress   string            `toml:"rest_address"`
+	APIKey        string            `toml:"api_key"`
+	APISecret     string            `toml:"api_secret"`
+	APIPassphrase string            `toml:"api_passphrase"`
+	Currencies    []string          `toml:"currencies"`
+	IncludeZero   bool              `toml:"include_zero"`
+	FillProducts  []string          `toml:"fill_product_ids"`
+	Timeout       internal.Duration `toml:"timeout"`
+	tls.ClientConfig
+
+	Log telegraf.Logger `toml:"-"`
+
+	client *http.Client
+	now    func() time.Time
+
+	// the latest trade id of the fills emitted per product
+	lastFills map[string]int64
+}
+
+// account is an entry of the response of the /accounts endpoint, e.g.
+// {
+//  "id": "71452118-efc7-4cc4-8780-a5e22d4baa53",
+//  "currency": "BTC",
+//  "balance": "0.0000000000000000",
+//  "available": "0.0000000000000000",
+//  "hold": "0.0000000000000000",
+//  "profile_id": "75da88c5-05bf-4f54-bc85-5c775bd68254",
+//  "trading_enabled": true
+// }
+type account struct {
+	Id             string `json:"id"`
+	Currency       string `json:"currency"`
+	Balance        string `json:"balance"`
+	Available      string `json:"available"`
+	Hold           string `json:"hold"`
+	ProfileId      string `json:"profile_id"`
+	TradingEnabled bool   `json:"trading_enabled"`
+}
+
+func (ca *CoinbaseAccounts) SampleConfig() string {
+	return `
+## Coinbase environment, either "production" or "sandbox", whose REST api
+## is used unless rest_address is set
+# environment = "production"
+# rest_address = "https://api.pro.coinbase.com"
+## API key with the "view" permission
+api_key = ""
+api_secret = ""
+api_passphrase = ""
+## Currencies to emit the accounts of, all when empty
+# currencies = ["BTC", "ETH", "USD"]
+## Also emit the accounts without any balance
+# include_zero = false
+## Products to emit the fills of your orders of, as coinbase_fill metrics
+# fill_product_ids = ["BTC-USD"]
+## Timeout of a request
+# timeout = "10s"
+
+## Optional TLS Config
+# tls_ca = "/etc/telegraf/ca.pem"
+# tls_cert = "/etc/telegraf/cert.pem"
+# tls_key = "/etc/telegraf/key.pem"
+## Use TLS but skip chain & host verification
+# insecure_skip_verify = false
+`
+}
+
+func (ca *CoinbaseAccounts) Description() string {
+	return "Reads the balances, holds and fills of the accounts of a Coinbase API key"
+}
+
+func (ca *CoinbaseAccounts) Init() error {
+	if ca.Environment == "" {
+		ca.Environment = environmentProduction
+	}
+	restAddress, ok := restAddresses[ca.Environment]
+	if !ok {
+		return fmt.Errorf("invalid environment %q, must be %q or %q", ca.Environment, environmentProduction, environmentSandbox)
+	}
+	if ca.RestAddress == "" {
+		ca.RestAddress = restAddress
+	}
+
+	if ca.APIKey == "" || ca.APISecret == "" || ca.APIPassphrase == "" {
+		return fmt.Errorf("api_key, api_secret and api_passphrase must be set")
+	}
+	if _, err := base64.StdEncoding.DecodeString(ca.APISecret); err != nil {
+		return fmt.Errorf("invalid api_secret: %w", err)
+	}
+
+	tlsCfg, err := ca.ClientConfig.TLSConfig()
+	if err != nil {
+		return err
+	}
+	ca.client = &http.Client{
+		Timeout: ca.Timeout.Duration,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsCfg,
+		},
+	}
+	return nil
+}
+
+func (ca *CoinbaseAccounts) Gather(acc telegraf.Accumulator) error {
+	for _, productId := range ca.FillProducts {
+		if err := ca.gatherFills(acc, productId); err != nil {
+			acc.AddError(fmt.Errorf("unable to fetch the fills of %s: %s", productId, err))
+		}
+	}
+
+	var accounts []account
+	if _, err := ca.get(accountsPath, &accounts); err != nil {
+		return err
+	}
+
+	currencies := make(map[string]bool, len(ca.Currencies))
+	for _, currency := range ca.Currencies {
+		currencies[currency] = true
+	}
+
+	now := ca.now()
+	for _, a := range accounts {
+		if len(currencies) > 0 && !currencies[a.Currency] {
+			continue
+		}
+
+		fields := map[string]interface{}{
+			"trading_enabled": a.TradingEnabled,
+		}
+		for field, value := range map[string]string{"balance": a.Balance, "available": a.Available, "hold": a.Hold} {
+			amount, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				acc.AddError(fmt.Errorf("invalid %s %q of the %s account: %s", field, value, a.Currency, err))
+				continue
+			}
+			fields[field] = amount
+		}
+		if balance, ok := fields["balance"].(float64); ok && balance == 0 && !ca.IncludeZero {
+			continue
+		}
+
+		acc.AddFields("coinbase_account", fields,
+			map[string]string{
+				"currency":   a.Currency,
+				"profile_id": a.ProfileId,
+			},
+			now,
+		)
+	}
+	return nil
+}
+
+// sign returns the CB-ACCESS-SIGN signature of a request, the base64 encoded
+// HMAC-SHA256 of timestamp + method + path + body keyed with the base64
+// decoded api secret
+func sign(secret string, timestamp string, method string, path string, body string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return "", fmt.Errorf("invalid api_secret: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp + method + path + body))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// get decodes the response of a signed request of path, which includes the
+// query, into v and returns its headers
+func (ca *CoinbaseAccounts) get(path string, v interface{}) (http.Header, error) {
+	addr := ca.RestAddress + path
+
+	req, err := http.NewRequest(http.MethodGet, addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(ca.now().Unix(), 10)
+	signature, err := sign(ca.APISecret, timestamp, http.MethodGet, path, "")
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("CB-ACCESS-KEY", ca.APIKey)
+	req.Header.Set("CB-ACCESS-SIGN", signature)
+	req.Header.Set("CB-ACCESS-TIMESTAMP", timestamp)
+	req.Header.Set("CB-ACCESS-PASSPHRASE", ca.APIPassphrase)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := ca.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// the api explains a rejected signature or key in a message
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		return nil, fmt.Errorf("fetching %s: %s %s", addr, resp.Status, apiErr.Message)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return nil, fmt.Errorf("decoding %s: %s", addr, err)
+	}
+	return resp.Header, nil
+}
+
+func newCoinbaseAccounts() *CoinbaseAccounts {
+	return &CoinbaseAccounts{
+		Timeout:   internal.Duration{Duration: defaultTimeout},
+		now:       time.Now,
+		lastFills: make(map[string]int64),
+	}
+}
+
+func init() {
+	inputs.Add("coinbase_accounts", func() telegraf.Input { return newCoinbaseAccounts() })
+}
@@ -0,0 +1,97 @@
+package coinbase_accounts
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+const accounts = `[
+  {"id": "71452118", "currency": "BTC", "balance": "1.1000000000000000", "available": "1.0000000000000000", "hold": "0.1000000000000000", "profile_id": "75da88c5", "trading_enabled": true},
+  {"id": "e316cb9a", "currency": "USD", "balance": "80.2301373066930000", "available": "79.2266348066930000", "hold": "1.0035025000000000", "profile_id": "75da88c5", "trading_enabled": true},
+  {"id": "dc5a0a31", "currency": "ETH", "balance": "0.0000000000000000", "available": "0.0000000000000000", "hold": "0.0000000000000000", "profile_id": "75da88c5", "trading_enabled": false}
+]`
+
+func newAccountsServer(t *testing.T) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timestamp := r.Header.Get("CB-ACCESS-TIMESTAMP")
+		signature, err := sign("c2VjcmV0", timestamp, "GET", r.URL.RequestURI(), "")
+		require.NoError(t, err)
+
+		if r.Header.Get("CB-ACCESS-KEY") != "key" ||
+			r.Header.Get("CB-ACCESS-PASSPHRASE") != "passphrase" || r.Header.Get("CB-ACCESS-SIGN") != signature {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"message": "invalid signature"}`))
+			return
+		}
+		require.Equal(t, "1614600000", timestamp)
+		if r.URL.Path == "/fills" {
+			serveFills(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(accounts))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestGatherAccounts(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	ca := newCoinbaseAccounts()
+	ca.RestAddress = newAccountsServer(t).URL
+	ca.APIKey = "key"
+	ca.APISecret = "c2VjcmV0"
+	ca.APIPassphrase = "passphrase"
+	ca.now = func() time.Time { return now }
+	require.NoError(t, ca.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, ca.Gather(acc))
+	require.NoError(t, acc.FirstError())
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric("coinbase_account",
+			map[string]string{"currency": "BTC", "profile_id": "75da88c5"},
+			map[string]interface{}{"balance": 1.1, "available": 1.0, "hold": 0.1, "trading_enabled": true},
+			now,
+		),
+		testutil.MustMetric("coinbase_account",
+			map[string]string{"currency": "USD", "profile_id": "75da88c5"},
+			map[string]interface{}{"balance": 80.230137306693, "available": 79.226634806693, "hold": 1.0035025, "trading_enabled": true},
+			now,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+
+	ca.IncludeZero = true
+	ca.Currencies = []string{"ETH"}
+	acc.ClearMetrics()
+	require.NoError(t, ca.Gather(acc))
+	require.Equal(t, uint64(1), acc.NMetrics())
+	require.True(t, acc.HasTag("coinbase_account", "currency"))
+
+	ca.APIPassphrase = "wrong"
+	require.Error(t, ca.Gather(acc))
+}
+
+func TestInitRequiresCredentials(t *testing.T) {
+	ca := newCoinbaseAccounts()
+	ca.APIKey = "key"
+	require.Error(t, ca.Init())
+
+	ca.APISecret = "not base64!"
+	ca.APIPassphrase = "passphrase"
+	require.Error(t, ca.Init())
+
+	ca.APISecret = "c2VjcmV0"
+	ca.RestAddress = ""
+	ca.Environment = "sandbox"
+	require.NoError(t, ca.Init())
+	require.Equal(t, "https://api-public.sandbox.pro.coinbase.com", ca.RestAddress)
+}
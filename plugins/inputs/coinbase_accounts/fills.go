@@ -0,0 +1,133 @@
+package coinbase_accounts
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+const (
+	fillsPath = "/fills"
+
+	// the most fills the /fills endpoint returns at once
+	fillsLimit = 100
+)
+
+// fill is an entry of the response of the /fills endpoint, e.g.
+// {
+//  "trade_id": 74,
+//  "product_id": "BTC-USD",
+//  "price": "10.00",
+//  "size": "0.01",
+//  "order_id": "d50ec984-77a8-460a-b958-66f114b0de9b",
+//  "created_at": "2014-11-07T22:19:28.578544Z",
+//  "liquidity": "T",
+//  "fee": "0.00025",
+//  "settled": true,
+//  "side": "buy"
+// }
+type fill struct {
+	TradeId   int64  `json:"trade_id"`
+	ProductId string `json:"product_id"`
+	Price     string `json:"price"`
+	Size      string `json:"size"`
+	OrderId   string `json:"order_id"`
+	CreatedAt string `json:"created_at"`
+	Liquidity string `json:"liquidity"`
+	Fee       string `json:"fee"`
+	Settled   bool   `json:"settled"`
+	Side      string `json:"side"`
+}
+
+// the liquidity flags of a fill, whether the order made or took liquidity
+var liquidities = map[string]string{
+	"M": "maker",
+	"T": "taker",
+}
+
+// gatherFills emits the fills of a product since the latest one emitted,
+// oldest first. The first collection emits the latest page of fills.
+func (ca *CoinbaseAccounts) gatherFills(acc telegraf.Accumulator, productId string) error {
+	last, seen := ca.lastFills[productId]
+
+	var fills []fill
+	query := url.Values{}
+	query.Set("product_id", productId)
+	query.Set("limit", strconv.Itoa(fillsLimit))
+	for {
+		var page []fill
+		header, err := ca.get(fillsPath+"?"+query.Encode(), &page)
+		if err != nil {
+			return err
+		}
+
+		reachedLast := false
+		for _, f := range page {
+			if seen && f.TradeId <= last {
+				reachedLast = true
+				continue
+			}
+			fills = append(fills, f)
+		}
+
+		// the fills are paged from the latest, the CB-AFTER header being
+		// the cursor of the older ones
+		after := header.Get("CB-AFTER")
+		if !seen || reachedLast || len(page) < fillsLimit || after == "" {
+			break
+		}
+		query.Set("after", after)
+	}
+
+	sort.Slice(fills, func(i, j int) bool {
+		return fills[i].TradeId < fills[j].TradeId
+	})
+	for _, f := range fills {
+		if err := ca.emitFill(acc, f); err != nil {
+			acc.AddError(fmt.Errorf("invalid fill %d of %s: %s", f.TradeId, productId, err))
+		}
+		if f.TradeId > ca.lastFills[productId] {
+			ca.lastFills[productId] = f.TradeId
+		}
+	}
+	return nil
+}
+
+func (ca *CoinbaseAccounts) emitFill(acc telegraf.Accumulator, f fill) error {
+	fields := map[string]interface{}{
+		"trade_id": f.TradeId,
+		"order_id": f.OrderId,
+		"settled":  f.Settled,
+	}
+	for field, value := range map[string]string{"price": f.Price, "size": f.Size, "fee": f.Fee} {
+		amount, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s %q: %s", field, value, err)
+		}
+		fields[field] = amount
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, f.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("created_at %q: %s", f.CreatedAt, err)
+	}
+
+	liquidity, ok := liquidities[f.Liquidity]
+	if !ok {
+		liquidity = f.Liquidity
+	}
+
+	acc.AddFields("coinbase_fill", fields,
+		map[string]string{
+			"product_id": f.ProductId,
+			"side":       f.Side,
+			"liquidity":  liquidity,
+		},
+		ts,
+	)
+	return nil
+}
@@ -0,0 +1,95 @@
+package coinbase_accounts
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// the trade ids of the fills of BTC-USD served, the latest first
+var fillIds []int
+
+// serveFills pages the fills of BTC-USD by fillsLimit, older fills following
+// the CB-AFTER cursor
+func serveFills(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("product_id") != "BTC-USD" {
+		_, _ = w.Write([]byte("[]"))
+		return
+	}
+
+	start := 0
+	if after := r.URL.Query().Get("after"); after != "" {
+		start, _ = strconv.Atoi(after)
+	}
+	end := start + fillsLimit
+	if end > len(fillIds) {
+		end = len(fillIds)
+	}
+
+	var fills []string
+	for _, id := range fillIds[start:end] {
+		fills = append(fills, fmt.Sprintf(`{"trade_id": %d, "product_id": "BTC-USD", "price": "10.00", "size": "0.01", "order_id": "d50ec984", "created_at": "2014-11-07T22:19:28.578544Z", "liquidity": "T", "fee": "0.00025", "settled": true, "side": "buy"}`, id))
+	}
+	if end < len(fillIds) {
+		w.Header().Set("CB-AFTER", strconv.Itoa(end))
+	}
+	_, _ = w.Write([]byte("[" + strings.Join(fills, ",") + "]"))
+}
+
+func fillTradeIds(acc *testutil.Accumulator) []int64 {
+	var ids []int64
+	for _, m := range acc.GetTelegrafMetrics() {
+		if m.Name() == "coinbase_fill" {
+			ids = append(ids, m.Fields()["trade_id"].(int64))
+		}
+	}
+	return ids
+}
+
+func TestGatherFills(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	fillIds = []int{74, 73, 72}
+
+	ca := newCoinbaseAccounts()
+	ca.RestAddress = newAccountsServer(t).URL
+	ca.APIKey = "key"
+	ca.APISecret = "c2VjcmV0"
+	ca.APIPassphrase = "passphrase"
+	ca.FillProducts = []string{"BTC-USD", "ETH-USD"}
+	ca.now = func() time.Time { return now }
+	require.NoError(t, ca.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, ca.Gather(acc))
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, []int64{72, 73, 74}, fillTradeIds(acc))
+	acc.AssertContainsTaggedFields(t, "coinbase_fill",
+		map[string]interface{}{
+			"trade_id": int64(74),
+			"order_id": "d50ec984",
+			"price":    10.0,
+			"size":     0.01,
+			"fee":      0.00025,
+			"settled":  true,
+		},
+		map[string]string{"product_id": "BTC-USD", "side": "buy", "liquidity": "taker"},
+	)
+
+	// more fills than a page since, the older ones on a second page
+	fillIds = nil
+	for id := 74 + fillsLimit + 5; id > 70; id-- {
+		fillIds = append(fillIds, id)
+	}
+	acc.ClearMetrics()
+	require.NoError(t, ca.Gather(acc))
+	ids := fillTradeIds(acc)
+	require.Len(t, ids, fillsLimit+5)
+	require.Equal(t, int64(75), ids[0])
+	require.Equal(t, int64(74+fillsLimit+5), ids[len(ids)-1])
+}
@@ -0,0 +1,264 @@
+package coinbase_candles
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/common/tls"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const (
+	environmentProduction = "production"
+	environmentSandbox    = "sandbox"
+
+	defaultGranularity = time.Minute
+	defaultTimeout     = 10 * time.Second
+
+	// the most candles the /products/{id}/candles endpoint returns at once
+	maxCandles = 300
+)
+
+// the granularities the /products/{id}/candles endpoint accepts
+var granularities = map[time.Duration]bool{
+	time.Minute:      true,
+	5 * time.Minute:  true,
+	15 * time.Minute: true,
+	time.Hour:        true,
+	6 * time.Hour:    true,
+	24 * time.Hour:   true,
+}
+
+// the REST api of each environment
+var restAddresses = map[string]string{
+	environmentProduction: "https://api.pro.coinbase.com",
+	environmentSandbox:    "https://api-public.sandbox.pro.coinbase.com",
+}
+
+type CoinbaseCandles struct {
+	Environment string            `toml:"environment"`
+	RestAddress string            `toml:"rest_address"`
+	ProductIds  []string          `toml:"product_ids"`
+	Granularity internal.Duration `toml:"granularity"`
+	History     internal.Duration `toml:"history"`
+	IncludeOpen bool              `toml:"include_open"`
+	Timeout     internal.Duration `toml:"timeout"`
+	tls.ClientConfig
+
+	Log telegraf.Logger `toml:"-"`
+
+	client *http.Client
+	now    func() time.Time
+
+	// the start of the latest closed candle emitted per product
+	last map[string]time.Time
+}
+
+// candle is an entry of the response of the candles endpoint in the format
+// of [time, low, high, open, close, volume], the time in unix seconds
+type candle struct {
+	start  time.Time
+	low    float64
+	high   float64
+	open   float64
+	close  float64
+	volume float64
+}
+
+func (c *candle) UnmarshalJSON(data []byte) error {
+	var values []float64
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	if len(values) != 6 {
+		return fmt.Errorf("candle of %d values, expected 6", len(values))
+	}
+
+	c.start = time.Unix(int64(values[0]), 0).UTC()
+	c.low, c.high, c.open, c.close, c.volume = values[1], values[2], values[3], values[4], values[5]
+	return nil
+}
+
+func (cc *CoinbaseCandles) SampleConfig() string {
+	return `
+## Coinbase environment, either "production" or "sandbox", whose REST api
+## is used unless rest_address is set
+# environment = "production"
+# rest_address = "https://api.pro.coinbase.com"
+## Products to fetch the candles of
+product_ids = ["ETH-USD"]
+## Duration of a candle, one of "1m", "5m", "15m", "1h", "6h" or "24h"
+# granularity = "1m"
+## How far back the candles are fetched on the first collection, further
+## collections fetch the candles closed since
+# history = "1h"
+## Also emit the candle still in progress, updated on every collection
+# include_open = false
+## Timeout of a request
+# timeout = "10s"
+
+## Optional TLS Config
+# tls_ca = "/etc/telegraf/ca.pem"
+# tls_cert = "/etc/telegraf/cert.pem"
+# tls_key = "/etc/telegraf/key.pem"
+## Use TLS but skip chain & host verification
+# insecure_skip_verify = false
+`
+}
+
+func (cc *CoinbaseCandles) Description() string {
+	return "Reads the candles of Coinbase products from the REST api"
+}
+
+func (cc *CoinbaseCandles) Init() error {
+	if cc.Environment == "" {
+		cc.Environment = environmentProduction
+	}
+	restAddress, ok := restAddresses[cc.Environment]
+	if !ok {
+		return fmt.Errorf("invalid environment %q, must be %q or %q", cc.Environment, environmentProduction, environmentSandbox)
+	}
+	if cc.RestAddress == "" {
+		cc.RestAddress = restAddress
+	}
+
+	if len(cc.ProductIds) == 0 {
+		return fmt.Errorf("product_ids must be set")
+	}
+	if !granularities[cc.Granularity.Duration] {
+		return fmt.Errorf("invalid granularity %s, must be one of 1m, 5m, 15m, 1h, 6h or 24h", cc.Granularity.Duration)
+	}
+	if cc.History.Duration < 0 {
+		return fmt.Errorf("invalid history %s, must not be negative", cc.History.Duration)
+	}
+
+	tlsCfg, err := cc.ClientConfig.TLSConfig()
+	if err != nil {
+		return err
+	}
+	cc.client = &http.Client{
+		Timeout: cc.Timeout.Duration,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsCfg,
+		},
+	}
+	return nil
+}
+
+func (cc *CoinbaseCandles) Gather(acc telegraf.Accumulator) error {
+	now := cc.now()
+
+	for _, productId := range cc.ProductIds {
+		if err := cc.gatherProduct(acc, productId, now); err != nil {
+			acc.AddError(fmt.Errorf("unable to fetch the candles of %s: %s", productId, err))
+		}
+	}
+	return nil
+}
+
+// gatherProduct emits the candles of a product closed since the last one
+// emitted, or within history on the first collection, oldest first
+func (cc *CoinbaseCandles) gatherProduct(acc telegraf.Accumulator, productId string, now time.Time) error {
+	granularity := cc.Granularity.Duration
+
+	start := now.Add(-cc.History.Duration).Truncate(granularity)
+	if last, ok := cc.last[productId]; ok {
+		start = last.Add(granularity)
+	}
+
+	var candles []candle
+	for from := start; from.Before(now); from = from.Add(maxCandles * granularity) {
+		to := from.Add((maxCandles - 1) * granularity)
+		if to.After(now) {
+			to = now
+		}
+		page, err := cc.fetchCandles(productId, from, to)
+		if err != nil {
+			return err
+		}
+		candles = append(candles, page...)
+	}
+	sort.Slice(candles, func(i, j int) bool {
+		return candles[i].start.Before(candles[j].start)
+	})
+
+	tags := map[string]string{
+		"product_id":  productId,
+		"granularity": strconv.Itoa(int(granularity.Seconds())),
+	}
+	for i, c := range candles {
+		if c.start.Before(start) || (i > 0 && c.start.Equal(candles[i-1].start)) {
+			continue
+		}
+
+		closed := !c.start.Add(granularity).After(now)
+		if !closed && !cc.IncludeOpen {
+			continue
+		}
+
+		acc.AddFields("coinbase_candle",
+			map[string]interface{}{
+				"open":   c.open,
+				"high":   c.high,
+				"low":    c.low,
+				"close":  c.close,
+				"volume": c.volume,
+				"closed": closed,
+			},
+			tags,
+			c.start,
+		)
+		if closed {
+			cc.last[productId] = c.start
+		}
+	}
+	return nil
+}
+
+// fetchCandles returns the candles of a product starting from from to to,
+// the latest first
+func (cc *CoinbaseCandles) fetchCandles(productId string, from time.Time, to time.Time) ([]candle, error) {
+	query := url.Values{}
+	query.Set("granularity", strconv.Itoa(int(cc.Granularity.Duration.Seconds())))
+	query.Set("start", from.UTC().Format(time.RFC3339))
+	query.Set("end", to.UTC().Format(time.RFC3339))
+	addr := fmt.Sprintf("%s/products/%s/candles?%s", cc.RestAddress, productId, query.Encode())
+
+	resp, err := cc.client.Get(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", addr, resp.Status)
+	}
+
+	var candles []candle
+	if err := json.NewDecoder(resp.Body).Decode(&candles); err != nil {
+		return nil, fmt.Errorf("decoding %s: %s", addr, err)
+	}
+	return candles, nil
+}
+
+func newCoinbaseCandles() *CoinbaseCandles {
+	return &CoinbaseCandles{
+		Granularity: internal.Duration{Duration: defaultGranularity},
+		History:     internal.Duration{Duration: time.Hour},
+		Timeout:     internal.Duration{Duration: defaultTimeout},
+		now:         time.Now,
+		last:        make(map[string]time.Time),
+	}
+}
+
+func init() {
+	inputs.Add("coinbase_candles", func() telegraf.Input { return newCoinbaseCandles() })
+}
@@ -0,0 +1,111 @@
+package coinbase_candles
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// newCandlesServer serves a candle of ETH-USD every minute from 12:00 up to
+// the minute of now, the latest first
+func newCandlesServer(t *testing.T, now *time.Time) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/products/ETH-USD/candles" || r.URL.Query().Get("granularity") != "60" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		start, err := time.Parse(time.RFC3339, r.URL.Query().Get("start"))
+		require.NoError(t, err)
+		end, err := time.Parse(time.RFC3339, r.URL.Query().Get("end"))
+		require.NoError(t, err)
+
+		first := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+		candles := [][]float64{}
+		for ts := now.Truncate(time.Minute); !ts.Before(first); ts = ts.Add(-time.Minute) {
+			if ts.Before(start) || ts.After(end) {
+				continue
+			}
+			minute := float64(ts.Sub(first) / time.Minute)
+			candles = append(candles, []float64{float64(ts.Unix()), 1500 + minute, 1510 + minute, 1505 + minute, 1506 + minute, 10})
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(candles))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func candleMinutes(acc *testutil.Accumulator) []int {
+	var minutes []int
+	for _, m := range acc.GetTelegrafMetrics() {
+		minutes = append(minutes, m.Time().Minute())
+	}
+	return minutes
+}
+
+func TestGatherClosedCandles(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 5, 30, 0, time.UTC)
+
+	cc := newCoinbaseCandles()
+	cc.RestAddress = newCandlesServer(t, &now).URL
+	cc.ProductIds = []string{"ETH-USD"}
+	cc.History = internal.Duration{Duration: 3 * time.Minute}
+	cc.now = func() time.Time { return now }
+	require.NoError(t, cc.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, cc.Gather(acc))
+	require.NoError(t, acc.FirstError())
+	// the candle of 12:05 is still open
+	require.Equal(t, []int{2, 3, 4}, candleMinutes(acc))
+	acc.AssertContainsTaggedFields(t, "coinbase_candle",
+		map[string]interface{}{
+			"open":   1507.0,
+			"high":   1512.0,
+			"low":    1502.0,
+			"close":  1508.0,
+			"volume": 10.0,
+			"closed": true,
+		},
+		map[string]string{"product_id": "ETH-USD", "granularity": "60"},
+	)
+
+	// only the candles closed since are emitted
+	now = now.Add(2 * time.Minute)
+	acc.ClearMetrics()
+	require.NoError(t, cc.Gather(acc))
+	require.Equal(t, []int{5, 6}, candleMinutes(acc))
+
+	cc.IncludeOpen = true
+	acc.ClearMetrics()
+	require.NoError(t, cc.Gather(acc))
+	require.Equal(t, []int{7}, candleMinutes(acc))
+	m := acc.GetTelegrafMetrics()[0]
+	require.Equal(t, false, m.Fields()["closed"])
+}
+
+func TestInitValidation(t *testing.T) {
+	cc := newCoinbaseCandles()
+	require.Error(t, cc.Init())
+
+	cc.ProductIds = []string{"ETH-USD"}
+	cc.Granularity = internal.Duration{Duration: 2 * time.Minute}
+	require.Error(t, cc.Init())
+
+	cc.Granularity = internal.Duration{Duration: time.Hour}
+	require.NoError(t, cc.Init())
+	require.Equal(t, "https://api.pro.coinbase.com", cc.RestAddress)
+
+	cc.RestAddress = ""
+	cc.Environment = "sandbox"
+	require.NoError(t, cc.Init())
+	require.Equal(t, "https://api-public.sandbox.pro.coinbase.com", cc.RestAddress)
+
+	cc.Environment = "staging"
+	require.Error(t, cc.Init())
+}
@@ -0,0 +1,473 @@
+package coinbase_fix
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	tlsint "github.com/influxdata/telegraf/plugins/common/tls"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const (
+	defaultServiceAddress = "ssl://fix-md.exchange.coinbase.com:6121"
+	defaultTargetCompID   = "Coinbase"
+
+	beginStringFIX42 = "FIX.4.2"
+	beginStringFIXT  = "FIXT.1.1"
+
+	// applVerIDFIX50SP2 is the DefaultApplVerID of FIX 5.0 SP2 sessions
+	applVerIDFIX50SP2 = "9"
+
+	defaultHeartbeatInterval = 30 * time.Second
+	defaultReconnectInterval = 5 * time.Second
+
+	// sendingTimeFormat is the UTCTimestamp format of the SendingTime
+	sendingTimeFormat = "20060102-15:04:05.000"
+)
+
+type CoinbaseFix struct {
+	ServiceAddress string   `toml:"service_address"`
+	BeginString    string   `toml:"begin_string"`
+	ProductIds     []string `toml:"product_ids"`
+	MetricSchema   string   `toml:"metric_schema"`
+
+	APIKey        string `toml:"api_key"`
+	APISecret     string `toml:"api_secret"`
+	APIPassphrase string `toml:"api_passphrase"`
+	SenderCompID  string `toml:"sender_comp_id"`
+	TargetCompID  string `toml:"target_comp_id"`
+
+	HeartbeatInterval internal.Duration `toml:"heartbeat_interval"`
+	ReconnectInterval internal.Duration `toml:"reconnect_interval"`
+
+	tlsint.ClientConfig
+
+	Log telegraf.Logger `toml:"-"`
+
+	acc        telegraf.Accumulator
+	normalizer *marketdata.Normalizer
+	now        func() time.Time
+
+	address   string
+	tlsConfig *tls.Config
+
+	// the connection of the current session and the sequence number of the
+	// next message sent on it
+	conn      net.Conn
+	seqNum    int
+	writeLock sync.Mutex
+
+	// the order book of every product, sides keyed by price
+	books     map[string]*book
+	booksLock sync.Mutex
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+type book struct {
+	bids map[float64]float64
+	asks map[float64]float64
+}
+
+func (c *CoinbaseFix) SampleConfig() string {
+	return `
+## Address of the FIX market data gateway, "ssl://" for TLS or "tcp://"
+# service_address = "ssl://fix-md.exchange.coinbase.com:6121"
+## Version of the session, "FIXT.1.1" for FIX 5.0 SP2 or "FIX.4.2"
+# begin_string = "FIXT.1.1"
+## Products whose order books and trades to subscribe to
+product_ids = ["BTC-USD", "ETH-USD"]
+## Emit the trades and books in the "native" schema of the plugin, or the
+## "normalized" schema shared by the exchange inputs
+# metric_schema = "native"
+
+## Credentials of an api key with FIX market data entitlements, signing
+## the logon
+api_key = ""
+api_secret = ""
+api_passphrase = ""
+## SenderCompID of the session, defaults to the api_key
+# sender_comp_id = ""
+# target_comp_id = "Coinbase"
+
+## Interval of the heartbeats, the session being restarted when nothing was
+## received for twice the interval
+# heartbeat_interval = "30s"
+## Delay before logging on again after the session ended
+# reconnect_interval = "5s"
+
+## Optional TLS Config
+# tls_ca = "/etc/telegraf/ca.pem"
+# tls_cert = "/etc/telegraf/cert.pem"
+# tls_key = "/etc/telegraf/key.pem"
+## Use TLS but skip chain & host verification
+# insecure_skip_verify = false
+`
+}
+
+func (c *CoinbaseFix) Description() string {
+	return "Receives the order books and trades of Coinbase products from its FIX market data gateway"
+}
+
+// Gather emits the top of the order book of every product
+func (c *CoinbaseFix) Gather(acc telegraf.Accumulator) error {
+	acc = c.normalizer.Accumulator(acc)
+
+	c.booksLock.Lock()
+	defer c.booksLock.Unlock()
+
+	now := c.now()
+	for productId, b := range c.books {
+		acc.AddFields("coinbase_fix_book", b.fields(), map[string]string{"product_id": productId}, now)
+	}
+	return nil
+}
+
+func (c *CoinbaseFix) Init() error {
+	if len(c.ProductIds) == 0 {
+		return fmt.Errorf("product_ids must be set")
+	}
+	if c.APIKey == "" || c.APISecret == "" || c.APIPassphrase == "" {
+		return fmt.Errorf("api_key, api_secret and api_passphrase must be set")
+	}
+	if _, err := base64.StdEncoding.DecodeString(c.APISecret); err != nil {
+		return fmt.Errorf("invalid api_secret: %w", err)
+	}
+	switch c.BeginString {
+	case beginStringFIXT, beginStringFIX42:
+	default:
+		return fmt.Errorf("invalid begin_string %q, must be %q or %q", c.BeginString, beginStringFIXT, beginStringFIX42)
+	}
+	if c.HeartbeatInterval.Duration < time.Second {
+		return fmt.Errorf("heartbeat_interval must be at least 1s")
+	}
+	if c.SenderCompID == "" {
+		c.SenderCompID = c.APIKey
+	}
+
+	scheme := "ssl"
+	c.address = c.ServiceAddress
+	if i := strings.Index(c.ServiceAddress, "://"); i >= 0 {
+		scheme, c.address = c.ServiceAddress[:i], c.ServiceAddress[i+3:]
+	}
+	switch scheme {
+	case "ssl", "tls", "tcp+ssl":
+		tlsCfg, err := c.ClientConfig.TLSConfig()
+		if err != nil {
+			return err
+		}
+		if tlsCfg == nil {
+			tlsCfg = &tls.Config{}
+		}
+		c.tlsConfig = tlsCfg
+	case "tcp":
+	default:
+		return fmt.Errorf("invalid scheme %q of service_address, must be \"ssl\" or \"tcp\"", scheme)
+	}
+
+	normalizer, err := marketdata.NewNormalizer(c.MetricSchema, marketdata.Normalizer{
+		Exchange: "coinbase",
+		Measurements: map[string]string{
+			"coinbase_fix_trade": marketdata.Trade,
+			"coinbase_fix_book":  marketdata.Book,
+		},
+		SymbolTag: "product_id",
+	}, c.ProductIds)
+	if err != nil {
+		return err
+	}
+	c.normalizer = normalizer
+
+	return nil
+}
+
+func (c *CoinbaseFix) Start(acc telegraf.Accumulator) error {
+	c.acc = c.normalizer.Accumulator(acc)
+	c.done = make(chan struct{})
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.run()
+	}()
+	return nil
+}
+
+// Stop logs out of the session and waits for it to end
+func (c *CoinbaseFix) Stop() {
+	close(c.done)
+
+	c.writeLock.Lock()
+	if c.conn != nil {
+		c.send(msgLogout, nil)
+		c.conn.Close()
+	}
+	c.writeLock.Unlock()
+
+	c.wg.Wait()
+}
+
+// run starts a session, and a new one reconnect_interval after every
+// session ended, until stopped
+func (c *CoinbaseFix) run() {
+	for {
+		if err := c.session(); err != nil {
+			select {
+			case <-c.done:
+				return
+			default:
+			}
+			c.acc.AddError(fmt.Errorf("session ended: %s", err))
+		}
+
+		select {
+		case <-c.done:
+			return
+		case <-time.After(c.ReconnectInterval.Duration):
+		}
+	}
+}
+
+// session logs on, subscribes to the market data of the products and
+// handles the messages received until the connection ends
+func (c *CoinbaseFix) session() error {
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	c.writeLock.Lock()
+	select {
+	case <-c.done:
+		c.writeLock.Unlock()
+		return nil
+	default:
+	}
+	c.conn = conn
+	c.seqNum = 1
+	err = c.send(msgLogon, c.logon())
+	c.writeLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		c.writeLock.Lock()
+		c.conn = nil
+		c.writeLock.Unlock()
+	}()
+
+	r := bufio.NewReader(conn)
+	msg, err := c.read(conn, r)
+	if err != nil {
+		return fmt.Errorf("logon: %s", err)
+	}
+	if msgType, _ := msg.get(tagMsgType); msgType != msgLogon {
+		text, _ := msg.get(tagText)
+		return fmt.Errorf("logon rejected with message type %q: %s", msgType, text)
+	}
+	c.Log.Infof("Logged on to %s", c.ServiceAddress)
+
+	// the books start over from the snapshots sent upon subscribing
+	c.booksLock.Lock()
+	c.books = make(map[string]*book)
+	c.booksLock.Unlock()
+
+	c.writeLock.Lock()
+	err = c.send(msgMarketDataRequest, c.marketDataRequest())
+	c.writeLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	heartbeats := make(chan struct{})
+	defer close(heartbeats)
+	go c.heartbeat(heartbeats)
+
+	for {
+		msg, err := c.read(conn, r)
+		if err != nil {
+			return err
+		}
+		if err := c.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *CoinbaseFix) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: c.HeartbeatInterval.Duration}
+	if c.tlsConfig != nil {
+		return tls.DialWithDialer(dialer, "tcp", c.address, c.tlsConfig)
+	}
+	return dialer.Dial("tcp", c.address)
+}
+
+// read reads the next message, failing when nothing was received for twice
+// the heartbeat interval
+func (c *CoinbaseFix) read(conn net.Conn, r *bufio.Reader) (message, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(2 * c.HeartbeatInterval.Duration)); err != nil {
+		return nil, err
+	}
+	msg, err := readMessage(r)
+	if err != nil {
+		return nil, err
+	}
+	c.Log.Debugf("recv: %s", msg)
+	return msg, nil
+}
+
+// heartbeat sends a heartbeat every heartbeat interval until done is closed
+func (c *CoinbaseFix) heartbeat(done chan struct{}) {
+	ticker := time.NewTicker(c.HeartbeatInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			c.writeLock.Lock()
+			if c.conn != nil {
+				if err := c.send(msgHeartbeat, nil); err != nil {
+					c.Log.Errorf("Unable to send heartbeat: %s", err)
+				}
+			}
+			c.writeLock.Unlock()
+		}
+	}
+}
+
+// handle handles a message of the session, failing on the messages ending
+// it
+func (c *CoinbaseFix) handle(msg message) error {
+	msgType, _ := msg.get(tagMsgType)
+	text, _ := msg.get(tagText)
+
+	switch msgType {
+	case msgTestRequest:
+		testReqID, _ := msg.get(tagTestReqID)
+		c.writeLock.Lock()
+		defer c.writeLock.Unlock()
+		return c.send(msgHeartbeat, message{{tagTestReqID, testReqID}})
+	case msgMarketDataSnapshot:
+		return c.addSnapshot(msg)
+	case msgMarketDataIncrement:
+		return c.addIncrement(msg)
+	case msgMarketDataReject:
+		return fmt.Errorf("market data request rejected: %s", text)
+	case msgReject:
+		c.acc.AddError(fmt.Errorf("message rejected: %s", text))
+	case msgLogout:
+		return fmt.Errorf("logged out: %s", text)
+	}
+	return nil
+}
+
+// logon returns the fields of the logon, signed with the HMAC-SHA256 of the
+// SendingTime, MsgType, MsgSeqNum, SenderCompID, TargetCompID and Password
+// keyed with the base64 decoded api secret
+func (c *CoinbaseFix) logon() message {
+	fields := message{
+		{tagEncryptMethod, "0"},
+		{tagHeartBtInt, strconv.Itoa(int(c.HeartbeatInterval.Duration / time.Second))},
+		{tagResetSeqNumFlag, "Y"},
+		{tagUsername, c.APIKey},
+		{tagPassword, c.APIPassphrase},
+	}
+	if c.BeginString == beginStringFIXT {
+		fields = append(fields, field{tagDefaultApplVerID, applVerIDFIX50SP2})
+	}
+	return fields
+}
+
+// sign returns the RawData of a logon sent at sendingTime
+func (c *CoinbaseFix) sign(sendingTime string, seqNum int) string {
+	key, _ := base64.StdEncoding.DecodeString(c.APISecret)
+	prehash := strings.Join([]string{
+		sendingTime, msgLogon, strconv.Itoa(seqNum), c.SenderCompID, c.TargetCompID, c.APIPassphrase,
+	}, string(soh))
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(prehash))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// marketDataRequest returns the fields of the subscription to the full book
+// and the trades of the products
+func (c *CoinbaseFix) marketDataRequest() message {
+	fields := message{
+		{tagMDReqID, strconv.FormatInt(c.now().UnixNano(), 10)},
+		{tagSubscriptionType, "1"},
+		{tagMarketDepth, "0"},
+		{tagNoMDEntryTypes, "3"},
+		{tagMDEntryType, entryBid},
+		{tagMDEntryType, entryOffer},
+		{tagMDEntryType, entryTrade},
+		{tagNoRelatedSym, strconv.Itoa(len(c.ProductIds))},
+	}
+	for _, productId := range c.ProductIds {
+		fields = append(fields, field{tagSymbol, productId})
+	}
+	return fields
+}
+
+// send sends a message on the current connection, with the header of the
+// session. The caller holds the writeLock.
+func (c *CoinbaseFix) send(msgType string, fields message) error {
+	sendingTime := c.now().UTC().Format(sendingTimeFormat)
+	body := message{
+		{tagMsgType, msgType},
+		{tagSenderCompID, c.SenderCompID},
+		{tagTargetCompID, c.TargetCompID},
+		{tagMsgSeqNum, strconv.Itoa(c.seqNum)},
+		{tagSendingTime, sendingTime},
+	}
+	body = append(body, fields...)
+	if msgType == msgLogon {
+		signature := c.sign(sendingTime, c.seqNum)
+		body = append(body,
+			field{tagRawDataLength, strconv.Itoa(len(signature))},
+			field{tagRawData, signature},
+		)
+	}
+	c.seqNum++
+
+	c.Log.Debugf("send: %s", body)
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.HeartbeatInterval.Duration)); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(encode(c.BeginString, body))
+	return err
+}
+
+func newCoinbaseFix() *CoinbaseFix {
+	return &CoinbaseFix{
+		ServiceAddress:    defaultServiceAddress,
+		BeginString:       beginStringFIXT,
+		MetricSchema:      marketdata.SchemaNative,
+		TargetCompID:      defaultTargetCompID,
+		HeartbeatInterval: internal.Duration{Duration: defaultHeartbeatInterval},
+		ReconnectInterval: internal.Duration{Duration: defaultReconnectInterval},
+		now:               time.Now,
+		books:             make(map[string]*book),
+	}
+}
+
+func init() {
+	inputs.Add("coinbase_fix", func() telegraf.Input { return newCoinbaseFix() })
+}
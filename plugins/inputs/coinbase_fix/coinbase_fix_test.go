@@ -0,0 +1,316 @@
+package coinbase_fix
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// the api secret of the tests, base64 encoded
+var secret = base64.StdEncoding.EncodeToString([]byte("secret"))
+
+func newTestPlugin(t *testing.T) (*CoinbaseFix, *testutil.Accumulator) {
+	c := newCoinbaseFix()
+	c.Log = testutil.Logger{}
+	c.ProductIds = []string{"BTC-USD"}
+	c.APIKey = "key"
+	c.APISecret = secret
+	c.APIPassphrase = "passphrase"
+	c.now = func() time.Time { return now }
+	require.NoError(t, c.Init())
+
+	acc := &testutil.Accumulator{}
+	c.acc = acc
+	return c, acc
+}
+
+var snapshot = message{
+	{tagMsgType, msgMarketDataSnapshot},
+	{tagSymbol, "BTC-USD"},
+	{tagNoMDEntries, "4"},
+	{tagMDEntryType, entryBid},
+	{tagMDEntryPx, "9122.04"},
+	{tagMDEntrySize, "0.5"},
+	{tagMDEntryType, entryBid},
+	{tagMDEntryPx, "9121.00"},
+	{tagMDEntrySize, "1.5"},
+	{tagMDEntryType, entryOffer},
+	{tagMDEntryPx, "9123.00"},
+	{tagMDEntrySize, "0.25"},
+	{tagMDEntryType, entryOffer},
+	{tagMDEntryPx, "9124.00"},
+	{tagMDEntrySize, "2"},
+}
+
+var increment = message{
+	{tagMsgType, msgMarketDataIncrement},
+	{tagSendingTime, "20210301-11:59:59.500"},
+	{tagNoMDEntries, "3"},
+	{tagMDUpdateAction, actionDelete},
+	{tagMDEntryType, entryOffer},
+	{tagSymbol, "BTC-USD"},
+	{tagMDEntryPx, "9123.00"},
+	{tagMDUpdateAction, actionNew},
+	{tagMDEntryType, entryBid},
+	{tagSymbol, "BTC-USD"},
+	{tagMDEntryPx, "9122.50"},
+	{tagMDEntrySize, "1"},
+	{tagMDUpdateAction, actionNew},
+	{tagMDEntryType, entryTrade},
+	{tagSymbol, "BTC-USD"},
+	{tagMDEntryPx, "9123.00"},
+	{tagMDEntrySize, "0.25"},
+	{tagMDEntryDate, "20210301"},
+	{tagMDEntryTime, "11:59:59.250"},
+	{tagAggressorSide, "1"},
+}
+
+var (
+	expectedTrade = testutil.MustMetric("coinbase_fix_trade",
+		map[string]string{"product_id": "BTC-USD", "side": "buy"},
+		map[string]interface{}{"price": 9123.0, "size": 0.25},
+		time.Date(2021, 3, 1, 11, 59, 59, 250000000, time.UTC),
+	)
+	expectedBook = testutil.MustMetric("coinbase_fix_book",
+		map[string]string{"product_id": "BTC-USD"},
+		map[string]interface{}{
+			"best_bid":      9122.5,
+			"best_bid_size": 1.0,
+			"best_ask":      9124.0,
+			"best_ask_size": 2.0,
+			"spread":        1.5,
+			"mid_price":     9123.25,
+			"bid_depth":     3.0,
+			"ask_depth":     2.0,
+		},
+		now,
+	)
+)
+
+func TestMarketData(t *testing.T) {
+	c, acc := newTestPlugin(t)
+
+	require.NoError(t, c.handle(snapshot))
+	require.NoError(t, c.handle(increment))
+	require.NoError(t, acc.FirstError())
+
+	require.NoError(t, c.Gather(acc))
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{expectedTrade, expectedBook}, acc.GetTelegrafMetrics())
+}
+
+func TestTradeTime(t *testing.T) {
+	c, acc := newTestPlugin(t)
+
+	require.NoError(t, c.handle(message{
+		{tagMsgType, msgMarketDataIncrement},
+		{tagSendingTime, "20210301-11:59:59.500"},
+		{tagNoMDEntries, "1"},
+		{tagMDUpdateAction, actionNew},
+		{tagMDEntryType, entryTrade},
+		{tagSymbol, "BTC-USD"},
+		{tagMDEntryPx, "9123.00"},
+		{tagMDEntrySize, "0.25"},
+	}))
+
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("coinbase_fix_trade",
+			map[string]string{"product_id": "BTC-USD"},
+			map[string]interface{}{"price": 9123.0, "size": 0.25},
+			time.Date(2021, 3, 1, 11, 59, 59, 500000000, time.UTC),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestNormalizedSchema(t *testing.T) {
+	c := newCoinbaseFix()
+	c.Log = testutil.Logger{}
+	c.ProductIds = []string{"BTC-USD"}
+	c.APIKey = "key"
+	c.APISecret = secret
+	c.APIPassphrase = "passphrase"
+	c.MetricSchema = marketdata.SchemaNormalized
+	c.now = func() time.Time { return now }
+	require.NoError(t, c.Init())
+
+	acc := &testutil.Accumulator{}
+	c.acc = c.normalizer.Accumulator(acc)
+
+	require.NoError(t, c.handle(snapshot))
+	require.NoError(t, c.handle(increment))
+	require.NoError(t, c.Gather(acc))
+
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("market_trade",
+			map[string]string{"exchange": "coinbase", "base": "BTC", "quote": "USD", "side": "buy"},
+			map[string]interface{}{"price": 9123.0, "size": 0.25},
+			time.Date(2021, 3, 1, 11, 59, 59, 250000000, time.UTC),
+		),
+		testutil.MustMetric("market_book",
+			map[string]string{"exchange": "coinbase", "base": "BTC", "quote": "USD"},
+			map[string]interface{}{
+				"bid":       9122.5,
+				"bid_size":  1.0,
+				"ask":       9124.0,
+				"ask_size":  2.0,
+				"spread":    1.5,
+				"mid_price": 9123.25,
+				"bid_depth": 3.0,
+				"ask_depth": 2.0,
+			},
+			now,
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestRejects(t *testing.T) {
+	c, acc := newTestPlugin(t)
+
+	require.NoError(t, c.handle(message{{tagMsgType, msgReject}, {tagText, "Invalid tag"}}))
+	require.EqualError(t, acc.FirstError(), "message rejected: Invalid tag")
+
+	require.EqualError(t, c.handle(message{{tagMsgType, msgMarketDataReject}, {tagText, "Unknown symbol"}}),
+		"market data request rejected: Unknown symbol")
+	require.EqualError(t, c.handle(message{{tagMsgType, msgLogout}, {tagText, "Bye"}}), "logged out: Bye")
+}
+
+// serve accepts a session, checking the logon and the market data request,
+// and answers with the market data and a test request
+func serve(t *testing.T, l net.Listener, heartbeats chan<- message, logouts chan<- message) {
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	send := func(msg message) {
+		_, err := conn.Write(encode(beginStringFIXT, msg))
+		require.NoError(t, err)
+	}
+
+	logon, err := readMessage(r)
+	require.NoError(t, err)
+	fields := make(map[int]string)
+	for _, f := range logon {
+		fields[f.tag] = f.value
+	}
+	require.Equal(t, msgLogon, fields[tagMsgType])
+	require.Equal(t, "1", fields[tagMsgSeqNum])
+	require.Equal(t, "key", fields[tagSenderCompID])
+	require.Equal(t, "Coinbase", fields[tagTargetCompID])
+	require.Equal(t, "key", fields[tagUsername])
+	require.Equal(t, "passphrase", fields[tagPassword])
+	require.Equal(t, "Y", fields[tagResetSeqNumFlag])
+	require.Equal(t, applVerIDFIX50SP2, fields[tagDefaultApplVerID])
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(strings.Join([]string{
+		fields[tagSendingTime], msgLogon, "1", "key", "Coinbase", "passphrase",
+	}, "\x01")))
+	require.Equal(t, base64.StdEncoding.EncodeToString(mac.Sum(nil)), fields[tagRawData])
+	send(message{{tagMsgType, msgLogon}, {tagMsgSeqNum, "1"}})
+
+	request, err := readMessage(r)
+	require.NoError(t, err)
+	msgType, _ := request.get(tagMsgType)
+	require.Equal(t, msgMarketDataRequest, msgType)
+	require.Equal(t, []message{{{tagSymbol, "BTC-USD"}}}, request.groups(tagNoRelatedSym))
+
+	send(snapshot)
+	send(increment)
+	send(message{{tagMsgType, msgTestRequest}, {tagTestReqID, "test"}})
+
+	for {
+		msg, err := readMessage(r)
+		if err != nil {
+			return
+		}
+		switch msgType, _ := msg.get(tagMsgType); msgType {
+		case msgHeartbeat:
+			heartbeats <- msg
+		case msgLogout:
+			logouts <- msg
+			return
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	heartbeats := make(chan message, 10)
+	logouts := make(chan message, 1)
+	go serve(t, l, heartbeats, logouts)
+
+	c := newCoinbaseFix()
+	c.Log = testutil.Logger{}
+	c.ServiceAddress = "tcp://" + l.Addr().String()
+	c.ProductIds = []string{"BTC-USD"}
+	c.APIKey = "key"
+	c.APISecret = secret
+	c.APIPassphrase = "passphrase"
+	c.now = func() time.Time { return now }
+	require.NoError(t, c.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, c.Start(acc))
+
+	acc.Wait(1)
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{expectedTrade}, acc.GetTelegrafMetrics())
+
+	select {
+	case heartbeat := <-heartbeats:
+		testReqID, _ := heartbeat.get(tagTestReqID)
+		require.Equal(t, "test", testReqID)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no heartbeat answering the test request")
+	}
+
+	acc.ClearMetrics()
+	require.NoError(t, c.Gather(acc))
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{expectedBook}, acc.GetTelegrafMetrics())
+
+	c.Stop()
+	select {
+	case <-logouts:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no logout upon stopping")
+	}
+	require.NoError(t, acc.FirstError())
+}
+
+func TestInitErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		modify func(c *CoinbaseFix)
+	}{
+		{"no products", func(c *CoinbaseFix) { c.ProductIds = nil }},
+		{"no credentials", func(c *CoinbaseFix) { c.APIPassphrase = "" }},
+		{"secret", func(c *CoinbaseFix) { c.APISecret = "not base64!" }},
+		{"begin string", func(c *CoinbaseFix) { c.BeginString = "FIX.4.4" }},
+		{"heartbeat", func(c *CoinbaseFix) { c.HeartbeatInterval.Duration = 0 }},
+		{"scheme", func(c *CoinbaseFix) { c.ServiceAddress = "udp://localhost:6121" }},
+		{"schema", func(c *CoinbaseFix) { c.MetricSchema = "other" }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCoinbaseFix()
+			c.ProductIds = []string{"BTC-USD"}
+			c.APIKey = "key"
+			c.APISecret = secret
+			c.APIPassphrase = "passphrase"
+			tt.modify(c)
+			require.Error(t, c.Init())
+		})
+	}
+}
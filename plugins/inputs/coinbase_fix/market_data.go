@@ -0,0 +1,196 @@
+package coinbase_fix
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// the MDEntryType of the entries of the book and of the trades
+const (
+	entryBid   = "0"
+	entryOffer = "1"
+	entryTrade = "2"
+)
+
+// the MDUpdateAction of the entries of an incremental refresh
+const (
+	actionNew    = "0"
+	actionChange = "1"
+	actionDelete = "2"
+)
+
+// addSnapshot replaces the book of a product with the bids and offers of a
+// full refresh
+//
+// 35=W|55=BTC-USD|268=2|269=0|270=9122.04|271=0.5|269=1|270=9123.00|271=0.25
+func (c *CoinbaseFix) addSnapshot(msg message) error {
+	productId, _ := msg.get(tagSymbol)
+	b := &book{bids: make(map[float64]float64), asks: make(map[float64]float64)}
+
+	for _, entry := range msg.groups(tagNoMDEntries) {
+		entryType, _ := entry.get(tagMDEntryType)
+		if entryType != entryBid && entryType != entryOffer {
+			continue
+		}
+		price, size, err := priceAndSize(entry)
+		if err != nil {
+			return fmt.Errorf("snapshot of %s: %s", productId, err)
+		}
+		b.update(entryType, actionNew, price, size)
+	}
+
+	c.booksLock.Lock()
+	c.books[productId] = b
+	c.booksLock.Unlock()
+	return nil
+}
+
+// addIncrement applies the changes of an incremental refresh to the books,
+// emitting its trades. Every entry carries its product.
+//
+// 35=X|268=2|279=0|269=0|55=BTC-USD|270=9122.50|271=1|279=0|269=2|55=BTC-USD|270=9123.00|271=0.1|2446=1
+func (c *CoinbaseFix) addIncrement(msg message) error {
+	sendingTime, _ := msg.get(tagSendingTime)
+
+	c.booksLock.Lock()
+	defer c.booksLock.Unlock()
+
+	for _, entry := range msg.groups(tagNoMDEntries) {
+		productId, ok := entry.get(tagSymbol)
+		if !ok {
+			productId, _ = msg.get(tagSymbol)
+		}
+		entryType, _ := entry.get(tagMDEntryType)
+		action, _ := entry.get(tagMDUpdateAction)
+
+		price, size, err := priceAndSize(entry)
+		if err != nil {
+			return fmt.Errorf("refresh of %s: %s", productId, err)
+		}
+
+		switch entryType {
+		case entryBid, entryOffer:
+			b, ok := c.books[productId]
+			if !ok {
+				b = &book{bids: make(map[float64]float64), asks: make(map[float64]float64)}
+				c.books[productId] = b
+			}
+			b.update(entryType, action, price, size)
+		case entryTrade:
+			tags := map[string]string{"product_id": productId}
+			// the AggressorSide is the side of the taker
+			switch aggressor, _ := entry.get(tagAggressorSide); aggressor {
+			case "1":
+				tags["side"] = "buy"
+			case "2":
+				tags["side"] = "sell"
+			}
+			c.acc.AddFields("coinbase_fix_trade",
+				map[string]interface{}{
+					"price": price,
+					"size":  size,
+				},
+				tags,
+				c.entryTime(entry, sendingTime),
+			)
+		}
+	}
+	return nil
+}
+
+// entryTime returns the MDEntryDate and MDEntryTime of an entry, or the
+// SendingTime of its message when the entry has none
+func (c *CoinbaseFix) entryTime(entry message, sendingTime string) time.Time {
+	date, hasDate := entry.get(tagMDEntryDate)
+	clock, hasClock := entry.get(tagMDEntryTime)
+	if hasDate && hasClock {
+		if t, err := time.Parse("20060102 15:04:05", date+" "+clock); err == nil {
+			return t
+		}
+	}
+	if t, err := time.Parse("20060102-15:04:05", sendingTime); err == nil {
+		return t
+	}
+	return c.now()
+}
+
+// priceAndSize returns the MDEntryPx and MDEntrySize of an entry, the size
+// being left out of the deletes
+func priceAndSize(entry message) (float64, float64, error) {
+	px, _ := entry.get(tagMDEntryPx)
+	price, err := strconv.ParseFloat(px, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid price %q", px)
+	}
+	sz, ok := entry.get(tagMDEntrySize)
+	if !ok {
+		return price, 0, nil
+	}
+	size, err := strconv.ParseFloat(sz, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid size %q", sz)
+	}
+	return price, size, nil
+}
+
+// update sets the size of a price level, removing the level when deleted or
+// emptied
+func (b *book) update(entryType string, action string, price float64, size float64) {
+	side := b.bids
+	if entryType == entryOffer {
+		side = b.asks
+	}
+	if action == actionDelete || size == 0 {
+		delete(side, price)
+	} else {
+		side[price] = size
+	}
+}
+
+// fields returns the top of the book and the total size of each side
+func (b *book) fields() map[string]interface{} {
+	bids, asks := sortedPrices(b.bids, true), sortedPrices(b.asks, false)
+
+	fields := map[string]interface{}{
+		"bid_depth": totalSize(b.bids),
+		"ask_depth": totalSize(b.asks),
+	}
+	if len(bids) > 0 {
+		fields["best_bid"] = bids[0]
+		fields["best_bid_size"] = b.bids[bids[0]]
+	}
+	if len(asks) > 0 {
+		fields["best_ask"] = asks[0]
+		fields["best_ask_size"] = b.asks[asks[0]]
+	}
+	if len(bids) > 0 && len(asks) > 0 {
+		fields["spread"] = asks[0] - bids[0]
+		fields["mid_price"] = (asks[0] + bids[0]) / 2
+	}
+	return fields
+}
+
+// sortedPrices returns the prices of a side from the best, the highest bid
+// or the lowest ask
+func sortedPrices(side map[float64]float64, descending bool) []float64 {
+	prices := make([]float64, 0, len(side))
+	for price := range side {
+		prices = append(prices, price)
+	}
+	if descending {
+		sort.Sort(sort.Reverse(sort.Float64Slice(prices)))
+	} else {
+		sort.Float64s(prices)
+	}
+	return prices
+}
+
+func totalSize(side map[float64]float64) float64 {
+	var total float64
+	for _, size := range side {
+		total += size
+	}
+	return total
+}
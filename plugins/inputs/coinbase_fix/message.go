@@ -0,0 +1,202 @@
+package coinbase_fix
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// soh is the delimiter of the fields of a message
+const soh = '\x01'
+
+// the tags of the fields used by the session
+const (
+	tagBeginString      = 8
+	tagBodyLength       = 9
+	tagCheckSum         = 10
+	tagMsgSeqNum        = 34
+	tagMsgType          = 35
+	tagSenderCompID     = 49
+	tagSendingTime      = 52
+	tagSymbol           = 55
+	tagTargetCompID     = 56
+	tagText             = 58
+	tagRawDataLength    = 95
+	tagRawData          = 96
+	tagEncryptMethod    = 98
+	tagHeartBtInt       = 108
+	tagTestReqID        = 112
+	tagResetSeqNumFlag  = 141
+	tagNoRelatedSym     = 146
+	tagMDReqID          = 262
+	tagSubscriptionType = 263
+	tagMarketDepth      = 264
+	tagNoMDEntryTypes   = 267
+	tagNoMDEntries      = 268
+	tagMDEntryType      = 269
+	tagMDEntryPx        = 270
+	tagMDEntrySize      = 271
+	tagMDEntryDate      = 272
+	tagMDEntryTime      = 273
+	tagMDUpdateAction   = 279
+	tagUsername         = 553
+	tagPassword         = 554
+	tagDefaultApplVerID = 1137
+	tagAggressorSide    = 2446
+)
+
+// the types of the messages used by the session
+const (
+	msgHeartbeat           = "0"
+	msgTestRequest         = "1"
+	msgReject              = "3"
+	msgLogout              = "5"
+	msgLogon               = "A"
+	msgMarketDataRequest   = "V"
+	msgMarketDataSnapshot  = "W"
+	msgMarketDataIncrement = "X"
+	msgMarketDataReject    = "Y"
+)
+
+type field struct {
+	tag   int
+	value string
+}
+
+// message is the fields of a message in their order, which tells the
+// entries of repeating groups apart
+type message []field
+
+// get returns the value of the first field of a tag
+func (m message) get(tag int) (string, bool) {
+	for _, f := range m {
+		if f.tag == tag {
+			return f.value, true
+		}
+	}
+	return "", false
+}
+
+// groups returns the entries of the repeating group counted by countTag,
+// every entry starting with the tag following the count
+func (m message) groups(countTag int) []message {
+	var entries []message
+	for i, f := range m {
+		if f.tag != countTag || i+1 >= len(m) {
+			continue
+		}
+
+		delimiter := m[i+1].tag
+		for _, f := range m[i+1:] {
+			if f.tag == delimiter || len(entries) == 0 {
+				entries = append(entries, nil)
+			}
+			entries[len(entries)-1] = append(entries[len(entries)-1], f)
+		}
+		break
+	}
+	return entries
+}
+
+// String returns the message with "|" as the delimiter, for logging, the
+// passphrase and signature of a logon redacted
+func (m message) String() string {
+	parts := make([]string, 0, len(m))
+	for _, f := range m {
+		value := f.value
+		if f.tag == tagPassword || f.tag == tagRawData {
+			value = "<redacted>"
+		}
+		parts = append(parts, strconv.Itoa(f.tag)+"="+value)
+	}
+	return strings.Join(parts, "|")
+}
+
+// encode returns the message on the wire, framing the body, the message
+// type and header followed by the fields of the message, with the begin
+// string, body length and checksum
+func encode(beginString string, body message) []byte {
+	var b bytes.Buffer
+	for _, f := range body {
+		b.WriteString(strconv.Itoa(f.tag))
+		b.WriteByte('=')
+		b.WriteString(f.value)
+		b.WriteByte(soh)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "%d=%s%c%d=%d%c", tagBeginString, beginString, soh, tagBodyLength, b.Len(), soh)
+	msg.Write(b.Bytes())
+	fmt.Fprintf(&msg, "%d=%03d%c", tagCheckSum, checksum(msg.Bytes()), soh)
+	return msg.Bytes()
+}
+
+// checksum is the sum of the bytes of a message up to its checksum, modulo
+// 256
+func checksum(b []byte) int {
+	var sum int
+	for _, c := range b {
+		sum += int(c)
+	}
+	return sum % 256
+}
+
+// readMessage reads the next message, checking its body length and
+// checksum. The begin string, body length and checksum are left out of the
+// fields returned.
+func readMessage(r *bufio.Reader) (message, error) {
+	begin, err := r.ReadBytes(soh)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(begin, []byte("8=")) {
+		return nil, fmt.Errorf("expected the begin string, got %q", begin)
+	}
+	length, err := r.ReadBytes(soh)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(length, []byte("9=")) {
+		return nil, fmt.Errorf("expected the body length, got %q", length)
+	}
+	n, err := strconv.Atoi(string(length[2 : len(length)-1]))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid body length %q", length[2:len(length)-1])
+	}
+
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	trailer, err := r.ReadBytes(soh)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(trailer, []byte("10=")) {
+		return nil, fmt.Errorf("expected the checksum, got %q", trailer)
+	}
+	sum, err := strconv.Atoi(string(trailer[3 : len(trailer)-1]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid checksum %q", trailer[3:len(trailer)-1])
+	}
+	if expected := checksum(append(append(begin, length...), body...)); sum != expected {
+		return nil, fmt.Errorf("checksum %d, expected %d", sum, expected)
+	}
+
+	var msg message
+	for _, part := range bytes.Split(bytes.TrimSuffix(body, []byte{soh}), []byte{soh}) {
+		i := bytes.IndexByte(part, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid field %q", part)
+		}
+		tag, err := strconv.Atoi(string(part[:i]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag of field %q", part)
+		}
+		msg = append(msg, field{tag: tag, value: string(part[i+1:])})
+	}
+	return msg, nil
+}
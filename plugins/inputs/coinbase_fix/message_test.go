@@ -0,0 +1,79 @@
+package coinbase_fix
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	msg := encode("FIX.4.2", message{{tagMsgType, msgHeartbeat}, {tagMsgSeqNum, "2"}})
+	require.Equal(t, "8=FIX.4.2\x019=10\x0135=0\x0134=2\x0110=164\x01", string(msg))
+}
+
+func TestReadMessage(t *testing.T) {
+	body := message{
+		{tagMsgType, msgMarketDataSnapshot},
+		{tagSymbol, "BTC-USD"},
+		{tagNoMDEntries, "2"},
+		{tagMDEntryType, entryBid},
+		{tagMDEntryPx, "9122.04"},
+		{tagMDEntryType, entryOffer},
+		{tagMDEntryPx, "9123.00"},
+	}
+	r := bufio.NewReader(bytes.NewReader(append(encode("FIXT.1.1", body), encode("FIXT.1.1", body)...)))
+
+	for i := 0; i < 2; i++ {
+		msg, err := readMessage(r)
+		require.NoError(t, err)
+		require.Equal(t, body, msg)
+	}
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	valid := string(encode("FIX.4.2", message{{tagMsgType, msgHeartbeat}}))
+
+	for _, tt := range []struct {
+		name string
+		msg  string
+	}{
+		{"begin string", strings.Replace(valid, "8=", "7=", 1)},
+		{"body length", strings.Replace(valid, "9=5", "9=x", 1)},
+		{"checksum", valid[:len(valid)-4] + "999\x01"},
+		{"field", "8=FIX.4.2\x019=3\x0135\x0110=050\x01"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readMessage(bufio.NewReader(strings.NewReader(tt.msg)))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestGroups(t *testing.T) {
+	msg := message{
+		{tagMsgType, msgMarketDataIncrement},
+		{tagNoMDEntries, "2"},
+		{tagMDUpdateAction, actionNew},
+		{tagMDEntryType, entryBid},
+		{tagMDUpdateAction, actionDelete},
+		{tagMDEntryType, entryOffer},
+	}
+	require.Equal(t, []message{
+		{{tagMDUpdateAction, actionNew}, {tagMDEntryType, entryBid}},
+		{{tagMDUpdateAction, actionDelete}, {tagMDEntryType, entryOffer}},
+	}, msg.groups(tagNoMDEntries))
+	require.Empty(t, msg.groups(tagNoRelatedSym))
+}
+
+func TestStringRedactsCredentials(t *testing.T) {
+	m := message{
+		{tagMsgType, msgLogon},
+		{tagRawDataLength, "4"},
+		{tagRawData, "c2ln"},
+		{tagPassword, "passphrase"},
+	}
+	require.Equal(t, "35=A|95=4|96=<redacted>|554=<redacted>", m.String())
+}
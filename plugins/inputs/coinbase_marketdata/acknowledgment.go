@@ -0,0 +1,224 @@
+package coinbase_marketdata
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	defaultSubscriptionTimeout = 5 * time.Second
+	defaultSubscriptionRetries = 2
+)
+
+// errSubscriptionIncomplete is reported when the acknowledgment of the
+// subscription still lacks products or channels after subscription_retries
+var errSubscriptionIncomplete = errors.New("subscription incomplete")
+
+// subscriptions are the products subscribed to per channel. A channel
+// without products is subscribed to as a whole, e.g. the heartbeats of the
+// advanced trade feed.
+type subscriptions map[string]map[string]bool
+
+func (s subscriptions) add(channel string, productIds ...string) {
+	if s[channel] == nil {
+		s[channel] = make(map[string]bool)
+	}
+	for _, productId := range productIds {
+		s[channel][productId] = true
+	}
+}
+
+func (s subscriptions) merge(other subscriptions) {
+	for channel, productIds := range other {
+		s.add(channel)
+		for productId := range productIds {
+			s[channel][productId] = true
+		}
+	}
+}
+
+// missing returns the subscriptions of s not listed in acked, in the format
+// of "ticker BTC-USDX", sorted
+func (s subscriptions) missing(acked subscriptions) []string {
+	var missing []string
+	for channel, productIds := range s {
+		ackedIds, ok := acked[channel]
+		if !ok {
+			missing = append(missing, channel)
+			continue
+		}
+		if len(ackedIds) == 0 {
+			continue
+		}
+		for productId := range productIds {
+			if !ackedIds[productId] {
+				missing = append(missing, channel+" "+productId)
+			}
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// requestedSubscriptions returns the subscriptions requested by subscribe
+// messages of either feed, including the channel objects of an
+// on_connect_msg, e.g.
+// {"type": "subscribe", "product_ids": ["ETH-USD"], "channels": ["level2", {"name": "ticker", "product_ids": ["BTC-USD"]}]}
+// {"type": "subscribe", "product_ids": ["ETH-USD"], "channel": "level2"}
+func requestedSubscriptions(msgs [][]byte) subscriptions {
+	requested := make(subscriptions)
+	for _, msg := range msgs {
+		if gjson.GetBytes(msg, "type").String() != "subscribe" {
+			continue
+		}
+		productIds := stringArray(gjson.GetBytes(msg, "product_ids"))
+
+		if channel := gjson.GetBytes(msg, "channel"); channel.Exists() {
+			requested.add(channel.String(), productIds...)
+			continue
+		}
+		for _, channel := range gjson.GetBytes(msg, "channels").Array() {
+			if !channel.IsObject() {
+				requested.add(channel.String(), productIds...)
+				continue
+			}
+			ids := productIds
+			if own := channel.Get("product_ids"); own.Exists() {
+				ids = stringArray(own)
+			}
+			requested.add(channel.Get("name").String(), ids...)
+		}
+	}
+	return requested
+}
+
+// acknowledgedSubscriptions returns the subscriptions listed by a
+// subscriptions message of either feed, in the format of
+// {"type": "subscriptions", "channels": [{"name": "level2", "product_ids": ["ETH-USD"]}]}
+// {"channel": "subscriptions", "events": [{"subscriptions": {"level2": ["ETH-USD"]}}]}
+// and whether message is one
+func acknowledgedSubscriptions(message []byte) (subscriptions, bool) {
+	results := gjson.GetManyBytes(message, "type", "channel")
+	acked := make(subscriptions)
+
+	switch {
+	case results[0].String() == "subscriptions":
+		for _, channel := range gjson.GetBytes(message, "channels").Array() {
+			acked.add(channel.Get("name").String(), stringArray(channel.Get("product_ids"))...)
+		}
+	case results[1].String() == "subscriptions":
+		for _, event := range gjson.GetBytes(message, "events").Array() {
+			event.Get("subscriptions").ForEach(func(channel, productIds gjson.Result) bool {
+				acked.add(channel.String(), stringArray(productIds)...)
+				return true
+			})
+		}
+	default:
+		return nil, false
+	}
+	return acked, true
+}
+
+func stringArray(result gjson.Result) []string {
+	var values []string
+	for _, value := range result.Array() {
+		values = append(values, value.String())
+	}
+	return values
+}
+
+// awaitSubscription reads the answers to the subscription of c until acks
+// subscriptions messages were received, or with acks 0 until any message
+// was, failing when the server rejected the subscription with an error
+// message or did not answer within subscription_timeout. It returns the
+// subscriptions acknowledged. The messages read are left for onConnect.
+func (wsl *WebSocketListener) awaitSubscription(c *connection, acks int) (subscriptions, error) {
+	conn := c.get()
+	defer wsl.resetDeadline(conn)
+
+	acked := make(subscriptions)
+	_ = conn.SetReadDeadline(time.Now().Add(wsl.SubscriptionTimeout.Duration))
+	for {
+		message, err := c.client.ReadMessage(conn)
+		if err != nil {
+			return nil, fmt.Errorf("awaiting the answer to the subscription: %w", err)
+		}
+		c.unread = append(c.unread, message)
+
+		results := gjson.GetManyBytes(message, "type", "message", "reason")
+		if results[0].String() == "error" {
+			return nil, fmt.Errorf("%w: %s: %s", errSubscriptionRejected, results[1].String(), results[2].String())
+		}
+
+		if subscribed, ok := acknowledgedSubscriptions(message); ok {
+			acked.merge(subscribed)
+			acks--
+		}
+		if acks <= 0 {
+			return acked, nil
+		}
+	}
+}
+
+// verifySubscription checks that the server acknowledged every product and
+// channel subscribed to by c, resubscribing up to subscription_retries times
+// when some are missing, e.g. for a misspelled product id. Read errors are
+// returned, an incomplete or rejected subscription is recorded in c and
+// reported, leaving c connected with the products that were acknowledged.
+func (wsl *WebSocketListener) verifySubscription(c *connection) error {
+	c.subscribeErr = nil
+
+	msgs, err := wsl.subscriptionMessagesFor(c.products())
+	if err != nil {
+		return err
+	}
+	requested := requestedSubscriptions(msgs)
+	if len(requested) == 0 {
+		return nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		acked, err := wsl.awaitSubscription(c, len(msgs))
+		if errors.Is(err, errSubscriptionRejected) {
+			// the error message itself is reported by the read loop
+			c.subscribeErr = err
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		missing := requested.missing(acked)
+		if len(missing) == 0 {
+			return nil
+		}
+		if attempt >= wsl.SubscriptionRetries {
+			c.subscribeErr = fmt.Errorf("%w on %s connection to %s, not acknowledged: %s",
+				errSubscriptionIncomplete, c.name, c.getAddress(), strings.Join(missing, ", "))
+			wsl.AddError(c.subscribeErr)
+			return nil
+		}
+
+		wsl.Log.Warnf("Subscription on %s connection not acknowledged for %s, resubscribing",
+			c.name, strings.Join(missing, ", "))
+		if err := wsl.subscribe(c.get(), c.products()); err != nil {
+			return err
+		}
+	}
+}
+
+// validateSubscriptionAck checks the settings of verify_subscription
+func (wsl *WebSocketListener) validateSubscriptionAck() error {
+	if wsl.SubscriptionTimeout.Duration <= 0 {
+		return fmt.Errorf("invalid subscription_timeout %s, must be positive", wsl.SubscriptionTimeout.Duration)
+	}
+	if wsl.SubscriptionRetries < 0 {
+		return fmt.Errorf("invalid subscription_retries %d, must not be negative", wsl.SubscriptionRetries)
+	}
+	return nil
+}
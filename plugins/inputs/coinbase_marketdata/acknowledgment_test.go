@@ -0,0 +1,99 @@
+package coinbase_marketdata
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+const proSubscriptionsAck = `{"type": "subscriptions", "channels": [{"name": "ticker", "product_ids": ["ETH-USD"]}]}`
+
+func TestRequestedSubscriptionsMissingFromAck(t *testing.T) {
+	requested := requestedSubscriptions([][]byte{
+		[]byte(`{"type": "subscribe", "product_ids": ["ETH-USD", "BTC-USDX"], "channels": ["ticker", {"name": "level2", "product_ids": ["ETH-USD"]}]}`),
+	})
+	acked, ok := acknowledgedSubscriptions([]byte(proSubscriptionsAck))
+	require.True(t, ok)
+	require.Equal(t, []string{"level2", "ticker BTC-USDX"}, requested.missing(acked))
+
+	requested = requestedSubscriptions([][]byte{
+		[]byte(`{"type": "subscribe", "product_ids": ["ETH-USD"], "channel": "ticker"}`),
+		[]byte(`{"type": "subscribe", "product_ids": ["ETH-USD"], "channel": "heartbeats"}`),
+	})
+	acked, ok = acknowledgedSubscriptions([]byte(`{"channel": "subscriptions", "events": [{"subscriptions": {"ticker": ["ETH-USD"], "heartbeats": []}}]}`))
+	require.True(t, ok)
+	require.Empty(t, requested.missing(acked))
+
+	_, ok = acknowledgedSubscriptions([]byte(proTicker))
+	require.False(t, ok)
+}
+
+// newAckServer answers every subscription with ack, counting the
+// subscriptions received
+func newAckServer(t *testing.T, ack string, subscriptions chan<- struct{}) string {
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+			subscriptions <- struct{}{}
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(ack))
+		}
+	})
+	return wsURL(ts)
+}
+
+func TestVerifySubscriptionReportsMissingProducts(t *testing.T) {
+	subscriptions := make(chan struct{}, 10)
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = newAckServer(t, proSubscriptionsAck, subscriptions)
+	wsl.ProductIds = []string{"ETH-USD", "BTC-USDX"}
+	wsl.Channels = []string{"ticker"}
+	wsl.VerifySubscription = true
+	wsl.SubscriptionRetries = 1
+	require.NoError(t, wsl.Init())
+	require.NoError(t, wsl.Start(acc))
+	defer wsl.Stop()
+
+	// subscribed once more before giving up
+	require.Len(t, subscriptions, 2)
+	require.True(t, errors.Is(acc.FirstError(), errSubscriptionIncomplete))
+	require.Contains(t, acc.FirstError().Error(), "ticker BTC-USDX")
+}
+
+func TestVerifySubscriptionComplete(t *testing.T) {
+	subscriptions := make(chan struct{}, 10)
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = newAckServer(t, proSubscriptionsAck, subscriptions)
+	wsl.ProductIds = []string{"ETH-USD"}
+	wsl.Channels = []string{"ticker"}
+	wsl.VerifySubscription = true
+	wsl.FailOnSubscribeError = true
+	require.NoError(t, wsl.Init())
+	require.NoError(t, wsl.Start(acc))
+	defer wsl.Stop()
+
+	require.Len(t, subscriptions, 1)
+	require.NoError(t, acc.FirstError())
+}
+
+func TestVerifySubscriptionFailsStart(t *testing.T) {
+	subscriptions := make(chan struct{}, 10)
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = newAckServer(t, proSubscriptionsAck, subscriptions)
+	wsl.ProductIds = []string{"BTC-USDX"}
+	wsl.Channels = []string{"ticker"}
+	wsl.VerifySubscription = true
+	wsl.FailOnSubscribeError = true
+	wsl.SubscriptionRetries = 0
+	require.NoError(t, wsl.Init())
+
+	err := wsl.Start(acc)
+	require.Error(t, err)
+	require.True(t, errors.Is(err, errSubscriptionIncomplete))
+}
@@ -0,0 +1,122 @@
+package coinbase_marketdata
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jwtLifetime is how long the JWT of an advanced trade subscription is valid,
+// it only needs to outlive the subscribe message
+const jwtLifetime = 2 * time.Minute
+
+// authenticateAdvanced adds the credentials of the Advanced Trade feed to a
+// subscribe message. Cloud API keys, whose api_secret is an EC private key,
+// sign a JWT; legacy keys sign the timestamp, channel and product ids.
+func (wsl *WebSocketListener) authenticateAdvanced(subscription map[string]interface{}, now time.Time) error {
+	if strings.Contains(wsl.APISecret, "PRIVATE KEY") {
+		token, err := signJWT(wsl.APIKey, wsl.APISecret, now)
+		if err != nil {
+			return err
+		}
+		subscription["jwt"] = token
+		return nil
+	}
+
+	var productIds []string
+	if ids, ok := subscription["product_ids"].([]interface{}); ok {
+		for _, id := range ids {
+			productIds = append(productIds, fmt.Sprintf("%v", id))
+		}
+	}
+	channel := fmt.Sprintf("%v", subscription["channel"])
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(wsl.APISecret))
+	mac.Write([]byte(timestamp + channel + strings.Join(productIds, ",")))
+
+	subscription["api_key"] = wsl.APIKey
+	subscription["timestamp"] = timestamp
+	subscription["signature"] = hex.EncodeToString(mac.Sum(nil))
+	return nil
+}
+
+// parseECPrivateKey parses a PEM encoded SEC 1 or PKCS #8 EC private key
+func parseECPrivateKey(secret string) (*ecdsa.PrivateKey, error) {
+	// keys pasted into a config file often have escaped newlines
+	block, _ := pem.Decode([]byte(strings.ReplaceAll(secret, `\n`, "\n")))
+	if block == nil {
+		return nil, errors.New("invalid api_secret: no PEM encoded private key")
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid api_secret: %w", err)
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("invalid api_secret: not an EC private key")
+	}
+	return ecKey, nil
+}
+
+// signJWT returns an ES256 signed JWT authenticating the api key keyName
+func signJWT(keyName string, secret string, now time.Time) (string, error) {
+	key, err := parseECPrivateKey(secret)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(map[string]interface{}{
+		"alg":   "ES256",
+		"typ":   "JWT",
+		"kid":   keyName,
+		"nonce": hex.EncodeToString(nonce),
+	})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss": "cdp",
+		"sub": keyName,
+		"nbf": now.Unix(),
+		"exp": now.Add(jwtLifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	// ES256 signatures are the fixed size big endian r and s concatenated
+	size := (key.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
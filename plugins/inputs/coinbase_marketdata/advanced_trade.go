@@ -0,0 +1,245 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	apiVersionAuto     = ""
+	apiVersionPro      = "pro"
+	apiVersionAdvanced = "advanced"
+)
+
+// isAdvancedTrade reports whether a message uses the Advanced Trade envelope,
+// i.e. {"channel": "...", "events": [...]}
+func (wsl *WebSocketListener) isAdvancedTrade(marketData map[string]interface{}) bool {
+	switch wsl.APIVersion {
+	case apiVersionAdvanced:
+		return true
+	case apiVersionPro:
+		return false
+	}
+
+	_, hasChannel := marketData["channel"]
+	_, hasEvents := marketData["events"]
+	return hasChannel && hasEvents
+}
+
+// advancedFeed reports whether the plugin is connected to the Advanced Trade
+// feed, either configured by api_version or detected from service_address
+func (wsl *WebSocketListener) advancedFeed() bool {
+	return wsl.APIVersion == apiVersionAdvanced ||
+		(wsl.APIVersion == apiVersionAuto && strings.Contains(wsl.ServiceAddress, "advanced-trade"))
+}
+
+// normalizes the timestamps of the advanced trade feed to UTC, keeping
+// their nanoseconds
+func advancedTime(v interface{}) string {
+	t, err := parseTime(fmt.Sprintf("%v", v))
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// takes in a map of an advanced trade message in the format of
+// {
+//  "channel": "ticker",
+//  "client_id": "",
+//  "timestamp": "2023-02-09T20:30:37.167359596Z",
+//  "sequence_num": 0,
+//  "events": [
+//    {
+//      "type": "snapshot",
+//      "tickers": [
+//        {
+//          "type": "ticker",
+//          "product_id": "BTC-USD",
+//          "price": "21932.98",
+//          "volume_24_h": "16038.28770938",
+//          "low_24_h": "21835.29",
+//          "high_24_h": "23011.18",
+//          "low_52_w": "15460",
+//          "high_52_w": "48240",
+//          "price_percent_chg_24_h": "-4.15775596190603",
+//          "best_bid": "21932.97",
+//          "best_ask": "21932.98"
+//        }
+//      ]
+//    }
+//  ]
+// }
+// and maps it onto the same output types as the legacy pro feed
+func (wsl *WebSocketListener) parseAdvanced(envelope map[string]interface{}) ([]*Ticker, []L2Update) {
+	var tickers []*Ticker
+	var updates []L2Update
+
+	events, _ := envelope["events"].([]interface{})
+	channel := fmt.Sprintf("%v", envelope["channel"])
+	timestamp := advancedTime(envelope["timestamp"])
+	sequenceId, _ := parseInt(envelope["sequence_num"])
+
+	for _, e := range events {
+		event, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		switch channel {
+		case "ticker", "ticker_batch":
+			tickerData, _ := event["tickers"].([]interface{})
+			for _, t := range tickerData {
+				ticker, ok := t.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				tickers = append(tickers, wsl.parseAdvancedTicker(ticker, timestamp, sequenceId))
+			}
+		case "l2_data":
+			updates = append(updates, wsl.parseAdvancedL2Update(event)...)
+		}
+	}
+
+	return tickers, updates
+}
+
+func (wsl *WebSocketListener) parseAdvancedTicker(tickerData map[string]interface{}, timestamp string, sequenceId int64) *Ticker {
+	price, _ := parseFloat(tickerData["price"])
+	volume24H, _ := parseFloat(tickerData["volume_24_h"])
+	low24H, _ := parseFloat(tickerData["low_24_h"])
+	high24H, _ := parseFloat(tickerData["high_24_h"])
+	bestBid, _ := parseFloat(tickerData["best_bid"])
+	bestAsk, _ := parseFloat(tickerData["best_ask"])
+	spread, spreadBps := bidAskSpread(bestBid, bestAsk)
+
+	return wsl.withMidPrices(&Ticker{
+		DataType:   "ticker",
+		ProductId:  fmt.Sprintf("%v", tickerData["product_id"]),
+		Time:       timestamp,
+		Price:      price,
+		Volume24H:  volume24H,
+		Low24H:     low24H,
+		High24H:    high24H,
+		BestBid:    bestBid,
+		BestAsk:    bestAsk,
+		SequenceId: sequenceId,
+
+		BestBidSize: optionalFloat(tickerData["best_bid_quantity"]),
+		BestAskSize: optionalFloat(tickerData["best_ask_quantity"]),
+		Spread:      spread,
+		SpreadBps:   spreadBps,
+
+		text: wsl.decimalText(tickerData, advancedTickerDecimals),
+	})
+}
+
+// takes in a single l2_data event in the format of
+// {
+//  "type": "update",
+//  "product_id": "BTC-USD",
+//  "updates": [
+//    {
+//      "side": "bid",
+//      "event_time": "1970-01-01T00:00:00Z",
+//      "price_level": "21921.73",
+//      "new_quantity": "0.06317902"
+//    }
+//  ]
+// }
+func (wsl *WebSocketListener) parseAdvancedL2Update(event map[string]interface{}) []L2Update {
+	var updates []L2Update
+
+	changes, _ := event["updates"].([]interface{})
+	for _, c := range changes {
+		change, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		// the advanced trade feed uses bid/offer rather than buy/sell
+		side := "sell"
+		if change["side"] == "bid" {
+			side = "buy"
+		}
+
+		price, _ := parseFloat(change["price_level"])
+		qty, _ := parseFloat(change["new_quantity"])
+
+		updates = append(updates, L2Update{
+			DataType:  "l2update",
+			ProductId: fmt.Sprintf("%v", event["product_id"]),
+			Time:      advancedTime(change["event_time"]),
+			Side:      side,
+			Price:     price,
+			Qty:       qty,
+			text: wsl.decimalText(map[string]interface{}{"price": change["price_level"], "qty": change["new_quantity"]},
+				l2UpdateDecimals),
+		})
+	}
+
+	return updates
+}
+
+// takes in a map of an advanced trade market_trades message in the format of
+// {
+//  "channel": "market_trades",
+//  "timestamp": "2023-02-09T20:19:35.39625135Z",
+//  "sequence_num": 0,
+//  "events": [
+//    {
+//      "type": "snapshot",
+//      "trades": [
+//        {
+//          "trade_id": "000000000",
+//          "product_id": "ETH-USD",
+//          "price": "1260.01",
+//          "size": "0.3",
+//          "side": "BUY",
+//          "time": "2019-08-14T20:42:27.265Z"
+//        }
+//      ]
+//    }
+//  ]
+// }
+// and maps every trade onto the match type of the legacy pro feed
+func (wsl *WebSocketListener) parseAdvancedTrades(envelope map[string]interface{}) []*Match {
+	var matches []*Match
+
+	events, _ := envelope["events"].([]interface{})
+	sequenceId, _ := parseInt(envelope["sequence_num"])
+
+	for _, e := range events {
+		event, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		trades, _ := event["trades"].([]interface{})
+		for _, t := range trades {
+			trade, ok := t.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			price, _ := parseFloat(trade["price"])
+			size, _ := parseFloat(trade["size"])
+			tradeId, _ := parseInt(trade["trade_id"])
+
+			matches = append(matches, &Match{
+				DataType:   "match",
+				ProductId:  fmt.Sprintf("%v", trade["product_id"]),
+				Side:       strings.ToLower(fmt.Sprintf("%v", trade["side"])),
+				Time:       advancedTime(trade["time"]),
+				Price:      price,
+				Size:       size,
+				TradeId:    tradeId,
+				SequenceId: sequenceId,
+				text:       wsl.decimalText(trade, matchDecimals),
+			})
+		}
+	}
+
+	return matches
+}
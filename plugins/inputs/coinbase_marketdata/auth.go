@@ -0,0 +1,65 @@
+package coinbase_marketdata
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// the request signed to authenticate websocket subscriptions
+const (
+	authMethod = "GET"
+	authPath   = "/users/self/verify"
+)
+
+// sign returns the CB-ACCESS-SIGN signature of a request, the base64 encoded
+// HMAC-SHA256 of timestamp + method + path + body keyed with the base64
+// decoded api secret
+func sign(secret string, timestamp string, method string, path string, body string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return "", fmt.Errorf("invalid api_secret: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp + method + path + body))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// authenticate adds the credentials to a subscribe message, which is
+// required for the user channel, and returns the message unchanged without
+// an api_key
+func (wsl *WebSocketListener) authenticate(msg []byte, now time.Time) ([]byte, error) {
+	if wsl.APIKey == "" {
+		return msg, nil
+	}
+
+	subscription := make(map[string]interface{})
+	if err := json.Unmarshal(msg, &subscription); err != nil {
+		return nil, fmt.Errorf("unable to authenticate subscription: %w", err)
+	}
+
+	if wsl.advancedFeed() {
+		if err := wsl.authenticateAdvanced(subscription, now); err != nil {
+			return nil, err
+		}
+		return json.Marshal(subscription)
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature, err := sign(wsl.APISecret, timestamp, authMethod, authPath, "")
+	if err != nil {
+		return nil, err
+	}
+
+	subscription["key"] = wsl.APIKey
+	subscription["passphrase"] = wsl.APIPassphrase
+	subscription["timestamp"] = timestamp
+	subscription["signature"] = signature
+
+	return json.Marshal(subscription)
+}
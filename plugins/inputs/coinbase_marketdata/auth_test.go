@@ -0,0 +1,62 @@
+package coinbase_marketdata
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticateSubscription(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString([]byte("secret"))
+
+	wsl, _ := newTestListener(t)
+	wsl.ProductIds = []string{"BTC-USD"}
+	wsl.Channels = []string{"user"}
+	wsl.APIKey = "key"
+	wsl.APISecret = secret
+	wsl.APIPassphrase = "passphrase"
+	require.NoError(t, wsl.Init())
+
+	msgs, err := wsl.subscriptionMessages()
+	require.NoError(t, err)
+	msg, err := wsl.authenticate(msgs[0], time.Unix(1609459200, 0))
+	require.NoError(t, err)
+
+	subscription := make(map[string]interface{})
+	require.NoError(t, json.Unmarshal(msg, &subscription))
+	require.Equal(t, "subscribe", subscription["type"])
+	require.Equal(t, []interface{}{"user"}, subscription["channels"])
+	require.Equal(t, "key", subscription["key"])
+	require.Equal(t, "passphrase", subscription["passphrase"])
+	require.Equal(t, "1609459200", subscription["timestamp"])
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1609459200GET/users/self/verify"))
+	require.Equal(t, base64.StdEncoding.EncodeToString(mac.Sum(nil)), subscription["signature"])
+}
+
+func TestAuthenticateWithoutKey(t *testing.T) {
+	wsl, _ := newTestListener(t)
+
+	msg, err := wsl.authenticate([]byte(`{"type": "subscribe"}`), time.Now())
+	require.NoError(t, err)
+	require.Equal(t, `{"type": "subscribe"}`, string(msg))
+}
+
+func TestAuthenticateInvalidSecret(t *testing.T) {
+	wsl, _ := newTestListener(t)
+	wsl.APIKey = "key"
+	wsl.APISecret = "not base64!"
+	wsl.APIPassphrase = "passphrase"
+
+	_, err := wsl.authenticate([]byte(`{"type": "subscribe"}`), time.Now())
+	require.Error(t, err)
+
+	wsl.APIPassphrase = ""
+	require.Error(t, wsl.Init())
+}
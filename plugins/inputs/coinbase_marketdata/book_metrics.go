@@ -0,0 +1,67 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+	"time"
+)
+
+// emitBooks emits one coinbase_book metric per product with the top of its
+// book, and the cumulative size of the best book_depths levels of each side
+// and their imbalance
+func (wsl *WebSocketListener) emitBooks(now time.Time) {
+	maxDepth := 1
+	for _, depth := range wsl.BookDepths {
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+	}
+
+	for _, productId := range wsl.books.productIds() {
+		bids, ok := wsl.books.levels(productId, "buy", maxDepth)
+		if !ok {
+			continue
+		}
+		asks, _ := wsl.books.levels(productId, "sell", maxDepth)
+
+		fields := make(map[string]interface{})
+		if len(bids) > 0 {
+			fields["best_bid"] = bids[0].Price
+			fields["best_bid_size"] = bids[0].Size
+		}
+		if len(asks) > 0 {
+			fields["best_ask"] = asks[0].Price
+			fields["best_ask_size"] = asks[0].Size
+		}
+		if len(bids) > 0 && len(asks) > 0 {
+			fields["spread"] = asks[0].Price - bids[0].Price
+			fields["mid_price"] = (asks[0].Price + bids[0].Price) / 2
+		}
+		for _, depth := range wsl.BookDepths {
+			bidDepth, askDepth := cumulativeSize(bids, depth), cumulativeSize(asks, depth)
+			fields[fmt.Sprintf("bid_depth_%d", depth)] = bidDepth
+			fields[fmt.Sprintf("ask_depth_%d", depth)] = askDepth
+			if bidDepth+askDepth > 0 {
+				fields[fmt.Sprintf("imbalance_%d", depth)] = (bidDepth - askDepth) / (bidDepth + askDepth)
+			}
+		}
+
+		wsl.AddFields("coinbase_book", fields,
+			map[string]string{
+				"product_id": productId,
+			},
+			now,
+		)
+	}
+}
+
+// cumulativeSize returns the total size of the best depth price levels
+func cumulativeSize(levels []PriceLevel, depth int) float64 {
+	var size float64
+	for i, level := range levels {
+		if i >= depth {
+			break
+		}
+		size += level.Size
+	}
+	return size
+}
@@ -0,0 +1,284 @@
+package coinbase_marketdata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultRestAddress    = "https://api.pro.coinbase.com"
+	defaultVerifyInterval = time.Minute
+	defaultVerifyDepth    = 10
+)
+
+// BookDivergenceError is reported when the order book reconstructed from the
+// websocket feed no longer matches the book served by the REST api
+type BookDivergenceError struct {
+	ProductId   string
+	Depth       int
+	Divergences int
+}
+
+func (e *BookDivergenceError) Error() string {
+	return fmt.Sprintf("order book of %s diverged from the REST snapshot at %d of the top %d levels per side",
+		e.ProductId, e.Divergences, e.Depth)
+}
+
+// the response of the /products/{id}/book?level=2 REST endpoint
+type restBook struct {
+	Sequence int64         `json:"sequence"`
+	Bids     []interface{} `json:"bids"`
+	Asks     []interface{} `json:"asks"`
+}
+
+func (wsl *WebSocketListener) fetchBook(ctx context.Context, productId string) (*restBook, error) {
+	url := fmt.Sprintf("%s/products/%s/book?level=2", wsl.RestAddress, productId)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := wsl.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+
+	book := &restBook{}
+	if err := json.NewDecoder(resp.Body).Decode(book); err != nil {
+		return nil, fmt.Errorf("decoding %s: %s", url, err)
+	}
+	return book, nil
+}
+
+// bookState is the top of the book of a product as of a sequence of its
+// messages
+type bookState struct {
+	sequence int64
+	bids     []PriceLevel
+	asks     []PriceLevel
+}
+
+// bookWatch records the states of the book of a product while its REST
+// snapshot is fetched, until the state at the sequence of the snapshot is
+// reached
+type bookWatch struct {
+	productId string
+	depth     int
+
+	// the states recorded while the sequence of the snapshot is unknown,
+	// then the sequence awaited
+	states []bookState
+	target int64
+
+	reached chan bookState
+}
+
+// advance records that the messages of a product reached sequence, handing
+// the state of its book to the watches awaiting that sequence. The level2
+// messages carry no sequence, those of the ticker, matches and full channels
+// tell how far the feed got.
+func (s *bookStore) advance(productId string, sequence int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if sequence <= s.sequences[productId] {
+		return
+	}
+	s.sequences[productId] = sequence
+
+	book, ok := s.books[productId]
+	if !ok {
+		return
+	}
+	watches := s.watches[:0]
+	for _, w := range s.watches {
+		if w.productId != productId {
+			watches = append(watches, w)
+			continue
+		}
+		state := bookState{
+			sequence: sequence,
+			bids:     book.levels("buy", w.depth),
+			asks:     book.levels("sell", w.depth),
+		}
+		if w.target == 0 {
+			w.states = append(w.states, state)
+			watches = append(watches, w)
+			continue
+		}
+		if sequence < w.target {
+			watches = append(watches, w)
+			continue
+		}
+		w.reached <- state
+	}
+	s.watches = watches
+}
+
+// watch starts recording the states of the book of a product, along with
+// its current state, or returns nil when the product has no book
+func (s *bookStore) watch(productId string, depth int) *bookWatch {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	book, ok := s.books[productId]
+	if !ok {
+		return nil
+	}
+	w := &bookWatch{
+		productId: productId,
+		depth:     depth,
+		states: []bookState{{
+			sequence: s.sequences[productId],
+			bids:     book.levels("buy", depth),
+			asks:     book.levels("sell", depth),
+		}},
+		reached: make(chan bookState, 1),
+	}
+	s.watches = append(s.watches, w)
+	return w
+}
+
+// await sets the sequence w awaits, handing the first state recorded at or
+// after it to w.reached right away
+func (s *bookStore) await(w *bookWatch, sequence int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	states := w.states
+	w.states = nil
+	w.target = sequence
+	for _, state := range states {
+		if state.sequence >= sequence {
+			w.reached <- state
+			s.remove(w)
+			return
+		}
+	}
+}
+
+// forget stops recording the states of w
+func (s *bookStore) forget(w *bookWatch) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.remove(w)
+}
+
+// remove drops w from the watches, called with the store locked
+func (s *bookStore) remove(w *bookWatch) {
+	for i, watch := range s.watches {
+		if watch == w {
+			s.watches = append(s.watches[:i], s.watches[i+1:]...)
+			return
+		}
+	}
+}
+
+// countDivergences compares two sides of a book level by level
+func countDivergences(local []PriceLevel, remote []PriceLevel, depth int) int {
+	divergences := 0
+	for i := 0; i < depth; i++ {
+		switch {
+		case i >= len(local) && i >= len(remote):
+			return divergences
+		case i >= len(local) || i >= len(remote):
+			divergences++
+		case local[i] != remote[i]:
+			divergences++
+		}
+	}
+	return divergences
+}
+
+// startVerification verifies the books in the background, unless the
+// previous verification is still running, giving up on those which don't
+// reach the sequence of their snapshot within verify_interval
+func (wsl *WebSocketListener) startVerification() {
+	if !atomic.CompareAndSwapInt32(&wsl.verifying, 0, 1) {
+		return
+	}
+
+	wsl.wg.Add(1)
+	go func() {
+		defer wsl.wg.Done()
+		defer atomic.StoreInt32(&wsl.verifying, 0)
+
+		ctx, cancel := context.WithTimeout(wsl.ctx, wsl.VerifyInterval.Duration)
+		defer cancel()
+		wsl.verifyBooks(ctx)
+	}()
+}
+
+// verifyBooks checks every maintained book against a fresh REST snapshot.
+// The feed and the REST api are not in step, so the top verify_depth levels
+// of a book are compared as of the sequence of the snapshot.
+func (wsl *WebSocketListener) verifyBooks(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, productId := range wsl.books.productIds() {
+		wg.Add(1)
+		go func(productId string) {
+			defer wg.Done()
+			err := wsl.verifyBook(ctx, productId)
+			if err != nil && wsl.ctx.Err() == nil {
+				wsl.AddError(fmt.Errorf("unable to verify order book of %s: %s", productId, err))
+			}
+		}(productId)
+	}
+	wg.Wait()
+}
+
+func (wsl *WebSocketListener) verifyBook(ctx context.Context, productId string) error {
+	// watched before fetching, as the feed may be ahead of the REST api
+	w := wsl.books.watch(productId, wsl.VerifyDepth)
+	if w == nil {
+		return nil
+	}
+	defer wsl.books.forget(w)
+
+	remote, err := wsl.fetchBook(ctx, productId)
+	if err != nil {
+		return err
+	}
+	wsl.books.await(w, remote.Sequence)
+
+	var local bookState
+	select {
+	case local = <-w.reached:
+	case <-ctx.Done():
+		return fmt.Errorf("the feed did not reach sequence %d of the snapshot: %s", remote.Sequence, ctx.Err())
+	}
+
+	divergences := countDivergences(local.bids, parsePriceLevels(remote.Bids), wsl.VerifyDepth) +
+		countDivergences(local.asks, parsePriceLevels(remote.Asks), wsl.VerifyDepth)
+
+	consistent := 1
+	if divergences > 0 {
+		consistent = 0
+		wsl.AddError(&BookDivergenceError{
+			ProductId:   productId,
+			Depth:       wsl.VerifyDepth,
+			Divergences: divergences,
+		})
+	}
+
+	wsl.AddFields("coinbase_book_check",
+		map[string]interface{}{
+			"book_consistent": consistent,
+			"divergences":     divergences,
+		},
+		map[string]string{
+			"product_id": productId,
+		},
+	)
+	return nil
+}
@@ -0,0 +1,154 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	candleEmptySkip = "skip"
+	candleEmptyFlat = "flat"
+)
+
+// candle is the OHLCV aggregate of the trades of one product in one
+// candle_interval. A candle without trades is a flat placeholder carrying the
+// previous close.
+type candle struct {
+	start  time.Time
+	open   float64
+	high   float64
+	low    float64
+	close  float64
+	volume float64
+	trades int64
+}
+
+func (c *candle) add(price float64, size float64) {
+	if c.trades == 0 {
+		c.open, c.high, c.low = price, price, price
+	}
+	if price > c.high {
+		c.high = price
+	}
+	if price < c.low {
+		c.low = price
+	}
+	c.close = price
+	c.volume += size
+	c.trades++
+}
+
+// tradeOf extracts the price, size and time of a trade from ticker and
+// match messages
+func tradeOf(marketData map[string]interface{}) (price float64, size float64, ts time.Time, ok bool) {
+	switch marketData["type"] {
+	case "ticker":
+		size, _ = parseFloat(marketData["last_size"])
+	case "match", "last_match":
+		size, _ = parseFloat(marketData["size"])
+	default:
+		return 0, 0, ts, false
+	}
+
+	price, err := parseFloat(marketData["price"])
+	if err != nil {
+		return 0, 0, ts, false
+	}
+
+	ts, err = parseTime(fmt.Sprintf("%v", marketData["time"]))
+	if err != nil {
+		ts = time.Now()
+	}
+
+	return price, size, ts, true
+}
+
+// addTrade adds the trade of a ticker or match message to the current candle
+// of its product, closing the candles of all intervals before it. The ticker
+// and matches channels both carry every trade, so the tickers are ignored
+// when the matches are subscribed to.
+func (wsl *WebSocketListener) addTrade(marketData map[string]interface{}) {
+	if wsl.candlesFromMatches && marketData["type"] == "ticker" {
+		return
+	}
+
+	price, size, ts, ok := tradeOf(marketData)
+	if !ok {
+		return
+	}
+
+	productId := fmt.Sprintf("%v", marketData["product_id"])
+	bucket := ts.Truncate(wsl.CandleInterval.Duration)
+
+	wsl.candlesMutex.Lock()
+	defer wsl.candlesMutex.Unlock()
+
+	wsl.closeCandles(productId, bucket)
+
+	c, ok := wsl.candles[productId]
+	if !ok {
+		c = &candle{start: bucket}
+		wsl.candles[productId] = c
+	}
+	if bucket.Before(c.start) {
+		// the candle of a trade delivered this late has already been emitted
+		return
+	}
+	c.add(price, size)
+}
+
+// closeCandles emits the candle of a product if it started before bucket.
+// Intervals without trades up to bucket are either skipped or emitted as
+// flat candles, depending on candle_empty_intervals.
+func (wsl *WebSocketListener) closeCandles(productId string, bucket time.Time) {
+	c, ok := wsl.candles[productId]
+	for ok && c.start.Before(bucket) {
+		if c.trades > 0 || wsl.CandleEmptyIntervals == candleEmptyFlat {
+			wsl.emitCandle(productId, c)
+		}
+
+		if wsl.CandleEmptyIntervals != candleEmptyFlat {
+			delete(wsl.candles, productId)
+			return
+		}
+
+		c = &candle{
+			start: c.start.Add(wsl.CandleInterval.Duration),
+			open:  c.close,
+			high:  c.close,
+			low:   c.close,
+			close: c.close,
+		}
+		wsl.candles[productId] = c
+	}
+}
+
+func (wsl *WebSocketListener) emitCandle(productId string, c *candle) {
+	wsl.AddFields("coinbase_candle",
+		map[string]interface{}{
+			"open":   c.open,
+			"high":   c.high,
+			"low":    c.low,
+			"close":  c.close,
+			"volume": c.volume,
+			"trades": c.trades,
+		},
+		map[string]string{
+			"product_id": productId,
+		},
+		c.start,
+	)
+}
+
+// flushCandles emits the candles of every product whose interval ended
+// before now, so that candles close even when no further trades arrive
+func (wsl *WebSocketListener) flushCandles(now time.Time) {
+	bucket := now.Truncate(wsl.CandleInterval.Duration)
+
+	wsl.candlesMutex.Lock()
+	defer wsl.candlesMutex.Unlock()
+
+	for productId := range wsl.candles {
+		wsl.closeCandles(productId, bucket)
+	}
+}
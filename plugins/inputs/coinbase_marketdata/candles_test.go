@@ -0,0 +1,134 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func match(productId string, price string, size string, ts string) []byte {
+	return []byte(fmt.Sprintf(`{"type": "match", "product_id": %q, "price": %q, "size": %q, "side": "buy", "time": %q}`,
+		productId, price, size, ts))
+}
+
+func candleFields(open, high, low, close, volume float64, trades int64) map[string]interface{} {
+	return map[string]interface{}{
+		"open":   open,
+		"high":   high,
+		"low":    low,
+		"close":  close,
+		"volume": volume,
+		"trades": trades,
+	}
+}
+
+func candleAt(t *testing.T, acc *testutil.Accumulator, start time.Time) map[string]interface{} {
+	for _, m := range acc.Metrics {
+		if m.Measurement == "coinbase_candle" && m.Time.Equal(start) {
+			return m.Fields
+		}
+	}
+	require.Failf(t, "missing candle", "no candle starting at %s", start)
+	return nil
+}
+
+func candleStarts(acc *testutil.Accumulator) []time.Time {
+	var starts []time.Time
+	for _, m := range acc.Metrics {
+		if m.Measurement == "coinbase_candle" {
+			starts = append(starts, m.Time)
+		}
+	}
+	return starts
+}
+
+func TestCandlesCloseOnNextInterval(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.CandleInterval.Duration = time.Minute
+	require.NoError(t, wsl.Init())
+
+	wsl.addMetric(match("ETH-USD", "100", "1", "2020-12-28T23:54:01.000000Z"))
+	wsl.addMetric(match("ETH-USD", "105", "0.5", "2020-12-28T23:54:20.000000Z"))
+	wsl.addMetric(match("ETH-USD", "95", "2", "2020-12-28T23:54:40.000000Z"))
+	wsl.addMetric(match("ETH-USD", "101", "1", "2020-12-28T23:54:59.000000Z"))
+	require.False(t, acc.HasMeasurement("coinbase_candle"))
+
+	wsl.addMetric(match("ETH-USD", "102", "1", "2020-12-28T23:55:01.000000Z"))
+
+	start := time.Date(2020, 12, 28, 23, 54, 0, 0, time.UTC)
+	require.Equal(t, candleFields(100, 105, 95, 101, 4.5, 4), candleAt(t, acc, start))
+	acc.AssertContainsTaggedFields(t, "coinbase_candle", candleFields(100, 105, 95, 101, 4.5, 4),
+		map[string]string{"product_id": "ETH-USD"})
+}
+
+func TestCandlesTickerLastSize(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.CandleInterval.Duration = time.Minute
+	require.NoError(t, wsl.Init())
+
+	wsl.addMetric([]byte(proTicker))
+	wsl.flushCandles(time.Date(2020, 12, 28, 23, 55, 0, 0, time.UTC))
+
+	start := time.Date(2020, 12, 28, 23, 54, 0, 0, time.UTC)
+	require.Equal(t, candleFields(731.99, 731.99, 731.99, 731.99, 0.24169456, 1), candleAt(t, acc, start))
+}
+
+func TestCandlesFromMatchesOnly(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.ProductIds = []string{"ETH-USD"}
+	wsl.Channels = []string{"ticker", "matches"}
+	wsl.CandleInterval.Duration = time.Minute
+	require.NoError(t, wsl.Init())
+
+	// the same trade, sent on both channels
+	wsl.addMetric([]byte(proTicker))
+	wsl.addMetric(match("ETH-USD", "731.99", "0.24169456", "2020-12-28T23:54:24.617734Z"))
+	wsl.flushCandles(time.Date(2020, 12, 28, 23, 55, 0, 0, time.UTC))
+
+	start := time.Date(2020, 12, 28, 23, 54, 0, 0, time.UTC)
+	require.Equal(t, candleFields(731.99, 731.99, 731.99, 731.99, 0.24169456, 1), candleAt(t, acc, start))
+}
+
+func TestCandlesSkipEmptyIntervals(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.CandleInterval.Duration = time.Minute
+	require.NoError(t, wsl.Init())
+
+	wsl.addMetric(match("ETH-USD", "100", "1", "2020-12-28T23:54:01.000000Z"))
+	wsl.addMetric(match("ETH-USD", "110", "1", "2020-12-28T23:57:01.000000Z"))
+	wsl.flushCandles(time.Date(2020, 12, 28, 23, 58, 0, 0, time.UTC))
+
+	require.Equal(t, []time.Time{
+		time.Date(2020, 12, 28, 23, 54, 0, 0, time.UTC),
+		time.Date(2020, 12, 28, 23, 57, 0, 0, time.UTC),
+	}, candleStarts(acc))
+}
+
+func TestCandlesFlatEmptyIntervals(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.CandleInterval.Duration = time.Minute
+	wsl.CandleEmptyIntervals = candleEmptyFlat
+	require.NoError(t, wsl.Init())
+
+	wsl.addMetric(match("ETH-USD", "100", "1", "2020-12-28T23:54:01.000000Z"))
+	wsl.addMetric(match("ETH-USD", "110", "1", "2020-12-28T23:56:30.000000Z"))
+	wsl.flushCandles(time.Date(2020, 12, 28, 23, 57, 30, 0, time.UTC))
+
+	require.Equal(t, candleFields(100, 100, 100, 100, 1, 1),
+		candleAt(t, acc, time.Date(2020, 12, 28, 23, 54, 0, 0, time.UTC)))
+	require.Equal(t, candleFields(100, 100, 100, 100, 0, 0),
+		candleAt(t, acc, time.Date(2020, 12, 28, 23, 55, 0, 0, time.UTC)))
+	require.Equal(t, candleFields(110, 110, 110, 110, 1, 1),
+		candleAt(t, acc, time.Date(2020, 12, 28, 23, 56, 0, 0, time.UTC)))
+	// the running interval is not emitted yet
+	require.Len(t, candleStarts(acc), 3)
+}
+
+func TestInvalidCandleEmptyIntervals(t *testing.T) {
+	wsl, _ := newTestListener(t)
+	wsl.CandleEmptyIntervals = "zero"
+	require.Error(t, wsl.Init())
+}
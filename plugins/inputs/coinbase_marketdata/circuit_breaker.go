@@ -0,0 +1,50 @@
+package coinbase_marketdata
+
+import (
+	"time"
+
+	"github.com/influxdata/telegraf/internal/wsclient"
+)
+
+const connectionStateFailed = "failed"
+
+// retryDelay returns the delay before the next reconnect attempt of c after
+// cause broke the connection and failures consecutive attempts failed. When
+// rate limited it waits rate_limit_backoff instead of the usual delay so as
+// not to make the rate limiting worse. Every failover_after failed attempts c
+// moves on to the next endpoint of service_addresses.
+func (wsl *WebSocketListener) retryDelay(c *connection, cause error, failures int) time.Duration {
+	if failures > 0 && wsl.FailoverAfter > 0 && failures%wsl.FailoverAfter == 0 {
+		wsl.rotate(c)
+	}
+
+	delay := wsl.backoff(failures)
+	if reason, ok := wsl.takeRateLimit(cause); ok {
+		wsl.emitRateLimited(reason)
+		delay = wsl.RateLimitBackoff.Duration
+	}
+	return delay
+}
+
+// backoff returns the delay before a reconnect attempt after failures
+// consecutive failed attempts, doubling reconnect_interval on every failure
+// up to max_backoff
+func (wsl *WebSocketListener) backoff(failures int) time.Duration {
+	return wsclient.Backoff(wsl.ReconnectInterval.Duration, wsl.MaxBackoff.Duration, failures)
+}
+
+// trip reports the terminal state once the client of a connection gave up
+// reconnecting on a permanent failure or after max_reconnect_attempts
+// consecutive failed attempts
+func (wsl *WebSocketListener) trip(cause error, failures int) {
+	wsl.AddFields("coinbase_connection",
+		map[string]interface{}{
+			"state":                connectionStateFailed,
+			"consecutive_failures": failures,
+			"permanent":            wsclient.IsPermanent(cause),
+		},
+		map[string]string{
+			"service_address": wsl.ServiceAddress,
+		},
+	)
+}
@@ -1,16 +1,37 @@
 package coinbase_marketdata
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/wsclient"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/plugins/common/tls"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/influxdata/telegraf/plugins/parsers"
+	"github.com/influxdata/telegraf/selfstat"
 	"io"
-	"log"
-	"strconv"
+	"net/http"
 	"sync"
+	"time"
+)
+
+// the resolutions timestamp_units may truncate timestamps to
+var timestampPrecisions = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+// the sources timestamp_source may take the time of a metric from
+const (
+	timestampSourceExchange = "exchange"
+	timestampSourceLocal    = "local"
 )
 
 type Ticker struct {
@@ -29,6 +50,21 @@ type Ticker struct {
 	Size       float64 `json:"last_size"`
 	SequenceId int64   `json:"sequence_id"`
 	TradeId    int64   `json:"trade_id"`
+
+	// only sent on some channels, omitted rather than emitted as zero
+	BestBidSize *float64 `json:"best_bid_size,omitempty"`
+	BestAskSize *float64 `json:"best_ask_size,omitempty"`
+
+	// derived from the best bid and ask, omitted when either is missing
+	Spread    *float64 `json:"spread,omitempty"`
+	SpreadBps *float64 `json:"spread_bps,omitempty"`
+
+	// derived with mid_prices, the micro price from the sizes too
+	MidPrice   *float64 `json:"mid_price,omitempty"`
+	MicroPrice *float64 `json:"micro_price,omitempty"`
+
+	// the decimal values as received, kept for price_format
+	text map[string]string
 }
 
 type L2Update struct {
@@ -38,62 +74,562 @@ type L2Update struct {
 	Price     float64 `json:"price"`
 	Qty       float64 `json:"qty"`
 	Time      string  `json:"time"`
+
+	// the decimal values as received, kept for price_format
+	text map[string]string
+}
+
+// connection is one of the websocket connections to the server, the primary
+// and optionally a warm standby
+type connection struct {
+	name   string
+	client *wsclient.Client
+
+	mutex sync.Mutex
+	up    bool
+
+	// the endpoint of service_addresses connected to, and its index
+	address  string
+	endpoint int
+
+	// the products subscribed to, and the messages buffered while this is
+	// the standby, guarded by failoverMutex
+	shard   *shard
+	pending []inbound
+
+	// messages read while awaiting the answer to the subscription, the
+	// failure of the last subscription verified, and whether a subscription
+	// succeeded, only accessed by the client of the connection
+	unread       [][]byte
+	subscribeErr error
+	subscribed   bool
+
+	// the last sequence number received per product and channel, only
+	// accessed by the connection's read loop
+	sequences map[string]int64
+}
+
+// get returns the current websocket connection, nil while none is up
+func (c *connection) get() *websocket.Conn {
+	if c.client == nil {
+		return nil
+	}
+	return c.client.Conn()
+}
+
+func (c *connection) getAddress() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.address
+}
+
+// products returns the products c subscribes to, nil for all of product_ids
+func (c *connection) products() []string {
+	if c.shard == nil {
+		return nil
+	}
+	return c.shard.products
+}
+
+func (c *connection) setUp(up bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.up = up
+}
+
+func (c *connection) isUp() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.up
 }
 
 type WebSocketListener struct {
-	ServiceAddress string `toml:"service_address"`
-	OnConnectMsg   string `toml:"on_connect_msg"`
+	Environment      string   `toml:"environment"`
+	ServiceAddress   string   `toml:"service_address"`
+	ServiceAddresses []string `toml:"service_addresses"`
+	FailoverAfter    int      `toml:"failover_after"`
+	OnConnectMsg     string   `toml:"on_connect_msg"`
+	APIVersion       string   `toml:"api_version"`
+
+	ProductIds []string `toml:"product_ids"`
+	Channels   []string `toml:"channels"`
+
+	APIKey        string `toml:"api_key"`
+	APISecret     string `toml:"api_secret"`
+	APIPassphrase string `toml:"api_passphrase"`
+
+	TagChannel   bool   `toml:"tag_channel"`
+	CurrencyTags bool   `toml:"currency_tags"`
+	MetricSchema string `toml:"metric_schema"`
+	MidPrices    bool   `toml:"mid_prices"`
+	ParseWorkers int    `toml:"parse_workers"`
+	Ordered      bool   `toml:"ordered"`
+	QueueSize    int    `toml:"queue_size"`
+	QueueFull    string `toml:"queue_full"`
+	Standby      bool   `toml:"standby"`
+
+	MaxProductsPerConnection int               `toml:"max_products_per_connection"`
+	FailOnSubscribeError     bool              `toml:"fail_on_subscribe_error"`
+	VerifySubscription       bool              `toml:"verify_subscription"`
+	SubscriptionTimeout      internal.Duration `toml:"subscription_timeout"`
+	SubscriptionRetries      int               `toml:"subscription_retries"`
+
+	JSONQueryByType        map[string]string `toml:"json_query_by_type"`
+	ProductSampleIntervals map[string]string `toml:"product_sample_intervals"`
+	FieldRename            map[string]string `toml:"field_rename"`
+	MeasurementPrefix      string            `toml:"measurement_prefix"`
+	MeasurementByType      map[string]string `toml:"measurement_by_type"`
+	ExtraTags              map[string]string `toml:"extra_tags"`
+
+	TimestampUnits  string   `toml:"timestamp_units"`
+	TimestampSource string   `toml:"timestamp_source"`
+	MetricTagKeys   []string `toml:"metric_tag_keys"`
+
+	PriceFormat        string         `toml:"price_format"`
+	PriceScale         int            `toml:"price_scale"`
+	ProductPriceScales map[string]int `toml:"product_price_scales"`
+
+	MaxSilence           internal.Duration `toml:"max_silence"`
+	HeartbeatTimeout     internal.Duration `toml:"heartbeat_timeout"`
+	HeartbeatResubscribe bool              `toml:"heartbeat_resubscribe"`
+	PingInterval         internal.Duration `toml:"ping_interval"`
+	PongWait             internal.Duration `toml:"pong_wait"`
+	ReconnectInterval    internal.Duration `toml:"reconnect_interval"`
+	MaxBackoff           internal.Duration `toml:"max_backoff"`
+	MaxReconnectAttempts int               `toml:"max_reconnect_attempts"`
+	ResyncOnGap          bool              `toml:"resync_on_gap"`
+	RateLimitBackoff     internal.Duration `toml:"rate_limit_backoff"`
+	MaxDowntime          internal.Duration `toml:"max_downtime"`
+	DowntimeWindow       internal.Duration `toml:"downtime_window"`
+	RestFallbackAfter    internal.Duration `toml:"rest_fallback_after"`
+
+	CandleInterval       internal.Duration `toml:"candle_interval"`
+	CandleEmptyIntervals string            `toml:"candle_empty_intervals"`
+	TickerMinInterval    internal.Duration `toml:"ticker_min_interval"`
+	DedupTrades          bool              `toml:"dedup_trades"`
+	L2UpdateWindow       internal.Duration `toml:"l2update_window"`
+	L2UpdatePriceBucket  float64           `toml:"l2update_price_bucket"`
+
+	OrderBook    bool              `toml:"order_book"`
+	BookDepths   []int             `toml:"book_depths"`
+	BookInterval internal.Duration `toml:"book_interval"`
+
+	RestAddress    string            `toml:"rest_address"`
+	VerifyBook     bool              `toml:"verify_book"`
+	VerifyInterval internal.Duration `toml:"verify_interval"`
+	VerifyDepth    int               `toml:"verify_depth"`
 
-	done chan bool
+	GapFill          bool `toml:"gap_fill"`
+	GapFillMaxTrades int  `toml:"gap_fill_max_trades"`
 
-	conn *websocket.Conn
-	wg   sync.WaitGroup
+	Enrich bool `toml:"enrich"`
+
+	HealthAddress    string            `toml:"health_address"`
+	HealthMaxSilence internal.Duration `toml:"health_max_silence"`
+
+	StateFile string `toml:"state_file"`
+
+	RecordFile                string            `toml:"record_file"`
+	RecordRotationInterval    internal.Duration `toml:"record_rotation_interval"`
+	RecordRotationMaxSize     internal.Size     `toml:"record_rotation_max_size"`
+	RecordRotationMaxArchives int               `toml:"record_rotation_max_archives"`
+
+	ReplayFile  string  `toml:"replay_file"`
+	ReplaySpeed float64 `toml:"replay_speed"`
+
+	tls.ClientConfig
+
+	HTTPProxyURL  string `toml:"http_proxy_url"`
+	Socks5Proxy   string `toml:"socks5_proxy"`
+	ProxyUsername string `toml:"proxy_username"`
+	ProxyPassword string `toml:"proxy_password"`
+
+	EnableCompression  bool   `toml:"enable_compression"`
+	MessageCompression string `toml:"message_compression"`
+
+	MaxMessageSize internal.Size `toml:"max_message_size"`
+
+	Log telegraf.Logger `toml:"-"`
+
+	// cancelled by Stop, ending the routines of the plugin
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	parserMutex sync.Mutex
+	precision   time.Duration
+
+	shards        map[string]*shard
+	emitted       *recentSet
+	failoverMutex sync.Mutex
+
+	sampleIntervals map[string]time.Duration
+	lastSampled     map[string]time.Time
+	sampleMutex     sync.Mutex
+
+	throttled     map[string]*throttledTicker
+	throttleMutex sync.Mutex
+
+	rateLimitReason string
+	rateLimitMutex  sync.Mutex
+
+	outages   []outage
+	downSince time.Time
+
+	disconnectedSince time.Time
+	fallbackActive    bool
+	fallbackMutex     sync.Mutex
+	downtimeAlerted   bool
+	downtimeMutex     sync.Mutex
+
+	candles      map[string]*candle
+	candlesMutex sync.Mutex
+	// whether the trades of the candles are taken from the match messages
+	// only, rather than from the ticker messages as well
+	candlesFromMatches bool
+
+	lastTradeIds map[string]int64
+	tradesMutex  sync.Mutex
+
+	lastMatchIds map[string]int64
+	gaps         chan tradeGap
+	gapMutex     sync.Mutex
+
+	recorder      io.WriteCloser
+	recordFailing bool
+	recordMutex   sync.Mutex
+
+	productStates  map[string]*productState
+	restoredStates map[string]*productState
+	stateMutex     sync.Mutex
+
+	l2Windows map[string]*l2Window
+	l2Mutex   sync.Mutex
+
+	lastTickers  map[string]*Ticker
+	tickersMutex sync.Mutex
+
+	heartbeats      map[string]*heartbeatState
+	heartbeatsMutex sync.Mutex
+
+	books            *bookStore
+	httpClient       *http.Client
+	normalizer       *marketdata.Normalizer
+	lastVerification time.Time
+	verifying        int32
+	lastBookEmit     time.Time
+
+	dialer      *websocket.Dialer
+	connections []*connection
+	wg          sync.WaitGroup
+
+	queues          []chan inbound
+	workersWg       sync.WaitGroup
+	reportedDropped int64
+
+	lastMessage  time.Time
+	messageMutex sync.Mutex
+	healthServer *http.Server
+
+	lastProductMessage map[string]time.Time
+	silenceMutex       sync.Mutex
+
+	parseErrors      selfstat.Stat
+	droppedMessages  selfstat.Stat
+	timestampErrors  selfstat.Stat
+	duplicateTrades  selfstat.Stat
+	backfilledTrades selfstat.Stat
 
 	// Mixins
 	parsers.Parser
 	telegraf.Accumulator
-	io.Closer
 }
 
 // The telegraf Input Interface Implementation
 
 func (wsl *WebSocketListener) SampleConfig() string {
 	return `
-## Websocket URL to connect to
-service_address = "wss://ws-feed.pro.coinbase.com"
+## Coinbase environment, either "production" or "sandbox", whose websocket
+## feed and REST api are used unless service_address and rest_address are set
+# environment = "production"
+## Websocket URL to connect to, defaults to the feed of the environment
+# service_address = "wss://ws-feed.pro.coinbase.com"
+## Endpoints to fail over between, e.g. the public feed and an internal
+## relay, taking precedence over service_address. A connection moves on to
+## the next endpoint after failover_after consecutive failed reconnects, and
+## metrics are tagged with the endpoint they were received from.
+# service_addresses = ["wss://ws-feed.pro.coinbase.com", "wss://relay.example.com"]
+# failover_after = 3
+## Feed schema, one of "pro" or "advanced" (wss://advanced-trade-ws.coinbase.com).
+## When left empty the schema is detected from the shape of each message.
+# api_version = ""
+## Products and channels to subscribe to
+product_ids = ["ETH-USD"]
+channels = ["level2", "heartbeat", "ticker"]
+## API key used to sign the subscription, required for the authenticated
+## "user" channel of your own orders and fills
+# api_key = ""
+# api_secret = ""
+# api_passphrase = ""
+## Tags of the ticker, l2update and match metrics, which are built directly
+## rather than by the parser configured below. Every other value of these
+## messages becomes a field.
+# metric_tag_keys = ["type", "product_id", "side"]
+## How prices, sizes and volumes of these metrics are emitted: as "float",
+## as the "string" received, or "scaled" to exact integers named <field>_int,
+## e.g. price_int = price × 10^price_scale. The scale of single products can
+## be set in product_price_scales.
+# price_format = "float"
+# price_scale = 8
+## Tag every metric with the channel it was received on, derived from the
+## message type, e.g. l2update and snapshot messages are tagged "level2"
+# tag_channel = false
+## Tag every metric of a product with the base_currency and quote_currency
+## of its product_id, e.g. ETH and USD for ETH-USD
+# currency_tags = false
+## Emit the tickers, matches and books in the "native" schema of the plugin,
+## or the "normalized" schema shared by the exchange inputs, which requires
+## "product_id" and "side" among the metric_tag_keys
+# metric_schema = "native"
+## Add the mid_price of the best bid and ask to the tickers, and their
+## micro_price weighted by the best bid and ask sizes when the ticker has them
+# mid_prices = false
+## Number of workers parsing messages, defaults to the number of CPUs. The
+## messages of a product are always parsed by the same worker, in order.
+# parse_workers = 0
+## Parse and emit every message in the order received, across products, with
+## a single worker. Required when consumers depend on the relative order of
+## the messages of different products or channels.
+# ordered = false
+## Messages buffered per worker, and what to do when a worker's queue is
+## full: "block" reading from the connection until the worker catches up,
+## or "drop" the message, breaking order books and candles
+# queue_size = 1024
+# queue_full = "block"
+## Truncate the timestamps of the metrics parsed from messages to "ns", "us",
+## "ms" or "s", for outputs that can't store nanoseconds
+# timestamp_units = "ns"
+## Timestamp the metrics parsed from messages with the time of the event
+## given by the exchange, "exchange", or with the time the message was
+## received, "local"
+# timestamp_source = "exchange"
+## Keep a second, subscribed standby connection whose messages are only
+## emitted once the primary connection fails, for failover without a gap
+# standby = false
+## Emit a coinbase_heartbeat_missed event when the heartbeat channel stops
+## delivering heartbeats of a product for heartbeat_timeout, and with
+## heartbeat_resubscribe resubscribe. "0s" disables the check.
+# heartbeat_timeout = "0s"
+# heartbeat_resubscribe = false
+## Fail to start when the server answers the initial subscription with an
+## error message, e.g. for an unknown product. Errors of the server are
+## always reported as errors and coinbase_error metrics.
+# fail_on_subscribe_error = false
+## Check that the subscriptions acknowledgment of the server lists every
+## product and channel subscribed to within subscription_timeout,
+## resubscribing up to subscription_retries times before reporting the
+## missing ones as an error, e.g. for a misspelled product id.
+# verify_subscription = false
+# subscription_timeout = "5s"
+# subscription_retries = 2
+## Prepend to the measurement names of the metrics parsed from messages,
+## e.g. "cb_" for cb_ticker. measurement_by_type takes precedence.
+# measurement_prefix = ""
+## Split product_ids over several connections of at most this many
+## products each, every connection reconnecting on its own. 0 subscribes all
+## products over a single connection.
+# max_products_per_connection = 0
+## Ping the server every ping_interval and reconnect when neither a pong nor
+## a message was received for pong_wait, to detect half-open connections.
+## A ping_interval of "0s" disables the keepalive.
+# ping_interval = "10s"
+# pong_wait = "20s"
+## Resubscribe when no message was received for a product for max_silence,
+## as the feed occasionally stops sending a product over a live connection.
+## Emits a coinbase_stale metric per silent product. Checked on every
+## collection interval, "0s" disables the watchdog.
+# max_silence = "0s"
+## Delay before reconnecting after the connection dropped, doubled after
+## every failed attempt up to max_backoff
+# reconnect_interval = "1s"
+# max_backoff = "1m"
+## Resubscribe, receiving fresh snapshots, when a gap or out of order
+## delivery is detected in the sequence numbers of a product. Gaps are always
+## reported as coinbase_sequence_gap metrics.
+# resync_on_gap = true
+## Give up reconnecting after this many consecutive failed attempts, 0 retries
+## forever. Authentication failures stop reconnecting immediately.
+# max_reconnect_attempts = 0
+## How long to wait before reconnecting after being disconnected for
+## subscribing to too many products or reconnecting too often
+# rate_limit_backoff = "1m"
+## Emit a coinbase_downtime_exceeded metric when the time spent disconnected
+## within any downtime_window exceeds max_downtime, while still reconnecting.
+## A max_downtime of "0s" disables the alert.
+# max_downtime = "0s"
+# downtime_window = "1h"
+## Once disconnected for rest_fallback_after, poll the ticker of every
+## product of product_ids from the REST api on every collection interval,
+## tagged source=rest, until a connection is up again. "0s" disables polling.
+# rest_fallback_after = "0s"
+## Build OHLCV candles per product from the trades of the matches channel,
+## or of the ticker channel when matches isn't subscribed to, and emit them
+## as coinbase_candle metrics when each candle_interval closes.
+## Intervals without trades are either "skip"ped or emitted as "flat" candles
+## at the previous close.
+# candle_interval = "0s"
+# candle_empty_intervals = "skip"
+## Emit at most one ticker per product every ticker_min_interval, the latest
+## one received, instead of every ticker. "0s" emits every ticker.
+# ticker_min_interval = "0s"
+## Drop the ticker and match messages, and advanced trade market_trades,
+## of trades already received, as replayed by the feed after a reconnect
+# dedup_trades = false
+## Instead of a metric per level2 update, emit coinbase_l2_summary metrics
+## counting the updates and summing the size changes per product, side and
+## price bucket of l2update_price_bucket every l2update_window. A price
+## bucket of 0 summarizes every price level on its own.
+# l2update_window = "0s"
+# l2update_price_bucket = 0.0
+## Maintain the order book of every product from the snapshot and updates of
+## the level2 channel. Also enabled by verify_book and enrich.
+# order_book = false
+## Emit a coinbase_book metric per product with the top of the book, and the
+## cumulative size of the best book_depths levels of each side and their
+## imbalance, every book_interval. An interval of "0s" emits on every collection interval.
+# book_depths = [5, 10, 25]
+# book_interval = "0s"
+## Coinbase REST api, used to verify the order book and to fill gaps,
+## defaults to the REST api of the environment
+# rest_address = "https://api.pro.coinbase.com"
+## Periodically compare the order book reconstructed from the level2 channel
+## against a fresh REST snapshot, comparing the top verify_depth levels per side
+## once the feed reached the sequence of the snapshot, as told by the ticker,
+## matches or full channel, giving up after verify_interval
+# verify_book = false
+# verify_interval = "1m"
+# verify_depth = 10
+## Fetch the trades missing from the match messages of the matches channel
+## from the REST api and emit them as match metrics at the time of the trade,
+## at most gap_fill_max_trades of every gap. Pro feed only.
+# gap_fill = false
+# gap_fill_max_trades = 1000
+## Emit a coinbase_market metric per product every interval, combining the
+## latest ticker with the top of the order book of the level2 channel
+# enrich = false
+## Serve a liveness probe at http://<health_address>/, answering 503 when
+## disconnected or when no message was received for health_max_silence
+# health_address = ":8080"
+# health_max_silence = "1m"
+## Save the last sequence number and trade id of every product to this file
+## on every gather and on shutdown, so that after a restart of the agent a
+## coinbase_restart_gap metric reports the data missed in between
+# state_file = "/var/lib/telegraf/coinbase_marketdata.json"
+## Archive every websocket frame as received, before parsing, as a line of
+## JSON with its receive time and connection. The file is rotated every
+## record_rotation_interval and when larger than record_rotation_max_size,
+## keeping record_rotation_max_archives rotated files, -1 keeping all.
+# record_file = "/var/lib/telegraf/coinbase_frames.ndjson"
+# record_rotation_interval = "24h"
+# record_rotation_max_size = "100MB"
+# record_rotation_max_archives = 5
+## Instead of connecting, replay the frames recorded by record_file in the
+## files matching this pattern, the least recently modified first, at their
+## original receive time. A replay_speed of 2.0 replays twice as fast as
+## received, 0.0 as fast as possible.
+# replay_file = "/var/lib/telegraf/coinbase_frames*.ndjson"
+# replay_speed = 1.0
+## Optional TLS Config, e.g. for TLS intercepting proxies or relays with
+## self-signed certificates
+# tls_ca = "/etc/telegraf/ca.pem"
+# tls_cert = "/etc/telegraf/cert.pem"
+# tls_key = "/etc/telegraf/key.pem"
+## Use TLS but skip chain & host verification
+# insecure_skip_verify = false
+## Connect through an HTTP proxy, or a SOCKS5 proxy given as host:port,
+## optionally authenticating with proxy_username and proxy_password. Without
+## either the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables apply.
+# http_proxy_url = "http://proxy.example.com:3128"
+# socks5_proxy = "proxy.example.com:1080"
+# proxy_username = ""
+# proxy_password = ""
+## Negotiate the permessage-deflate compression of the messages on the
+## websocket handshake, cutting the bandwidth of the level2 and full channels.
+## Uncompressed messages are received when the server declines.
+# enable_compression = false
+## Compression of the payload of the binary messages, e.g. of a relay, "none",
+## "gzip" or "deflate". Text messages are parsed as they are.
+# message_compression = "none"
+## Maximum size of a message, as received and once decompressed, e.g. "16MB",
+## above the largest level2 snapshot. A larger message is reported as an
+## error and breaks the connection, which is re-established. 0 is unbounded.
+# max_message_size = "0"
 data_format = "json"
-json_name_key = "type"
-json_time_key = "time"
-json_time_format = "2006-01-02T15:04:05.000000Z"
-tag_keys = [
-	"type", 
-	"product_id", 
-	"side"
-]
-json_string_fields = [
-	"type", 
-	"product_id", 
-	"side"
-]
-json_query = ".changes"
-on_connect_msg = '''
-{ 
-	"type": "subscribe", 
-	"product_ids": [ 
-		"ETH-USD" 
-	], 
-	"channels": [ 
-		"level2", 
-		"heartbeat", 
-		{ 
-			"name": "ticker", 
-			"product_ids": [ 
-				"ETH-USD" 
-			] 
-		} 
-	] 
-}
-'''
+## The parser settings only apply to the message types of
+## json_query_by_type, the ticker, l2update and match metrics are built
+## directly, tagged with metric_tag_keys
+# json_name_key = "type"
+# json_time_key = "time"
+# json_time_format = "2006-01-02T15:04:05.999999999Z07:00"
+# tag_keys = ["type", "product_id", "side"]
+# json_string_fields = ["type", "product_id", "side"]
+## Advanced: the raw subscription message sent upon connecting, overriding
+## product_ids and channels
+# on_connect_msg = '''
+# {
+# 	"type": "subscribe",
+# 	"product_ids": [
+# 		"ETH-USD"
+# 	],
+# 	"channels": [
+# 		"level2",
+# 		"heartbeat",
+# 		{
+# 			"name": "ticker",
+# 			"product_ids": [
+# 				"ETH-USD"
+# 			]
+# 		}
+# 	]
+# }
+# '''
+
+## Extract the metrics of a message type (or advanced trade channel) from a
+## nested object or array of objects using a gjson path, like json_query.
+## Scalar fields of the enclosing message such as product_id and time are
+## copied into every extracted object. Takes precedence over the built in
+## parsing of that message type.
+# [inputs.coinbase_marketdata.json_query_by_type]
+#   market_trades = "events.0.trades"
+
+## Emit at most one message of each type per interval for a product, e.g. to
+## keep every tick of liquid products but only sample illiquid ones. Products
+## not listed, or with an interval of "0s", emit every message.
+# [inputs.coinbase_marketdata.product_sample_intervals]
+#   "BTC-USD" = "0s"
+#   "DOGE-USD" = "10s"
+
+## Scale of the integers of price_format = "scaled" per product, for assets
+## quoted with more or fewer decimals than price_scale
+# [inputs.coinbase_marketdata.product_price_scales]
+#   "SHIB-USD" = 12
+
+## Rename the fields of every message type's metrics to match a downstream
+## schema, applied after parsing
+# [inputs.coinbase_marketdata.field_rename]
+#   best_bid = "bid"
+#   last_size = "trade_size"
+
+## Name the measurement of the metrics of a message type, overriding the
+## name derived from the type and measurement_prefix
+# [inputs.coinbase_marketdata.measurement_by_type]
+#   ticker = "cb_ticker"
+#   l2update = "cb_l2"
+
+## Tags added to every metric of the plugin, e.g. to tell the feeds of
+## several venues apart. Tags of the metric itself take precedence.
+# [inputs.coinbase_marketdata.extra_tags]
+#   environment = "prod"
+#   venue = "coinbase"
 `
 }
 
@@ -102,30 +638,286 @@ func (wsl *WebSocketListener) Description() string {
 }
 
 func (wsl *WebSocketListener) Gather(_ telegraf.Accumulator) error {
+	if wsl.CandleInterval.Duration > 0 {
+		wsl.flushCandles(time.Now())
+	}
+
+	if wsl.L2UpdateWindow.Duration > 0 {
+		wsl.flushL2Windows(time.Now())
+	}
+
+	if wsl.VerifyBook && time.Since(wsl.lastVerification) >= wsl.VerifyInterval.Duration {
+		wsl.lastVerification = time.Now()
+		wsl.startVerification()
+	}
+
+	if wsl.OrderBook && time.Since(wsl.lastBookEmit) >= wsl.BookInterval.Duration {
+		wsl.lastBookEmit = time.Now()
+		wsl.emitBooks(wsl.lastBookEmit)
+	}
+
+	if wsl.Enrich {
+		wsl.emitMarkets(time.Now())
+	}
+
+	wsl.checkDowntime(time.Now())
+	if wsl.RestFallbackAfter.Duration > 0 {
+		wsl.pollFallback(time.Now())
+	}
+	wsl.checkSilence(time.Now())
+	wsl.checkHeartbeats(time.Now())
+	wsl.reportDropped()
+	wsl.emitStatus(time.Now())
+
+	if wsl.StateFile != "" {
+		if err := wsl.saveState(); err != nil {
+			wsl.AddError(fmt.Errorf("saving the state to %s: %w", wsl.StateFile, err))
+		}
+	}
+	return nil
+}
+
+func (wsl *WebSocketListener) Init() error {
+	if len(wsl.ServiceAddresses) > 0 {
+		// identifies the plugin in tags and statistics
+		wsl.ServiceAddress = wsl.ServiceAddresses[0]
+	}
+
+	if err := wsl.applyEnvironment(); err != nil {
+		return err
+	}
+
+	wsl.registerStats()
+
+	if err := wsl.validateSubscription(); err != nil {
+		return err
+	}
+
+	if err := wsl.validateSubscriptionAck(); err != nil {
+		return err
+	}
+
+	if err := wsl.validateExtraTags(); err != nil {
+		return err
+	}
+
+	if err := wsl.initNormalizer(); err != nil {
+		return err
+	}
+
+	for msgType, name := range wsl.MeasurementByType {
+		if name == "" {
+			return fmt.Errorf("empty measurement_by_type name for %q", msgType)
+		}
+	}
+
+	if wsl.Ordered && wsl.ParseWorkers > 1 {
+		return fmt.Errorf("ordered parses with a single worker, parse_workers %d can not be set along with it", wsl.ParseWorkers)
+	}
+
+	if err := validatePriceFormat(wsl.PriceFormat, wsl.PriceScale, wsl.ProductPriceScales); err != nil {
+		return err
+	}
+
+	if err := validateQueue(wsl.QueueSize, wsl.QueueFull); err != nil {
+		return err
+	}
+
+	if err := wsclient.ValidateCompression(wsl.MessageCompression); err != nil {
+		return err
+	}
+
+	if wsl.MaxMessageSize.Size < 0 {
+		return fmt.Errorf("invalid max_message_size %d, must not be negative", wsl.MaxMessageSize.Size)
+	}
+
+	if wsl.PingInterval.Duration > 0 && wsl.PongWait.Duration <= wsl.PingInterval.Duration {
+		return fmt.Errorf("pong_wait %s must be longer than ping_interval %s", wsl.PongWait.Duration, wsl.PingInterval.Duration)
+	}
+
+	for _, depth := range wsl.BookDepths {
+		if depth <= 0 {
+			return fmt.Errorf("invalid book_depths %v, must be positive", wsl.BookDepths)
+		}
+	}
+
+	sampleIntervals, err := parseSampleIntervals(wsl.ProductSampleIntervals)
+	if err != nil {
+		return err
+	}
+	wsl.sampleIntervals = sampleIntervals
+
+	precision, ok := timestampPrecisions[wsl.TimestampUnits]
+	if !ok {
+		return fmt.Errorf("invalid timestamp_units %q, must be one of \"ns\", \"us\", \"ms\" or \"s\"", wsl.TimestampUnits)
+	}
+	wsl.precision = precision
+
+	switch wsl.TimestampSource {
+	case "", timestampSourceExchange, timestampSourceLocal:
+	default:
+		return fmt.Errorf("invalid timestamp_source %q, must be %q or %q", wsl.TimestampSource, timestampSourceExchange, timestampSourceLocal)
+	}
+
+	switch wsl.CandleEmptyIntervals {
+	case "", candleEmptySkip, candleEmptyFlat:
+	default:
+		return fmt.Errorf("invalid candle_empty_intervals %q, must be %q or %q",
+			wsl.CandleEmptyIntervals, candleEmptySkip, candleEmptyFlat)
+	}
+	wsl.candlesFromMatches = wsl.subscribesMatches()
+
+	if err := validateTickerMinInterval(wsl.TickerMinInterval.Duration); err != nil {
+		return err
+	}
+
+	if err := validateL2Summary(wsl.L2UpdateWindow.Duration, wsl.L2UpdatePriceBucket); err != nil {
+		return err
+	}
+
+	if wsl.GapFill {
+		if err := validateGapFill(wsl.GapFillMaxTrades); err != nil {
+			return err
+		}
+	}
+
+	if wsl.ReplayFile != "" {
+		if err := wsl.validateReplay(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 func (wsl *WebSocketListener) SetParser(parser parsers.Parser) {
+	wsl.parserMutex.Lock()
+	defer wsl.parserMutex.Unlock()
 	wsl.Parser = parser
 }
 
 func (wsl *WebSocketListener) Start(acc telegraf.Accumulator) error {
 	wsl.Accumulator = acc
 
-	log.Print("Service Address: ", wsl.ServiceAddress)
-	log.Print("Subscription Request: ", wsl.OnConnectMsg)
+	wsl.Log.Debugf("Service Address: %s", wsl.ServiceAddress)
 
-	err := wsl.connect()
-	if err != nil {
+	// reset the state of a previous run, as the plugin is restarted on
+	// config reload
+	wsl.ctx, wsl.cancel = context.WithCancel(context.Background())
+	wsl.emitted = newRecentSet(standbyHistory)
+	wsl.rateLimitReason = ""
+	wsl.lastMessage = time.Time{}
+	wsl.downtimeMutex.Lock()
+	wsl.outages = nil
+	wsl.downSince = time.Time{}
+	wsl.downtimeAlerted = false
+	wsl.downtimeMutex.Unlock()
+	wsl.fallbackMutex.Lock()
+	wsl.disconnectedSince = time.Time{}
+	wsl.fallbackMutex.Unlock()
+	wsl.fallbackActive = false
+	wsl.books = newBookStore()
+	wsl.tickersMutex.Lock()
+	wsl.lastTickers = make(map[string]*Ticker)
+	wsl.tickersMutex.Unlock()
+	wsl.heartbeatsMutex.Lock()
+	wsl.heartbeats = make(map[string]*heartbeatState)
+	wsl.heartbeatsMutex.Unlock()
+	wsl.silenceMutex.Lock()
+	wsl.lastProductMessage = nil
+	wsl.silenceMutex.Unlock()
+	wsl.throttleMutex.Lock()
+	wsl.throttled = make(map[string]*throttledTicker)
+	wsl.throttleMutex.Unlock()
+
+	wsl.gapMutex.Lock()
+	wsl.lastMatchIds = make(map[string]int64)
+	wsl.gapMutex.Unlock()
+	wsl.gaps = make(chan tradeGap, gapFillQueue)
+
+	if wsl.StateFile != "" {
+		if err := wsl.loadState(); err != nil {
+			// start over rather than refuse to collect
+			wsl.Log.Warnf("Unable to load the state of the previous run: %s", err)
+		}
+	}
+
+	// started first, as messages are handled from the first subscription on
+	wsl.startWorkers()
+
+	if wsl.ReplayFile != "" {
+		// the frames are read from replay_file instead
+		wsl.connections = nil
+	} else if err := wsl.connectAll(); err != nil {
+		wsl.stopWorkers()
 		return err
 	}
 
-	// start the routine for reading incoming data stream
-	go wsl.read()
+	if wsl.TickerMinInterval.Duration > 0 {
+		wsl.wg.Add(1)
+		go func() {
+			defer wsl.wg.Done()
+			wsl.runTickerFlush()
+		}()
+	}
+
+	if wsl.GapFill {
+		wsl.wg.Add(1)
+		go func() {
+			defer wsl.wg.Done()
+			wsl.runGapFill()
+		}()
+	}
+
+	if wsl.ReplayFile != "" {
+		wsl.wg.Add(1)
+		go func() {
+			defer wsl.wg.Done()
+			wsl.replay()
+		}()
+	}
 
 	return nil
 }
 
+// connectAll starts serving health_address and recording to record_file,
+// and connects and subscribes every connection
+func (wsl *WebSocketListener) connectAll() error {
+	if err := wsl.newDialer(); err != nil {
+		return err
+	}
+
+	wsl.newConnections()
+
+	// started before connecting, as the messages are recorded from the
+	// first subscription on
+	if wsl.HealthAddress != "" {
+		if err := wsl.startHealthServer(); err != nil {
+			wsl.cancel()
+			return err
+		}
+	}
+
+	if wsl.RecordFile != "" {
+		if err := wsl.openRecorder(); err != nil {
+			wsl.cancel()
+			wsl.stopHealthServer()
+			return err
+		}
+	}
+
+	for _, c := range wsl.connections {
+		if err := wsl.connectFirst(c); err != nil {
+			wsl.cancel()
+			wsl.Close()
+			wsl.stopHealthServer()
+			wsl.closeRecorder()
+			return err
+		}
+	}
+	return nil
+}
+
 // takes in a map of l2update data type in the format of
 // {
 //  "type": "l2update",
@@ -139,17 +931,23 @@ func (wsl *WebSocketListener) Start(acc telegraf.Accumulator) error {
 //  ],
 //  "time": "2020-12-28T23:54:32.051347Z"
 // }
-func (wsl *WebSocketListener) parseL2Update(l2UpdateData map[string]interface{}) []L2Update {
-	changes := l2UpdateData["changes"].([]interface{})
+func (wsl *WebSocketListener) parseL2Update(l2UpdateData map[string]interface{}) ([]L2Update, error) {
+	changes, ok := l2UpdateData["changes"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("l2update without changes: %v", l2UpdateData["changes"])
+	}
 
 	var updates []L2Update
 
 	for _, c := range changes {
-		change := c.([]interface{})
+		change, ok := c.([]interface{})
+		if !ok || len(change) < 3 {
+			return nil, fmt.Errorf("malformed l2update change: %v", c)
+		}
 
 		side := fmt.Sprintf("%v", change[0])
-		price, _ := strconv.ParseFloat(fmt.Sprintf("%v", change[1]), 64)
-		qty, _ := strconv.ParseFloat(fmt.Sprintf("%v", change[2]), 64)
+		price, _ := parseFloat(change[1])
+		qty, _ := parseFloat(change[2])
 
 		updates = append(updates, L2Update{
 			DataType:  fmt.Sprintf("%v", l2UpdateData["type"]),
@@ -158,10 +956,11 @@ func (wsl *WebSocketListener) parseL2Update(l2UpdateData map[string]interface{})
 			Side:      side,
 			Price:     price,
 			Qty:       qty,
+			text:      wsl.decimalText(map[string]interface{}{"price": change[1], "qty": change[2]}, l2UpdateDecimals),
 		})
 	}
 
-	return updates
+	return updates, nil
 }
 
 // takes in a map of ticker data type in the format of
@@ -183,19 +982,20 @@ func (wsl *WebSocketListener) parseL2Update(l2UpdateData map[string]interface{})
 //  "last_size": "0.24169456"
 // }
 func (wsl *WebSocketListener) parseTicker(tickerData map[string]interface{}) *Ticker {
-	open24H, _ := strconv.ParseFloat(fmt.Sprintf("%v", tickerData["open_24h"]), 64)
-	volume24H, _ := strconv.ParseFloat(fmt.Sprintf("%v", tickerData["volume_24h"]), 64)
-	low24H, _ := strconv.ParseFloat(fmt.Sprintf("%v", tickerData["low_24h"]), 64)
-	high24H, _ := strconv.ParseFloat(fmt.Sprintf("%v", tickerData["high_24h"]), 64)
-	volume30D, _ := strconv.ParseFloat(fmt.Sprintf("%v", tickerData["volume_30d"]), 64)
-	bestBid, _ := strconv.ParseFloat(fmt.Sprintf("%v", tickerData["best_bid"]), 64)
-	bestAsk, _ := strconv.ParseFloat(fmt.Sprintf("%v", tickerData["best_ask"]), 64)
-	sequenceId, _ := strconv.ParseInt(fmt.Sprintf("%v", tickerData["sequence"]), 10, 64)
-	tradeId, _ := strconv.ParseInt(fmt.Sprintf("%v", tickerData["trade_id"]), 10, 64)
-	size, _ := strconv.ParseFloat(fmt.Sprintf("%v", tickerData["last_size"]), 64)
-	price, _ := strconv.ParseFloat(fmt.Sprintf("%v", tickerData["price"]), 64)
-
-	return &Ticker{
+	open24H, _ := parseFloat(tickerData["open_24h"])
+	volume24H, _ := parseFloat(tickerData["volume_24h"])
+	low24H, _ := parseFloat(tickerData["low_24h"])
+	high24H, _ := parseFloat(tickerData["high_24h"])
+	volume30D, _ := parseFloat(tickerData["volume_30d"])
+	bestBid, _ := parseFloat(tickerData["best_bid"])
+	bestAsk, _ := parseFloat(tickerData["best_ask"])
+	sequenceId, _ := parseInt(tickerData["sequence"])
+	tradeId, _ := parseInt(tickerData["trade_id"])
+	size, _ := parseFloat(tickerData["last_size"])
+	price, _ := parseFloat(tickerData["price"])
+	spread, spreadBps := bidAskSpread(bestBid, bestAsk)
+
+	return wsl.withMidPrices(&Ticker{
 		DataType:   fmt.Sprintf("%v", tickerData["type"]),
 		ProductId:  fmt.Sprintf("%v", tickerData["product_id"]),
 		Side:       fmt.Sprintf("%v", tickerData["side"]),
@@ -211,107 +1011,444 @@ func (wsl *WebSocketListener) parseTicker(tickerData map[string]interface{}) *Ti
 		Size:       size,
 		SequenceId: sequenceId,
 		TradeId:    tradeId,
-	}
+
+		BestBidSize: optionalFloat(tickerData["best_bid_size"]),
+		BestAskSize: optionalFloat(tickerData["best_ask_size"]),
+		Spread:      spread,
+		SpreadBps:   spreadBps,
+
+		text: wsl.decimalText(tickerData, proTickerDecimals),
+	})
 }
 
-func (wsl *WebSocketListener) read() {
-	for {
-		select {
-		case <-wsl.done:
-			return
+// handle hands a message received on c to the workers, if it is to be
+// emitted
+func (wsl *WebSocketListener) handle(c *connection, message []byte) {
+	wsl.Log.Debugf("recv: %s", message)
 
-		default:
-			_, message, err := wsl.conn.ReadMessage()
-			if err != nil {
-				log.Println("Read Error: ", err, " Reconnecting...")
+	received := time.Now()
+	wsl.messageReceived(received)
 
-				err := wsl.connect()
-				if err != nil {
-					log.Println("Unable to reconnect, quitting...")
-					return
-				}
-				continue
-			}
+	if wsl.RecordFile != "" {
+		wsl.record(c, message, received)
+	}
 
-			log.Printf("recv: %s\n", message)
+	// with resync_on_gap a broken sequence closes the connection, so that
+	// the next read fails and reconnects
+	wsl.checkSequence(c, message)
 
-			go wsl.addMetric(message)
-		}
+	if !wsl.accept(c, message, received) {
+		return
 	}
+	wsl.forward(message, received)
 }
 
-func (wsl *WebSocketListener) parse(marketData map[string]interface{}) []byte {
-	var data []byte
+// forward hands a message to the workers, once accepted from either a
+// connection or replay_file
+func (wsl *WebSocketListener) forward(message []byte, received time.Time) {
+	wsl.productReceived(message, received)
+	if wsl.StateFile != "" {
+		wsl.trackState(message, received)
+	}
+
+	wsl.dispatch(message, received)
+}
 
-	if marketData["type"] == "ticker" {
-		data, _ = json.Marshal(wsl.parseTicker(marketData))
+// parse parses the ticker, l2update and match messages of both feeds into
+// their records, failing on a malformed l2update
+func (wsl *WebSocketListener) parse(marketData map[string]interface{}) ([]record, error) {
+	var records []record
+
+	if wsl.isAdvancedTrade(marketData) {
+		tickers, l2Updates := wsl.parseAdvanced(marketData)
+		for _, ticker := range tickers {
+			records = append(records, ticker)
+		}
+		for _, update := range l2Updates {
+			records = append(records, update)
+		}
+		if marketData["channel"] == "market_trades" {
+			matches := wsl.parseAdvancedTrades(marketData)
+			if wsl.DedupTrades {
+				matches = wsl.newTrades(matches)
+			}
+			for _, match := range matches {
+				records = append(records, match)
+			}
+		}
+	} else if marketData["type"] == "ticker" {
+		records = append(records, wsl.parseTicker(marketData))
 	} else if marketData["type"] == "l2update" {
-		l2Updates := wsl.parseL2Update(marketData)
-		for _, updates := range l2Updates {
-			data, _ = json.Marshal(updates)
+		updates, err := wsl.parseL2Update(marketData)
+		if err != nil {
+			return nil, err
 		}
+		for _, update := range updates {
+			records = append(records, update)
+		}
+	} else if marketData["type"] == "match" || marketData["type"] == "last_match" {
+		records = append(records, wsl.parseMatch(marketData))
+	} else if marketData["type"] == "heartbeat" {
+		records = append(records, wsl.parseHeartbeat(marketData))
 	}
 
-	return data
+	return records, nil
+}
+
+// parseMetrics serializes access to the parser. Messages are parsed by
+// several workers, but not every parser configuration is safe for concurrent
+// use.
+func (wsl *WebSocketListener) parseMetrics(data []byte) ([]telegraf.Metric, error) {
+	wsl.parserMutex.Lock()
+	defer wsl.parserMutex.Unlock()
+	return wsl.Parser.Parse(data)
 }
 
 func (wsl *WebSocketListener) addMetric(message []byte) {
+	wsl.addReceived(message, time.Now())
+}
+
+// addReceived parses a message received at received and emits its metrics
+func (wsl *WebSocketListener) addReceived(message []byte, received time.Time) {
 	marketData := make(map[string]interface{})
 	err := json.Unmarshal(message, &marketData)
 	if err != nil {
-		wsl.AddError(fmt.Errorf("unable to parse incoming msg: %s", err))
+		wsl.parseError(fmt.Errorf("unable to parse incoming msg: %s", err))
+		return
+	}
+
+	if marketData["type"] == "error" {
+		wsl.serverError(marketData)
+	}
+
+	if wsl.DedupTrades && wsl.replayedTrade(marketData) {
 		return
 	}
 
-	data := wsl.parse(marketData)
-	if data != nil {
-		metrics, err := wsl.Parser.Parse(data)
+	if wsl.GapFill {
+		wsl.checkTradeGap(marketData)
+	}
+
+	if wsl.maintainsBooks() {
+		if err := wsl.updateBook(marketData); err != nil {
+			wsl.parseError(fmt.Errorf("unable to parse incoming msg: %s", err))
+			return
+		}
+	}
+
+	if wsl.Enrich {
+		wsl.rememberTickers(marketData)
+	}
+
+	if wsl.CandleInterval.Duration > 0 {
+		wsl.addTrade(marketData)
+	}
+
+	if wsl.HeartbeatTimeout.Duration > 0 && marketData["type"] == "heartbeat" {
+		wsl.rememberHeartbeat(wsl.parseHeartbeat(marketData), time.Now())
+	}
+
+	if wsl.aggregatesL2(marketData) {
+		// summarized by updateBook instead
+		return
+	}
+
+	if !wsl.sample(marketData, time.Now()) {
+		return
+	}
+
+	var metrics []telegraf.Metric
+	if path, ok := wsl.queryPath(marketData); ok {
+		data, err := extract(message, marketData, path)
 		if err != nil {
-			wsl.AddError(fmt.Errorf("unable to parse incoming msg: %s", err))
+			wsl.parseError(fmt.Errorf("unable to parse incoming msg: %s", err))
 			return
 		}
+		if data != nil {
+			metrics, err = wsl.parseMetrics(data)
+			if err != nil {
+				wsl.parseError(fmt.Errorf("unable to parse incoming msg: %s", err))
+				return
+			}
+		}
+	} else if marketData["type"] == "status" {
+		metrics = wsl.parseStatus(marketData)
+	} else if orderEventTypes[messageType(marketData)] {
+		m, err := wsl.parseOrderEvent(marketData, received)
+		if err != nil {
+			wsl.parseError(fmt.Errorf("unable to create order metric: %s", err))
+			return
+		}
+		metrics = append(metrics, m)
+	} else {
+		records, err := wsl.parse(marketData)
+		if err != nil {
+			wsl.parseError(fmt.Errorf("unable to parse incoming msg: %s", err))
+			return
+		}
+		for _, r := range records {
+			m, err := wsl.newMetric(r, received)
+			if err != nil {
+				wsl.parseError(fmt.Errorf("unable to parse incoming msg: %s", err))
+				continue
+			}
+			metrics = append(metrics, m)
+		}
+	}
+
+	for _, m := range metrics {
+		wsl.emit(marketData, m, received)
+	}
+}
+
+// emit adds a metric derived from a message to the accumulator
+func (wsl *WebSocketListener) emit(marketData map[string]interface{}, m telegraf.Metric, received time.Time) {
+	ticker := m.Name() == "ticker"
 
-		for _, m := range metrics {
-			wsl.AddMetric(m)
+	if wsl.TagChannel {
+		m.AddTag("channel", channelOf(messageType(marketData)))
+	}
+
+	if len(wsl.ServiceAddresses) > 1 {
+		productId, _ := m.GetTag("product_id")
+		if active := wsl.activeConnection(productId); active != nil {
+			m.AddTag("endpoint", active.getAddress())
 		}
 	}
+
+	if wsl.TimestampSource == timestampSourceLocal {
+		m.SetTime(received)
+	}
+
+	if wsl.precision > time.Nanosecond {
+		m.SetTime(m.Time().Truncate(wsl.precision))
+	}
+
+	for from, to := range wsl.FieldRename {
+		if value, ok := m.GetField(from); ok {
+			m.RemoveField(from)
+			m.AddField(to, value)
+		}
+	}
+
+	if name := wsl.measurementName(m.Name()); name != m.Name() {
+		m.SetName(name)
+	}
+
+	if ticker && wsl.TickerMinInterval.Duration > 0 && !wsl.throttleTicker(m, time.Now()) {
+		return
+	}
+
+	wsl.AddMetric(m)
 }
 
-func (wsl *WebSocketListener) connect() error {
-	c, _, err := websocket.DefaultDialer.Dial(wsl.ServiceAddress, nil)
-	if err != nil {
-		log.Fatal("dial:", err)
+// measurementName returns the measurement a metric named after its message
+// type is emitted as
+func (wsl *WebSocketListener) measurementName(msgType string) string {
+	if name, ok := wsl.MeasurementByType[msgType]; ok {
+		return name
+	}
+	return wsl.MeasurementPrefix + msgType
+}
+
+// newClient sets up the websocket client of c, dialing the current endpoint
+// of c, subscribing and reconnecting it, and handing its messages to handle
+func (wsl *WebSocketListener) newClient(c *connection) {
+	c.client = wsclient.New(wsclient.Config{
+		URL: wsl.ServiceAddress,
+		Resolve: func(context.Context) (wsclient.Endpoint, error) {
+			return wsclient.Endpoint{URL: c.getAddress()}, nil
+		},
+		Dialer:               wsl.dialer,
+		PingInterval:         wsl.PingInterval.Duration,
+		PongWait:             wsl.PongWait.Duration,
+		MessageCompression:   wsl.MessageCompression,
+		MaxMessageSize:       wsl.MaxMessageSize.Size,
+		ReconnectInterval:    wsl.ReconnectInterval.Duration,
+		MaxBackoff:           wsl.MaxBackoff.Duration,
+		MaxReconnectAttempts: wsl.MaxReconnectAttempts,
+		Disconnected: func(error) {
+			wsl.disconnected(c)
+		},
+		RetryDelay: func(cause error, failures int) time.Duration {
+			return wsl.retryDelay(c, cause, failures)
+		},
+		GaveUp:    wsl.trip,
+		StatsName: "coinbase_marketdata",
+		StatsTags: map[string]string{"address": wsl.ServiceAddress},
+		Log:       wsl.Log,
+	}, connectionHandler{wsl: wsl, c: c})
+}
+
+// connectionHandler hands the connections of c to onConnect and their
+// messages to handle
+type connectionHandler struct {
+	wsl *WebSocketListener
+	c   *connection
+}
+
+func (h connectionHandler) Subscribe(conn *websocket.Conn) error {
+	return h.wsl.onConnect(h.c, conn)
+}
+
+func (h connectionHandler) Handle(message []byte) {
+	h.wsl.handle(h.c, message)
+}
+
+// onConnect subscribes a new connection of c, initially and after every
+// reconnect, and verifies or awaits the answer to the subscription as
+// configured. The messages read meanwhile are handled once c is up.
+func (wsl *WebSocketListener) onConnect(c *connection, conn *websocket.Conn) error {
+	// sequence numbers start over on a new connection
+	c.sequences = make(map[string]int64)
+	c.unread = nil
+
+	if err := wsl.subscribe(conn, c.products()); err != nil {
 		return err
 	}
-	wsl.conn = c
-	wsl.subscribe()
+	if wsl.VerifySubscription {
+		if err := wsl.verifySubscription(c); err != nil {
+			return err
+		}
+	}
+	if !c.subscribed && wsl.FailOnSubscribeError {
+		// only the first subscription fails Start
+		err := c.subscribeErr
+		if !wsl.VerifySubscription {
+			_, err = wsl.awaitSubscription(c, 0)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	c.subscribed = true
+
+	wsl.resetSilence(time.Now(), c.products())
+	c.setUp(true)
+	wsl.connectionChanged(time.Now())
 
+	unread := c.unread
+	c.unread = nil
+	for _, message := range unread {
+		wsl.handle(c, message)
+	}
 	return nil
 }
 
-func (wsl *WebSocketListener) subscribe() {
-	err := wsl.conn.WriteMessage(websocket.TextMessage, []byte(wsl.OnConnectMsg))
+// disconnected records that the connection of c broke, failing over to the
+// standby if c was the active connection
+func (wsl *WebSocketListener) disconnected(c *connection) {
+	c.setUp(false)
+	wsl.connectionChanged(time.Now())
+	wsl.failover(c)
+}
+
+func (wsl *WebSocketListener) subscribe(conn *websocket.Conn, productIds []string) error {
+	msgs, err := wsl.subscriptionMessagesFor(productIds)
 	if err != nil {
-		log.Fatalln("Unable to reconnect", err)
-		return
+		return fmt.Errorf("subscribe: %w", err)
+	}
+
+	for _, msg := range msgs {
+		wsl.Log.Debugf("Subscription Request: %s", msg)
+
+		msg, err = wsl.authenticate(msg, time.Now())
+		if err != nil {
+			return fmt.Errorf("subscribe: %w", err)
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			return fmt.Errorf("subscribe: %w", err)
+		}
 	}
+	return nil
+}
+
+// Close stops the client of every connection, waiting up to
+// wsclient.CloseTimeout for the server to answer the close frames, and
+// returns the first error closing them. Closing again is a no-op.
+func (wsl *WebSocketListener) Close() error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(wsl.connections))
+	for i, c := range wsl.connections {
+		if c.client == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, c *connection) {
+			defer wg.Done()
+			errs[i] = c.client.Stop()
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (wsl *WebSocketListener) Stop() {
-	wsl.done <- true
-	if wsl.Closer != nil {
-		_ = wsl.Close()
-		wsl.Closer = nil
+	// cancelled before closing the connections so that the read loops don't
+	// reconnect, which also aborts a reconnect in progress
+	wsl.cancel()
+	if err := wsl.Close(); err != nil {
+		wsl.Log.Errorf("Unable to close connection: %s", err)
 	}
+	wsl.stopHealthServer()
 	wsl.wg.Wait()
+	wsl.stopWorkers()
+	wsl.closeRecorder()
+
+	// the tickers held back by ticker_min_interval are not lost on shutdown
+	if wsl.TickerMinInterval.Duration > 0 {
+		wsl.flushTickers(time.Now(), true)
+	}
+
+	if wsl.StateFile != "" {
+		if err := wsl.saveState(); err != nil {
+			wsl.Log.Errorf("Unable to save the state to %s: %s", wsl.StateFile, err)
+		}
+	}
 }
 
 func newSocketListener() *WebSocketListener {
 	parser, _ := parsers.NewInfluxParser()
 
 	return &WebSocketListener{
-		Parser: parser,
-		done:   make(chan bool),
+		Parser:                    parser,
+		RateLimitBackoff:          internal.Duration{Duration: defaultRateLimitBackoff},
+		DowntimeWindow:            internal.Duration{Duration: defaultDowntimeWindow},
+		VerifyInterval:            internal.Duration{Duration: defaultVerifyInterval},
+		HealthMaxSilence:          internal.Duration{Duration: defaultHealthMaxSilence},
+		VerifyDepth:               defaultVerifyDepth,
+		BookDepths:                []int{5, 10, 25},
+		QueueSize:                 defaultQueueSize,
+		MetricTagKeys:             defaultMetricTagKeys,
+		MetricSchema:              marketdata.SchemaNative,
+		PriceScale:                defaultPriceScale,
+		FailoverAfter:             defaultFailoverAfter,
+		SubscriptionTimeout:       internal.Duration{Duration: defaultSubscriptionTimeout},
+		SubscriptionRetries:       defaultSubscriptionRetries,
+		PingInterval:              internal.Duration{Duration: wsclient.DefaultPingInterval},
+		PongWait:                  internal.Duration{Duration: wsclient.DefaultPongWait},
+		ReconnectInterval:         internal.Duration{Duration: wsclient.DefaultReconnectInterval},
+		MaxBackoff:                internal.Duration{Duration: wsclient.DefaultMaxBackoff},
+		ResyncOnGap:               true,
+		lastSampled:               make(map[string]time.Time),
+		candles:                   make(map[string]*candle),
+		lastTradeIds:              make(map[string]int64),
+		lastMatchIds:              make(map[string]int64),
+		GapFillMaxTrades:          defaultGapFillMaxTrades,
+		RecordRotationMaxArchives: defaultRecordMaxArchives,
+		ReplaySpeed:               defaultReplaySpeed,
+		l2Windows:                 make(map[string]*l2Window),
+		lastTickers:               make(map[string]*Ticker),
+		heartbeats:                make(map[string]*heartbeatState),
+		throttled:                 make(map[string]*throttledTicker),
+		books:                     newBookStore(),
+		httpClient:                &http.Client{Timeout: 10 * time.Second},
 	}
 }
 
@@ -1 +1,1008 @@
 package coinbase_marketdata
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/wsclient"
+	"github.com/influxdata/telegraf/models"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/plugins/parsers"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+const proTicker = `{
+  "type": "ticker",
+  "sequence": 12238444095,
+  "product_id": "ETH-USD",
+  "price": "731.99",
+  "open_24h": "684.11",
+  "volume_24h": "395831.08785795",
+  "low_24h": "680.9",
+  "high_24h": "747",
+  "volume_30d": "6144317.83380943",
+  "best_bid": "731.83",
+  "best_ask": "731.99",
+  "side": "buy",
+  "time": "2020-12-28T23:54:32.051347Z",
+  "trade_id": 71476932,
+  "last_size": "0.24169456"
+}`
+
+const proL2Update = `{
+  "type": "l2update",
+  "product_id": "ETH-USD",
+  "changes": [
+    ["sell", "731.99", "1.24025886"],
+    ["buy", "731.50", "0.5"]
+  ],
+  "time": "2020-12-28T23:54:32.051347Z"
+}`
+
+const advancedTicker = `{
+  "channel": "ticker",
+  "client_id": "",
+  "timestamp": "2023-02-09T20:30:37.167359596Z",
+  "sequence_num": 7,
+  "events": [
+    {
+      "type": "snapshot",
+      "tickers": [
+        {
+          "type": "ticker",
+          "product_id": "BTC-USD",
+          "price": "21932.98",
+          "volume_24_h": "16038.28770938",
+          "low_24_h": "21835.29",
+          "high_24_h": "23011.18",
+          "best_bid": "21932.97",
+          "best_ask": "21932.98"
+        }
+      ]
+    }
+  ]
+}`
+
+func newTestListener(t testing.TB) (*WebSocketListener, *testutil.Accumulator) {
+	parser, err := parsers.NewParser(&parsers.Config{
+		DataFormat:       "json",
+		JSONNameKey:      "type",
+		JSONTimeKey:      "time",
+		JSONTimeFormat:   time.RFC3339Nano,
+		TagKeys:          []string{"type", "product_id", "side"},
+		JSONStringFields: []string{"type", "product_id", "side", "maker_order_id", "taker_order_id"},
+	})
+	require.NoError(t, err)
+
+	acc := &testutil.Accumulator{}
+	wsl := newSocketListener()
+	wsl.Log = testutil.Logger{}
+	wsl.registerStats()
+	wsl.SetParser(parser)
+	wsl.Accumulator = acc
+
+	return wsl, acc
+}
+
+func TestProTicker(t *testing.T) {
+	wsl, acc := newTestListener(t)
+
+	wsl.addMetric([]byte(proTicker))
+	require.NoError(t, acc.FirstError())
+
+	m, ok := acc.Get("ticker")
+	require.True(t, ok)
+	require.Equal(t, map[string]string{"type": "ticker", "product_id": "ETH-USD", "side": "buy"}, m.Tags)
+	require.Equal(t, 731.99, m.Fields["price"])
+	require.Equal(t, 684.11, m.Fields["open_24h"])
+	require.Equal(t, 395831.08785795, m.Fields["volume_24h"])
+	require.Equal(t, 680.9, m.Fields["low_24h"])
+	require.Equal(t, 747.0, m.Fields["high_24h"])
+	require.Equal(t, 6144317.83380943, m.Fields["volume_30d"])
+	require.Equal(t, 731.83, m.Fields["best_bid"])
+	require.Equal(t, 731.99, m.Fields["best_ask"])
+	require.Equal(t, 0.24169456, m.Fields["last_size"])
+	require.Equal(t, float64(12238444095), m.Fields["sequence_id"])
+	require.Equal(t, float64(71476932), m.Fields["trade_id"])
+	require.Equal(t, time.Date(2020, 12, 28, 23, 54, 32, 51347000, time.UTC), m.Time)
+	require.NotContains(t, m.Fields, "best_bid_size")
+	require.NotContains(t, m.Fields, "best_ask_size")
+}
+
+func TestTickerBestSizes(t *testing.T) {
+	wsl, acc := newTestListener(t)
+
+	wsl.addMetric([]byte(strings.Replace(proTicker, `"best_ask": "731.99",`,
+		`"best_ask": "731.99", "best_bid_size": "1.5", "best_ask_size": "0.25",`, 1)))
+	wsl.addMetric([]byte(strings.Replace(advancedTicker, `"best_ask": "21932.98"`,
+		`"best_ask": "21932.98", "best_bid_quantity": "3", "best_ask_quantity": "0.1"`, 1)))
+	require.NoError(t, acc.FirstError())
+
+	sizes := make(map[string][2]interface{})
+	for _, m := range acc.GetTelegrafMetrics() {
+		sizes[m.Tags()["product_id"]] = [2]interface{}{m.Fields()["best_bid_size"], m.Fields()["best_ask_size"]}
+	}
+	require.Equal(t, map[string][2]interface{}{
+		"ETH-USD": {1.5, 0.25},
+		"BTC-USD": {3.0, 0.1},
+	}, sizes)
+}
+
+func TestTickerSpread(t *testing.T) {
+	wsl, acc := newTestListener(t)
+
+	wsl.addMetric([]byte(strings.Replace(proTicker, `"best_ask": "731.99"`, `"best_ask": "732.01"`, 1)))
+	wsl.addMetric([]byte(advancedTicker))
+	// a ticker without a bid has no spread
+	wsl.addMetric([]byte(strings.Replace(strings.Replace(proTicker, `"ETH-USD"`, `"BTC-USD"`, 1),
+		`"best_bid": "731.83",`, ``, 1)))
+	require.NoError(t, acc.FirstError())
+
+	spreads := make(map[string][2]interface{})
+	for _, m := range acc.GetTelegrafMetrics() {
+		spreads[m.Tags()["product_id"]+"/"+m.Tags()["side"]] = [2]interface{}{m.Fields()["spread"], m.Fields()["spread_bps"]}
+	}
+	ethBid, ethAsk := 731.83, 732.01
+	btcBid, btcAsk := 21932.97, 21932.98
+	require.Equal(t, map[string][2]interface{}{
+		"ETH-USD/buy": {ethAsk - ethBid, (ethAsk - ethBid) / ((ethAsk + ethBid) / 2) * 10000},
+		"BTC-USD/":    {btcAsk - btcBid, (btcAsk - btcBid) / ((btcAsk + btcBid) / 2) * 10000},
+		"BTC-USD/buy": {nil, nil},
+	}, spreads)
+}
+
+func TestTickerMidPrices(t *testing.T) {
+	wsl, acc := newTestListener(t)
+
+	wsl.addMetric([]byte(proTicker))
+	require.NoError(t, acc.FirstError())
+	m, _ := acc.Get("ticker")
+	require.NotContains(t, m.Fields, "mid_price")
+	require.NotContains(t, m.Fields, "micro_price")
+
+	acc.ClearMetrics()
+	wsl.MidPrices = true
+	wsl.addMetric([]byte(proTicker))
+	wsl.addMetric([]byte(strings.Replace(advancedTicker, `"best_ask": "21932.98"`,
+		`"best_ask": "21932.98", "best_bid_quantity": "3", "best_ask_quantity": "1"`, 1)))
+	require.NoError(t, acc.FirstError())
+
+	prices := make(map[string][2]interface{})
+	for _, m := range acc.GetTelegrafMetrics() {
+		prices[m.Tags()["product_id"]] = [2]interface{}{m.Fields()["mid_price"], m.Fields()["micro_price"]}
+	}
+	ethBid, ethAsk := 731.83, 731.99
+	btcBid, btcAsk := 21932.97, 21932.98
+	require.Equal(t, map[string][2]interface{}{
+		// without the sizes the micro price is left out
+		"ETH-USD": {(ethBid + ethAsk) / 2, nil},
+		"BTC-USD": {(btcBid + btcAsk) / 2, (btcBid*1 + btcAsk*3) / 4},
+	}, prices)
+}
+
+func TestProL2UpdateEmitsEveryChange(t *testing.T) {
+	wsl, acc := newTestListener(t)
+
+	wsl.addMetric([]byte(proL2Update))
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, uint64(2), acc.NMetrics())
+
+	acc.AssertContainsTaggedFields(t, "l2update",
+		map[string]interface{}{"price": 731.99, "qty": 1.24025886},
+		map[string]string{"type": "l2update", "product_id": "ETH-USD", "side": "sell"},
+	)
+	acc.AssertContainsTaggedFields(t, "l2update",
+		map[string]interface{}{"price": 731.50, "qty": 0.5},
+		map[string]string{"type": "l2update", "product_id": "ETH-USD", "side": "buy"},
+	)
+}
+
+func TestMalformedL2UpdateIsParseError(t *testing.T) {
+	for _, orderBook := range []bool{false, true} {
+		wsl, acc := newTestListener(t)
+		wsl.OrderBook = orderBook
+		parseErrors := wsl.parseErrors.Get()
+
+		wsl.addMetric([]byte(`{"type": "l2update", "product_id": "ETH-USD", "changes": "none"}`))
+		wsl.addMetric([]byte(`{"type": "l2update", "product_id": "ETH-USD", "changes": [["sell", "731.99"]]}`))
+		wsl.addMetric([]byte(`{"type": "l2update", "product_id": "ETH-USD", "changes": ["sell"]}`))
+
+		require.Equal(t, int64(3), wsl.parseErrors.Get()-parseErrors)
+		require.Len(t, acc.Errors, 3)
+		require.False(t, acc.HasMeasurement("l2update"))
+	}
+}
+
+func TestAdvancedTickerDetected(t *testing.T) {
+	wsl, acc := newTestListener(t)
+
+	wsl.addMetric([]byte(advancedTicker))
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, uint64(1), acc.NMetrics())
+
+	m, ok := acc.Get("ticker")
+	require.True(t, ok)
+	require.Equal(t, "BTC-USD", m.Tags["product_id"])
+	require.Equal(t, 21932.98, m.Fields["price"])
+	require.Equal(t, 21932.97, m.Fields["best_bid"])
+	require.Equal(t, 16038.28770938, m.Fields["volume_24h"])
+	require.Equal(t, float64(7), m.Fields["sequence_id"])
+	require.Equal(t, time.Date(2023, 2, 9, 20, 30, 37, 167359596, time.UTC), m.Time)
+}
+
+func TestAPIVersionPinnedToPro(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.APIVersion = apiVersionPro
+
+	wsl.addMetric([]byte(advancedTicker))
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, uint64(0), acc.NMetrics())
+}
+
+func TestAdvancedL2Data(t *testing.T) {
+	wsl, acc := newTestListener(t)
+
+	wsl.addMetric([]byte(`{
+  "channel": "l2_data",
+  "timestamp": "2023-02-09T20:32:50.714964855Z",
+  "sequence_num": 0,
+  "events": [
+    {
+      "type": "update",
+      "product_id": "BTC-USD",
+      "updates": [
+        {"side": "bid", "event_time": "2023-02-09T20:32:50.714964855Z", "price_level": "21921.73", "new_quantity": "0.06317902"},
+        {"side": "offer", "event_time": "2023-02-09T20:32:50.714964855Z", "price_level": "21921.74", "new_quantity": "0"}
+      ]
+    }
+  ]
+}`))
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, uint64(2), acc.NMetrics())
+
+	acc.AssertContainsTaggedFields(t, "l2update",
+		map[string]interface{}{"price": 21921.73, "qty": 0.06317902},
+		map[string]string{"type": "l2update", "product_id": "BTC-USD", "side": "buy"},
+	)
+	acc.AssertContainsTaggedFields(t, "l2update",
+		map[string]interface{}{"price": 21921.74, "qty": 0.0},
+		map[string]string{"type": "l2update", "product_id": "BTC-USD", "side": "sell"},
+	)
+}
+
+// newTestServer starts a websocket server calling handler for every
+// accepted connection
+func newTestServer(t *testing.T, handler func(conn *websocket.Conn)) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handler(conn)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func wsURL(ts *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+func TestCircuitBreakerTripsOnAuthFailure(t *testing.T) {
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		_, _, _ = conn.ReadMessage()
+		// 3000 is the unauthorized close code of the IANA registry
+		_ = conn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(3000, "Authentication Failed"))
+		time.Sleep(100 * time.Millisecond)
+	})
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.ReconnectInterval = internal.Duration{Duration: time.Millisecond}
+	require.NoError(t, wsl.Start(acc))
+
+	acc.Wait(1)
+	acc.AssertContainsTaggedFields(t, "coinbase_connection",
+		map[string]interface{}{
+			"state":                connectionStateFailed,
+			"consecutive_failures": 0,
+			"permanent":            true,
+		},
+		map[string]string{"service_address": wsl.ServiceAddress},
+	)
+	require.Error(t, acc.FirstError())
+}
+
+func TestCircuitBreakerTripsAfterMaxAttempts(t *testing.T) {
+	var connections int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// accept the first connection and drop it, then refuse service
+		if atomic.AddInt32(&connections, 1) > 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		_, _, _ = conn.ReadMessage()
+		conn.Close()
+	}))
+	defer ts.Close()
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.MaxReconnectAttempts = 3
+	wsl.ReconnectInterval = internal.Duration{Duration: time.Millisecond}
+	require.NoError(t, wsl.Start(acc))
+
+	acc.Wait(1)
+	acc.AssertContainsTaggedFields(t, "coinbase_connection",
+		map[string]interface{}{
+			"state":                connectionStateFailed,
+			"consecutive_failures": 3,
+			"permanent":            false,
+		},
+		map[string]string{"service_address": wsl.ServiceAddress},
+	)
+	require.Equal(t, int32(4), atomic.LoadInt32(&connections))
+}
+
+func TestJSONQueryByType(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.JSONQueryByType = map[string]string{"market_trades": "events.0.trades"}
+
+	wsl.addMetric([]byte(`{
+  "channel": "market_trades",
+  "timestamp": "2023-02-09T20:19:35.39625135Z",
+  "sequence_num": 0,
+  "events": [
+    {
+      "type": "snapshot",
+      "trades": [
+        {"trade_id": "000000000", "product_id": "ETH-USD", "price": 1260.01, "size": 0.3, "side": "BUY", "time": "2019-08-14T20:42:27.265000Z"},
+        {"trade_id": "000000001", "product_id": "ETH-USD", "price": 1260.02, "size": 0.1, "side": "SELL", "time": "2019-08-14T20:42:27.265000Z"}
+      ]
+    }
+  ]
+}`))
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, uint64(2), acc.NMetrics())
+
+	acc.AssertContainsTaggedFields(t, "market_trades",
+		map[string]interface{}{"price": 1260.01, "size": 0.3, "sequence_num": 0.0},
+		map[string]string{"type": "market_trades", "product_id": "ETH-USD", "side": "BUY"},
+	)
+}
+
+func TestJSONQueryByTypeInheritsEnvelopeFields(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.JSONQueryByType = map[string]string{"l2update": "changes_detail"}
+
+	wsl.addMetric([]byte(`{
+  "type": "l2update",
+  "product_id": "ETH-USD",
+  "time": "2020-12-28T23:54:32.051347Z",
+  "changes_detail": {"side": "sell", "price": 731.99, "qty": 1.5}
+}`))
+	require.NoError(t, acc.FirstError())
+
+	m, ok := acc.Get("l2update")
+	require.True(t, ok)
+	require.Equal(t, map[string]string{"type": "l2update", "product_id": "ETH-USD", "side": "sell"}, m.Tags)
+	require.Equal(t, map[string]interface{}{"price": 731.99, "qty": 1.5}, m.Fields)
+	require.Equal(t, time.Date(2020, 12, 28, 23, 54, 32, 51347000, time.UTC), m.Time)
+}
+
+func TestJSONQueryByTypeInvalidPath(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.JSONQueryByType = map[string]string{"ticker": "price"}
+
+	wsl.addMetric([]byte(proTicker))
+	require.Error(t, acc.FirstError())
+	require.Equal(t, uint64(0), acc.NMetrics())
+}
+
+func TestRateLimitedErrorMessageBacksOff(t *testing.T) {
+	var connections int32
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		_, _, _ = conn.ReadMessage()
+		if atomic.AddInt32(&connections, 1) > 1 {
+			time.Sleep(time.Second)
+			return
+		}
+		_ = conn.WriteMessage(websocket.TextMessage,
+			[]byte(`{"type": "error", "message": "Too many subscriptions", "reason": "rate limit exceeded"}`))
+		time.Sleep(50 * time.Millisecond)
+	})
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.ReconnectInterval = internal.Duration{Duration: time.Millisecond}
+	wsl.RateLimitBackoff.Duration = 10 * time.Millisecond
+	require.NoError(t, wsl.Start(acc))
+
+	// the coinbase_error of the message and the backoff
+	acc.Wait(2)
+	acc.AssertContainsTaggedFields(t, "coinbase_rate_limited",
+		map[string]interface{}{
+			"reason":          "Too many subscriptions",
+			"backoff_seconds": 0.01,
+		},
+		map[string]string{"service_address": wsl.ServiceAddress},
+	)
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&connections) == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+// exclusiveParser fails when Parse is entered by more than one goroutine at
+// a time, like a parser keeping state between calls would misbehave
+type exclusiveParser struct {
+	parsers.Parser
+	inFlight int32
+	overlaps int32
+}
+
+func (p *exclusiveParser) Parse(buf []byte) ([]telegraf.Metric, error) {
+	if atomic.AddInt32(&p.inFlight, 1) > 1 {
+		atomic.AddInt32(&p.overlaps, 1)
+	}
+	defer atomic.AddInt32(&p.inFlight, -1)
+
+	time.Sleep(time.Millisecond)
+	return p.Parser.Parse(buf)
+}
+
+func TestConcurrentAddMetricSerializesParser(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	parser := &exclusiveParser{Parser: wsl.Parser}
+	wsl.SetParser(parser)
+
+	const messages = 50
+	var wg sync.WaitGroup
+	for i := 0; i < messages; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wsl.addMetric([]byte(proTicker))
+		}()
+	}
+	wg.Wait()
+
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, uint64(messages), acc.NMetrics())
+	require.Equal(t, int32(0), atomic.LoadInt32(&parser.overlaps))
+}
+
+func TestProductSampleIntervals(t *testing.T) {
+	wsl, _ := newTestListener(t)
+	wsl.ProductSampleIntervals = map[string]string{
+		"BTC-USD":  "0s",
+		"DOGE-USD": "10s",
+	}
+	require.NoError(t, wsl.Init())
+
+	now := time.Now()
+	btc := map[string]interface{}{"type": "ticker", "product_id": "BTC-USD"}
+	doge := map[string]interface{}{"type": "ticker", "product_id": "DOGE-USD"}
+	dogeBook := map[string]interface{}{"type": "l2update", "product_id": "DOGE-USD"}
+	eth := map[string]interface{}{"type": "ticker", "product_id": "ETH-USD"}
+
+	require.True(t, wsl.sample(btc, now))
+	require.True(t, wsl.sample(btc, now))
+	require.True(t, wsl.sample(eth, now))
+	require.True(t, wsl.sample(eth, now))
+
+	require.True(t, wsl.sample(doge, now))
+	require.False(t, wsl.sample(doge, now.Add(9*time.Second)))
+	require.True(t, wsl.sample(dogeBook, now.Add(9*time.Second)))
+	require.True(t, wsl.sample(doge, now.Add(10*time.Second)))
+	require.False(t, wsl.sample(doge, now.Add(11*time.Second)))
+}
+
+func TestInvalidProductSampleInterval(t *testing.T) {
+	wsl, _ := newTestListener(t)
+	wsl.ProductSampleIntervals = map[string]string{"DOGE-USD": "often"}
+	require.Error(t, wsl.Init())
+}
+
+func TestStopSendsCloseFrame(t *testing.T) {
+	closed := make(chan error, 1)
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				closed <- err
+				return
+			}
+		}
+	})
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	require.NoError(t, wsl.Start(acc))
+
+	stopped := make(chan struct{})
+	go func() {
+		wsl.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return")
+	}
+
+	select {
+	case err := <-closed:
+		require.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), err.Error())
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not see the connection close")
+	}
+
+	// closing again is a no-op
+	require.NoError(t, wsl.Close())
+}
+
+func TestStopWithoutCloseAnswer(t *testing.T) {
+	release := make(chan struct{})
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		_, _, _ = conn.ReadMessage()
+		// never read again, so the close frame is not answered
+		<-release
+	})
+	defer close(release)
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.PingInterval = internal.Duration{}
+	require.NoError(t, wsl.Start(acc))
+
+	start := time.Now()
+	wsl.Stop()
+	require.Less(t, int64(time.Since(start)), int64(wsclient.CloseTimeout+time.Second))
+}
+
+func TestStopAbortsReconnect(t *testing.T) {
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		_, _, _ = conn.ReadMessage()
+		// drop the connection right away, so the plugin backs off
+	})
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.ReconnectInterval = internal.Duration{Duration: time.Hour}
+	require.NoError(t, wsl.Start(acc))
+
+	require.Eventually(t, func() bool {
+		up, _ := wsl.Healthy()
+		return !up
+	}, time.Second, 10*time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		wsl.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not abort the reconnect")
+	}
+}
+
+func TestTagChannel(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.TagChannel = true
+
+	wsl.addMetric([]byte(proTicker))
+	wsl.addMetric([]byte(proL2Update))
+	wsl.addMetric([]byte(advancedTicker))
+	require.NoError(t, acc.FirstError())
+
+	channels := make(map[string]string)
+	for _, m := range acc.GetTelegrafMetrics() {
+		channels[m.Name()+"/"+m.Tags()["product_id"]] = m.Tags()["channel"]
+	}
+	require.Equal(t, map[string]string{
+		"ticker/ETH-USD":   "ticker",
+		"l2update/ETH-USD": "level2",
+		"ticker/BTC-USD":   "ticker",
+	}, channels)
+
+	require.Equal(t, "matches", channelOf("last_match"))
+	require.Equal(t, "full", channelOf("received"))
+	require.Equal(t, "heartbeat", channelOf("heartbeat"))
+}
+
+func TestStandbyPromotedWithoutGapOrDuplicates(t *testing.T) {
+	nextTicker := strings.Replace(proTicker, "12238444095", "12238444096", 1)
+
+	var connections int32
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		_, _, _ = conn.ReadMessage()
+
+		switch atomic.AddInt32(&connections, 1) {
+		case 1:
+			// the primary fails after the first ticker
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(proTicker))
+			time.Sleep(200 * time.Millisecond)
+			return
+		case 2:
+			time.Sleep(50 * time.Millisecond)
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(proTicker))
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(nextTicker))
+		}
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.Standby = true
+	wsl.ReconnectInterval = internal.Duration{Duration: time.Millisecond}
+	require.NoError(t, wsl.Start(acc))
+	defer wsl.Stop()
+
+	acc.Wait(3)
+	acc.AssertContainsTaggedFields(t, "coinbase_connection",
+		map[string]interface{}{
+			"state":      "promoted",
+			"connection": "standby",
+			"replayed":   1,
+		},
+		map[string]string{"service_address": wsl.ServiceAddress},
+	)
+
+	var sequences []interface{}
+	for _, m := range acc.GetTelegrafMetrics() {
+		if m.Name() == "ticker" {
+			sequences = append(sequences, m.Fields()["sequence_id"])
+		}
+	}
+	require.ElementsMatch(t, []interface{}{float64(12238444095), float64(12238444096)}, sequences)
+}
+
+func TestRecentSetEvictsOldest(t *testing.T) {
+	s := newRecentSet(2)
+	require.True(t, s.add(1))
+	require.False(t, s.add(1))
+	require.True(t, s.add(2))
+	require.True(t, s.add(3))
+	require.True(t, s.add(1))
+	require.False(t, s.add(3))
+}
+
+func TestFieldRename(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.FieldRename = map[string]string{
+		"best_bid":  "bid",
+		"last_size": "trade_size",
+		"qty":       "quantity",
+	}
+
+	wsl.addMetric([]byte(proTicker))
+	wsl.addMetric([]byte(proL2Update))
+	require.NoError(t, acc.FirstError())
+
+	m, ok := acc.Get("ticker")
+	require.True(t, ok)
+	require.Equal(t, 731.83, m.Fields["bid"])
+	require.Equal(t, 0.24169456, m.Fields["trade_size"])
+	require.NotContains(t, m.Fields, "best_bid")
+	require.NotContains(t, m.Fields, "last_size")
+	require.Equal(t, 731.99, m.Fields["price"])
+
+	m, ok = acc.Get("l2update")
+	require.True(t, ok)
+	require.Contains(t, m.Fields, "quantity")
+	require.NotContains(t, m.Fields, "qty")
+}
+
+func TestMeasurementNames(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.MeasurementPrefix = "coinbase_"
+	wsl.MeasurementByType = map[string]string{"ticker": "cb_ticker"}
+	require.NoError(t, wsl.Init())
+
+	wsl.addMetric([]byte(proTicker))
+	wsl.addMetric([]byte(proL2Update))
+	require.NoError(t, acc.FirstError())
+
+	require.True(t, acc.HasMeasurement("cb_ticker"))
+	require.True(t, acc.HasMeasurement("coinbase_l2update"))
+	require.False(t, acc.HasMeasurement("ticker"))
+	require.False(t, acc.HasMeasurement("l2update"))
+
+	wsl.MeasurementByType["l2update"] = ""
+	require.Error(t, wsl.Init())
+}
+
+func TestExtraTags(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.ExtraTags = map[string]string{"venue": "coinbase", "product_id": "overridden"}
+	wsl.CandleInterval = internal.Duration{Duration: time.Minute}
+	require.NoError(t, wsl.Init())
+
+	wsl.addMetric([]byte(proTicker))
+	require.NoError(t, acc.FirstError())
+
+	m, ok := acc.Get("ticker")
+	require.True(t, ok)
+	require.Equal(t, "coinbase", m.Tags["venue"])
+	require.Equal(t, "ETH-USD", m.Tags["product_id"])
+
+	wsl.flushCandles(time.Now().Add(time.Hour))
+	m, ok = acc.Get("coinbase_candle")
+	require.True(t, ok)
+	require.Equal(t, "coinbase", m.Tags["venue"])
+	require.Equal(t, "ETH-USD", m.Tags["product_id"])
+
+	wsl.ExtraTags[""] = "empty"
+	require.Error(t, wsl.Init())
+}
+
+func TestCurrencyTags(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.CurrencyTags = true
+	wsl.CandleInterval = internal.Duration{Duration: time.Minute}
+	require.NoError(t, wsl.Init())
+
+	wsl.addMetric([]byte(proTicker))
+	wsl.flushCandles(time.Now().Add(time.Hour))
+	require.NoError(t, acc.FirstError())
+
+	for _, measurement := range []string{"ticker", "coinbase_candle"} {
+		m, ok := acc.Get(measurement)
+		require.True(t, ok)
+		require.Equal(t, "ETH", m.Tags["base_currency"], measurement)
+		require.Equal(t, "USD", m.Tags["quote_currency"], measurement)
+	}
+
+	require.Nil(t, currencyTags(""))
+	require.Nil(t, currencyTags("BTCUSD"))
+	require.Equal(t, map[string]string{"base_currency": "USDT", "quote_currency": "USD"}, currencyTags("USDT-USD"))
+}
+
+func TestNormalizedSchema(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.ProductIds = []string{"ETH-USD", "BTC-USD"}
+	wsl.Channels = []string{"ticker", "matches"}
+	wsl.MetricSchema = marketdata.SchemaNormalized
+	require.NoError(t, wsl.Init())
+
+	wsl.addMetric([]byte(proTicker))
+	wsl.addMetric([]byte(proMatch))
+	require.NoError(t, acc.FirstError())
+
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("market_ticker",
+			map[string]string{"exchange": "coinbase", "base": "ETH", "quote": "USD", "type": "ticker", "side": "buy"},
+			map[string]interface{}{"price": 731.99, "bid": 731.83, "ask": 731.99, "volume_24h": 395831.08785795},
+			time.Date(2020, 12, 28, 23, 54, 32, 51347000, time.UTC),
+		),
+		// the side of the maker is flipped to that of the taker
+		testutil.MustMetric("market_trade",
+			map[string]string{"exchange": "coinbase", "base": "BTC", "quote": "USD", "side": "buy", "type": "match"},
+			map[string]interface{}{"price": 400.23, "size": 5.23512},
+			time.Date(2014, 11, 7, 8, 19, 27, 28459000, time.UTC),
+		),
+	}, acc.GetTelegrafMetrics())
+
+	wsl.MetricTagKeys = []string{"type", "product_id"}
+	require.Error(t, wsl.Init())
+}
+
+func TestAgentTags(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.ExtraTags = map[string]string{"venue": "coinbase"}
+	wsl.JSONQueryByType = map[string]string{"market_trades": "events.0.trades"}
+	wsl.CandleInterval = internal.Duration{Duration: time.Minute}
+	require.NoError(t, wsl.Init())
+
+	input := models.NewRunningInput(wsl, &models.InputConfig{
+		Name: "coinbase_marketdata",
+		Tags: map[string]string{"venue": "plugin", "environment": "prod"},
+	})
+	input.SetDefaultTags(map[string]string{"region": "eu", "environment": "global"})
+
+	// parsed directly, by the parser and the plugin's own measurements
+	wsl.addMetric([]byte(proTicker))
+	wsl.addMetric([]byte(`{"channel": "market_trades", "events": [{"trades": [{"product_id": "ETH-USD", "price": 1260.01, "size": 0.3, "side": "BUY", "time": "2019-08-14T20:42:27.265000Z"}]}]}`))
+	wsl.flushCandles(time.Now().Add(time.Hour))
+	require.NoError(t, acc.FirstError())
+
+	// the tags of the input and the global tags are applied by the agent's
+	// accumulator
+	var names []string
+	for _, m := range acc.GetTelegrafMetrics() {
+		m = input.MakeMetric(m)
+		names = append(names, m.Name())
+		require.Equal(t, map[string]string{"venue": "coinbase", "environment": "prod", "region": "eu", "product_id": "ETH-USD"},
+			withoutTags(m.Tags(), "type", "side"), m.Name())
+	}
+	require.ElementsMatch(t, []string{"ticker", "market_trades", "coinbase_candle"}, names)
+}
+
+func withoutTags(tags map[string]string, keys ...string) map[string]string {
+	for _, key := range keys {
+		delete(tags, key)
+	}
+	return tags
+}
+
+func TestRestartAfterStop(t *testing.T) {
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		_, _, _ = conn.ReadMessage()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(proTicker))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+
+	for cycle := 1; cycle <= 2; cycle++ {
+		require.NoError(t, wsl.Start(acc))
+		acc.Wait(cycle)
+
+		stopped := make(chan struct{})
+		go func() {
+			wsl.Stop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Stop did not return in cycle %d", cycle)
+		}
+	}
+
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, 2, len(acc.GetTelegrafMetrics()))
+}
+
+func TestTimestampUnits(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.TimestampUnits = "ms"
+	require.NoError(t, wsl.Init())
+
+	wsl.addMetric([]byte(proTicker))
+	require.NoError(t, acc.FirstError())
+
+	m, ok := acc.Get("ticker")
+	require.True(t, ok)
+	require.Equal(t, time.Date(2020, 12, 28, 23, 54, 32, 51000000, time.UTC), m.Time)
+
+	wsl.TimestampUnits = "minutes"
+	require.Error(t, wsl.Init())
+}
+
+func TestTimestampSource(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.TimestampSource = "local"
+	wsl.TimestampUnits = "ms"
+	require.NoError(t, wsl.Init())
+
+	received := time.Date(2021, 1, 2, 3, 4, 5, 6789012, time.UTC)
+	wsl.addReceived([]byte(proTicker), received)
+	require.NoError(t, acc.FirstError())
+
+	m, ok := acc.Get("ticker")
+	require.True(t, ok)
+	require.Equal(t, received.Truncate(time.Millisecond), m.Time)
+
+	wsl.TimestampSource = "server"
+	require.Error(t, wsl.Init())
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	wsl, _ := newTestListener(t)
+	wsl.ReconnectInterval = internal.Duration{Duration: time.Second}
+	wsl.MaxBackoff = internal.Duration{Duration: 5 * time.Second}
+
+	var delays []time.Duration
+	for failures := 0; failures < 5; failures++ {
+		delays = append(delays, wsl.backoff(failures))
+	}
+	require.Equal(t, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second,
+	}, delays)
+}
+
+func TestReconnectResubscribes(t *testing.T) {
+	var connections int32
+	subscriptions := make(chan string, 2)
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		_, subscription, _ := conn.ReadMessage()
+		subscriptions <- string(subscription)
+
+		// drop the first connection, stream on the second
+		if atomic.AddInt32(&connections, 1) == 1 {
+			return
+		}
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(proTicker))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.OnConnectMsg = `{"type": "subscribe"}`
+	wsl.ReconnectInterval = internal.Duration{Duration: time.Millisecond}
+	require.NoError(t, wsl.Start(acc))
+	defer wsl.Stop()
+
+	acc.Wait(1)
+	require.Equal(t, wsl.OnConnectMsg, <-subscriptions)
+	require.Equal(t, wsl.OnConnectMsg, <-subscriptions)
+	_, ok := acc.Get("ticker")
+	require.True(t, ok)
+}
+
+func TestStartReturnsDialError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	require.Error(t, wsl.Start(acc))
+}
+
+func TestAdvancedMarketTrades(t *testing.T) {
+	wsl, acc := newTestListener(t)
+
+	wsl.addMetric([]byte(`{
+  "channel": "market_trades",
+  "client_id": "",
+  "timestamp": "2023-02-09T20:19:35.39625135Z",
+  "sequence_num": 4,
+  "events": [
+    {
+      "type": "snapshot",
+      "trades": [
+        {"trade_id": "12345", "product_id": "ETH-USD", "price": "1260.01", "size": "0.3", "side": "BUY", "time": "2019-08-14T20:42:27.265Z"},
+        {"trade_id": "12346", "product_id": "ETH-USD", "price": "1260.02", "size": "0.1", "side": "SELL", "time": "2019-08-14T20:42:27.266Z"}
+      ]
+    }
+  ]
+}`))
+	require.NoError(t, acc.FirstError())
+
+	acc.AssertContainsTaggedFields(t, "match",
+		map[string]interface{}{"price": 1260.01, "size": 0.3, "trade_id": float64(12345), "sequence_id": float64(4)},
+		map[string]string{"type": "match", "product_id": "ETH-USD", "side": "buy"},
+	)
+	acc.AssertContainsTaggedFields(t, "match",
+		map[string]interface{}{"price": 1260.02, "size": 0.1, "trade_id": float64(12346), "sequence_id": float64(4)},
+		map[string]string{"type": "match", "product_id": "ETH-USD", "side": "sell"},
+	)
+}
@@ -0,0 +1,164 @@
+package coinbase_marketdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+const (
+	priceFormatFloat  = "float"
+	priceFormatString = "string"
+	priceFormatScaled = "scaled"
+
+	defaultPriceScale = 8
+)
+
+// the decimal fields of the pro ticker, by the message key they are parsed from
+var proTickerDecimals = map[string]string{
+	"price":         "price",
+	"open_24h":      "open_24h",
+	"volume_24h":    "volume_24h",
+	"low_24h":       "low_24h",
+	"high_24h":      "high_24h",
+	"volume_30d":    "volume_30d",
+	"best_bid":      "best_bid",
+	"best_ask":      "best_ask",
+	"last_size":     "last_size",
+	"best_bid_size": "best_bid_size",
+	"best_ask_size": "best_ask_size",
+}
+
+// the decimal fields of the advanced trade ticker, by the message key they
+// are parsed from
+var advancedTickerDecimals = map[string]string{
+	"price":         "price",
+	"volume_24h":    "volume_24_h",
+	"low_24h":       "low_24_h",
+	"high_24h":      "high_24_h",
+	"best_bid":      "best_bid",
+	"best_ask":      "best_ask",
+	"best_bid_size": "best_bid_quantity",
+	"best_ask_size": "best_ask_quantity",
+
+	// not sent by the advanced trade feed
+	"open_24h":   "",
+	"volume_30d": "",
+	"last_size":  "",
+}
+
+var l2UpdateDecimals = map[string]string{
+	"price": "price",
+	"qty":   "qty",
+}
+
+var matchDecimals = map[string]string{
+	"price": "price",
+	"size":  "size",
+}
+
+// keepsDecimals reports whether the text of decimal values is needed, as
+// price_format emits them other than as floats
+func (wsl *WebSocketListener) keepsDecimals() bool {
+	return wsl.PriceFormat == priceFormatString || wsl.PriceFormat == priceFormatScaled
+}
+
+// rawDecimal returns the text of a decimal value as received, or "" when it
+// is missing
+func rawDecimal(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case json.Number:
+		return t.String()
+	}
+	return ""
+}
+
+// decimalText returns the text of the decimal values of data by field name,
+// given the message key of each field, or nil unless price_format needs them
+func (wsl *WebSocketListener) decimalText(data map[string]interface{}, keys map[string]string) map[string]string {
+	if !wsl.keepsDecimals() {
+		return nil
+	}
+
+	text := make(map[string]string, len(keys))
+	for field, key := range keys {
+		text[field] = rawDecimal(data[key])
+	}
+	return text
+}
+
+// scaleDecimal converts a decimal to an integer number of 10^-scale units,
+// exactly rather than through a float, truncating any digits beyond scale
+func scaleDecimal(text string, scale int) (int64, error) {
+	r, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return 0, fmt.Errorf("invalid decimal %q", text)
+	}
+
+	factor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	r.Mul(r, new(big.Rat).SetInt(factor))
+
+	scaled := new(big.Int).Quo(r.Num(), r.Denom())
+	if !scaled.IsInt64() {
+		return 0, fmt.Errorf("decimal %q overflows at a scale of %d", text, scale)
+	}
+	return scaled.Int64(), nil
+}
+
+// formatDecimals replaces the float decimal fields of a product's metric by
+// their text as received, or by "<field>_int" integers scaled by the scale of
+// the product. Fields the message did not carry are dropped rather than
+// emitted as zero.
+func (wsl *WebSocketListener) formatDecimals(fields map[string]interface{}, text map[string]string, productId string) error {
+	scale := wsl.PriceScale
+	if productScale, ok := wsl.ProductPriceScales[productId]; ok {
+		scale = productScale
+	}
+
+	for field, value := range text {
+		if _, ok := fields[field]; !ok {
+			continue
+		}
+		delete(fields, field)
+		if value == "" {
+			continue
+		}
+
+		switch wsl.PriceFormat {
+		case priceFormatString:
+			fields[field] = value
+		case priceFormatScaled:
+			scaled, err := scaleDecimal(value, scale)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %s", field, err)
+			}
+			fields[field+"_int"] = scaled
+		}
+	}
+	return nil
+}
+
+func validatePriceFormat(format string, scale int, productScales map[string]int) error {
+	switch format {
+	case "", priceFormatFloat, priceFormatString, priceFormatScaled:
+	default:
+		return fmt.Errorf("invalid price_format %q, must be %q, %q or %q",
+			format, priceFormatFloat, priceFormatString, priceFormatScaled)
+	}
+
+	// 10^18 is the largest power of ten an int64 holds
+	if scale < 0 || scale > 18 {
+		return fmt.Errorf("invalid price_scale %d, must be between 0 and 18", scale)
+	}
+	for productId, productScale := range productScales {
+		if productScale < 0 || productScale > 18 {
+			return fmt.Errorf("invalid price scale %d of %s, must be between 0 and 18", productScale, productId)
+		}
+	}
+	return nil
+}
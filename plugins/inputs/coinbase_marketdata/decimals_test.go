@@ -0,0 +1,86 @@
+package coinbase_marketdata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScaleDecimal(t *testing.T) {
+	tests := []struct {
+		text     string
+		scale    int
+		expected int64
+	}{
+		{"731.99", 8, 73199000000},
+		{"0.00000001", 8, 1},
+		{"0.000000019", 8, 1},
+		{"1.2e-8", 10, 120},
+		{"747", 2, 74700},
+		{"-0.5", 1, -5},
+	}
+	for _, tt := range tests {
+		scaled, err := scaleDecimal(tt.text, tt.scale)
+		require.NoError(t, err, tt.text)
+		require.Equal(t, tt.expected, scaled, tt.text)
+	}
+
+	_, err := scaleDecimal("12.3.4", 8)
+	require.Error(t, err)
+	_, err = scaleDecimal("100000000000", 18)
+	require.Error(t, err)
+}
+
+func TestPriceFormatString(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.PriceFormat = priceFormatString
+	require.NoError(t, wsl.Init())
+
+	wsl.addMetric([]byte(proL2Update))
+	require.NoError(t, acc.FirstError())
+
+	acc.AssertContainsTaggedFields(t, "l2update",
+		map[string]interface{}{"price": "731.99", "qty": "1.24025886"},
+		map[string]string{"type": "l2update", "product_id": "ETH-USD", "side": "sell"},
+	)
+}
+
+func TestPriceFormatScaled(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.PriceFormat = priceFormatScaled
+	wsl.ProductPriceScales = map[string]int{"BTC-USD": 2}
+	require.NoError(t, wsl.Init())
+
+	wsl.addMetric([]byte(proTicker))
+	wsl.addMetric([]byte(advancedTicker))
+	require.NoError(t, acc.FirstError())
+
+	eth, btc := acc.GetTelegrafMetrics()[0], acc.GetTelegrafMetrics()[1]
+	require.Equal(t, "ETH-USD", eth.Tags()["product_id"])
+	price, ok := eth.GetField("price_int")
+	require.True(t, ok)
+	require.Equal(t, int64(73199000000), price)
+	require.False(t, eth.HasField("price"))
+	require.False(t, eth.HasField("best_bid_size_int"))
+
+	require.Equal(t, "BTC-USD", btc.Tags()["product_id"])
+	price, _ = btc.GetField("price_int")
+	require.Equal(t, int64(2193298), price)
+	// not sent by the advanced trade feed
+	require.False(t, btc.HasField("open_24h_int"))
+	require.False(t, btc.HasField("open_24h"))
+}
+
+func TestInvalidPriceFormat(t *testing.T) {
+	wsl, _ := newTestListener(t)
+	wsl.PriceFormat = "fixed"
+	require.Error(t, wsl.Init())
+
+	wsl.PriceFormat = priceFormatScaled
+	wsl.PriceScale = 19
+	require.Error(t, wsl.Init())
+
+	wsl.PriceScale = defaultPriceScale
+	wsl.ProductPriceScales = map[string]int{"BTC-USD": -1}
+	require.Error(t, wsl.Init())
+}
@@ -0,0 +1,69 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+)
+
+// tradeKey identifies the trades of a product on a channel. The ticker and
+// matches channels both carry every trade, so each is tracked on its own.
+func tradeKey(productId string, msgType string) string {
+	return productId + "|" + channelOf(msgType)
+}
+
+// replayedTrade reports whether a ticker or match message of the pro feed
+// carries a trade already received, as replayed by the feed after a
+// reconnect, remembering the trade otherwise
+func (wsl *WebSocketListener) replayedTrade(marketData map[string]interface{}) bool {
+	switch marketData["type"] {
+	case "ticker", "match", "last_match":
+	default:
+		return false
+	}
+
+	tradeId, err := parseInt(marketData["trade_id"])
+	if err != nil {
+		return false
+	}
+	key := tradeKey(fmt.Sprintf("%v", marketData["product_id"]), fmt.Sprintf("%v", marketData["type"]))
+
+	wsl.tradesMutex.Lock()
+	defer wsl.tradesMutex.Unlock()
+
+	if last, ok := wsl.lastTradeIds[key]; ok && tradeId <= last {
+		wsl.duplicateTrades.Incr(1)
+		return true
+	}
+	wsl.lastTradeIds[key] = tradeId
+	return false
+}
+
+// newTrades drops the trades of an advanced trade market_trades message
+// already received, e.g. the snapshot of the latest trades sent after a
+// reconnect. The trades of a message may come in any order, so all are
+// compared with the last trade received before the message.
+func (wsl *WebSocketListener) newTrades(matches []*Match) []*Match {
+	wsl.tradesMutex.Lock()
+	defer wsl.tradesMutex.Unlock()
+
+	before := make(map[string]int64)
+	for _, m := range matches {
+		key := tradeKey(m.ProductId, m.DataType)
+		if last, ok := wsl.lastTradeIds[key]; ok {
+			before[key] = last
+		}
+	}
+
+	fresh := matches[:0]
+	for _, m := range matches {
+		key := tradeKey(m.ProductId, m.DataType)
+		if last, ok := before[key]; ok && m.TradeId <= last {
+			wsl.duplicateTrades.Incr(1)
+			continue
+		}
+		if last, ok := wsl.lastTradeIds[key]; !ok || m.TradeId > last {
+			wsl.lastTradeIds[key] = m.TradeId
+		}
+		fresh = append(fresh, m)
+	}
+	return fresh
+}
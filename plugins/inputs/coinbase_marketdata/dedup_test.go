@@ -0,0 +1,70 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupTrades(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.DedupTrades = true
+	require.NoError(t, wsl.Init())
+	duplicates := wsl.duplicateTrades.Get()
+
+	wsl.addMetric([]byte(proTicker))
+	wsl.addMetric([]byte(proMatch))
+	// replayed after a reconnect
+	wsl.addMetric([]byte(proTicker))
+	wsl.addMetric([]byte(strings.Replace(proMatch, `"type": "match"`, `"type": "last_match"`, 1)))
+	// an older trade, then a newer one
+	wsl.addMetric([]byte(strings.Replace(proTicker, `"trade_id": 71476932`, `"trade_id": 71476931`, 1)))
+	wsl.addMetric([]byte(strings.Replace(proTicker, `"trade_id": 71476932`, `"trade_id": 71476933`, 1)))
+	require.NoError(t, acc.FirstError())
+
+	var tickers, matches int
+	for _, m := range acc.GetTelegrafMetrics() {
+		switch m.Name() {
+		case "ticker":
+			tickers++
+		case "match", "last_match":
+			matches++
+		}
+	}
+	require.Equal(t, 2, tickers)
+	require.Equal(t, 1, matches)
+	require.Equal(t, duplicates+3, wsl.duplicateTrades.Get())
+}
+
+func advancedTrades(tradeIds ...int) []byte {
+	var trades []string
+	for _, id := range tradeIds {
+		trades = append(trades, fmt.Sprintf(
+			`{"trade_id": "%d", "product_id": "ETH-USD", "price": "1260.01", "size": "0.3", "side": "BUY", "time": "2019-08-14T20:42:27.265Z"}`, id))
+	}
+	return []byte(fmt.Sprintf(`{"channel": "market_trades", "timestamp": "2023-02-09T20:19:35.39625135Z", "sequence_num": 0,
+  "events": [{"type": "snapshot", "trades": [%s]}]}`, strings.Join(trades, ",")))
+}
+
+func TestDedupAdvancedTrades(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.DedupTrades = true
+	require.NoError(t, wsl.Init())
+
+	// the snapshot lists the latest trades first
+	wsl.addMetric(advancedTrades(12, 11, 10))
+	require.Equal(t, uint64(3), acc.NMetrics())
+
+	// the snapshot after a reconnect overlaps the trades received
+	wsl.addMetric(advancedTrades(14, 13, 12, 11))
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, uint64(5), acc.NMetrics())
+
+	var tradeIds []interface{}
+	for _, m := range acc.GetTelegrafMetrics() {
+		tradeIds = append(tradeIds, m.Fields()["trade_id"])
+	}
+	require.Equal(t, []interface{}{12.0, 11.0, 10.0, 14.0, 13.0}, tradeIds)
+}
@@ -0,0 +1,94 @@
+package coinbase_marketdata
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf/internal/wsclient"
+	"golang.org/x/net/proxy"
+)
+
+// proxyFunc returns the proxy of http requests, http_proxy_url if set and
+// otherwise the proxy of the environment's HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY variables
+func (wsl *WebSocketListener) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	if wsl.HTTPProxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	u, err := url.Parse(wsl.HTTPProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid http_proxy_url: %w", err)
+	}
+	if wsl.ProxyUsername != "" {
+		u.User = url.UserPassword(wsl.ProxyUsername, wsl.ProxyPassword)
+	}
+	return http.ProxyURL(u), nil
+}
+
+// socks5Dial returns the dial function connecting through socks5_proxy, or
+// nil if none is configured
+func (wsl *WebSocketListener) socks5Dial() (func(network, addr string) (net.Conn, error), error) {
+	if wsl.Socks5Proxy == "" {
+		return nil, nil
+	}
+
+	var auth *proxy.Auth
+	if wsl.ProxyUsername != "" {
+		auth = &proxy.Auth{User: wsl.ProxyUsername, Password: wsl.ProxyPassword}
+	}
+	dialer, err := proxy.SOCKS5("tcp", wsl.Socks5Proxy, auth, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("invalid socks5_proxy: %w", err)
+	}
+	return dialer.Dial, nil
+}
+
+// newDialer builds the websocket dialer, and the client of the REST api,
+// from the TLS and proxy options
+func (wsl *WebSocketListener) newDialer() error {
+	if wsl.HTTPProxyURL != "" && wsl.Socks5Proxy != "" {
+		return errors.New("only one of http_proxy_url and socks5_proxy can be set")
+	}
+
+	tlsCfg, err := wsl.ClientConfig.TLSConfig()
+	if err != nil {
+		return err
+	}
+	proxyFunc, err := wsl.proxyFunc()
+	if err != nil {
+		return err
+	}
+	dial, err := wsl.socks5Dial()
+	if err != nil {
+		return err
+	}
+	if dial != nil {
+		proxyFunc = nil
+	}
+
+	wsl.dialer = &websocket.Dialer{
+		Proxy:             proxyFunc,
+		NetDial:           dial,
+		HandshakeTimeout:  wsclient.HandshakeTimeout,
+		TLSClientConfig:   tlsCfg,
+		EnableCompression: wsl.EnableCompression,
+	}
+
+	if tlsCfg != nil || wsl.HTTPProxyURL != "" || dial != nil {
+		wsl.httpClient = &http.Client{
+			Timeout: wsl.httpClient.Timeout,
+			Transport: &http.Transport{
+				Proxy:           proxyFunc,
+				Dial:            dial,
+				TLSClientConfig: tlsCfg,
+			},
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,324 @@
+package coinbase_marketdata
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/wsclient"
+	"github.com/stretchr/testify/require"
+)
+
+func newTLSTestServer(t *testing.T) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestDialerTrustsConfiguredCA(t *testing.T) {
+	ts := newTLSTestServer(t)
+
+	dir, err := ioutil.TempDir("", "coinbase_marketdata")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600))
+
+	// the server's self-signed certificate is rejected by default
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	require.Error(t, wsl.Start(acc))
+
+	wsl, acc = newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.TLSCA = ca
+	require.NoError(t, wsl.Start(acc))
+	wsl.Stop()
+}
+
+func TestDialerInsecureSkipVerify(t *testing.T) {
+	ts := newTLSTestServer(t)
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.InsecureSkipVerify = true
+	require.NoError(t, wsl.Start(acc))
+	wsl.Stop()
+}
+
+func TestDialerInvalidCA(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.TLSCA = "/nonexistent/ca.pem"
+	require.Error(t, wsl.Start(acc))
+}
+
+// newConnectProxy starts an HTTP proxy tunneling CONNECT requests carrying
+// the given basic auth credentials
+func newConnectProxy(t *testing.T, username string, password string, tunnels *int32) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expected := "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+		if r.Method != http.MethodConnect || r.Header.Get("Proxy-Authorization") != expected {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		client, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		atomic.AddInt32(tunnels, 1)
+		pipe(client, upstream)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// newSocks5Proxy starts a SOCKS5 proxy requiring username/password auth
+func newSocks5Proxy(t *testing.T, username string, password string, tunnels *int32) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			client, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				upstream, err := socks5Handshake(client, username, password)
+				if err != nil {
+					client.Close()
+					return
+				}
+				atomic.AddInt32(tunnels, 1)
+				pipe(client, upstream)
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func socks5Handshake(client net.Conn, username string, password string) (net.Conn, error) {
+	r := bufio.NewReader(client)
+	read := func(n int) []byte {
+		buf := make([]byte, n)
+		_, _ = io.ReadFull(r, buf)
+		return buf
+	}
+
+	// greeting, select username/password auth
+	methods := read(2)
+	read(int(methods[1]))
+	_, _ = client.Write([]byte{5, 2})
+
+	auth := read(2)
+	user := string(read(int(auth[1])))
+	pass := string(read(int(read(1)[0])))
+	if user != username || pass != password {
+		_, _ = client.Write([]byte{1, 1})
+		return nil, errors.New("bad credentials")
+	}
+	_, _ = client.Write([]byte{1, 0})
+
+	// connect request, the test servers are addressed by IPv4
+	request := read(4)
+	if request[3] != 1 {
+		return nil, errors.New("unsupported address type")
+	}
+	ip := net.IP(read(4))
+	port := binary.BigEndian.Uint16(read(2))
+	upstream, err := net.Dial("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+	if err != nil {
+		return nil, err
+	}
+	_, _ = client.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	return upstream, nil
+}
+
+func pipe(a net.Conn, b net.Conn) {
+	go func() {
+		_, _ = io.Copy(a, b)
+		a.Close()
+	}()
+	_, _ = io.Copy(b, a)
+	b.Close()
+}
+
+func newEchoServer(t *testing.T) *httptest.Server {
+	return newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func TestHTTPProxy(t *testing.T) {
+	var tunnels int32
+	ts := newEchoServer(t)
+	proxy := newConnectProxy(t, "user", "secret", &tunnels)
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.HTTPProxyURL = proxy.URL
+	wsl.ProxyUsername = "user"
+	wsl.ProxyPassword = "wrong"
+	require.Error(t, wsl.Start(acc))
+
+	wsl.ProxyPassword = "secret"
+	require.NoError(t, wsl.Start(acc))
+	wsl.Stop()
+	require.Equal(t, int32(1), atomic.LoadInt32(&tunnels))
+}
+
+func TestSocks5Proxy(t *testing.T) {
+	var tunnels int32
+	ts := newEchoServer(t)
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.Socks5Proxy = newSocks5Proxy(t, "user", "secret", &tunnels)
+	wsl.ProxyUsername = "user"
+	wsl.ProxyPassword = "secret"
+	require.NoError(t, wsl.Start(acc))
+	wsl.Stop()
+	require.Equal(t, int32(1), atomic.LoadInt32(&tunnels))
+
+	wsl.HTTPProxyURL = "http://proxy.example.com:3128"
+	require.Error(t, wsl.Start(acc))
+}
+
+func TestDialerEnableCompression(t *testing.T) {
+	extensions := make(chan string, 1)
+	upgrader := websocket.Upgrader{EnableCompression: true}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		extensions <- r.Header.Get("Sec-Websocket-Extensions")
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _, _ = conn.ReadMessage()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(proTicker))
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer ts.Close()
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.EnableCompression = true
+	require.NoError(t, wsl.Start(acc))
+	defer wsl.Stop()
+
+	require.Contains(t, <-extensions, "permessage-deflate")
+	require.Eventually(t, func() bool { return acc.HasMeasurement("ticker") }, 5*time.Second, time.Millisecond)
+
+	// not negotiated by default
+	wsl, acc = newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	require.NoError(t, wsl.Start(acc))
+	defer wsl.Stop()
+
+	require.NotContains(t, <-extensions, "permessage-deflate")
+}
+
+func TestMessageCompression(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(proTicker))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		_, _, _ = conn.ReadMessage()
+		_ = conn.WriteMessage(websocket.BinaryMessage, []byte("corrupt"))
+		_ = conn.WriteMessage(websocket.BinaryMessage, buf.Bytes())
+		_, _, _ = conn.ReadMessage()
+	})
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.ProductIds = []string{"ETH-USD"}
+	wsl.Channels = []string{"ticker"}
+	wsl.MessageCompression = "zstd"
+	require.Error(t, wsl.Init())
+
+	wsl.MessageCompression = "gzip"
+	require.NoError(t, wsl.Init())
+	require.NoError(t, wsl.Start(acc))
+	defer wsl.Stop()
+
+	require.Eventually(t, func() bool { return acc.HasMeasurement("ticker") }, 5*time.Second, time.Millisecond)
+	require.Contains(t, acc.FirstError().Error(), "unable to decompress incoming msg")
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	var connections int32
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		_, _, _ = conn.ReadMessage()
+		if atomic.AddInt32(&connections, 1) == 1 {
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "snapshot", "bids": [`+strings.Repeat(`["1", "1"],`, 1024)+`]}`))
+		} else {
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(proTicker))
+		}
+		_, _, _ = conn.ReadMessage()
+	})
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.ProductIds = []string{"ETH-USD"}
+	wsl.Channels = []string{"ticker"}
+	wsl.MaxMessageSize = internal.Size{Size: -1}
+	require.Error(t, wsl.Init())
+
+	wsl.MaxMessageSize = internal.Size{Size: 4096}
+	wsl.ReconnectInterval = internal.Duration{Duration: 10 * time.Millisecond}
+	require.NoError(t, wsl.Init())
+	require.NoError(t, wsl.Start(acc))
+	defer wsl.Stop()
+
+	// the oversize snapshot broke the first connection
+	require.Eventually(t, func() bool { return acc.HasMeasurement("ticker") }, 5*time.Second, time.Millisecond)
+	require.True(t, errors.Is(acc.FirstError(), wsclient.ErrMessageTooLarge))
+	require.Equal(t, int64(1), wsl.clientStat(oversizeMessages))
+}
@@ -0,0 +1,104 @@
+package coinbase_marketdata
+
+import (
+	"time"
+)
+
+const defaultDowntimeWindow = time.Hour
+
+// outage is a period during which no connection to the server was up
+type outage struct {
+	start time.Time
+	end   time.Time
+}
+
+// connectionChanged records the start or end of an outage when the first
+// connection comes up or the last one goes down
+func (wsl *WebSocketListener) connectionChanged(now time.Time) {
+	if wsl.RestFallbackAfter.Duration > 0 {
+		wsl.disconnectionChanged(now)
+	}
+
+	if wsl.MaxDowntime.Duration <= 0 {
+		return
+	}
+
+	up, _ := wsl.Healthy()
+
+	wsl.downtimeMutex.Lock()
+	if !up && wsl.downSince.IsZero() {
+		wsl.downSince = now
+	} else if up && !wsl.downSince.IsZero() {
+		wsl.outages = append(wsl.outages, outage{start: wsl.downSince, end: now})
+		wsl.downSince = time.Time{}
+	}
+	wsl.downtimeMutex.Unlock()
+
+	wsl.checkDowntime(now)
+}
+
+// downtime returns the time spent disconnected within the downtime_window
+// before now, including an ongoing outage. Must be called with
+// downtimeMutex held.
+func (wsl *WebSocketListener) downtime(now time.Time) time.Duration {
+	windowStart := now.Add(-wsl.DowntimeWindow.Duration)
+
+	var total time.Duration
+	outages := wsl.outages[:0]
+	for _, o := range wsl.outages {
+		if !o.end.After(windowStart) {
+			// outside the window for good
+			continue
+		}
+		outages = append(outages, o)
+
+		start := o.start
+		if start.Before(windowStart) {
+			start = windowStart
+		}
+		total += o.end.Sub(start)
+	}
+	wsl.outages = outages
+
+	if !wsl.downSince.IsZero() {
+		start := wsl.downSince
+		if start.Before(windowStart) {
+			start = windowStart
+		}
+		total += now.Sub(start)
+	}
+
+	return total
+}
+
+// checkDowntime emits a coinbase_downtime_exceeded metric when the time
+// spent disconnected within the window first exceeds max_downtime. The alert
+// is rearmed once the downtime has dropped below max_downtime again.
+func (wsl *WebSocketListener) checkDowntime(now time.Time) {
+	if wsl.MaxDowntime.Duration <= 0 {
+		return
+	}
+
+	wsl.downtimeMutex.Lock()
+	downtime := wsl.downtime(now)
+	exceeded := downtime > wsl.MaxDowntime.Duration
+	alert := exceeded && !wsl.downtimeAlerted
+	wsl.downtimeAlerted = exceeded
+	wsl.downtimeMutex.Unlock()
+
+	if !alert {
+		return
+	}
+
+	wsl.AddFields("coinbase_downtime_exceeded",
+		map[string]interface{}{
+			"downtime_seconds":     downtime.Seconds(),
+			"max_downtime_seconds": wsl.MaxDowntime.Duration.Seconds(),
+			"window_seconds":       wsl.DowntimeWindow.Duration.Seconds(),
+		},
+		map[string]string{
+			"service_address": wsl.ServiceAddress,
+		},
+		now,
+	)
+}
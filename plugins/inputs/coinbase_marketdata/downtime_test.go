@@ -0,0 +1,49 @@
+package coinbase_marketdata
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDowntimeExceeded(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.MaxDowntime = internal.Duration{Duration: 5 * time.Minute}
+	c := &connection{name: "primary", up: true}
+	wsl.connections = []*connection{c}
+
+	flap := func(down time.Time, up time.Time) {
+		c.setUp(false)
+		wsl.connectionChanged(down)
+		c.setUp(true)
+		wsl.connectionChanged(up)
+	}
+
+	t0 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	flap(t0, t0.Add(3*time.Minute))
+	require.Empty(t, acc.GetTelegrafMetrics())
+
+	flap(t0.Add(10*time.Minute), t0.Add(13*time.Minute))
+	acc.AssertContainsTaggedFields(t, "coinbase_downtime_exceeded",
+		map[string]interface{}{
+			"downtime_seconds":     360.0,
+			"max_downtime_seconds": 300.0,
+			"window_seconds":       3600.0,
+		},
+		map[string]string{"service_address": wsl.ServiceAddress},
+	)
+
+	// still above max_downtime, not alerted again
+	flap(t0.Add(20*time.Minute), t0.Add(21*time.Minute))
+	require.Equal(t, 1, len(acc.GetTelegrafMetrics()))
+
+	// the outages have left the window, an ongoing one alerts again
+	c.setUp(false)
+	wsl.connectionChanged(t0.Add(2 * time.Hour))
+	wsl.checkDowntime(t0.Add(2*time.Hour + 4*time.Minute))
+	require.Equal(t, 1, len(acc.GetTelegrafMetrics()))
+	wsl.checkDowntime(t0.Add(2*time.Hour + 6*time.Minute))
+	require.Equal(t, 2, len(acc.GetTelegrafMetrics()))
+}
@@ -0,0 +1,66 @@
+package coinbase_marketdata
+
+import (
+	"errors"
+	"time"
+
+	"github.com/influxdata/telegraf/internal/wsclient"
+)
+
+const defaultFailoverAfter = 3
+
+// addresses returns the endpoints to connect to in order of preference
+func (wsl *WebSocketListener) addresses() []string {
+	if len(wsl.ServiceAddresses) > 0 {
+		return wsl.ServiceAddresses
+	}
+	return []string{wsl.ServiceAddress}
+}
+
+// rotate moves c on to the next endpoint of service_addresses after it
+// failed to reconnect to its current one failover_after times in a row
+func (wsl *WebSocketListener) rotate(c *connection) {
+	addresses := wsl.addresses()
+	if len(addresses) < 2 {
+		return
+	}
+
+	c.mutex.Lock()
+	from := c.address
+	c.endpoint = (c.endpoint + 1) % len(addresses)
+	c.address = addresses[c.endpoint]
+	to := c.address
+	c.mutex.Unlock()
+
+	wsl.Log.Warnf("Failing over %s connection from %s to %s", c.name, from, to)
+
+	wsl.AddFields("coinbase_connection",
+		map[string]interface{}{
+			"state":      "rotated",
+			"connection": c.name,
+			"from":       from,
+			"to":         to,
+		},
+		map[string]string{
+			"service_address": wsl.ServiceAddress,
+		},
+		time.Now(),
+	)
+}
+
+// connectFirst starts the client of c on its endpoint or, failing that, on
+// the next endpoints of service_addresses in turn. A rejected subscription
+// isn't retried, as the other endpoints would reject it as well.
+func (wsl *WebSocketListener) connectFirst(c *connection) error {
+	var err error
+	for range wsl.addresses() {
+		err = c.client.Start(wsl.Accumulator)
+		if err == nil || wsclient.IsPermanent(err) ||
+			errors.Is(err, errSubscriptionRejected) || errors.Is(err, errSubscriptionIncomplete) {
+			return err
+		}
+		wsl.Log.Warnf("Unable to connect %s connection to %s: %s", c.name, c.getAddress(), err)
+		wsl.rotate(c)
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package coinbase_marketdata
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/stretchr/testify/require"
+)
+
+func newTickerServer(t *testing.T) string {
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		_, _, _ = conn.ReadMessage()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(proTicker))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	return wsURL(ts)
+}
+
+func TestStartSkipsUnreachableEndpoint(t *testing.T) {
+	unreachable := newTestServer(t, func(conn *websocket.Conn) {})
+	unreachable.Close()
+	live := newTickerServer(t)
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddresses = []string{wsURL(unreachable), live}
+	require.NoError(t, wsl.Init())
+	require.NoError(t, wsl.Start(acc))
+
+	acc.Wait(2)
+	wsl.Stop()
+
+	acc.AssertContainsTaggedFields(t, "coinbase_connection",
+		map[string]interface{}{
+			"state":      "rotated",
+			"connection": "primary",
+			"from":       wsURL(unreachable),
+			"to":         live,
+		},
+		map[string]string{"service_address": wsURL(unreachable)},
+	)
+
+	m, ok := acc.Get("ticker")
+	require.True(t, ok)
+	require.Equal(t, live, m.Tags["endpoint"])
+}
+
+func TestReconnectRotatesEndpoint(t *testing.T) {
+	// accepts a single connection and then rejects every handshake
+	var handshakes int32
+	first := newTestServer(t, func(conn *websocket.Conn) {
+		_, _, _ = conn.ReadMessage()
+	})
+	upgrade := first.Config.Handler
+	first.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&handshakes, 1) > 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		upgrade.ServeHTTP(w, r)
+	})
+	live := newTickerServer(t)
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddresses = []string{wsURL(first), live}
+	wsl.ReconnectInterval = internal.Duration{Duration: time.Millisecond}
+	wsl.FailoverAfter = 2
+	require.NoError(t, wsl.Init())
+	require.NoError(t, wsl.Start(acc))
+	defer wsl.Stop()
+
+	acc.Wait(2)
+	require.Equal(t, live, wsl.activeConnection("").getAddress())
+	// the first endpoint was retried failover_after times
+	require.Equal(t, int32(3), atomic.LoadInt32(&handshakes))
+}
@@ -0,0 +1,89 @@
+package coinbase_marketdata
+
+import (
+	"sort"
+	"time"
+)
+
+// rememberTickers keeps the latest ticker of every product, to be joined
+// with its order book by emitMarkets
+func (wsl *WebSocketListener) rememberTickers(marketData map[string]interface{}) {
+	var tickers []*Ticker
+	if wsl.isAdvancedTrade(marketData) {
+		tickers, _ = wsl.parseAdvanced(marketData)
+	} else if marketData["type"] == "ticker" {
+		tickers = append(tickers, wsl.parseTicker(marketData))
+	}
+	if len(tickers) == 0 {
+		return
+	}
+
+	wsl.tickersMutex.Lock()
+	defer wsl.tickersMutex.Unlock()
+	for _, ticker := range tickers {
+		wsl.lastTickers[ticker.ProductId] = ticker
+	}
+}
+
+// emitMarkets emits one coinbase_market metric per product, combining its
+// latest ticker with the top of its order book. Products with only one of
+// the two emit the fields available.
+func (wsl *WebSocketListener) emitMarkets(now time.Time) {
+	markets := make(map[string]map[string]interface{})
+	fieldsOf := func(productId string) map[string]interface{} {
+		fields, ok := markets[productId]
+		if !ok {
+			fields = make(map[string]interface{})
+			markets[productId] = fields
+		}
+		return fields
+	}
+
+	wsl.tickersMutex.Lock()
+	for productId, ticker := range wsl.lastTickers {
+		fields := fieldsOf(productId)
+		fields["price"] = ticker.Price
+		fields["best_bid"] = ticker.BestBid
+		fields["best_ask"] = ticker.BestAsk
+		fields["last_size"] = ticker.Size
+		fields["open_24h"] = ticker.Open24H
+		fields["high_24h"] = ticker.High24H
+		fields["low_24h"] = ticker.Low24H
+		fields["volume_24h"] = ticker.Volume24H
+		fields["volume_30d"] = ticker.Volume30D
+	}
+	wsl.tickersMutex.Unlock()
+
+	for _, productId := range wsl.books.productIds() {
+		bids, _ := wsl.books.levels(productId, "buy", 1)
+		asks, _ := wsl.books.levels(productId, "sell", 1)
+		if len(bids) == 0 && len(asks) == 0 {
+			continue
+		}
+
+		fields := fieldsOf(productId)
+		if len(bids) > 0 {
+			fields["book_bid"] = bids[0].Price
+			fields["book_bid_size"] = bids[0].Size
+		}
+		if len(asks) > 0 {
+			fields["book_ask"] = asks[0].Price
+			fields["book_ask_size"] = asks[0].Size
+		}
+	}
+
+	productIds := make([]string, 0, len(markets))
+	for productId := range markets {
+		productIds = append(productIds, productId)
+	}
+	sort.Strings(productIds)
+
+	for _, productId := range productIds {
+		wsl.AddFields("coinbase_market", markets[productId],
+			map[string]string{
+				"product_id": productId,
+			},
+			now,
+		)
+	}
+}
@@ -0,0 +1,71 @@
+package coinbase_marketdata
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnrichJoinsTickerAndBook(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.Enrich = true
+
+	wsl.addMetric([]byte(proSnapshot))
+	wsl.addMetric([]byte(proTicker))
+	wsl.addMetric([]byte(advancedTicker))
+	wsl.addMetric([]byte(strings.Replace(proSnapshot, "ETH-USD", "LTC-USD", 1)))
+	require.NoError(t, acc.FirstError())
+
+	acc.ClearMetrics()
+	now := time.Now()
+	wsl.emitMarkets(now)
+
+	acc.AssertContainsTaggedFields(t, "coinbase_market",
+		map[string]interface{}{
+			"price":         731.99,
+			"best_bid":      731.83,
+			"best_ask":      731.99,
+			"last_size":     0.24169456,
+			"open_24h":      684.11,
+			"high_24h":      747.0,
+			"low_24h":       680.9,
+			"volume_24h":    395831.08785795,
+			"volume_30d":    6144317.83380943,
+			"book_bid":      731.83,
+			"book_bid_size": 1.0,
+			"book_ask":      731.99,
+			"book_ask_size": 0.5,
+		},
+		map[string]string{"product_id": "ETH-USD"},
+	)
+
+	// ticker without a book
+	acc.AssertContainsTaggedFields(t, "coinbase_market",
+		map[string]interface{}{
+			"price":      21932.98,
+			"best_bid":   21932.97,
+			"best_ask":   21932.98,
+			"last_size":  0.0,
+			"open_24h":   0.0,
+			"high_24h":   23011.18,
+			"low_24h":    21835.29,
+			"volume_24h": 16038.28770938,
+			"volume_30d": 0.0,
+		},
+		map[string]string{"product_id": "BTC-USD"},
+	)
+
+	// book without a ticker
+	acc.AssertContainsTaggedFields(t, "coinbase_market",
+		map[string]interface{}{
+			"book_bid":      731.83,
+			"book_bid_size": 1.0,
+			"book_ask":      731.99,
+			"book_ask_size": 0.5,
+		},
+		map[string]string{"product_id": "LTC-USD"},
+	)
+	require.Equal(t, 3, len(acc.GetTelegrafMetrics()))
+}
@@ -0,0 +1,56 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+)
+
+const (
+	environmentProduction = "production"
+	environmentSandbox    = "sandbox"
+)
+
+// endpoints are the websocket feed and REST api of an environment
+type endpoints struct {
+	feed         string
+	advancedFeed string
+	rest         string
+}
+
+var environments = map[string]endpoints{
+	environmentProduction: {
+		feed:         "wss://ws-feed.pro.coinbase.com",
+		advancedFeed: "wss://advanced-trade-ws.coinbase.com",
+		rest:         defaultRestAddress,
+	},
+	environmentSandbox: {
+		feed: "wss://ws-feed-public.sandbox.pro.coinbase.com",
+		rest: "https://api-public.sandbox.pro.coinbase.com",
+	},
+}
+
+// applyEnvironment defaults service_address and rest_address to the
+// endpoints of environment, leaving the addresses configured explicitly
+func (wsl *WebSocketListener) applyEnvironment() error {
+	if wsl.Environment == "" {
+		wsl.Environment = environmentProduction
+	}
+	env, ok := environments[wsl.Environment]
+	if !ok {
+		return fmt.Errorf("invalid environment %q, must be %q or %q",
+			wsl.Environment, environmentProduction, environmentSandbox)
+	}
+
+	if wsl.ServiceAddress == "" {
+		wsl.ServiceAddress = env.feed
+		if wsl.APIVersion == apiVersionAdvanced {
+			if env.advancedFeed == "" {
+				return fmt.Errorf("the %s environment has no advanced trade feed", wsl.Environment)
+			}
+			wsl.ServiceAddress = env.advancedFeed
+		}
+	}
+	if wsl.RestAddress == "" {
+		wsl.RestAddress = env.rest
+	}
+	return nil
+}
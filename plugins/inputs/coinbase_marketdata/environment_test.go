@@ -0,0 +1,41 @@
+package coinbase_marketdata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironment(t *testing.T) {
+	wsl, _ := newTestListener(t)
+	require.NoError(t, wsl.Init())
+	require.Equal(t, "wss://ws-feed.pro.coinbase.com", wsl.ServiceAddress)
+	require.Equal(t, "https://api.pro.coinbase.com", wsl.RestAddress)
+
+	wsl, _ = newTestListener(t)
+	wsl.APIVersion = apiVersionAdvanced
+	require.NoError(t, wsl.Init())
+	require.Equal(t, "wss://advanced-trade-ws.coinbase.com", wsl.ServiceAddress)
+
+	wsl, _ = newTestListener(t)
+	wsl.Environment = environmentSandbox
+	require.NoError(t, wsl.Init())
+	require.Equal(t, "wss://ws-feed-public.sandbox.pro.coinbase.com", wsl.ServiceAddress)
+	require.Equal(t, "https://api-public.sandbox.pro.coinbase.com", wsl.RestAddress)
+
+	// explicit addresses take precedence
+	wsl, _ = newTestListener(t)
+	wsl.Environment = environmentSandbox
+	wsl.ServiceAddress = "wss://relay.example.com"
+	require.NoError(t, wsl.Init())
+	require.Equal(t, "wss://relay.example.com", wsl.ServiceAddress)
+
+	wsl, _ = newTestListener(t)
+	wsl.Environment = environmentSandbox
+	wsl.APIVersion = apiVersionAdvanced
+	require.Error(t, wsl.Init())
+
+	wsl, _ = newTestListener(t)
+	wsl.Environment = "staging"
+	require.Error(t, wsl.Init())
+}
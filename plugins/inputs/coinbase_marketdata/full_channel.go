@@ -0,0 +1,63 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+)
+
+// the order lifecycle message types of the full channel, besides match which
+// is shared with the matches channel
+var orderEventTypes = map[string]bool{
+	"received": true,
+	"open":     true,
+	"done":     true,
+	"change":   true,
+}
+
+// takes in a map of a full channel order event in the format of
+// {
+//  "type": "done",
+//  "time": "2014-11-07T08:19:27.028459Z",
+//  "product_id": "BTC-USD",
+//  "sequence": 10,
+//  "price": "200.2",
+//  "order_id": "d50ec984-77a8-460a-b958-66f114b0de9b",
+//  "reason": "filled",
+//  "side": "sell",
+//  "remaining_size": "0"
+// }
+// and returns a coinbase_order metric. Numeric fields are only present on
+// some event types, e.g. market orders have no price. The metric is built
+// directly rather than through the parser, as the order id and reason would
+// otherwise be dropped.
+func (wsl *WebSocketListener) parseOrderEvent(event map[string]interface{}, received time.Time) (telegraf.Metric, error) {
+	fields := make(map[string]interface{})
+	for _, key := range []string{"order_id", "order_type", "reason"} {
+		if v, ok := event[key].(string); ok {
+			fields[key] = v
+		}
+	}
+	for _, key := range []string{"price", "size", "remaining_size", "funds", "new_size", "old_size"} {
+		if v := optionalFloat(event[key]); v != nil {
+			fields[key] = *v
+		}
+	}
+	if sequenceId, err := parseInt(event["sequence"]); err == nil {
+		fields["sequence_id"] = sequenceId
+	}
+
+	ts := wsl.eventTime(event["time"], received)
+
+	return metric.New("coinbase_order",
+		map[string]string{
+			"type":       fmt.Sprintf("%v", event["type"]),
+			"product_id": fmt.Sprintf("%v", event["product_id"]),
+			"side":       fmt.Sprintf("%v", event["side"]),
+		},
+		fields,
+		ts,
+	)
+}
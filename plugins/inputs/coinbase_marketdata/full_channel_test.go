@@ -0,0 +1,65 @@
+package coinbase_marketdata
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFullChannelOrderEvents(t *testing.T) {
+	wsl, acc := newTestListener(t)
+
+	for _, message := range []string{
+		`{"type": "received", "time": "2014-11-07T08:19:27.028459Z", "product_id": "BTC-USD", "sequence": 10,
+		  "order_id": "d50ec984-77a8-460a-b958-66f114b0de9b", "size": "1.34", "price": "502.1", "side": "buy", "order_type": "limit"}`,
+		`{"type": "received", "time": "2014-11-07T08:19:27.028459Z", "product_id": "BTC-USD", "sequence": 11,
+		  "order_id": "dddec984-77a8-460a-b958-66f114b0de9b", "funds": "3000.234", "side": "buy", "order_type": "market"}`,
+		`{"type": "open", "time": "2014-11-07T08:19:27.028459Z", "product_id": "BTC-USD", "sequence": 12,
+		  "order_id": "d50ec984-77a8-460a-b958-66f114b0de9b", "price": "200.2", "remaining_size": "1.00", "side": "sell"}`,
+		`{"type": "done", "time": "2014-11-07T08:19:27.028459Z", "product_id": "BTC-USD", "sequence": 13,
+		  "price": "200.2", "order_id": "d50ec984-77a8-460a-b958-66f114b0de9b", "reason": "filled", "side": "sell", "remaining_size": "0"}`,
+		`{"type": "change", "time": "2014-11-07T08:19:27.028459Z", "sequence": 14, "order_id": "ac928c66-ca53-498f-9c13-a110027a60e8",
+		  "product_id": "BTC-USD", "new_size": "5.23512", "old_size": "12.234412", "price": "400.23", "side": "sell"}`,
+	} {
+		wsl.addMetric([]byte(message))
+	}
+	require.NoError(t, acc.FirstError())
+
+	metrics := acc.GetTelegrafMetrics()
+	require.Len(t, metrics, 5)
+	for _, m := range metrics {
+		require.Equal(t, "coinbase_order", m.Name())
+		require.Equal(t, time.Date(2014, 11, 7, 8, 19, 27, 28459000, time.UTC), m.Time())
+	}
+
+	acc.AssertContainsTaggedFields(t, "coinbase_order",
+		map[string]interface{}{
+			"order_id":    "dddec984-77a8-460a-b958-66f114b0de9b",
+			"order_type":  "market",
+			"funds":       3000.234,
+			"sequence_id": int64(11),
+		},
+		map[string]string{"type": "received", "product_id": "BTC-USD", "side": "buy"},
+	)
+	acc.AssertContainsTaggedFields(t, "coinbase_order",
+		map[string]interface{}{
+			"order_id":       "d50ec984-77a8-460a-b958-66f114b0de9b",
+			"reason":         "filled",
+			"price":          200.2,
+			"remaining_size": 0.0,
+			"sequence_id":    int64(13),
+		},
+		map[string]string{"type": "done", "product_id": "BTC-USD", "side": "sell"},
+	)
+	acc.AssertContainsTaggedFields(t, "coinbase_order",
+		map[string]interface{}{
+			"order_id":    "ac928c66-ca53-498f-9c13-a110027a60e8",
+			"price":       400.23,
+			"new_size":    5.23512,
+			"old_size":    12.234412,
+			"sequence_id": int64(14),
+		},
+		map[string]string{"type": "change", "product_id": "BTC-USD", "side": "sell"},
+	)
+}
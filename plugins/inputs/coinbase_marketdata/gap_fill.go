@@ -0,0 +1,189 @@
+package coinbase_marketdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultGapFillMaxTrades = 1000
+
+	// the most trades the /products/{id}/trades REST endpoint returns at once
+	restTradesLimit = 1000
+
+	// the gaps waiting to be filled, further ones are dropped
+	gapFillQueue = 100
+)
+
+// tradeGap is a range of trades of a product missing from the stream,
+// between the trade ids last and next received on either side of it
+type tradeGap struct {
+	productId string
+	last      int64
+	next      int64
+}
+
+func (g tradeGap) missed() int64 {
+	return g.next - g.last - 1
+}
+
+// checkTradeGap detects trades missing from the match and last_match
+// messages of the pro feed, whose trade ids are contiguous per product, and
+// queues them to be fetched from the REST api. The last_match sent upon
+// subscribing also covers the trades missed while reconnecting.
+func (wsl *WebSocketListener) checkTradeGap(marketData map[string]interface{}) {
+	switch marketData["type"] {
+	case "match", "last_match":
+	default:
+		return
+	}
+
+	tradeId, err := parseInt(marketData["trade_id"])
+	if err != nil {
+		return
+	}
+	productId := fmt.Sprintf("%v", marketData["product_id"])
+
+	wsl.gapMutex.Lock()
+	last, ok := wsl.lastMatchIds[productId]
+	if !ok || tradeId > last {
+		wsl.lastMatchIds[productId] = tradeId
+	}
+	wsl.gapMutex.Unlock()
+
+	if ok && tradeId > last+1 {
+		wsl.queueGapFill(tradeGap{productId: productId, last: last, next: tradeId})
+	}
+}
+
+// queueGapFill hands a gap to runGapFill without blocking the stream
+func (wsl *WebSocketListener) queueGapFill(gap tradeGap) {
+	select {
+	case wsl.gaps <- gap:
+	default:
+		wsl.Log.Warnf("Too many gaps waiting to be filled, not filling the %d trades of %s missed after trade %d",
+			gap.missed(), gap.productId, gap.last)
+	}
+}
+
+// runGapFill fills the gaps queued until the plugin stops
+func (wsl *WebSocketListener) runGapFill() {
+	for {
+		select {
+		case <-wsl.ctx.Done():
+			return
+		case gap := <-wsl.gaps:
+			wsl.fillGap(gap)
+		}
+	}
+}
+
+// fetchTrades returns up to limit trades of a product with a trade id below
+// before, the latest first, decoded like the match messages of the feed
+func (wsl *WebSocketListener) fetchTrades(productId string, before int64, limit int) ([]map[string]interface{}, error) {
+	url := fmt.Sprintf("%s/products/%s/trades?after=%d&limit=%d", wsl.RestAddress, productId, before, limit)
+
+	req, err := http.NewRequestWithContext(wsl.ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := wsl.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+
+	var trades []map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&trades); err != nil {
+		return nil, fmt.Errorf("decoding %s: %s", url, err)
+	}
+	return trades, nil
+}
+
+// fillGap fetches the trades of a gap from the REST api and emits them as
+// match metrics at the time of the trade, paging back from the trade
+// received after the gap. Of a gap larger than gap_fill_max_trades only the
+// latest trades are filled, whose candles are the most likely to be open.
+func (wsl *WebSocketListener) fillGap(gap tradeGap) {
+	wanted := gap.missed()
+	if wanted > int64(wsl.GapFillMaxTrades) {
+		wsl.Log.Warnf("Missed %d trades of %s, filling only the latest %d", wanted, gap.productId, wsl.GapFillMaxTrades)
+		wanted = int64(wsl.GapFillMaxTrades)
+	}
+
+	var trades []map[string]interface{}
+	cursor := gap.next
+	for int64(len(trades)) < wanted {
+		limit := wanted - int64(len(trades))
+		if limit > restTradesLimit {
+			limit = restTradesLimit
+		}
+
+		page, err := wsl.fetchTrades(gap.productId, cursor, int(limit))
+		if err != nil {
+			wsl.AddError(fmt.Errorf("unable to fill the trades of %s missed after trade %d: %s", gap.productId, gap.last, err))
+			break
+		}
+		if len(page) == 0 {
+			break
+		}
+
+		for _, trade := range page {
+			tradeId, err := parseInt(trade["trade_id"])
+			if err != nil || tradeId <= gap.last || tradeId >= cursor {
+				continue
+			}
+			trade["type"] = "match"
+			trade["product_id"] = gap.productId
+			trades = append(trades, trade)
+		}
+		next, _ := parseInt(page[len(page)-1]["trade_id"])
+		if next <= gap.last+1 || next >= cursor {
+			break
+		}
+		cursor = next
+	}
+
+	// emitted oldest first, as if received from the stream
+	for i := len(trades) - 1; i >= 0; i-- {
+		wsl.backfill(trades[i])
+	}
+	wsl.backfilledTrades.Incr(int64(len(trades)))
+
+	wsl.AddFields("coinbase_gap_fill",
+		map[string]interface{}{
+			"missed":         gap.missed(),
+			"filled":         len(trades),
+			"first_trade_id": gap.last + 1,
+		},
+		map[string]string{
+			"product_id": gap.productId,
+		},
+	)
+}
+
+// the fields of a match message missing from the trades of the REST api
+var restTradeAbsent = []string{"sequence_id"}
+
+// backfill emits a trade fetched from the REST api like a match message,
+// adding it to its candle if still open
+func (wsl *WebSocketListener) backfill(trade map[string]interface{}) {
+	if wsl.CandleInterval.Duration > 0 {
+		wsl.addTrade(trade)
+	}
+
+	wsl.emitFetched(trade, time.Now(), nil, restTradeAbsent)
+}
+
+func validateGapFill(maxTrades int) error {
+	if maxTrades <= 0 {
+		return fmt.Errorf("invalid gap_fill_max_trades %d, must be positive", maxTrades)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package coinbase_marketdata
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTradesServer serves the trades of BTC-USD with the ids 1 to 100, the
+// latest first, a second apart
+func newTradesServer(t *testing.T, requests *int) *httptest.Server {
+	start := time.Date(2014, 11, 7, 8, 19, 0, 0, time.UTC)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/products/BTC-USD/trades" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		*requests++
+		before, _ := strconv.Atoi(r.URL.Query().Get("after"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+		var trades []string
+		for id := before - 1; id > 0 && len(trades) < limit; id-- {
+			trades = append(trades, fmt.Sprintf(`{"time": "%s", "trade_id": %d, "price": "400.%02d", "size": "0.1", "side": "buy"}`,
+				start.Add(time.Duration(id)*time.Second).Format(time.RFC3339Nano), id, id))
+		}
+		_, _ = w.Write([]byte("[" + strings.Join(trades, ",") + "]"))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func matchOf(tradeId int) []byte {
+	return []byte(strings.Replace(proMatch, `"trade_id": 10`, fmt.Sprintf(`"trade_id": %d`, tradeId), 1))
+}
+
+func TestCheckTradeGap(t *testing.T) {
+	wsl, _ := newTestListener(t)
+	wsl.GapFill = true
+	wsl.gaps = make(chan tradeGap, gapFillQueue)
+
+	wsl.addMetric(matchOf(10))
+	wsl.addMetric(matchOf(11))
+	require.Len(t, wsl.gaps, 0)
+
+	wsl.addMetric(matchOf(15))
+	// a late trade neither moves the last trade id back nor opens a gap
+	wsl.addMetric(matchOf(12))
+	wsl.addMetric(matchOf(16))
+	require.Len(t, wsl.gaps, 1)
+	require.Equal(t, tradeGap{productId: "BTC-USD", last: 11, next: 15}, <-wsl.gaps)
+}
+
+func TestFillGap(t *testing.T) {
+	var requests int
+	wsl, acc := newTestListener(t)
+	wsl.RestAddress = newTradesServer(t, &requests).URL
+	wsl.GapFill = true
+	wsl.ctx = context.Background()
+	backfilled := wsl.backfilledTrades.Get()
+
+	wsl.fillGap(tradeGap{productId: "BTC-USD", last: 11, next: 15})
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, 1, requests)
+	require.Equal(t, backfilled+3, wsl.backfilledTrades.Get())
+
+	var tradeIds []interface{}
+	for _, m := range acc.GetTelegrafMetrics() {
+		if m.Name() == "match" {
+			tradeIds = append(tradeIds, m.Fields()["trade_id"])
+			require.Equal(t, time.Date(2014, 11, 7, 8, 19, int(m.Fields()["trade_id"].(float64)), 0, time.UTC), m.Time())
+		}
+	}
+	require.Equal(t, []interface{}{12.0, 13.0, 14.0}, tradeIds)
+	acc.AssertContainsTaggedFields(t, "coinbase_gap_fill",
+		map[string]interface{}{"missed": int64(3), "filled": 3, "first_trade_id": int64(12)},
+		map[string]string{"product_id": "BTC-USD"},
+	)
+}
+
+func TestFillGapLimitsTrades(t *testing.T) {
+	var requests int
+	wsl, acc := newTestListener(t)
+	wsl.RestAddress = newTradesServer(t, &requests).URL
+	wsl.GapFill = true
+	wsl.GapFillMaxTrades = 20
+	wsl.ctx = context.Background()
+
+	wsl.fillGap(tradeGap{productId: "BTC-USD", last: 1, next: 100})
+	require.NoError(t, acc.FirstError())
+	// the latest trades of the gap
+	m, ok := acc.Get("match")
+	require.True(t, ok)
+	require.Equal(t, 80.0, m.Fields["trade_id"])
+	acc.AssertContainsTaggedFields(t, "coinbase_gap_fill",
+		map[string]interface{}{"missed": int64(98), "filled": 20, "first_trade_id": int64(2)},
+		map[string]string{"product_id": "BTC-USD"},
+	)
+
+	wsl.GapFillMaxTrades = 0
+	require.Error(t, wsl.Init())
+}
@@ -0,0 +1,94 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const defaultHealthMaxSilence = time.Minute
+
+// Healthy reports whether a connection to the server is up and when the last
+// message was received, the zero time if none was received yet
+func (wsl *WebSocketListener) Healthy() (bool, time.Time) {
+	wsl.messageMutex.Lock()
+	lastMessage := wsl.lastMessage
+	wsl.messageMutex.Unlock()
+
+	for _, c := range wsl.connections {
+		if c.isUp() {
+			return true, lastMessage
+		}
+	}
+	return false, lastMessage
+}
+
+// emitStatus emits a coinbase_marketdata_status metric summarizing the
+// health of the feed, for alerting on a disconnected or silent feed
+func (wsl *WebSocketListener) emitStatus(now time.Time) {
+	up, lastMessage := wsl.Healthy()
+
+	fields := map[string]interface{}{
+		"connected":           up,
+		"subscribed_products": wsl.subscribedProducts(),
+		"reconnect_count":     wsl.clientStat(reconnects),
+	}
+	if !lastMessage.IsZero() {
+		fields["seconds_since_last_message"] = now.Sub(lastMessage).Seconds()
+	}
+
+	wsl.AddFields("coinbase_marketdata_status", fields,
+		map[string]string{
+			"service_address": wsl.ServiceAddress,
+		},
+		now,
+	)
+}
+
+func (wsl *WebSocketListener) messageReceived(now time.Time) {
+	wsl.messageMutex.Lock()
+	defer wsl.messageMutex.Unlock()
+	wsl.lastMessage = now
+}
+
+// ServeHTTP answers liveness probes with 200 while the feed is delivering,
+// and 503 when it is disconnected or has been silent for health_max_silence
+func (wsl *WebSocketListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	up, lastMessage := wsl.Healthy()
+
+	switch {
+	case !up:
+		http.Error(w, "disconnected", http.StatusServiceUnavailable)
+	case wsl.HealthMaxSilence.Duration > 0 && time.Since(lastMessage) > wsl.HealthMaxSilence.Duration:
+		http.Error(w, fmt.Sprintf("no message received since %s", lastMessage.Format(time.RFC3339)), http.StatusServiceUnavailable)
+	default:
+		fmt.Fprintf(w, "last message received at %s\n", lastMessage.Format(time.RFC3339Nano))
+	}
+}
+
+func (wsl *WebSocketListener) startHealthServer() error {
+	listener, err := net.Listen("tcp", wsl.HealthAddress)
+	if err != nil {
+		return fmt.Errorf("health server: %w", err)
+	}
+
+	wsl.healthServer = &http.Server{Handler: wsl}
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			wsl.Log.Errorf("Health server error: %s", err)
+		}
+	}(wsl.healthServer)
+
+	return nil
+}
+
+func (wsl *WebSocketListener) stopHealthServer() {
+	if wsl.healthServer == nil {
+		return
+	}
+	if err := wsl.healthServer.Close(); err != nil {
+		wsl.Log.Errorf("Unable to close health server: %s", err)
+	}
+	wsl.healthServer = nil
+}
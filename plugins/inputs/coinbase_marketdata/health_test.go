@@ -0,0 +1,111 @@
+package coinbase_marketdata
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthy(t *testing.T) {
+	wsl, _ := newTestListener(t)
+	wsl.connections = []*connection{{name: "primary"}}
+
+	probe := func() int {
+		rec := httptest.NewRecorder()
+		wsl.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		return rec.Code
+	}
+
+	up, last := wsl.Healthy()
+	require.False(t, up)
+	require.True(t, last.IsZero())
+	require.Equal(t, http.StatusServiceUnavailable, probe())
+
+	wsl.connections[0].setUp(true)
+	require.Equal(t, http.StatusServiceUnavailable, probe())
+
+	now := time.Now()
+	wsl.messageReceived(now)
+	up, last = wsl.Healthy()
+	require.True(t, up)
+	require.Equal(t, now, last)
+	require.Equal(t, http.StatusOK, probe())
+
+	wsl.messageReceived(now.Add(-2 * time.Minute))
+	require.Equal(t, http.StatusServiceUnavailable, probe())
+
+	wsl.HealthMaxSilence = internal.Duration{}
+	require.Equal(t, http.StatusOK, probe())
+}
+
+func TestHealthServerStoppedWithPlugin(t *testing.T) {
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	// reserve a free port for the health server
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.HealthAddress = address
+	require.NoError(t, wsl.Start(acc))
+
+	// connected but silent
+	resp, err := http.Get("http://" + address + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	wsl.Stop()
+
+	_, err = http.Get("http://" + address + "/")
+	require.Error(t, err)
+}
+
+func TestGatherEmitsStatus(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.ProductIds = []string{"ETH-USD", "BTC-USD"}
+	wsl.Channels = []string{"ticker", "level2"}
+	wsl.connections = []*connection{{name: "primary", up: true}}
+
+	require.NoError(t, wsl.Gather(acc))
+	acc.AssertContainsTaggedFields(t, "coinbase_marketdata_status",
+		map[string]interface{}{
+			"connected":           true,
+			"subscribed_products": 2,
+			"reconnect_count":     int64(0),
+		},
+		map[string]string{"service_address": wsl.ServiceAddress},
+	)
+
+	acc.ClearMetrics()
+	wsl.connections[0].setUp(false)
+	wsl.messageReceived(time.Now().Add(-time.Minute))
+	require.NoError(t, wsl.Gather(acc))
+
+	m, ok := acc.Get("coinbase_marketdata_status")
+	require.True(t, ok)
+	require.Equal(t, false, m.Fields["connected"])
+	require.GreaterOrEqual(t, m.Fields["seconds_since_last_message"].(float64), 60.0)
+}
+
+func TestSubscribedProductsOfOnConnectMsg(t *testing.T) {
+	wsl, _ := newTestListener(t)
+	wsl.OnConnectMsg = `{"type": "subscribe", "product_ids": ["ETH-USD"],
+		"channels": ["level2", {"name": "ticker", "product_ids": ["ETH-USD", "BTC-USD"]}]}`
+	require.Equal(t, 2, wsl.subscribedProducts())
+}
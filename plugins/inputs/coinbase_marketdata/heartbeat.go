@@ -0,0 +1,116 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+	"time"
+)
+
+type Heartbeat struct {
+	DataType    string `json:"type"`
+	ProductId   string `json:"product_id"`
+	Time        string `json:"time"`
+	SequenceId  int64  `json:"sequence_id"`
+	LastTradeId int64  `json:"last_trade_id"`
+}
+
+func (h *Heartbeat) values() map[string]interface{} {
+	return map[string]interface{}{
+		"type":          h.DataType,
+		"product_id":    h.ProductId,
+		"time":          h.Time,
+		"sequence_id":   float64(h.SequenceId),
+		"last_trade_id": float64(h.LastTradeId),
+	}
+}
+
+func (h *Heartbeat) decimalText() map[string]string {
+	return nil
+}
+
+// the last heartbeat received for a product
+type heartbeatState struct {
+	received    time.Time
+	sequenceId  int64
+	lastTradeId int64
+	missed      bool
+}
+
+// takes in a map of heartbeat data type in the format of
+// {
+//  "type": "heartbeat",
+//  "sequence": 90,
+//  "last_trade_id": 20,
+//  "product_id": "BTC-USD",
+//  "time": "2014-11-07T08:19:28.464459Z"
+// }
+func (wsl *WebSocketListener) parseHeartbeat(heartbeatData map[string]interface{}) *Heartbeat {
+	sequenceId, _ := parseInt(heartbeatData["sequence"])
+	lastTradeId, _ := parseInt(heartbeatData["last_trade_id"])
+
+	return &Heartbeat{
+		DataType:    fmt.Sprintf("%v", heartbeatData["type"]),
+		ProductId:   fmt.Sprintf("%v", heartbeatData["product_id"]),
+		Time:        fmt.Sprintf("%v", heartbeatData["time"]),
+		SequenceId:  sequenceId,
+		LastTradeId: lastTradeId,
+	}
+}
+
+// rememberHeartbeat records the heartbeat of a product received at now,
+// rearming the missed heartbeat event
+func (wsl *WebSocketListener) rememberHeartbeat(heartbeat *Heartbeat, now time.Time) {
+	wsl.heartbeatsMutex.Lock()
+	defer wsl.heartbeatsMutex.Unlock()
+	wsl.heartbeats[heartbeat.ProductId] = &heartbeatState{
+		received:    now,
+		sequenceId:  heartbeat.SequenceId,
+		lastTradeId: heartbeat.LastTradeId,
+	}
+}
+
+// checkHeartbeats emits a coinbase_heartbeat_missed event for every product
+// whose heartbeats stopped for heartbeat_timeout, once until they resume.
+// With heartbeat_resubscribe the active connection of the product is closed,
+// so that it reconnects and resubscribes.
+func (wsl *WebSocketListener) checkHeartbeats(now time.Time) {
+	if wsl.HeartbeatTimeout.Duration <= 0 {
+		return
+	}
+
+	missed := make(map[string]heartbeatState)
+	wsl.heartbeatsMutex.Lock()
+	for productId, state := range wsl.heartbeats {
+		if !state.missed && now.Sub(state.received) > wsl.HeartbeatTimeout.Duration {
+			state.missed = true
+			missed[productId] = *state
+		}
+	}
+	wsl.heartbeatsMutex.Unlock()
+
+	stale := make(map[*connection]bool)
+	for productId, state := range missed {
+		wsl.AddFields("coinbase_heartbeat_missed",
+			map[string]interface{}{
+				"seconds_since_heartbeat": now.Sub(state.received).Seconds(),
+				"sequence_id":             state.sequenceId,
+				"last_trade_id":           state.lastTradeId,
+			},
+			map[string]string{
+				"service_address": wsl.ServiceAddress,
+				"product_id":      productId,
+			},
+			now,
+		)
+
+		if active := wsl.activeConnection(productId); wsl.HeartbeatResubscribe && active != nil && active.isUp() {
+			stale[active] = true
+		}
+	}
+
+	for c := range stale {
+		wsl.Log.Warnf("Heartbeats stopped on %s connection, resubscribing", c.name)
+		if conn := c.get(); conn != nil {
+			conn.Close()
+		}
+	}
+}
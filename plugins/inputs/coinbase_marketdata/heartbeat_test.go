@@ -0,0 +1,58 @@
+package coinbase_marketdata
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/stretchr/testify/require"
+)
+
+const proHeartbeat = `{
+  "type": "heartbeat",
+  "sequence": 90,
+  "last_trade_id": 20,
+  "product_id": "BTC-USD",
+  "time": "2014-11-07T08:19:28.464459Z"
+}`
+
+func TestHeartbeat(t *testing.T) {
+	wsl, acc := newTestListener(t)
+
+	wsl.addMetric([]byte(proHeartbeat))
+	require.NoError(t, acc.FirstError())
+
+	acc.AssertContainsTaggedFields(t, "heartbeat",
+		map[string]interface{}{"sequence_id": 90.0, "last_trade_id": 20.0},
+		map[string]string{"type": "heartbeat", "product_id": "BTC-USD"},
+	)
+}
+
+func TestHeartbeatMissed(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.HeartbeatTimeout = internal.Duration{Duration: 5 * time.Second}
+
+	now := time.Now()
+	wsl.rememberHeartbeat(wsl.parseHeartbeat(decode(t, proHeartbeat)), now)
+
+	wsl.checkHeartbeats(now.Add(4 * time.Second))
+	require.Empty(t, acc.GetTelegrafMetrics())
+
+	wsl.checkHeartbeats(now.Add(6 * time.Second))
+	acc.AssertContainsTaggedFields(t, "coinbase_heartbeat_missed",
+		map[string]interface{}{
+			"seconds_since_heartbeat": 6.0,
+			"sequence_id":             int64(90),
+			"last_trade_id":           int64(20),
+		},
+		map[string]string{"service_address": wsl.ServiceAddress, "product_id": "BTC-USD"},
+	)
+
+	// reported once until heartbeats resume
+	wsl.checkHeartbeats(now.Add(10 * time.Second))
+	require.Equal(t, 1, len(acc.GetTelegrafMetrics()))
+
+	wsl.rememberHeartbeat(wsl.parseHeartbeat(decode(t, proHeartbeat)), now.Add(11*time.Second))
+	wsl.checkHeartbeats(now.Add(17 * time.Second))
+	require.Equal(t, 2, len(acc.GetTelegrafMetrics()))
+}
@@ -0,0 +1,100 @@
+package coinbase_marketdata
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/tidwall/gjson"
+)
+
+// messageType returns the type of a legacy message, or the channel of an
+// advanced trade envelope
+func messageType(marketData map[string]interface{}) string {
+	if t, ok := marketData["type"]; ok {
+		return fmt.Sprintf("%v", t)
+	}
+	if c, ok := marketData["channel"]; ok {
+		return fmt.Sprintf("%v", c)
+	}
+	return ""
+}
+
+// the channels of message types whose name differs from their channel
+var channelByType = map[string]string{
+	"l2update":   "level2",
+	"snapshot":   "level2",
+	"l2_data":    "level2",
+	"match":      "matches",
+	"last_match": "matches",
+	"received":   "full",
+	"open":       "full",
+	"done":       "full",
+	"change":     "full",
+	"activate":   "full",
+}
+
+// channelOf returns the subscription channel a message type is published on
+func channelOf(msgType string) string {
+	if channel, ok := channelByType[msgType]; ok {
+		return channel
+	}
+	return msgType
+}
+
+// queryPath returns the json_query_by_type path configured for a message
+func (wsl *WebSocketListener) queryPath(marketData map[string]interface{}) (string, bool) {
+	if len(wsl.JSONQueryByType) == 0 {
+		return "", false
+	}
+	path, ok := wsl.JSONQueryByType[messageType(marketData)]
+	return path, ok
+}
+
+// extract selects the nested object or array of objects at path, a gjson
+// path like the parser's json_query. The scalar top level fields of the
+// message, e.g. type, product_id and time, are copied into every extracted
+// object that doesn't already contain them so that the parser can still
+// derive the name, tags and timestamp of the metrics. Objects of an advanced
+// trade envelope are given the channel as their type.
+func extract(message []byte, marketData map[string]interface{}, path string) ([]byte, error) {
+	result := gjson.GetBytes(message, path)
+	if !result.IsArray() && !result.IsObject() {
+		return nil, fmt.Errorf("query path %q of %q messages must lead to a JSON object or array of objects, but lead to: %v",
+			path, messageType(marketData), result.Type)
+	}
+
+	var selected interface{}
+	if err := json.Unmarshal([]byte(result.Raw), &selected); err != nil {
+		return nil, err
+	}
+
+	switch v := selected.(type) {
+	case map[string]interface{}:
+		inherit(v, marketData)
+	case []interface{}:
+		for _, item := range v {
+			if object, ok := item.(map[string]interface{}); ok {
+				inherit(object, marketData)
+			}
+		}
+	}
+
+	return json.Marshal(selected)
+}
+
+func inherit(object map[string]interface{}, parent map[string]interface{}) {
+	if _, ok := object["type"]; !ok {
+		object["type"] = messageType(parent)
+	}
+
+	for k, v := range parent {
+		if _, ok := object[k]; ok {
+			continue
+		}
+
+		switch v.(type) {
+		case string, float64, bool:
+			object[k] = v
+		}
+	}
+}
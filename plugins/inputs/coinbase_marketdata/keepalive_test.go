@@ -0,0 +1,75 @@
+package coinbase_marketdata
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeepaliveReconnectsHalfOpenConnection(t *testing.T) {
+	var connections int32
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		_, _, _ = conn.ReadMessage()
+		if atomic.AddInt32(&connections, 1) == 1 {
+			// stop reading, so that pings are not answered
+			time.Sleep(2 * time.Second)
+			return
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.PingInterval = internal.Duration{Duration: 50 * time.Millisecond}
+	wsl.PongWait = internal.Duration{Duration: 200 * time.Millisecond}
+	wsl.ReconnectInterval = internal.Duration{Duration: time.Millisecond}
+	require.NoError(t, wsl.Init())
+	require.NoError(t, wsl.Start(acc))
+	defer wsl.Stop()
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&connections) == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestKeepaliveKeepsResponsiveConnection(t *testing.T) {
+	var connections int32
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		atomic.AddInt32(&connections, 1)
+		for {
+			// reading answers pings
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.PingInterval = internal.Duration{Duration: 50 * time.Millisecond}
+	wsl.PongWait = internal.Duration{Duration: 200 * time.Millisecond}
+	require.NoError(t, wsl.Init())
+	require.NoError(t, wsl.Start(acc))
+	defer wsl.Stop()
+
+	time.Sleep(500 * time.Millisecond)
+	require.Equal(t, int32(1), atomic.LoadInt32(&connections))
+}
+
+func TestKeepaliveValidation(t *testing.T) {
+	wsl, _ := newTestListener(t)
+	wsl.PingInterval = internal.Duration{Duration: time.Minute}
+	wsl.PongWait = internal.Duration{Duration: time.Second}
+	require.Error(t, wsl.Init())
+
+	wsl.PingInterval = internal.Duration{}
+	require.NoError(t, wsl.Init())
+}
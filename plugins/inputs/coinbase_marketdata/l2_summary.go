@@ -0,0 +1,133 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// l2Summary aggregates the changes of a product's book at one side and price
+// bucket over an l2update_window
+type l2Summary struct {
+	updates int64
+	netQty  float64
+}
+
+// l2Window holds the summaries of a product's book changes in the window
+// starting at start, keyed by side and price bucket
+type l2Window struct {
+	start     time.Time
+	summaries map[[2]string]*l2Summary
+}
+
+// aggregatesL2 reports whether the metrics of the level2 updates of a
+// message are replaced by the summaries of l2update_window
+func (wsl *WebSocketListener) aggregatesL2(marketData map[string]interface{}) bool {
+	if wsl.L2UpdateWindow.Duration <= 0 {
+		return false
+	}
+	return marketData["type"] == "l2update" || marketData["channel"] == "l2_data"
+}
+
+// priceBucket returns the lower bound of the l2update_price_bucket a price
+// falls into, formatted with the decimals of the bucket size, or the price
+// itself without buckets
+func (wsl *WebSocketListener) priceBucket(price float64) string {
+	size := wsl.L2UpdatePriceBucket
+	if size <= 0 {
+		return strconv.FormatFloat(price, 'f', -1, 64)
+	}
+
+	decimals := 0
+	text := strconv.FormatFloat(size, 'f', -1, 64)
+	if i := strings.IndexByte(text, '.'); i >= 0 {
+		decimals = len(text) - i - 1
+	}
+	return strconv.FormatFloat(math.Floor(price/size)*size, 'f', decimals, 64)
+}
+
+// addL2Changes adds the size changes of level2 updates, as applied to the
+// books, to the window of their event time, closing the windows before it
+func (wsl *WebSocketListener) addL2Changes(updates []L2Update, changes []float64) {
+	wsl.l2Mutex.Lock()
+	defer wsl.l2Mutex.Unlock()
+
+	for i, u := range updates {
+		ts, err := parseTime(u.Time)
+		if err != nil {
+			ts = time.Now()
+		}
+		start := ts.Truncate(wsl.L2UpdateWindow.Duration)
+
+		wsl.closeL2Window(u.ProductId, start)
+
+		w, ok := wsl.l2Windows[u.ProductId]
+		if !ok {
+			w = &l2Window{start: start, summaries: make(map[[2]string]*l2Summary)}
+			wsl.l2Windows[u.ProductId] = w
+		}
+		if start.Before(w.start) {
+			// the window of an update delivered this late has already been emitted
+			continue
+		}
+
+		key := [2]string{u.Side, wsl.priceBucket(u.Price)}
+		s, ok := w.summaries[key]
+		if !ok {
+			s = &l2Summary{}
+			w.summaries[key] = s
+		}
+		s.updates++
+		s.netQty += changes[i]
+	}
+}
+
+// closeL2Window emits the summaries of a product if its window started
+// before start
+func (wsl *WebSocketListener) closeL2Window(productId string, start time.Time) {
+	w, ok := wsl.l2Windows[productId]
+	if !ok || !w.start.Before(start) {
+		return
+	}
+	delete(wsl.l2Windows, productId)
+
+	for key, s := range w.summaries {
+		wsl.AddFields("coinbase_l2_summary",
+			map[string]interface{}{
+				"update_count":   s.updates,
+				"net_qty_change": s.netQty,
+			},
+			map[string]string{
+				"product_id":   productId,
+				"side":         key[0],
+				"price_bucket": key[1],
+			},
+			w.start,
+		)
+	}
+}
+
+// flushL2Windows emits the summaries of every product whose window ended
+// before now, so that windows close even when no further updates arrive
+func (wsl *WebSocketListener) flushL2Windows(now time.Time) {
+	start := now.Truncate(wsl.L2UpdateWindow.Duration)
+
+	wsl.l2Mutex.Lock()
+	defer wsl.l2Mutex.Unlock()
+
+	for productId := range wsl.l2Windows {
+		wsl.closeL2Window(productId, start)
+	}
+}
+
+func validateL2Summary(window time.Duration, bucket float64) error {
+	if window < 0 {
+		return fmt.Errorf("invalid l2update_window %s, must not be negative", window)
+	}
+	if bucket < 0 {
+		return fmt.Errorf("invalid l2update_price_bucket %v, must not be negative", bucket)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package coinbase_marketdata
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestL2UpdateSummary(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.L2UpdateWindow = internal.Duration{Duration: time.Minute}
+	wsl.L2UpdatePriceBucket = 0.5
+	require.NoError(t, wsl.Init())
+
+	wsl.addMetric([]byte(proSnapshot))
+	wsl.addMetric([]byte(`{
+  "type": "l2update",
+  "product_id": "ETH-USD",
+  "changes": [["sell", "731.99", "0"], ["buy", "731.90", "0.7"], ["buy", "731.83", "1.5"]],
+  "time": "2020-12-28T23:54:32.051347Z"
+}`))
+	require.NoError(t, acc.FirstError())
+	require.False(t, acc.HasMeasurement("l2update"))
+	require.False(t, acc.HasMeasurement("coinbase_l2_summary"))
+
+	// an update of the next window closes the first one
+	wsl.addMetric([]byte(`{
+  "type": "l2update",
+  "product_id": "ETH-USD",
+  "changes": [["buy", "730.00", "3.0"]],
+  "time": "2020-12-28T23:55:01.000000Z"
+}`))
+
+	start := time.Date(2020, 12, 28, 23, 54, 0, 0, time.UTC)
+	summaries := make(map[string]map[string]interface{})
+	for _, m := range acc.Metrics {
+		if m.Measurement == "coinbase_l2_summary" {
+			require.Equal(t, start, m.Time)
+			require.Equal(t, "ETH-USD", m.Tags["product_id"])
+			summaries[m.Tags["side"]+" "+m.Tags["price_bucket"]] = m.Fields
+		}
+	}
+	require.Len(t, summaries, 2)
+	require.Equal(t, int64(2), summaries["buy 731.5"]["update_count"])
+	require.InDelta(t, 1.2, summaries["buy 731.5"]["net_qty_change"], 1e-9)
+	require.Equal(t, int64(1), summaries["sell 731.5"]["update_count"])
+	require.InDelta(t, -0.5, summaries["sell 731.5"]["net_qty_change"], 1e-9)
+
+	// the last window closes once it has ended
+	acc.ClearMetrics()
+	wsl.flushL2Windows(start.Add(2 * time.Minute))
+	acc.AssertContainsTaggedFields(t, "coinbase_l2_summary",
+		map[string]interface{}{"update_count": int64(1), "net_qty_change": -1.0},
+		map[string]string{"product_id": "ETH-USD", "side": "buy", "price_bucket": "730.0"},
+	)
+}
+
+func TestPriceBucket(t *testing.T) {
+	wsl, _ := newTestListener(t)
+	require.Equal(t, "731.99", wsl.priceBucket(731.99))
+
+	wsl.L2UpdatePriceBucket = 0.01
+	require.Equal(t, "731.99", wsl.priceBucket(731.99))
+
+	wsl.L2UpdatePriceBucket = 10
+	require.Equal(t, "730", wsl.priceBucket(731.99))
+
+	wsl.L2UpdatePriceBucket = -1
+	require.Error(t, wsl.Init())
+}
@@ -0,0 +1,61 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+)
+
+type Match struct {
+	DataType     string  `json:"type"`
+	ProductId    string  `json:"product_id"`
+	Side         string  `json:"side"`
+	Time         string  `json:"time"`
+	Price        float64 `json:"price"`
+	Size         float64 `json:"size"`
+	TradeId      int64   `json:"trade_id"`
+	SequenceId   int64   `json:"sequence_id"`
+	MakerOrderId string  `json:"maker_order_id,omitempty"`
+	TakerOrderId string  `json:"taker_order_id,omitempty"`
+
+	// the decimal values as received, kept for price_format
+	text map[string]string
+}
+
+// takes in a map of match or last_match data type in the format of
+// {
+//  "type": "match",
+//  "trade_id": 10,
+//  "sequence": 50,
+//  "maker_order_id": "ac928c66-ca53-498f-9c13-a110027a60e8",
+//  "taker_order_id": "132fb6ae-456b-4654-b4e0-d681ac05cea1",
+//  "time": "2014-11-07T08:19:27.028459Z",
+//  "product_id": "BTC-USD",
+//  "size": "5.23512",
+//  "price": "400.23",
+//  "side": "sell"
+// }
+func (wsl *WebSocketListener) parseMatch(matchData map[string]interface{}) *Match {
+	price, _ := parseFloat(matchData["price"])
+	size, _ := parseFloat(matchData["size"])
+	tradeId, _ := parseInt(matchData["trade_id"])
+	sequenceId, _ := parseInt(matchData["sequence"])
+
+	match := &Match{
+		DataType:   fmt.Sprintf("%v", matchData["type"]),
+		ProductId:  fmt.Sprintf("%v", matchData["product_id"]),
+		Side:       fmt.Sprintf("%v", matchData["side"]),
+		Time:       fmt.Sprintf("%v", matchData["time"]),
+		Price:      price,
+		Size:       size,
+		TradeId:    tradeId,
+		SequenceId: sequenceId,
+		text:       wsl.decimalText(matchData, matchDecimals),
+	}
+	if makerOrderId, ok := matchData["maker_order_id"].(string); ok {
+		match.MakerOrderId = makerOrderId
+	}
+	if takerOrderId, ok := matchData["taker_order_id"].(string); ok {
+		match.TakerOrderId = takerOrderId
+	}
+
+	return match
+}
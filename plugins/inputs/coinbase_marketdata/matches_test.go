@@ -0,0 +1,63 @@
+package coinbase_marketdata
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const proMatch = `{
+  "type": "match",
+  "trade_id": 10,
+  "sequence": 50,
+  "maker_order_id": "ac928c66-ca53-498f-9c13-a110027a60e8",
+  "taker_order_id": "132fb6ae-456b-4654-b4e0-d681ac05cea1",
+  "time": "2014-11-07T08:19:27.028459Z",
+  "product_id": "BTC-USD",
+  "size": "5.23512",
+  "price": "400.23",
+  "side": "sell"
+}`
+
+func TestMatch(t *testing.T) {
+	wsl, acc := newTestListener(t)
+
+	wsl.addMetric([]byte(proMatch))
+	require.NoError(t, acc.FirstError())
+
+	m, ok := acc.Get("match")
+	require.True(t, ok)
+	require.Equal(t, map[string]string{"type": "match", "product_id": "BTC-USD", "side": "sell"}, m.Tags)
+	require.Equal(t, map[string]interface{}{
+		"price":          400.23,
+		"size":           5.23512,
+		"trade_id":       float64(10),
+		"sequence_id":    float64(50),
+		"maker_order_id": "ac928c66-ca53-498f-9c13-a110027a60e8",
+		"taker_order_id": "132fb6ae-456b-4654-b4e0-d681ac05cea1",
+	}, m.Fields)
+	require.Equal(t, time.Date(2014, 11, 7, 8, 19, 27, 28459000, time.UTC), m.Time)
+}
+
+func TestLastMatchWithoutOrderIds(t *testing.T) {
+	wsl, acc := newTestListener(t)
+
+	wsl.addMetric([]byte(`{
+  "type": "last_match",
+  "trade_id": 11,
+  "sequence": 51,
+  "time": "2014-11-07T08:19:27.028459Z",
+  "product_id": "BTC-USD",
+  "size": "1",
+  "price": "400.25",
+  "side": "buy"
+}`))
+	require.NoError(t, acc.FirstError())
+
+	m, ok := acc.Get("last_match")
+	require.True(t, ok)
+	require.Equal(t, 400.25, m.Fields["price"])
+	require.NotContains(t, m.Fields, "maker_order_id")
+	require.NotContains(t, m.Fields, "taker_order_id")
+}
@@ -0,0 +1,165 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+)
+
+var defaultMetricTagKeys = []string{"type", "product_id", "side"}
+
+// record is a parsed message, turned into a metric directly rather than
+// marshalled to json for the configured parser to unmarshal again
+type record interface {
+	// values returns the "type" naming the metric, the "time" of the
+	// message and every other value by its json name. Numbers are float64,
+	// as the json parser would have emitted them, so that switching between
+	// the two keeps the field types of existing series.
+	values() map[string]interface{}
+
+	// decimalText returns the decimal values as received by field name,
+	// nil unless price_format needs them
+	decimalText() map[string]string
+}
+
+func (t *Ticker) values() map[string]interface{} {
+	values := map[string]interface{}{
+		"type":        t.DataType,
+		"product_id":  t.ProductId,
+		"side":        t.Side,
+		"time":        t.Time,
+		"price":       t.Price,
+		"open_24h":    t.Open24H,
+		"volume_24h":  t.Volume24H,
+		"low_24h":     t.Low24H,
+		"high_24h":    t.High24H,
+		"volume_30d":  t.Volume30D,
+		"best_bid":    t.BestBid,
+		"best_ask":    t.BestAsk,
+		"last_size":   t.Size,
+		"sequence_id": float64(t.SequenceId),
+		"trade_id":    float64(t.TradeId),
+	}
+	if t.BestBidSize != nil {
+		values["best_bid_size"] = *t.BestBidSize
+	}
+	if t.BestAskSize != nil {
+		values["best_ask_size"] = *t.BestAskSize
+	}
+	if t.Spread != nil {
+		values["spread"] = *t.Spread
+	}
+	if t.SpreadBps != nil {
+		values["spread_bps"] = *t.SpreadBps
+	}
+	if t.MidPrice != nil {
+		values["mid_price"] = *t.MidPrice
+	}
+	if t.MicroPrice != nil {
+		values["micro_price"] = *t.MicroPrice
+	}
+	return values
+}
+
+func (t *Ticker) decimalText() map[string]string {
+	return t.text
+}
+
+func (u L2Update) values() map[string]interface{} {
+	return map[string]interface{}{
+		"type":       u.DataType,
+		"product_id": u.ProductId,
+		"side":       u.Side,
+		"time":       u.Time,
+		"price":      u.Price,
+		"qty":        u.Qty,
+	}
+}
+
+func (u L2Update) decimalText() map[string]string {
+	return u.text
+}
+
+func (m *Match) values() map[string]interface{} {
+	values := map[string]interface{}{
+		"type":        m.DataType,
+		"product_id":  m.ProductId,
+		"side":        m.Side,
+		"time":        m.Time,
+		"price":       m.Price,
+		"size":        m.Size,
+		"trade_id":    float64(m.TradeId),
+		"sequence_id": float64(m.SequenceId),
+	}
+	if m.MakerOrderId != "" {
+		values["maker_order_id"] = m.MakerOrderId
+	}
+	if m.TakerOrderId != "" {
+		values["taker_order_id"] = m.TakerOrderId
+	}
+	return values
+}
+
+func (m *Match) decimalText() map[string]string {
+	return m.text
+}
+
+// newMetric turns a record into a metric named after its type, tagged with
+// the values of metric_tag_keys and timestamped with its time, or the
+// current time when the message has none
+func (wsl *WebSocketListener) newMetric(r record, received time.Time) (telegraf.Metric, error) {
+	fields := r.values()
+
+	name := fmt.Sprintf("%v", fields["type"])
+
+	ts := wsl.eventTime(fields["time"], received)
+	delete(fields, "time")
+
+	if wsl.keepsDecimals() {
+		productId, _ := fields["product_id"].(string)
+		if err := wsl.formatDecimals(fields, r.decimalText(), productId); err != nil {
+			return nil, fmt.Errorf("%s of %s: %s", name, productId, err)
+		}
+	}
+
+	tags := make(map[string]string, len(wsl.MetricTagKeys))
+	for _, key := range wsl.MetricTagKeys {
+		if value, ok := fields[key]; ok {
+			tags[key] = fmt.Sprintf("%v", value)
+			delete(fields, key)
+		}
+	}
+
+	return metric.New(name, tags, fields, ts)
+}
+
+// emitFetched emits the metrics of data fetched from the REST api in the
+// shape of a message of the feed, timestamped with the time of the data
+// rather than when it was fetched. The tags and fields of absent, which the
+// REST api does not provide, are removed rather than emitted empty or zero.
+func (wsl *WebSocketListener) emitFetched(marketData map[string]interface{}, fetched time.Time, tags map[string]string, absent []string) {
+	ts := wsl.eventTime(marketData["time"], fetched)
+
+	records, err := wsl.parse(marketData)
+	if err != nil {
+		wsl.parseError(fmt.Errorf("unable to parse fetched %v: %s", marketData["type"], err))
+		return
+	}
+	for _, r := range records {
+		m, err := wsl.newMetric(r, ts)
+		if err != nil {
+			wsl.parseError(fmt.Errorf("unable to parse fetched %v: %s", marketData["type"], err))
+			continue
+		}
+		for _, key := range absent {
+			m.RemoveTag(key)
+			m.RemoveField(key)
+		}
+		for key, value := range tags {
+			m.AddTag(key, value)
+		}
+		wsl.emit(marketData, m, ts)
+	}
+}
@@ -0,0 +1,63 @@
+package coinbase_marketdata
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// the metrics built directly must match those the json parser of the sample
+// config builds from the marshalled records
+func TestNewMetricMatchesParser(t *testing.T) {
+	wsl, _ := newTestListener(t)
+
+	for _, message := range []string{proTicker, proL2Update, proMatch, advancedTicker} {
+		records, err := wsl.parse(decode(t, message))
+		require.NoError(t, err)
+		require.NotEmpty(t, records)
+
+		var direct, parsed []telegraf.Metric
+		for _, r := range records {
+			m, err := wsl.newMetric(r, time.Now())
+			require.NoError(t, err)
+			direct = append(direct, m)
+
+			data, err := json.Marshal(r)
+			require.NoError(t, err)
+			metrics, err := wsl.parseMetrics(data)
+			require.NoError(t, err)
+			parsed = append(parsed, metrics...)
+		}
+
+		testutil.RequireMetricsEqual(t, parsed, direct)
+	}
+}
+
+func TestMetricTagKeys(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.MetricTagKeys = []string{"product_id"}
+
+	wsl.addMetric([]byte(proMatch))
+	require.NoError(t, acc.FirstError())
+
+	m, ok := acc.Get("match")
+	require.True(t, ok)
+	require.Equal(t, map[string]string{"product_id": "BTC-USD"}, m.Tags)
+	require.Equal(t, "sell", m.Fields["side"])
+	require.Equal(t, "match", m.Fields["type"])
+}
+
+func BenchmarkAddMetric(b *testing.B) {
+	wsl, _ := newTestListener(b)
+	wsl.Accumulator = &testutil.NopAccumulator{}
+	message := []byte(proTicker)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		wsl.addMetric(message)
+	}
+}
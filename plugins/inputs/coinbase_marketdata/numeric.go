@@ -0,0 +1,98 @@
+package coinbase_marketdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// parseFloat converts a numeric field that may arrive either as a JSON
+// string, e.g. "0.24169456" or "1.2e-8", or as a JSON number, which is
+// already decoded to a float64 and must not be round-tripped through
+// fmt's %v formatting.
+func parseFloat(v interface{}) (float64, error) {
+	switch t := v.(type) {
+	case float64:
+		return t, nil
+	case string:
+		return strconv.ParseFloat(t, 64)
+	case json.Number:
+		return t.Float64()
+	case nil:
+		return 0, fmt.Errorf("missing numeric value")
+	default:
+		return 0, fmt.Errorf("unexpected numeric type %T", v)
+	}
+}
+
+// optionalFloat converts a numeric field that is not sent in every message,
+// returning nil when it is missing or malformed
+func optionalFloat(v interface{}) *float64 {
+	f, err := parseFloat(v)
+	if err != nil {
+		return nil
+	}
+	return &f
+}
+
+// bidAskSpread returns the spread between the best bid and ask, and the
+// spread in basis points of their mid price, or nils when either is missing
+func bidAskSpread(bestBid float64, bestAsk float64) (*float64, *float64) {
+	if bestBid <= 0 || bestAsk <= 0 {
+		return nil, nil
+	}
+	spread := bestAsk - bestBid
+	spreadBps := spread / ((bestAsk + bestBid) / 2) * 10000
+	return &spread, &spreadBps
+}
+
+// withMidPrices sets the mid price of the best bid and ask of a ticker with
+// mid_prices, and its micro price, which weights the bid by the size of the
+// best ask and the ask by the size of the best bid, when it has both sizes
+func (wsl *WebSocketListener) withMidPrices(t *Ticker) *Ticker {
+	if !wsl.MidPrices || t.BestBid <= 0 || t.BestAsk <= 0 {
+		return t
+	}
+	midPrice := (t.BestBid + t.BestAsk) / 2
+	t.MidPrice = &midPrice
+
+	if t.BestBidSize == nil || t.BestAskSize == nil {
+		return t
+	}
+	bidSize, askSize := *t.BestBidSize, *t.BestAskSize
+	if bidSize+askSize > 0 {
+		microPrice := (t.BestBid*askSize + t.BestAsk*bidSize) / (bidSize + askSize)
+		t.MicroPrice = &microPrice
+	}
+	return t
+}
+
+// parseInt converts an integer field such as a sequence number or trade id.
+// Large integers decoded as JSON numbers are formatted by %v in scientific
+// notation, e.g. 1.2238444095e+10, which strconv.ParseInt rejects.
+func parseInt(v interface{}) (int64, error) {
+	switch t := v.(type) {
+	case float64:
+		if t != math.Trunc(t) {
+			return 0, fmt.Errorf("%v is not an integer", t)
+		}
+		return int64(t), nil
+	case string:
+		i, err := strconv.ParseInt(t, 10, 64)
+		if err == nil {
+			return i, nil
+		}
+		f, ferr := strconv.ParseFloat(t, 64)
+		if ferr != nil || f != math.Trunc(f) {
+			return 0, err
+		}
+		return int64(f), nil
+	case json.Number:
+		return parseInt(t.String())
+	case nil:
+		return 0, fmt.Errorf("missing numeric value")
+	default:
+		return 0, fmt.Errorf("unexpected numeric type %T", v)
+	}
+}
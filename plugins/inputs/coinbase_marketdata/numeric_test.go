@@ -0,0 +1,85 @@
+package coinbase_marketdata
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFloat(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    interface{}
+		expected float64
+	}{
+		{"decimal string", "0.24169456", 0.24169456},
+		{"dust string", "1.2e-8", 1.2e-8},
+		{"dust string upper case exponent", "3.5E-12", 3.5e-12},
+		{"large string", "6.02e+23", 6.02e23},
+		{"dust number", 1.2e-8, 1.2e-8},
+		{"large number", 6.02e23, 6.02e23},
+		{"json number", json.Number("1.2e-8"), 1.2e-8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := parseFloat(tt.input)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, actual)
+		})
+	}
+
+	_, err := parseFloat(nil)
+	require.Error(t, err)
+	_, err = parseFloat("dust")
+	require.Error(t, err)
+}
+
+func TestParseInt(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    interface{}
+		expected int64
+	}{
+		{"string", "71476932", 71476932},
+		{"large number", float64(12238444095), 12238444095},
+		{"scientific string", "1.2238444095e+10", 12238444095},
+		{"json number", json.Number("12238444095"), 12238444095},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := parseInt(tt.input)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, actual)
+		})
+	}
+
+	_, err := parseInt(1.5)
+	require.Error(t, err)
+}
+
+func TestScientificNotationQuantities(t *testing.T) {
+	wsl, acc := newTestListener(t)
+
+	wsl.addMetric([]byte(`{
+  "type": "l2update",
+  "product_id": "SHIB-USD",
+  "changes": [
+    ["buy", 1.2e-8, "3.5e+12"],
+    ["sell", "0.0000000125", 4.2e-9]
+  ],
+  "time": "2020-12-28T23:54:32.051347Z"
+}`))
+	require.NoError(t, acc.FirstError())
+
+	acc.AssertContainsTaggedFields(t, "l2update",
+		map[string]interface{}{"price": 1.2e-8, "qty": 3.5e12},
+		map[string]string{"type": "l2update", "product_id": "SHIB-USD", "side": "buy"},
+	)
+	acc.AssertContainsTaggedFields(t, "l2update",
+		map[string]interface{}{"price": 1.25e-8, "qty": 4.2e-9},
+		map[string]string{"type": "l2update", "product_id": "SHIB-USD", "side": "sell"},
+	)
+}
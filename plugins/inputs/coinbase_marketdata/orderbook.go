@@ -0,0 +1,230 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type PriceLevel struct {
+	Price float64
+	Size  float64
+}
+
+// orderBook is the level2 book of a single product, reconstructed from the
+// initial snapshot and subsequent l2updates
+type orderBook struct {
+	bids map[float64]float64
+	asks map[float64]float64
+}
+
+func newOrderBook() *orderBook {
+	return &orderBook{
+		bids: make(map[float64]float64),
+		asks: make(map[float64]float64),
+	}
+}
+
+// update sets the size available at a price level, a size of 0 removes the
+// level. Returns the size the level had before.
+func (b *orderBook) update(side string, price float64, size float64) float64 {
+	levels := b.asks
+	if side == "buy" {
+		levels = b.bids
+	}
+
+	previous := levels[price]
+	if size == 0 {
+		delete(levels, price)
+		return previous
+	}
+	levels[price] = size
+	return previous
+}
+
+// levels returns up to depth price levels of a side ordered from the best
+// price outwards, a depth of 0 returns the whole side
+func (b *orderBook) levels(side string, depth int) []PriceLevel {
+	levels := b.asks
+	if side == "buy" {
+		levels = b.bids
+	}
+
+	result := make([]PriceLevel, 0, len(levels))
+	for price, size := range levels {
+		result = append(result, PriceLevel{Price: price, Size: size})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if side == "buy" {
+			return result[i].Price > result[j].Price
+		}
+		return result[i].Price < result[j].Price
+	})
+
+	if depth > 0 && len(result) > depth {
+		result = result[:depth]
+	}
+	return result
+}
+
+// parses the [price, size, ...] arrays used by both the websocket snapshot
+// and the REST book endpoint
+func parsePriceLevels(data interface{}) []PriceLevel {
+	entries, _ := data.([]interface{})
+
+	var levels []PriceLevel
+	for _, e := range entries {
+		entry, ok := e.([]interface{})
+		if !ok || len(entry) < 2 {
+			continue
+		}
+
+		price, _ := parseFloat(entry[0])
+		size, _ := parseFloat(entry[1])
+		levels = append(levels, PriceLevel{Price: price, Size: size})
+	}
+
+	return levels
+}
+
+// bookStore holds the order books of all products. A product's book exists
+// from its snapshot on, updates received before are meaningless.
+type bookStore struct {
+	mutex sync.Mutex
+	books map[string]*orderBook
+
+	// the last sequence of the messages of every product, and the books
+	// watched by verify_book
+	sequences map[string]int64
+	watches   []*bookWatch
+}
+
+func newBookStore() *bookStore {
+	return &bookStore{
+		books:     make(map[string]*orderBook),
+		sequences: make(map[string]int64),
+	}
+}
+
+// snapshot replaces the book of a product
+func (s *bookStore) snapshot(productId string, bids []PriceLevel, asks []PriceLevel) {
+	book := newOrderBook()
+	for _, level := range bids {
+		book.update("buy", level.Price, level.Size)
+	}
+	for _, level := range asks {
+		book.update("sell", level.Price, level.Size)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.books[productId] = book
+}
+
+// apply applies updates to the books of their products, skipping products
+// whose snapshot has not arrived yet. Returns the change of the size at the
+// price level of every update, 0 for the skipped ones.
+func (s *bookStore) apply(updates []L2Update) []float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	changes := make([]float64, len(updates))
+	for i, u := range updates {
+		book, ok := s.books[u.ProductId]
+		if !ok {
+			continue
+		}
+		changes[i] = u.Qty - book.update(u.Side, u.Price, u.Qty)
+	}
+	return changes
+}
+
+// levels returns up to depth price levels of a side of a product's book, and
+// whether the product has a book
+func (s *bookStore) levels(productId string, side string, depth int) ([]PriceLevel, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	book, ok := s.books[productId]
+	if !ok {
+		return nil, false
+	}
+	return book.levels(side, depth), true
+}
+
+// productIds returns the products with a book in alphabetical order
+func (s *bookStore) productIds() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	productIds := make([]string, 0, len(s.books))
+	for productId := range s.books {
+		productIds = append(productIds, productId)
+	}
+	sort.Strings(productIds)
+	return productIds
+}
+
+// maintainsBooks reports whether any option needs the order books
+func (wsl *WebSocketListener) maintainsBooks() bool {
+	return wsl.OrderBook || wsl.VerifyBook || wsl.Enrich || wsl.L2UpdateWindow.Duration > 0
+}
+
+// updateBook applies snapshot and l2update messages, and the snapshot and
+// update events of advanced trade l2_data messages, to the books of their
+// products. Fails on a malformed l2update.
+//
+// takes in a map of snapshot data type in the format of
+// {
+//  "type": "snapshot",
+//  "product_id": "BTC-USD",
+//  "bids": [["10101.10", "0.45054140"]],
+//  "asks": [["10102.55", "0.57753524"]]
+// }
+func (wsl *WebSocketListener) updateBook(marketData map[string]interface{}) error {
+	if wsl.isAdvancedTrade(marketData) {
+		if marketData["channel"] != "l2_data" {
+			return nil
+		}
+		events, _ := marketData["events"].([]interface{})
+		for _, e := range events {
+			event, ok := e.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			updates := wsl.parseAdvancedL2Update(event)
+			if event["type"] == "snapshot" {
+				// the snapshot is sent as the updates building the book
+				wsl.books.snapshot(fmt.Sprintf("%v", event["product_id"]), nil, nil)
+				wsl.books.apply(updates)
+				continue
+			}
+			changes := wsl.books.apply(updates)
+			if wsl.L2UpdateWindow.Duration > 0 {
+				wsl.addL2Changes(updates, changes)
+			}
+		}
+		return nil
+	}
+
+	if sequence, err := parseInt(marketData["sequence"]); err == nil {
+		wsl.books.advance(fmt.Sprintf("%v", marketData["product_id"]), sequence)
+	}
+
+	switch marketData["type"] {
+	case "snapshot":
+		wsl.books.snapshot(fmt.Sprintf("%v", marketData["product_id"]),
+			parsePriceLevels(marketData["bids"]), parsePriceLevels(marketData["asks"]))
+	case "l2update":
+		updates, err := wsl.parseL2Update(marketData)
+		if err != nil {
+			return err
+		}
+		changes := wsl.books.apply(updates)
+		if wsl.L2UpdateWindow.Duration > 0 {
+			wsl.addL2Changes(updates, changes)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package coinbase_marketdata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+const proSnapshot = `{
+  "type": "snapshot",
+  "product_id": "ETH-USD",
+  "bids": [["731.50", "2.5"], ["731.83", "1.0"], ["730.00", "4.0"]],
+  "asks": [["732.10", "3.0"], ["731.99", "0.5"]]
+}`
+
+func decode(t *testing.T, message string) map[string]interface{} {
+	marketData := make(map[string]interface{})
+	require.NoError(t, json.Unmarshal([]byte(message), &marketData))
+	return marketData
+}
+
+func TestOrderBookLevelsAreSortedFromBestPrice(t *testing.T) {
+	wsl, _ := newTestListener(t)
+
+	wsl.updateBook(decode(t, proSnapshot))
+
+	bids, ok := wsl.books.levels("ETH-USD", "buy", 2)
+	require.True(t, ok)
+	require.Equal(t, []PriceLevel{{731.83, 1.0}, {731.50, 2.5}}, bids)
+	asks, _ := wsl.books.levels("ETH-USD", "sell", 0)
+	require.Equal(t, []PriceLevel{{731.99, 0.5}, {732.10, 3.0}}, asks)
+}
+
+func TestOrderBookAppliesL2Updates(t *testing.T) {
+	wsl, _ := newTestListener(t)
+
+	// updates before the snapshot are ignored
+	wsl.updateBook(decode(t, proL2Update))
+	require.Empty(t, wsl.books.productIds())
+
+	wsl.updateBook(decode(t, proSnapshot))
+	wsl.updateBook(decode(t, `{
+  "type": "l2update",
+  "product_id": "ETH-USD",
+  "changes": [["sell", "731.99", "0"], ["buy", "731.90", "0.7"]],
+  "time": "2020-12-28T23:54:32.051347Z"
+}`))
+
+	bids, _ := wsl.books.levels("ETH-USD", "buy", 0)
+	require.Equal(t, []PriceLevel{{731.90, 0.7}, {731.83, 1.0}, {731.50, 2.5}, {730.00, 4.0}}, bids)
+	asks, _ := wsl.books.levels("ETH-USD", "sell", 0)
+	require.Equal(t, []PriceLevel{{732.10, 3.0}}, asks)
+}
+
+func TestOrderBookFromAdvancedL2Data(t *testing.T) {
+	wsl, _ := newTestListener(t)
+
+	wsl.updateBook(decode(t, `{
+  "channel": "l2_data",
+  "timestamp": "2023-02-09T20:32:50.714964855Z",
+  "sequence_num": 0,
+  "events": [
+    {
+      "type": "snapshot",
+      "product_id": "BTC-USD",
+      "updates": [
+        {"side": "bid", "event_time": "1970-01-01T00:00:00Z", "price_level": "21921.73", "new_quantity": "0.06317902"},
+        {"side": "bid", "event_time": "1970-01-01T00:00:00Z", "price_level": "21921.30", "new_quantity": "1"},
+        {"side": "offer", "event_time": "1970-01-01T00:00:00Z", "price_level": "21921.74", "new_quantity": "0.5"}
+      ]
+    }
+  ]
+}`))
+	wsl.updateBook(decode(t, `{
+  "channel": "l2_data",
+  "timestamp": "2023-02-09T20:32:50.714964855Z",
+  "sequence_num": 1,
+  "events": [
+    {
+      "type": "update",
+      "product_id": "BTC-USD",
+      "updates": [
+        {"side": "bid", "event_time": "2023-02-09T20:32:50.714964855Z", "price_level": "21921.73", "new_quantity": "0"}
+      ]
+    }
+  ]
+}`))
+
+	bids, ok := wsl.books.levels("BTC-USD", "buy", 0)
+	require.True(t, ok)
+	require.Equal(t, []PriceLevel{{21921.30, 1.0}}, bids)
+	asks, _ := wsl.books.levels("BTC-USD", "sell", 0)
+	require.Equal(t, []PriceLevel{{21921.74, 0.5}}, asks)
+}
+
+func newRestServer(t *testing.T, books map[string]string) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for productId, book := range books {
+			if r.URL.Path == fmt.Sprintf("/products/%s/book", productId) {
+				_, _ = w.Write([]byte(book))
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// newVerifyingListener returns a listener verifying its books against the
+// REST api of ts
+func newVerifyingListener(t *testing.T, ts *httptest.Server) (*WebSocketListener, *testutil.Accumulator) {
+	wsl, acc := newTestListener(t)
+	wsl.RestAddress = ts.URL
+	wsl.VerifyBook = true
+	wsl.ctx, wsl.cancel = context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		wsl.cancel()
+		wsl.wg.Wait()
+	})
+	return wsl, acc
+}
+
+// awaitWatch waits for the verification to watch the book of a product
+func awaitWatch(t *testing.T, wsl *WebSocketListener) {
+	require.Eventually(t, func() bool {
+		wsl.books.mutex.Lock()
+		defer wsl.books.mutex.Unlock()
+		return len(wsl.books.watches) > 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestVerifyBookConsistent(t *testing.T) {
+	ts := newRestServer(t, map[string]string{
+		"ETH-USD": `{"sequence": 3, "bids": [["731.83", "1.0", 2], ["731.50", "2.5", 1], ["730.00", "4.0", 1]], "asks": [["731.99", "0.5", 1], ["732.10", "3.0", 4]]}`,
+	})
+
+	wsl, acc := newVerifyingListener(t, ts)
+	wsl.updateBook(decode(t, proSnapshot))
+
+	require.NoError(t, wsl.Gather(acc))
+	awaitWatch(t, wsl)
+	wsl.updateBook(decode(t, `{"type": "ticker", "product_id": "ETH-USD", "sequence": 3}`))
+
+	require.Eventually(t, func() bool { return acc.HasMeasurement("coinbase_book_check") }, time.Second, time.Millisecond)
+	require.NoError(t, acc.FirstError())
+	acc.AssertContainsTaggedFields(t, "coinbase_book_check",
+		map[string]interface{}{"book_consistent": 1, "divergences": 0},
+		map[string]string{"product_id": "ETH-USD"},
+	)
+}
+
+func TestVerifyBookDiverged(t *testing.T) {
+	ts := newRestServer(t, map[string]string{
+		"ETH-USD": `{"sequence": 3, "bids": [["731.83", "1.5", 2], ["731.50", "2.5", 1]], "asks": [["731.99", "0.5", 1], ["732.10", "3.0", 4]]}`,
+	})
+
+	wsl, acc := newVerifyingListener(t, ts)
+	wsl.VerifyDepth = 3
+	wsl.updateBook(decode(t, proSnapshot))
+
+	require.NoError(t, wsl.Gather(acc))
+	awaitWatch(t, wsl)
+	wsl.updateBook(decode(t, `{"type": "ticker", "product_id": "ETH-USD", "sequence": 3}`))
+
+	require.Eventually(t, func() bool { return acc.HasMeasurement("coinbase_book_check") }, time.Second, time.Millisecond)
+	acc.AssertContainsTaggedFields(t, "coinbase_book_check",
+		map[string]interface{}{"book_consistent": 0, "divergences": 2},
+		map[string]string{"product_id": "ETH-USD"},
+	)
+
+	var divergence *BookDivergenceError
+	require.True(t, errors.As(acc.FirstError(), &divergence))
+	require.Equal(t, "ETH-USD", divergence.ProductId)
+	require.Equal(t, 2, divergence.Divergences)
+
+	// the next verification waits for verify_interval
+	acc.ClearMetrics()
+	require.NoError(t, wsl.Gather(acc))
+	require.False(t, acc.HasMeasurement("coinbase_book_check"))
+}
+
+func TestVerifyBookAwaitsSequence(t *testing.T) {
+	// the snapshot includes an update the feed delivers after sequence 4
+	ts := newRestServer(t, map[string]string{
+		"ETH-USD": `{"sequence": 5, "bids": [["731.83", "2.0", 2], ["731.50", "2.5", 1], ["730.00", "4.0", 1]], "asks": [["731.99", "0.5", 1], ["732.10", "3.0", 4]]}`,
+	})
+
+	wsl, acc := newVerifyingListener(t, ts)
+	wsl.updateBook(decode(t, proSnapshot))
+
+	require.NoError(t, wsl.Gather(acc))
+	awaitWatch(t, wsl)
+	wsl.updateBook(decode(t, `{"type": "ticker", "product_id": "ETH-USD", "sequence": 4}`))
+	wsl.updateBook(decode(t, `{"type": "l2update", "product_id": "ETH-USD", "changes": [["buy", "731.83", "2.0"]]}`))
+	wsl.updateBook(decode(t, `{"type": "ticker", "product_id": "ETH-USD", "sequence": 5}`))
+	// later updates don't affect the state compared
+	wsl.updateBook(decode(t, `{"type": "l2update", "product_id": "ETH-USD", "changes": [["sell", "731.99", "0"]]}`))
+
+	require.Eventually(t, func() bool { return acc.HasMeasurement("coinbase_book_check") }, time.Second, time.Millisecond)
+	require.NoError(t, acc.FirstError())
+	acc.AssertContainsTaggedFields(t, "coinbase_book_check",
+		map[string]interface{}{"book_consistent": 1, "divergences": 0},
+		map[string]string{"product_id": "ETH-USD"},
+	)
+}
+
+func TestVerifyBookGivesUp(t *testing.T) {
+	ts := newRestServer(t, map[string]string{
+		"ETH-USD": `{"sequence": 3, "bids": [], "asks": []}`,
+	})
+
+	wsl, acc := newVerifyingListener(t, ts)
+	wsl.VerifyInterval = internal.Duration{Duration: 50 * time.Millisecond}
+	wsl.updateBook(decode(t, proSnapshot))
+
+	// the feed never reaches the sequence of the snapshot
+	require.NoError(t, wsl.Gather(acc))
+	acc.WaitError(1)
+	require.Contains(t, acc.FirstError().Error(), "did not reach sequence 3")
+	require.False(t, acc.HasMeasurement("coinbase_book_check"))
+
+	wsl.books.mutex.Lock()
+	defer wsl.books.mutex.Unlock()
+	require.Empty(t, wsl.books.watches)
+}
+
+func TestBookMetrics(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.OrderBook = true
+	wsl.BookDepths = []int{1, 2, 5}
+	require.NoError(t, wsl.Init())
+
+	wsl.addMetric([]byte(proSnapshot))
+	require.NoError(t, acc.FirstError())
+	require.NoError(t, wsl.Gather(acc))
+
+	bid, ask := 731.83, 731.99
+	bidDepth5, askDepth5 := 7.5, 3.5
+	acc.AssertContainsTaggedFields(t, "coinbase_book",
+		map[string]interface{}{
+			"best_bid":      731.83,
+			"best_bid_size": 1.0,
+			"best_ask":      731.99,
+			"best_ask_size": 0.5,
+			"spread":        ask - bid,
+			"mid_price":     (ask + bid) / 2,
+			"bid_depth_1":   1.0,
+			"bid_depth_2":   3.5,
+			"bid_depth_5":   7.5,
+			"ask_depth_1":   0.5,
+			"ask_depth_2":   3.5,
+			"ask_depth_5":   3.5,
+			"imbalance_1":   0.5 / 1.5,
+			"imbalance_2":   0.0,
+			"imbalance_5":   (bidDepth5 - askDepth5) / (bidDepth5 + askDepth5),
+		},
+		map[string]string{"product_id": "ETH-USD"},
+	)
+
+	wsl.BookDepths = []int{0}
+	require.Error(t, wsl.Init())
+}
@@ -0,0 +1,49 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/influxdata/telegraf/internal/wsclient"
+)
+
+const defaultRateLimitBackoff = time.Minute
+
+// noteRateLimit remembers that the server told us we are rate limited, so
+// that the disconnect which usually follows is backed off accordingly
+func (wsl *WebSocketListener) noteRateLimit(reason string) {
+	wsl.rateLimitMutex.Lock()
+	defer wsl.rateLimitMutex.Unlock()
+	wsl.rateLimitReason = reason
+}
+
+// takeRateLimit returns the reason we were rate limited, if either the server
+// told us so before disconnecting or cause itself is a rate limit
+func (wsl *WebSocketListener) takeRateLimit(cause error) (string, bool) {
+	wsl.rateLimitMutex.Lock()
+	reason := wsl.rateLimitReason
+	wsl.rateLimitReason = ""
+	wsl.rateLimitMutex.Unlock()
+
+	if reason != "" {
+		return reason, true
+	}
+	if wsclient.IsRateLimited(cause) {
+		return cause.Error(), true
+	}
+	return "", false
+}
+
+func (wsl *WebSocketListener) emitRateLimited(reason string) {
+	wsl.AddError(fmt.Errorf("rate limited by %s, backing off for %s: %s", wsl.ServiceAddress, wsl.RateLimitBackoff.Duration, reason))
+
+	wsl.AddFields("coinbase_rate_limited",
+		map[string]interface{}{
+			"reason":          reason,
+			"backoff_seconds": wsl.RateLimitBackoff.Duration.Seconds(),
+		},
+		map[string]string{
+			"service_address": wsl.ServiceAddress,
+		},
+	)
+}
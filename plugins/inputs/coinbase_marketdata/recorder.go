@@ -0,0 +1,89 @@
+package coinbase_marketdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/influxdata/telegraf/internal/rotate"
+)
+
+const defaultRecordMaxArchives = 5
+
+// recordedFrame is a line of record_file, a websocket frame as received
+// before any parsing. Frames which are not JSON are kept as text.
+type recordedFrame struct {
+	Received   time.Time       `json:"received"`
+	Connection string          `json:"connection"`
+	Address    string          `json:"address"`
+	Message    json.RawMessage `json:"message,omitempty"`
+	Text       string          `json:"text,omitempty"`
+}
+
+// openRecorder opens record_file, rotated every record_rotation_interval
+// or record_rotation_max_size
+func (wsl *WebSocketListener) openRecorder() error {
+	recorder, err := rotate.NewFileWriter(wsl.RecordFile, wsl.RecordRotationInterval.Duration,
+		wsl.RecordRotationMaxSize.Size, wsl.RecordRotationMaxArchives)
+	if err != nil {
+		return fmt.Errorf("opening record_file: %s", err)
+	}
+
+	wsl.recordMutex.Lock()
+	wsl.recorder = recorder
+	wsl.recordFailing = false
+	wsl.recordMutex.Unlock()
+	return nil
+}
+
+// closeRecorder closes record_file once no more frames are received
+func (wsl *WebSocketListener) closeRecorder() {
+	wsl.recordMutex.Lock()
+	defer wsl.recordMutex.Unlock()
+
+	if wsl.recorder == nil {
+		return
+	}
+	if err := wsl.recorder.Close(); err != nil {
+		wsl.Log.Errorf("Unable to close record_file: %s", err)
+	}
+	wsl.recorder = nil
+}
+
+// record appends a frame received on c to record_file as a line of JSON.
+// A failing write is reported once until writing succeeds again, rather
+// than on every frame.
+func (wsl *WebSocketListener) record(c *connection, message []byte, received time.Time) {
+	frame := recordedFrame{
+		Received:   received,
+		Connection: c.name,
+		Address:    c.getAddress(),
+	}
+	if json.Valid(message) {
+		frame.Message = message
+	} else {
+		frame.Text = string(message)
+	}
+
+	line, err := json.Marshal(frame)
+	if err != nil {
+		wsl.Log.Errorf("Unable to record frame: %s", err)
+		return
+	}
+	line = append(line, '\n')
+
+	wsl.recordMutex.Lock()
+	defer wsl.recordMutex.Unlock()
+
+	if wsl.recorder == nil {
+		return
+	}
+	if _, err := wsl.recorder.Write(line); err != nil {
+		if !wsl.recordFailing {
+			wsl.AddError(fmt.Errorf("unable to write to record_file %s: %s", wsl.RecordFile, err))
+		}
+		wsl.recordFailing = true
+		return
+	}
+	wsl.recordFailing = false
+}
@@ -0,0 +1,51 @@
+package coinbase_marketdata
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordFile(t *testing.T) {
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(proTicker))
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("not json"))
+		time.Sleep(time.Second)
+	})
+	recordFile := filepath.Join(t.TempDir(), "frames.ndjson")
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.RecordFile = recordFile
+	require.NoError(t, wsl.Init())
+	require.NoError(t, wsl.Start(acc))
+	require.Eventually(t, func() bool {
+		return wsl.clientStat(messagesReceived) == 2
+	}, time.Second, 10*time.Millisecond)
+	wsl.Stop()
+
+	f, err := os.Open(recordFile)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var frames []recordedFrame
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var frame recordedFrame
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &frame))
+		frames = append(frames, frame)
+	}
+	require.Len(t, frames, 2)
+
+	require.Equal(t, "primary", frames[0].Connection)
+	require.Equal(t, wsl.ServiceAddress, frames[0].Address)
+	require.False(t, frames[0].Received.IsZero())
+	require.JSONEq(t, proTicker, string(frames[0].Message))
+	require.Equal(t, "not json", frames[1].Text)
+}
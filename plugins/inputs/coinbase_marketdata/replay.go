@@ -0,0 +1,169 @@
+package coinbase_marketdata
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const defaultReplaySpeed = 1.0
+
+// replayFiles returns the files matching the pattern of replay_file, the
+// least recently modified first, so that the rotated archives of a
+// record_file are replayed before the current file
+func replayFiles(pattern string) ([]string, error) {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no file matches %s", pattern)
+	}
+
+	modified := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modified[file] = info.ModTime()
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return modified[files[i]].Before(modified[files[j]])
+	})
+	return files, nil
+}
+
+// replayPacer spaces the frames replayed as they were received, sped up by
+// replay_speed. A speed of 0 replays them as fast as they are processed.
+type replayPacer struct {
+	speed float64
+	first time.Time
+	start time.Time
+}
+
+// wait waits until a frame received at received is due, returning false
+// when the plugin stopped first
+func (p *replayPacer) wait(done <-chan struct{}, received time.Time) bool {
+	if p.speed <= 0 {
+		return true
+	}
+	if p.first.IsZero() {
+		p.first, p.start = received, time.Now()
+		return true
+	}
+
+	due := p.start.Add(time.Duration(float64(received.Sub(p.first)) / p.speed))
+	delay := time.Until(due)
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// replay pushes the frames recorded in replay_file through the workers as
+// if received again at their original receive time, instead of connecting
+func (wsl *WebSocketListener) replay() {
+	files, err := replayFiles(wsl.ReplayFile)
+	if err != nil {
+		wsl.AddError(fmt.Errorf("unable to replay: %s", err))
+		return
+	}
+
+	// the connections the frames were recorded on, tracking the sequence
+	// numbers of each
+	connections := make(map[string]*connection)
+	pacer := &replayPacer{speed: wsl.ReplaySpeed}
+	for _, file := range files {
+		if err := wsl.replayFile(file, connections, pacer); err != nil {
+			wsl.AddError(fmt.Errorf("unable to replay %s: %s", file, err))
+			return
+		}
+		if wsl.ctx.Err() != nil {
+			return
+		}
+	}
+	wsl.Log.Infof("Replayed %d file(s) matching %s", len(files), wsl.ReplayFile)
+}
+
+func (wsl *WebSocketListener) replayFile(file string, connections map[string]*connection, pacer *replayPacer) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// read by line rather than with a bufio.Scanner, as the snapshot of a
+	// book can exceed any reasonable maximum line length
+	reader := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if len(data) > 0 {
+			var frame recordedFrame
+			if jsonErr := json.Unmarshal(data, &frame); jsonErr != nil {
+				wsl.parseError(fmt.Errorf("invalid frame at %s:%d: %s", file, line, jsonErr))
+			} else {
+				if !pacer.wait(wsl.ctx.Done(), frame.Received) {
+					return nil
+				}
+				wsl.replayFrame(connections, frame)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// replayFrame handles a recorded frame like handle does a frame received on
+// its connection. With standby the frames recorded on both connections of a
+// shard are deduplicated as they were when received.
+func (wsl *WebSocketListener) replayFrame(connections map[string]*connection, frame recordedFrame) {
+	message := []byte(frame.Message)
+	if frame.Message == nil {
+		message = []byte(frame.Text)
+	}
+
+	c, ok := connections[frame.Connection]
+	if !ok {
+		c = &connection{
+			name:      frame.Connection,
+			address:   frame.Address,
+			sequences: make(map[string]int64),
+		}
+		connections[frame.Connection] = c
+	}
+	wsl.checkSequence(c, message)
+
+	if wsl.Standby && !wsl.emitted.add(hashMessage(message)) {
+		return
+	}
+	wsl.forward(message, frame.Received)
+}
+
+// validateReplay checks the settings of replay_file
+func (wsl *WebSocketListener) validateReplay() error {
+	if wsl.ReplaySpeed < 0 {
+		return fmt.Errorf("invalid replay_speed %v, must not be negative", wsl.ReplaySpeed)
+	}
+	if wsl.RecordFile != "" {
+		return fmt.Errorf("record_file can not be set along with replay_file")
+	}
+	return nil
+}
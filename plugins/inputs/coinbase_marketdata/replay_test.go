@@ -0,0 +1,71 @@
+package coinbase_marketdata
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeRecording(t *testing.T, path string, frames ...recordedFrame) {
+	var lines []string
+	for _, frame := range frames {
+		line, err := json.Marshal(frame)
+		require.NoError(t, err)
+		lines = append(lines, string(line))
+	}
+	require.NoError(t, ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644))
+}
+
+func TestReplayFile(t *testing.T) {
+	dir := t.TempDir()
+	received := time.Date(2020, 12, 28, 23, 54, 33, 0, time.UTC)
+	writeRecording(t, filepath.Join(dir, "frames.ndjson"),
+		recordedFrame{Received: received, Connection: "primary", Message: json.RawMessage(proTicker)},
+		recordedFrame{Received: received.Add(time.Second), Connection: "primary", Message: json.RawMessage(proMatch)},
+	)
+
+	wsl, acc := newTestListener(t)
+	wsl.ReplayFile = filepath.Join(dir, "*.ndjson")
+	wsl.ReplaySpeed = 10
+	wsl.TimestampSource = timestampSourceLocal
+	require.NoError(t, wsl.Init())
+
+	start := time.Now()
+	require.NoError(t, wsl.Start(acc))
+	acc.Wait(2)
+	// the second frame is replayed a tenth of a second after the first
+	require.True(t, time.Since(start) >= 100*time.Millisecond)
+	wsl.Stop()
+	require.NoError(t, acc.FirstError())
+
+	ticker, ok := acc.Get("ticker")
+	require.True(t, ok)
+	require.Equal(t, received, ticker.Time)
+	match, ok := acc.Get("match")
+	require.True(t, ok)
+	require.Equal(t, received.Add(time.Second), match.Time)
+}
+
+func TestReplayFileValidation(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.ReplayFile = filepath.Join(t.TempDir(), "*.ndjson")
+	wsl.ReplaySpeed = -1
+	require.Error(t, wsl.Init())
+
+	wsl.ReplaySpeed = 0
+	wsl.RecordFile = "frames.ndjson"
+	require.Error(t, wsl.Init())
+
+	// no recording to replay
+	wsl.RecordFile = ""
+	require.NoError(t, wsl.Init())
+	require.NoError(t, wsl.Start(acc))
+	acc.WaitError(1)
+	wsl.Stop()
+	require.Contains(t, acc.FirstError().Error(), "no file matches")
+}
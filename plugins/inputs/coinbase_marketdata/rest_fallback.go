@@ -0,0 +1,99 @@
+package coinbase_marketdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// the response of the /products/{id}/ticker REST endpoint
+type restTicker struct {
+	TradeId int64  `json:"trade_id"`
+	Price   string `json:"price"`
+	Size    string `json:"size"`
+	Bid     string `json:"bid"`
+	Ask     string `json:"ask"`
+	Volume  string `json:"volume"`
+	Time    string `json:"time"`
+}
+
+// the tags and fields of a ticker message missing from the REST ticker
+var restTickerAbsent = []string{"side", "open_24h", "low_24h", "high_24h", "volume_30d", "sequence_id"}
+
+// disconnectionChanged records since when no connection is up, for
+// rest_fallback_after
+func (wsl *WebSocketListener) disconnectionChanged(now time.Time) {
+	up, _ := wsl.Healthy()
+
+	wsl.fallbackMutex.Lock()
+	defer wsl.fallbackMutex.Unlock()
+	if !up && wsl.disconnectedSince.IsZero() {
+		wsl.disconnectedSince = now
+	} else if up {
+		wsl.disconnectedSince = time.Time{}
+	}
+}
+
+// pollFallback polls the REST ticker of every product once no connection
+// was up for rest_fallback_after, so that prices keep flowing until the
+// stream recovers
+func (wsl *WebSocketListener) pollFallback(now time.Time) {
+	wsl.fallbackMutex.Lock()
+	since := wsl.disconnectedSince
+	wsl.fallbackMutex.Unlock()
+
+	if since.IsZero() || now.Sub(since) < wsl.RestFallbackAfter.Duration {
+		if wsl.fallbackActive {
+			wsl.Log.Infof("Stream recovered, no longer polling the REST ticker")
+			wsl.fallbackActive = false
+		}
+		return
+	}
+	if !wsl.fallbackActive {
+		wsl.Log.Warnf("Disconnected for %s, polling the REST ticker until the stream recovers", now.Sub(since).Round(time.Second))
+		wsl.fallbackActive = true
+	}
+
+	for _, productId := range wsl.ProductIds {
+		ticker, err := wsl.fetchTicker(productId)
+		if err != nil {
+			wsl.AddError(fmt.Errorf("unable to poll the ticker of %s: %s", productId, err))
+			continue
+		}
+
+		// the fields of a ticker message of the pro feed
+		marketData := map[string]interface{}{
+			"type":       "ticker",
+			"product_id": productId,
+			"trade_id":   float64(ticker.TradeId),
+			"price":      ticker.Price,
+			"last_size":  ticker.Size,
+			"best_bid":   ticker.Bid,
+			"best_ask":   ticker.Ask,
+			"volume_24h": ticker.Volume,
+			"time":       ticker.Time,
+		}
+		wsl.emitFetched(marketData, now, map[string]string{"source": "rest"}, restTickerAbsent)
+	}
+}
+
+func (wsl *WebSocketListener) fetchTicker(productId string) (*restTicker, error) {
+	url := fmt.Sprintf("%s/products/%s/ticker", wsl.RestAddress, productId)
+
+	resp, err := wsl.httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+
+	ticker := &restTicker{}
+	if err := json.NewDecoder(resp.Body).Decode(ticker); err != nil {
+		return nil, fmt.Errorf("decoding %s: %s", url, err)
+	}
+	return ticker, nil
+}
@@ -0,0 +1,56 @@
+package coinbase_marketdata
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestFallback(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/products/ETH-USD/ticker" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"trade_id": 4729088, "price": "333.99", "size": "0.193", "bid": "333.98", "ask": "333.99", "volume": "5957.11914015", "time": "2015-11-14T20:46:03.511254Z"}`))
+	}))
+	defer ts.Close()
+
+	wsl, acc := newTestListener(t)
+	wsl.RestAddress = ts.URL
+	wsl.ProductIds = []string{"ETH-USD"}
+	wsl.RestFallbackAfter = internal.Duration{Duration: time.Minute}
+	c := &connection{name: "primary", up: true}
+	wsl.connections = []*connection{c}
+
+	t0 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.setUp(false)
+	wsl.connectionChanged(t0)
+
+	// not disconnected for long enough
+	wsl.pollFallback(t0.Add(30 * time.Second))
+	require.Empty(t, acc.GetTelegrafMetrics())
+
+	wsl.pollFallback(t0.Add(time.Minute))
+	require.NoError(t, acc.FirstError())
+	m, ok := acc.Get("ticker")
+	require.True(t, ok)
+	require.Equal(t, map[string]string{"type": "ticker", "product_id": "ETH-USD", "source": "rest"}, m.Tags)
+	require.Equal(t, 333.99, m.Fields["price"])
+	require.Equal(t, 333.98, m.Fields["best_bid"])
+	require.Equal(t, 0.193, m.Fields["last_size"])
+	require.Equal(t, 5957.11914015, m.Fields["volume_24h"])
+	require.NotContains(t, m.Fields, "open_24h")
+	require.Equal(t, time.Date(2015, 11, 14, 20, 46, 3, 511254000, time.UTC), m.Time)
+
+	// the stream recovered
+	acc.ClearMetrics()
+	c.setUp(true)
+	wsl.connectionChanged(t0.Add(90 * time.Second))
+	wsl.pollFallback(t0.Add(2 * time.Minute))
+	require.Empty(t, acc.GetTelegrafMetrics())
+}
@@ -0,0 +1,45 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+	"time"
+)
+
+// parseSampleIntervals validates the product_sample_intervals option
+func parseSampleIntervals(intervals map[string]string) (map[string]time.Duration, error) {
+	parsed := make(map[string]time.Duration, len(intervals))
+	for productId, interval := range intervals {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sample interval %q for %s: %s", interval, productId, err)
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("invalid sample interval %q for %s: must not be negative", interval, productId)
+		}
+		parsed[productId] = d
+	}
+	return parsed, nil
+}
+
+// sample decides whether the metrics of a message are emitted. Products with
+// a sample interval emit at most one message of each type per interval, all
+// other products emit every message.
+func (wsl *WebSocketListener) sample(marketData map[string]interface{}, now time.Time) bool {
+	productId := fmt.Sprintf("%v", marketData["product_id"])
+
+	interval, ok := wsl.sampleIntervals[productId]
+	if !ok || interval == 0 {
+		return true
+	}
+
+	key := productId + "|" + messageType(marketData)
+
+	wsl.sampleMutex.Lock()
+	defer wsl.sampleMutex.Unlock()
+
+	if last, ok := wsl.lastSampled[key]; ok && now.Sub(last) < interval {
+		return false
+	}
+	wsl.lastSampled[key] = now
+	return true
+}
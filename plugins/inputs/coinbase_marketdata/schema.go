@@ -0,0 +1,40 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+
+	"github.com/influxdata/telegraf/internal/choice"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+)
+
+// initNormalizer maps the tickers, matches and books to the schema shared by
+// the exchange inputs when metric_schema is normalized. The symbol and side
+// of the parsed metrics are among the metric_tag_keys.
+func (wsl *WebSocketListener) initNormalizer() error {
+	normalizer, err := marketdata.NewNormalizer(wsl.MetricSchema, marketdata.Normalizer{
+		Exchange: "coinbase",
+		Measurements: map[string]string{
+			wsl.measurementName("ticker"):     marketdata.Ticker,
+			wsl.measurementName("match"):      marketdata.Trade,
+			wsl.measurementName("last_match"): marketdata.Trade,
+			"coinbase_book":                   marketdata.Book,
+		},
+		SymbolTag: "product_id",
+		// the side of the matches of the exchange feed is the side of the
+		// maker order, that of the advanced trade feed the side of the taker
+		MakerSide: !wsl.advancedFeed(),
+	}, wsl.ProductIds)
+	if err != nil {
+		return err
+	}
+
+	if normalizer != nil {
+		for _, key := range []string{"product_id", "side"} {
+			if !choice.Contains(key, wsl.MetricTagKeys) {
+				return fmt.Errorf("metric_schema %q requires %q among the metric_tag_keys", wsl.MetricSchema, key)
+			}
+		}
+	}
+	wsl.normalizer = normalizer
+	return nil
+}
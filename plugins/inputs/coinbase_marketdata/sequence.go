@@ -0,0 +1,96 @@
+package coinbase_marketdata
+
+import (
+	"github.com/tidwall/gjson"
+)
+
+// the message types of the full channel, whose sequence numbers are
+// contiguous per product. Other channels, such as ticker, only carry the
+// sequence number of a subset of the product's messages, so only out of
+// order delivery can be detected on them.
+var contiguousTypes = map[string]bool{
+	"received": true,
+	"open":     true,
+	"done":     true,
+	"match":    true,
+	"change":   true,
+	"activate": true,
+}
+
+// sequenceOf returns the key sequence numbers are tracked by, the sequence
+// number of a message and whether it is contiguous with the previous one of
+// that key. Advanced trade envelopes carry a sequence_num contiguous per
+// connection.
+func sequenceOf(message []byte) (key string, productId string, sequence int64, contiguous bool, ok bool) {
+	results := gjson.GetManyBytes(message, "type", "product_id", "sequence", "channel", "sequence_num")
+	msgType, product, seq, channel, seqNum := results[0], results[1], results[2], results[3], results[4]
+
+	if seqNum.Exists() && channel.Exists() {
+		return "", "", seqNum.Int(), true, true
+	}
+	if !seq.Exists() || !product.Exists() {
+		return "", "", 0, false, false
+	}
+
+	key = product.String() + "|" + channelOf(msgType.String())
+	return key, product.String(), seq.Int(), contiguousTypes[msgType.String()], true
+}
+
+// checkSequence detects gaps and out of order delivery in the sequence
+// numbers received on c. It emits a coinbase_sequence_gap metric and, with
+// resync_on_gap, closes the connection so that it reconnects, resubscribes
+// and receives fresh snapshots. Returns whether the sequence was broken.
+func (wsl *WebSocketListener) checkSequence(c *connection, message []byte) bool {
+	key, productId, sequence, contiguous, ok := sequenceOf(message)
+	if !ok {
+		return false
+	}
+
+	last, seen := c.sequences[key]
+	c.sequences[key] = sequence
+	if !seen {
+		return false
+	}
+
+	outOfOrder := sequence <= last
+	gap := contiguous && sequence > last+1
+	if !outOfOrder && !gap {
+		return false
+	}
+	if outOfOrder {
+		// keep the highest sequence seen, so a late message is not
+		// followed by a spurious gap
+		c.sequences[key] = last
+	}
+
+	tags := map[string]string{
+		"service_address": wsl.ServiceAddress,
+		"connection":      c.name,
+	}
+	if productId != "" {
+		tags["product_id"] = productId
+	}
+
+	missed := int64(0)
+	if gap {
+		missed = sequence - last - 1
+	}
+	wsl.AddFields("coinbase_sequence_gap",
+		map[string]interface{}{
+			"expected":     last + 1,
+			"received":     sequence,
+			"missed":       missed,
+			"out_of_order": outOfOrder,
+		},
+		tags,
+	)
+
+	if wsl.ResyncOnGap {
+		wsl.Log.Warnf("Sequence broken on %s connection, expected %d but received %d, resubscribing", c.name, last+1, sequence)
+		if conn := c.get(); conn != nil {
+			conn.Close()
+		}
+	}
+
+	return true
+}
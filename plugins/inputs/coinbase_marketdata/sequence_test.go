@@ -0,0 +1,79 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/stretchr/testify/require"
+)
+
+func fullMatch(sequence int) []byte {
+	return []byte(fmt.Sprintf(`{"type": "match", "product_id": "ETH-USD", "sequence": %d, "price": "731.99", "size": "0.1"}`, sequence))
+}
+
+func TestCheckSequence(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.ResyncOnGap = false
+	c := &connection{name: "primary", sequences: make(map[string]int64)}
+
+	require.False(t, wsl.checkSequence(c, fullMatch(1)))
+	require.False(t, wsl.checkSequence(c, fullMatch(2)))
+	require.True(t, wsl.checkSequence(c, fullMatch(5)))
+	acc.AssertContainsTaggedFields(t, "coinbase_sequence_gap",
+		map[string]interface{}{"expected": int64(3), "received": int64(5), "missed": int64(2), "out_of_order": false},
+		map[string]string{"service_address": wsl.ServiceAddress, "connection": "primary", "product_id": "ETH-USD"},
+	)
+
+	// a late message is out of order, the next one continues the sequence
+	require.True(t, wsl.checkSequence(c, fullMatch(4)))
+	require.False(t, wsl.checkSequence(c, fullMatch(6)))
+	acc.AssertContainsTaggedFields(t, "coinbase_sequence_gap",
+		map[string]interface{}{"expected": int64(6), "received": int64(4), "missed": int64(0), "out_of_order": true},
+		map[string]string{"service_address": wsl.ServiceAddress, "connection": "primary", "product_id": "ETH-USD"},
+	)
+
+	// ticker sequences skip the messages of other channels
+	require.False(t, wsl.checkSequence(c, []byte(proTicker)))
+	require.False(t, wsl.checkSequence(c, []byte(strings.Replace(proTicker, "12238444095", "12238444195", 1))))
+
+	// advanced trade envelopes are contiguous per connection
+	require.False(t, wsl.checkSequence(c, []byte(advancedTicker)))
+	require.True(t, wsl.checkSequence(c, []byte(strings.Replace(advancedTicker, `"sequence_num": 7`, `"sequence_num": 9`, 1))))
+
+	require.Equal(t, 3, len(acc.GetTelegrafMetrics()))
+}
+
+func TestSequenceGapResubscribes(t *testing.T) {
+	var connections int32
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		_, _, _ = conn.ReadMessage()
+		if atomic.AddInt32(&connections, 1) == 1 {
+			_ = conn.WriteMessage(websocket.TextMessage, fullMatch(1))
+			_ = conn.WriteMessage(websocket.TextMessage, fullMatch(3))
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.ReconnectInterval = internal.Duration{Duration: time.Millisecond}
+	require.NoError(t, wsl.Start(acc))
+	defer wsl.Stop()
+
+	require.Eventually(t, func() bool {
+		return acc.HasMeasurement("coinbase_sequence_gap")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&connections) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,56 @@
+package coinbase_marketdata
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf/internal/wsclient"
+)
+
+// errSubscriptionRejected is returned by Start when the server answers the
+// subscription with an error message
+var errSubscriptionRejected = errors.New("subscription rejected")
+
+// serverError reports an error message of the server, e.g. the answer to a
+// malformed subscription, in the format of
+// {
+//  "type": "error",
+//  "message": "Failed to subscribe",
+//  "reason": "BTC-USDX is not a valid product"
+// }
+func (wsl *WebSocketListener) serverError(marketData map[string]interface{}) {
+	message := fmt.Sprintf("%v", marketData["message"])
+	reason, _ := marketData["reason"].(string)
+
+	if wsclient.IsRateLimitText(message) || wsclient.IsRateLimitText(reason) {
+		wsl.noteRateLimit(message)
+	}
+
+	fields := map[string]interface{}{
+		"message": message,
+	}
+	text := message
+	if reason != "" {
+		fields["reason"] = reason
+		text = fmt.Sprintf("%s: %s", message, reason)
+	}
+
+	wsl.AddError(fmt.Errorf("error from %s: %s", wsl.ServiceAddress, text))
+	wsl.AddFields("coinbase_error", fields,
+		map[string]string{
+			"service_address": wsl.ServiceAddress,
+		},
+	)
+}
+
+// resetDeadline restores the read deadline of the keepalive, or none, after
+// a read with a deadline of its own
+func (wsl *WebSocketListener) resetDeadline(conn *websocket.Conn) {
+	if wsl.PingInterval.Duration > 0 {
+		wsclient.ExtendDeadline(conn, wsl.PingInterval.Duration, wsl.PongWait.Duration)
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+}
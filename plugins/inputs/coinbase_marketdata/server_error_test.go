@@ -0,0 +1,63 @@
+package coinbase_marketdata
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+const proError = `{"type": "error", "message": "Failed to subscribe", "reason": "BTC-USDX is not a valid product"}`
+
+func TestServerError(t *testing.T) {
+	wsl, acc := newTestListener(t)
+
+	wsl.addMetric([]byte(proError))
+	require.EqualError(t, acc.FirstError(), "error from : Failed to subscribe: BTC-USDX is not a valid product")
+
+	acc.AssertContainsTaggedFields(t, "coinbase_error",
+		map[string]interface{}{
+			"message": "Failed to subscribe",
+			"reason":  "BTC-USDX is not a valid product",
+		},
+		map[string]string{"service_address": wsl.ServiceAddress},
+	)
+}
+
+func TestFailOnSubscribeError(t *testing.T) {
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		_, _, _ = conn.ReadMessage()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(proError))
+		_, _, _ = conn.ReadMessage()
+	})
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.FailOnSubscribeError = true
+
+	err := wsl.Start(acc)
+	require.Error(t, err)
+	require.True(t, errors.Is(err, errSubscriptionRejected))
+}
+
+func TestFailOnSubscribeErrorKeepsAnswer(t *testing.T) {
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		_, _, _ = conn.ReadMessage()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(proTicker))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.FailOnSubscribeError = true
+	require.NoError(t, wsl.Start(acc))
+	defer wsl.Stop()
+
+	acc.Wait(1)
+	require.True(t, acc.HasMeasurement("ticker"))
+}
@@ -0,0 +1,87 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+)
+
+// shard is a subset of product_ids subscribed to over connections of its
+// own, a primary and optionally a standby
+type shard struct {
+	products []string
+
+	// the connection whose messages are emitted, guarded by failoverMutex
+	active *connection
+}
+
+// shardProducts splits product_ids into chunks of at most
+// max_products_per_connection products, keeping them all on one connection
+// when unset
+func (wsl *WebSocketListener) shardProducts() [][]string {
+	if wsl.MaxProductsPerConnection <= 0 || len(wsl.ProductIds) <= wsl.MaxProductsPerConnection {
+		return [][]string{wsl.ProductIds}
+	}
+
+	var shards [][]string
+	for start := 0; start < len(wsl.ProductIds); start += wsl.MaxProductsPerConnection {
+		end := start + wsl.MaxProductsPerConnection
+		if end > len(wsl.ProductIds) {
+			end = len(wsl.ProductIds)
+		}
+		shards = append(shards, wsl.ProductIds[start:end])
+	}
+	return shards
+}
+
+// newConnections creates the connections of every shard. The standby starts
+// out on the next endpoint, if there is one, so that the failure of a single
+// endpoint doesn't take both connections down.
+func (wsl *WebSocketListener) newConnections() {
+	addresses := wsl.addresses()
+	shards := wsl.shardProducts()
+
+	name := func(role string, i int) string {
+		if len(shards) == 1 {
+			return role
+		}
+		return fmt.Sprintf("%s-%d", role, i+1)
+	}
+
+	wsl.connections = nil
+	wsl.shards = make(map[string]*shard)
+	for i, products := range shards {
+		s := &shard{products: products}
+		for _, productId := range products {
+			wsl.shards[productId] = s
+		}
+
+		primary := &connection{name: name("primary", i), shard: s, address: addresses[0]}
+		s.active = primary
+		wsl.connections = append(wsl.connections, primary)
+
+		if wsl.Standby {
+			endpoint := 1 % len(addresses)
+			wsl.connections = append(wsl.connections,
+				&connection{name: name("standby", i), shard: s, address: addresses[endpoint], endpoint: endpoint})
+		}
+	}
+
+	for _, c := range wsl.connections {
+		wsl.newClient(c)
+	}
+}
+
+// activeConnection returns the connection whose messages of a product are
+// emitted, that of the first shard for messages without a known product
+func (wsl *WebSocketListener) activeConnection(productId string) *connection {
+	s, ok := wsl.shards[productId]
+	if !ok {
+		if len(wsl.connections) == 0 || wsl.connections[0].shard == nil {
+			return nil
+		}
+		s = wsl.connections[0].shard
+	}
+
+	wsl.failoverMutex.Lock()
+	defer wsl.failoverMutex.Unlock()
+	return s.active
+}
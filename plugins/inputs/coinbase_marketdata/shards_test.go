@@ -0,0 +1,90 @@
+package coinbase_marketdata
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestShardProducts(t *testing.T) {
+	wsl, _ := newTestListener(t)
+	wsl.ProductIds = []string{"BTC-USD", "ETH-USD", "LTC-USD", "DOGE-USD", "SOL-USD"}
+	require.Equal(t, [][]string{wsl.ProductIds}, wsl.shardProducts())
+
+	wsl.MaxProductsPerConnection = 2
+	require.Equal(t, [][]string{
+		{"BTC-USD", "ETH-USD"},
+		{"LTC-USD", "DOGE-USD"},
+		{"SOL-USD"},
+	}, wsl.shardProducts())
+}
+
+func TestShardedConnections(t *testing.T) {
+	var mutex sync.Mutex
+	var subscriptions []string
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var products []string
+		for _, productId := range gjson.GetBytes(msg, "product_ids").Array() {
+			products = append(products, productId.String())
+		}
+		mutex.Lock()
+		subscriptions = append(subscriptions, strings.Join(products, ","))
+		mutex.Unlock()
+
+		// answer with a ticker of the first product of the shard
+		ticker := strings.Replace(proTicker, "ETH-USD", products[0], 1)
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(ticker))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.ProductIds = []string{"BTC-USD", "ETH-USD", "LTC-USD"}
+	wsl.Channels = []string{"ticker"}
+	wsl.MaxProductsPerConnection = 2
+	require.NoError(t, wsl.Init())
+	require.NoError(t, wsl.Start(acc))
+
+	acc.Wait(2)
+	wsl.Stop()
+
+	var names []string
+	for _, c := range wsl.connections {
+		names = append(names, c.name)
+	}
+	require.Equal(t, []string{"primary-1", "primary-2"}, names)
+
+	sort.Strings(subscriptions)
+	require.Equal(t, []string{"BTC-USD,ETH-USD", "LTC-USD"}, subscriptions)
+
+	products := make(map[string]bool)
+	for _, m := range acc.GetTelegrafMetrics() {
+		products[m.Tags()["product_id"]] = true
+	}
+	require.Equal(t, map[string]bool{"BTC-USD": true, "LTC-USD": true}, products)
+}
+
+func TestShardingValidation(t *testing.T) {
+	wsl, _ := newTestListener(t)
+	wsl.OnConnectMsg = `{"type": "subscribe"}`
+	wsl.MaxProductsPerConnection = 10
+	require.Error(t, wsl.Init())
+
+	wsl.OnConnectMsg = ""
+	wsl.MaxProductsPerConnection = -1
+	require.Error(t, wsl.Init())
+}
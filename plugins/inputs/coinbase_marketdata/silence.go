@@ -0,0 +1,115 @@
+package coinbase_marketdata
+
+import (
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// productOf returns the product a message belongs to, for both legacy
+// messages and advanced trade envelopes
+func productOf(message []byte) (string, bool) {
+	for _, path := range []string{"product_id", "events.0.product_id", "events.0.tickers.0.product_id"} {
+		if r := gjson.GetBytes(message, path); r.Exists() {
+			return r.String(), true
+		}
+	}
+	return "", false
+}
+
+// productReceived records that a message of the product of message was
+// emitted at now
+func (wsl *WebSocketListener) productReceived(message []byte, now time.Time) {
+	if wsl.MaxSilence.Duration <= 0 {
+		return
+	}
+
+	productId, ok := productOf(message)
+	if !ok {
+		return
+	}
+
+	wsl.silenceMutex.Lock()
+	defer wsl.silenceMutex.Unlock()
+	if wsl.lastProductMessage == nil {
+		wsl.lastProductMessage = make(map[string]time.Time)
+	}
+	wsl.lastProductMessage[productId] = now
+}
+
+// resetSilence restarts the silence of the products of a subscription at
+// now, of every product when productIds is nil, as a fresh subscription gets
+// a max_silence of its own to deliver. The configured products are tracked
+// from the start, so that a product never delivering is detected as well.
+func (wsl *WebSocketListener) resetSilence(now time.Time, productIds []string) {
+	if wsl.MaxSilence.Duration <= 0 {
+		return
+	}
+
+	wsl.silenceMutex.Lock()
+	defer wsl.silenceMutex.Unlock()
+
+	if wsl.lastProductMessage == nil {
+		wsl.lastProductMessage = make(map[string]time.Time)
+	}
+	if productIds == nil {
+		for productId := range wsl.lastProductMessage {
+			wsl.lastProductMessage[productId] = now
+		}
+		productIds = wsl.ProductIds
+	}
+	for _, productId := range productIds {
+		wsl.lastProductMessage[productId] = now
+	}
+}
+
+// checkSilence emits a coinbase_stale metric for every product without a
+// message for max_silence and closes the active connection of the product,
+// so that it reconnects and resubscribes. Coinbase occasionally stops
+// sending a product over a connection which is otherwise alive, which the
+// keepalive can not detect.
+func (wsl *WebSocketListener) checkSilence(now time.Time) {
+	if wsl.MaxSilence.Duration <= 0 {
+		return
+	}
+
+	wsl.silenceMutex.Lock()
+	silences := make(map[string]time.Duration)
+	for productId, last := range wsl.lastProductMessage {
+		if silence := now.Sub(last); silence > wsl.MaxSilence.Duration {
+			silences[productId] = silence
+		}
+	}
+	wsl.silenceMutex.Unlock()
+
+	stale := make(map[*connection]int)
+	for productId, silence := range silences {
+		active := wsl.activeConnection(productId)
+		if active == nil || !active.isUp() {
+			// reconnecting already
+			continue
+		}
+		stale[active]++
+
+		wsl.AddFields("coinbase_stale",
+			map[string]interface{}{
+				"silence_seconds":     silence.Seconds(),
+				"max_silence_seconds": wsl.MaxSilence.Duration.Seconds(),
+			},
+			map[string]string{
+				"service_address": wsl.ServiceAddress,
+				"product_id":      productId,
+			},
+			now,
+		)
+	}
+
+	for c, products := range stale {
+		wsl.Log.Warnf("No message received for %d products within %s on %s connection, resubscribing",
+			products, wsl.MaxSilence.Duration, c.name)
+		wsl.resetSilence(now, c.products())
+		if conn := c.get(); conn != nil {
+			conn.Close()
+		}
+	}
+}
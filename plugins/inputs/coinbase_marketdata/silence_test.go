@@ -0,0 +1,69 @@
+package coinbase_marketdata
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProductOf(t *testing.T) {
+	productId, ok := productOf([]byte(proTicker))
+	require.True(t, ok)
+	require.Equal(t, "ETH-USD", productId)
+
+	productId, ok = productOf([]byte(advancedTicker))
+	require.True(t, ok)
+	require.Equal(t, "BTC-USD", productId)
+
+	_, ok = productOf([]byte(`{"type": "subscriptions"}`))
+	require.False(t, ok)
+}
+
+func TestSilentProductResubscribes(t *testing.T) {
+	var connections int32
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		atomic.AddInt32(&connections, 1)
+		_, _, _ = conn.ReadMessage()
+		// keep sending ETH-USD but never BTC-USD
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(proTicker))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	wsl.ProductIds = []string{"ETH-USD", "BTC-USD"}
+	wsl.Channels = []string{"ticker"}
+	wsl.MaxSilence = internal.Duration{Duration: time.Minute}
+	wsl.ReconnectInterval = internal.Duration{Duration: time.Millisecond}
+	require.NoError(t, wsl.Init())
+	require.NoError(t, wsl.Start(acc))
+
+	acc.Wait(1)
+	now := time.Now()
+	wsl.productReceived([]byte(proTicker), now.Add(30*time.Second))
+
+	wsl.checkSilence(now.Add(90 * time.Second))
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&connections) == 2
+	}, time.Second, 10*time.Millisecond)
+	wsl.Stop()
+
+	var stale []string
+	for _, m := range acc.GetTelegrafMetrics() {
+		if m.Name() == "coinbase_stale" {
+			stale = append(stale, m.Tags()["product_id"])
+			silence, _ := m.GetField("silence_seconds")
+			require.GreaterOrEqual(t, silence.(float64), 90.0)
+		}
+	}
+	require.Equal(t, []string{"BTC-USD"}, stale)
+}
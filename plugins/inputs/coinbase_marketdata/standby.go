@@ -0,0 +1,129 @@
+package coinbase_marketdata
+
+import (
+	"hash/fnv"
+	"time"
+)
+
+// standbyHistory is the number of recently emitted messages remembered, and
+// of standby messages buffered, to bridge a failover without gaps or
+// duplicates
+const standbyHistory = 4096
+
+// recentSet remembers the last size hashes added to it
+type recentSet struct {
+	order []uint64
+	seen  map[uint64]struct{}
+	next  int
+	size  int
+}
+
+func newRecentSet(size int) *recentSet {
+	return &recentSet{
+		order: make([]uint64, 0, size),
+		seen:  make(map[uint64]struct{}, size),
+		size:  size,
+	}
+}
+
+// add adds h to the set, evicting the oldest hash if the set is full, and
+// reports whether h was not already in it
+func (s *recentSet) add(h uint64) bool {
+	if _, ok := s.seen[h]; ok {
+		return false
+	}
+
+	if len(s.order) < s.size {
+		s.order = append(s.order, h)
+	} else {
+		delete(s.seen, s.order[s.next])
+		s.order[s.next] = h
+		s.next = (s.next + 1) % s.size
+	}
+	s.seen[h] = struct{}{}
+	return true
+}
+
+func hashMessage(message []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(message)
+	return h.Sum64()
+}
+
+// accept reports whether a message received on c is to be emitted. Only the
+// messages of the active connection are emitted, once; those of the standby
+// are buffered until it is promoted.
+func (wsl *WebSocketListener) accept(c *connection, message []byte, received time.Time) bool {
+	if !wsl.Standby {
+		return true
+	}
+
+	wsl.failoverMutex.Lock()
+	defer wsl.failoverMutex.Unlock()
+
+	if c != c.shard.active {
+		if len(c.pending) >= standbyHistory {
+			// drop the older half at once rather than shifting on every message
+			c.pending = append(c.pending[:0], c.pending[standbyHistory/2:]...)
+		}
+		c.pending = append(c.pending, inbound{message, received})
+		return false
+	}
+
+	return wsl.emitted.add(hashMessage(message))
+}
+
+// failover promotes the standby connection when the active connection c
+// fails. The buffered standby messages which were not emitted by c are
+// emitted, so that no messages are lost while c reconnects, after which it
+// becomes the standby.
+func (wsl *WebSocketListener) failover(c *connection) {
+	if !wsl.Standby {
+		return
+	}
+
+	wsl.failoverMutex.Lock()
+
+	if c != c.shard.active {
+		wsl.failoverMutex.Unlock()
+		return
+	}
+
+	var standby *connection
+	for _, other := range wsl.connections {
+		if other != c && other.shard == c.shard && other.isUp() {
+			standby = other
+		}
+	}
+	if standby == nil {
+		wsl.failoverMutex.Unlock()
+		return
+	}
+
+	c.shard.active = standby
+
+	var replay []inbound
+	for _, message := range standby.pending {
+		if wsl.emitted.add(hashMessage(message.data)) {
+			replay = append(replay, message)
+		}
+	}
+	standby.pending = nil
+
+	wsl.failoverMutex.Unlock()
+
+	wsl.AddFields("coinbase_connection",
+		map[string]interface{}{
+			"state":      "promoted",
+			"connection": standby.name,
+			"replayed":   len(replay),
+		},
+		map[string]string{
+			"service_address": wsl.ServiceAddress,
+		},
+	)
+
+	for _, message := range replay {
+		wsl.dispatch(message.data, message.received)
+	}
+}
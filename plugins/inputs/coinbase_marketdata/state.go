@@ -0,0 +1,137 @@
+package coinbase_marketdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// productState is the last sequence number and trade id received of a
+// product, persisted in state_file across restarts of the agent
+type productState struct {
+	Sequence int64     `json:"sequence,omitempty"`
+	TradeId  int64     `json:"trade_id,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// loadState reads the product states saved by the previous run from
+// state_file. The first message of each product then reports the data
+// missed in between. A missing file is not an error.
+func (wsl *WebSocketListener) loadState() error {
+	wsl.stateMutex.Lock()
+	defer wsl.stateMutex.Unlock()
+
+	wsl.productStates = make(map[string]*productState)
+	wsl.restoredStates = make(map[string]*productState)
+
+	data, err := ioutil.ReadFile(wsl.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var states map[string]*productState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return fmt.Errorf("invalid state_file %s: %s", wsl.StateFile, err)
+	}
+	for productId, state := range states {
+		wsl.productStates[productId] = state
+		restored := *state
+		wsl.restoredStates[productId] = &restored
+	}
+	return nil
+}
+
+// saveState writes the product states to state_file, replacing it at once
+// so that a crash while writing does not leave a truncated file behind
+func (wsl *WebSocketListener) saveState() error {
+	wsl.stateMutex.Lock()
+	data, err := json.Marshal(wsl.productStates)
+	wsl.stateMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(wsl.StateFile), filepath.Base(wsl.StateFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), wsl.StateFile)
+}
+
+// trackState remembers the sequence number and trade id of a message of
+// the pro feed. The first one of a product restored from state_file emits a
+// coinbase_restart_gap metric with the sequence numbers and trades missed
+// while the agent was down.
+func (wsl *WebSocketListener) trackState(message []byte, now time.Time) {
+	results := gjson.GetManyBytes(message, "product_id", "sequence", "trade_id", "last_trade_id")
+	product, sequence, tradeId := results[0], results[1], results[2]
+	if !tradeId.Exists() {
+		tradeId = results[3]
+	}
+	if !product.Exists() || (!sequence.Exists() && !tradeId.Exists()) {
+		return
+	}
+	productId := product.String()
+
+	wsl.stateMutex.Lock()
+	defer wsl.stateMutex.Unlock()
+
+	if restored, ok := wsl.restoredStates[productId]; ok {
+		delete(wsl.restoredStates, productId)
+		wsl.emitRestartGap(productId, restored, sequence, tradeId, now)
+	}
+
+	state, ok := wsl.productStates[productId]
+	if !ok {
+		state = &productState{}
+		wsl.productStates[productId] = state
+	}
+	if sequence.Exists() && sequence.Int() > state.Sequence {
+		state.Sequence = sequence.Int()
+	}
+	if tradeId.Exists() && tradeId.Int() > state.TradeId {
+		state.TradeId = tradeId.Int()
+	}
+	state.Time = now
+}
+
+func (wsl *WebSocketListener) emitRestartGap(productId string, restored *productState, sequence gjson.Result, tradeId gjson.Result, now time.Time) {
+	fields := map[string]interface{}{
+		"outage_seconds": now.Sub(restored.Time).Seconds(),
+	}
+	if restored.Sequence > 0 && sequence.Exists() && sequence.Int() > restored.Sequence {
+		fields["missed_sequences"] = sequence.Int() - restored.Sequence - 1
+	}
+	if restored.TradeId > 0 && tradeId.Exists() && tradeId.Int() > restored.TradeId {
+		fields["missed_trades"] = tradeId.Int() - restored.TradeId - 1
+		fields["last_trade_id"] = restored.TradeId
+
+		if wsl.GapFill {
+			wsl.queueGapFill(tradeGap{productId: productId, last: restored.TradeId, next: tradeId.Int()})
+		}
+	}
+
+	wsl.AddFields("coinbase_restart_gap", fields,
+		map[string]string{
+			"product_id": productId,
+		},
+		now,
+	)
+}
@@ -0,0 +1,63 @@
+package coinbase_marketdata
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateFileReportsRestartGap(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	stopped := time.Date(2020, 12, 28, 23, 54, 32, 0, time.UTC)
+
+	wsl, acc := newTestListener(t)
+	wsl.StateFile = stateFile
+	require.NoError(t, wsl.loadState())
+	wsl.trackState([]byte(proTicker), stopped)
+	require.NoError(t, wsl.Gather(acc))
+	require.NoError(t, acc.FirstError())
+
+	// the agent restarts a minute later
+	wsl, acc = newTestListener(t)
+	wsl.StateFile = stateFile
+	require.NoError(t, wsl.loadState())
+	ticker := strings.Replace(proTicker, `"sequence": 12238444095`, `"sequence": 12238444195`, 1)
+	ticker = strings.Replace(ticker, `"trade_id": 71476932`, `"trade_id": 71476942`, 1)
+	wsl.trackState([]byte(ticker), stopped.Add(time.Minute))
+	wsl.trackState([]byte(ticker), stopped.Add(2*time.Minute))
+
+	require.Equal(t, uint64(1), acc.NMetrics())
+	acc.AssertContainsTaggedFields(t, "coinbase_restart_gap",
+		map[string]interface{}{
+			"outage_seconds":   60.0,
+			"missed_sequences": int64(99),
+			"missed_trades":    int64(9),
+			"last_trade_id":    int64(71476932),
+		},
+		map[string]string{"product_id": "ETH-USD"},
+	)
+}
+
+func TestStateFileMissingOrCorrupt(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	wsl, acc := newTestListener(t)
+	wsl.StateFile = stateFile
+	require.NoError(t, wsl.loadState())
+
+	require.NoError(t, ioutil.WriteFile(stateFile, []byte("{"), 0644))
+	require.Error(t, wsl.loadState())
+
+	// a product not found in the file reports no gap
+	wsl.trackState([]byte(proTicker), time.Now())
+	require.Equal(t, uint64(0), acc.NMetrics())
+	require.NoError(t, wsl.saveState())
+
+	data, err := ioutil.ReadFile(stateFile)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"ETH-USD":{"sequence":12238444095,"trade_id":71476932`)
+}
@@ -0,0 +1,43 @@
+package coinbase_marketdata
+
+import (
+	"github.com/influxdata/telegraf/internal/wsclient"
+	"github.com/influxdata/telegraf/selfstat"
+)
+
+// registerStats registers the internal statistics of the plugin, reported
+// by the internal input. The messages, bytes, reconnects and oversize
+// messages are counted by the clients of the connections instead.
+func (wsl *WebSocketListener) registerStats() {
+	tags := map[string]string{
+		"address": wsl.ServiceAddress,
+	}
+	wsl.parseErrors = selfstat.Register("coinbase_marketdata", "parse_errors", tags)
+	wsl.droppedMessages = selfstat.Register("coinbase_marketdata", "dropped_messages", tags)
+	wsl.timestampErrors = selfstat.Register("coinbase_marketdata", "timestamp_errors", tags)
+	wsl.duplicateTrades = selfstat.Register("coinbase_marketdata", "duplicate_trades", tags)
+	wsl.backfilledTrades = selfstat.Register("coinbase_marketdata", "backfilled_trades", tags)
+}
+
+// parseError reports a message which could not be turned into metrics
+func (wsl *WebSocketListener) parseError(err error) {
+	wsl.parseErrors.Incr(1)
+	wsl.AddError(err)
+}
+
+// clientStat returns a statistic counted by the clients of the connections,
+// which all count into the same one under the address tag, or 0 without a
+// client
+func (wsl *WebSocketListener) clientStat(stat func(*wsclient.Client) selfstat.Stat) int64 {
+	for _, c := range wsl.connections {
+		if c.client != nil {
+			return stat(c.client).Get()
+		}
+	}
+	return 0
+}
+
+func messagesReceived(c *wsclient.Client) selfstat.Stat { return c.MessagesReceived }
+func bytesReceived(c *wsclient.Client) selfstat.Stat    { return c.BytesReceived }
+func reconnects(c *wsclient.Client) selfstat.Stat       { return c.Reconnects }
+func oversizeMessages(c *wsclient.Client) selfstat.Stat { return c.OversizeMessages }
@@ -0,0 +1,34 @@
+package coinbase_marketdata
+
+import (
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	ts := newTestServer(t, func(conn *websocket.Conn) {
+		_, _, _ = conn.ReadMessage()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(proTicker))
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "ticker",`))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	wsl, acc := newTestListener(t)
+	wsl.ServiceAddress = wsURL(ts)
+	require.NoError(t, wsl.Init())
+	require.NoError(t, wsl.Start(acc))
+
+	acc.WaitError(1)
+	wsl.Stop()
+
+	require.Equal(t, int64(2), wsl.clientStat(messagesReceived))
+	require.Equal(t, int64(len(proTicker)+len(`{"type": "ticker",`)), wsl.clientStat(bytesReceived))
+	require.Equal(t, int64(1), wsl.parseErrors.Get())
+	require.Equal(t, int64(0), wsl.clientStat(reconnects))
+}
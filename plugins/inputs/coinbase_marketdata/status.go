@@ -0,0 +1,126 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+)
+
+// takes in a map of status data type in the format of
+// {
+//  "type": "status",
+//  "products": [
+//    {
+//      "id": "BTC-USD",
+//      "base_currency": "BTC",
+//      "quote_currency": "USD",
+//      "base_min_size": "0.001",
+//      "base_max_size": "70",
+//      "base_increment": "0.00000001",
+//      "quote_increment": "0.01",
+//      "display_name": "BTC/USD",
+//      "status": "online",
+//      "status_message": null,
+//      "min_market_funds": "10",
+//      "max_market_funds": "1000000",
+//      "post_only": false,
+//      "limit_only": false,
+//      "cancel_only": false,
+//      "trading_disabled": false
+//    }
+//  ],
+//  "currencies": [
+//    {
+//      "id": "USD",
+//      "name": "United States Dollar",
+//      "min_size": "0.01000000",
+//      "status": "online",
+//      "status_message": null,
+//      "max_precision": "0.01",
+//      "convertible_to": ["USDC"],
+//      "details": {}
+//    }
+//  ]
+// }
+// and returns one metric per product and currency. Products carry their
+// trading restrictions (post, limit and cancel only) and size limits, so that
+// gaps in prices can be correlated with them. The metrics are built directly
+// rather than through the parser, as their string and boolean fields would
+// otherwise be dropped.
+func (wsl *WebSocketListener) parseStatus(statusData map[string]interface{}) []telegraf.Metric {
+	var metrics []telegraf.Metric
+	now := time.Now()
+
+	products, _ := statusData["products"].([]interface{})
+	for _, p := range products {
+		product, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		fields := map[string]interface{}{
+			"status": fmt.Sprintf("%v", product["status"]),
+		}
+		if statusMessage, ok := product["status_message"].(string); ok {
+			fields["status_message"] = statusMessage
+		}
+		for _, key := range []string{"base_min_size", "base_max_size", "base_increment", "quote_increment", "min_market_funds", "max_market_funds"} {
+			if v := optionalFloat(product[key]); v != nil {
+				fields[key] = *v
+			}
+		}
+		for _, key := range []string{"post_only", "limit_only", "cancel_only", "trading_disabled"} {
+			if v, ok := product[key].(bool); ok {
+				fields[key] = v
+			}
+		}
+
+		tags := map[string]string{
+			"product_id": fmt.Sprintf("%v", product["id"]),
+		}
+		for _, key := range []string{"base_currency", "quote_currency"} {
+			if v, ok := product[key].(string); ok {
+				tags[key] = v
+			}
+		}
+
+		m, err := metric.New("coinbase_product_status", tags, fields, now)
+		if err != nil {
+			wsl.parseError(fmt.Errorf("unable to create product status metric: %s", err))
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+
+	currencies, _ := statusData["currencies"].([]interface{})
+	for _, c := range currencies {
+		currency, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		minSize, _ := parseFloat(currency["min_size"])
+		maxPrecision, _ := parseFloat(currency["max_precision"])
+
+		m, err := metric.New("coinbase_currency_status",
+			map[string]string{
+				"currency": fmt.Sprintf("%v", currency["id"]),
+			},
+			map[string]interface{}{
+				"status":        fmt.Sprintf("%v", currency["status"]),
+				"min_size":      minSize,
+				"max_precision": maxPrecision,
+			},
+			now,
+		)
+		if err != nil {
+			wsl.parseError(fmt.Errorf("unable to create currency status metric: %s", err))
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+
+	return metrics
+}
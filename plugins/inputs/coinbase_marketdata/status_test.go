@@ -0,0 +1,111 @@
+package coinbase_marketdata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const proStatus = `{
+  "type": "status",
+  "products": [
+    {
+      "id": "BTC-USD",
+      "base_currency": "BTC",
+      "quote_currency": "USD",
+      "base_min_size": "0.001",
+      "base_max_size": "70",
+      "base_increment": "0.00000001",
+      "quote_increment": "0.01",
+      "display_name": "BTC/USD",
+      "status": "online",
+      "status_message": null,
+      "min_market_funds": "10",
+      "max_market_funds": "1000000",
+      "post_only": false,
+      "limit_only": false,
+      "cancel_only": false,
+      "trading_disabled": false
+    },
+    {
+      "id": "XRP-USD",
+      "base_currency": "XRP",
+      "quote_currency": "USD",
+      "base_min_size": "1",
+      "base_max_size": "500000",
+      "base_increment": "0.000001",
+      "quote_increment": "0.0001",
+      "display_name": "XRP/USD",
+      "status": "delisted",
+      "status_message": "Trading halted",
+      "min_market_funds": "10",
+      "max_market_funds": "100000",
+      "post_only": false,
+      "limit_only": false,
+      "cancel_only": true,
+      "trading_disabled": true
+    }
+  ],
+  "currencies": [
+    {
+      "id": "USD",
+      "name": "United States Dollar",
+      "min_size": "0.01000000",
+      "status": "online",
+      "status_message": null,
+      "max_precision": "0.01",
+      "convertible_to": ["USDC"],
+      "details": {}
+    }
+  ]
+}`
+
+func TestStatusChannel(t *testing.T) {
+	wsl, acc := newTestListener(t)
+
+	wsl.addMetric([]byte(proStatus))
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, uint64(3), acc.NMetrics())
+
+	acc.AssertContainsTaggedFields(t, "coinbase_product_status",
+		map[string]interface{}{
+			"status":           "online",
+			"base_min_size":    0.001,
+			"base_max_size":    70.0,
+			"base_increment":   0.00000001,
+			"quote_increment":  0.01,
+			"min_market_funds": 10.0,
+			"max_market_funds": 1000000.0,
+			"post_only":        false,
+			"limit_only":       false,
+			"cancel_only":      false,
+			"trading_disabled": false,
+		},
+		map[string]string{"product_id": "BTC-USD", "base_currency": "BTC", "quote_currency": "USD"},
+	)
+	acc.AssertContainsTaggedFields(t, "coinbase_product_status",
+		map[string]interface{}{
+			"status":           "delisted",
+			"status_message":   "Trading halted",
+			"base_min_size":    1.0,
+			"base_max_size":    500000.0,
+			"base_increment":   0.000001,
+			"quote_increment":  0.0001,
+			"min_market_funds": 10.0,
+			"max_market_funds": 100000.0,
+			"post_only":        false,
+			"limit_only":       false,
+			"cancel_only":      true,
+			"trading_disabled": true,
+		},
+		map[string]string{"product_id": "XRP-USD", "base_currency": "XRP", "quote_currency": "USD"},
+	)
+	acc.AssertContainsTaggedFields(t, "coinbase_currency_status",
+		map[string]interface{}{
+			"status":        "online",
+			"min_size":      0.01,
+			"max_precision": 0.01,
+		},
+		map[string]string{"currency": "USD"},
+	)
+}
@@ -0,0 +1,139 @@
+package coinbase_marketdata
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/tidwall/gjson"
+)
+
+// subscribeMsg is the subscribe message of the pro feed, which subscribes to
+// several channels at once
+type subscribeMsg struct {
+	Type       string   `json:"type"`
+	ProductIds []string `json:"product_ids"`
+	Channels   []string `json:"channels"`
+}
+
+// advancedSubscribeMsg is the subscribe message of the advanced trade feed,
+// which subscribes to a single channel
+type advancedSubscribeMsg struct {
+	Type       string   `json:"type"`
+	ProductIds []string `json:"product_ids"`
+	Channel    string   `json:"channel"`
+}
+
+// subscriptionMessages returns the messages sent upon connecting,
+// on_connect_msg if set, otherwise built from product_ids and channels in
+// the schema of the feed
+func (wsl *WebSocketListener) subscriptionMessages() ([][]byte, error) {
+	return wsl.subscriptionMessagesFor(nil)
+}
+
+// subscriptionMessagesFor returns the subscription messages of a shard of
+// product_ids, all of them when productIds is nil
+func (wsl *WebSocketListener) subscriptionMessagesFor(productIds []string) ([][]byte, error) {
+	if productIds == nil {
+		productIds = wsl.ProductIds
+	}
+
+	if wsl.OnConnectMsg != "" || len(productIds) == 0 {
+		return [][]byte{[]byte(wsl.OnConnectMsg)}, nil
+	}
+
+	if !wsl.advancedFeed() {
+		msg, err := json.Marshal(subscribeMsg{
+			Type:       "subscribe",
+			ProductIds: productIds,
+			Channels:   wsl.Channels,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{msg}, nil
+	}
+
+	var msgs [][]byte
+	for _, channel := range wsl.Channels {
+		msg, err := json.Marshal(advancedSubscribeMsg{
+			Type:       "subscribe",
+			ProductIds: productIds,
+			Channel:    channel,
+		})
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// subscribedProducts returns the number of distinct products subscribed to
+// by the subscription messages, including the products of the channel
+// objects of an on_connect_msg
+func (wsl *WebSocketListener) subscribedProducts() int {
+	msgs, err := wsl.subscriptionMessages()
+	if err != nil {
+		return 0
+	}
+
+	products := make(map[string]bool)
+	for _, msg := range msgs {
+		for _, path := range []string{"product_ids", "channels.#.product_ids|@flatten"} {
+			for _, productId := range gjson.GetBytes(msg, path).Array() {
+				products[productId.String()] = true
+			}
+		}
+	}
+	return len(products)
+}
+
+// subscribesMatches reports whether the subscription messages, including an
+// on_connect_msg, subscribe to the match messages of the pro feed, sent on
+// the matches and full channels
+func (wsl *WebSocketListener) subscribesMatches() bool {
+	msgs, err := wsl.subscriptionMessages()
+	if err != nil {
+		return false
+	}
+
+	for _, msg := range msgs {
+		for _, channel := range gjson.GetBytes(msg, "channels").Array() {
+			name := channel.String()
+			if channel.IsObject() {
+				name = channel.Get("name").String()
+			}
+			if name == "matches" || name == "full" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateSubscription checks that product_ids and channels, and the api
+// credentials, are set together
+func (wsl *WebSocketListener) validateSubscription() error {
+	if wsl.OnConnectMsg != "" {
+		if wsl.MaxProductsPerConnection > 0 {
+			return fmt.Errorf("max_products_per_connection can not shard an on_connect_msg")
+		}
+		return nil
+	}
+	if wsl.MaxProductsPerConnection < 0 {
+		return fmt.Errorf("invalid max_products_per_connection %d, must not be negative", wsl.MaxProductsPerConnection)
+	}
+	if len(wsl.ProductIds) > 0 && len(wsl.Channels) == 0 {
+		return fmt.Errorf("channels must be set along with product_ids")
+	}
+	if len(wsl.Channels) > 0 && len(wsl.ProductIds) == 0 {
+		return fmt.Errorf("product_ids must be set along with channels")
+	}
+	if wsl.APIKey != "" && wsl.APISecret == "" {
+		return fmt.Errorf("api_secret must be set along with api_key")
+	}
+	if wsl.APIKey != "" && wsl.APIPassphrase == "" && !wsl.advancedFeed() {
+		return fmt.Errorf("api_passphrase must be set along with api_key")
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package coinbase_marketdata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptionMessages(t *testing.T) {
+	wsl, _ := newTestListener(t)
+	wsl.ProductIds = []string{"ETH-USD", "BTC-USD"}
+	wsl.Channels = []string{"level2", "ticker"}
+	require.NoError(t, wsl.Init())
+
+	msgs, err := wsl.subscriptionMessages()
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.JSONEq(t, `{"type": "subscribe", "product_ids": ["ETH-USD", "BTC-USD"], "channels": ["level2", "ticker"]}`, string(msgs[0]))
+
+	wsl.APIVersion = apiVersionAdvanced
+	msgs, err = wsl.subscriptionMessages()
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	require.JSONEq(t, `{"type": "subscribe", "product_ids": ["ETH-USD", "BTC-USD"], "channel": "level2"}`, string(msgs[0]))
+	require.JSONEq(t, `{"type": "subscribe", "product_ids": ["ETH-USD", "BTC-USD"], "channel": "ticker"}`, string(msgs[1]))
+
+	wsl.OnConnectMsg = `{"type": "subscribe"}`
+	msgs, err = wsl.subscriptionMessages()
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte(wsl.OnConnectMsg)}, msgs)
+}
+
+func TestSubscriptionRequiresProductsAndChannels(t *testing.T) {
+	wsl, _ := newTestListener(t)
+	wsl.ProductIds = []string{"ETH-USD"}
+	require.Error(t, wsl.Init())
+
+	wsl.ProductIds = nil
+	wsl.Channels = []string{"ticker"}
+	require.Error(t, wsl.Init())
+}
@@ -0,0 +1,85 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// AddFields adds the fields of one of the plugin's own measurements,
+// normalized to metric_schema and tagged with extra_tags and the currencies
+// of its product
+func (wsl *WebSocketListener) AddFields(measurement string, fields map[string]interface{}, tags map[string]string, t ...time.Time) {
+	if wsl.normalizer != nil {
+		measurement, fields, tags = wsl.normalizer.Normalize(measurement, fields, tags)
+	}
+	if len(wsl.ExtraTags) > 0 || wsl.CurrencyTags {
+		tags = wsl.withExtraTags(tags)
+	}
+	wsl.Accumulator.AddFields(measurement, fields, tags, t...)
+}
+
+// AddMetric adds a metric parsed from a message, normalized to
+// metric_schema and tagged with extra_tags and the currencies of its product
+func (wsl *WebSocketListener) AddMetric(m telegraf.Metric) {
+	if wsl.normalizer != nil {
+		m = wsl.normalizer.NormalizeMetric(m)
+	}
+	if wsl.CurrencyTags {
+		productId, _ := m.GetTag("product_id")
+		for key, value := range currencyTags(productId) {
+			if !m.HasTag(key) {
+				m.AddTag(key, value)
+			}
+		}
+	}
+	for key, value := range wsl.ExtraTags {
+		if !m.HasTag(key) {
+			m.AddTag(key, value)
+		}
+	}
+	wsl.Accumulator.AddMetric(m)
+}
+
+// withExtraTags returns a copy of tags with extra_tags and the currency tags
+// added, not replacing the tags of the measurement itself
+func (wsl *WebSocketListener) withExtraTags(tags map[string]string) map[string]string {
+	merged := make(map[string]string, len(tags)+len(wsl.ExtraTags)+2)
+	for key, value := range wsl.ExtraTags {
+		merged[key] = value
+	}
+	if wsl.CurrencyTags {
+		for key, value := range currencyTags(tags["product_id"]) {
+			merged[key] = value
+		}
+	}
+	for key, value := range tags {
+		merged[key] = value
+	}
+	return merged
+}
+
+// currencyTags splits a product id such as "ETH-USD" into its base_currency
+// ETH and its quote_currency USD, returning nil for anything else
+func currencyTags(productId string) map[string]string {
+	i := strings.Index(productId, "-")
+	if i <= 0 || i == len(productId)-1 {
+		return nil
+	}
+	return map[string]string{
+		"base_currency":  productId[:i],
+		"quote_currency": productId[i+1:],
+	}
+}
+
+// validateExtraTags checks that every extra_tags key is named
+func (wsl *WebSocketListener) validateExtraTags() error {
+	for key := range wsl.ExtraTags {
+		if key == "" {
+			return fmt.Errorf("empty extra_tags key")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// throttledTicker is the ticker_min_interval state of a product: when its
+// last ticker was emitted, and the latest ticker held back since
+type throttledTicker struct {
+	emitted time.Time
+	pending telegraf.Metric
+}
+
+// throttleTicker decides whether the ticker metric m is emitted right away.
+// With ticker_min_interval a product emits at most one ticker per interval;
+// a ticker received sooner replaces the one held back, so that the latest
+// ticker is emitted by flushTickers once the interval has passed.
+func (wsl *WebSocketListener) throttleTicker(m telegraf.Metric, now time.Time) bool {
+	productId, _ := m.GetTag("product_id")
+
+	wsl.throttleMutex.Lock()
+	defer wsl.throttleMutex.Unlock()
+
+	t, ok := wsl.throttled[productId]
+	if !ok {
+		t = &throttledTicker{}
+		wsl.throttled[productId] = t
+	}
+
+	if t.pending == nil && now.Sub(t.emitted) >= wsl.TickerMinInterval.Duration {
+		t.emitted = now
+		return true
+	}
+	t.pending = m
+	return false
+}
+
+// flushTickers emits the tickers held back whose product's interval has
+// passed by now, or with all every ticker held back
+func (wsl *WebSocketListener) flushTickers(now time.Time, all bool) {
+	var flushed []telegraf.Metric
+
+	wsl.throttleMutex.Lock()
+	for _, t := range wsl.throttled {
+		if t.pending == nil || (!all && now.Sub(t.emitted) < wsl.TickerMinInterval.Duration) {
+			continue
+		}
+		flushed = append(flushed, t.pending)
+		t.pending = nil
+		t.emitted = now
+	}
+	wsl.throttleMutex.Unlock()
+
+	for _, m := range flushed {
+		wsl.AddMetric(m)
+	}
+}
+
+// runTickerFlush flushes the tickers held back every ticker_min_interval
+// until the plugin stops
+func (wsl *WebSocketListener) runTickerFlush() {
+	ticker := time.NewTicker(wsl.TickerMinInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-wsl.ctx.Done():
+			return
+		case now := <-ticker.C:
+			wsl.flushTickers(now, false)
+		}
+	}
+}
+
+func validateTickerMinInterval(interval time.Duration) error {
+	if interval < 0 {
+		return fmt.Errorf("invalid ticker_min_interval %s, must not be negative", interval)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package coinbase_marketdata
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func tickerAt(price string) []byte {
+	return []byte(strings.Replace(proTicker, `"price": "731.99"`, `"price": "`+price+`"`, 1))
+}
+
+// tickerPrices returns the prices of the ticker metrics emitted
+func tickerPrices(acc *testutil.Accumulator) []interface{} {
+	var prices []interface{}
+	for _, m := range acc.GetTelegrafMetrics() {
+		if m.Name() == "ticker" {
+			prices = append(prices, m.Fields()["price"])
+		}
+	}
+	return prices
+}
+
+func TestTickerMinIntervalKeepsLatest(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.TickerMinInterval = internal.Duration{Duration: time.Hour}
+	require.NoError(t, wsl.Init())
+
+	wsl.addMetric(tickerAt("100"))
+	wsl.addMetric(tickerAt("101"))
+	wsl.addMetric(tickerAt("102"))
+	wsl.addMetric([]byte(proL2Update))
+	require.NoError(t, acc.FirstError())
+
+	// the first ticker is emitted, the later ones are held back
+	require.Equal(t, []interface{}{100.0}, tickerPrices(acc))
+	require.True(t, acc.HasMeasurement("l2update"))
+
+	wsl.flushTickers(time.Now(), false)
+	require.Equal(t, []interface{}{100.0}, tickerPrices(acc))
+
+	wsl.flushTickers(time.Now().Add(time.Hour), false)
+	require.Equal(t, []interface{}{100.0, 102.0}, tickerPrices(acc))
+
+	wsl.TickerMinInterval = internal.Duration{Duration: -time.Second}
+	require.Error(t, wsl.Init())
+}
+
+func TestTickerMinIntervalFlushedOnStop(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.TickerMinInterval = internal.Duration{Duration: time.Hour}
+	wsl.ServiceAddress = newAckServer(t, proSubscriptionsAck, make(chan struct{}, 10))
+	require.NoError(t, wsl.Init())
+	require.NoError(t, wsl.Start(acc))
+
+	wsl.addMetric(tickerAt("100"))
+	wsl.addMetric(tickerAt("101"))
+	wsl.Stop()
+
+	require.Equal(t, []interface{}{100.0, 101.0}, tickerPrices(acc))
+}
@@ -0,0 +1,45 @@
+package coinbase_marketdata
+
+import (
+	"time"
+)
+
+// timeLayouts are the layouts the event times of the feed are parsed with.
+// RFC3339Nano accepts any number of fractional digits, or none, as the width
+// varies from message to message.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	// without a zone, taken as UTC
+	"2006-01-02T15:04:05.999999999",
+}
+
+// parseTime parses an event time of the feed, keeping nanoseconds
+func parseTime(value string) (time.Time, error) {
+	var err error
+	for _, layout := range timeLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// eventTime returns the event time v of a message received at received. A
+// message without a time is timestamped when it was received, and so is one
+// with a malformed time, which is counted in timestamp_errors rather than
+// dropping the metric.
+func (wsl *WebSocketListener) eventTime(v interface{}, received time.Time) time.Time {
+	value, _ := v.(string)
+	if value == "" || value == "<nil>" {
+		return received
+	}
+
+	t, err := parseTime(value)
+	if err != nil {
+		wsl.timestampErrors.Incr(1)
+		wsl.Log.Debugf("Invalid event time %q, using the time received: %s", value, err)
+		return received
+	}
+	return t
+}
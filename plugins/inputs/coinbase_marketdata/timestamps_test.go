@@ -0,0 +1,42 @@
+package coinbase_marketdata
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTimeOfAnyFractionWidth(t *testing.T) {
+	for value, expected := range map[string]time.Time{
+		"2020-12-28T23:54:32Z":                time.Date(2020, 12, 28, 23, 54, 32, 0, time.UTC),
+		"2020-12-28T23:54:32.05Z":             time.Date(2020, 12, 28, 23, 54, 32, 50000000, time.UTC),
+		"2020-12-28T23:54:32.051347Z":         time.Date(2020, 12, 28, 23, 54, 32, 51347000, time.UTC),
+		"2023-02-09T20:32:50.714964855Z":      time.Date(2023, 2, 9, 20, 32, 50, 714964855, time.UTC),
+		"2023-02-09T20:32:50.714964855":       time.Date(2023, 2, 9, 20, 32, 50, 714964855, time.UTC),
+		"2023-02-09T21:32:50.714964855+01:00": time.Date(2023, 2, 9, 20, 32, 50, 714964855, time.UTC),
+	} {
+		ts, err := parseTime(value)
+		require.NoError(t, err, value)
+		require.True(t, expected.Equal(ts), "%s parsed as %s", value, ts)
+	}
+
+	_, err := parseTime("28/12/2020 23:54:32")
+	require.Error(t, err)
+}
+
+func TestMalformedTimeFallsBackToReceived(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	require.NoError(t, wsl.Init())
+	errors := wsl.timestampErrors.Get()
+
+	received := time.Date(2021, 1, 2, 3, 4, 5, 6, time.UTC)
+	wsl.addReceived([]byte(strings.Replace(proTicker, "2020-12-28T23:54:32.051347Z", "yesterday", 1)), received)
+	require.NoError(t, acc.FirstError())
+
+	m, ok := acc.Get("ticker")
+	require.True(t, ok)
+	require.Equal(t, received, m.Time)
+	require.Equal(t, errors+1, wsl.timestampErrors.Get())
+}
@@ -0,0 +1,115 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+	"hash/fnv"
+	"runtime"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// defaultQueueSize is the number of messages buffered per worker before
+	// the queue_full policy applies
+	defaultQueueSize = 1024
+
+	queueFullBlock = "block"
+	queueFullDrop  = "drop"
+)
+
+// inbound is a received message along with the time it was received
+type inbound struct {
+	data     []byte
+	received time.Time
+}
+
+// routingKey returns the product a message belongs to, for both legacy
+// messages and advanced trade envelopes, falling back to its type for
+// messages without a product such as subscriptions
+func routingKey(message []byte) string {
+	if productId, ok := productOf(message); ok {
+		return productId
+	}
+	if r := gjson.GetBytes(message, "type"); r.Exists() {
+		return r.String()
+	}
+	return gjson.GetBytes(message, "channel").String()
+}
+
+// startWorkers starts parse_workers workers parsing the received messages.
+// Every product is routed to the same worker, so the messages of a product
+// are parsed in the order they were received. With ordered a single worker
+// parses every message in the order received.
+func (wsl *WebSocketListener) startWorkers() {
+	workers := wsl.ParseWorkers
+	if wsl.Ordered {
+		workers = 1
+	} else if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	wsl.queues = make([]chan inbound, workers)
+	for i := range wsl.queues {
+		queue := make(chan inbound, wsl.QueueSize)
+		wsl.queues[i] = queue
+
+		wsl.workersWg.Add(1)
+		go func() {
+			defer wsl.workersWg.Done()
+			for message := range queue {
+				wsl.addReceived(message.data, message.received)
+			}
+		}()
+	}
+}
+
+// dispatch hands a message to the worker of its product. When the worker's
+// queue is full the read loop either waits, applying backpressure to the
+// connection, or with queue_full = "drop" discards the message.
+func (wsl *WebSocketListener) dispatch(message []byte, received time.Time) {
+	h := fnv.New32a()
+	h.Write([]byte(routingKey(message)))
+	queue := wsl.queues[h.Sum32()%uint32(len(wsl.queues))]
+
+	if wsl.QueueFull != queueFullDrop {
+		queue <- inbound{message, received}
+		return
+	}
+
+	select {
+	case queue <- inbound{message, received}:
+	default:
+		wsl.droppedMessages.Incr(1)
+	}
+}
+
+// reportDropped warns about the messages dropped since the last report
+func (wsl *WebSocketListener) reportDropped() {
+	dropped := wsl.droppedMessages.Get()
+	if dropped > wsl.reportedDropped {
+		wsl.Log.Warnf("Dropped %d messages as the parse queues were full", dropped-wsl.reportedDropped)
+	}
+	wsl.reportedDropped = dropped
+}
+
+func validateQueue(size int, full string) error {
+	if size <= 0 {
+		return fmt.Errorf("invalid queue_size %d, must be positive", size)
+	}
+	switch full {
+	case "", queueFullBlock, queueFullDrop:
+		return nil
+	}
+	return fmt.Errorf("invalid queue_full %q, must be %q or %q", full, queueFullBlock, queueFullDrop)
+}
+
+// stopWorkers waits for the workers to parse the messages already queued.
+// The read loops must have returned, as they dispatch to the queues.
+func (wsl *WebSocketListener) stopWorkers() {
+	for _, queue := range wsl.queues {
+		close(queue)
+	}
+	wsl.workersWg.Wait()
+	wsl.queues = nil
+}
@@ -0,0 +1,128 @@
+package coinbase_marketdata
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// tickerOf returns a ticker of product with the given sequence number
+func tickerOf(product string, sequence int) []byte {
+	return []byte(strings.NewReplacer(
+		`"ETH-USD"`, fmt.Sprintf("%q", product),
+		"12238444095", fmt.Sprint(sequence),
+	).Replace(proTicker))
+}
+
+func TestRoutingKey(t *testing.T) {
+	require.Equal(t, "ETH-USD", routingKey([]byte(proTicker)))
+	require.Equal(t, "BTC-USD", routingKey([]byte(advancedTicker)))
+	require.Equal(t, "heartbeat", routingKey([]byte(`{"type": "heartbeat"}`)))
+}
+
+func TestWorkersPreserveProductOrder(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.ParseWorkers = 4
+	wsl.startWorkers()
+
+	products := []string{"BTC-USD", "ETH-USD", "LTC-USD", "DOGE-USD"}
+	for sequence := 1; sequence <= 100; sequence++ {
+		for _, product := range products {
+			wsl.dispatch(tickerOf(product, sequence), time.Now())
+		}
+	}
+	wsl.stopWorkers()
+	require.NoError(t, acc.FirstError())
+
+	last := make(map[string]float64)
+	for _, m := range acc.GetTelegrafMetrics() {
+		product := m.Tags()["product_id"]
+		sequence := m.Fields()["sequence_id"].(float64)
+		require.Greater(t, sequence, last[product], product)
+		last[product] = sequence
+	}
+	for _, product := range products {
+		require.Equal(t, 100.0, last[product])
+	}
+}
+
+func BenchmarkParseWorkers(b *testing.B) {
+	messages := make([][]byte, 64)
+	for i := range messages {
+		messages[i] = tickerOf(fmt.Sprintf("P%d-USD", i%16), i)
+	}
+
+	for _, workers := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			wsl, _ := newTestListener(b)
+			wsl.Accumulator = &testutil.NopAccumulator{}
+			wsl.ParseWorkers = workers
+			wsl.startWorkers()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				wsl.dispatch(messages[i%len(messages)], time.Now())
+			}
+			wsl.stopWorkers()
+		})
+	}
+}
+
+func TestQueueFullDrop(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.ParseWorkers = 1
+	wsl.QueueSize = 2
+	wsl.QueueFull = queueFullDrop
+	require.NoError(t, wsl.Init())
+
+	// no workers consuming, so the queue fills up
+	dropped := wsl.droppedMessages.Get()
+	wsl.queues = []chan inbound{make(chan inbound, wsl.QueueSize)}
+	for sequence := 1; sequence <= 5; sequence++ {
+		wsl.dispatch(tickerOf("BTC-USD", sequence), time.Now())
+	}
+	require.Equal(t, dropped+3, wsl.droppedMessages.Get())
+
+	wsl.reportDropped()
+	require.Equal(t, dropped+3, wsl.reportedDropped)
+	require.Equal(t, 2, len(wsl.queues[0]))
+	require.Empty(t, acc.GetTelegrafMetrics())
+}
+
+func TestQueueValidation(t *testing.T) {
+	wsl, _ := newTestListener(t)
+	wsl.QueueFull = "spill"
+	require.Error(t, wsl.Init())
+
+	wsl.QueueFull = queueFullBlock
+	wsl.QueueSize = 0
+	require.Error(t, wsl.Init())
+}
+
+func TestOrderedPreservesArrivalOrder(t *testing.T) {
+	wsl, acc := newTestListener(t)
+	wsl.Ordered = true
+	require.NoError(t, wsl.Init())
+	wsl.startWorkers()
+	require.Equal(t, 1, len(wsl.queues))
+
+	products := []string{"BTC-USD", "ETH-USD", "LTC-USD", "DOGE-USD"}
+	for sequence := 1; sequence <= 100; sequence++ {
+		wsl.dispatch(tickerOf(products[sequence%len(products)], sequence), time.Now())
+	}
+	wsl.stopWorkers()
+	require.NoError(t, acc.FirstError())
+
+	metrics := acc.GetTelegrafMetrics()
+	require.Equal(t, 100, len(metrics))
+	for i, m := range metrics {
+		require.Equal(t, float64(i+1), m.Fields()["sequence_id"])
+	}
+
+	wsl.ParseWorkers = 4
+	require.Error(t, wsl.Init())
+}
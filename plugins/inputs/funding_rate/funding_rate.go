@@ -0,0 +1,332 @@
+package funding_rate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/common/tls"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const (
+	venueBinance = "binance"
+	venueOKX     = "okx"
+	venueBybit   = "bybit"
+
+	defaultTimeout = 10 * time.Second
+)
+
+// the REST api of each venue
+var restAddresses = map[string]string{
+	venueBinance: "https://fapi.binance.com",
+	venueOKX:     "https://www.okx.com",
+	venueBybit:   "https://api.bybit.com",
+}
+
+// funding is the funding of a perpetual swap, the fields missing from the
+// response of a venue left nil
+type funding struct {
+	rate            float64
+	predictedRate   *float64
+	markPrice       *float64
+	indexPrice      *float64
+	nextFundingTime *time.Time
+}
+
+type FundingRate struct {
+	Instruments   map[string][]string `toml:"instruments"`
+	RestAddresses map[string]string   `toml:"rest_addresses"`
+	Timeout       internal.Duration   `toml:"timeout"`
+	tls.ClientConfig
+
+	Log telegraf.Logger `toml:"-"`
+
+	client *http.Client
+	now    func() time.Time
+}
+
+func (f *FundingRate) SampleConfig() string {
+	return `
+## Timeout of a request
+# timeout = "10s"
+
+## Optional TLS Config
+# tls_ca = "/etc/telegraf/ca.pem"
+# tls_cert = "/etc/telegraf/cert.pem"
+# tls_key = "/etc/telegraf/key.pem"
+## Use TLS but skip chain & host verification
+# insecure_skip_verify = false
+
+## The perpetual swaps to collect the funding of, by venue: "binance" (the
+## USD-M futures), "okx" or "bybit" (the linear contracts)
+[inputs.funding_rate.instruments]
+  binance = ["BTCUSDT"]
+  # okx = ["BTC-USDT-SWAP"]
+  # bybit = ["BTCUSDT"]
+
+## The REST api of a venue, defaults to its public api
+# [inputs.funding_rate.rest_addresses]
+#   binance = "https://fapi.binance.com"
+`
+}
+
+func (f *FundingRate) Description() string {
+	return "Reads the funding rates of perpetual swaps from the REST api of derivatives venues"
+}
+
+func (f *FundingRate) Init() error {
+	if len(f.Instruments) == 0 {
+		return fmt.Errorf("instruments must be set")
+	}
+	for venue := range f.Instruments {
+		if _, ok := restAddresses[venue]; !ok {
+			return fmt.Errorf("invalid venue %q, must be %q, %q or %q", venue, venueBinance, venueOKX, venueBybit)
+		}
+	}
+	for venue := range f.RestAddresses {
+		if _, ok := restAddresses[venue]; !ok {
+			return fmt.Errorf("invalid venue %q of rest_addresses, must be %q, %q or %q", venue, venueBinance, venueOKX, venueBybit)
+		}
+	}
+
+	tlsCfg, err := f.ClientConfig.TLSConfig()
+	if err != nil {
+		return err
+	}
+	f.client = &http.Client{
+		Timeout: f.Timeout.Duration,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsCfg,
+		},
+	}
+	return nil
+}
+
+func (f *FundingRate) Gather(acc telegraf.Accumulator) error {
+	now := f.now()
+
+	venues := make([]string, 0, len(f.Instruments))
+	for venue := range f.Instruments {
+		venues = append(venues, venue)
+	}
+	sort.Strings(venues)
+
+	for _, venue := range venues {
+		for _, instrument := range f.Instruments[venue] {
+			fund, err := f.fetch(venue, instrument)
+			if err != nil {
+				acc.AddError(fmt.Errorf("unable to fetch the funding of %s on %s: %s", instrument, venue, err))
+				continue
+			}
+
+			fields := map[string]interface{}{
+				"funding_rate": fund.rate,
+			}
+			if fund.predictedRate != nil {
+				fields["predicted_funding_rate"] = *fund.predictedRate
+			}
+			if fund.markPrice != nil {
+				fields["mark_price"] = *fund.markPrice
+			}
+			if fund.indexPrice != nil {
+				fields["index_price"] = *fund.indexPrice
+			}
+			if fund.nextFundingTime != nil {
+				fields["next_funding_time"] = fund.nextFundingTime.UnixNano() / int64(time.Millisecond)
+			}
+			acc.AddFields("funding_rate", fields,
+				map[string]string{
+					"exchange":   venue,
+					"instrument": instrument,
+				},
+				now,
+			)
+		}
+	}
+	return nil
+}
+
+func (f *FundingRate) restAddress(venue string) string {
+	if addr, ok := f.RestAddresses[venue]; ok {
+		return addr
+	}
+	return restAddresses[venue]
+}
+
+func (f *FundingRate) fetch(venue string, instrument string) (*funding, error) {
+	switch venue {
+	case venueBinance:
+		return f.fetchBinance(instrument)
+	case venueOKX:
+		return f.fetchOKX(instrument)
+	default:
+		return f.fetchBybit(instrument)
+	}
+}
+
+// fetchBinance reads the premium index of a USD-M perpetual, whose last
+// funding rate is the rate of the next funding, updated in real time
+//
+// {"symbol": "BTCUSDT", "markPrice": "11793.63104562", "indexPrice": "11781.80495970",
+//  "lastFundingRate": "0.00038246", "nextFundingTime": 1597392000000, "time": 1597370495002, ...}
+func (f *FundingRate) fetchBinance(symbol string) (*funding, error) {
+	var index struct {
+		MarkPrice       string `json:"markPrice"`
+		IndexPrice      string `json:"indexPrice"`
+		LastFundingRate string `json:"lastFundingRate"`
+		NextFundingTime int64  `json:"nextFundingTime"`
+	}
+	query := url.Values{"symbol": []string{symbol}}
+	if err := f.get(f.restAddress(venueBinance)+"/fapi/v1/premiumIndex?"+query.Encode(), &index); err != nil {
+		return nil, err
+	}
+
+	rate, err := strconv.ParseFloat(index.LastFundingRate, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid funding rate %q", index.LastFundingRate)
+	}
+	return &funding{
+		rate:            rate,
+		markPrice:       optionalFloat(index.MarkPrice),
+		indexPrice:      optionalFloat(index.IndexPrice),
+		nextFundingTime: optionalTime(index.NextFundingTime),
+	}, nil
+}
+
+// fetchOKX reads the funding rate of a perpetual swap, the rate of the
+// current period and the predicted rate of the next one
+//
+// {"code": "0", "msg": "", "data": [{"instId": "BTC-USDT-SWAP", "fundingRate": "0.0001",
+//  "nextFundingRate": "0.00012", "fundingTime": "1703088000000", ...}]}
+func (f *FundingRate) fetchOKX(instId string) (*funding, error) {
+	var resp struct {
+		Code string `json:"code"`
+		Msg  string `json:"msg"`
+		Data []struct {
+			FundingRate     string `json:"fundingRate"`
+			NextFundingRate string `json:"nextFundingRate"`
+			FundingTime     string `json:"fundingTime"`
+		} `json:"data"`
+	}
+	query := url.Values{"instId": []string{instId}}
+	if err := f.get(f.restAddress(venueOKX)+"/api/v5/public/funding-rate?"+query.Encode(), &resp); err != nil {
+		return nil, err
+	}
+	if resp.Code != "0" {
+		return nil, fmt.Errorf("error %s: %s", resp.Code, resp.Msg)
+	}
+	if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("no funding rate")
+	}
+
+	data := resp.Data[0]
+	rate, err := strconv.ParseFloat(data.FundingRate, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid funding rate %q", data.FundingRate)
+	}
+	fundingTime, _ := strconv.ParseInt(data.FundingTime, 10, 64)
+	return &funding{
+		rate: rate,
+		// empty once the venue stopped predicting the next rate
+		predictedRate:   optionalFloat(data.NextFundingRate),
+		nextFundingTime: optionalTime(fundingTime),
+	}, nil
+}
+
+// fetchBybit reads the ticker of a linear perpetual, whose funding rate is
+// the rate of the next funding
+//
+// {"retCode": 0, "retMsg": "OK", "result": {"category": "linear", "list": [{"symbol": "BTCUSDT",
+//  "markPrice": "16659.56", "indexPrice": "16666.65", "fundingRate": "0.0001", "nextFundingTime": "1672041600000", ...}]}}
+func (f *FundingRate) fetchBybit(symbol string) (*funding, error) {
+	var resp struct {
+		RetCode int    `json:"retCode"`
+		RetMsg  string `json:"retMsg"`
+		Result  struct {
+			List []struct {
+				MarkPrice       string `json:"markPrice"`
+				IndexPrice      string `json:"indexPrice"`
+				FundingRate     string `json:"fundingRate"`
+				NextFundingTime string `json:"nextFundingTime"`
+			} `json:"list"`
+		} `json:"result"`
+	}
+	query := url.Values{"category": []string{"linear"}, "symbol": []string{symbol}}
+	if err := f.get(f.restAddress(venueBybit)+"/v5/market/tickers?"+query.Encode(), &resp); err != nil {
+		return nil, err
+	}
+	if resp.RetCode != 0 {
+		return nil, fmt.Errorf("error %d: %s", resp.RetCode, resp.RetMsg)
+	}
+	if len(resp.Result.List) == 0 {
+		return nil, fmt.Errorf("no ticker")
+	}
+
+	ticker := resp.Result.List[0]
+	rate, err := strconv.ParseFloat(ticker.FundingRate, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid funding rate %q", ticker.FundingRate)
+	}
+	nextFundingTime, _ := strconv.ParseInt(ticker.NextFundingTime, 10, 64)
+	return &funding{
+		rate:            rate,
+		markPrice:       optionalFloat(ticker.MarkPrice),
+		indexPrice:      optionalFloat(ticker.IndexPrice),
+		nextFundingTime: optionalTime(nextFundingTime),
+	}, nil
+}
+
+// get decodes the json response of addr into v
+func (f *FundingRate) get(addr string, v interface{}) error {
+	resp, err := f.client.Get(addr)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: %s", addr, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding %s: %s", addr, err)
+	}
+	return nil
+}
+
+// optionalFloat parses a decimal a venue may leave empty, nil when empty or
+// malformed
+func optionalFloat(s string) *float64 {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+// optionalTime converts a time in unix milliseconds, nil when zero
+func optionalTime(ms int64) *time.Time {
+	if ms <= 0 {
+		return nil
+	}
+	t := time.Unix(0, ms*int64(time.Millisecond)).UTC()
+	return &t
+}
+
+func newFundingRate() *FundingRate {
+	return &FundingRate{
+		Timeout: internal.Duration{Duration: defaultTimeout},
+		now:     time.Now,
+	}
+}
+
+func init() {
+	inputs.Add("funding_rate", func() telegraf.Input { return newFundingRate() })
+}
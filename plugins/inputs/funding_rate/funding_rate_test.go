@@ -0,0 +1,166 @@
+package funding_rate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFundingRate(addr string, instruments map[string][]string) *FundingRate {
+	f := newFundingRate()
+	f.Instruments = instruments
+	f.RestAddresses = map[string]string{
+		venueBinance: addr,
+		venueOKX:     addr,
+		venueBybit:   addr,
+	}
+	f.Log = testutil.Logger{}
+	f.now = func() time.Time { return time.Unix(1700000000, 0) }
+	return f
+}
+
+func TestGather(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fapi/v1/premiumIndex":
+			require.Equal(t, "BTCUSDT", r.URL.Query().Get("symbol"))
+			w.Write([]byte(`{"symbol": "BTCUSDT", "markPrice": "37000.5", "indexPrice": "36990.25",
+				"estimatedSettlePrice": "36995.1", "lastFundingRate": "0.0001", "interestRate": "0.0001",
+				"nextFundingTime": 1700006400000, "time": 1700000000000}`))
+		case "/api/v5/public/funding-rate":
+			require.Equal(t, "BTC-USDT-SWAP", r.URL.Query().Get("instId"))
+			w.Write([]byte(`{"code": "0", "msg": "", "data": [{"instId": "BTC-USDT-SWAP", "instType": "SWAP",
+				"fundingRate": "0.00015", "nextFundingRate": "0.0002", "fundingTime": "1700006400000",
+				"nextFundingTime": "1700035200000"}]}`))
+		case "/v5/market/tickers":
+			require.Equal(t, "linear", r.URL.Query().Get("category"))
+			require.Equal(t, "ETHUSDT", r.URL.Query().Get("symbol"))
+			w.Write([]byte(`{"retCode": 0, "retMsg": "OK", "result": {"category": "linear", "list": [{
+				"symbol": "ETHUSDT", "markPrice": "2000.5", "indexPrice": "2001.25", "fundingRate": "-0.00005",
+				"nextFundingTime": "1700006400000"}]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	f := newTestFundingRate(ts.URL, map[string][]string{
+		venueBinance: {"BTCUSDT"},
+		venueOKX:     {"BTC-USDT-SWAP"},
+		venueBybit:   {"ETHUSDT"},
+	})
+	require.NoError(t, f.Init())
+
+	var acc testutil.Accumulator
+	require.NoError(t, f.Gather(&acc))
+	require.Empty(t, acc.Errors)
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric("funding_rate",
+			map[string]string{"exchange": "binance", "instrument": "BTCUSDT"},
+			map[string]interface{}{
+				"funding_rate":      0.0001,
+				"mark_price":        37000.5,
+				"index_price":       36990.25,
+				"next_funding_time": int64(1700006400000),
+			},
+			time.Unix(1700000000, 0),
+		),
+		testutil.MustMetric("funding_rate",
+			map[string]string{"exchange": "bybit", "instrument": "ETHUSDT"},
+			map[string]interface{}{
+				"funding_rate":      -0.00005,
+				"mark_price":        2000.5,
+				"index_price":       2001.25,
+				"next_funding_time": int64(1700006400000),
+			},
+			time.Unix(1700000000, 0),
+		),
+		testutil.MustMetric("funding_rate",
+			map[string]string{"exchange": "okx", "instrument": "BTC-USDT-SWAP"},
+			map[string]interface{}{
+				"funding_rate":           0.00015,
+				"predicted_funding_rate": 0.0002,
+				"next_funding_time":      int64(1700006400000),
+			},
+			time.Unix(1700000000, 0),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestGatherWithoutPredictedRate(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"code": "0", "msg": "", "data": [{"instId": "BTC-USD-SWAP",
+			"fundingRate": "0.0001", "nextFundingRate": "", "fundingTime": "1700006400000"}]}`))
+	}))
+	defer ts.Close()
+
+	f := newTestFundingRate(ts.URL, map[string][]string{venueOKX: {"BTC-USD-SWAP"}})
+	require.NoError(t, f.Init())
+
+	var acc testutil.Accumulator
+	require.NoError(t, f.Gather(&acc))
+	require.Empty(t, acc.Errors)
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric("funding_rate",
+			map[string]string{"exchange": "okx", "instrument": "BTC-USD-SWAP"},
+			map[string]interface{}{
+				"funding_rate":      0.0001,
+				"next_funding_time": int64(1700006400000),
+			},
+			time.Unix(1700000000, 0),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestGatherErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v5/public/funding-rate":
+			w.Write([]byte(`{"code": "51001", "msg": "Instrument ID does not exist", "data": []}`))
+		case "/v5/market/tickers":
+			w.Write([]byte(`{"retCode": 10001, "retMsg": "params error", "result": {}}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	f := newTestFundingRate(ts.URL, map[string][]string{
+		venueBinance: {"BTCUSDT"},
+		venueOKX:     {"NOPE-SWAP"},
+		venueBybit:   {"NOPE"},
+	})
+	require.NoError(t, f.Init())
+
+	var acc testutil.Accumulator
+	require.NoError(t, f.Gather(&acc))
+	require.Len(t, acc.Errors, 3)
+	require.Contains(t, acc.Errors[0].Error(), "500 Internal Server Error")
+	require.Contains(t, acc.Errors[1].Error(), "error 10001: params error")
+	require.Contains(t, acc.Errors[2].Error(), "error 51001: Instrument ID does not exist")
+	require.Empty(t, acc.GetTelegrafMetrics())
+}
+
+func TestInitErrors(t *testing.T) {
+	f := newFundingRate()
+	require.Error(t, f.Init())
+
+	f.Instruments = map[string][]string{"deribit": {"BTC-PERPETUAL"}}
+	require.Error(t, f.Init())
+
+	f.Instruments = map[string][]string{venueBinance: {"BTCUSDT"}}
+	f.RestAddresses = map[string]string{"deribit": "http://localhost"}
+	require.Error(t, f.Init())
+
+	f.RestAddresses = nil
+	require.NoError(t, f.Init())
+}
@@ -0,0 +1,398 @@
+package gemini_marketdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/wsclient"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/plugins/common/tls"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const defaultServiceAddress = "wss://api.gemini.com/v2/marketdata"
+
+// the fields of the auction events, all decimals sent as strings
+var auctionFields = []string{
+	"auction_price",
+	"auction_quantity",
+	"highest_bid_price",
+	"lowest_ask_price",
+	"collar_price",
+	"indicative_price",
+	"indicative_quantity",
+}
+
+type GeminiMarketData struct {
+	ServiceAddress string   `toml:"service_address"`
+	Symbols        []string `toml:"symbols"`
+	MetricSchema   string   `toml:"metric_schema"`
+
+	PingInterval         internal.Duration `toml:"ping_interval"`
+	PongWait             internal.Duration `toml:"pong_wait"`
+	ReconnectInterval    internal.Duration `toml:"reconnect_interval"`
+	MaxBackoff           internal.Duration `toml:"max_backoff"`
+	MaxReconnectAttempts int               `toml:"max_reconnect_attempts"`
+
+	tls.ClientConfig
+
+	Log telegraf.Logger `toml:"-"`
+
+	acc        telegraf.Accumulator
+	client     *wsclient.Client
+	normalizer *marketdata.Normalizer
+	now        func() time.Time
+
+	// the order book of every symbol, sides keyed by price
+	books     map[string]*book
+	booksLock sync.Mutex
+}
+
+type book struct {
+	bids map[float64]float64
+	asks map[float64]float64
+}
+
+// l2Updates is the l2_updates message, the first of a symbol being the
+// snapshot of the whole book, each change in the format of
+// [side, price, quantity]
+type l2Updates struct {
+	Symbol  string      `json:"symbol"`
+	Changes [][3]string `json:"changes"`
+}
+
+// trade is the trade message of the l2 subscription
+type trade struct {
+	Symbol    string `json:"symbol"`
+	EventId   int64  `json:"event_id"`
+	Timestamp int64  `json:"timestamp"`
+	Price     string `json:"price"`
+	Quantity  string `json:"quantity"`
+	Side      string `json:"side"`
+}
+
+func (g *GeminiMarketData) SampleConfig() string {
+	return `
+## Gemini market data v2 websocket api
+# service_address = "wss://api.gemini.com/v2/marketdata"
+## Symbols whose order books, trades and auction events to subscribe to
+symbols = ["BTCUSD", "ETHUSD"]
+## Emit the trades, tickers and books in the "native" schema of the plugin,
+## or the "normalized" schema shared by the exchange inputs
+# metric_schema = "native"
+## Ping the server every ping_interval and reconnect when neither a pong nor
+## a message was received for pong_wait. "0s" disables the keepalive.
+# ping_interval = "10s"
+# pong_wait = "20s"
+## Delay before reconnecting after the connection dropped, doubled after
+## every failed attempt up to max_backoff
+# reconnect_interval = "1s"
+# max_backoff = "1m"
+## Give up reconnecting after this many consecutive failed attempts, 0 retries
+## forever
+# max_reconnect_attempts = 0
+
+## Optional TLS Config
+# tls_ca = "/etc/telegraf/ca.pem"
+# tls_cert = "/etc/telegraf/cert.pem"
+# tls_key = "/etc/telegraf/key.pem"
+## Use TLS but skip chain & host verification
+# insecure_skip_verify = false
+`
+}
+
+func (g *GeminiMarketData) Description() string {
+	return "Receives the order books, trades and auctions of Gemini symbols from its market data websocket api"
+}
+
+// Gather emits the top of the order book of every symbol
+func (g *GeminiMarketData) Gather(acc telegraf.Accumulator) error {
+	acc = g.normalizer.Accumulator(acc)
+
+	g.booksLock.Lock()
+	defer g.booksLock.Unlock()
+
+	now := g.now()
+	for symbol, b := range g.books {
+		acc.AddFields("gemini_book", b.fields(), map[string]string{"symbol": symbol}, now)
+	}
+	return nil
+}
+
+func (g *GeminiMarketData) Init() error {
+	if len(g.Symbols) == 0 {
+		return fmt.Errorf("symbols must be set")
+	}
+
+	normalizer, err := marketdata.NewNormalizer(g.MetricSchema, marketdata.Normalizer{
+		Exchange: "gemini",
+		Measurements: map[string]string{
+			"gemini_trade": marketdata.Trade,
+			"gemini_book":  marketdata.Book,
+		},
+		SymbolTag: "symbol",
+	}, g.Symbols)
+	if err != nil {
+		return err
+	}
+	g.normalizer = normalizer
+
+	tlsCfg, err := g.ClientConfig.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	cfg := wsclient.Config{
+		URL: g.ServiceAddress,
+		Dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: wsclient.HandshakeTimeout,
+			TLSClientConfig:  tlsCfg,
+		},
+		PingInterval:         g.PingInterval.Duration,
+		PongWait:             g.PongWait.Duration,
+		ReconnectInterval:    g.ReconnectInterval.Duration,
+		MaxBackoff:           g.MaxBackoff.Duration,
+		MaxReconnectAttempts: g.MaxReconnectAttempts,
+		StatsName:            "gemini_marketdata",
+		StatsTags:            map[string]string{"address": g.ServiceAddress},
+		Log:                  g.Log,
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	g.client = wsclient.New(cfg, g)
+
+	return nil
+}
+
+func (g *GeminiMarketData) Start(acc telegraf.Accumulator) error {
+	g.acc = g.normalizer.Accumulator(acc)
+	return g.client.Start(g.acc)
+}
+
+func (g *GeminiMarketData) Stop() {
+	g.client.Stop()
+}
+
+// Subscribe subscribes to the l2 data of the symbols on every connection.
+// The books start over from the snapshot sent upon subscribing.
+func (g *GeminiMarketData) Subscribe(conn *websocket.Conn) error {
+	g.booksLock.Lock()
+	g.books = make(map[string]*book)
+	g.booksLock.Unlock()
+
+	msg, err := json.Marshal(map[string]interface{}{
+		"type": "subscribe",
+		"subscriptions": []map[string]interface{}{
+			{"name": "l2", "symbols": g.Symbols},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	g.Log.Debugf("Subscription Request: %s", msg)
+	return conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+// Handle emits the metrics of a message of the feed
+func (g *GeminiMarketData) Handle(message []byte) {
+	g.Log.Debugf("recv: %s", message)
+
+	var msg map[string]interface{}
+	if err := json.Unmarshal(message, &msg); err != nil {
+		g.acc.AddError(fmt.Errorf("unable to parse incoming msg: %s", err))
+		return
+	}
+
+	var err error
+	switch msgType, _ := msg["type"].(string); msgType {
+	case "l2_updates":
+		err = g.updateBook(message)
+	case "trade":
+		err = g.addTrade(message)
+	case "auction_open", "auction_indicative", "auction_result":
+		err = g.addAuction(msgType, msg)
+	case "error":
+		err = fmt.Errorf("server error: %v", msg["reason"])
+	}
+	if err != nil {
+		g.acc.AddError(fmt.Errorf("%s: %s", msg["type"], err))
+	}
+}
+
+func (g *GeminiMarketData) updateBook(message []byte) error {
+	var updates l2Updates
+	if err := json.Unmarshal(message, &updates); err != nil {
+		return err
+	}
+
+	g.booksLock.Lock()
+	defer g.booksLock.Unlock()
+
+	b, ok := g.books[updates.Symbol]
+	if !ok {
+		b = &book{bids: make(map[float64]float64), asks: make(map[float64]float64)}
+		g.books[updates.Symbol] = b
+	}
+
+	for _, change := range updates.Changes {
+		price, err := strconv.ParseFloat(change[1], 64)
+		if err != nil {
+			return err
+		}
+		quantity, err := strconv.ParseFloat(change[2], 64)
+		if err != nil {
+			return err
+		}
+
+		side := b.bids
+		if change[0] == "sell" {
+			side = b.asks
+		}
+		if quantity == 0 {
+			delete(side, price)
+		} else {
+			side[price] = quantity
+		}
+	}
+	return nil
+}
+
+func (g *GeminiMarketData) addTrade(message []byte) error {
+	var t trade
+	if err := json.Unmarshal(message, &t); err != nil {
+		return err
+	}
+	price, err := strconv.ParseFloat(t.Price, 64)
+	if err != nil {
+		return err
+	}
+	size, err := strconv.ParseFloat(t.Quantity, 64)
+	if err != nil {
+		return err
+	}
+
+	g.acc.AddFields("gemini_trade",
+		map[string]interface{}{
+			"price":    price,
+			"size":     size,
+			"event_id": t.EventId,
+		},
+		map[string]string{
+			"symbol": t.Symbol,
+			"side":   t.Side,
+		},
+		time.Unix(0, t.Timestamp*int64(time.Millisecond)),
+	)
+	return nil
+}
+
+// addAuction emits an auction_open, auction_indicative or auction_result
+// event, whose fields vary by type
+func (g *GeminiMarketData) addAuction(msgType string, msg map[string]interface{}) error {
+	fields := make(map[string]interface{})
+	for _, name := range auctionFields {
+		s, ok := msg[name].(string)
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		fields[name] = value
+	}
+	if result, ok := msg["result"].(string); ok {
+		fields["result"] = result
+	}
+	if auctionId, ok := msg["auction_id"].(float64); ok {
+		fields["auction_id"] = int64(auctionId)
+	}
+
+	tm := g.now()
+	if ms, ok := msg["time_ms"].(float64); ok {
+		tm = time.Unix(0, int64(ms)*int64(time.Millisecond))
+	}
+
+	symbol, _ := msg["symbol"].(string)
+	g.acc.AddFields("gemini_auction", fields,
+		map[string]string{
+			"symbol": symbol,
+			"type":   msgType,
+		},
+		tm,
+	)
+	return nil
+}
+
+// fields returns the top of the book and the total quantity of each side
+func (b *book) fields() map[string]interface{} {
+	bids, asks := sortedPrices(b.bids, true), sortedPrices(b.asks, false)
+
+	fields := map[string]interface{}{
+		"bid_depth": totalQuantity(b.bids),
+		"ask_depth": totalQuantity(b.asks),
+	}
+	if len(bids) > 0 {
+		fields["best_bid"] = bids[0]
+		fields["best_bid_size"] = b.bids[bids[0]]
+	}
+	if len(asks) > 0 {
+		fields["best_ask"] = asks[0]
+		fields["best_ask_size"] = b.asks[asks[0]]
+	}
+	if len(bids) > 0 && len(asks) > 0 {
+		fields["spread"] = asks[0] - bids[0]
+		fields["mid_price"] = (asks[0] + bids[0]) / 2
+	}
+	return fields
+}
+
+// sortedPrices returns the prices of a side from the best, the highest bid
+// or the lowest ask
+func sortedPrices(side map[float64]float64, descending bool) []float64 {
+	prices := make([]float64, 0, len(side))
+	for price := range side {
+		prices = append(prices, price)
+	}
+	if descending {
+		sort.Sort(sort.Reverse(sort.Float64Slice(prices)))
+	} else {
+		sort.Float64s(prices)
+	}
+	return prices
+}
+
+func totalQuantity(side map[float64]float64) float64 {
+	var total float64
+	for _, quantity := range side {
+		total += quantity
+	}
+	return total
+}
+
+func newGeminiMarketData() *GeminiMarketData {
+	return &GeminiMarketData{
+		ServiceAddress:    defaultServiceAddress,
+		MetricSchema:      marketdata.SchemaNative,
+		PingInterval:      internal.Duration{Duration: wsclient.DefaultPingInterval},
+		PongWait:          internal.Duration{Duration: wsclient.DefaultPongWait},
+		ReconnectInterval: internal.Duration{Duration: wsclient.DefaultReconnectInterval},
+		MaxBackoff:        internal.Duration{Duration: wsclient.DefaultMaxBackoff},
+		now:               time.Now,
+		books:             make(map[string]*book),
+	}
+}
+
+func init() {
+	inputs.Add("gemini_marketdata", func() telegraf.Input { return newGeminiMarketData() })
+}
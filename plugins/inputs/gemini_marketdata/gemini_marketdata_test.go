@@ -0,0 +1,145 @@
+package gemini_marketdata
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestPlugin(t *testing.T) (*GeminiMarketData, *testutil.Accumulator) {
+	g := newGeminiMarketData()
+	g.Log = testutil.Logger{}
+	g.Symbols = []string{"BTCUSD"}
+	g.now = func() time.Time { return now }
+	require.NoError(t, g.Init())
+
+	acc := &testutil.Accumulator{}
+	g.acc = acc
+	return g, acc
+}
+
+func TestTrade(t *testing.T) {
+	g, acc := newTestPlugin(t)
+
+	g.Handle([]byte(`{"type": "trade", "symbol": "BTCUSD", "event_id": 3575573053, "timestamp": 1562866744658, "price": "9122.04", "quantity": "0.0073173", "side": "sell", "tid": 2840140800042677}`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("gemini_trade",
+			map[string]string{"symbol": "BTCUSD", "side": "sell"},
+			map[string]interface{}{"price": 9122.04, "size": 0.0073173, "event_id": int64(3575573053)},
+			time.Unix(0, 1562866744658*int64(time.Millisecond)),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestBook(t *testing.T) {
+	g, acc := newTestPlugin(t)
+
+	g.Handle([]byte(`{"type": "l2_updates", "symbol": "BTCUSD", "changes": [["buy", "9122.04", "0.5"], ["buy", "9121.00", "1.5"], ["sell", "9123.00", "0.25"], ["sell", "9124.00", "2"]], "trades": [], "auction_events": []}`))
+	g.Handle([]byte(`{"type": "l2_updates", "symbol": "BTCUSD", "changes": [["sell", "9123.00", "0"], ["buy", "9122.50", "1"]]}`))
+	require.NoError(t, acc.FirstError())
+
+	require.NoError(t, g.Gather(acc))
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("gemini_book",
+			map[string]string{"symbol": "BTCUSD"},
+			map[string]interface{}{
+				"best_bid":      9122.5,
+				"best_bid_size": 1.0,
+				"best_ask":      9124.0,
+				"best_ask_size": 2.0,
+				"spread":        1.5,
+				"mid_price":     9123.25,
+				"bid_depth":     3.0,
+				"ask_depth":     2.0,
+			},
+			now,
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestAuction(t *testing.T) {
+	g, acc := newTestPlugin(t)
+
+	g.Handle([]byte(`{"type": "auction_result", "symbol": "BTCUSD", "time_ms": 1562866800000, "result": "success", "highest_bid_price": "9150.80", "lowest_ask_price": "9150.81", "collar_price": "9146.93", "auction_price": "9145.00", "auction_quantity": "470.10390845", "auction_id": 3, "event_id": 4}`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("gemini_auction",
+			map[string]string{"symbol": "BTCUSD", "type": "auction_result"},
+			map[string]interface{}{
+				"result":            "success",
+				"highest_bid_price": 9150.8,
+				"lowest_ask_price":  9150.81,
+				"collar_price":      9146.93,
+				"auction_price":     9145.0,
+				"auction_quantity":  470.10390845,
+				"auction_id":        int64(3),
+			},
+			time.Unix(1562866800, 0),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestSubscribe(t *testing.T) {
+	subscriptions := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		subscriptions <- string(msg)
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer ts.Close()
+
+	g := newGeminiMarketData()
+	g.Log = testutil.Logger{}
+	g.ServiceAddress = "ws" + strings.TrimPrefix(ts.URL, "http")
+	g.Symbols = []string{"BTCUSD", "ETHUSD"}
+	require.NoError(t, g.Init())
+	require.NoError(t, g.Start(&testutil.Accumulator{}))
+	defer g.Stop()
+
+	require.JSONEq(t, `{"type": "subscribe", "subscriptions": [{"name": "l2", "symbols": ["BTCUSD", "ETHUSD"]}]}`, <-subscriptions)
+}
+
+func TestNormalizedSchema(t *testing.T) {
+	g, acc := newTestPlugin(t)
+	g.MetricSchema = marketdata.SchemaNormalized
+	require.NoError(t, g.Init())
+	g.acc = g.normalizer.Accumulator(acc)
+
+	g.Handle([]byte(`{"type": "trade", "symbol": "BTCUSD", "event_id": 3575573053, "timestamp": 1562866744658, "price": "9122.04", "quantity": "0.0073173", "side": "sell", "tid": 2840140800042677}`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("market_trade",
+			map[string]string{"exchange": "gemini", "base": "BTC", "quote": "USD", "side": "sell"},
+			map[string]interface{}{"price": 9122.04, "size": 0.0073173},
+			time.Unix(0, 1562866744658*int64(time.Millisecond)),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestInit(t *testing.T) {
+	g := newGeminiMarketData()
+	require.Error(t, g.Init())
+}
@@ -0,0 +1,434 @@
+package htx_marketdata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/wsclient"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/plugins/common/tls"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const (
+	defaultServiceAddress = "wss://api.huobi.pro/ws"
+
+	channelTicker = "ticker"
+	channelTrade  = "trade"
+	channelDepth  = "depth"
+)
+
+// the topic of every channel, formatted with the symbol
+var channelTopics = map[string]string{
+	channelTicker: "market.%s.ticker",
+	channelTrade:  "market.%s.trade.detail",
+	channelDepth:  "market.%s.depth.step0",
+}
+
+type HTXMarketData struct {
+	ServiceAddress string   `toml:"service_address"`
+	Symbols        []string `toml:"symbols"`
+	Channels       []string `toml:"channels"`
+	MetricSchema   string   `toml:"metric_schema"`
+
+	PingInterval         internal.Duration `toml:"ping_interval"`
+	PongWait             internal.Duration `toml:"pong_wait"`
+	ReconnectInterval    internal.Duration `toml:"reconnect_interval"`
+	MaxBackoff           internal.Duration `toml:"max_backoff"`
+	MaxReconnectAttempts int               `toml:"max_reconnect_attempts"`
+
+	tls.ClientConfig
+
+	Log telegraf.Logger `toml:"-"`
+
+	acc        telegraf.Accumulator
+	client     *wsclient.Client
+	normalizer *marketdata.Normalizer
+	now        func() time.Time
+
+	// the last depth snapshot of every symbol
+	books     map[string]*book
+	booksLock sync.Mutex
+}
+
+type book struct {
+	bids [][2]float64
+	asks [][2]float64
+}
+
+// message is either a ping of the server, the answer to a subscription, or
+// the data of a topic
+type message struct {
+	Ping    *int64          `json:"ping"`
+	Status  string          `json:"status"`
+	ErrCode string          `json:"err-code"`
+	ErrMsg  string          `json:"err-msg"`
+	Channel string          `json:"ch"`
+	Ts      int64           `json:"ts"`
+	Tick    json.RawMessage `json:"tick"`
+}
+
+type ticker struct {
+	Open     float64 `json:"open"`
+	High     float64 `json:"high"`
+	Low      float64 `json:"low"`
+	Close    float64 `json:"close"`
+	Amount   float64 `json:"amount"`
+	Vol      float64 `json:"vol"`
+	Count    int64   `json:"count"`
+	Bid      float64 `json:"bid"`
+	BidSize  float64 `json:"bidSize"`
+	Ask      float64 `json:"ask"`
+	AskSize  float64 `json:"askSize"`
+	LastSize float64 `json:"lastSize"`
+}
+
+type tradeDetail struct {
+	Data []struct {
+		Ts        int64   `json:"ts"`
+		TradeId   int64   `json:"tradeId"`
+		Amount    float64 `json:"amount"`
+		Price     float64 `json:"price"`
+		Direction string  `json:"direction"`
+	} `json:"data"`
+}
+
+// depth is a snapshot of the book, sorted from the best price, the levels in
+// the format of [price, size]
+type depth struct {
+	Bids [][2]float64 `json:"bids"`
+	Asks [][2]float64 `json:"asks"`
+}
+
+func (h *HTXMarketData) SampleConfig() string {
+	return `
+## HTX (Huobi) market websocket api
+# service_address = "wss://api.huobi.pro/ws"
+## Symbols to subscribe to
+symbols = ["btcusdt", "ethusdt"]
+## Channels of every symbol, any of "ticker", "trade" and "depth"
+channels = ["ticker", "trade", "depth"]
+## Emit the trades, tickers and books in the "native" schema of the plugin,
+## or the "normalized" schema shared by the exchange inputs
+# metric_schema = "native"
+## Ping the server every ping_interval and reconnect when neither a pong nor
+## a message was received for pong_wait. "0s" disables the keepalive. The
+## pings of the server are answered regardless.
+# ping_interval = "10s"
+# pong_wait = "20s"
+## Delay before reconnecting after the connection dropped, doubled after
+## every failed attempt up to max_backoff
+# reconnect_interval = "1s"
+# max_backoff = "1m"
+## Give up reconnecting after this many consecutive failed attempts, 0 retries
+## forever
+# max_reconnect_attempts = 0
+
+## Optional TLS Config
+# tls_ca = "/etc/telegraf/ca.pem"
+# tls_cert = "/etc/telegraf/cert.pem"
+# tls_key = "/etc/telegraf/key.pem"
+## Use TLS but skip chain & host verification
+# insecure_skip_verify = false
+`
+}
+
+func (h *HTXMarketData) Description() string {
+	return "Receives the tickers, trades and order books of HTX (Huobi) symbols from its market websocket api"
+}
+
+// Gather emits the top of the last depth snapshot of every symbol
+func (h *HTXMarketData) Gather(acc telegraf.Accumulator) error {
+	acc = h.normalizer.Accumulator(acc)
+
+	h.booksLock.Lock()
+	defer h.booksLock.Unlock()
+
+	now := h.now()
+	for symbol, bk := range h.books {
+		acc.AddFields("htx_book", bk.fields(), map[string]string{"symbol": symbol}, now)
+	}
+	return nil
+}
+
+func (h *HTXMarketData) Init() error {
+	if len(h.Symbols) == 0 {
+		return fmt.Errorf("symbols must be set")
+	}
+	if len(h.Channels) == 0 {
+		return fmt.Errorf("channels must be set")
+	}
+	for _, channel := range h.Channels {
+		if _, ok := channelTopics[channel]; !ok {
+			return fmt.Errorf("invalid channel %q, must be %q, %q or %q", channel, channelTicker, channelTrade, channelDepth)
+		}
+	}
+
+	normalizer, err := marketdata.NewNormalizer(h.MetricSchema, marketdata.Normalizer{
+		Exchange: "htx",
+		Measurements: map[string]string{
+			"htx_ticker": marketdata.Ticker,
+			"htx_trade":  marketdata.Trade,
+			"htx_book":   marketdata.Book,
+		},
+		SymbolTag: "symbol",
+	}, h.Symbols)
+	if err != nil {
+		return err
+	}
+	h.normalizer = normalizer
+
+	tlsCfg, err := h.ClientConfig.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	cfg := wsclient.Config{
+		URL: h.ServiceAddress,
+		Dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: wsclient.HandshakeTimeout,
+			TLSClientConfig:  tlsCfg,
+		},
+		PingInterval:         h.PingInterval.Duration,
+		PongWait:             h.PongWait.Duration,
+		ReconnectInterval:    h.ReconnectInterval.Duration,
+		MaxBackoff:           h.MaxBackoff.Duration,
+		MaxReconnectAttempts: h.MaxReconnectAttempts,
+		StatsName:            "htx_marketdata",
+		StatsTags:            map[string]string{"address": h.ServiceAddress},
+		Log:                  h.Log,
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	h.client = wsclient.New(cfg, h)
+
+	return nil
+}
+
+func (h *HTXMarketData) Start(acc telegraf.Accumulator) error {
+	h.acc = h.normalizer.Accumulator(acc)
+	return h.client.Start(h.acc)
+}
+
+func (h *HTXMarketData) Stop() {
+	h.client.Stop()
+}
+
+// Subscribe subscribes to the topic of every channel of every symbol, a
+// message each, the depth snapshots replacing the books kept so far
+func (h *HTXMarketData) Subscribe(conn *websocket.Conn) error {
+	h.booksLock.Lock()
+	h.books = make(map[string]*book)
+	h.booksLock.Unlock()
+
+	for _, symbol := range h.Symbols {
+		for _, channel := range h.Channels {
+			topic := fmt.Sprintf(channelTopics[channel], symbol)
+			msg, err := json.Marshal(map[string]string{"sub": topic, "id": topic})
+			if err != nil {
+				return err
+			}
+			h.Log.Debugf("Subscription Request: %s", msg)
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Handle decompresses a message of the feed, all of them being gzipped
+// binary frames, then answers the pings of the server or emits the metrics
+// of the topic
+func (h *HTXMarketData) Handle(data []byte) {
+	data, err := gunzip(data)
+	if err != nil {
+		h.acc.AddError(fmt.Errorf("unable to decompress incoming msg: %s", err))
+		return
+	}
+	h.Log.Debugf("recv: %s", data)
+
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		h.acc.AddError(fmt.Errorf("unable to parse incoming msg: %s", err))
+		return
+	}
+
+	switch {
+	case msg.Ping != nil:
+		// the server drops the connection after two unanswered pings
+		pong := fmt.Sprintf(`{"pong":%d}`, *msg.Ping)
+		if err := h.client.WriteMessage([]byte(pong)); err != nil {
+			h.Log.Warnf("Unable to answer ping: %s", err)
+		}
+		return
+	case msg.Status == "error":
+		h.acc.AddError(fmt.Errorf("server error %s: %s", msg.ErrCode, msg.ErrMsg))
+		return
+	case msg.Channel == "":
+		return
+	}
+
+	// topics are in the format of market.$symbol.$channel[.$param]
+	parts := strings.Split(msg.Channel, ".")
+	if len(parts) < 3 {
+		return
+	}
+	symbol := parts[1]
+	switch parts[2] {
+	case channelTicker:
+		err = h.addTicker(symbol, msg.Ts, msg.Tick)
+	case channelTrade:
+		err = h.addTrades(symbol, msg.Tick)
+	case channelDepth:
+		err = h.updateBook(symbol, msg.Tick)
+	}
+	if err != nil {
+		h.acc.AddError(fmt.Errorf("unable to parse %s message: %s", msg.Channel, err))
+	}
+}
+
+// gunzip decompresses a gzipped message
+func gunzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (h *HTXMarketData) addTicker(symbol string, ts int64, tick []byte) error {
+	var t ticker
+	if err := json.Unmarshal(tick, &t); err != nil {
+		return err
+	}
+
+	h.acc.AddFields("htx_ticker",
+		map[string]interface{}{
+			"price":         t.Close,
+			"last_size":     t.LastSize,
+			"best_bid":      t.Bid,
+			"best_bid_size": t.BidSize,
+			"best_ask":      t.Ask,
+			"best_ask_size": t.AskSize,
+			"open_24h":      t.Open,
+			"high_24h":      t.High,
+			"low_24h":       t.Low,
+			"volume_24h":    t.Amount,
+			"turnover_24h":  t.Vol,
+			"trades_24h":    t.Count,
+		},
+		map[string]string{"symbol": symbol},
+		time.Unix(0, ts*int64(time.Millisecond)),
+	)
+	return nil
+}
+
+func (h *HTXMarketData) addTrades(symbol string, tick []byte) error {
+	var d tradeDetail
+	if err := json.Unmarshal(tick, &d); err != nil {
+		return err
+	}
+
+	for _, t := range d.Data {
+		h.acc.AddFields("htx_trade",
+			map[string]interface{}{
+				"price":    t.Price,
+				"size":     t.Amount,
+				"trade_id": t.TradeId,
+			},
+			map[string]string{
+				"symbol": symbol,
+				"side":   t.Direction,
+			},
+			time.Unix(0, t.Ts*int64(time.Millisecond)),
+		)
+	}
+	return nil
+}
+
+// updateBook replaces the book of symbol with a depth snapshot
+func (h *HTXMarketData) updateBook(symbol string, tick []byte) error {
+	var d depth
+	if err := json.Unmarshal(tick, &d); err != nil {
+		return err
+	}
+
+	h.booksLock.Lock()
+	defer h.booksLock.Unlock()
+	h.books[symbol] = &book{bids: sortLevels(d.Bids, true), asks: sortLevels(d.Asks, false)}
+	return nil
+}
+
+// sortLevels sorts the levels of a side from the best price, the highest
+// bid or the lowest ask
+func sortLevels(levels [][2]float64, descending bool) [][2]float64 {
+	sort.Slice(levels, func(i, j int) bool {
+		if descending {
+			return levels[i][0] > levels[j][0]
+		}
+		return levels[i][0] < levels[j][0]
+	})
+	return levels
+}
+
+// fields returns the top of the book and the total size of each side
+func (bk *book) fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"bid_depth": totalSize(bk.bids),
+		"ask_depth": totalSize(bk.asks),
+	}
+	if len(bk.bids) > 0 {
+		fields["best_bid"] = bk.bids[0][0]
+		fields["best_bid_size"] = bk.bids[0][1]
+	}
+	if len(bk.asks) > 0 {
+		fields["best_ask"] = bk.asks[0][0]
+		fields["best_ask_size"] = bk.asks[0][1]
+	}
+	if len(bk.bids) > 0 && len(bk.asks) > 0 {
+		bid, ask := bk.bids[0][0], bk.asks[0][0]
+		fields["spread"] = ask - bid
+		fields["mid_price"] = (ask + bid) / 2
+	}
+	return fields
+}
+
+func totalSize(levels [][2]float64) float64 {
+	var total float64
+	for _, l := range levels {
+		total += l[1]
+	}
+	return total
+}
+
+func newHTXMarketData() *HTXMarketData {
+	return &HTXMarketData{
+		ServiceAddress:    defaultServiceAddress,
+		MetricSchema:      marketdata.SchemaNative,
+		PingInterval:      internal.Duration{Duration: wsclient.DefaultPingInterval},
+		PongWait:          internal.Duration{Duration: wsclient.DefaultPongWait},
+		ReconnectInterval: internal.Duration{Duration: wsclient.DefaultReconnectInterval},
+		MaxBackoff:        internal.Duration{Duration: wsclient.DefaultMaxBackoff},
+		now:               time.Now,
+		books:             make(map[string]*book),
+	}
+}
+
+func init() {
+	inputs.Add("htx_marketdata", func() telegraf.Input { return newHTXMarketData() })
+}
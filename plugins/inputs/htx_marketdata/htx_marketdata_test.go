@@ -0,0 +1,209 @@
+package htx_marketdata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestPlugin(t *testing.T) (*HTXMarketData, *testutil.Accumulator) {
+	h := newHTXMarketData()
+	h.Log = testutil.Logger{}
+	h.Symbols = []string{"btcusdt"}
+	h.Channels = []string{"ticker", "trade", "depth"}
+	h.now = func() time.Time { return now }
+	require.NoError(t, h.Init())
+
+	acc := &testutil.Accumulator{}
+	h.acc = acc
+	return h, acc
+}
+
+func compress(t *testing.T, msg string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(msg))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestTicker(t *testing.T) {
+	h, acc := newTestPlugin(t)
+
+	h.Handle(compress(t, `{"ch": "market.btcusdt.ticker", "ts": 1630982370526, "tick": {"open": 51732, "high": 52785.64, "low": 51000, "close": 52735.63, "amount": 13259.24137056181, "vol": 687640987.4125315, "count": 448737, "bid": 52732.88, "bidSize": 0.036, "ask": 52732.89, "askSize": 0.583653, "lastPrice": 52735.63, "lastSize": 0.03}}`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("htx_ticker",
+			map[string]string{"symbol": "btcusdt"},
+			map[string]interface{}{
+				"price":         52735.63,
+				"last_size":     0.03,
+				"best_bid":      52732.88,
+				"best_bid_size": 0.036,
+				"best_ask":      52732.89,
+				"best_ask_size": 0.583653,
+				"open_24h":      51732.0,
+				"high_24h":      52785.64,
+				"low_24h":       51000.0,
+				"volume_24h":    13259.24137056181,
+				"turnover_24h":  687640987.4125315,
+				"trades_24h":    int64(448737),
+			},
+			time.Unix(0, 1630982370526*int64(time.Millisecond)),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestTrades(t *testing.T) {
+	h, acc := newTestPlugin(t)
+
+	h.Handle(compress(t, `{"ch": "market.btcusdt.trade.detail", "ts": 1630994963175, "tick": {"id": 137005445109, "ts": 1630994963173, "data": [{"id": 1370054451098297658, "ts": 1630994963173, "tradeId": 102523573486, "amount": 0.006754, "price": 52648.62, "direction": "buy"}, {"id": 1370054451098297659, "ts": 1630994963174, "tradeId": 102523573487, "amount": 0.5, "price": 52648.6, "direction": "sell"}]}}`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("htx_trade",
+			map[string]string{"symbol": "btcusdt", "side": "buy"},
+			map[string]interface{}{"price": 52648.62, "size": 0.006754, "trade_id": int64(102523573486)},
+			time.Unix(0, 1630994963173*int64(time.Millisecond)),
+		),
+		testutil.MustMetric("htx_trade",
+			map[string]string{"symbol": "btcusdt", "side": "sell"},
+			map[string]interface{}{"price": 52648.6, "size": 0.5, "trade_id": int64(102523573487)},
+			time.Unix(0, 1630994963174*int64(time.Millisecond)),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestBook(t *testing.T) {
+	h, acc := newTestPlugin(t)
+
+	h.Handle(compress(t, `{"ch": "market.btcusdt.depth.step0", "ts": 1630983549503, "tick": {"bids": [[52690.69, 0.36281], [52690.68, 0.2]], "asks": [[52690.7, 2.7143], [52693.57, 0.25]], "version": 137002820373, "ts": 1630983549500}}`))
+	// a later snapshot replaces the book
+	h.Handle(compress(t, `{"ch": "market.btcusdt.depth.step0", "ts": 1630983550503, "tick": {"bids": [[52690.68, 0.25], [52690.69, 0.5]], "asks": [[52693.57, 0.25], [52691.7, 2]], "version": 137002820374, "ts": 1630983550500}}`))
+	require.NoError(t, acc.FirstError())
+
+	require.NoError(t, h.Gather(acc))
+	bid, ask := 52690.69, 52691.7
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("htx_book",
+			map[string]string{"symbol": "btcusdt"},
+			map[string]interface{}{
+				"best_bid":      52690.69,
+				"best_bid_size": 0.5,
+				"best_ask":      52691.7,
+				"best_ask_size": 2.0,
+				"spread":        ask - bid,
+				"mid_price":     (ask + bid) / 2,
+				"bid_depth":     0.75,
+				"ask_depth":     2.25,
+			},
+			now,
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestServerError(t *testing.T) {
+	h, acc := newTestPlugin(t)
+
+	h.Handle(compress(t, `{"id": "market.btcusdt.ticker", "status": "ok", "subbed": "market.btcusdt.ticker", "ts": 1630982370000}`))
+	require.NoError(t, acc.FirstError())
+	require.Empty(t, acc.GetTelegrafMetrics())
+
+	h.Handle(compress(t, `{"status": "error", "ts": 1630982370000, "id": "market.btcusdx.ticker", "err-code": "bad-request", "err-msg": "invalid symbol btcusdx"}`))
+	require.Error(t, acc.FirstError())
+	require.Contains(t, acc.FirstError().Error(), "server error bad-request")
+}
+
+func TestUncompressedMessage(t *testing.T) {
+	h, acc := newTestPlugin(t)
+
+	h.Handle([]byte(`{"ping": 1492420473027}`))
+	require.Error(t, acc.FirstError())
+}
+
+func TestSubscribesAndAnswersPings(t *testing.T) {
+	msgs := make(chan string, 10)
+	ping := compress(t, `{"ping": 1492420473027}`)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for i := 0; ; i++ {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			select {
+			case msgs <- string(msg):
+			default:
+			}
+			if i == 0 {
+				_ = conn.WriteMessage(websocket.BinaryMessage, ping)
+			}
+		}
+	}))
+	defer ts.Close()
+
+	h := newHTXMarketData()
+	h.Log = testutil.Logger{}
+	h.ServiceAddress = "ws" + strings.TrimPrefix(ts.URL, "http")
+	h.Symbols = []string{"btcusdt"}
+	h.Channels = []string{"depth"}
+	require.NoError(t, h.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, h.Start(acc))
+	defer h.Stop()
+
+	require.JSONEq(t, `{"sub": "market.btcusdt.depth.step0", "id": "market.btcusdt.depth.step0"}`, <-msgs)
+	require.JSONEq(t, `{"pong": 1492420473027}`, <-msgs)
+	require.NoError(t, acc.FirstError())
+}
+
+func TestNormalizedSchema(t *testing.T) {
+	h, acc := newTestPlugin(t)
+	h.MetricSchema = marketdata.SchemaNormalized
+	require.NoError(t, h.Init())
+	h.acc = h.normalizer.Accumulator(acc)
+
+	h.Handle(compress(t, `{"ch": "market.btcusdt.trade.detail", "ts": 1630994963175, "tick": {"id": 137005445109, "ts": 1630994963173, "data": [{"id": 1370054451098297658, "ts": 1630994963173, "tradeId": 102523573486, "amount": 0.006754, "price": 52648.62, "direction": "buy"}]}}`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("market_trade",
+			map[string]string{"exchange": "htx", "base": "BTC", "quote": "USDT", "side": "buy"},
+			map[string]interface{}{"price": 52648.62, "size": 0.006754},
+			time.Unix(0, 1630994963173*int64(time.Millisecond)),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestInit(t *testing.T) {
+	h := newHTXMarketData()
+	h.Channels = []string{"ticker"}
+	require.Error(t, h.Init())
+
+	h.Symbols = []string{"btcusdt"}
+	h.Channels = []string{"kline"}
+	require.Error(t, h.Init())
+
+	h.Channels = []string{"depth"}
+	require.NoError(t, h.Init())
+}
@@ -0,0 +1,240 @@
+package kraken_marketdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// checksumLevels is the number of levels of each side the checksum of the
+// feed covers
+const checksumLevels = 10
+
+// level is a price level of the book, with the price and volume as
+// received, which the checksum is computed from
+type level struct {
+	price      float64
+	volume     float64
+	priceText  string
+	volumeText string
+}
+
+// book is the order book of a pair, its levels keyed by the price as
+// received
+type book struct {
+	depth int
+	bids  map[string]level
+	asks  map[string]level
+}
+
+// bookPayload is a payload of the book channel, "as" and "bs" in the
+// snapshot, "a" and "b" in updates with the checksum "c" in the last payload
+// of an update. The levels are [price, volume, timestamp] with an optional
+// fourth "r" of republished updates.
+type bookPayload struct {
+	SnapshotAsks [][]string `json:"as"`
+	SnapshotBids [][]string `json:"bs"`
+	Asks         [][]string `json:"a"`
+	Bids         [][]string `json:"b"`
+	Checksum     string     `json:"c"`
+}
+
+// apply updates a side of the book with levels, removing the levels of a
+// zero volume
+func apply(side map[string]level, levels [][]string) error {
+	for _, l := range levels {
+		if len(l) < 2 {
+			return fmt.Errorf("level of %d values, expected 3", len(l))
+		}
+		price, err := strconv.ParseFloat(l[0], 64)
+		if err != nil {
+			return err
+		}
+		volume, err := strconv.ParseFloat(l[1], 64)
+		if err != nil {
+			return err
+		}
+
+		if volume == 0 {
+			delete(side, l[0])
+			continue
+		}
+		side[l[0]] = level{price: price, volume: volume, priceText: l[0], volumeText: l[1]}
+	}
+	return nil
+}
+
+// sorted returns the levels of a side from the best price, the highest bid
+// or the lowest ask
+func sorted(side map[string]level, descending bool) []level {
+	levels := make([]level, 0, len(side))
+	for _, l := range side {
+		levels = append(levels, l)
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		if descending {
+			return levels[i].price > levels[j].price
+		}
+		return levels[i].price < levels[j].price
+	})
+	return levels
+}
+
+// truncate drops the levels beyond the depth subscribed to, which the feed
+// no longer sends updates of
+func (b *book) truncate() {
+	for _, side := range []struct {
+		levels     map[string]level
+		descending bool
+	}{{b.bids, true}, {b.asks, false}} {
+		levels := sorted(side.levels, side.descending)
+		for i := b.depth; i < len(levels); i++ {
+			delete(side.levels, levels[i].priceText)
+		}
+	}
+}
+
+// checksumText is a price or volume as it enters the checksum, without the
+// decimal point and leading zeros
+func checksumText(s string) string {
+	return strings.TrimLeft(strings.Replace(s, ".", "", 1), "0")
+}
+
+// checksum is the CRC32 of the best ten asks from the lowest, followed by
+// the best ten bids from the highest, each as its price and volume
+func (b *book) checksum() uint32 {
+	var sb strings.Builder
+	for _, side := range [][]level{sorted(b.asks, false), sorted(b.bids, true)} {
+		for i, l := range side {
+			if i == checksumLevels {
+				break
+			}
+			sb.WriteString(checksumText(l.priceText))
+			sb.WriteString(checksumText(l.volumeText))
+		}
+	}
+	return crc32.ChecksumIEEE([]byte(sb.String()))
+}
+
+// fields returns the top of the book and the total volume of each side
+func (b *book) fields() map[string]interface{} {
+	bids, asks := sorted(b.bids, true), sorted(b.asks, false)
+
+	fields := map[string]interface{}{
+		"bid_depth": totalVolume(bids),
+		"ask_depth": totalVolume(asks),
+	}
+	if len(bids) > 0 {
+		fields["best_bid"] = bids[0].price
+		fields["best_bid_size"] = bids[0].volume
+	}
+	if len(asks) > 0 {
+		fields["best_ask"] = asks[0].price
+		fields["best_ask_size"] = asks[0].volume
+	}
+	if len(bids) > 0 && len(asks) > 0 {
+		fields["spread"] = asks[0].price - bids[0].price
+		fields["mid_price"] = (asks[0].price + bids[0].price) / 2
+	}
+	return fields
+}
+
+func totalVolume(levels []level) float64 {
+	var total float64
+	for _, l := range levels {
+		total += l.volume
+	}
+	return total
+}
+
+// updateBook applies the payloads of a book message to the book of pair,
+// starting it over from a snapshot, and validates the checksum of an update
+func (k *KrakenMarketData) updateBook(pair string, payloads []json.RawMessage) error {
+	k.booksLock.Lock()
+	defer k.booksLock.Unlock()
+
+	var checksum string
+	for _, raw := range payloads {
+		var payload bookPayload
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			return err
+		}
+
+		if payload.SnapshotAsks != nil || payload.SnapshotBids != nil {
+			b := &book{depth: k.BookDepth, bids: make(map[string]level), asks: make(map[string]level)}
+			if err := apply(b.asks, payload.SnapshotAsks); err != nil {
+				return err
+			}
+			if err := apply(b.bids, payload.SnapshotBids); err != nil {
+				return err
+			}
+			k.books[pair] = b
+			continue
+		}
+
+		b, ok := k.books[pair]
+		if !ok {
+			// awaiting the snapshot
+			return nil
+		}
+		if err := apply(b.asks, payload.Asks); err != nil {
+			return err
+		}
+		if err := apply(b.bids, payload.Bids); err != nil {
+			return err
+		}
+		b.truncate()
+
+		if payload.Checksum != "" {
+			checksum = payload.Checksum
+		}
+	}
+
+	if checksum == "" || !k.ValidateChecksum {
+		return nil
+	}
+	expected, err := strconv.ParseUint(checksum, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid checksum %q: %s", checksum, err)
+	}
+	if computed := k.books[pair].checksum(); computed != uint32(expected) {
+		k.resync(pair, uint32(expected), computed)
+	}
+	return nil
+}
+
+// resync reports a book diverged from the checksum of the feed, and
+// resubscribes to the book of pair for a fresh snapshot. Called with the
+// books locked.
+func (k *KrakenMarketData) resync(pair string, expected, computed uint32) {
+	k.Log.Warnf("Order book of %s diverged, checksum %d, computed %d, resubscribing", pair, expected, computed)
+	delete(k.books, pair)
+
+	k.acc.AddFields("kraken_checksum_mismatch",
+		map[string]interface{}{
+			"expected": int64(expected),
+			"computed": int64(computed),
+		},
+		map[string]string{"pair": pair},
+		k.now(),
+	)
+
+	if k.client.Conn() == nil {
+		// the read loop subscribes again once reconnected
+		return
+	}
+	for _, event := range []string{"unsubscribe", "subscribe"} {
+		msg, err := k.subscription(event, channelBook, []string{pair})
+		if err == nil {
+			err = k.client.WriteMessage(msg)
+		}
+		if err != nil {
+			// the read loop reconnects, subscribing again
+			k.acc.AddError(fmt.Errorf("resubscribing to the book of %s: %s", pair, err))
+			return
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package kraken_marketdata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/wsclient"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/plugins/common/tls"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const (
+	defaultServiceAddress = "wss://ws.kraken.com"
+	defaultBookDepth      = 10
+
+	channelTicker = "ticker"
+	channelTrade  = "trade"
+	channelBook   = "book"
+)
+
+// the depths the book channel can be subscribed with
+var bookDepths = map[int]bool{10: true, 25: true, 100: true, 500: true, 1000: true}
+
+type KrakenMarketData struct {
+	ServiceAddress   string   `toml:"service_address"`
+	Pairs            []string `toml:"pairs"`
+	Channels         []string `toml:"channels"`
+	BookDepth        int      `toml:"book_depth"`
+	ValidateChecksum bool     `toml:"validate_checksum"`
+	MetricSchema     string   `toml:"metric_schema"`
+
+	PingInterval         internal.Duration `toml:"ping_interval"`
+	PongWait             internal.Duration `toml:"pong_wait"`
+	ReconnectInterval    internal.Duration `toml:"reconnect_interval"`
+	MaxBackoff           internal.Duration `toml:"max_backoff"`
+	MaxReconnectAttempts int               `toml:"max_reconnect_attempts"`
+
+	tls.ClientConfig
+
+	Log telegraf.Logger `toml:"-"`
+
+	acc        telegraf.Accumulator
+	client     *wsclient.Client
+	normalizer *marketdata.Normalizer
+	now        func() time.Time
+
+	// the order book of every pair, from its last snapshot
+	books     map[string]*book
+	booksLock sync.Mutex
+}
+
+// event is a message of the feed which is not channel data, e.g. the
+// answer to a subscription
+type event struct {
+	Event        string `json:"event"`
+	Status       string `json:"status"`
+	Pair         string `json:"pair"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+func (k *KrakenMarketData) SampleConfig() string {
+	return `
+## Kraken websocket api
+# service_address = "wss://ws.kraken.com"
+## Pairs to subscribe to, in the notation of the websocket api
+pairs = ["XBT/USD", "ETH/USD"]
+## Channels of every pair, any of "ticker", "trade" and "book"
+channels = ["ticker", "trade", "book"]
+## Levels of each side of the order book, one of 10, 25, 100, 500 or 1000
+# book_depth = 10
+## Validate the order book maintained from the book channel against the
+## checksum of every update, resubscribing to a fresh snapshot when it
+## diverged
+# validate_checksum = true
+## Emit the trades, tickers and books in the "native" schema of the plugin,
+## or the "normalized" schema shared by the exchange inputs
+# metric_schema = "native"
+## Ping the server every ping_interval and reconnect when neither a pong nor
+## a message was received for pong_wait. "0s" disables the keepalive.
+# ping_interval = "10s"
+# pong_wait = "20s"
+## Delay before reconnecting after the connection dropped, doubled after
+## every failed attempt up to max_backoff
+# reconnect_interval = "1s"
+# max_backoff = "1m"
+## Give up reconnecting after this many consecutive failed attempts, 0 retries
+## forever
+# max_reconnect_attempts = 0
+
+## Optional TLS Config
+# tls_ca = "/etc/telegraf/ca.pem"
+# tls_cert = "/etc/telegraf/cert.pem"
+# tls_key = "/etc/telegraf/key.pem"
+## Use TLS but skip chain & host verification
+# insecure_skip_verify = false
+`
+}
+
+func (k *KrakenMarketData) Description() string {
+	return "Receives the tickers, trades and order books of Kraken pairs from its websocket api"
+}
+
+// Gather emits the top of the order book of every pair
+func (k *KrakenMarketData) Gather(acc telegraf.Accumulator) error {
+	acc = k.normalizer.Accumulator(acc)
+
+	k.booksLock.Lock()
+	defer k.booksLock.Unlock()
+
+	now := k.now()
+	for pair, b := range k.books {
+		acc.AddFields("kraken_book", b.fields(), map[string]string{"pair": pair}, now)
+	}
+	return nil
+}
+
+func (k *KrakenMarketData) Init() error {
+	if len(k.Pairs) == 0 {
+		return fmt.Errorf("pairs must be set")
+	}
+	if len(k.Channels) == 0 {
+		return fmt.Errorf("channels must be set")
+	}
+	for _, channel := range k.Channels {
+		switch channel {
+		case channelTicker, channelTrade, channelBook:
+		default:
+			return fmt.Errorf("invalid channel %q, must be %q, %q or %q", channel, channelTicker, channelTrade, channelBook)
+		}
+	}
+	if !bookDepths[k.BookDepth] {
+		return fmt.Errorf("invalid book_depth %d, must be 10, 25, 100, 500 or 1000", k.BookDepth)
+	}
+
+	normalizer, err := marketdata.NewNormalizer(k.MetricSchema, marketdata.Normalizer{
+		Exchange: "kraken",
+		Measurements: map[string]string{
+			"kraken_ticker": marketdata.Ticker,
+			"kraken_trade":  marketdata.Trade,
+			"kraken_book":   marketdata.Book,
+		},
+		SymbolTag: "pair",
+		ParsePair: parsePair,
+	}, k.Pairs)
+	if err != nil {
+		return err
+	}
+	k.normalizer = normalizer
+
+	tlsCfg, err := k.ClientConfig.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	cfg := wsclient.Config{
+		URL: k.ServiceAddress,
+		Dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: wsclient.HandshakeTimeout,
+			TLSClientConfig:  tlsCfg,
+		},
+		PingInterval:         k.PingInterval.Duration,
+		PongWait:             k.PongWait.Duration,
+		ReconnectInterval:    k.ReconnectInterval.Duration,
+		MaxBackoff:           k.MaxBackoff.Duration,
+		MaxReconnectAttempts: k.MaxReconnectAttempts,
+		StatsName:            "kraken_marketdata",
+		StatsTags:            map[string]string{"address": k.ServiceAddress},
+		Log:                  k.Log,
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	k.client = wsclient.New(cfg, k)
+
+	return nil
+}
+
+func (k *KrakenMarketData) Start(acc telegraf.Accumulator) error {
+	k.acc = k.normalizer.Accumulator(acc)
+	return k.client.Start(k.acc)
+}
+
+func (k *KrakenMarketData) Stop() {
+	k.client.Stop()
+}
+
+// subscription returns the subscribe or unsubscribe message of a channel
+func (k *KrakenMarketData) subscription(event string, channel string, pairs []string) ([]byte, error) {
+	subscription := map[string]interface{}{"name": channel}
+	if channel == channelBook {
+		subscription["depth"] = k.BookDepth
+	}
+	return json.Marshal(map[string]interface{}{
+		"event":        event,
+		"pair":         pairs,
+		"subscription": subscription,
+	})
+}
+
+// Subscribe subscribes to every channel of the pairs on every connection.
+// The books start over from the snapshot sent upon subscribing.
+func (k *KrakenMarketData) Subscribe(conn *websocket.Conn) error {
+	k.booksLock.Lock()
+	k.books = make(map[string]*book)
+	k.booksLock.Unlock()
+
+	for _, channel := range k.Channels {
+		msg, err := k.subscription("subscribe", channel, k.Pairs)
+		if err != nil {
+			return err
+		}
+		k.Log.Debugf("Subscription Request: %s", msg)
+		if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handle emits the metrics of a message of the feed, which is either an
+// event object or channel data framed as an array of the channel id, one
+// or more payloads, the channel name and the pair
+func (k *KrakenMarketData) Handle(message []byte) {
+	k.Log.Debugf("recv: %s", message)
+
+	if bytes.HasPrefix(bytes.TrimSpace(message), []byte("{")) {
+		k.handleEvent(message)
+		return
+	}
+
+	var frame []json.RawMessage
+	if err := json.Unmarshal(message, &frame); err != nil {
+		k.acc.AddError(fmt.Errorf("unable to parse incoming msg: %s", err))
+		return
+	}
+	if len(frame) < 4 {
+		k.acc.AddError(fmt.Errorf("unexpected message of %d elements", len(frame)))
+		return
+	}
+
+	var channelName, pair string
+	if err := json.Unmarshal(frame[len(frame)-2], &channelName); err != nil {
+		k.acc.AddError(fmt.Errorf("unable to parse channel name: %s", err))
+		return
+	}
+	if err := json.Unmarshal(frame[len(frame)-1], &pair); err != nil {
+		k.acc.AddError(fmt.Errorf("unable to parse pair: %s", err))
+		return
+	}
+	payloads := frame[1 : len(frame)-2]
+
+	var err error
+	switch {
+	case channelName == channelTicker:
+		err = k.addTicker(pair, payloads[0])
+	case channelName == channelTrade:
+		err = k.addTrades(pair, payloads[0])
+	case strings.HasPrefix(channelName, channelBook):
+		err = k.updateBook(pair, payloads)
+	}
+	if err != nil {
+		k.acc.AddError(fmt.Errorf("unable to parse %s message of %s: %s", channelName, pair, err))
+	}
+}
+
+func (k *KrakenMarketData) handleEvent(message []byte) {
+	var e event
+	if err := json.Unmarshal(message, &e); err != nil {
+		k.acc.AddError(fmt.Errorf("unable to parse incoming msg: %s", err))
+		return
+	}
+	if e.Status == "error" || e.Event == "error" {
+		k.acc.AddError(fmt.Errorf("%s of %s failed: %s", e.Event, e.Pair, e.ErrorMessage))
+	}
+}
+
+// addTicker emits the ticker payload in the format of
+// {"a": [price, wholeLotVolume, lotVolume], "b": [...], "c": [price, lotVolume],
+// "v": [today, last24Hours], "p": [...], "t": [...], "l": [...], "h": [...], "o": [...]}
+func (k *KrakenMarketData) addTicker(pair string, payload []byte) error {
+	var ticker map[string][]interface{}
+	if err := json.Unmarshal(payload, &ticker); err != nil {
+		return err
+	}
+
+	fields := make(map[string]interface{})
+	for _, f := range []struct {
+		name  string
+		key   string
+		index int
+	}{
+		{"best_ask", "a", 0},
+		{"best_ask_size", "a", 2},
+		{"best_bid", "b", 0},
+		{"best_bid_size", "b", 2},
+		{"price", "c", 0},
+		{"last_size", "c", 1},
+		{"volume_24h", "v", 1},
+		{"vwap_24h", "p", 1},
+		{"trades_24h", "t", 1},
+		{"low_24h", "l", 1},
+		{"high_24h", "h", 1},
+		{"open_24h", "o", 1},
+	} {
+		values := ticker[f.key]
+		if f.index >= len(values) {
+			continue
+		}
+		switch v := values[f.index].(type) {
+		case string:
+			value, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %s", f.name, err)
+			}
+			fields[f.name] = value
+		case float64:
+			// the trade counts are numbers
+			fields[f.name] = int64(v)
+		}
+	}
+
+	k.acc.AddFields("kraken_ticker", fields, map[string]string{"pair": pair}, k.now())
+	return nil
+}
+
+// addTrades emits the trades of the trade payload, each in the format of
+// [price, volume, time, side, orderType, misc]
+func (k *KrakenMarketData) addTrades(pair string, payload []byte) error {
+	var trades [][]string
+	if err := json.Unmarshal(payload, &trades); err != nil {
+		return err
+	}
+
+	for _, t := range trades {
+		if len(t) < 5 {
+			return fmt.Errorf("trade of %d values, expected 6", len(t))
+		}
+		price, err := strconv.ParseFloat(t[0], 64)
+		if err != nil {
+			return err
+		}
+		size, err := strconv.ParseFloat(t[1], 64)
+		if err != nil {
+			return err
+		}
+		tm, err := parseTime(t[2])
+		if err != nil {
+			return err
+		}
+
+		side := "buy"
+		if t[3] == "s" {
+			side = "sell"
+		}
+		orderType := "market"
+		if t[4] == "l" {
+			orderType = "limit"
+		}
+
+		k.acc.AddFields("kraken_trade",
+			map[string]interface{}{
+				"price": price,
+				"size":  size,
+			},
+			map[string]string{
+				"pair":       pair,
+				"side":       side,
+				"order_type": orderType,
+			},
+			tm,
+		)
+	}
+	return nil
+}
+
+// parseTime converts the time of the feed, seconds since the epoch with a
+// fraction such as "1534614057.321597"
+func parseTime(s string) (time.Time, error) {
+	parts := strings.SplitN(s, ".", 2)
+	sec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var nsec int64
+	if len(parts) == 2 {
+		fraction := (parts[1] + "000000000")[:9]
+		if nsec, err = strconv.ParseInt(fraction, 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Unix(sec, nsec), nil
+}
+
+// assetAliases are the legacy asset codes of Kraken, e.g. of XBT/USD
+var assetAliases = map[string]string{
+	"XBT": "BTC",
+	"XDG": "DOGE",
+}
+
+// parsePair splits a pair such as XBT/USD, replacing the legacy asset codes
+// with their common ones
+func parsePair(symbol string) (marketdata.Pair, error) {
+	pair, err := marketdata.ParsePair(symbol)
+	if err != nil {
+		return pair, err
+	}
+	for _, currency := range []*string{&pair.Base, &pair.Quote} {
+		if alias, ok := assetAliases[*currency]; ok {
+			*currency = alias
+		}
+	}
+	return pair, nil
+}
+
+func newKrakenMarketData() *KrakenMarketData {
+	return &KrakenMarketData{
+		ServiceAddress:    defaultServiceAddress,
+		MetricSchema:      marketdata.SchemaNative,
+		BookDepth:         defaultBookDepth,
+		ValidateChecksum:  true,
+		PingInterval:      internal.Duration{Duration: wsclient.DefaultPingInterval},
+		PongWait:          internal.Duration{Duration: wsclient.DefaultPongWait},
+		ReconnectInterval: internal.Duration{Duration: wsclient.DefaultReconnectInterval},
+		MaxBackoff:        internal.Duration{Duration: wsclient.DefaultMaxBackoff},
+		now:               time.Now,
+		books:             make(map[string]*book),
+	}
+}
+
+func init() {
+	inputs.Add("kraken_marketdata", func() telegraf.Input { return newKrakenMarketData() })
+}
@@ -0,0 +1,233 @@
+package kraken_marketdata
+
+import (
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+const bookSnapshot = `[1234, {
+  "as": [["5541.30000", "2.50700000", "1534614248.123678"], ["5541.80000", "0.33000000", "1534614098.345543"]],
+  "bs": [["5541.20000", "1.52900000", "1534614248.765567"], ["5539.90000", "0.30000000", "1534614241.769870"]]
+}, "book-10", "XBT/USD"]`
+
+func newTestPlugin(t *testing.T) (*KrakenMarketData, *testutil.Accumulator) {
+	k := newKrakenMarketData()
+	k.Log = testutil.Logger{}
+	k.Pairs = []string{"XBT/USD"}
+	k.Channels = []string{"ticker", "trade", "book"}
+	k.now = func() time.Time { return now }
+	require.NoError(t, k.Init())
+
+	acc := &testutil.Accumulator{}
+	k.acc = acc
+	return k, acc
+}
+
+// bookUpdate returns an update of the ask at 5541.30000 carrying the
+// checksum of the resulting book, or of a corrupt one
+func bookUpdate(volume string, corrupt bool) string {
+	checksum := crc32.ChecksumIEEE([]byte(
+		"554130000" + strings.TrimLeft(strings.Replace(volume, ".", "", 1), "0") + "554180000" + "33000000" +
+			"554120000" + "152900000" + "553990000" + "30000000"))
+	if corrupt {
+		checksum++
+	}
+	return fmt.Sprintf(`[1234, {"a": [["5541.30000", "%s", "1534614248.456738"]], "c": "%d"}, "book-10", "XBT/USD"]`, volume, checksum)
+}
+
+func TestTicker(t *testing.T) {
+	k, acc := newTestPlugin(t)
+
+	k.Handle([]byte(`[340, {
+  "a": ["5525.40000", 1, "1.000"],
+  "b": ["5525.10000", 1, "1.000"],
+  "c": ["5525.10000", "0.00398963"],
+  "v": ["2634.11501494", "3591.17907851"],
+  "p": ["5631.44067", "5653.78939"],
+  "t": [11493, 16267],
+  "l": ["5505.00000", "5505.00000"],
+  "h": ["5783.00000", "5783.00000"],
+  "o": ["5760.70000", "5763.40000"]
+}, "ticker", "XBT/USD"]`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("kraken_ticker",
+			map[string]string{"pair": "XBT/USD"},
+			map[string]interface{}{
+				"best_ask":      5525.4,
+				"best_ask_size": 1.0,
+				"best_bid":      5525.1,
+				"best_bid_size": 1.0,
+				"price":         5525.1,
+				"last_size":     0.00398963,
+				"volume_24h":    3591.17907851,
+				"vwap_24h":      5653.78939,
+				"trades_24h":    int64(16267),
+				"low_24h":       5505.0,
+				"high_24h":      5783.0,
+				"open_24h":      5763.4,
+			},
+			now,
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestTrades(t *testing.T) {
+	k, acc := newTestPlugin(t)
+
+	k.Handle([]byte(`[337, [
+  ["5541.20000", "0.15850568", "1534614057.321597", "s", "l", ""],
+  ["6060.00000", "0.02455000", "1534614057.324998", "b", "m", ""]
+], "trade", "XBT/USD"]`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("kraken_trade",
+			map[string]string{"pair": "XBT/USD", "side": "sell", "order_type": "limit"},
+			map[string]interface{}{"price": 5541.2, "size": 0.15850568},
+			time.Unix(1534614057, 321597000),
+		),
+		testutil.MustMetric("kraken_trade",
+			map[string]string{"pair": "XBT/USD", "side": "buy", "order_type": "market"},
+			map[string]interface{}{"price": 6060.0, "size": 0.02455},
+			time.Unix(1534614057, 324998000),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestBookChecksum(t *testing.T) {
+	k, acc := newTestPlugin(t)
+
+	// updates before the snapshot are ignored
+	k.Handle([]byte(bookUpdate("1.00000000", false)))
+	require.NoError(t, k.Gather(acc))
+	require.Zero(t, acc.NMetrics())
+
+	k.Handle([]byte(bookSnapshot))
+	k.Handle([]byte(bookUpdate("1.00000000", false)))
+	require.NoError(t, acc.FirstError())
+	require.False(t, acc.HasMeasurement("kraken_checksum_mismatch"))
+
+	require.NoError(t, k.Gather(acc))
+	m, ok := acc.Get("kraken_book")
+	require.True(t, ok)
+	require.Equal(t, map[string]string{"pair": "XBT/USD"}, m.Tags)
+	require.Equal(t, 5541.2, m.Fields["best_bid"])
+	require.Equal(t, 1.529, m.Fields["best_bid_size"])
+	require.Equal(t, 5541.3, m.Fields["best_ask"])
+	require.Equal(t, 1.0, m.Fields["best_ask_size"])
+	require.InDelta(t, 0.1, m.Fields["spread"], 1e-9)
+	require.InDelta(t, 5541.25, m.Fields["mid_price"], 1e-9)
+	require.InDelta(t, 1.829, m.Fields["bid_depth"], 1e-9)
+	require.InDelta(t, 1.33, m.Fields["ask_depth"], 1e-9)
+}
+
+func TestBookTruncatedToDepth(t *testing.T) {
+	k, _ := newTestPlugin(t)
+	k.BookDepth = 1
+
+	k.Handle([]byte(bookSnapshot))
+	k.Handle([]byte(`[1234, {"b": [["5541.25000", "0.10000000", "1534614248.456738"]]}, "book-10", "XBT/USD"]`))
+
+	b := k.books["XBT/USD"]
+	require.Len(t, b.bids, 1)
+	require.Contains(t, b.bids, "5541.25000")
+}
+
+func TestBookResyncsOnChecksumMismatch(t *testing.T) {
+	subscriptions := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for i := 0; ; i++ {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			subscriptions <- string(msg)
+			if i == 0 {
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(bookSnapshot))
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(bookUpdate("1.00000000", true)))
+			}
+		}
+	}))
+	defer ts.Close()
+
+	k := newKrakenMarketData()
+	k.Log = testutil.Logger{}
+	k.ServiceAddress = "ws" + strings.TrimPrefix(ts.URL, "http")
+	k.Pairs = []string{"XBT/USD"}
+	k.Channels = []string{"book"}
+	require.NoError(t, k.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, k.Start(acc))
+	acc.Wait(1)
+	require.JSONEq(t, `{"event": "subscribe", "pair": ["XBT/USD"], "subscription": {"name": "book", "depth": 10}}`, <-subscriptions)
+	require.JSONEq(t, `{"event": "unsubscribe", "pair": ["XBT/USD"], "subscription": {"name": "book", "depth": 10}}`, <-subscriptions)
+	require.JSONEq(t, `{"event": "subscribe", "pair": ["XBT/USD"], "subscription": {"name": "book", "depth": 10}}`, <-subscriptions)
+	k.Stop()
+
+	require.True(t, acc.HasMeasurement("kraken_checksum_mismatch"))
+	require.NoError(t, acc.FirstError())
+}
+
+func TestSubscriptionError(t *testing.T) {
+	k, acc := newTestPlugin(t)
+
+	k.Handle([]byte(`{"event": "heartbeat"}`))
+	require.NoError(t, acc.FirstError())
+
+	k.Handle([]byte(`{"errorMessage": "Currency pair not supported XBT/USDX", "event": "subscriptionStatus", "pair": "XBT/USDX", "status": "error", "subscription": {"name": "ticker"}}`))
+	require.EqualError(t, acc.FirstError(), "subscriptionStatus of XBT/USDX failed: Currency pair not supported XBT/USDX")
+}
+
+func TestNormalizedSchema(t *testing.T) {
+	k, acc := newTestPlugin(t)
+	k.MetricSchema = marketdata.SchemaNormalized
+	require.NoError(t, k.Init())
+	k.acc = k.normalizer.Accumulator(acc)
+
+	k.Handle([]byte(`[337, [["5541.20000", "0.15850568", "1534614057.321597", "s", "l", ""]], "trade", "XBT/USD"]`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("market_trade",
+			map[string]string{"exchange": "kraken", "base": "BTC", "quote": "USD", "side": "sell", "order_type": "limit"},
+			map[string]interface{}{"price": 5541.2, "size": 0.15850568},
+			time.Unix(1534614057, 321597000),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestInit(t *testing.T) {
+	k := newKrakenMarketData()
+	k.Channels = []string{"ticker"}
+	require.Error(t, k.Init())
+
+	k.Pairs = []string{"XBT/USD"}
+	k.Channels = []string{"ohlc"}
+	require.Error(t, k.Init())
+
+	k.Channels = []string{"book"}
+	k.BookDepth = 50
+	require.Error(t, k.Init())
+}
@@ -0,0 +1,208 @@
+package kucoin_marketdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// book is the order book of a symbol, the size of every price level of each
+// side, and the sequence of its last change
+type book struct {
+	bids     map[float64]float64
+	asks     map[float64]float64
+	sequence int64
+}
+
+// l2Update is the data of the level2 topic, the changes in the format of
+// [price, size, sequence]
+type l2Update struct {
+	Changes struct {
+		Asks [][]string `json:"asks"`
+		Bids [][]string `json:"bids"`
+	} `json:"changes"`
+	SequenceStart int64 `json:"sequenceStart"`
+	SequenceEnd   int64 `json:"sequenceEnd"`
+}
+
+// snapshot is the answer of the REST api to the request of a book, the
+// levels in the format of [price, size]
+type snapshot struct {
+	Code string `json:"code"`
+	Msg  string `json:"msg"`
+	Data struct {
+		Sequence json.Number `json:"sequence"`
+		Bids     [][]string  `json:"bids"`
+		Asks     [][]string  `json:"asks"`
+	} `json:"data"`
+}
+
+// fetchBook requests the top 100 levels of each side of the book of symbol
+// from the REST api
+func (k *KuCoinMarketData) fetchBook(symbol string) (*book, error) {
+	u := fmt.Sprintf("%s/api/v1/market/orderbook/level2_100?symbol=%s", k.RestAddress, url.QueryEscape(symbol))
+	resp, err := k.httpClient.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", u, resp.Status)
+	}
+
+	var s snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return nil, fmt.Errorf("decoding %s: %s", u, err)
+	}
+	if s.Code != codeSuccess {
+		return nil, fmt.Errorf("fetching %s: error %s: %s", u, s.Code, s.Msg)
+	}
+
+	sequence, err := s.Data.Sequence.Int64()
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %s", u, err)
+	}
+	b := &book{bids: make(map[float64]float64), asks: make(map[float64]float64), sequence: sequence}
+	if err := apply(b.bids, s.Data.Bids, 0); err != nil {
+		return nil, err
+	}
+	if err := apply(b.asks, s.Data.Asks, 0); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// apply updates a side of the book with the changes of a sequence after
+// sequence, the levels of a snapshot having none, removing the levels of a
+// zero size. A change of a zero price only advances the sequence.
+func apply(side map[float64]float64, changes [][]string, sequence int64) error {
+	for _, c := range changes {
+		if len(c) < 2 {
+			return fmt.Errorf("change of %d values, expected 3", len(c))
+		}
+		if len(c) > 2 {
+			seq, err := strconv.ParseInt(c[2], 10, 64)
+			if err != nil {
+				return err
+			}
+			if seq <= sequence {
+				continue
+			}
+		}
+
+		price, err := strconv.ParseFloat(c[0], 64)
+		if err != nil {
+			return err
+		}
+		size, err := strconv.ParseFloat(c[1], 64)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case price == 0:
+		case size == 0:
+			delete(side, price)
+		default:
+			side[price] = size
+		}
+	}
+	return nil
+}
+
+// updateBook applies an update of the level2 topic to the book of symbol.
+// The book is fetched from the REST api upon the first update, and fetched
+// anew when updates were missed: the updates received meanwhile wait in the
+// connection, and those preceding the snapshot are skipped.
+func (k *KuCoinMarketData) updateBook(symbol string, data []byte) error {
+	var u l2Update
+	if err := json.Unmarshal(data, &u); err != nil {
+		return err
+	}
+
+	// only the read loop changes the books, the lock guarding them from
+	// Gather isn't held while fetching
+	k.booksLock.Lock()
+	b, ok := k.books[symbol]
+	k.booksLock.Unlock()
+
+	if ok && u.SequenceStart > b.sequence+1 {
+		k.Log.Warnf("Missed the updates of %s from sequence %d to %d, fetching the book", symbol, b.sequence+1, u.SequenceStart-1)
+		ok = false
+	}
+	if !ok {
+		var err error
+		if b, err = k.fetchBook(symbol); err != nil {
+			k.booksLock.Lock()
+			delete(k.books, symbol)
+			k.booksLock.Unlock()
+			return err
+		}
+	}
+
+	k.booksLock.Lock()
+	defer k.booksLock.Unlock()
+	k.books[symbol] = b
+
+	if u.SequenceEnd <= b.sequence {
+		return nil
+	}
+	if err := apply(b.bids, u.Changes.Bids, b.sequence); err != nil {
+		return err
+	}
+	if err := apply(b.asks, u.Changes.Asks, b.sequence); err != nil {
+		return err
+	}
+	b.sequence = u.SequenceEnd
+	return nil
+}
+
+// fields returns the top of the book and the total size of each side
+func (b *book) fields() map[string]interface{} {
+	bids, asks := sortedPrices(b.bids, true), sortedPrices(b.asks, false)
+
+	fields := map[string]interface{}{
+		"bid_depth": totalSize(b.bids),
+		"ask_depth": totalSize(b.asks),
+	}
+	if len(bids) > 0 {
+		fields["best_bid"] = bids[0]
+		fields["best_bid_size"] = b.bids[bids[0]]
+	}
+	if len(asks) > 0 {
+		fields["best_ask"] = asks[0]
+		fields["best_ask_size"] = b.asks[asks[0]]
+	}
+	if len(bids) > 0 && len(asks) > 0 {
+		fields["spread"] = asks[0] - bids[0]
+		fields["mid_price"] = (asks[0] + bids[0]) / 2
+	}
+	return fields
+}
+
+// sortedPrices returns the prices of a side from the best, the highest bid
+// or the lowest ask
+func sortedPrices(side map[float64]float64, descending bool) []float64 {
+	prices := make([]float64, 0, len(side))
+	for price := range side {
+		prices = append(prices, price)
+	}
+	if descending {
+		sort.Sort(sort.Reverse(sort.Float64Slice(prices)))
+	} else {
+		sort.Float64s(prices)
+	}
+	return prices
+}
+
+func totalSize(side map[float64]float64) float64 {
+	var total float64
+	for _, size := range side {
+		total += size
+	}
+	return total
+}
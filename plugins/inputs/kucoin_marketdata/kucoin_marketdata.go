@@ -0,0 +1,382 @@
+package kucoin_marketdata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/wsclient"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/plugins/common/tls"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const (
+	defaultRestAddress = "https://api.kucoin.com"
+	restTimeout        = 10 * time.Second
+
+	// the code of the successful answers of the REST api
+	codeSuccess = "200000"
+
+	channelTicker = "ticker"
+	channelLevel2 = "level2"
+)
+
+// the topic of every channel, followed by the symbols
+var channelTopics = map[string]string{
+	channelTicker: "/market/ticker",
+	channelLevel2: "/market/level2",
+}
+
+type KuCoinMarketData struct {
+	RestAddress  string   `toml:"rest_address"`
+	Symbols      []string `toml:"symbols"`
+	Channels     []string `toml:"channels"`
+	MetricSchema string   `toml:"metric_schema"`
+
+	ReconnectInterval    internal.Duration `toml:"reconnect_interval"`
+	MaxBackoff           internal.Duration `toml:"max_backoff"`
+	MaxReconnectAttempts int               `toml:"max_reconnect_attempts"`
+
+	tls.ClientConfig
+
+	Log telegraf.Logger `toml:"-"`
+
+	acc        telegraf.Accumulator
+	client     *wsclient.Client
+	normalizer *marketdata.Normalizer
+	httpClient *http.Client
+	now        func() time.Time
+
+	// the order book of every symbol, sides keyed by price
+	books     map[string]*book
+	booksLock sync.Mutex
+}
+
+// message is either a message of the connection, e.g. welcome, ack or pong,
+// or the data of a topic when of the type message
+type message struct {
+	Id      string          `json:"id"`
+	Type    string          `json:"type"`
+	Code    json.Number     `json:"code"`
+	Topic   string          `json:"topic"`
+	Subject string          `json:"subject"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type ticker struct {
+	Price       string `json:"price"`
+	Size        string `json:"size"`
+	BestBid     string `json:"bestBid"`
+	BestBidSize string `json:"bestBidSize"`
+	BestAsk     string `json:"bestAsk"`
+	BestAskSize string `json:"bestAskSize"`
+	Time        int64  `json:"time"`
+}
+
+func (k *KuCoinMarketData) SampleConfig() string {
+	return `
+## KuCoin REST api, handing out the token and the websocket endpoint to
+## connect to
+# rest_address = "https://api.kucoin.com"
+## Symbols to subscribe to
+symbols = ["BTC-USDT", "ETH-USDT"]
+## Channels of every symbol, any of "ticker" and "level2"
+channels = ["ticker", "level2"]
+## Emit the trades, tickers and books in the "native" schema of the plugin,
+## or the "normalized" schema shared by the exchange inputs
+# metric_schema = "native"
+## Delay before reconnecting after the connection dropped, doubled after
+## every failed attempt up to max_backoff
+# reconnect_interval = "1s"
+# max_backoff = "1m"
+## Give up reconnecting after this many consecutive failed attempts, 0 retries
+## forever
+# max_reconnect_attempts = 0
+
+## Optional TLS Config
+# tls_ca = "/etc/telegraf/ca.pem"
+# tls_cert = "/etc/telegraf/cert.pem"
+# tls_key = "/etc/telegraf/key.pem"
+## Use TLS but skip chain & host verification
+# insecure_skip_verify = false
+`
+}
+
+func (k *KuCoinMarketData) Description() string {
+	return "Receives the tickers and order books of KuCoin symbols from its websocket api"
+}
+
+// Gather emits the top of the order book of every symbol
+func (k *KuCoinMarketData) Gather(acc telegraf.Accumulator) error {
+	acc = k.normalizer.Accumulator(acc)
+
+	k.booksLock.Lock()
+	defer k.booksLock.Unlock()
+
+	now := k.now()
+	for symbol, bk := range k.books {
+		acc.AddFields("kucoin_book", bk.fields(), map[string]string{"symbol": symbol}, now)
+	}
+	return nil
+}
+
+func (k *KuCoinMarketData) Init() error {
+	if len(k.Symbols) == 0 {
+		return fmt.Errorf("symbols must be set")
+	}
+	if len(k.Channels) == 0 {
+		return fmt.Errorf("channels must be set")
+	}
+	for _, channel := range k.Channels {
+		if _, ok := channelTopics[channel]; !ok {
+			return fmt.Errorf("invalid channel %q, must be %q or %q", channel, channelTicker, channelLevel2)
+		}
+	}
+
+	normalizer, err := marketdata.NewNormalizer(k.MetricSchema, marketdata.Normalizer{
+		Exchange: "kucoin",
+		Measurements: map[string]string{
+			"kucoin_ticker": marketdata.Ticker,
+			"kucoin_book":   marketdata.Book,
+		},
+		SymbolTag: "symbol",
+	}, k.Symbols)
+	if err != nil {
+		return err
+	}
+	k.normalizer = normalizer
+
+	tlsCfg, err := k.ClientConfig.TLSConfig()
+	if err != nil {
+		return err
+	}
+	k.httpClient = &http.Client{
+		Timeout: restTimeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsCfg,
+		},
+	}
+
+	cfg := wsclient.Config{
+		URL:     k.RestAddress,
+		Resolve: k.resolve,
+		Dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: wsclient.HandshakeTimeout,
+			TLSClientConfig:  tlsCfg,
+		},
+		PingMessage:          []byte(`{"id":"ping","type":"ping"}`),
+		ReconnectInterval:    k.ReconnectInterval.Duration,
+		MaxBackoff:           k.MaxBackoff.Duration,
+		MaxReconnectAttempts: k.MaxReconnectAttempts,
+		StatsName:            "kucoin_marketdata",
+		StatsTags:            map[string]string{"address": k.RestAddress},
+		Log:                  k.Log,
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	k.client = wsclient.New(cfg, k)
+
+	return nil
+}
+
+func (k *KuCoinMarketData) Start(acc telegraf.Accumulator) error {
+	k.acc = k.normalizer.Accumulator(acc)
+	return k.client.Start(k.acc)
+}
+
+func (k *KuCoinMarketData) Stop() {
+	k.client.Stop()
+}
+
+// bullet is the answer of the bullet-public endpoint, the token to connect
+// with and the servers to connect to
+type bullet struct {
+	Code string `json:"code"`
+	Msg  string `json:"msg"`
+	Data struct {
+		Token           string `json:"token"`
+		InstanceServers []struct {
+			Endpoint     string `json:"endpoint"`
+			Protocol     string `json:"protocol"`
+			PingInterval int64  `json:"pingInterval"`
+			PingTimeout  int64  `json:"pingTimeout"`
+		} `json:"instanceServers"`
+	} `json:"data"`
+}
+
+// resolve requests a token and a server to connect to before every
+// connection, the server expecting a ping every pingInterval and closing
+// the connection when none was received for a further pingTimeout
+func (k *KuCoinMarketData) resolve(ctx context.Context) (wsclient.Endpoint, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.RestAddress+"/api/v1/bullet-public", nil)
+	if err != nil {
+		return wsclient.Endpoint{}, err
+	}
+	resp, err := k.httpClient.Do(req)
+	if err != nil {
+		return wsclient.Endpoint{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return wsclient.Endpoint{}, wsclient.HandshakeError(fmt.Errorf("requesting token: %s", resp.Status), resp)
+	}
+
+	var b bullet
+	if err := json.NewDecoder(resp.Body).Decode(&b); err != nil {
+		return wsclient.Endpoint{}, fmt.Errorf("decoding token: %s", err)
+	}
+	if b.Code != codeSuccess {
+		return wsclient.Endpoint{}, fmt.Errorf("requesting token: error %s: %s", b.Code, b.Msg)
+	}
+	if b.Data.Token == "" || len(b.Data.InstanceServers) == 0 {
+		return wsclient.Endpoint{}, fmt.Errorf("requesting token: no token or server")
+	}
+
+	server := b.Data.InstanceServers[0]
+	u, err := url.Parse(server.Endpoint)
+	if err != nil {
+		return wsclient.Endpoint{}, fmt.Errorf("invalid endpoint %q: %s", server.Endpoint, err)
+	}
+	query := u.Query()
+	query.Set("token", b.Data.Token)
+	query.Set("connectId", strconv.FormatInt(k.now().UnixNano(), 10))
+	u.RawQuery = query.Encode()
+
+	pingInterval := time.Duration(server.PingInterval) * time.Millisecond
+	return wsclient.Endpoint{
+		URL:          u.String(),
+		PingInterval: pingInterval,
+		PongWait:     pingInterval + time.Duration(server.PingTimeout)*time.Millisecond,
+	}, nil
+}
+
+// Subscribe awaits the welcome message, before which the server ignores
+// subscriptions, then subscribes to the topic of every channel for all the
+// symbols at once. The books are fetched anew upon the first update.
+func (k *KuCoinMarketData) Subscribe(conn *websocket.Conn) error {
+	k.booksLock.Lock()
+	k.books = make(map[string]*book)
+	k.booksLock.Unlock()
+
+	_ = conn.SetReadDeadline(time.Now().Add(wsclient.HandshakeTimeout))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return fmt.Errorf("awaiting welcome: %w", err)
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	var welcome message
+	if err := json.Unmarshal(data, &welcome); err != nil || welcome.Type != "welcome" {
+		return fmt.Errorf("expected welcome, received: %s", data)
+	}
+
+	for _, channel := range k.Channels {
+		topic := channelTopics[channel] + ":" + strings.Join(k.Symbols, ",")
+		msg, err := json.Marshal(map[string]interface{}{
+			"id":             channel,
+			"type":           "subscribe",
+			"topic":          topic,
+			"privateChannel": false,
+			"response":       true,
+		})
+		if err != nil {
+			return err
+		}
+		k.Log.Debugf("Subscription Request: %s", msg)
+		if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handle emits the metrics of a message of the feed
+func (k *KuCoinMarketData) Handle(data []byte) {
+	k.Log.Debugf("recv: %s", data)
+
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		k.acc.AddError(fmt.Errorf("unable to parse incoming msg: %s", err))
+		return
+	}
+
+	switch msg.Type {
+	case "error":
+		k.acc.AddError(fmt.Errorf("server error %s: %s", msg.Code, msg.Data))
+		return
+	case "message":
+	default:
+		// welcome, ack and pong
+		return
+	}
+
+	var err error
+	symbol := msg.Topic[strings.LastIndex(msg.Topic, ":")+1:]
+	switch msg.Subject {
+	case "trade.ticker":
+		err = k.addTicker(symbol, msg.Data)
+	case "trade.l2update":
+		err = k.updateBook(symbol, msg.Data)
+	}
+	if err != nil {
+		k.acc.AddError(fmt.Errorf("unable to parse %s message: %s", msg.Topic, err))
+	}
+}
+
+func (k *KuCoinMarketData) addTicker(symbol string, data []byte) error {
+	var t ticker
+	if err := json.Unmarshal(data, &t); err != nil {
+		return err
+	}
+
+	fields := make(map[string]interface{})
+	for name, value := range map[string]string{
+		"price":         t.Price,
+		"last_size":     t.Size,
+		"best_bid":      t.BestBid,
+		"best_bid_size": t.BestBidSize,
+		"best_ask":      t.BestAsk,
+		"best_ask_size": t.BestAskSize,
+	} {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		fields[name] = f
+	}
+
+	k.acc.AddFields("kucoin_ticker", fields,
+		map[string]string{"symbol": symbol},
+		time.Unix(0, t.Time*int64(time.Millisecond)),
+	)
+	return nil
+}
+
+func newKuCoinMarketData() *KuCoinMarketData {
+	return &KuCoinMarketData{
+		RestAddress:       defaultRestAddress,
+		MetricSchema:      marketdata.SchemaNative,
+		ReconnectInterval: internal.Duration{Duration: wsclient.DefaultReconnectInterval},
+		MaxBackoff:        internal.Duration{Duration: wsclient.DefaultMaxBackoff},
+		now:               time.Now,
+		books:             make(map[string]*book),
+	}
+}
+
+func init() {
+	inputs.Add("kucoin_marketdata", func() telegraf.Input { return newKuCoinMarketData() })
+}
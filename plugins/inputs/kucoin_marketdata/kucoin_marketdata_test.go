@@ -0,0 +1,247 @@
+package kucoin_marketdata
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/wsclient"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+const bookSnapshot = `{"code": "200000", "data": {"time": 1663747970000, "sequence": "14103843", "bids": [["18091.1", "0.25"], ["18090", "1"]], "asks": [["18906", "0.5"], ["18910", "2"]]}}`
+
+func newTestPlugin(t *testing.T) (*KuCoinMarketData, *testutil.Accumulator) {
+	k := newKuCoinMarketData()
+	k.Log = testutil.Logger{}
+	k.Symbols = []string{"BTC-USDT"}
+	k.Channels = []string{"ticker", "level2"}
+	k.now = func() time.Time { return now }
+	require.NoError(t, k.Init())
+
+	acc := &testutil.Accumulator{}
+	k.acc = acc
+	return k, acc
+}
+
+// newRestServer serves the book snapshot, counting the requests
+func newRestServer(t *testing.T, requests *int32) string {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/market/orderbook/level2_100" || r.URL.Query().Get("symbol") != "BTC-USDT" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		atomic.AddInt32(requests, 1)
+		_, _ = w.Write([]byte(bookSnapshot))
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func TestTicker(t *testing.T) {
+	k, acc := newTestPlugin(t)
+
+	k.Handle([]byte(`{"type": "message", "topic": "/market/ticker:BTC-USDT", "subject": "trade.ticker", "data": {"sequence": "1545896668986", "price": "0.08", "size": "0.011", "bestAsk": "0.08", "bestAskSize": "0.18", "bestBid": "0.049", "bestBidSize": "0.036", "time": 1704873323416}}`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("kucoin_ticker",
+			map[string]string{"symbol": "BTC-USDT"},
+			map[string]interface{}{
+				"price":         0.08,
+				"last_size":     0.011,
+				"best_bid":      0.049,
+				"best_bid_size": 0.036,
+				"best_ask":      0.08,
+				"best_ask_size": 0.18,
+			},
+			time.Unix(0, 1704873323416*int64(time.Millisecond)),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestBook(t *testing.T) {
+	var requests int32
+	k, acc := newTestPlugin(t)
+	k.RestAddress = newRestServer(t, &requests)
+
+	// the first update fetches the book, which already includes it
+	k.Handle([]byte(`{"type": "message", "topic": "/market/level2:BTC-USDT", "subject": "trade.l2update", "data": {"changes": {"asks": [], "bids": [["18091.1", "0.25", "14103843"]]}, "sequenceEnd": 14103843, "sequenceStart": 14103843, "symbol": "BTC-USDT", "time": 1663747970273}}`))
+	// the changes up to the sequence of the book are skipped
+	k.Handle([]byte(`{"type": "message", "topic": "/market/level2:BTC-USDT", "subject": "trade.l2update", "data": {"changes": {"asks": [["18906", "0", "14103844"], ["18905", "0.75", "14103845"]], "bids": [["18091.1", "9", "14103843"], ["0", "0", "14103846"]]}, "sequenceEnd": 14103846, "sequenceStart": 14103843, "symbol": "BTC-USDT", "time": 1663747970373}}`))
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	require.NoError(t, k.Gather(acc))
+	bid, ask := 18091.1, 18905.0
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("kucoin_book",
+			map[string]string{"symbol": "BTC-USDT"},
+			map[string]interface{}{
+				"best_bid":      18091.1,
+				"best_bid_size": 0.25,
+				"best_ask":      18905.0,
+				"best_ask_size": 0.75,
+				"spread":        ask - bid,
+				"mid_price":     (ask + bid) / 2,
+				"bid_depth":     1.25,
+				"ask_depth":     2.75,
+			},
+			now,
+		),
+	}, acc.GetTelegrafMetrics())
+
+	// missed updates fetch the book anew
+	k.Handle([]byte(`{"type": "message", "topic": "/market/level2:BTC-USDT", "subject": "trade.l2update", "data": {"changes": {"asks": [], "bids": [["18091.1", "0", "14103850"]]}, "sequenceEnd": 14103850, "sequenceStart": 14103850, "symbol": "BTC-USDT", "time": 1663747970473}}`))
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+func TestBookFetchError(t *testing.T) {
+	var requests int32
+	k, acc := newTestPlugin(t)
+	k.RestAddress = newRestServer(t, &requests)
+	k.Symbols = []string{"BTC-USDX"}
+
+	k.Handle([]byte(`{"type": "message", "topic": "/market/level2:BTC-USDX", "subject": "trade.l2update", "data": {"changes": {"asks": [], "bids": [["18091.1", "0.25", "14103843"]]}, "sequenceEnd": 14103843, "sequenceStart": 14103843, "symbol": "BTC-USDX", "time": 1663747970273}}`))
+	require.Error(t, acc.FirstError())
+	require.Contains(t, acc.FirstError().Error(), "404 Not Found")
+
+	require.NoError(t, k.Gather(acc))
+	require.False(t, acc.HasMeasurement("kucoin_book"))
+}
+
+func TestServerError(t *testing.T) {
+	k, acc := newTestPlugin(t)
+
+	k.Handle([]byte(`{"id": "ticker", "type": "ack"}`))
+	k.Handle([]byte(`{"id": "ping", "type": "pong"}`))
+	require.NoError(t, acc.FirstError())
+	require.Empty(t, acc.GetTelegrafMetrics())
+
+	k.Handle([]byte(`{"id": "level2", "type": "error", "code": 404, "data": "topic /market/level2:BTC-USDX is not found"}`))
+	require.Error(t, acc.FirstError())
+	require.Contains(t, acc.FirstError().Error(), "server error 404")
+}
+
+func TestConnectsWithToken(t *testing.T) {
+	msgs := make(chan map[string]interface{}, 10)
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/bullet-public":
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			endpoint := "ws" + strings.TrimPrefix(ts.URL, "http") + "/endpoint"
+			_, _ = w.Write([]byte(`{"code": "200000", "data": {"token": "2neAiuYvAU61ZD", "instanceServers": [{"endpoint": "` + endpoint + `", "encrypt": true, "protocol": "websocket", "pingInterval": 50, "pingTimeout": 1000}]}}`))
+		case "/endpoint":
+			if r.URL.Query().Get("token") != "2neAiuYvAU61ZD" || r.URL.Query().Get("connectId") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"id": "hQvf8jkno", "type": "welcome"}`))
+			for {
+				_, msg, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				var m map[string]interface{}
+				if json.Unmarshal(msg, &m) != nil {
+					return
+				}
+				select {
+				case msgs <- m:
+				default:
+				}
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	k := newKuCoinMarketData()
+	k.Log = testutil.Logger{}
+	k.RestAddress = ts.URL
+	k.Symbols = []string{"BTC-USDT", "ETH-USDT"}
+	k.Channels = []string{"ticker", "level2"}
+	require.NoError(t, k.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, k.Start(acc))
+	defer k.Stop()
+
+	require.Equal(t, map[string]interface{}{"id": "ticker", "type": "subscribe", "topic": "/market/ticker:BTC-USDT,ETH-USDT", "privateChannel": false, "response": true}, <-msgs)
+	require.Equal(t, map[string]interface{}{"id": "level2", "type": "subscribe", "topic": "/market/level2:BTC-USDT,ETH-USDT", "privateChannel": false, "response": true}, <-msgs)
+	// pinged every pingInterval of the server
+	require.Equal(t, map[string]interface{}{"id": "ping", "type": "ping"}, <-msgs)
+	require.NoError(t, acc.FirstError())
+}
+
+func TestTokenRejected(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	k := newKuCoinMarketData()
+	k.Log = testutil.Logger{}
+	k.RestAddress = ts.URL
+	k.Symbols = []string{"BTC-USDT"}
+	k.Channels = []string{"ticker"}
+	require.NoError(t, k.Init())
+
+	err := k.Start(&testutil.Accumulator{})
+	require.Error(t, err)
+	require.True(t, errors.Is(err, wsclient.ErrHandshakeRejected))
+}
+
+func TestNormalizedSchema(t *testing.T) {
+	k, acc := newTestPlugin(t)
+	k.MetricSchema = marketdata.SchemaNormalized
+	require.NoError(t, k.Init())
+	k.acc = k.normalizer.Accumulator(acc)
+
+	k.Handle([]byte(`{"type": "message", "topic": "/market/ticker:BTC-USDT", "subject": "trade.ticker", "data": {"sequence": "1545896668986", "price": "0.08", "size": "0.011", "bestAsk": "0.08", "bestAskSize": "0.18", "bestBid": "0.049", "bestBidSize": "0.036", "time": 1704873323416}}`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("market_ticker",
+			map[string]string{"exchange": "kucoin", "base": "BTC", "quote": "USDT"},
+			map[string]interface{}{"price": 0.08, "bid": 0.049, "bid_size": 0.036, "ask": 0.08, "ask_size": 0.18},
+			time.Unix(0, 1704873323416*int64(time.Millisecond)),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestInit(t *testing.T) {
+	k := newKuCoinMarketData()
+	k.Channels = []string{"ticker"}
+	require.Error(t, k.Init())
+
+	k.Symbols = []string{"BTC-USDT"}
+	k.Channels = []string{"match"}
+	require.Error(t, k.Init())
+
+	k.Channels = []string{"level2"}
+	require.NoError(t, k.Init())
+}
@@ -0,0 +1,164 @@
+package okx_marketdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// book is the order book of an instrument, the size of every price level
+// of each side, and the seqId of its last update
+type book struct {
+	bids  map[float64]float64
+	asks  map[float64]float64
+	seqId int64
+}
+
+// bookData is the data of the books channel, the levels in the format of
+// [price, size, deprecated, orders]
+type bookData struct {
+	Asks      [][]string `json:"asks"`
+	Bids      [][]string `json:"bids"`
+	SeqId     int64      `json:"seqId"`
+	PrevSeqId int64      `json:"prevSeqId"`
+}
+
+// apply updates a side of the book with levels, removing the levels of a
+// zero size
+func apply(side map[float64]float64, levels [][]string) error {
+	for _, l := range levels {
+		if len(l) < 2 {
+			return fmt.Errorf("level of %d values, expected 4", len(l))
+		}
+		price, err := strconv.ParseFloat(l[0], 64)
+		if err != nil {
+			return err
+		}
+		size, err := strconv.ParseFloat(l[1], 64)
+		if err != nil {
+			return err
+		}
+
+		if size == 0 {
+			delete(side, price)
+		} else {
+			side[price] = size
+		}
+	}
+	return nil
+}
+
+// updateBook applies the snapshot or update of the books channel to the
+// book of instId. An update whose prevSeqId is not the seqId of the last
+// one means updates were missed, and the book is resubscribed to.
+func (o *OKXMarketData) updateBook(instId string, action string, data []json.RawMessage) error {
+	o.booksLock.Lock()
+	defer o.booksLock.Unlock()
+
+	for _, raw := range data {
+		var d bookData
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return err
+		}
+
+		b, ok := o.books[instId]
+		if action == "snapshot" {
+			b = &book{bids: make(map[float64]float64), asks: make(map[float64]float64)}
+			o.books[instId] = b
+		} else if !ok {
+			// awaiting the snapshot
+			return nil
+		} else if d.PrevSeqId != b.seqId {
+			o.resync(instId, b.seqId, d.PrevSeqId)
+			return nil
+		}
+
+		if err := apply(b.asks, d.Asks); err != nil {
+			return err
+		}
+		if err := apply(b.bids, d.Bids); err != nil {
+			return err
+		}
+		b.seqId = d.SeqId
+	}
+	return nil
+}
+
+// resync reports updates missed from the book of instId and resubscribes to
+// it for a fresh snapshot. Called with the books locked.
+func (o *OKXMarketData) resync(instId string, seqId, prevSeqId int64) {
+	o.Log.Warnf("Order book of %s missed updates after seqId %d, next prevSeqId %d, resubscribing", instId, seqId, prevSeqId)
+	delete(o.books, instId)
+
+	o.acc.AddFields("okx_sequence_gap",
+		map[string]interface{}{
+			"last_seq_id": seqId,
+			"prev_seq_id": prevSeqId,
+		},
+		map[string]string{"inst_id": instId},
+		o.now(),
+	)
+
+	if o.client.Conn() == nil {
+		// the read loop subscribes again once reconnected
+		return
+	}
+	for _, name := range []string{"unsubscribe", "subscribe"} {
+		msg, err := op(name, []arg{{Channel: channelBooks, InstId: instId}})
+		if err == nil {
+			err = o.client.WriteMessage(msg)
+		}
+		if err != nil {
+			// the read loop reconnects, subscribing again
+			o.acc.AddError(fmt.Errorf("resubscribing to the book of %s: %s", instId, err))
+			return
+		}
+	}
+}
+
+// fields returns the top of the book and the total size of each side
+func (b *book) fields() map[string]interface{} {
+	bids, asks := sortedPrices(b.bids, true), sortedPrices(b.asks, false)
+
+	fields := map[string]interface{}{
+		"bid_depth": totalSize(b.bids),
+		"ask_depth": totalSize(b.asks),
+	}
+	if len(bids) > 0 {
+		fields["best_bid"] = bids[0]
+		fields["best_bid_size"] = b.bids[bids[0]]
+	}
+	if len(asks) > 0 {
+		fields["best_ask"] = asks[0]
+		fields["best_ask_size"] = b.asks[asks[0]]
+	}
+	if len(bids) > 0 && len(asks) > 0 {
+		fields["spread"] = asks[0] - bids[0]
+		fields["mid_price"] = (asks[0] + bids[0]) / 2
+	}
+	return fields
+}
+
+// sortedPrices returns the prices of a side from the best, the highest bid
+// or the lowest ask
+func sortedPrices(side map[float64]float64, descending bool) []float64 {
+	prices := make([]float64, 0, len(side))
+	for price := range side {
+		prices = append(prices, price)
+	}
+	if descending {
+		sort.Sort(sort.Reverse(sort.Float64Slice(prices)))
+	} else {
+		sort.Float64s(prices)
+	}
+	return prices
+}
+
+func totalSize(side map[float64]float64) float64 {
+	var total float64
+	for _, size := range side {
+		total += size
+	}
+	return total
+}
@@ -0,0 +1,414 @@
+package okx_marketdata
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/wsclient"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/plugins/common/tls"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const (
+	defaultServiceAddress = "wss://ws.okx.com:8443/ws/v5/public"
+	defaultLoginTimeout   = 10 * time.Second
+
+	channelTickers = "tickers"
+	channelTrades  = "trades"
+	channelBooks   = "books"
+
+	// the request path the signature of the login op covers
+	loginPath = "/users/self/verify"
+)
+
+type OKXMarketData struct {
+	ServiceAddress string   `toml:"service_address"`
+	InstIds        []string `toml:"inst_ids"`
+	Channels       []string `toml:"channels"`
+
+	APIKey        string `toml:"api_key"`
+	APISecret     string `toml:"api_secret"`
+	APIPassphrase string `toml:"api_passphrase"`
+	MetricSchema  string `toml:"metric_schema"`
+
+	PingInterval         internal.Duration `toml:"ping_interval"`
+	PongWait             internal.Duration `toml:"pong_wait"`
+	ReconnectInterval    internal.Duration `toml:"reconnect_interval"`
+	MaxBackoff           internal.Duration `toml:"max_backoff"`
+	MaxReconnectAttempts int               `toml:"max_reconnect_attempts"`
+
+	tls.ClientConfig
+
+	Log telegraf.Logger `toml:"-"`
+
+	acc        telegraf.Accumulator
+	client     *wsclient.Client
+	normalizer *marketdata.Normalizer
+	now        func() time.Time
+
+	// the order book of every instrument, from its last snapshot
+	books     map[string]*book
+	booksLock sync.Mutex
+}
+
+// arg identifies the channel of a subscription and of its data
+type arg struct {
+	Channel string `json:"channel"`
+	InstId  string `json:"instId"`
+}
+
+// message is either an event, the answer to an op, or channel data
+type message struct {
+	Event  string            `json:"event"`
+	Code   string            `json:"code"`
+	Msg    string            `json:"msg"`
+	Arg    arg               `json:"arg"`
+	Action string            `json:"action"`
+	Data   []json.RawMessage `json:"data"`
+}
+
+func (o *OKXMarketData) SampleConfig() string {
+	return `
+## OKX V5 websocket api, the public endpoint serves the market data
+# service_address = "wss://ws.okx.com:8443/ws/v5/public"
+## Instruments to subscribe to
+inst_ids = ["BTC-USDT", "ETH-USDT"]
+## Channels of every instrument, any of "tickers", "trades" and "books"
+channels = ["tickers", "trades", "books"]
+## API key to log in with upon connecting, for endpoints requiring a login
+# api_key = ""
+# api_secret = ""
+# api_passphrase = ""
+## Emit the trades, tickers and books in the "native" schema of the plugin,
+## or the "normalized" schema shared by the exchange inputs
+# metric_schema = "native"
+## Ping the server every ping_interval and reconnect when neither a pong nor
+## a message was received for pong_wait. "0s" disables the keepalive.
+# ping_interval = "10s"
+# pong_wait = "20s"
+## Delay before reconnecting after the connection dropped, doubled after
+## every failed attempt up to max_backoff
+# reconnect_interval = "1s"
+# max_backoff = "1m"
+## Give up reconnecting after this many consecutive failed attempts, 0 retries
+## forever
+# max_reconnect_attempts = 0
+
+## Optional TLS Config
+# tls_ca = "/etc/telegraf/ca.pem"
+# tls_cert = "/etc/telegraf/cert.pem"
+# tls_key = "/etc/telegraf/key.pem"
+## Use TLS but skip chain & host verification
+# insecure_skip_verify = false
+`
+}
+
+func (o *OKXMarketData) Description() string {
+	return "Receives the tickers, trades and order books of OKX instruments from its V5 websocket api"
+}
+
+// Gather emits the top of the order book of every instrument
+func (o *OKXMarketData) Gather(acc telegraf.Accumulator) error {
+	acc = o.normalizer.Accumulator(acc)
+
+	o.booksLock.Lock()
+	defer o.booksLock.Unlock()
+
+	now := o.now()
+	for instId, b := range o.books {
+		acc.AddFields("okx_book", b.fields(), map[string]string{"inst_id": instId}, now)
+	}
+	return nil
+}
+
+func (o *OKXMarketData) Init() error {
+	if len(o.InstIds) == 0 {
+		return fmt.Errorf("inst_ids must be set")
+	}
+	if len(o.Channels) == 0 {
+		return fmt.Errorf("channels must be set")
+	}
+	for _, channel := range o.Channels {
+		switch channel {
+		case channelTickers, channelTrades, channelBooks:
+		default:
+			return fmt.Errorf("invalid channel %q, must be %q, %q or %q", channel, channelTickers, channelTrades, channelBooks)
+		}
+	}
+	if o.APIKey != "" && (o.APISecret == "" || o.APIPassphrase == "") {
+		return fmt.Errorf("api_secret and api_passphrase must be set along with api_key")
+	}
+
+	normalizer, err := marketdata.NewNormalizer(o.MetricSchema, marketdata.Normalizer{
+		Exchange: "okx",
+		Measurements: map[string]string{
+			"okx_ticker": marketdata.Ticker,
+			"okx_trade":  marketdata.Trade,
+			"okx_book":   marketdata.Book,
+		},
+		SymbolTag: "inst_id",
+	}, o.InstIds)
+	if err != nil {
+		return err
+	}
+	o.normalizer = normalizer
+
+	tlsCfg, err := o.ClientConfig.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	cfg := wsclient.Config{
+		URL: o.ServiceAddress,
+		Dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: wsclient.HandshakeTimeout,
+			TLSClientConfig:  tlsCfg,
+		},
+		PingInterval:         o.PingInterval.Duration,
+		PongWait:             o.PongWait.Duration,
+		ReconnectInterval:    o.ReconnectInterval.Duration,
+		MaxBackoff:           o.MaxBackoff.Duration,
+		MaxReconnectAttempts: o.MaxReconnectAttempts,
+		StatsName:            "okx_marketdata",
+		StatsTags:            map[string]string{"address": o.ServiceAddress},
+		Log:                  o.Log,
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	o.client = wsclient.New(cfg, o)
+
+	return nil
+}
+
+func (o *OKXMarketData) Start(acc telegraf.Accumulator) error {
+	o.acc = o.normalizer.Accumulator(acc)
+	return o.client.Start(o.acc)
+}
+
+func (o *OKXMarketData) Stop() {
+	o.client.Stop()
+}
+
+// op returns the message of an op such as subscribe
+func op(name string, args interface{}) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"op":   name,
+		"args": args,
+	})
+}
+
+// Subscribe logs in, if an api key is set, and subscribes to every channel
+// of the instruments on every connection. The books start over from the
+// snapshot sent upon subscribing.
+func (o *OKXMarketData) Subscribe(conn *websocket.Conn) error {
+	o.booksLock.Lock()
+	o.books = make(map[string]*book)
+	o.booksLock.Unlock()
+
+	if o.APIKey != "" {
+		if err := o.login(conn); err != nil {
+			return err
+		}
+	}
+
+	var args []arg
+	for _, instId := range o.InstIds {
+		for _, channel := range o.Channels {
+			args = append(args, arg{Channel: channel, InstId: instId})
+		}
+	}
+	msg, err := op("subscribe", args)
+	if err != nil {
+		return err
+	}
+	o.Log.Debugf("Subscription Request: %s", msg)
+	return conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+// login sends the login op and awaits its answer, which the server sends
+// before any other message
+func (o *OKXMarketData) login(conn *websocket.Conn) error {
+	timestamp := strconv.FormatInt(o.now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(o.APISecret))
+	mac.Write([]byte(timestamp + http.MethodGet + loginPath))
+
+	msg, err := op("login", []map[string]string{{
+		"apiKey":     o.APIKey,
+		"passphrase": o.APIPassphrase,
+		"timestamp":  timestamp,
+		"sign":       base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+	}})
+	if err != nil {
+		return err
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		return err
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(defaultLoginTimeout))
+	defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+
+	_, answer, err := conn.ReadMessage()
+	if err != nil {
+		return fmt.Errorf("awaiting the login: %w", err)
+	}
+	var m message
+	if err := json.Unmarshal(answer, &m); err != nil {
+		return fmt.Errorf("unable to parse the answer to the login: %s", err)
+	}
+	if m.Event != "login" || m.Code != "0" {
+		return fmt.Errorf("%w: %s %s", wsclient.ErrHandshakeRejected, m.Code, m.Msg)
+	}
+	return nil
+}
+
+// Handle emits the metrics of a message of the feed
+func (o *OKXMarketData) Handle(data []byte) {
+	o.Log.Debugf("recv: %s", data)
+
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		o.acc.AddError(fmt.Errorf("unable to parse incoming msg: %s", err))
+		return
+	}
+
+	if msg.Event == "error" {
+		o.acc.AddError(fmt.Errorf("server error %s: %s", msg.Code, msg.Msg))
+		return
+	}
+	if msg.Event != "" {
+		return
+	}
+
+	var err error
+	switch msg.Arg.Channel {
+	case channelTickers:
+		err = o.addTickers(msg.Data)
+	case channelTrades:
+		err = o.addTrades(msg.Data)
+	case channelBooks:
+		err = o.updateBook(msg.Arg.InstId, msg.Action, msg.Data)
+	}
+	if err != nil {
+		o.acc.AddError(fmt.Errorf("unable to parse %s message of %s: %s", msg.Arg.Channel, msg.Arg.InstId, err))
+	}
+}
+
+// addTickers emits the tickers of the tickers channel
+func (o *OKXMarketData) addTickers(data []json.RawMessage) error {
+	for _, raw := range data {
+		var ticker map[string]string
+		if err := json.Unmarshal(raw, &ticker); err != nil {
+			return err
+		}
+
+		fields := make(map[string]interface{})
+		for name, key := range map[string]string{
+			"price":         "last",
+			"last_size":     "lastSz",
+			"best_bid":      "bidPx",
+			"best_bid_size": "bidSz",
+			"best_ask":      "askPx",
+			"best_ask_size": "askSz",
+			"open_24h":      "open24h",
+			"high_24h":      "high24h",
+			"low_24h":       "low24h",
+			"volume_24h":    "vol24h",
+		} {
+			if ticker[key] == "" {
+				continue
+			}
+			value, err := strconv.ParseFloat(ticker[key], 64)
+			if err != nil {
+				return fmt.Errorf("%s: %s", key, err)
+			}
+			fields[name] = value
+		}
+		tm, err := parseMillis(ticker["ts"])
+		if err != nil {
+			return err
+		}
+
+		o.acc.AddFields("okx_ticker", fields, map[string]string{"inst_id": ticker["instId"]}, tm)
+	}
+	return nil
+}
+
+// addTrades emits the trades of the trades channel
+func (o *OKXMarketData) addTrades(data []json.RawMessage) error {
+	for _, raw := range data {
+		var trade map[string]string
+		if err := json.Unmarshal(raw, &trade); err != nil {
+			return err
+		}
+		price, err := strconv.ParseFloat(trade["px"], 64)
+		if err != nil {
+			return err
+		}
+		size, err := strconv.ParseFloat(trade["sz"], 64)
+		if err != nil {
+			return err
+		}
+		tradeId, err := strconv.ParseInt(trade["tradeId"], 10, 64)
+		if err != nil {
+			return err
+		}
+		tm, err := parseMillis(trade["ts"])
+		if err != nil {
+			return err
+		}
+
+		o.acc.AddFields("okx_trade",
+			map[string]interface{}{
+				"price":    price,
+				"size":     size,
+				"trade_id": tradeId,
+			},
+			map[string]string{
+				"inst_id": trade["instId"],
+				"side":    trade["side"],
+			},
+			tm,
+		)
+	}
+	return nil
+}
+
+// parseMillis converts the milliseconds since the epoch of the feed
+func parseMillis(s string) (time.Time, error) {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid ts %q: %s", s, err)
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), nil
+}
+
+func newOKXMarketData() *OKXMarketData {
+	return &OKXMarketData{
+		ServiceAddress:    defaultServiceAddress,
+		MetricSchema:      marketdata.SchemaNative,
+		PingInterval:      internal.Duration{Duration: wsclient.DefaultPingInterval},
+		PongWait:          internal.Duration{Duration: wsclient.DefaultPongWait},
+		ReconnectInterval: internal.Duration{Duration: wsclient.DefaultReconnectInterval},
+		MaxBackoff:        internal.Duration{Duration: wsclient.DefaultMaxBackoff},
+		now:               time.Now,
+		books:             make(map[string]*book),
+	}
+}
+
+func init() {
+	inputs.Add("okx_marketdata", func() telegraf.Input { return newOKXMarketData() })
+}
@@ -0,0 +1,269 @@
+package okx_marketdata
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/common/marketdata"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+const (
+	bookSnapshot = `{"arg": {"channel": "books", "instId": "BTC-USDT"}, "action": "snapshot", "data": [{"asks": [["8476.98", "415", "0", "13"], ["8477", "7", "0", "2"]], "bids": [["8476.97", "256", "0", "12"], ["8475.55", "101", "0", "1"]], "ts": "1597026383085", "checksum": -855196043, "prevSeqId": -1, "seqId": 123456}]}`
+	bookUpdate   = `{"arg": {"channel": "books", "instId": "BTC-USDT"}, "action": "update", "data": [{"asks": [["8476.98", "0", "0", "0"]], "bids": [["8476.97", "300", "0", "13"]], "ts": "1597026383185", "checksum": 1, "prevSeqId": 123456, "seqId": 123457}]}`
+	bookGap      = `{"arg": {"channel": "books", "instId": "BTC-USDT"}, "action": "update", "data": [{"asks": [], "bids": [["8476.97", "1", "0", "1"]], "ts": "1597026383285", "checksum": 1, "prevSeqId": 123460, "seqId": 123461}]}`
+)
+
+func newTestPlugin(t *testing.T) (*OKXMarketData, *testutil.Accumulator) {
+	o := newOKXMarketData()
+	o.Log = testutil.Logger{}
+	o.InstIds = []string{"BTC-USDT"}
+	o.Channels = []string{"tickers", "trades", "books"}
+	o.now = func() time.Time { return now }
+	require.NoError(t, o.Init())
+
+	acc := &testutil.Accumulator{}
+	o.acc = acc
+	return o, acc
+}
+
+// newTestServer answers every message read with the answer returned by
+// handler, if any
+func newTestServer(t *testing.T, handler func(msg []byte) []string) string {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			for _, answer := range handler(msg) {
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(answer))
+			}
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+func TestTicker(t *testing.T) {
+	o, acc := newTestPlugin(t)
+
+	o.Handle([]byte(`{"arg": {"channel": "tickers", "instId": "BTC-USDT"}, "data": [{"instType": "SPOT", "instId": "BTC-USDT", "last": "9999.99", "lastSz": "0.1", "askPx": "9999.99", "askSz": "11", "bidPx": "8888.88", "bidSz": "5", "open24h": "9000", "high24h": "10000", "low24h": "8888.88", "volCcy24h": "2222", "vol24h": "2222", "sodUtc0": "2222", "sodUtc8": "2222", "ts": "1597026383085"}]}`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("okx_ticker",
+			map[string]string{"inst_id": "BTC-USDT"},
+			map[string]interface{}{
+				"price":         9999.99,
+				"last_size":     0.1,
+				"best_bid":      8888.88,
+				"best_bid_size": 5.0,
+				"best_ask":      9999.99,
+				"best_ask_size": 11.0,
+				"open_24h":      9000.0,
+				"high_24h":      10000.0,
+				"low_24h":       8888.88,
+				"volume_24h":    2222.0,
+			},
+			time.Unix(0, 1597026383085*int64(time.Millisecond)),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestTrades(t *testing.T) {
+	o, acc := newTestPlugin(t)
+
+	o.Handle([]byte(`{"arg": {"channel": "trades", "instId": "BTC-USDT"}, "data": [{"instId": "BTC-USDT", "tradeId": "130639474", "px": "42219.9", "sz": "0.12060306", "side": "buy", "ts": "1630048897897"}]}`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("okx_trade",
+			map[string]string{"inst_id": "BTC-USDT", "side": "buy"},
+			map[string]interface{}{"price": 42219.9, "size": 0.12060306, "trade_id": int64(130639474)},
+			time.Unix(0, 1630048897897*int64(time.Millisecond)),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestBook(t *testing.T) {
+	o, acc := newTestPlugin(t)
+
+	// updates before the snapshot are ignored
+	o.Handle([]byte(bookUpdate))
+	o.Handle([]byte(bookSnapshot))
+	o.Handle([]byte(bookUpdate))
+	require.NoError(t, acc.FirstError())
+
+	require.NoError(t, o.Gather(acc))
+	bid, ask := 8476.97, 8477.0
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("okx_book",
+			map[string]string{"inst_id": "BTC-USDT"},
+			map[string]interface{}{
+				"best_bid":      8476.97,
+				"best_bid_size": 300.0,
+				"best_ask":      8477.0,
+				"best_ask_size": 7.0,
+				"spread":        ask - bid,
+				"mid_price":     (ask + bid) / 2,
+				"bid_depth":     401.0,
+				"ask_depth":     7.0,
+			},
+			now,
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestBookResyncsOnSequenceGap(t *testing.T) {
+	ops := make(chan string, 10)
+	var received int32
+	url := newTestServer(t, func(msg []byte) []string {
+		ops <- string(msg)
+		if atomic.AddInt32(&received, 1) == 1 {
+			return []string{bookSnapshot, bookGap}
+		}
+		return nil
+	})
+
+	o := newOKXMarketData()
+	o.Log = testutil.Logger{}
+	o.ServiceAddress = url
+	o.InstIds = []string{"BTC-USDT"}
+	o.Channels = []string{"books"}
+	require.NoError(t, o.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, o.Start(acc))
+	acc.Wait(1)
+	require.JSONEq(t, `{"op": "subscribe", "args": [{"channel": "books", "instId": "BTC-USDT"}]}`, <-ops)
+	require.JSONEq(t, `{"op": "unsubscribe", "args": [{"channel": "books", "instId": "BTC-USDT"}]}`, <-ops)
+	require.JSONEq(t, `{"op": "subscribe", "args": [{"channel": "books", "instId": "BTC-USDT"}]}`, <-ops)
+	o.Stop()
+
+	require.NoError(t, acc.FirstError())
+	acc.AssertContainsTaggedFields(t, "okx_sequence_gap",
+		map[string]interface{}{"last_seq_id": int64(123456), "prev_seq_id": int64(123460)},
+		map[string]string{"inst_id": "BTC-USDT"},
+	)
+}
+
+func TestLogin(t *testing.T) {
+	ops := make(chan map[string]interface{}, 10)
+	url := newTestServer(t, func(msg []byte) []string {
+		var m map[string]interface{}
+		require.NoError(t, json.Unmarshal(msg, &m))
+		ops <- m
+		if m["op"] == "login" {
+			return []string{`{"event": "login", "code": "0", "msg": ""}`}
+		}
+		return nil
+	})
+
+	o := newOKXMarketData()
+	o.Log = testutil.Logger{}
+	o.ServiceAddress = url
+	o.InstIds = []string{"BTC-USDT"}
+	o.Channels = []string{"tickers"}
+	o.APIKey = "key"
+	o.APISecret = "secret"
+	o.APIPassphrase = "passphrase"
+	o.now = func() time.Time { return now }
+	require.NoError(t, o.Init())
+	require.NoError(t, o.Start(&testutil.Accumulator{}))
+	defer o.Stop()
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1614600000GET/users/self/verify"))
+	login := <-ops
+	require.Equal(t, "login", login["op"])
+	require.Equal(t, []interface{}{map[string]interface{}{
+		"apiKey":     "key",
+		"passphrase": "passphrase",
+		"timestamp":  "1614600000",
+		"sign":       base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+	}}, login["args"])
+	require.Equal(t, "subscribe", (<-ops)["op"])
+}
+
+func TestLoginRejected(t *testing.T) {
+	url := newTestServer(t, func(msg []byte) []string {
+		return []string{`{"event": "error", "code": "60009", "msg": "Login failed."}`}
+	})
+
+	o := newOKXMarketData()
+	o.Log = testutil.Logger{}
+	o.ServiceAddress = url
+	o.InstIds = []string{"BTC-USDT"}
+	o.Channels = []string{"tickers"}
+	o.APIKey = "key"
+	o.APISecret = "secret"
+	o.APIPassphrase = "passphrase"
+	require.NoError(t, o.Init())
+
+	err := o.Start(&testutil.Accumulator{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "60009 Login failed.")
+}
+
+func TestServerError(t *testing.T) {
+	o, acc := newTestPlugin(t)
+
+	o.Handle([]byte(`{"event": "subscribe", "arg": {"channel": "tickers", "instId": "BTC-USDT"}}`))
+	require.NoError(t, acc.FirstError())
+
+	o.Handle([]byte(`{"event": "error", "code": "60018", "msg": "Invalid request: {\"op\": \"subscribe\", \"argss\":[{ \"channel\" : \"tickers\", \"instId\" : \"BTC-USDT\"}]}"}`))
+	require.Error(t, acc.FirstError())
+	require.Contains(t, acc.FirstError().Error(), "server error 60018")
+}
+
+func TestNormalizedSchema(t *testing.T) {
+	o, acc := newTestPlugin(t)
+	o.MetricSchema = marketdata.SchemaNormalized
+	require.NoError(t, o.Init())
+	o.acc = o.normalizer.Accumulator(acc)
+
+	o.Handle([]byte(`{"arg": {"channel": "trades", "instId": "BTC-USDT"}, "data": [{"instId": "BTC-USDT", "tradeId": "130639474", "px": "42219.9", "sz": "0.12060306", "side": "buy", "ts": "1630048897897"}]}`))
+
+	require.NoError(t, acc.FirstError())
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("market_trade",
+			map[string]string{"exchange": "okx", "base": "BTC", "quote": "USDT", "side": "buy"},
+			map[string]interface{}{"price": 42219.9, "size": 0.12060306},
+			time.Unix(0, 1630048897897*int64(time.Millisecond)),
+		),
+	}, acc.GetTelegrafMetrics())
+}
+
+func TestInit(t *testing.T) {
+	o := newOKXMarketData()
+	o.Channels = []string{"tickers"}
+	require.Error(t, o.Init())
+
+	o.InstIds = []string{"BTC-USDT"}
+	o.Channels = []string{"candle1m"}
+	require.Error(t, o.Init())
+
+	o.Channels = []string{"tickers"}
+	o.APIKey = "key"
+	require.Error(t, o.Init())
+}
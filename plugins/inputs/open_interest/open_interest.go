@@ -0,0 +1,330 @@
+package open_interest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/common/tls"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const (
+	venueBinance = "binance"
+	venueOKX     = "okx"
+	venueBybit   = "bybit"
+
+	defaultTimeout = 10 * time.Second
+)
+
+// the REST api of each venue
+var restAddresses = map[string]string{
+	venueBinance: "https://fapi.binance.com",
+	venueOKX:     "https://www.okx.com",
+	venueBybit:   "https://api.bybit.com",
+}
+
+// openInterest is the open interest of an instrument, the fields missing from
+// the response of a venue left nil
+type openInterest struct {
+	contracts float64
+	currency  *float64
+	value     *float64
+	time      *time.Time
+}
+
+type OpenInterest struct {
+	Instruments   map[string][]string `toml:"instruments"`
+	RestAddresses map[string]string   `toml:"rest_addresses"`
+	Timeout       internal.Duration   `toml:"timeout"`
+	tls.ClientConfig
+
+	Log telegraf.Logger `toml:"-"`
+
+	client *http.Client
+	now    func() time.Time
+}
+
+func (o *OpenInterest) SampleConfig() string {
+	return `
+## Timeout of a request
+# timeout = "10s"
+
+## Optional TLS Config
+# tls_ca = "/etc/telegraf/ca.pem"
+# tls_cert = "/etc/telegraf/cert.pem"
+# tls_key = "/etc/telegraf/key.pem"
+## Use TLS but skip chain & host verification
+# insecure_skip_verify = false
+
+## The futures and perpetual swaps to collect the open interest of, by
+## venue: "binance" (the USD-M futures), "okx" or "bybit" (the linear
+## contracts)
+[inputs.open_interest.instruments]
+  binance = ["BTCUSDT"]
+  # okx = ["BTC-USDT-SWAP"]
+  # bybit = ["BTCUSDT"]
+
+## The REST api of a venue, defaults to its public api
+# [inputs.open_interest.rest_addresses]
+#   binance = "https://fapi.binance.com"
+`
+}
+
+func (o *OpenInterest) Description() string {
+	return "Reads the open interest of futures and perpetual swaps from the REST api of derivatives venues"
+}
+
+func (o *OpenInterest) Init() error {
+	if len(o.Instruments) == 0 {
+		return fmt.Errorf("instruments must be set")
+	}
+	for venue := range o.Instruments {
+		if _, ok := restAddresses[venue]; !ok {
+			return fmt.Errorf("invalid venue %q, must be %q, %q or %q", venue, venueBinance, venueOKX, venueBybit)
+		}
+	}
+	for venue := range o.RestAddresses {
+		if _, ok := restAddresses[venue]; !ok {
+			return fmt.Errorf("invalid venue %q of rest_addresses, must be %q, %q or %q", venue, venueBinance, venueOKX, venueBybit)
+		}
+	}
+
+	tlsCfg, err := o.ClientConfig.TLSConfig()
+	if err != nil {
+		return err
+	}
+	o.client = &http.Client{
+		Timeout: o.Timeout.Duration,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsCfg,
+		},
+	}
+	return nil
+}
+
+func (o *OpenInterest) Gather(acc telegraf.Accumulator) error {
+	now := o.now()
+
+	venues := make([]string, 0, len(o.Instruments))
+	for venue := range o.Instruments {
+		venues = append(venues, venue)
+	}
+	sort.Strings(venues)
+
+	for _, venue := range venues {
+		for _, instrument := range o.Instruments[venue] {
+			oi, err := o.fetch(venue, instrument)
+			if err != nil {
+				acc.AddError(fmt.Errorf("unable to fetch the open interest of %s on %s: %s", instrument, venue, err))
+				continue
+			}
+
+			fields := map[string]interface{}{
+				"open_interest": oi.contracts,
+			}
+			if oi.currency != nil {
+				fields["open_interest_currency"] = *oi.currency
+			}
+			if oi.value != nil {
+				fields["open_interest_value"] = *oi.value
+			}
+			// timestamped by the venue where it says when the open interest
+			// was computed, lining up with the prices of the same time
+			ts := now
+			if oi.time != nil {
+				ts = *oi.time
+			}
+			acc.AddFields("open_interest", fields,
+				map[string]string{
+					"exchange":   venue,
+					"instrument": instrument,
+				},
+				ts,
+			)
+		}
+	}
+	return nil
+}
+
+func (o *OpenInterest) restAddress(venue string) string {
+	if addr, ok := o.RestAddresses[venue]; ok {
+		return addr
+	}
+	return restAddresses[venue]
+}
+
+func (o *OpenInterest) fetch(venue string, instrument string) (*openInterest, error) {
+	switch venue {
+	case venueBinance:
+		return o.fetchBinance(instrument)
+	case venueOKX:
+		return o.fetchOKX(instrument)
+	default:
+		return o.fetchBybit(instrument)
+	}
+}
+
+// fetchBinance reads the open interest of a USD-M future, in contracts
+//
+// {"openInterest": "10659.509", "symbol": "BTCUSDT", "time": 1589437530011}
+func (o *OpenInterest) fetchBinance(symbol string) (*openInterest, error) {
+	var resp struct {
+		OpenInterest string `json:"openInterest"`
+		Time         int64  `json:"time"`
+	}
+	query := url.Values{"symbol": []string{symbol}}
+	if err := o.get(o.restAddress(venueBinance)+"/fapi/v1/openInterest?"+query.Encode(), &resp); err != nil {
+		return nil, err
+	}
+
+	contracts, err := strconv.ParseFloat(resp.OpenInterest, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid open interest %q", resp.OpenInterest)
+	}
+	return &openInterest{
+		contracts: contracts,
+		time:      optionalTime(resp.Time),
+	}, nil
+}
+
+// fetchOKX reads the open interest of a perpetual swap or future, in
+// contracts and in the currency of the contracts
+//
+// {"code": "0", "msg": "", "data": [{"instId": "BTC-USDT-SWAP", "instType": "SWAP",
+//  "oi": "5000", "oiCcy": "50", "oiUsd": "1850000", "ts": "1597026383085"}]}
+func (o *OpenInterest) fetchOKX(instId string) (*openInterest, error) {
+	var resp struct {
+		Code string `json:"code"`
+		Msg  string `json:"msg"`
+		Data []struct {
+			Oi    string `json:"oi"`
+			OiCcy string `json:"oiCcy"`
+			OiUsd string `json:"oiUsd"`
+			Ts    string `json:"ts"`
+		} `json:"data"`
+	}
+	// the api needs the type of the instrument, told apart by its name
+	instType := "FUTURES"
+	if strings.HasSuffix(instId, "-SWAP") {
+		instType = "SWAP"
+	}
+	query := url.Values{"instType": []string{instType}, "instId": []string{instId}}
+	if err := o.get(o.restAddress(venueOKX)+"/api/v5/public/open-interest?"+query.Encode(), &resp); err != nil {
+		return nil, err
+	}
+	if resp.Code != "0" {
+		return nil, fmt.Errorf("error %s: %s", resp.Code, resp.Msg)
+	}
+	if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("no open interest")
+	}
+
+	data := resp.Data[0]
+	contracts, err := strconv.ParseFloat(data.Oi, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid open interest %q", data.Oi)
+	}
+	ts, _ := strconv.ParseInt(data.Ts, 10, 64)
+	return &openInterest{
+		contracts: contracts,
+		currency:  optionalFloat(data.OiCcy),
+		value:     optionalFloat(data.OiUsd),
+		time:      optionalTime(ts),
+	}, nil
+}
+
+// fetchBybit reads the ticker of a linear contract, holding its open interest
+// in contracts and its value in the quote currency
+//
+// {"retCode": 0, "retMsg": "OK", "result": {"category": "linear", "list": [{"symbol": "BTCUSDT",
+//  "openInterest": "28227.42", "openInterestValue": "470190024.92", ...}]}, "time": 1672053548579}
+func (o *OpenInterest) fetchBybit(symbol string) (*openInterest, error) {
+	var resp struct {
+		RetCode int    `json:"retCode"`
+		RetMsg  string `json:"retMsg"`
+		Result  struct {
+			List []struct {
+				OpenInterest      string `json:"openInterest"`
+				OpenInterestValue string `json:"openInterestValue"`
+			} `json:"list"`
+		} `json:"result"`
+		Time int64 `json:"time"`
+	}
+	query := url.Values{"category": []string{"linear"}, "symbol": []string{symbol}}
+	if err := o.get(o.restAddress(venueBybit)+"/v5/market/tickers?"+query.Encode(), &resp); err != nil {
+		return nil, err
+	}
+	if resp.RetCode != 0 {
+		return nil, fmt.Errorf("error %d: %s", resp.RetCode, resp.RetMsg)
+	}
+	if len(resp.Result.List) == 0 {
+		return nil, fmt.Errorf("no ticker")
+	}
+
+	ticker := resp.Result.List[0]
+	contracts, err := strconv.ParseFloat(ticker.OpenInterest, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid open interest %q", ticker.OpenInterest)
+	}
+	return &openInterest{
+		contracts: contracts,
+		value:     optionalFloat(ticker.OpenInterestValue),
+		time:      optionalTime(resp.Time),
+	}, nil
+}
+
+// get decodes the json response of addr into v
+func (o *OpenInterest) get(addr string, v interface{}) error {
+	resp, err := o.client.Get(addr)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: %s", addr, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding %s: %s", addr, err)
+	}
+	return nil
+}
+
+// optionalFloat parses a decimal a venue may leave empty, nil when empty or
+// malformed
+func optionalFloat(s string) *float64 {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+// optionalTime converts a time in unix milliseconds, nil when zero
+func optionalTime(ms int64) *time.Time {
+	if ms <= 0 {
+		return nil
+	}
+	t := time.Unix(0, ms*int64(time.Millisecond)).UTC()
+	return &t
+}
+
+func newOpenInterest() *OpenInterest {
+	return &OpenInterest{
+		Timeout: internal.Duration{Duration: defaultTimeout},
+		now:     time.Now,
+	}
+}
+
+func init() {
+	inputs.Add("open_interest", func() telegraf.Input { return newOpenInterest() })
+}
@@ -0,0 +1,172 @@
+package open_interest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestOpenInterest(addr string, instruments map[string][]string) *OpenInterest {
+	o := newOpenInterest()
+	o.Instruments = instruments
+	o.RestAddresses = map[string]string{
+		venueBinance: addr,
+		venueOKX:     addr,
+		venueBybit:   addr,
+	}
+	o.Log = testutil.Logger{}
+	o.now = func() time.Time { return time.Unix(1700000000, 0) }
+	return o
+}
+
+func TestGather(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fapi/v1/openInterest":
+			require.Equal(t, "BTCUSDT", r.URL.Query().Get("symbol"))
+			w.Write([]byte(`{"openInterest": "10659.509", "symbol": "BTCUSDT", "time": 1700000001000}`))
+		case "/api/v5/public/open-interest":
+			switch r.URL.Query().Get("instId") {
+			case "BTC-USDT-SWAP":
+				require.Equal(t, "SWAP", r.URL.Query().Get("instType"))
+				w.Write([]byte(`{"code": "0", "msg": "", "data": [{"instId": "BTC-USDT-SWAP", "instType": "SWAP",
+					"oi": "5000", "oiCcy": "50", "oiUsd": "1850000", "ts": "1700000002000"}]}`))
+			default:
+				require.Equal(t, "BTC-USD-240329", r.URL.Query().Get("instId"))
+				require.Equal(t, "FUTURES", r.URL.Query().Get("instType"))
+				w.Write([]byte(`{"code": "0", "msg": "", "data": [{"instId": "BTC-USD-240329", "instType": "FUTURES",
+					"oi": "1200", "oiCcy": "3.5", "ts": "1700000002000"}]}`))
+			}
+		case "/v5/market/tickers":
+			require.Equal(t, "linear", r.URL.Query().Get("category"))
+			require.Equal(t, "ETHUSDT", r.URL.Query().Get("symbol"))
+			w.Write([]byte(`{"retCode": 0, "retMsg": "OK", "result": {"category": "linear", "list": [{
+				"symbol": "ETHUSDT", "openInterest": "28227.42", "openInterestValue": "56454840"}]},
+				"time": 1700000003000}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	o := newTestOpenInterest(ts.URL, map[string][]string{
+		venueBinance: {"BTCUSDT"},
+		venueOKX:     {"BTC-USDT-SWAP", "BTC-USD-240329"},
+		venueBybit:   {"ETHUSDT"},
+	})
+	require.NoError(t, o.Init())
+
+	var acc testutil.Accumulator
+	require.NoError(t, o.Gather(&acc))
+	require.Empty(t, acc.Errors)
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric("open_interest",
+			map[string]string{"exchange": "binance", "instrument": "BTCUSDT"},
+			map[string]interface{}{
+				"open_interest": 10659.509,
+			},
+			time.Unix(1700000001, 0),
+		),
+		testutil.MustMetric("open_interest",
+			map[string]string{"exchange": "bybit", "instrument": "ETHUSDT"},
+			map[string]interface{}{
+				"open_interest":       28227.42,
+				"open_interest_value": 56454840.0,
+			},
+			time.Unix(1700000003, 0),
+		),
+		testutil.MustMetric("open_interest",
+			map[string]string{"exchange": "okx", "instrument": "BTC-USDT-SWAP"},
+			map[string]interface{}{
+				"open_interest":          5000.0,
+				"open_interest_currency": 50.0,
+				"open_interest_value":    1850000.0,
+			},
+			time.Unix(1700000002, 0),
+		),
+		testutil.MustMetric("open_interest",
+			map[string]string{"exchange": "okx", "instrument": "BTC-USD-240329"},
+			map[string]interface{}{
+				"open_interest":          1200.0,
+				"open_interest_currency": 3.5,
+			},
+			time.Unix(1700000002, 0),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestGatherWithoutVenueTime(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"openInterest": "1.5", "symbol": "BTCUSDT"}`))
+	}))
+	defer ts.Close()
+
+	o := newTestOpenInterest(ts.URL, map[string][]string{venueBinance: {"BTCUSDT"}})
+	require.NoError(t, o.Init())
+
+	var acc testutil.Accumulator
+	require.NoError(t, o.Gather(&acc))
+	require.Empty(t, acc.Errors)
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric("open_interest",
+			map[string]string{"exchange": "binance", "instrument": "BTCUSDT"},
+			map[string]interface{}{
+				"open_interest": 1.5,
+			},
+			time.Unix(1700000000, 0),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestGatherErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v5/public/open-interest":
+			w.Write([]byte(`{"code": "51001", "msg": "Instrument ID does not exist", "data": []}`))
+		case "/v5/market/tickers":
+			w.Write([]byte(`{"retCode": 10001, "retMsg": "params error", "result": {}}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	o := newTestOpenInterest(ts.URL, map[string][]string{
+		venueBinance: {"BTCUSDT"},
+		venueOKX:     {"NOPE-SWAP"},
+		venueBybit:   {"NOPE"},
+	})
+	require.NoError(t, o.Init())
+
+	var acc testutil.Accumulator
+	require.NoError(t, o.Gather(&acc))
+	require.Len(t, acc.Errors, 3)
+	require.Contains(t, acc.Errors[0].Error(), "500 Internal Server Error")
+	require.Contains(t, acc.Errors[1].Error(), "error 10001: params error")
+	require.Contains(t, acc.Errors[2].Error(), "error 51001: Instrument ID does not exist")
+	require.Empty(t, acc.GetTelegrafMetrics())
+}
+
+func TestInitErrors(t *testing.T) {
+	o := newOpenInterest()
+	require.Error(t, o.Init())
+
+	o.Instruments = map[string][]string{"deribit": {"BTC-PERPETUAL"}}
+	require.Error(t, o.Init())
+
+	o.Instruments = map[string][]string{venueBinance: {"BTCUSDT"}}
+	o.RestAddresses = map[string]string{"deribit": "http://localhost"}
+	require.Error(t, o.Init())
+
+	o.RestAddresses = nil
+	require.NoError(t, o.Init())
+}
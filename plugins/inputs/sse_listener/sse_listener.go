@@ -0,0 +1,393 @@
+package sse_listener
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/choice"
+	"github.com/influxdata/telegraf/internal/wsclient"
+	"github.com/influxdata/telegraf/plugins/common/tls"
+	"github.com/influxdata/telegraf/plugins/inputs"
+	"github.com/influxdata/telegraf/plugins/parsers"
+	"github.com/influxdata/telegraf/selfstat"
+)
+
+const (
+	defaultReadTimeout = time.Minute
+
+	// the type of the events without an event field
+	defaultEventType = "message"
+)
+
+// errStreamClosed is returned when the server asked not to reconnect by
+// answering with 204 No Content
+var errStreamClosed = errors.New("stream closed by the server")
+
+type SSEListener struct {
+	URL     string            `toml:"url"`
+	Headers map[string]string `toml:"headers"`
+	Events  []string          `toml:"events"`
+
+	ReadTimeout          internal.Duration `toml:"read_timeout"`
+	ReconnectInterval    internal.Duration `toml:"reconnect_interval"`
+	MaxBackoff           internal.Duration `toml:"max_backoff"`
+	MaxReconnectAttempts int               `toml:"max_reconnect_attempts"`
+
+	tls.ClientConfig
+
+	Log telegraf.Logger `toml:"-"`
+
+	parser parsers.Parser
+	acc    telegraf.Accumulator
+	client *http.Client
+
+	// cancelled by Stop, ending the read loop and reconnects
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// the id of the last event, sent as the Last-Event-ID of the reconnects,
+	// and the reconnect interval set by the server, only accessed by connect
+	// and the read loop
+	lastEventID string
+	retry       time.Duration
+
+	messagesReceived selfstat.Stat
+	bytesReceived    selfstat.Stat
+	parseErrors      selfstat.Stat
+	reconnects       selfstat.Stat
+}
+
+// stream is a connection to the server
+type stream struct {
+	reader *bufio.Reader
+	body   io.Closer
+	cancel context.CancelFunc
+
+	// idle cancels the request when nothing was received for read_timeout
+	idle *time.Timer
+}
+
+func (s *SSEListener) SampleConfig() string {
+	return `
+## URL of the event stream
+url = "https://stream.example.com/events"
+## Types of the events parsed, all when empty. Events without a type are of
+## type "message".
+# events = []
+## Reconnect when nothing, not even a comment, was received for read_timeout.
+## A read_timeout of "0s" disables it.
+# read_timeout = "1m"
+## Delay before reconnecting after the connection dropped, doubled after
+## every failed attempt up to max_backoff. The retry field of the stream
+## overrides the reconnect_interval.
+# reconnect_interval = "1s"
+# max_backoff = "1m"
+## Give up reconnecting after this many consecutive failed attempts, 0 retries
+## forever. A request rejected by the server, e.g. with 401 Unauthorized,
+## stops reconnecting immediately.
+# max_reconnect_attempts = 0
+## Optional TLS Config
+# tls_ca = "/etc/telegraf/ca.pem"
+# tls_cert = "/etc/telegraf/cert.pem"
+# tls_key = "/etc/telegraf/key.pem"
+## Use TLS but skip chain & host verification
+# insecure_skip_verify = false
+## Data format of the data of the events, every event is parsed on its own.
+## Each data format has its own unique set of configuration options, read
+## more about them here:
+## https://github.com/influxdata/telegraf/blob/master/docs/DATA_FORMATS_INPUT.md
+data_format = "json"
+
+## Headers of the request, e.g. for authentication
+# [inputs.sse_listener.headers]
+#   Authorization = "Bearer <token>"
+`
+}
+
+func (s *SSEListener) Description() string {
+	return "Receives metrics from the events of a Server-Sent Events stream"
+}
+
+func (s *SSEListener) Gather(_ telegraf.Accumulator) error {
+	return nil
+}
+
+func (s *SSEListener) SetParser(parser parsers.Parser) {
+	s.parser = parser
+}
+
+func (s *SSEListener) Init() error {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid url %q, the scheme must be http or https", s.URL)
+	}
+	if s.MaxReconnectAttempts < 0 {
+		return fmt.Errorf("invalid max_reconnect_attempts %d, must not be negative", s.MaxReconnectAttempts)
+	}
+
+	tlsCfg, err := s.ClientConfig.TLSConfig()
+	if err != nil {
+		return err
+	}
+	s.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsCfg,
+		},
+	}
+
+	tags := map[string]string{
+		"url": s.URL,
+	}
+	s.messagesReceived = selfstat.Register("sse_listener", "messages_received", tags)
+	s.bytesReceived = selfstat.Register("sse_listener", "bytes_received", tags)
+	s.parseErrors = selfstat.Register("sse_listener", "parse_errors", tags)
+	s.reconnects = selfstat.Register("sse_listener", "reconnects", tags)
+	return nil
+}
+
+// Start connects to the server and starts the read loop, failing when the
+// first connection can't be established
+func (s *SSEListener) Start(acc telegraf.Accumulator) error {
+	s.acc = acc
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	st, err := s.connect()
+	if err != nil {
+		s.cancel()
+		return err
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.read(st)
+	}()
+	return nil
+}
+
+// Stop closes the connection and waits for the read loop to end
+func (s *SSEListener) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// connect requests the stream, resuming from the last event received
+func (s *SSEListener) connect() (*stream, error) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if s.lastEventID != "" {
+		req.Header.Set("Last-Event-ID", s.lastEventID)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("request %s: %w", s.URL, err)
+	}
+	if err := responseError(resp); err != nil {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("request %s: %w", s.URL, err)
+	}
+
+	st := &stream{
+		reader: bufio.NewReader(resp.Body),
+		body:   resp.Body,
+		cancel: cancel,
+	}
+	if s.ReadTimeout.Duration > 0 {
+		st.idle = time.AfterFunc(s.ReadTimeout.Duration, cancel)
+	}
+	return st, nil
+}
+
+// responseError returns the error of a response other than an event stream,
+// wrapping wsclient.ErrHandshakeRejected for the client errors other than
+// 429 Too Many Requests, and errStreamClosed for 204 No Content
+func responseError(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return errStreamClosed
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %s", wsclient.ErrHandshakeRejected, resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return errors.New(resp.Status)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "text/event-stream") {
+		return fmt.Errorf("%w: unexpected content type %q", wsclient.ErrHandshakeRejected, contentType)
+	}
+	return nil
+}
+
+func (st *stream) close() {
+	if st.idle != nil {
+		st.idle.Stop()
+	}
+	st.cancel()
+	st.body.Close()
+}
+
+func (s *SSEListener) read(st *stream) {
+	for {
+		err := s.readEvents(st)
+		st.close()
+		if s.ctx.Err() != nil {
+			// the connection was closed by Stop
+			return
+		}
+
+		s.Log.Warnf("Read error, reconnecting: %s", err)
+		if st = s.reconnect(err); st == nil {
+			return
+		}
+	}
+}
+
+// reconnect requests the stream again after cause broke the connection,
+// backing off between attempts. Returns the new stream, or nil when giving
+// up.
+func (s *SSEListener) reconnect(cause error) *stream {
+	failures := 0
+
+	for {
+		if errors.Is(cause, wsclient.ErrHandshakeRejected) || errors.Is(cause, errStreamClosed) ||
+			(s.MaxReconnectAttempts > 0 && failures >= s.MaxReconnectAttempts) {
+			s.acc.AddError(fmt.Errorf("giving up on %s after %d reconnect attempts: %s", s.URL, failures, cause))
+			return nil
+		}
+
+		interval := s.ReconnectInterval.Duration
+		if s.retry > 0 {
+			interval = s.retry
+		}
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-time.After(wsclient.Backoff(interval, s.MaxBackoff.Duration, failures)):
+		}
+
+		failures++
+		st, err := s.connect()
+		if err == nil {
+			s.reconnects.Incr(1)
+			return st
+		}
+		cause = err
+
+		s.Log.Warnf("Reconnect attempt %d failed: %s", failures, cause)
+	}
+}
+
+// readEvents reads the events of a stream until the connection ends. The
+// data lines of an event are joined by newlines and the event is dispatched
+// upon the blank line ending it.
+func (s *SSEListener) readEvents(st *stream) error {
+	eventType := ""
+	var data []string
+
+	for {
+		line, err := st.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if st.idle != nil {
+			st.idle.Reset(s.ReadTimeout.Duration)
+		}
+		s.bytesReceived.Incr(int64(len(line)))
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "" {
+			if len(data) > 0 {
+				s.dispatch(eventType, strings.Join(data, "\n"))
+			}
+			eventType, data = "", nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			// a comment, sent by the servers to keep the connection alive
+			continue
+		}
+
+		name, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			name, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch name {
+		case "event":
+			eventType = value
+		case "data":
+			data = append(data, value)
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				s.lastEventID = value
+			}
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+				s.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// dispatch parses the data of an event of one of the events configured and
+// adds its metrics to the accumulator
+func (s *SSEListener) dispatch(eventType string, data string) {
+	if eventType == "" {
+		eventType = defaultEventType
+	}
+	s.messagesReceived.Incr(1)
+	s.Log.Debugf("recv %s: %s", eventType, data)
+
+	if len(s.Events) > 0 && !choice.Contains(eventType, s.Events) {
+		return
+	}
+
+	metrics, err := s.parser.Parse([]byte(data))
+	if err != nil {
+		s.parseErrors.Incr(1)
+		s.acc.AddError(fmt.Errorf("unable to parse %s event: %s", eventType, err))
+		return
+	}
+	for _, m := range metrics {
+		s.acc.AddMetric(m)
+	}
+}
+
+func newSSEListener() *SSEListener {
+	return &SSEListener{
+		ReadTimeout:       internal.Duration{Duration: defaultReadTimeout},
+		ReconnectInterval: internal.Duration{Duration: wsclient.DefaultReconnectInterval},
+		MaxBackoff:        internal.Duration{Duration: wsclient.DefaultMaxBackoff},
+	}
+}
+
+func init() {
+	inputs.Add("sse_listener", func() telegraf.Input { return newSSEListener() })
+}
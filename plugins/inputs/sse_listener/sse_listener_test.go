@@ -0,0 +1,240 @@
+package sse_listener
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/parsers"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer serves every request of the stream with handler, returning
+// the url of the server
+func newTestServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) string {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		handler(w, r)
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// send writes the events to the stream and flushes them
+func send(w http.ResponseWriter, events string) {
+	fmt.Fprint(w, events)
+	w.(http.Flusher).Flush()
+}
+
+func newTestListener(t *testing.T, url string) *SSEListener {
+	parser, err := parsers.NewParser(&parsers.Config{
+		DataFormat: "json",
+		MetricName: "price",
+		TagKeys:    []string{"symbol"},
+	})
+	require.NoError(t, err)
+
+	s := newSSEListener()
+	s.URL = url
+	s.Log = testutil.Logger{}
+	s.ReconnectInterval = internal.Duration{Duration: 10 * time.Millisecond}
+	s.SetParser(parser)
+	return s
+}
+
+func TestParseEvents(t *testing.T) {
+	url := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "text/event-stream" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		send(w, ": keepalive\n\n"+
+			"data: {\"symbol\": \"ETH-USD\", \"price\": 731.99}\n\n"+
+			"event: price\r\ndata: {\"symbol\": \"BTC-USD\",\r\ndata: \"price\": 21932.98}\r\n\r\n"+
+			"event: heartbeat\ndata\n\n")
+		<-r.Context().Done()
+	})
+
+	s := newTestListener(t, url)
+	require.NoError(t, s.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, s.Start(acc))
+	acc.Wait(2)
+	s.Stop()
+
+	require.NoError(t, acc.FirstError())
+	acc.AssertContainsTaggedFields(t, "price",
+		map[string]interface{}{"price": 731.99},
+		map[string]string{"symbol": "ETH-USD"},
+	)
+	acc.AssertContainsTaggedFields(t, "price",
+		map[string]interface{}{"price": 21932.98},
+		map[string]string{"symbol": "BTC-USD"},
+	)
+}
+
+func TestEventsFilter(t *testing.T) {
+	url := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		send(w, "data: {\"symbol\": \"ETH-USD\", \"price\": 731.99}\n\n"+
+			"event: status\ndata: not json\n\n"+
+			"event: price\ndata: {\"symbol\": \"BTC-USD\", \"price\": 21932.98}\n\n")
+		<-r.Context().Done()
+	})
+
+	s := newTestListener(t, url)
+	s.Events = []string{"price"}
+	require.NoError(t, s.Init())
+
+	acc := &testutil.Accumulator{}
+	messages := s.messagesReceived.Get()
+	require.NoError(t, s.Start(acc))
+	acc.Wait(1)
+	s.Stop()
+
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, int64(3), s.messagesReceived.Get()-messages)
+	require.Len(t, acc.GetTelegrafMetrics(), 1)
+	acc.AssertContainsTaggedFields(t, "price",
+		map[string]interface{}{"price": 21932.98},
+		map[string]string{"symbol": "BTC-USD"},
+	)
+}
+
+func TestHeaders(t *testing.T) {
+	authorization := make(chan string, 1)
+	url := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		authorization <- r.Header.Get("Authorization")
+		send(w, ": connected\n\n")
+		<-r.Context().Done()
+	})
+
+	s := newTestListener(t, url)
+	s.Headers = map[string]string{"Authorization": "Bearer token"}
+	require.NoError(t, s.Init())
+	require.NoError(t, s.Start(&testutil.Accumulator{}))
+	defer s.Stop()
+
+	require.Equal(t, "Bearer token", <-authorization)
+}
+
+func TestReconnectResumesFromLastEventID(t *testing.T) {
+	lastEventIDs := make(chan string, 10)
+	url := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case lastEventIDs <- r.Header.Get("Last-Event-ID"):
+		default:
+		}
+		// drop the connection after the first event
+		send(w, "retry: 20\nid: 1\ndata: {\"symbol\": \"ETH-USD\", \"price\": 731.99}\n\n")
+	})
+
+	s := newTestListener(t, url)
+	s.ReconnectInterval = internal.Duration{Duration: time.Hour}
+	require.NoError(t, s.Init())
+
+	acc := &testutil.Accumulator{}
+	reconnects := s.reconnects.Get()
+	require.NoError(t, s.Start(acc))
+	acc.Wait(3)
+	s.Stop()
+
+	require.Equal(t, "", <-lastEventIDs)
+	require.Equal(t, "1", <-lastEventIDs)
+	require.GreaterOrEqual(t, s.reconnects.Get()-reconnects, int64(2))
+}
+
+func TestReconnectWhenIdle(t *testing.T) {
+	url := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		send(w, "data: {\"symbol\": \"ETH-USD\", \"price\": 731.99}\n\n")
+		<-r.Context().Done()
+	})
+
+	s := newTestListener(t, url)
+	s.ReadTimeout = internal.Duration{Duration: 50 * time.Millisecond}
+	require.NoError(t, s.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, s.Start(acc))
+	acc.Wait(2)
+	s.Stop()
+
+	require.NoError(t, acc.FirstError())
+}
+
+func TestParseError(t *testing.T) {
+	url := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		send(w, "data: not json\n\n")
+		<-r.Context().Done()
+	})
+
+	s := newTestListener(t, url)
+	require.NoError(t, s.Init())
+
+	acc := &testutil.Accumulator{}
+	parseErrors := s.parseErrors.Get()
+	require.NoError(t, s.Start(acc))
+	acc.WaitError(1)
+	s.Stop()
+
+	require.Contains(t, acc.FirstError().Error(), "unable to parse message event")
+	require.Equal(t, int64(1), s.parseErrors.Get()-parseErrors)
+}
+
+func TestGivesUpOnRejectedRequest(t *testing.T) {
+	connected := false
+	url := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if connected {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		connected = true
+	})
+
+	s := newTestListener(t, url)
+	require.NoError(t, s.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, s.Start(acc))
+	acc.WaitError(1)
+	s.Stop()
+
+	require.Contains(t, acc.FirstError().Error(), "giving up")
+	require.Contains(t, acc.FirstError().Error(), "401 Unauthorized")
+}
+
+func TestStartFails(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	url := ts.URL
+	ts.Close()
+
+	s := newTestListener(t, url)
+	require.NoError(t, s.Init())
+	require.Error(t, s.Start(&testutil.Accumulator{}))
+
+	// not an event stream
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+	}))
+	defer ts.Close()
+
+	s = newTestListener(t, ts.URL)
+	require.NoError(t, s.Init())
+	require.Error(t, s.Start(&testutil.Accumulator{}))
+}
+
+func TestInit(t *testing.T) {
+	s := newTestListener(t, "wss://stream.example.com")
+	require.Error(t, s.Init())
+
+	s = newTestListener(t, "https://stream.example.com")
+	s.MaxReconnectAttempts = -1
+	require.Error(t, s.Init())
+
+	s = newTestListener(t, "https://stream.example.com")
+	require.NoError(t, s.Init())
+}
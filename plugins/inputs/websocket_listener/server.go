@@ -0,0 +1,187 @@
+package websocket_listener
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf/internal/wsclient"
+	"github.com/influxdata/telegraf/selfstat"
+)
+
+// server accepts the websocket connections of publishers on the listen
+// address, handing their messages to the listener like those of the feed
+// dialed
+type server struct {
+	listener *WebSocketListener
+	http     *http.Server
+	upgrader websocket.Upgrader
+	addr     net.Addr
+
+	// cancelled by stop, ending the keepalive of the connections
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// the connections of the publishers, no more being accepted once
+	// stopped
+	conns   map[*websocket.Conn]struct{}
+	stopped bool
+	mutex   sync.Mutex
+
+	publishersConnected selfstat.Stat
+	messagesReceived    selfstat.Stat
+	bytesReceived       selfstat.Stat
+	oversizeMessages    selfstat.Stat
+}
+
+func newServer(w *WebSocketListener, tlsConfig *tls.Config) *server {
+	s := &server{
+		listener: w,
+		upgrader: websocket.Upgrader{EnableCompression: w.EnableCompression},
+		conns:    make(map[*websocket.Conn]struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(w.Path, s.serveWebSocket)
+	s.http = &http.Server{
+		Addr:      w.Listen,
+		Handler:   mux,
+		TLSConfig: tlsConfig,
+	}
+
+	tags := map[string]string{
+		"listen": w.Listen,
+	}
+	s.publishersConnected = selfstat.Register("websocket_listener", "publishers_connected", tags)
+	s.messagesReceived = selfstat.Register("websocket_listener", "messages_received", tags)
+	s.bytesReceived = selfstat.Register("websocket_listener", "bytes_received", tags)
+	s.oversizeMessages = selfstat.Register("websocket_listener", "oversize_messages", tags)
+	return s
+}
+
+// start listens and serves the publishers
+func (s *server) start() error {
+	var l net.Listener
+	var err error
+	if s.http.TLSConfig != nil {
+		l, err = tls.Listen("tcp", s.http.Addr, s.http.TLSConfig)
+	} else {
+		l, err = net.Listen("tcp", s.http.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	s.addr = l.Addr()
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.listener.Log.Infof("Listening on %s", s.addr)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		err := s.http.Serve(l)
+		if err != nil && err != http.ErrServerClosed {
+			s.listener.Log.Errorf("Server error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// stop stops listening and closes the connections of the publishers,
+// waiting up to wsclient.CloseTimeout for them to answer the close frame
+func (s *server) stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = s.http.Shutdown(ctx)
+	s.cancel()
+
+	// the websocket connections were hijacked from the server, which doesn't
+	// track them anymore
+	s.mutex.Lock()
+	s.stopped = true
+	for conn := range s.conns {
+		deadline := time.Now().Add(wsclient.CloseTimeout)
+		err := conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), deadline)
+		if err != nil {
+			conn.Close()
+		} else {
+			_ = conn.SetReadDeadline(deadline)
+		}
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+}
+
+// serveWebSocket upgrades the request of a publisher and handles its
+// messages until it disconnects
+func (s *server) serveWebSocket(rw http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		// the upgrader answered the request with the error
+		s.listener.Log.Debugf("Unable to upgrade connection from %s: %v", r.RemoteAddr, err)
+		return
+	}
+	defer conn.Close()
+
+	s.mutex.Lock()
+	if s.stopped {
+		s.mutex.Unlock()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	s.mutex.Unlock()
+	defer s.wg.Done()
+
+	s.publishersConnected.Incr(1)
+	s.listener.Log.Debugf("Publisher %s connected", conn.RemoteAddr())
+
+	maxSize := s.listener.MaxMessageSize.Size
+	conn.SetReadLimit(maxSize)
+
+	pingInterval, pongWait := s.listener.PingInterval.Duration, s.listener.PongWait.Duration
+	wsclient.Keepalive(s.ctx, &s.wg, conn, pingInterval, pongWait)
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				s.oversizeMessages.Incr(1)
+				s.listener.acc.AddError(fmt.Errorf("publisher %s: %w of %d bytes, disconnected",
+					conn.RemoteAddr(), wsclient.ErrMessageTooLarge, maxSize))
+			}
+			if s.ctx.Err() == nil {
+				s.listener.Log.Debugf("Publisher %s disconnected: %v", conn.RemoteAddr(), err)
+			}
+			break
+		}
+
+		s.messagesReceived.Incr(1)
+		s.bytesReceived.Incr(int64(len(message)))
+		if s.ctx.Err() == nil {
+			wsclient.ExtendDeadline(conn, pingInterval, pongWait)
+		}
+
+		message, err = wsclient.Decompress(s.listener.MessageCompression, messageType, message, maxSize)
+		if err != nil {
+			if errors.Is(err, wsclient.ErrMessageTooLarge) {
+				s.oversizeMessages.Incr(1)
+			}
+			s.listener.acc.AddError(fmt.Errorf("unable to decompress incoming msg: %s", err))
+			continue
+		}
+		s.listener.Handle(message)
+	}
+
+	s.mutex.Lock()
+	delete(s.conns, conn)
+	s.mutex.Unlock()
+	s.publishersConnected.Incr(-1)
+}
@@ -0,0 +1,219 @@
+package websocket_listener
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/wsclient"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServerListener(t *testing.T) *WebSocketListener {
+	w := newTestListener(t, "")
+	w.Listen = "127.0.0.1:0"
+	w.Path = "/publish"
+	require.NoError(t, w.Init())
+	return w
+}
+
+// publish connects a publisher to the listener
+func publish(t *testing.T, w *WebSocketListener) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/publish", w.server.addr), nil)
+	require.NoError(t, err)
+	return conn
+}
+
+func TestAcceptPublishers(t *testing.T) {
+	w := newTestServerListener(t)
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, w.Start(acc))
+	defer w.Stop()
+
+	first := publish(t, w)
+	defer first.Close()
+	second := publish(t, w)
+	defer second.Close()
+
+	require.NoError(t, first.WriteMessage(websocket.TextMessage, []byte(`{"symbol": "ETH-USD", "price": 731.99}`)))
+	require.NoError(t, second.WriteMessage(websocket.BinaryMessage, []byte(`{"symbol": "BTC-USD", "price": 21932.98}`)))
+	acc.Wait(2)
+
+	require.NoError(t, acc.FirstError())
+	acc.AssertContainsTaggedFields(t, "price",
+		map[string]interface{}{"price": 731.99},
+		map[string]string{"symbol": "ETH-USD"},
+	)
+	acc.AssertContainsTaggedFields(t, "price",
+		map[string]interface{}{"price": 21932.98},
+		map[string]string{"symbol": "BTC-USD"},
+	)
+	require.Equal(t, int64(2), w.server.publishersConnected.Get())
+}
+
+func TestAcceptAndDial(t *testing.T) {
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"symbol": "ETH-USD", "price": 731.99}`))
+		_, _, _ = conn.ReadMessage()
+	})
+
+	w := newTestListener(t, url)
+	w.Listen = "127.0.0.1:0"
+	require.NoError(t, w.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, w.Start(acc))
+	defer w.Stop()
+
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/", w.server.addr), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"symbol": "BTC-USD", "price": 21932.98}`)))
+
+	acc.Wait(2)
+	require.NoError(t, acc.FirstError())
+}
+
+func TestStopClosesPublishers(t *testing.T) {
+	w := newTestServerListener(t)
+	require.NoError(t, w.Start(&testutil.Accumulator{}))
+
+	conn := publish(t, w)
+	defer conn.Close()
+	require.Eventually(t, func() bool { return w.server.publishersConnected.Get() == 1 }, time.Second, time.Millisecond)
+
+	// answers the close frame
+	closed := make(chan error, 1)
+	go func() {
+		_, _, err := conn.ReadMessage()
+		closed <- err
+	}()
+	w.Stop()
+
+	err := <-closed
+	require.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+	require.Equal(t, int64(0), w.server.publishersConnected.Get())
+}
+
+func TestPublisherKeepalive(t *testing.T) {
+	w := newTestListener(t, "")
+	w.Listen = "127.0.0.1:0"
+	w.Path = "/publish"
+	w.PingInterval = internal.Duration{Duration: 10 * time.Millisecond}
+	w.PongWait = internal.Duration{Duration: 50 * time.Millisecond}
+	require.NoError(t, w.Init())
+	require.NoError(t, w.Start(&testutil.Accumulator{}))
+	defer w.Stop()
+
+	// a publisher answering no pings, as it never reads, is disconnected
+	conn := publish(t, w)
+	defer conn.Close()
+	require.Eventually(t, func() bool { return w.server.publishersConnected.Get() == 1 }, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool { return w.server.publishersConnected.Get() == 0 }, 5*time.Second, time.Millisecond)
+}
+
+func TestInitListen(t *testing.T) {
+	w := newTestListener(t, "")
+	require.Error(t, w.Init())
+
+	w = newTestListener(t, "")
+	w.Listen = ":0"
+	w.PongWait = internal.Duration{Duration: 5 * time.Second}
+	require.Error(t, w.Init())
+
+	w = newTestListener(t, "")
+	w.Listen = ":0"
+	require.NoError(t, w.Init())
+	require.Equal(t, "/", w.Path)
+}
+
+func TestPublisherCompression(t *testing.T) {
+	w := newTestListener(t, "")
+	w.Listen = "127.0.0.1:0"
+	w.Path = "/publish"
+	w.EnableCompression = true
+	require.NoError(t, w.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, w.Start(acc))
+	defer w.Stop()
+
+	dialer := websocket.Dialer{EnableCompression: true}
+	conn, resp, err := dialer.Dial(fmt.Sprintf("ws://%s/publish", w.server.addr), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Contains(t, resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"symbol": "ETH-USD", "price": 731.99}`)))
+	acc.Wait(1)
+	require.NoError(t, acc.FirstError())
+}
+
+func TestPublisherMessageCompression(t *testing.T) {
+	w := newTestListener(t, "")
+	w.Listen = "127.0.0.1:0"
+	w.MessageCompression = "deflate"
+	require.NoError(t, w.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, w.Start(acc))
+	defer w.Stop()
+
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/", w.server.addr), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.BestSpeed)
+	require.NoError(t, err)
+	_, err = fw.Write([]byte(`{"symbol": "ETH-USD", "price": 731.99}`))
+	require.NoError(t, err)
+	require.NoError(t, fw.Close())
+
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte{0xff, 0xff, 0xff}))
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, buf.Bytes()))
+	acc.Wait(1)
+
+	require.Len(t, acc.Errors, 1)
+	require.Contains(t, acc.Errors[0].Error(), "unable to decompress incoming msg")
+	acc.AssertContainsTaggedFields(t, "price",
+		map[string]interface{}{"price": 731.99},
+		map[string]string{"symbol": "ETH-USD"},
+	)
+}
+
+func TestPublisherMaxMessageSize(t *testing.T) {
+	w := newTestListener(t, "")
+	w.Listen = "127.0.0.1:0"
+	w.MaxMessageSize = internal.Size{Size: 64}
+	require.NoError(t, w.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, w.Start(acc))
+	defer w.Stop()
+
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/", w.server.addr), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	oversize := w.server.oversizeMessages.Get()
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"symbol": "ETH-USD", "price": 731.99}`)))
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"symbol": "ETH-USD", "note": "`+strings.Repeat("x", 64)+`"}`)))
+
+	// the publisher is disconnected
+	_, _, err = conn.ReadMessage()
+	require.Error(t, err)
+
+	acc.Wait(1)
+	require.Eventually(t, func() bool { return acc.FirstError() != nil }, 5*time.Second, time.Millisecond)
+	require.True(t, errors.Is(acc.FirstError(), wsclient.ErrMessageTooLarge))
+	require.Equal(t, int64(1), w.server.oversizeMessages.Get()-oversize)
+}
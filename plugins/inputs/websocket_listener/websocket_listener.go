@@ -0,0 +1,291 @@
+package websocket_listener
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/wsclient"
+	"github.com/influxdata/telegraf/plugins/common/tls"
+	"github.com/influxdata/telegraf/plugins/inputs"
+	"github.com/influxdata/telegraf/plugins/parsers"
+	"github.com/influxdata/telegraf/selfstat"
+)
+
+type WebSocketListener struct {
+	URL           string            `toml:"url"`
+	Headers       map[string]string `toml:"headers"`
+	OnConnectMsgs []string          `toml:"on_connect_msgs"`
+
+	Listen    string           `toml:"listen"`
+	Path      string           `toml:"path"`
+	ListenTLS tls.ServerConfig `toml:"listen_tls"`
+
+	PingInterval         internal.Duration `toml:"ping_interval"`
+	PongWait             internal.Duration `toml:"pong_wait"`
+	ReconnectInterval    internal.Duration `toml:"reconnect_interval"`
+	MaxBackoff           internal.Duration `toml:"max_backoff"`
+	MaxReconnectAttempts int               `toml:"max_reconnect_attempts"`
+
+	EnableCompression  bool   `toml:"enable_compression"`
+	MessageCompression string `toml:"message_compression"`
+
+	MaxMessageSize internal.Size `toml:"max_message_size"`
+
+	tls.ClientConfig
+
+	Log telegraf.Logger `toml:"-"`
+
+	// the messages of the url and of the publishers are parsed concurrently,
+	// while parsers keeping state between calls are not safe for it
+	parser      parsers.Parser
+	parserMutex sync.Mutex
+
+	acc    telegraf.Accumulator
+	client *wsclient.Client
+	server *server
+
+	parseErrors selfstat.Stat
+}
+
+func (w *WebSocketListener) SampleConfig() string {
+	return `
+## Websocket URL to connect to
+url = "wss://stream.example.com/feed"
+## Address to listen on for publishers connecting to the plugin and sending
+## their messages, along with or instead of the url dialed, e.g. ":8080"
+# listen = ""
+## Path of the websocket endpoint of the publishers
+# path = "/"
+## Messages sent upon connecting, and again after every reconnect, e.g. to
+## subscribe to a feed
+# on_connect_msgs = ['{"op": "subscribe", "channel": "prices"}']
+## Ping the server every ping_interval and reconnect when neither a pong nor
+## a message was received for pong_wait, to detect half-open connections.
+## A ping_interval of "0s" disables the keepalive.
+# ping_interval = "10s"
+# pong_wait = "20s"
+## Delay before reconnecting after the connection dropped, doubled after
+## every failed attempt up to max_backoff
+# reconnect_interval = "1s"
+# max_backoff = "1m"
+## Give up reconnecting after this many consecutive failed attempts, 0 retries
+## forever. A handshake rejected by the server, e.g. with 401 Unauthorized,
+## stops reconnecting immediately.
+# max_reconnect_attempts = 0
+## Negotiate the permessage-deflate compression of the messages on the
+## websocket handshake, with the url and the publishers. Uncompressed
+## messages are received when the other end declines.
+# enable_compression = false
+## Compression of the payload of the binary messages, decompressed before
+## being parsed: "none", "gzip" or "deflate", raw or with a zlib header.
+## Text messages are always parsed as they are.
+# message_compression = "none"
+## Maximum size of a message, as received and once decompressed, bounding the
+## memory used by a misbehaving server. A larger message is reported as an
+## error and breaks the connection, which is re-established. 0 is unbounded.
+# max_message_size = "0"
+## Optional TLS Config
+# tls_ca = "/etc/telegraf/ca.pem"
+# tls_cert = "/etc/telegraf/cert.pem"
+# tls_key = "/etc/telegraf/key.pem"
+## Use TLS but skip chain & host verification
+# insecure_skip_verify = false
+## Data format of the messages, every text or binary frame is parsed on its
+## own. Each data format has its own unique set of configuration options,
+## read more about them here:
+## https://github.com/influxdata/telegraf/blob/master/docs/DATA_FORMATS_INPUT.md
+data_format = "json"
+
+## Headers of the websocket handshake, e.g. for authentication
+# [inputs.websocket_listener.headers]
+#   Authorization = "Bearer <token>"
+
+## TLS of the listen address, tls_allowed_cacerts requiring the publishers
+## to present a client certificate
+# [inputs.websocket_listener.listen_tls]
+#   tls_cert = "/etc/telegraf/cert.pem"
+#   tls_key = "/etc/telegraf/key.pem"
+#   tls_allowed_cacerts = ["/etc/telegraf/clientca.pem"]
+`
+}
+
+func (w *WebSocketListener) Description() string {
+	return "Receives metrics from the messages of a websocket feed"
+}
+
+func (w *WebSocketListener) Gather(_ telegraf.Accumulator) error {
+	return nil
+}
+
+func (w *WebSocketListener) SetParser(parser parsers.Parser) {
+	w.parserMutex.Lock()
+	defer w.parserMutex.Unlock()
+	w.parser = parser
+}
+
+func (w *WebSocketListener) Init() error {
+	if w.URL == "" && w.Listen == "" {
+		return fmt.Errorf("url or listen must be set")
+	}
+	if err := wsclient.ValidateCompression(w.MessageCompression); err != nil {
+		return err
+	}
+	if w.MaxMessageSize.Size < 0 {
+		return fmt.Errorf("invalid max_message_size %d, must not be negative", w.MaxMessageSize.Size)
+	}
+	if w.Listen != "" {
+		if err := w.initServer(); err != nil {
+			return err
+		}
+	}
+	if w.URL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return fmt.Errorf("invalid url %q, the scheme must be ws or wss", w.URL)
+	}
+
+	tlsCfg, err := w.ClientConfig.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	header := make(http.Header)
+	for k, v := range w.Headers {
+		header.Set(k, v)
+	}
+
+	tags := map[string]string{
+		"url": w.URL,
+	}
+	cfg := wsclient.Config{
+		URL:    w.URL,
+		Header: header,
+		Dialer: &websocket.Dialer{
+			Proxy:             http.ProxyFromEnvironment,
+			HandshakeTimeout:  wsclient.HandshakeTimeout,
+			TLSClientConfig:   tlsCfg,
+			EnableCompression: w.EnableCompression,
+		},
+		PingInterval:         w.PingInterval.Duration,
+		PongWait:             w.PongWait.Duration,
+		ReconnectInterval:    w.ReconnectInterval.Duration,
+		MaxBackoff:           w.MaxBackoff.Duration,
+		MaxReconnectAttempts: w.MaxReconnectAttempts,
+		MessageCompression:   w.MessageCompression,
+		MaxMessageSize:       w.MaxMessageSize.Size,
+		StatsName:            "websocket_listener",
+		StatsTags:            tags,
+		Log:                  w.Log,
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	w.client = wsclient.New(cfg, w)
+	w.parseErrors = selfstat.Register("websocket_listener", "parse_errors", tags)
+	return nil
+}
+
+// initServer validates the keepalive of the publishers, and sets up the
+// server accepting them
+func (w *WebSocketListener) initServer() error {
+	if w.PingInterval.Duration > 0 && w.PongWait.Duration <= w.PingInterval.Duration {
+		return fmt.Errorf("pong_wait %s must be longer than ping_interval %s", w.PongWait.Duration, w.PingInterval.Duration)
+	}
+	if w.Path == "" {
+		w.Path = "/"
+	}
+
+	tlsCfg, err := w.ListenTLS.TLSConfig()
+	if err != nil {
+		return err
+	}
+	w.server = newServer(w, tlsCfg)
+	// the parse errors of the publishers, when no url is dialed
+	w.parseErrors = selfstat.Register("websocket_listener", "parse_errors", map[string]string{"listen": w.Listen})
+	return nil
+}
+
+func (w *WebSocketListener) Start(acc telegraf.Accumulator) error {
+	w.acc = acc
+	if w.server != nil {
+		if err := w.server.start(); err != nil {
+			return err
+		}
+	}
+	if w.client != nil {
+		if err := w.client.Start(acc); err != nil {
+			if w.server != nil {
+				w.server.stop()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WebSocketListener) Stop() {
+	if w.client != nil {
+		w.client.Stop()
+	}
+	if w.server != nil {
+		w.server.stop()
+	}
+}
+
+// Subscribe sends on_connect_msgs on every new connection
+func (w *WebSocketListener) Subscribe(conn *websocket.Conn) error {
+	for _, msg := range w.OnConnectMsgs {
+		w.Log.Debugf("Sending: %s", msg)
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handle parses a message and adds its metrics to the accumulator
+func (w *WebSocketListener) Handle(message []byte) {
+	w.Log.Debugf("recv: %s", message)
+
+	metrics, err := w.parse(message)
+	if err != nil {
+		w.parseErrors.Incr(1)
+		w.acc.AddError(fmt.Errorf("unable to parse incoming msg: %s", err))
+		return
+	}
+	for _, m := range metrics {
+		w.acc.AddMetric(m)
+	}
+}
+
+// parse parses a message, one at a time
+func (w *WebSocketListener) parse(message []byte) ([]telegraf.Metric, error) {
+	w.parserMutex.Lock()
+	defer w.parserMutex.Unlock()
+	return w.parser.Parse(message)
+}
+
+func newWebSocketListener() *WebSocketListener {
+	return &WebSocketListener{
+		PingInterval:      internal.Duration{Duration: wsclient.DefaultPingInterval},
+		PongWait:          internal.Duration{Duration: wsclient.DefaultPongWait},
+		ReconnectInterval: internal.Duration{Duration: wsclient.DefaultReconnectInterval},
+		MaxBackoff:        internal.Duration{Duration: wsclient.DefaultMaxBackoff},
+	}
+}
+
+func init() {
+	inputs.Add("websocket_listener", func() telegraf.Input { return newWebSocketListener() })
+}
@@ -0,0 +1,317 @@
+package websocket_listener
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/parsers"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+var upgrader = websocket.Upgrader{}
+
+// newTestServer serves every websocket connection with handler, returning
+// the ws:// url of the server
+func newTestServer(t *testing.T, handler func(conn *websocket.Conn)) string {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handler(conn)
+	}))
+	t.Cleanup(ts.Close)
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+func newTestListener(t *testing.T, url string) *WebSocketListener {
+	parser, err := parsers.NewParser(&parsers.Config{
+		DataFormat: "json",
+		MetricName: "price",
+		TagKeys:    []string{"symbol"},
+	})
+	require.NoError(t, err)
+
+	w := newWebSocketListener()
+	w.URL = url
+	w.Log = testutil.Logger{}
+	w.ReconnectInterval = internal.Duration{Duration: 10 * time.Millisecond}
+	w.SetParser(parser)
+	return w
+}
+
+func TestSubscribeAndParse(t *testing.T) {
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		_, msg, err := conn.ReadMessage()
+		if err != nil || string(msg) != `{"op": "subscribe"}` {
+			return
+		}
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"symbol": "ETH-USD", "price": 731.99}`))
+		_ = conn.WriteMessage(websocket.BinaryMessage, []byte(`{"symbol": "BTC-USD", "price": 21932.98}`))
+		_, _, _ = conn.ReadMessage()
+	})
+
+	w := newTestListener(t, url)
+	w.OnConnectMsgs = []string{`{"op": "subscribe"}`}
+	require.NoError(t, w.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, w.Start(acc))
+	acc.Wait(2)
+	w.Stop()
+
+	require.NoError(t, acc.FirstError())
+	acc.AssertContainsTaggedFields(t, "price",
+		map[string]interface{}{"price": 731.99},
+		map[string]string{"symbol": "ETH-USD"},
+	)
+	acc.AssertContainsTaggedFields(t, "price",
+		map[string]interface{}{"price": 21932.98},
+		map[string]string{"symbol": "BTC-USD"},
+	)
+}
+
+func TestHandshakeHeaders(t *testing.T) {
+	authorization := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization <- r.Header.Get("Authorization")
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer ts.Close()
+
+	w := newTestListener(t, "ws"+strings.TrimPrefix(ts.URL, "http"))
+	w.Headers = map[string]string{"Authorization": "Bearer token"}
+	require.NoError(t, w.Init())
+	require.NoError(t, w.Start(&testutil.Accumulator{}))
+	defer w.Stop()
+
+	require.Equal(t, "Bearer token", <-authorization)
+}
+
+func TestReconnectResendsOnConnectMsgs(t *testing.T) {
+	subscriptions := make(chan struct{}, 10)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		subscriptions <- struct{}{}
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"symbol": "ETH-USD", "price": 731.99}`))
+		// drop the connection after the first message
+	})
+
+	w := newTestListener(t, url)
+	w.OnConnectMsgs = []string{`{"op": "subscribe"}`}
+	require.NoError(t, w.Init())
+
+	acc := &testutil.Accumulator{}
+	reconnects := w.client.Reconnects.Get()
+	require.NoError(t, w.Start(acc))
+	acc.Wait(3)
+	w.Stop()
+
+	require.GreaterOrEqual(t, len(subscriptions), 3)
+	require.GreaterOrEqual(t, w.client.Reconnects.Get()-reconnects, int64(2))
+}
+
+func TestParseError(t *testing.T) {
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`not json`))
+		_, _, _ = conn.ReadMessage()
+	})
+
+	w := newTestListener(t, url)
+	require.NoError(t, w.Init())
+
+	acc := &testutil.Accumulator{}
+	parseErrors := w.parseErrors.Get()
+	require.NoError(t, w.Start(acc))
+	acc.WaitError(1)
+	w.Stop()
+
+	require.Contains(t, acc.FirstError().Error(), "unable to parse incoming msg")
+	require.Equal(t, int64(1), w.parseErrors.Get()-parseErrors)
+}
+
+func TestGivesUpOnRejectedHandshake(t *testing.T) {
+	connected := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if connected {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		connected = true
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer ts.Close()
+
+	w := newTestListener(t, "ws"+strings.TrimPrefix(ts.URL, "http"))
+	require.NoError(t, w.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, w.Start(acc))
+	acc.WaitError(1)
+	w.Stop()
+
+	require.Contains(t, acc.FirstError().Error(), "giving up")
+	require.Contains(t, acc.FirstError().Error(), "401 Unauthorized")
+}
+
+func TestStartFailsWhenUnreachable(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+	ts.Close()
+
+	w := newTestListener(t, url)
+	require.NoError(t, w.Init())
+	require.Error(t, w.Start(&testutil.Accumulator{}))
+}
+
+func TestInit(t *testing.T) {
+	w := newTestListener(t, "http://stream.example.com")
+	require.Error(t, w.Init())
+
+	w = newTestListener(t, "wss://stream.example.com")
+	w.PongWait = internal.Duration{Duration: 5 * time.Second}
+	require.Error(t, w.Init())
+
+	w = newTestListener(t, "wss://stream.example.com")
+	w.MaxMessageSize = internal.Size{Size: -1}
+	require.Error(t, w.Init())
+
+	w = newTestListener(t, "wss://stream.example.com")
+	w.PingInterval = internal.Duration{}
+	w.MaxMessageSize = internal.Size{Size: 16 * 1024 * 1024}
+	require.NoError(t, w.Init())
+	require.Equal(t, int64(16*1024*1024), w.client.MaxMessageSize)
+}
+
+func TestEnableCompression(t *testing.T) {
+	extensions := make(chan string, 1)
+	upgrader := websocket.Upgrader{EnableCompression: true}
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		extensions <- r.Header.Get("Sec-Websocket-Extensions")
+		conn, err := upgrader.Upgrade(rw, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"symbol": "ETH-USD", "price": 731.99}`))
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer ts.Close()
+
+	w := newTestListener(t, "ws"+strings.TrimPrefix(ts.URL, "http"))
+	w.EnableCompression = true
+	require.NoError(t, w.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, w.Start(acc))
+	acc.Wait(1)
+	w.Stop()
+
+	require.Contains(t, <-extensions, "permessage-deflate")
+	require.NoError(t, acc.FirstError())
+	acc.AssertContainsTaggedFields(t, "price",
+		map[string]interface{}{"price": 731.99},
+		map[string]string{"symbol": "ETH-USD"},
+	)
+}
+
+func TestMessageCompression(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(`{"symbol": "BTC-USD", "price": 21932.98}`))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		_ = conn.WriteMessage(websocket.BinaryMessage, buf.Bytes())
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"symbol": "ETH-USD", "price": 731.99}`))
+		_, _, _ = conn.ReadMessage()
+	})
+
+	w := newTestListener(t, url)
+	w.MessageCompression = "zstd"
+	require.Error(t, w.Init())
+
+	w = newTestListener(t, url)
+	w.MessageCompression = "gzip"
+	require.NoError(t, w.Init())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, w.Start(acc))
+	acc.Wait(2)
+	w.Stop()
+
+	require.NoError(t, acc.FirstError())
+	acc.AssertContainsTaggedFields(t, "price",
+		map[string]interface{}{"price": 21932.98},
+		map[string]string{"symbol": "BTC-USD"},
+	)
+	acc.AssertContainsTaggedFields(t, "price",
+		map[string]interface{}{"price": 731.99},
+		map[string]string{"symbol": "ETH-USD"},
+	)
+}
+
+// exclusiveParser fails when Parse is entered by more than one goroutine at
+// a time, like a parser keeping state between calls would misbehave
+type exclusiveParser struct {
+	parsers.Parser
+	inFlight int32
+	overlaps int32
+}
+
+func (p *exclusiveParser) Parse(buf []byte) ([]telegraf.Metric, error) {
+	if atomic.AddInt32(&p.inFlight, 1) > 1 {
+		atomic.AddInt32(&p.overlaps, 1)
+	}
+	defer atomic.AddInt32(&p.inFlight, -1)
+
+	time.Sleep(time.Millisecond)
+	return p.Parser.Parse(buf)
+}
+
+func TestConcurrentHandleSerializesParser(t *testing.T) {
+	w := newTestListener(t, "")
+	parser := &exclusiveParser{Parser: w.parser}
+	w.SetParser(parser)
+	acc := &testutil.Accumulator{}
+	w.acc = acc
+
+	const messages = 50
+	var wg sync.WaitGroup
+	for i := 0; i < messages; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.Handle([]byte(`{"symbol": "ETH-USD", "price": 731.99}`))
+		}()
+	}
+	wg.Wait()
+
+	require.NoError(t, acc.FirstError())
+	require.Equal(t, uint64(messages), acc.NMetrics())
+	require.Equal(t, int32(0), atomic.LoadInt32(&parser.overlaps))
+}
@@ -41,5 +41,6 @@ import (
 	_ "github.com/influxdata/telegraf/plugins/outputs/timestream"
 	_ "github.com/influxdata/telegraf/plugins/outputs/warp10"
 	_ "github.com/influxdata/telegraf/plugins/outputs/wavefront"
+	_ "github.com/influxdata/telegraf/plugins/outputs/websocket_broadcast"
 	_ "github.com/influxdata/telegraf/plugins/outputs/yandex_cloud_monitoring"
 )
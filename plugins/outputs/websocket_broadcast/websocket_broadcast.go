@@ -0,0 +1,341 @@
+package websocket_broadcast
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/choice"
+	tlsint "github.com/influxdata/telegraf/plugins/common/tls"
+	"github.com/influxdata/telegraf/plugins/outputs"
+	"github.com/influxdata/telegraf/plugins/serializers"
+	"github.com/influxdata/telegraf/selfstat"
+)
+
+var (
+	defaultListen           = ":8081"
+	defaultPath             = "/"
+	defaultClientBufferSize = 1000
+	defaultWriteTimeout     = internal.Duration{Duration: 10 * time.Second}
+)
+
+// closeTimeout is how long Close waits for a client to answer the close
+// frame
+const closeTimeout = time.Second
+
+var sampleConfig = `
+  ## Address to listen on
+  # listen = ":8081"
+
+  ## Path of the websocket endpoint
+  # path = "/"
+
+  ## Origins of the browsers allowed to connect, e.g.
+  ## ["https://dashboard.example.com"], any when empty. Clients other than
+  ## browsers send no origin and are always allowed.
+  # allowed_origins = []
+
+  ## Messages buffered for every client. A client too slow to keep up misses
+  ## the messages written while its buffer is full.
+  # client_buffer_size = 1000
+
+  ## Timeout of writing a message to a client, which is disconnected when
+  ## exceeded
+  # write_timeout = "10s"
+
+  ## Use batch serialization format instead of line based delimiting, sending
+  ## the metrics of every write as one message rather than every metric as a
+  ## message of its own.
+  # use_batch_format = false
+
+  ## If set, enable TLS with the given certificate.
+  # tls_cert = "/etc/ssl/telegraf.crt"
+  # tls_key = "/etc/ssl/telegraf.key"
+
+  ## Set one or more allowed client CA certificate file names to
+  ## enable mutually authenticated TLS connections
+  # tls_allowed_cacerts = ["/etc/telegraf/clientca.pem"]
+
+  ## Data format to output.
+  ## Each data format has its own unique set of configuration options, read
+  ## more about them here:
+  ## https://github.com/influxdata/telegraf/blob/master/docs/DATA_FORMATS_OUTPUT.md
+  # data_format = "influx"
+`
+
+type WebSocketBroadcast struct {
+	Listen           string            `toml:"listen"`
+	Path             string            `toml:"path"`
+	AllowedOrigins   []string          `toml:"allowed_origins"`
+	ClientBufferSize int               `toml:"client_buffer_size"`
+	WriteTimeout     internal.Duration `toml:"write_timeout"`
+	UseBatchFormat   bool              `toml:"use_batch_format"`
+	tlsint.ServerConfig
+
+	Log telegraf.Logger `toml:"-"`
+
+	serializer serializers.Serializer
+	server     *http.Server
+	upgrader   websocket.Upgrader
+	url        *url.URL
+	wg         sync.WaitGroup
+
+	// the connected clients, no more being accepted once closed
+	clients map[*client]struct{}
+	closed  bool
+	mutex   sync.Mutex
+
+	clientsConnected selfstat.Stat
+	messagesSent     selfstat.Stat
+	messagesDropped  selfstat.Stat
+}
+
+// client is a connected client, whose messages are written by a goroutine
+// of its own so that a slow client doesn't hold up the others
+type client struct {
+	conn *websocket.Conn
+	send chan []byte
+}
+
+func (w *WebSocketBroadcast) Description() string {
+	return "Runs a websocket server pushing the metrics to the connected clients"
+}
+
+func (w *WebSocketBroadcast) SampleConfig() string {
+	return sampleConfig
+}
+
+func (w *WebSocketBroadcast) SetSerializer(serializer serializers.Serializer) {
+	w.serializer = serializer
+}
+
+func (w *WebSocketBroadcast) Init() error {
+	if w.ClientBufferSize < 1 {
+		return fmt.Errorf("client_buffer_size must be at least 1")
+	}
+	if w.Path == "" {
+		w.Path = "/"
+	}
+
+	tlsConfig, err := w.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	w.upgrader = websocket.Upgrader{
+		CheckOrigin: w.checkOrigin,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(w.Path, w.serveWebSocket)
+	w.server = &http.Server{
+		Addr:      w.Listen,
+		Handler:   mux,
+		TLSConfig: tlsConfig,
+	}
+	w.clients = make(map[*client]struct{})
+
+	tags := map[string]string{
+		"listen": w.Listen,
+	}
+	w.clientsConnected = selfstat.Register("websocket_broadcast", "clients_connected", tags)
+	w.messagesSent = selfstat.Register("websocket_broadcast", "messages_sent", tags)
+	w.messagesDropped = selfstat.Register("websocket_broadcast", "messages_dropped", tags)
+	return nil
+}
+
+// checkOrigin allows the browsers of the allowed_origins, and the clients
+// sending no origin
+func (w *WebSocketBroadcast) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(w.AllowedOrigins) == 0 {
+		return true
+	}
+	return choice.Contains(strings.TrimSuffix(origin, "/"), w.AllowedOrigins)
+}
+
+func (w *WebSocketBroadcast) Connect() error {
+	listener, err := w.listen()
+	if err != nil {
+		return err
+	}
+
+	scheme := "ws"
+	if w.server.TLSConfig != nil {
+		scheme = "wss"
+	}
+	w.url = &url.URL{
+		Scheme: scheme,
+		Host:   listener.Addr().String(),
+		Path:   w.Path,
+	}
+	w.Log.Infof("Listening on %s", w.URL())
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		err := w.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			w.Log.Errorf("Server error: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (w *WebSocketBroadcast) listen() (net.Listener, error) {
+	if w.server.TLSConfig != nil {
+		return tls.Listen("tcp", w.Listen, w.server.TLSConfig)
+	}
+	return net.Listen("tcp", w.Listen)
+}
+
+// URL returns the websocket url the plugin is listening on, or an empty
+// string when not listening
+func (w *WebSocketBroadcast) URL() string {
+	if w.url != nil {
+		return w.url.String()
+	}
+	return ""
+}
+
+// Close stops the server and disconnects the clients, sending them a close
+// frame
+func (w *WebSocketBroadcast) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := w.server.Shutdown(ctx)
+
+	// the websocket connections were hijacked from the server, which doesn't
+	// track them anymore
+	w.mutex.Lock()
+	w.closed = true
+	for c := range w.clients {
+		deadline := time.Now().Add(closeTimeout)
+		_ = c.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), deadline)
+		c.conn.Close()
+	}
+	w.mutex.Unlock()
+
+	w.wg.Wait()
+	w.url = nil
+	return err
+}
+
+// Write sends the serialized metrics to every client connected
+func (w *WebSocketBroadcast) Write(metrics []telegraf.Metric) error {
+	var messages [][]byte
+	if w.UseBatchFormat {
+		octets, err := w.serializer.SerializeBatch(metrics)
+		if err != nil {
+			return fmt.Errorf("could not serialize metrics: %v", err)
+		}
+		messages = append(messages, octets)
+	} else {
+		for _, metric := range metrics {
+			octets, err := w.serializer.Serialize(metric)
+			if err != nil {
+				w.Log.Debugf("Could not serialize metric: %v", err)
+				continue
+			}
+			messages = append(messages, octets)
+		}
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for c := range w.clients {
+		for _, msg := range messages {
+			select {
+			case c.send <- msg:
+			default:
+				w.messagesDropped.Incr(1)
+			}
+		}
+	}
+	return nil
+}
+
+// serveWebSocket upgrades a request to a websocket connection and keeps the
+// client connected until it disconnects or the plugin is closed
+func (w *WebSocketBroadcast) serveWebSocket(rw http.ResponseWriter, r *http.Request) {
+	conn, err := w.upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		// the upgrader answered the request with the error
+		w.Log.Debugf("Unable to upgrade connection from %s: %v", r.RemoteAddr, err)
+		return
+	}
+
+	c := &client{
+		conn: conn,
+		send: make(chan []byte, w.ClientBufferSize),
+	}
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		conn.Close()
+		return
+	}
+	w.clients[c] = struct{}{}
+	// the handler as well as the writer, so that Close waits for the client
+	// to be unregistered
+	w.wg.Add(2)
+	w.mutex.Unlock()
+	defer w.wg.Done()
+	w.clientsConnected.Incr(1)
+	w.Log.Debugf("Client %s connected", conn.RemoteAddr())
+
+	go func() {
+		defer w.wg.Done()
+		w.writeMessages(c)
+	}()
+
+	// the messages of the clients are discarded, reading them handles the
+	// pings and the close frame
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	w.mutex.Lock()
+	delete(w.clients, c)
+	close(c.send)
+	w.mutex.Unlock()
+	w.clientsConnected.Incr(-1)
+	w.Log.Debugf("Client %s disconnected", conn.RemoteAddr())
+}
+
+// writeMessages writes the messages sent to a client until it disconnected,
+// closing the connection on a write error, which ends its read loop
+func (w *WebSocketBroadcast) writeMessages(c *client) {
+	for msg := range c.send {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(w.WriteTimeout.Duration))
+		if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			w.Log.Debugf("Unable to write to client %s: %v", c.conn.RemoteAddr(), err)
+			c.conn.Close()
+			break
+		}
+		w.messagesSent.Incr(1)
+	}
+}
+
+func init() {
+	outputs.Add("websocket_broadcast", func() telegraf.Output {
+		return &WebSocketBroadcast{
+			Listen:           defaultListen,
+			Path:             defaultPath,
+			ClientBufferSize: defaultClientBufferSize,
+			WriteTimeout:     defaultWriteTimeout,
+		}
+	})
+}
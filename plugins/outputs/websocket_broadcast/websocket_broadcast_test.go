@@ -0,0 +1,165 @@
+package websocket_broadcast
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/serializers"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+var metrics = []telegraf.Metric{
+	testutil.MustMetric("market_trade",
+		map[string]string{"exchange": "coinbase", "base": "BTC", "quote": "USD", "side": "buy"},
+		map[string]interface{}{"price": 21932.98, "size": 0.5},
+		time.Unix(1614600120, 0),
+	),
+	testutil.MustMetric("market_trade",
+		map[string]string{"exchange": "coinbase", "base": "ETH", "quote": "USD", "side": "sell"},
+		map[string]interface{}{"price": 731.99, "size": 2.0},
+		time.Unix(1614600121, 0),
+	),
+}
+
+func newTestBroadcast(t *testing.T, dataFormat string) *WebSocketBroadcast {
+	serializer, err := serializers.NewSerializer(&serializers.Config{
+		DataFormat:       dataFormat,
+		InfluxSortFields: true,
+		TimestampUnits:   time.Second,
+	})
+	require.NoError(t, err)
+
+	w := &WebSocketBroadcast{
+		Listen:           "127.0.0.1:0",
+		Path:             "/stream",
+		ClientBufferSize: defaultClientBufferSize,
+		WriteTimeout:     defaultWriteTimeout,
+		Log:              testutil.Logger{},
+	}
+	w.SetSerializer(serializer)
+	require.NoError(t, w.Init())
+	require.NoError(t, w.Connect())
+	return w
+}
+
+// dial connects a client, waiting for the plugin to register it
+func dial(t *testing.T, w *WebSocketBroadcast, header http.Header) *websocket.Conn {
+	connected := w.clientsConnected.Get()
+	conn, _, err := websocket.DefaultDialer.Dial(w.URL(), header)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return w.clientsConnected.Get() > connected }, time.Second, time.Millisecond)
+	return conn
+}
+
+func read(t *testing.T, conn *websocket.Conn) string {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	msgType, msg, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, websocket.TextMessage, msgType)
+	return string(msg)
+}
+
+func TestBroadcast(t *testing.T) {
+	w := newTestBroadcast(t, "influx")
+	defer w.Close()
+	require.True(t, strings.HasPrefix(w.URL(), "ws://127.0.0.1:"))
+
+	first := dial(t, w, nil)
+	defer first.Close()
+	second := dial(t, w, nil)
+	defer second.Close()
+
+	require.NoError(t, w.Write(metrics))
+	for _, conn := range []*websocket.Conn{first, second} {
+		require.Equal(t, "market_trade,base=BTC,exchange=coinbase,quote=USD,side=buy price=21932.98,size=0.5 1614600120000000000\n", read(t, conn))
+		require.Equal(t, "market_trade,base=ETH,exchange=coinbase,quote=USD,side=sell price=731.99,size=2 1614600121000000000\n", read(t, conn))
+	}
+}
+
+func TestBatchFormat(t *testing.T) {
+	w := newTestBroadcast(t, "json")
+	defer w.Close()
+	w.UseBatchFormat = true
+
+	conn := dial(t, w, nil)
+	defer conn.Close()
+
+	require.NoError(t, w.Write(metrics))
+	require.JSONEq(t, `{"metrics": [
+		{"name": "market_trade", "tags": {"base": "BTC", "exchange": "coinbase", "quote": "USD", "side": "buy"}, "fields": {"price": 21932.98, "size": 0.5}, "timestamp": 1614600120},
+		{"name": "market_trade", "tags": {"base": "ETH", "exchange": "coinbase", "quote": "USD", "side": "sell"}, "fields": {"price": 731.99, "size": 2}, "timestamp": 1614600121}
+	]}`, read(t, conn))
+}
+
+func TestDisconnectedClient(t *testing.T) {
+	w := newTestBroadcast(t, "influx")
+	defer w.Close()
+
+	conn := dial(t, w, nil)
+	connected := w.clientsConnected.Get()
+	conn.Close()
+	require.Eventually(t, func() bool { return w.clientsConnected.Get() < connected }, time.Second, time.Millisecond)
+
+	require.NoError(t, w.Write(metrics))
+}
+
+func TestSlowClientMissesMessages(t *testing.T) {
+	w := newTestBroadcast(t, "influx")
+	defer w.Close()
+	w.ClientBufferSize = 1
+
+	conn := dial(t, w, nil)
+	defer conn.Close()
+
+	// the write loop of the client holds a message at most besides the one
+	// buffered, the others of the burst are dropped
+	dropped := w.messagesDropped.Get()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, w.Write(metrics))
+	}
+	require.Greater(t, w.messagesDropped.Get()-dropped, int64(0))
+}
+
+func TestAllowedOrigins(t *testing.T) {
+	w := newTestBroadcast(t, "influx")
+	defer w.Close()
+	w.AllowedOrigins = []string{"https://dashboard.example.com"}
+
+	conn := dial(t, w, http.Header{"Origin": []string{"https://dashboard.example.com"}})
+	conn.Close()
+
+	_, resp, err := websocket.DefaultDialer.Dial(w.URL(), http.Header{"Origin": []string{"https://evil.example.com"}})
+	require.Error(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestCloseDisconnectsClients(t *testing.T) {
+	w := newTestBroadcast(t, "influx")
+	conn := dial(t, w, nil)
+	defer conn.Close()
+
+	require.NoError(t, w.Close())
+	require.Empty(t, w.URL())
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, _, err := conn.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+}
+
+func TestInit(t *testing.T) {
+	w := &WebSocketBroadcast{
+		Listen:       "127.0.0.1:0",
+		WriteTimeout: internal.Duration{Duration: time.Second},
+	}
+	require.Error(t, w.Init())
+
+	w.ClientBufferSize = 1
+	require.NoError(t, w.Init())
+	require.Equal(t, "/", w.Path)
+}
@@ -0,0 +1,245 @@
+package arbitrage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/metric"
+	"github.com/influxdata/telegraf/plugins/processors"
+)
+
+const sampleConfig = `
+  ## The measurements carrying the best bid and ask of the exchange inputs,
+  ## by default those of their normalized metric_schema
+  # measurements = ["market_ticker", "market_book"]
+
+  ## The fields of the best bid and ask
+  # bid_field = "bid"
+  # ask_field = "ask"
+
+  ## The tag of the exchange, and the tags of the pair joined across exchanges
+  # exchange_tag = "exchange"
+  # pair_tags = ["base", "quote"]
+
+  ## Quotes older than max_quote_age, relative to the quote received, are not
+  ## joined anymore
+  # max_quote_age = "10s"
+
+  ## A spread above threshold_bps, in basis points of the ask, is flagged as
+  ## an opportunity
+  # threshold_bps = 10.0
+
+  ## Emit the spread only when flagged as an opportunity
+  # opportunities_only = false
+
+  ## The measurement of the spreads
+  # measurement = "arbitrage_spread"
+`
+
+// quote is the last best bid and ask of a pair on one exchange
+type quote struct {
+	bid  float64
+	ask  float64
+	time time.Time
+}
+
+type Arbitrage struct {
+	Measurements      []string          `toml:"measurements"`
+	BidField          string            `toml:"bid_field"`
+	AskField          string            `toml:"ask_field"`
+	ExchangeTag       string            `toml:"exchange_tag"`
+	PairTags          []string          `toml:"pair_tags"`
+	MaxQuoteAge       internal.Duration `toml:"max_quote_age"`
+	ThresholdBps      float64           `toml:"threshold_bps"`
+	OpportunitiesOnly bool              `toml:"opportunities_only"`
+	Measurement       string            `toml:"measurement"`
+
+	// the last quote of every exchange by pair
+	quotes map[string]map[string]quote
+}
+
+func (a *Arbitrage) SampleConfig() string {
+	return sampleConfig
+}
+
+func (a *Arbitrage) Description() string {
+	return "Join the best bid and ask of a pair across exchanges into the cross-exchange spread"
+}
+
+func (a *Arbitrage) Init() error {
+	if a.ExchangeTag == "" {
+		return fmt.Errorf("exchange_tag must be set")
+	}
+	if len(a.PairTags) == 0 {
+		return fmt.Errorf("pair_tags must be set")
+	}
+	if a.BidField == "" || a.AskField == "" {
+		return fmt.Errorf("bid_field and ask_field must be set")
+	}
+	if a.MaxQuoteAge.Duration < 0 {
+		return fmt.Errorf("invalid max_quote_age %s, must not be negative", a.MaxQuoteAge.Duration)
+	}
+	a.quotes = make(map[string]map[string]quote)
+	return nil
+}
+
+func (a *Arbitrage) Apply(in ...telegraf.Metric) []telegraf.Metric {
+	out := make([]telegraf.Metric, 0, len(in))
+	for _, m := range in {
+		out = append(out, m)
+		if spread := a.update(m); spread != nil {
+			out = append(out, spread)
+		}
+	}
+	return out
+}
+
+// update records the quote of m, returning the spread of its pair when the
+// pair is quoted by at least two exchanges
+func (a *Arbitrage) update(m telegraf.Metric) telegraf.Metric {
+	if !a.isQuote(m.Name()) {
+		return nil
+	}
+	exchange, ok := m.GetTag(a.ExchangeTag)
+	if !ok || exchange == "" {
+		return nil
+	}
+	pairTags := make(map[string]string, len(a.PairTags))
+	values := make([]string, 0, len(a.PairTags))
+	for _, key := range a.PairTags {
+		value, ok := m.GetTag(key)
+		if !ok {
+			return nil
+		}
+		pairTags[key] = value
+		values = append(values, value)
+	}
+	bid, ok := floatField(m, a.BidField)
+	if !ok {
+		return nil
+	}
+	ask, ok := floatField(m, a.AskField)
+	if !ok {
+		return nil
+	}
+
+	pair := strings.Join(values, "\x00")
+	quotes, ok := a.quotes[pair]
+	if !ok {
+		quotes = make(map[string]quote)
+		a.quotes[pair] = quotes
+	}
+	quotes[exchange] = quote{bid: bid, ask: ask, time: m.Time()}
+
+	return a.spread(pairTags, quotes, m.Time())
+}
+
+// spread returns the best spread of selling at the bid of one exchange and
+// buying at the ask of another, among the quotes not older than
+// max_quote_age at now
+func (a *Arbitrage) spread(pairTags map[string]string, quotes map[string]quote, now time.Time) telegraf.Metric {
+	// the exchanges are sorted for ties to be broken the same way every time
+	exchanges := make([]string, 0, len(quotes))
+	for exchange, q := range quotes {
+		if a.MaxQuoteAge.Duration <= 0 || now.Sub(q.time) <= a.MaxQuoteAge.Duration {
+			exchanges = append(exchanges, exchange)
+		}
+	}
+	sort.Strings(exchanges)
+
+	var buyExchange, sellExchange string
+	var buy, sell quote
+	for _, buyer := range exchanges {
+		for _, seller := range exchanges {
+			if seller == buyer {
+				continue
+			}
+			bq, sq := quotes[buyer], quotes[seller]
+			if buyExchange == "" || sq.bid-bq.ask > sell.bid-buy.ask {
+				buyExchange, sellExchange = buyer, seller
+				buy, sell = bq, sq
+			}
+		}
+	}
+	if buyExchange == "" {
+		return nil
+	}
+
+	spread := sell.bid - buy.ask
+	var spreadBps float64
+	if buy.ask != 0 {
+		spreadBps = spread / buy.ask * 10000
+	}
+	opportunity := spreadBps > a.ThresholdBps
+	if a.OpportunitiesOnly && !opportunity {
+		return nil
+	}
+
+	tags := make(map[string]string, len(pairTags)+2)
+	for key, value := range pairTags {
+		tags[key] = value
+	}
+	tags["buy_exchange"] = buyExchange
+	tags["sell_exchange"] = sellExchange
+	m, err := metric.New(a.Measurement, tags, map[string]interface{}{
+		"buy_price":   buy.ask,
+		"sell_price":  sell.bid,
+		"spread":      spread,
+		"spread_bps":  spreadBps,
+		"opportunity": opportunity,
+	}, now)
+	if err != nil {
+		return nil
+	}
+	return m
+}
+
+func (a *Arbitrage) isQuote(measurement string) bool {
+	for _, name := range a.Measurements {
+		if name == measurement {
+			return true
+		}
+	}
+	return false
+}
+
+// floatField returns a numeric field of m as a float
+func floatField(m telegraf.Metric, key string) (float64, bool) {
+	value, ok := m.GetField(key)
+	if !ok {
+		return 0, false
+	}
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+func newArbitrage() *Arbitrage {
+	return &Arbitrage{
+		Measurements: []string{"market_ticker", "market_book"},
+		BidField:     "bid",
+		AskField:     "ask",
+		ExchangeTag:  "exchange",
+		PairTags:     []string{"base", "quote"},
+		MaxQuoteAge:  internal.Duration{Duration: 10 * time.Second},
+		ThresholdBps: 10,
+		Measurement:  "arbitrage_spread",
+	}
+}
+
+func init() {
+	processors.Add("arbitrage", func() telegraf.Processor {
+		return newArbitrage()
+	})
+}
@@ -0,0 +1,107 @@
+package arbitrage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newQuote(exchange string, bid, ask float64, t time.Time) telegraf.Metric {
+	return testutil.MustMetric("market_ticker",
+		map[string]string{"exchange": exchange, "base": "BTC", "quote": "USD"},
+		map[string]interface{}{"price": bid, "bid": bid, "ask": ask},
+		t,
+	)
+}
+
+func newTestArbitrage(t *testing.T) *Arbitrage {
+	a := newArbitrage()
+	require.NoError(t, a.Init())
+	return a
+}
+
+func TestSpread(t *testing.T) {
+	a := newTestArbitrage(t)
+
+	// a single exchange has no spread to another
+	kraken := newQuote("kraken", 50000, 50001, now)
+	require.Equal(t, []telegraf.Metric{kraken}, a.Apply(kraken))
+
+	spread, ask := 99.0, 50001.0
+	coinbase := newQuote("coinbase", 50100, 50101, now.Add(time.Second))
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		coinbase,
+		testutil.MustMetric("arbitrage_spread",
+			map[string]string{"base": "BTC", "quote": "USD", "buy_exchange": "kraken", "sell_exchange": "coinbase"},
+			map[string]interface{}{
+				"buy_price":   50001.0,
+				"sell_price":  50100.0,
+				"spread":      99.0,
+				"spread_bps":  spread / ask * 10000,
+				"opportunity": true,
+			},
+			now.Add(time.Second),
+		),
+	}, a.Apply(coinbase))
+}
+
+func TestSpreadBelowThreshold(t *testing.T) {
+	a := newTestArbitrage(t)
+
+	out := a.Apply(
+		newQuote("kraken", 50000, 50001, now),
+		newQuote("coinbase", 49999, 50002, now),
+	)
+	require.Len(t, out, 3)
+	spread := out[2]
+	require.Equal(t, "arbitrage_spread", spread.Name())
+	require.Equal(t, map[string]string{"base": "BTC", "quote": "USD", "buy_exchange": "coinbase", "sell_exchange": "kraken"}, spread.Tags())
+	require.Equal(t, -2.0, spread.Fields()["spread"])
+	require.Equal(t, false, spread.Fields()["opportunity"])
+
+	a.OpportunitiesOnly = true
+	require.Len(t, a.Apply(newQuote("kraken", 50000, 50001, now)), 1)
+}
+
+func TestStaleQuotesAreNotJoined(t *testing.T) {
+	a := newArbitrage()
+	a.MaxQuoteAge = internal.Duration{Duration: 5 * time.Second}
+	require.NoError(t, a.Init())
+
+	a.Apply(newQuote("kraken", 50000, 50001, now))
+	require.Len(t, a.Apply(newQuote("coinbase", 50100, 50101, now.Add(6*time.Second))), 1)
+	require.Len(t, a.Apply(newQuote("kraken", 50000, 50001, now.Add(7*time.Second))), 2)
+}
+
+func TestOtherMetricsPassThrough(t *testing.T) {
+	a := newTestArbitrage(t)
+
+	trade := testutil.MustMetric("market_trade",
+		map[string]string{"exchange": "kraken", "base": "BTC", "quote": "USD", "side": "buy"},
+		map[string]interface{}{"price": 50000.0, "size": 1.0},
+		now,
+	)
+	other := testutil.MustMetric("market_ticker",
+		map[string]string{"exchange": "coinbase", "base": "ETH", "quote": "USD"},
+		map[string]interface{}{"bid": 1500.0, "ask": 1501.0},
+		now,
+	)
+	require.Equal(t, []telegraf.Metric{trade, other}, a.Apply(trade, other))
+	require.Len(t, a.Apply(newQuote("coinbase", 50100, 50101, now)), 1)
+}
+
+func TestInit(t *testing.T) {
+	a := newArbitrage()
+	a.PairTags = nil
+	require.Error(t, a.Init())
+
+	a = newArbitrage()
+	a.MaxQuoteAge = internal.Duration{Duration: -time.Second}
+	require.Error(t, a.Init())
+}
@@ -0,0 +1,176 @@
+package bollinger
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/processors"
+)
+
+const sampleConfig = `
+  ## The fields of the bands, e.g. the price of the tickers
+  # fields = ["price"]
+
+  ## The tags of the series banded apart, all the tags when empty
+  # tags = []
+
+  ## The number of values of the rolling mean and standard deviation
+  # window = 20
+
+  ## The number of standard deviations between the mean and the bands
+  # k = 2.0
+`
+
+type Bollinger struct {
+	Fields []string `toml:"fields"`
+	Tags   []string `toml:"tags"`
+	Window int      `toml:"window"`
+	K      float64  `toml:"k"`
+
+	// the windows of every field by series
+	series map[string]map[string]*window
+}
+
+// window is the last values of a field of a series, values[next] being the
+// oldest once full
+type window struct {
+	values []float64
+	next   int
+}
+
+func (p *Bollinger) SampleConfig() string {
+	return sampleConfig
+}
+
+func (p *Bollinger) Description() string {
+	return "Add the Bollinger bands of fields, and whether the value breaches them, to every metric"
+}
+
+func (p *Bollinger) Init() error {
+	if len(p.Fields) == 0 {
+		return fmt.Errorf("fields must be set")
+	}
+	if p.Window < 2 {
+		return fmt.Errorf("invalid window %d, must be at least 2", p.Window)
+	}
+	if p.K <= 0 {
+		return fmt.Errorf("invalid k %v, must be positive", p.K)
+	}
+	p.series = make(map[string]map[string]*window)
+	return nil
+}
+
+func (p *Bollinger) Apply(in ...telegraf.Metric) []telegraf.Metric {
+	for _, m := range in {
+		var windows map[string]*window
+		for _, field := range p.Fields {
+			value, ok := convert(m.Fields()[field])
+			if !ok {
+				continue
+			}
+			if windows == nil {
+				windows = p.windows(m)
+			}
+			w, ok := windows[field]
+			if !ok {
+				w = &window{values: make([]float64, 0, p.Window)}
+				windows[field] = w
+			}
+			w.add(value, p.Window)
+			if len(w.values) < p.Window {
+				continue
+			}
+
+			mean, stddev := w.stats()
+			upper, lower := mean+p.K*stddev, mean-p.K*stddev
+			m.AddField(field+"_bb_middle", mean)
+			m.AddField(field+"_bb_upper", upper)
+			m.AddField(field+"_bb_lower", lower)
+			m.AddField(field+"_bb_breach", value > upper || value < lower)
+		}
+	}
+	return in
+}
+
+// windows returns the windows of the series of m
+func (p *Bollinger) windows(m telegraf.Metric) map[string]*window {
+	key := p.seriesKey(m)
+	windows, ok := p.series[key]
+	if !ok {
+		windows = make(map[string]*window)
+		p.series[key] = windows
+	}
+	return windows
+}
+
+func (p *Bollinger) seriesKey(m telegraf.Metric) string {
+	var parts []string
+	if len(p.Tags) == 0 {
+		for _, tag := range m.TagList() {
+			parts = append(parts, tag.Key+"="+tag.Value)
+		}
+	} else {
+		for _, key := range p.Tags {
+			if value, ok := m.GetTag(key); ok {
+				parts = append(parts, key+"="+value)
+			}
+		}
+		sort.Strings(parts)
+	}
+	return m.Name() + "," + strings.Join(parts, ",")
+}
+
+func (w *window) add(value float64, size int) {
+	if len(w.values) < size {
+		w.values = append(w.values, value)
+		return
+	}
+	w.values[w.next] = value
+	w.next = (w.next + 1) % size
+}
+
+// stats returns the mean and the population standard deviation of the
+// window
+func (w *window) stats() (float64, float64) {
+	var sum float64
+	for _, value := range w.values {
+		sum += value
+	}
+	mean := sum / float64(len(w.values))
+
+	var squares float64
+	for _, value := range w.values {
+		squares += (value - mean) * (value - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(w.values)))
+}
+
+func convert(in interface{}) (float64, bool) {
+	switch v := in.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+func newBollinger() *Bollinger {
+	return &Bollinger{
+		Fields: []string{"price"},
+		Window: 20,
+		K:      2,
+	}
+}
+
+func init() {
+	processors.Add("bollinger", func() telegraf.Processor {
+		return newBollinger()
+	})
+}
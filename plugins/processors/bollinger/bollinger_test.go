@@ -0,0 +1,71 @@
+package bollinger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func ticker(productID string, price float64) telegraf.Metric {
+	return testutil.MustMetric("market_ticker",
+		map[string]string{"exchange": "kraken", "base": productID, "quote": "USD"},
+		map[string]interface{}{"price": price},
+		time.Unix(0, 0),
+	)
+}
+
+func TestBands(t *testing.T) {
+	p := newBollinger()
+	p.Window = 4
+	p.K = 1
+	require.NoError(t, p.Init())
+
+	// the window is not full yet
+	for _, price := range []float64{2, 4, 4} {
+		m := p.Apply(ticker("BTC", price))[0]
+		require.False(t, m.HasField("price_bb_middle"))
+	}
+
+	// a mean of 3 and a standard deviation of 1
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("market_ticker",
+			map[string]string{"exchange": "kraken", "base": "BTC", "quote": "USD"},
+			map[string]interface{}{
+				"price":           2.0,
+				"price_bb_middle": 3.0,
+				"price_bb_upper":  4.0,
+				"price_bb_lower":  2.0,
+				"price_bb_breach": false,
+			},
+			time.Unix(0, 0),
+		),
+	}, p.Apply(ticker("BTC", 2)))
+
+	// the window rolls over to 4, 4, 2, 10: a mean of 5 and a standard
+	// deviation of 3
+	m := p.Apply(ticker("BTC", 10))[0]
+	require.Equal(t, 5.0, m.Fields()["price_bb_middle"])
+	require.Equal(t, 8.0, m.Fields()["price_bb_upper"])
+	require.Equal(t, 2.0, m.Fields()["price_bb_lower"])
+	require.Equal(t, true, m.Fields()["price_bb_breach"])
+
+	// every series has its own window
+	require.False(t, p.Apply(ticker("ETH", 100))[0].HasField("price_bb_middle"))
+}
+
+func TestInit(t *testing.T) {
+	p := newBollinger()
+	p.Fields = nil
+	require.Error(t, p.Init())
+
+	p = newBollinger()
+	p.Window = 1
+	require.Error(t, p.Init())
+
+	p = newBollinger()
+	p.K = 0
+	require.Error(t, p.Init())
+}
@@ -0,0 +1,249 @@
+package crossrate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/metric"
+	"github.com/influxdata/telegraf/plugins/processors"
+)
+
+const sampleConfig = `
+  ## The measurements of the quotes, and the tag of their pair
+  # measurements = ["ticker"]
+  # pair_tag = "product_id"
+
+  ## The fields of the last, bid and ask prices of the quotes, the synthetic
+  ## pairs being emitted with the same fields
+  # price_field = "price"
+  # bid_field = "best_bid"
+  # ask_field = "best_ask"
+
+  ## Quotes older than max_quote_age, relative to the quote received, are not
+  ## crossed anymore
+  # max_quote_age = "10s"
+
+  ## The measurement of the synthetic pairs
+  # measurement = "cross_rate"
+
+  ## A synthetic pair, quoted from the pairs it is crossed from: the division
+  ## of ETH-USD by BTC-USD quotes ETH-BTC, the multiplication of ETH-USD by
+  ## USD-EUR quotes ETH-EUR
+  # [[processors.cross_rate.pair]]
+  #   name = "ETH-BTC"
+  #   operation = "divide"
+  #   left = "ETH-USD"
+  #   right = "BTC-USD"
+`
+
+const (
+	operationDivide   = "divide"
+	operationMultiply = "multiply"
+)
+
+// Pair is a synthetic pair crossed from two quoted pairs
+type Pair struct {
+	Name      string `toml:"name"`
+	Operation string `toml:"operation"`
+	Left      string `toml:"left"`
+	Right     string `toml:"right"`
+}
+
+// quote is the last prices of a quoted pair
+type quote struct {
+	fields map[string]float64
+	time   time.Time
+}
+
+type CrossRate struct {
+	Measurements []string          `toml:"measurements"`
+	PairTag      string            `toml:"pair_tag"`
+	PriceField   string            `toml:"price_field"`
+	BidField     string            `toml:"bid_field"`
+	AskField     string            `toml:"ask_field"`
+	MaxQuoteAge  internal.Duration `toml:"max_quote_age"`
+	Measurement  string            `toml:"measurement"`
+	Pairs        []Pair            `toml:"pair"`
+
+	// the synthetic pairs crossed from every quoted pair
+	crossed map[string][]Pair
+	quotes  map[string]quote
+}
+
+func (c *CrossRate) SampleConfig() string {
+	return sampleConfig
+}
+
+func (c *CrossRate) Description() string {
+	return "Quote synthetic pairs crossed from the quotes of two pairs"
+}
+
+func (c *CrossRate) Init() error {
+	if c.PairTag == "" {
+		return fmt.Errorf("pair_tag must be set")
+	}
+	if c.MaxQuoteAge.Duration < 0 {
+		return fmt.Errorf("invalid max_quote_age %s, must not be negative", c.MaxQuoteAge.Duration)
+	}
+
+	c.crossed = make(map[string][]Pair)
+	for i, pair := range c.Pairs {
+		if pair.Name == "" || pair.Left == "" || pair.Right == "" {
+			return fmt.Errorf("name, left and right must be set for every pair")
+		}
+		switch pair.Operation {
+		case "":
+			c.Pairs[i].Operation = operationDivide
+		case operationDivide, operationMultiply:
+		default:
+			return fmt.Errorf("invalid operation %q of pair %s, must be %q or %q",
+				pair.Operation, pair.Name, operationDivide, operationMultiply)
+		}
+		c.crossed[pair.Left] = append(c.crossed[pair.Left], c.Pairs[i])
+		if pair.Right != pair.Left {
+			c.crossed[pair.Right] = append(c.crossed[pair.Right], c.Pairs[i])
+		}
+	}
+	c.quotes = make(map[string]quote)
+	return nil
+}
+
+func (c *CrossRate) Apply(in ...telegraf.Metric) []telegraf.Metric {
+	out := make([]telegraf.Metric, 0, len(in))
+	for _, m := range in {
+		out = append(out, m)
+		out = append(out, c.update(m)...)
+	}
+	return out
+}
+
+// update records the quote of m, returning the synthetic pairs crossed from
+// its pair
+func (c *CrossRate) update(m telegraf.Metric) []telegraf.Metric {
+	if !c.isQuote(m.Name()) {
+		return nil
+	}
+	name, ok := m.GetTag(c.PairTag)
+	if !ok {
+		return nil
+	}
+	pairs, ok := c.crossed[name]
+	if !ok {
+		return nil
+	}
+
+	fields := make(map[string]float64, 3)
+	for _, key := range []string{c.PriceField, c.BidField, c.AskField} {
+		if value, ok := convert(m.Fields()[key]); ok && key != "" && value > 0 {
+			fields[key] = value
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	c.quotes[name] = quote{fields: fields, time: m.Time()}
+
+	var crossed []telegraf.Metric
+	for _, pair := range pairs {
+		if cm := c.cross(pair, m.Time()); cm != nil {
+			crossed = append(crossed, cm)
+		}
+	}
+	return crossed
+}
+
+// cross returns the quote of a synthetic pair, when both of its pairs are
+// quoted within max_quote_age of now
+func (c *CrossRate) cross(pair Pair, now time.Time) telegraf.Metric {
+	left, ok := c.quotes[pair.Left]
+	if !ok || c.stale(left, now) {
+		return nil
+	}
+	right, ok := c.quotes[pair.Right]
+	if !ok || c.stale(right, now) {
+		return nil
+	}
+
+	fields := make(map[string]interface{}, 3)
+	price := func(key string, leftKey string, rightKey string) {
+		l, ok := left.fields[leftKey]
+		if !ok {
+			return
+		}
+		r, ok := right.fields[rightKey]
+		if !ok {
+			return
+		}
+		if pair.Operation == operationMultiply {
+			fields[key] = l * r
+		} else {
+			fields[key] = l / r
+		}
+	}
+	price(c.PriceField, c.PriceField, c.PriceField)
+	if pair.Operation == operationMultiply {
+		price(c.BidField, c.BidField, c.BidField)
+		price(c.AskField, c.AskField, c.AskField)
+	} else {
+		// selling the left pair at its bid buys the right pair at its ask,
+		// and the other way round
+		price(c.BidField, c.BidField, c.AskField)
+		price(c.AskField, c.AskField, c.BidField)
+	}
+	delete(fields, "")
+	if len(fields) == 0 {
+		return nil
+	}
+
+	m, err := metric.New(c.Measurement, map[string]string{c.PairTag: pair.Name}, fields, now)
+	if err != nil {
+		return nil
+	}
+	return m
+}
+
+func (c *CrossRate) stale(q quote, now time.Time) bool {
+	return c.MaxQuoteAge.Duration > 0 && now.Sub(q.time) > c.MaxQuoteAge.Duration
+}
+
+func (c *CrossRate) isQuote(measurement string) bool {
+	for _, name := range c.Measurements {
+		if name == measurement {
+			return true
+		}
+	}
+	return false
+}
+
+func convert(in interface{}) (float64, bool) {
+	switch v := in.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+func newCrossRate() *CrossRate {
+	return &CrossRate{
+		Measurements: []string{"ticker"},
+		PairTag:      "product_id",
+		PriceField:   "price",
+		BidField:     "best_bid",
+		AskField:     "best_ask",
+		MaxQuoteAge:  internal.Duration{Duration: 10 * time.Second},
+		Measurement:  "cross_rate",
+	}
+}
+
+func init() {
+	processors.Add("cross_rate", func() telegraf.Processor {
+		return newCrossRate()
+	})
+}
@@ -0,0 +1,98 @@
+package crossrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func ticker(productID string, price, bid, ask float64, t time.Time) telegraf.Metric {
+	return testutil.MustMetric("ticker",
+		map[string]string{"type": "ticker", "product_id": productID, "side": "buy"},
+		map[string]interface{}{"price": price, "best_bid": bid, "best_ask": ask, "volume_24h": 1000.0},
+		t,
+	)
+}
+
+func newTestCrossRate(t *testing.T, pairs ...Pair) *CrossRate {
+	c := newCrossRate()
+	c.Pairs = pairs
+	require.NoError(t, c.Init())
+	return c
+}
+
+func TestDivide(t *testing.T) {
+	c := newTestCrossRate(t, Pair{Name: "ETH-BTC", Left: "ETH-USD", Right: "BTC-USD"})
+
+	eth := ticker("ETH-USD", 2000, 1999, 2001, now)
+	require.Equal(t, []telegraf.Metric{eth}, c.Apply(eth))
+
+	btc := ticker("BTC-USD", 50000, 49990, 50010, now.Add(time.Second))
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		btc,
+		testutil.MustMetric("cross_rate",
+			map[string]string{"product_id": "ETH-BTC"},
+			map[string]interface{}{
+				"price":    2000.0 / 50000,
+				"best_bid": 1999.0 / 50010,
+				"best_ask": 2001.0 / 49990,
+			},
+			now.Add(time.Second),
+		),
+	}, c.Apply(btc))
+}
+
+func TestMultiply(t *testing.T) {
+	c := newTestCrossRate(t, Pair{Name: "ETH-EUR", Operation: "multiply", Left: "ETH-USD", Right: "USD-EUR"})
+
+	out := c.Apply(
+		ticker("ETH-USD", 2000, 1999, 2001, now),
+		ticker("USD-EUR", 0.5, 0.25, 0.75, now),
+	)
+	require.Len(t, out, 3)
+	require.Equal(t, map[string]string{"product_id": "ETH-EUR"}, out[2].Tags())
+	require.Equal(t, map[string]interface{}{
+		"price":    1000.0,
+		"best_bid": 1999.0 * 0.25,
+		"best_ask": 2001.0 * 0.75,
+	}, out[2].Fields())
+}
+
+func TestStaleQuotesAreNotCrossed(t *testing.T) {
+	c := newCrossRate()
+	c.MaxQuoteAge = internal.Duration{Duration: 5 * time.Second}
+	c.Pairs = []Pair{{Name: "ETH-BTC", Left: "ETH-USD", Right: "BTC-USD"}}
+	require.NoError(t, c.Init())
+
+	c.Apply(ticker("ETH-USD", 2000, 1999, 2001, now))
+	require.Len(t, c.Apply(ticker("BTC-USD", 50000, 49990, 50010, now.Add(6*time.Second))), 1)
+	require.Len(t, c.Apply(ticker("ETH-USD", 2000, 1999, 2001, now.Add(7*time.Second))), 2)
+}
+
+func TestOtherPairsPassThrough(t *testing.T) {
+	c := newTestCrossRate(t, Pair{Name: "ETH-BTC", Left: "ETH-USD", Right: "BTC-USD"})
+
+	ltc := ticker("LTC-USD", 100, 99, 101, now)
+	match := testutil.MustMetric("match",
+		map[string]string{"type": "match", "product_id": "BTC-USD", "side": "sell"},
+		map[string]interface{}{"price": 50000.0, "size": 1.0},
+		now,
+	)
+	require.Equal(t, []telegraf.Metric{ltc, match}, c.Apply(ltc, match))
+	require.Len(t, c.Apply(ticker("ETH-USD", 2000, 1999, 2001, now)), 1)
+}
+
+func TestInit(t *testing.T) {
+	c := newCrossRate()
+	c.Pairs = []Pair{{Name: "ETH-BTC", Left: "ETH-USD"}}
+	require.Error(t, c.Init())
+
+	c.Pairs = []Pair{{Name: "ETH-BTC", Operation: "add", Left: "ETH-USD", Right: "BTC-USD"}}
+	require.Error(t, c.Init())
+}
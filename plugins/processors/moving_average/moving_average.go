@@ -0,0 +1,183 @@
+package movingaverage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/processors"
+)
+
+const sampleConfig = `
+  ## The fields averaged, e.g. the price of the tickers
+  # fields = ["price"]
+
+  ## The tags of the series averaged apart, all the tags when empty
+  # tags = []
+
+  ## The number of values of the simple moving average, added as the
+  ## <field>_sma field once the window is full, 0 disabling it
+  # sma_window = 20
+
+  ## The period of the exponential moving average, whose smoothing factor is
+  ## 2 / (period + 1), added as the <field>_ema field, 0 disabling it
+  # ema_period = 20
+`
+
+type MovingAverage struct {
+	Fields    []string `toml:"fields"`
+	Tags      []string `toml:"tags"`
+	SMAWindow int      `toml:"sma_window"`
+	EMAPeriod int      `toml:"ema_period"`
+
+	// the averages of every field by series
+	series map[string]map[string]*average
+}
+
+// average is the state of the moving averages of a field of a series
+type average struct {
+	// the last sma_window values, values[next] being the oldest once full
+	values []float64
+	next   int
+	ema    float64
+	count  int
+}
+
+func (p *MovingAverage) SampleConfig() string {
+	return sampleConfig
+}
+
+func (p *MovingAverage) Description() string {
+	return "Add the simple and exponential moving averages of fields to every metric"
+}
+
+func (p *MovingAverage) Init() error {
+	if len(p.Fields) == 0 {
+		return fmt.Errorf("fields must be set")
+	}
+	if p.SMAWindow < 0 {
+		return fmt.Errorf("invalid sma_window %d, must not be negative", p.SMAWindow)
+	}
+	if p.EMAPeriod < 0 {
+		return fmt.Errorf("invalid ema_period %d, must not be negative", p.EMAPeriod)
+	}
+	if p.SMAWindow == 0 && p.EMAPeriod == 0 {
+		return fmt.Errorf("sma_window or ema_period must be set")
+	}
+	p.series = make(map[string]map[string]*average)
+	return nil
+}
+
+func (p *MovingAverage) Apply(in ...telegraf.Metric) []telegraf.Metric {
+	for _, m := range in {
+		var averages map[string]*average
+		for _, field := range p.Fields {
+			value, ok := convert(m.Fields()[field])
+			if !ok {
+				continue
+			}
+			if averages == nil {
+				averages = p.averages(m)
+			}
+			a, ok := averages[field]
+			if !ok {
+				a = &average{values: make([]float64, 0, p.SMAWindow)}
+				averages[field] = a
+			}
+			a.add(value, p.SMAWindow, p.EMAPeriod)
+
+			if p.SMAWindow > 0 && len(a.values) == p.SMAWindow {
+				m.AddField(field+"_sma", a.sma())
+			}
+			if p.EMAPeriod > 0 {
+				m.AddField(field+"_ema", a.ema)
+			}
+		}
+	}
+	return in
+}
+
+// averages returns the averages of the series of m
+func (p *MovingAverage) averages(m telegraf.Metric) map[string]*average {
+	key := p.seriesKey(m)
+	averages, ok := p.series[key]
+	if !ok {
+		averages = make(map[string]*average)
+		p.series[key] = averages
+	}
+	return averages
+}
+
+func (p *MovingAverage) seriesKey(m telegraf.Metric) string {
+	var parts []string
+	if len(p.Tags) == 0 {
+		for _, tag := range m.TagList() {
+			parts = append(parts, tag.Key+"="+tag.Value)
+		}
+	} else {
+		for _, key := range p.Tags {
+			if value, ok := m.GetTag(key); ok {
+				parts = append(parts, key+"="+value)
+			}
+		}
+		sort.Strings(parts)
+	}
+	return m.Name() + "," + strings.Join(parts, ",")
+}
+
+// add adds a value to the window of the simple moving average, and to the
+// exponential moving average, which starts at the first value
+func (a *average) add(value float64, window int, period int) {
+	if window > 0 {
+		if len(a.values) < window {
+			a.values = append(a.values, value)
+		} else {
+			a.values[a.next] = value
+			a.next = (a.next + 1) % window
+		}
+	}
+
+	if a.count == 0 {
+		a.ema = value
+	} else if period > 0 {
+		alpha := 2 / float64(period+1)
+		a.ema = alpha*value + (1-alpha)*a.ema
+	}
+	a.count++
+}
+
+func (a *average) sma() float64 {
+	var sum float64
+	for _, value := range a.values {
+		sum += value
+	}
+	return sum / float64(len(a.values))
+}
+
+func convert(in interface{}) (float64, bool) {
+	switch v := in.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+func newMovingAverage() *MovingAverage {
+	return &MovingAverage{
+		Fields:    []string{"price"},
+		SMAWindow: 20,
+		EMAPeriod: 20,
+	}
+}
+
+func init() {
+	processors.Add("moving_average", func() telegraf.Processor {
+		return newMovingAverage()
+	})
+}
@@ -0,0 +1,104 @@
+package movingaverage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func ticker(productID string, side string, price float64) telegraf.Metric {
+	return testutil.MustMetric("ticker",
+		map[string]string{"product_id": productID, "side": side},
+		map[string]interface{}{"price": price},
+		time.Unix(0, 0),
+	)
+}
+
+func TestMovingAverages(t *testing.T) {
+	p := newMovingAverage()
+	p.Tags = []string{"product_id"}
+	p.SMAWindow = 3
+	p.EMAPeriod = 3
+	require.NoError(t, p.Init())
+
+	var out []telegraf.Metric
+	for i, price := range []float64{10, 12, 14, 8} {
+		side := "buy"
+		if i%2 == 1 {
+			side = "sell"
+		}
+		out = append(out, p.Apply(ticker("BTC-USD", side, price))...)
+	}
+	// another series
+	out = append(out, p.Apply(ticker("ETH-USD", "buy", 100))...)
+
+	// the smoothing factor of a period of 3 is 0.5
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("ticker",
+			map[string]string{"product_id": "BTC-USD", "side": "buy"},
+			map[string]interface{}{"price": 10.0, "price_ema": 10.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric("ticker",
+			map[string]string{"product_id": "BTC-USD", "side": "sell"},
+			map[string]interface{}{"price": 12.0, "price_ema": 11.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric("ticker",
+			map[string]string{"product_id": "BTC-USD", "side": "buy"},
+			map[string]interface{}{"price": 14.0, "price_sma": 12.0, "price_ema": 12.5},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric("ticker",
+			map[string]string{"product_id": "BTC-USD", "side": "sell"},
+			map[string]interface{}{"price": 8.0, "price_sma": 34.0 / 3, "price_ema": 10.25},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric("ticker",
+			map[string]string{"product_id": "ETH-USD", "side": "buy"},
+			map[string]interface{}{"price": 100.0, "price_ema": 100.0},
+			time.Unix(0, 0),
+		),
+	}, out)
+}
+
+func TestSeriesOfAllTags(t *testing.T) {
+	p := newMovingAverage()
+	p.SMAWindow = 2
+	p.EMAPeriod = 0
+	require.NoError(t, p.Init())
+
+	p.Apply(ticker("BTC-USD", "buy", 10))
+	m := p.Apply(ticker("BTC-USD", "sell", 20))[0]
+	require.False(t, m.HasField("price_sma"))
+	require.False(t, m.HasField("price_ema"))
+
+	m = p.Apply(ticker("BTC-USD", "buy", 30))[0]
+	require.Equal(t, 20.0, m.Fields()["price_sma"])
+
+	// metrics without the fields are left alone
+	other := testutil.MustMetric("ticker",
+		map[string]string{"product_id": "BTC-USD", "side": "buy"},
+		map[string]interface{}{"volume_24h": 1.0},
+		time.Unix(0, 0),
+	)
+	require.Equal(t, map[string]interface{}{"volume_24h": 1.0}, p.Apply(other)[0].Fields())
+}
+
+func TestInit(t *testing.T) {
+	p := newMovingAverage()
+	p.Fields = nil
+	require.Error(t, p.Init())
+
+	p = newMovingAverage()
+	p.SMAWindow = -1
+	require.Error(t, p.Init())
+
+	p = newMovingAverage()
+	p.SMAWindow = 0
+	p.EMAPeriod = 0
+	require.Error(t, p.Init())
+}
@@ -0,0 +1,206 @@
+package pricealert
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/metric"
+	"github.com/influxdata/telegraf/plugins/processors"
+)
+
+const sampleConfig = `
+  ## The field of the prices watched, and the tag of their symbol; use
+  ## namepass to watch the prices of some measurements only
+  # price_field = "price"
+  # symbol_tag = "product_id"
+
+  ## Emit an event when the price moves by more than move_percent within
+  ## move_window, 0 disabling the movement events
+  # move_percent = 0.0
+  # move_window = "5m"
+
+  ## The measurement of the events
+  # measurement = "price_alert"
+
+  ## The levels whose crossing emits an event, by symbol
+  # [processors.price_alert.levels]
+  #   "BTC-USD" = [50000.0, 60000.0]
+`
+
+const (
+	eventLevelCross = "level_cross"
+	eventMove       = "move"
+)
+
+type PriceAlert struct {
+	PriceField  string               `toml:"price_field"`
+	SymbolTag   string               `toml:"symbol_tag"`
+	MovePercent float64              `toml:"move_percent"`
+	MoveWindow  internal.Duration    `toml:"move_window"`
+	Measurement string               `toml:"measurement"`
+	Levels      map[string][]float64 `toml:"levels"`
+
+	symbols map[string]*history
+}
+
+// history is the prices of a symbol within move_window, oldest first
+type history struct {
+	prices []pricePoint
+}
+
+type pricePoint struct {
+	price float64
+	time  time.Time
+}
+
+func (p *PriceAlert) SampleConfig() string {
+	return sampleConfig
+}
+
+func (p *PriceAlert) Description() string {
+	return "Emit events when prices cross levels or move by more than a percentage"
+}
+
+func (p *PriceAlert) Init() error {
+	if p.PriceField == "" || p.SymbolTag == "" {
+		return fmt.Errorf("price_field and symbol_tag must be set")
+	}
+	if p.MovePercent < 0 {
+		return fmt.Errorf("invalid move_percent %v, must not be negative", p.MovePercent)
+	}
+	if p.MovePercent > 0 && p.MoveWindow.Duration <= 0 {
+		return fmt.Errorf("invalid move_window %s, must be positive", p.MoveWindow.Duration)
+	}
+	p.symbols = make(map[string]*history)
+	return nil
+}
+
+func (p *PriceAlert) Apply(in ...telegraf.Metric) []telegraf.Metric {
+	out := make([]telegraf.Metric, 0, len(in))
+	for _, m := range in {
+		out = append(out, m)
+		out = append(out, p.watch(m)...)
+	}
+	return out
+}
+
+// watch records the price of m, returning the events it triggers
+func (p *PriceAlert) watch(m telegraf.Metric) []telegraf.Metric {
+	price, ok := convert(m.Fields()[p.PriceField])
+	if !ok || price <= 0 {
+		return nil
+	}
+	symbol, ok := m.GetTag(p.SymbolTag)
+	if !ok {
+		return nil
+	}
+
+	h, ok := p.symbols[symbol]
+	if !ok {
+		h = &history{}
+		p.symbols[symbol] = h
+	}
+
+	var events []telegraf.Metric
+	if len(h.prices) > 0 {
+		last := h.prices[len(h.prices)-1].price
+		for _, level := range p.Levels[symbol] {
+			var direction string
+			switch {
+			case last < level && price >= level:
+				direction = "up"
+			case last > level && price <= level:
+				direction = "down"
+			default:
+				continue
+			}
+			events = append(events, p.event(symbol, eventLevelCross, direction, map[string]interface{}{
+				"price":          price,
+				"previous_price": last,
+				"level":          level,
+			}, m.Time()))
+		}
+	}
+
+	// only the last price is needed without movement events, to tell the
+	// levels crossed
+	if p.MovePercent <= 0 {
+		h.prices = append(h.prices[:0], pricePoint{price: price, time: m.Time()})
+		return events
+	}
+
+	start := 0
+	for start < len(h.prices) && m.Time().Sub(h.prices[start].time) > p.MoveWindow.Duration {
+		start++
+	}
+	h.prices = append(h.prices[start:], pricePoint{price: price, time: m.Time()})
+
+	low, high := h.prices[0].price, h.prices[0].price
+	for _, point := range h.prices {
+		if point.price < low {
+			low = point.price
+		}
+		if point.price > high {
+			high = point.price
+		}
+	}
+	var direction string
+	var reference float64
+	switch {
+	case (price-low)/low*100 > p.MovePercent:
+		direction, reference = "up", low
+	case (high-price)/high*100 > p.MovePercent:
+		direction, reference = "down", high
+	default:
+		return events
+	}
+	events = append(events, p.event(symbol, eventMove, direction, map[string]interface{}{
+		"price":           price,
+		"reference_price": reference,
+		"change_percent":  (price - reference) / reference * 100,
+	}, m.Time()))
+
+	// the next movement is measured from this price, rather than emitting
+	// an event for every price until the move leaves the window
+	h.prices = append(h.prices[:0], pricePoint{price: price, time: m.Time()})
+	return events
+}
+
+func (p *PriceAlert) event(symbol string, event string, direction string, fields map[string]interface{}, t time.Time) telegraf.Metric {
+	m, _ := metric.New(p.Measurement, map[string]string{
+		p.SymbolTag: symbol,
+		"event":     event,
+		"direction": direction,
+	}, fields, t)
+	return m
+}
+
+func convert(in interface{}) (float64, bool) {
+	switch v := in.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+func newPriceAlert() *PriceAlert {
+	return &PriceAlert{
+		PriceField:  "price",
+		SymbolTag:   "product_id",
+		MoveWindow:  internal.Duration{Duration: 5 * time.Minute},
+		Measurement: "price_alert",
+	}
+}
+
+func init() {
+	processors.Add("price_alert", func() telegraf.Processor {
+		return newPriceAlert()
+	})
+}
@@ -0,0 +1,97 @@
+package pricealert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func ticker(productID string, price float64, t time.Time) telegraf.Metric {
+	return testutil.MustMetric("ticker",
+		map[string]string{"type": "ticker", "product_id": productID, "side": "buy"},
+		map[string]interface{}{"price": price},
+		t,
+	)
+}
+
+func TestLevelCross(t *testing.T) {
+	p := newPriceAlert()
+	p.Levels = map[string][]float64{"BTC-USD": {50000, 60000}}
+	require.NoError(t, p.Init())
+
+	require.Len(t, p.Apply(ticker("BTC-USD", 49000, now)), 1)
+	require.Len(t, p.Apply(ticker("BTC-USD", 49999, now)), 1)
+
+	up := ticker("BTC-USD", 50000, now.Add(time.Second))
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		up,
+		testutil.MustMetric("price_alert",
+			map[string]string{"product_id": "BTC-USD", "event": "level_cross", "direction": "up"},
+			map[string]interface{}{"price": 50000.0, "previous_price": 49999.0, "level": 50000.0},
+			now.Add(time.Second),
+		),
+	}, p.Apply(up))
+
+	// staying on the level is not crossing it again
+	require.Len(t, p.Apply(ticker("BTC-USD", 50000, now)), 1)
+
+	out := p.Apply(ticker("BTC-USD", 61000, now))
+	require.Len(t, out, 2)
+	require.Equal(t, 60000.0, out[1].Fields()["level"])
+
+	// both levels crossed at once
+	out = p.Apply(ticker("BTC-USD", 45000, now))
+	require.Len(t, out, 3)
+	require.Equal(t, "down", out[1].Tags()["direction"])
+	require.Equal(t, "down", out[2].Tags()["direction"])
+
+	// other symbols have no levels
+	require.Len(t, p.Apply(ticker("ETH-USD", 50000, now), ticker("ETH-USD", 70000, now)), 2)
+}
+
+func TestMove(t *testing.T) {
+	p := newPriceAlert()
+	p.MovePercent = 5
+	p.MoveWindow = internal.Duration{Duration: time.Minute}
+	require.NoError(t, p.Init())
+
+	require.Len(t, p.Apply(ticker("BTC-USD", 100, now)), 1)
+	require.Len(t, p.Apply(ticker("BTC-USD", 104, now.Add(10*time.Second))), 1)
+
+	up := ticker("BTC-USD", 106, now.Add(20*time.Second))
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		up,
+		testutil.MustMetric("price_alert",
+			map[string]string{"product_id": "BTC-USD", "event": "move", "direction": "up"},
+			map[string]interface{}{"price": 106.0, "reference_price": 100.0, "change_percent": 6.0},
+			now.Add(20*time.Second),
+		),
+	}, p.Apply(up))
+
+	// the next move is measured from the price of the event
+	require.Len(t, p.Apply(ticker("BTC-USD", 107, now.Add(30*time.Second))), 1)
+
+	// prices older than the window are forgotten
+	require.Len(t, p.Apply(ticker("BTC-USD", 102, now.Add(2*time.Minute))), 1)
+	out := p.Apply(ticker("BTC-USD", 96, now.Add(2*time.Minute+time.Second)))
+	require.Len(t, out, 2)
+	require.Equal(t, "down", out[1].Tags()["direction"])
+	require.Equal(t, 102.0, out[1].Fields()["reference_price"])
+}
+
+func TestInit(t *testing.T) {
+	p := newPriceAlert()
+	p.MovePercent = -1
+	require.Error(t, p.Init())
+
+	p = newPriceAlert()
+	p.MovePercent = 1
+	p.MoveWindow = internal.Duration{}
+	require.Error(t, p.Init())
+}